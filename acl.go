@@ -0,0 +1,327 @@
+package gofakes3
+
+import (
+	"net/http"
+	"strings"
+)
+
+// CannedACL is one of the predefined grants that can be set with the x-amz-acl
+// header.
+//
+// https://docs.aws.amazon.com/AmazonS3/latest/userguide/acl-overview.html#canned-acl
+type CannedACL string
+
+const (
+	ACLPrivate                CannedACL = "private"
+	ACLPublicRead             CannedACL = "public-read"
+	ACLPublicReadWrite        CannedACL = "public-read-write"
+	ACLAuthenticatedRead      CannedACL = "authenticated-read"
+	ACLBucketOwnerRead        CannedACL = "bucket-owner-read"
+	ACLBucketOwnerFullControl CannedACL = "bucket-owner-full-control"
+	ACLLogDeliveryWrite       CannedACL = "log-delivery-write"
+)
+
+type Permission string
+
+const (
+	PermissionFullControl Permission = "FULL_CONTROL"
+	PermissionRead        Permission = "READ"
+	PermissionWrite       Permission = "WRITE"
+	PermissionReadACP     Permission = "READ_ACP"
+	PermissionWriteACP    Permission = "WRITE_ACP"
+)
+
+// The values of Grantee.Type:
+const (
+	GranteeCanonicalUser         = "CanonicalUser"
+	GranteeAmazonCustomerByEmail = "AmazonCustomerByEmail"
+	GranteeGroup                 = "Group"
+)
+
+// The predefined groups that may be used as the URI of a Grantee:
+const (
+	GroupAllUsers           = "http://acs.amazonaws.com/groups/global/AllUsers"
+	GroupAuthenticatedUsers = "http://acs.amazonaws.com/groups/global/AuthenticatedUsers"
+	GroupLogDelivery        = "http://acs.amazonaws.com/groups/s3/LogDelivery"
+)
+
+// From https://docs.aws.amazon.com/AmazonS3/latest/userguide/acl-overview.html:
+//
+//	"You can grant permissions to up to 100 grantees in an ACL."
+const MaxACLGrants = 100
+
+// grantHeaders maps the x-amz-grant-* headers to the permission they grant.
+var grantHeaders = []struct {
+	header     string
+	permission Permission
+}{
+	{"X-Amz-Grant-Full-Control", PermissionFullControl},
+	{"X-Amz-Grant-Read", PermissionRead},
+	{"X-Amz-Grant-Read-Acp", PermissionReadACP},
+	{"X-Amz-Grant-Write", PermissionWrite},
+	{"X-Amz-Grant-Write-Acp", PermissionWriteACP},
+}
+
+// cannedACL returns the grants of a canned ACL for a resource belonging to
+// owner. As every bucket and object has the same owner, the bucket-owner-*
+// ACLs are the same as private.
+func cannedACL(canned CannedACL, owner *UserInfo) (*AccessControlPolicy, error) {
+	acl := &AccessControlPolicy{
+		Owner:  owner,
+		Grants: []Grant{{Grantee: Grantee{Type: GranteeCanonicalUser, ID: owner.ID, DisplayName: owner.DisplayName}, Permission: PermissionFullControl}},
+	}
+	group := func(uri string, permissions ...Permission) {
+		for _, permission := range permissions {
+			acl.Grants = append(acl.Grants, Grant{Grantee: Grantee{Type: GranteeGroup, URI: uri}, Permission: permission})
+		}
+	}
+
+	switch canned {
+	case ACLPrivate, ACLBucketOwnerRead, ACLBucketOwnerFullControl:
+	case ACLPublicRead:
+		group(GroupAllUsers, PermissionRead)
+	case ACLPublicReadWrite:
+		group(GroupAllUsers, PermissionRead, PermissionWrite)
+	case ACLAuthenticatedRead:
+		group(GroupAuthenticatedUsers, PermissionRead)
+	case ACLLogDeliveryWrite:
+		group(GroupLogDelivery, PermissionWrite, PermissionReadACP)
+	default:
+		return nil, ErrorInvalidArgument("x-amz-acl", string(canned), "")
+	}
+	return acl, nil
+}
+
+// aclFromHeaders returns the ACL requested with the x-amz-acl header or the
+// x-amz-grant-* headers, or nil if there are none. The headers are ignored if
+// the Backend does not implement ACLBackend.
+func (g *GoFakeS3) aclFromHeaders(h http.Header) (*AccessControlPolicy, error) {
+	if g.acl == nil {
+		return nil, nil
+	}
+	canned := h.Get("X-Amz-Acl")
+
+	var grants []Grant
+	for _, gh := range grantHeaders {
+		value := h.Get(gh.header)
+		if value == "" {
+			continue
+		}
+		grantees, err := parseGrantHeader(gh.header, value)
+		if err != nil {
+			return nil, err
+		}
+		for _, grantee := range grantees {
+			grants = append(grants, Grant{Grantee: grantee, Permission: gh.permission})
+		}
+	}
+
+	if canned != "" && grants != nil {
+		return nil, ErrorMessage(ErrInvalidRequest, "Specifying both Canned ACLs and Header Grants is not allowed")
+	} else if canned != "" {
+		return cannedACL(CannedACL(canned), g.owner)
+	} else if grants != nil {
+		acl := &AccessControlPolicy{Owner: g.owner, Grants: grants}
+		return acl, g.validateACL(acl)
+	}
+	return nil, nil
+}
+
+// aclFromMetadata returns the ACL requested with the headers stored in the
+// metadata of a multipart upload when it was initiated.
+func (g *GoFakeS3) aclFromMetadata(meta map[string]string) (*AccessControlPolicy, error) {
+	h := make(http.Header, len(meta))
+	for k, v := range meta {
+		h.Set(k, v)
+	}
+	return g.aclFromHeaders(h)
+}
+
+// parseGrantHeader parses the value of an x-amz-grant-* header, which is a
+// comma separated list of 'id="..."', 'uri="..."' or 'emailAddress="..."'.
+func parseGrantHeader(header, value string) ([]Grantee, error) {
+	var grantees []Grantee
+	for _, item := range strings.Split(value, ",") {
+		parts := strings.SplitN(strings.TrimSpace(item), "=", 2)
+		if len(parts) != 2 {
+			return nil, ErrorInvalidArgument(header, value, "")
+		}
+		v := strings.Trim(strings.TrimSpace(parts[1]), `"`)
+
+		switch strings.ToLower(strings.TrimSpace(parts[0])) {
+		case "id":
+			grantees = append(grantees, Grantee{Type: GranteeCanonicalUser, ID: v})
+		case "uri":
+			grantees = append(grantees, Grantee{Type: GranteeGroup, URI: v})
+		case "emailaddress":
+			grantees = append(grantees, Grantee{Type: GranteeAmazonCustomerByEmail, EmailAddress: v})
+		default:
+			return nil, ErrorInvalidArgument(header, value, "")
+		}
+	}
+	return grantees, nil
+}
+
+// validateACL checks the grants of an ACL supplied by the client, and fills
+// in the display name of grants to the owner.
+func (g *GoFakeS3) validateACL(acl *AccessControlPolicy) error {
+	if len(acl.Grants) > MaxACLGrants {
+		return ErrorMessagef(ErrMalformedACLError, "An ACL can have at most %d grants", MaxACLGrants)
+	}
+
+	for i := range acl.Grants {
+		grant := &acl.Grants[i]
+		switch grant.Permission {
+		case PermissionFullControl, PermissionRead, PermissionWrite, PermissionReadACP, PermissionWriteACP:
+		default:
+			return ErrMalformedACLError
+		}
+
+		grantee := &grant.Grantee
+		switch grantee.Type {
+		case GranteeCanonicalUser:
+			if grantee.ID == "" {
+				return ErrMalformedACLError
+			}
+			if grantee.ID == g.owner.ID {
+				grantee.DisplayName = g.owner.DisplayName
+			}
+		case GranteeAmazonCustomerByEmail:
+			if grantee.EmailAddress == "" {
+				return ErrMalformedACLError
+			}
+		case GranteeGroup:
+			switch grantee.URI {
+			case GroupAllUsers, GroupAuthenticatedUsers, GroupLogDelivery:
+			default:
+				return ErrorInvalidArgument("uri", grantee.URI, "Invalid group uri")
+			}
+		default:
+			return ErrMalformedACLError
+		}
+	}
+	return nil
+}
+
+// allows reports whether the ACL grants permission, or FULL_CONTROL, to the
+// group with the given URI.
+func (acl *AccessControlPolicy) allows(group string, permission Permission) bool {
+	for _, grant := range acl.Grants {
+		if grant.Grantee.Type == GranteeGroup && grant.Grantee.URI == group &&
+			(grant.Permission == permission || grant.Permission == PermissionFullControl) {
+			return true
+		}
+	}
+	return false
+}
+
+// isPublic reports whether the ACL grants anything to AllUsers or
+// AuthenticatedUsers, which a public access block with BlockPublicACLs
+// refuses.
+func (acl *AccessControlPolicy) isPublic() bool {
+	for _, grant := range acl.Grants {
+		if grant.Grantee.Type == GranteeGroup && (grant.Grantee.URI == GroupAllUsers || grant.Grantee.URI == GroupAuthenticatedUsers) {
+			return true
+		}
+	}
+	return false
+}
+
+// isOwnerOnly reports whether the ACL grants nothing but FULL_CONTROL to
+// owner, as the private and bucket-owner-* canned ACLs do. It is the only ACL
+// accepted once ACLs are disabled with ObjectOwnershipBucketOwnerEnforced.
+func (acl *AccessControlPolicy) isOwnerOnly(owner *UserInfo) bool {
+	for _, grant := range acl.Grants {
+		if grant.Grantee.Type != GranteeCanonicalUser || grant.Grantee.ID != owner.ID || grant.Permission != PermissionFullControl {
+			return false
+		}
+	}
+	return true
+}
+
+// checkRequestedACL refuses an ACL requested for the bucket or one of its
+// objects if the bucket has ACLs disabled, or if it is public and the
+// bucket's public access block refuses it. A nil ACL, for which nothing is
+// stored, is always allowed.
+func (g *GoFakeS3) checkRequestedACL(bucket string, acl *AccessControlPolicy) error {
+	if acl == nil {
+		return nil
+	}
+	if !acl.isOwnerOnly(g.owner) {
+		ownership, err := g.objectOwnership(bucket)
+		if err != nil {
+			return err
+		}
+		if ownership == ObjectOwnershipBucketOwnerEnforced {
+			return ErrAccessControlListNotSupported
+		}
+	}
+	return g.checkPublicACL(bucket, acl)
+}
+
+// aclAllowsAnonymous reports whether the object or bucket ACL grants the
+// policy action returned by anonymousAction to everyone. Missing buckets and
+// objects are not an error; anonymous requests for them are denied.
+func (g *GoFakeS3) aclAllowsAnonymous(r *http.Request, bucket, object, action string) (bool, error) {
+	var acl *AccessControlPolicy
+	var err error
+	var permission Permission
+
+	switch action {
+	case "s3:GetObject":
+		versionID := g.queryVersionID(r.URL.Query()["versionId"])
+		acl, err = g.acl.ObjectACL(bucket, object, versionID)
+		permission = PermissionRead
+	case "s3:ListBucket":
+		acl, err = g.acl.BucketACL(bucket)
+		permission = PermissionRead
+	case "s3:PutObject", "s3:DeleteObject":
+		acl, err = g.acl.BucketACL(bucket)
+		permission = PermissionWrite
+	default:
+		return false, nil
+	}
+
+	if HasErrorCode(err, ErrNoSuchBucket) || HasErrorCode(err, ErrNoSuchKey) || HasErrorCode(err, ErrNoSuchVersion) {
+		return false, nil
+	} else if err != nil {
+		return false, err
+	}
+	return acl != nil && acl.allows(GroupAllUsers, permission), nil
+}
+
+// storeObjectACL passes the ACL requested when an object was created to the
+// ACLBackend. Nothing is stored if no ACL was requested, as new objects are
+// private.
+func (g *GoFakeS3) storeObjectACL(bucket, object string, versionID VersionID, acl *AccessControlPolicy) error {
+	if g.acl == nil || acl == nil {
+		return nil
+	}
+	return g.acl.SetObjectACL(bucket, object, versionID, *acl)
+}
+
+// aclFromRequest reads the ACL of a PutBucketAcl or PutObjectAcl request from
+// the headers, or from the body if there are none.
+func (g *GoFakeS3) aclFromRequest(r *http.Request) (*AccessControlPolicy, error) {
+	acl, err := g.aclFromHeaders(r.Header)
+	if err != nil || acl != nil {
+		return acl, err
+	}
+
+	var in AccessControlPolicy
+	if err := g.xmlDecodeBody(r.Body, &in); err != nil {
+		return nil, err
+	}
+	in.Owner = g.owner
+	return &in, g.validateACL(&in)
+}
+
+// writeACL encodes the ACL returned by the ACLBackend, which is nil for
+// private buckets and objects.
+func (g *GoFakeS3) writeACL(w http.ResponseWriter, acl *AccessControlPolicy) error {
+	if acl == nil {
+		acl, _ = cannedACL(ACLPrivate, g.owner)
+	}
+	acl.Xmlns = "http://s3.amazonaws.com/doc/2006-03-01/"
+	return g.xmlEncoder(w).Encode(acl)
+}
@@ -0,0 +1,186 @@
+package gofakes3
+
+import (
+	"encoding/json"
+	"math"
+	"net/http"
+	"strings"
+)
+
+// objectPartsMetaKey is the Metadata key under which CompleteMultipartUpload
+// stores the parts of the object, for GetObjectAttributes and the partNumber
+// of GET and HEAD requests. It is not returned in the headers of GET and HEAD
+// responses.
+const objectPartsMetaKey = "X-Gofakes3-Object-Parts"
+
+// objectPart is an entry in the JSON list stored under objectPartsMetaKey.
+// The names are kept short as the list counts towards the size of the
+// metadata stored by the backend.
+type objectPart struct {
+	PartNumber int    `json:"n"`
+	Size       int64  `json:"s"`
+	Checksum   string `json:"c,omitempty"` // Base64 encoded
+}
+
+func encodeObjectParts(parts []objectPart) (string, error) {
+	bts, err := json.Marshal(parts)
+	return string(bts), err
+}
+
+// decodeObjectParts returns the parts stored in the metadata of an object,
+// which is nil if the object was not uploaded in parts.
+func decodeObjectParts(meta map[string]string) (parts []objectPart, err error) {
+	if encoded := meta[objectPartsMetaKey]; encoded != "" {
+		err = json.Unmarshal([]byte(encoded), &parts)
+	}
+	return parts, err
+}
+
+// The attributes that may be requested in the x-amz-object-attributes header:
+const (
+	objectAttributeETag         = "ETag"
+	objectAttributeChecksum     = "Checksum"
+	objectAttributeObjectParts  = "ObjectParts"
+	objectAttributeStorageClass = "StorageClass"
+	objectAttributeObjectSize   = "ObjectSize"
+)
+
+func parseObjectAttributes(header string) (map[string]bool, error) {
+	attrs := map[string]bool{}
+	for _, attr := range strings.Split(header, ",") {
+		attr = strings.TrimSpace(attr)
+		switch attr {
+		case "":
+			continue
+		case objectAttributeETag, objectAttributeChecksum, objectAttributeObjectParts,
+			objectAttributeStorageClass, objectAttributeObjectSize:
+			attrs[attr] = true
+		default:
+			return nil, ErrorInvalidArgument("x-amz-object-attributes", attr, "Invalid attribute name specified.")
+		}
+	}
+	if len(attrs) == 0 {
+		return nil, ErrorMessage(ErrInvalidRequest, "The x-amz-object-attributes header specifying the attributes to be retrieved is either missing or empty")
+	}
+	return attrs, nil
+}
+
+// getObjectAttributes returns the attributes of an object listed in the
+// x-amz-object-attributes header, without its contents.
+// https://docs.aws.amazon.com/AmazonS3/latest/API/API_GetObjectAttributes.html
+func (g *GoFakeS3) getObjectAttributes(bucket, object string, versionID VersionID, w http.ResponseWriter, r *http.Request) error {
+	g.log.Print(LogInfo, "GET OBJECT ATTRIBUTES:", bucket, object, versionID)
+
+	attrs, err := parseObjectAttributes(r.Header.Get("x-amz-object-attributes"))
+	if err != nil {
+		return err
+	}
+	maxParts, err := parseClampedInt(r.Header.Get("x-amz-max-parts"), DefaultMaxUploadParts, 0, MaxUploadPartsLimit)
+	if err != nil {
+		return ErrorInvalidArgument("x-amz-max-parts", r.Header.Get("x-amz-max-parts"), "Argument max-parts must be an integer.")
+	}
+	marker, err := parseClampedInt(r.Header.Get("x-amz-part-number-marker"), 0, 0, math.MaxInt32)
+	if err != nil {
+		return ErrorInvalidArgument("x-amz-part-number-marker", r.Header.Get("x-amz-part-number-marker"), "Argument part-number-marker must be an integer.")
+	}
+
+	var obj *Object
+	if versionID == "" {
+		obj, err = g.storage.HeadObject(bucket, object)
+	} else {
+		if g.versioned == nil {
+			return ErrNotImplemented
+		}
+		obj, err = g.versioned.HeadObjectVersion(bucket, object, versionID)
+	}
+	if err != nil {
+		return err
+	}
+	defer obj.Contents.Close()
+
+	if obj.IsDeleteMarker {
+		w.Header().Set("x-amz-version-id", string(obj.VersionID))
+		w.Header().Set("x-amz-delete-marker", "true")
+		return KeyNotFound(obj.Name)
+	}
+	if err := checkSSECustomerKey(obj.Metadata, r.Header, ""); err != nil {
+		return err
+	}
+
+	if obj.VersionID != "" {
+		w.Header().Set("x-amz-version-id", string(obj.VersionID))
+	}
+	if lastModified := objectLastModified(obj); lastModified != "" {
+		w.Header().Set("Last-Modified", lastModified)
+	}
+
+	var result GetObjectAttributesResult
+
+	if attrs[objectAttributeETag] {
+		result.ETag = strings.Trim(ObjectETag(obj.Hash, obj.Metadata), `"`)
+	}
+
+	if attrs[objectAttributeChecksum] {
+		for _, alg := range checksumAlgorithms {
+			if v := obj.Metadata[alg.header()]; v != "" {
+				result.Checksum = &Checksums{}
+				result.Checksum.set(alg, v)
+				break
+			}
+		}
+	}
+
+	if attrs[objectAttributeObjectParts] {
+		parts, err := decodeObjectParts(obj.Metadata)
+		if err != nil {
+			return err
+		}
+		if parts != nil {
+			result.ObjectParts = objectAttributesParts(parts, obj.Metadata, int(marker), maxParts)
+		}
+	}
+
+	if attrs[objectAttributeStorageClass] {
+		result.StorageClass = ObjectStorageClass(obj.Metadata)
+	}
+
+	if attrs[objectAttributeObjectSize] {
+		size := obj.Size
+		result.ObjectSize = &size
+	}
+
+	return g.xmlEncoder(w).Encode(result)
+}
+
+// objectAttributesParts returns the page of parts that follows marker.
+func objectAttributesParts(parts []objectPart, meta map[string]string, marker int, maxParts int64) *ObjectAttributesParts {
+	var alg checksumAlgorithm
+	for _, known := range checksumAlgorithms {
+		if meta[known.header()] != "" {
+			alg = known
+		}
+	}
+
+	out := &ObjectAttributesParts{
+		PartsCount:       len(parts),
+		PartNumberMarker: marker,
+		MaxParts:         maxParts,
+	}
+	for _, part := range parts {
+		if part.PartNumber <= marker {
+			continue
+		}
+		if int64(len(out.Parts)) >= maxParts {
+			out.IsTruncated = true
+			break
+		}
+
+		item := ObjectAttributesPart{PartNumber: part.PartNumber, Size: part.Size}
+		if part.Checksum != "" {
+			item.set(alg, part.Checksum)
+		}
+		out.Parts = append(out.Parts, item)
+		out.NextPartNumberMarker = part.PartNumber
+	}
+	return out
+}
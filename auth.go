@@ -0,0 +1,416 @@
+package gofakes3
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Values used by AWS Signature Version 4:
+//
+// https://docs.aws.amazon.com/AmazonS3/latest/API/sig-v4-authenticating-requests.html
+const (
+	sigV4Algorithm   = "AWS4-HMAC-SHA256"
+	sigV4TimeFormat  = "20060102T150405Z"
+	sigV4Terminator  = "aws4_request"
+	unsignedPayload  = "UNSIGNED-PAYLOAD"
+	maxPresignExpiry = 7 * 24 * time.Hour
+)
+
+// sigV4 holds the components of a request signature, taken either from the
+// Authorization header or from the query string of a presigned URL.
+type sigV4 struct {
+	accessKey     string
+	scope         string // '<date>/<region>/<service>/aws4_request'
+	signedHeaders []string
+	signature     string
+	amzDate       string
+	payloadHash   string
+
+	// Only set for presigned URLs:
+	presigned bool
+	expires   time.Duration
+}
+
+// authMiddleware verifies the signature of every request when authentication
+// is enabled with WithAuthentication. It must wrap the hostBucketMiddleware,
+// as the signature covers the path the client sent.
+func (g *GoFakeS3) authMiddleware(handler http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, rq *http.Request) {
+		if err := g.authenticate(rq); err != nil {
+			g.httpError(w, rq, err)
+			return
+		}
+		handler.ServeHTTP(w, rq)
+	})
+}
+
+func (g *GoFakeS3) authenticate(r *http.Request) error {
+	sig, err := parseSigV4(r)
+	if err != nil {
+		return err
+	}
+
+	if sig == nil {
+		// CORS preflight requests are never signed:
+		if r.Method == "OPTIONS" {
+			return nil
+		}
+		return g.authorizeAnonymous(r)
+	}
+
+	secret, ok := g.authKeys[sig.accessKey]
+	if !ok {
+		return ErrInvalidAccessKeyID
+	}
+
+	expected := sig.calculate(r, secret)
+	if !hmac.Equal([]byte(expected), []byte(sig.signature)) {
+		return ErrSignatureDoesNotMatch
+	}
+
+	if sig.presigned {
+		if err := g.checkPresignedExpiry(sig); err != nil {
+			return err
+		}
+	}
+
+	// The signature only covers the declared hash of the payload, so the
+	// payload itself must be checked against it as it is read:
+	if len(sig.payloadHash) == sha256.Size*2 && r.Body != nil {
+		expectedHash, err := hex.DecodeString(sig.payloadHash)
+		if err != nil {
+			return ErrorMessage(ErrInvalidArgument, "x-amz-content-sha256 must be UNSIGNED-PAYLOAD, STREAMING-AWS4-HMAC-SHA256-PAYLOAD, or a valid sha256 value.")
+		}
+		r.Body = struct {
+			io.Reader
+			io.Closer
+		}{
+			Reader: &hashingReader{
+				inner:    r.Body,
+				expected: expectedHash,
+				hash:     sha256.New(),
+				mismatch: ErrXAmzContentSHA256Mismatch,
+			},
+			Closer: r.Body,
+		}
+	}
+
+	return nil
+}
+
+// checkPresignedExpiry ensures a presigned URL is used between the time it was
+// signed and the time X-Amz-Expires seconds later, according to the server's
+// TimeSource. Both ends of that window are widened by the limit set with
+// WithTimeSkewLimit, to tolerate clients whose clocks differ from the server's.
+//
+// This is checked after the signature, so a URL can't be extended by editing
+// its X-Amz-Date or X-Amz-Expires parameters.
+func (g *GoFakeS3) checkPresignedExpiry(sig *sigV4) error {
+	signedAt, err := time.Parse(sigV4TimeFormat, sig.amzDate)
+	if err != nil {
+		return ErrorMessage(ErrAuthorizationQueryParametersError,
+			"X-Amz-Date must be in the ISO8601 Long Format \"yyyyMMdd'T'HHmmss'Z'\"")
+	}
+
+	now := g.timeSource.Now()
+	if g.timeSkew != 0 && signedAt.After(now.Add(g.timeSkew)) {
+		return ErrorMessage(ErrAccessDenied, "Request is not valid yet")
+	}
+	if now.After(signedAt.Add(sig.expires + g.timeSkew)) {
+		return ErrorMessage(ErrAccessDenied, "Request has expired")
+	}
+	return nil
+}
+
+// authorizeAnonymous allows unsigned requests only if the bucket policy, or
+// the ACL of the object or bucket, grants the request's action to everyone.
+// A policy that denies the action to everyone overrides the ACLs.
+func (g *GoFakeS3) authorizeAnonymous(r *http.Request) error {
+	errDenied := ErrorMessage(ErrAccessDenied, "Access Denied")
+
+	bucket, object := g.requestBucketObject(r)
+	if bucket == "" {
+		return errDenied
+	}
+	action := anonymousAction(r, object)
+
+	block, err := g.publicAccessBlock(bucket)
+	if err != nil {
+		return err
+	}
+
+	if g.policy != nil {
+		policy, err := g.policy.BucketPolicy(bucket)
+		if HasErrorCode(err, ErrNoSuchBucket) {
+			return errDenied
+		} else if err != nil {
+			return err
+		}
+
+		resource := "arn:aws:s3:::" + bucket
+		if object != "" {
+			resource += "/" + object
+		}
+		if policy != nil {
+			if allowed, denied := policyAnonymousAccess(policy, action, resource); denied {
+				return errDenied
+			} else if allowed && !block.RestrictPublicBuckets {
+				return nil
+			}
+		}
+	}
+
+	ownership, err := g.objectOwnership(bucket)
+	if err != nil {
+		return err
+	}
+	if g.acl != nil && !block.IgnorePublicACLs && ownership != ObjectOwnershipBucketOwnerEnforced {
+		if allowed, err := g.aclAllowsAnonymous(r, bucket, object, action); err != nil {
+			return err
+		} else if allowed {
+			return nil
+		}
+	}
+
+	return errDenied
+}
+
+// requestBucketObject extracts the bucket and object from a request that has
+// not been through the hostBucketMiddleware yet.
+func (g *GoFakeS3) requestBucketObject(r *http.Request) (bucket, object string) {
+	path := strings.Trim(r.URL.Path, "/")
+	if g.hostBucket {
+		if bucket, _, ok := g.hostBucketName(r.Host); ok {
+			return bucket, path
+		}
+	}
+
+	parts := strings.SplitN(path, "/", 2)
+	if len(parts) == 2 {
+		return parts[0], parts[1]
+	}
+	return parts[0], ""
+}
+
+// anonymousSubresources lists the query parameters that select an operation
+// other than the basic object and bucket operations. Anonymous access to
+// these is only granted by a policy that allows all actions.
+var anonymousSubresources = []string{
+	"acl", "cors", "delete", "legal-hold", "lifecycle", "location", "policy",
+	"retention", "tagging", "uploadId", "uploads", "versioning", "versions", "website",
+}
+
+// anonymousAction returns the policy action a request needs to be allowed, or
+// an empty string if only a policy that allows all actions will do.
+func anonymousAction(r *http.Request, object string) string {
+	query := r.URL.Query()
+	for _, sub := range anonymousSubresources {
+		if _, ok := query[sub]; ok {
+			return ""
+		}
+	}
+
+	switch {
+	case object != "" && (r.Method == "GET" || r.Method == "HEAD"):
+		return "s3:GetObject"
+	case object != "" && r.Method == "PUT":
+		return "s3:PutObject"
+	case object != "" && r.Method == "DELETE":
+		return "s3:DeleteObject"
+	case object == "" && (r.Method == "GET" || r.Method == "HEAD"):
+		return "s3:ListBucket"
+	case object == "" && r.Method == "POST":
+		return "s3:PutObject" // Browser-based uploads
+	default:
+		return ""
+	}
+}
+
+// parseSigV4 extracts the signature from the Authorization header, or from the
+// query string of a presigned URL. If the request is not signed, nil is
+// returned.
+func parseSigV4(r *http.Request) (*sigV4, error) {
+	if auth := r.Header.Get("Authorization"); auth != "" {
+		return parseSigV4Header(r, auth)
+	}
+	if r.URL.Query().Get("X-Amz-Algorithm") != "" {
+		return parseSigV4Query(r)
+	}
+	return nil, nil
+}
+
+func parseSigV4Header(r *http.Request, auth string) (*sigV4, error) {
+	if !strings.HasPrefix(auth, sigV4Algorithm+" ") {
+		return nil, ErrorMessage(ErrInvalidRequest, "The authorization mechanism you have provided is not supported. Please use AWS4-HMAC-SHA256.")
+	}
+
+	fields := map[string]string{}
+	for _, field := range strings.Split(strings.TrimPrefix(auth, sigV4Algorithm+" "), ",") {
+		kv := strings.SplitN(strings.TrimSpace(field), "=", 2)
+		if len(kv) != 2 {
+			return nil, ErrorMessagef(ErrAuthorizationHeaderMalformed, "The authorization header is malformed; the authorization component %q is malformed.", field)
+		}
+		fields[kv[0]] = kv[1]
+	}
+
+	sig := &sigV4{
+		signature:   fields["Signature"],
+		amzDate:     r.Header.Get("X-Amz-Date"),
+		payloadHash: r.Header.Get("X-Amz-Content-Sha256"),
+	}
+	if fields["SignedHeaders"] != "" {
+		sig.signedHeaders = strings.Split(fields["SignedHeaders"], ";")
+	}
+	if err := sig.parseCredential(fields["Credential"], ErrAuthorizationHeaderMalformed); err != nil {
+		return nil, err
+	}
+	if sig.signature == "" || len(sig.signedHeaders) == 0 {
+		return nil, ErrorMessage(ErrAuthorizationHeaderMalformed, "The authorization header is malformed; it must contain Credential, SignedHeaders and Signature.")
+	}
+	if sig.amzDate == "" {
+		return nil, ErrorMessage(ErrAccessDenied, "AWS authentication requires a valid Date or x-amz-date header")
+	}
+	if sig.payloadHash == "" {
+		return nil, ErrorMessage(ErrInvalidRequest, "Missing required header for this request: x-amz-content-sha256")
+	}
+
+	return sig, nil
+}
+
+func parseSigV4Query(r *http.Request) (*sigV4, error) {
+	query := r.URL.Query()
+	if query.Get("X-Amz-Algorithm") != sigV4Algorithm {
+		return nil, ErrorMessage(ErrAuthorizationQueryParametersError, "X-Amz-Algorithm only supports \"AWS4-HMAC-SHA256\"")
+	}
+
+	sig := &sigV4{
+		signature:   query.Get("X-Amz-Signature"),
+		amzDate:     query.Get("X-Amz-Date"),
+		payloadHash: unsignedPayload,
+		presigned:   true,
+	}
+	if v := query.Get("X-Amz-SignedHeaders"); v != "" {
+		sig.signedHeaders = strings.Split(v, ";")
+	}
+	if err := sig.parseCredential(query.Get("X-Amz-Credential"), ErrAuthorizationQueryParametersError); err != nil {
+		return nil, err
+	}
+	if sig.signature == "" || sig.amzDate == "" || len(sig.signedHeaders) == 0 {
+		return nil, ErrorMessage(ErrAuthorizationQueryParametersError,
+			"Query-string authentication version 4 requires the X-Amz-Algorithm, X-Amz-Credential, X-Amz-Signature, X-Amz-Date, X-Amz-SignedHeaders, and X-Amz-Expires parameters.")
+	}
+
+	expires, err := strconv.ParseInt(query.Get("X-Amz-Expires"), 10, 64)
+	if err != nil || expires < 0 {
+		return nil, ErrorMessage(ErrAuthorizationQueryParametersError, "X-Amz-Expires should be a number")
+	}
+	sig.expires = time.Duration(expires) * time.Second
+	if sig.expires > maxPresignExpiry {
+		return nil, ErrorMessage(ErrAuthorizationQueryParametersError, "X-Amz-Expires must be less than a week (in seconds) that is 604800")
+	}
+
+	return sig, nil
+}
+
+// parseCredential parses a credential in the form
+// '<access key>/<date>/<region>/<service>/aws4_request'.
+func (sig *sigV4) parseCredential(credential string, code ErrorCode) error {
+	parts := strings.Split(credential, "/")
+	if len(parts) != 5 || parts[0] == "" || parts[4] != sigV4Terminator {
+		return ErrorMessage(code, "Error parsing the X-Amz-Credential parameter; the Credential is mal-formed; expecting \"<YOUR-AKID>/YYYYMMDD/REGION/SERVICE/aws4_request\".")
+	}
+	if parts[3] != "s3" {
+		return ErrorMessagef(code, "Error parsing the X-Amz-Credential parameter; incorrect service %q. This endpoint belongs to \"s3\".", parts[3])
+	}
+
+	sig.accessKey = parts[0]
+	sig.scope = strings.Join(parts[1:], "/")
+	return nil
+}
+
+// calculate returns the signature the request should have if it was signed
+// using secret.
+func (sig *sigV4) calculate(r *http.Request, secret string) string {
+	canonical := strings.Join([]string{
+		r.Method,
+		canonicalURI(r),
+		canonicalQuery(r),
+		canonicalHeaders(r, sig.signedHeaders),
+		strings.Join(sig.signedHeaders, ";"),
+		sig.payloadHash,
+	}, "\n")
+
+	requestHash := sha256.Sum256([]byte(canonical))
+	stringToSign := strings.Join([]string{
+		sigV4Algorithm,
+		sig.amzDate,
+		sig.scope,
+		hex.EncodeToString(requestHash[:]),
+	}, "\n")
+
+	key := []byte("AWS4" + secret)
+	for _, part := range strings.Split(sig.scope, "/") {
+		key = hmacSHA256(key, part)
+	}
+	return hex.EncodeToString(hmacSHA256(key, stringToSign))
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	h := hmac.New(sha256.New, key)
+	h.Write([]byte(data))
+	return h.Sum(nil)
+}
+
+// canonicalURI returns the path exactly as the client sent it; unlike other
+// services, S3 does not normalise or double-encode the path before signing.
+func canonicalURI(r *http.Request) string {
+	if path := r.URL.EscapedPath(); path != "" {
+		return path
+	}
+	return "/"
+}
+
+func canonicalQuery(r *http.Request) string {
+	query := r.URL.Query()
+	query.Del("X-Amz-Signature")
+	for _, values := range query {
+		sort.Strings(values)
+	}
+	return strings.Replace(query.Encode(), "+", "%20", -1)
+}
+
+func canonicalHeaders(r *http.Request, signed []string) string {
+	var b strings.Builder
+	for _, name := range signed {
+		var values []string
+		switch name {
+		case "host":
+			values = []string{r.Host}
+		case "content-length":
+			values = r.Header.Values(name)
+			if len(values) == 0 && r.ContentLength >= 0 {
+				values = []string{strconv.FormatInt(r.ContentLength, 10)}
+			}
+		default:
+			values = r.Header.Values(name)
+		}
+
+		b.WriteString(name)
+		b.WriteByte(':')
+		for i, v := range values {
+			if i > 0 {
+				b.WriteByte(',')
+			}
+			b.WriteString(strings.Join(strings.Fields(v), " "))
+		}
+		b.WriteByte('\n')
+	}
+	return b.String()
+}
@@ -1,7 +1,10 @@
 package gofakes3
 
 import (
+	"context"
+	"encoding/hex"
 	"io"
+	"time"
 
 	"github.com/aws/aws-sdk-go/aws/awserr"
 )
@@ -22,6 +25,11 @@ type Object struct {
 	Hash     []byte
 	Range    *ObjectRange
 
+	// LastModified is the time the object was last written, as recorded by
+	// the Backend. If it is zero, the "Last-Modified" entry stored in the
+	// Metadata by GoFakeS3 is used instead.
+	LastModified time.Time
+
 	// VersionID will be empty if bucket versioning has not been enabled.
 	VersionID VersionID
 
@@ -30,6 +38,34 @@ type Object struct {
 	IsDeleteMarker bool
 }
 
+// ETagMetaKey is the Metadata key under which GoFakeS3 stores the ETag of
+// an object if it is not the MD5 hash of the contents, which is the case for
+// objects created by CompleteMultipartUpload. Backends should use ObjectETag
+// when listing objects so the ETag matches GET and HEAD.
+const ETagMetaKey = "ETag"
+
+// ObjectETag returns the quoted ETag of an object with the given MD5 hash and
+// metadata.
+func ObjectETag(hash []byte, meta map[string]string) string {
+	if etag := meta[ETagMetaKey]; etag != "" {
+		return etag
+	}
+	return `"` + hex.EncodeToString(hash) + `"`
+}
+
+// StorageClassMetaKey is the Metadata key under which GoFakeS3 stores the
+// storage class requested for an object. Objects without one are STANDARD.
+const StorageClassMetaKey = "X-Amz-Storage-Class"
+
+// ObjectStorageClass returns the storage class of an object with the given
+// metadata. Backends should use it when listing objects.
+func ObjectStorageClass(meta map[string]string) StorageClass {
+	if class := meta[StorageClassMetaKey]; class != "" {
+		return StorageClass(class)
+	}
+	return StorageStandard
+}
+
 type ObjectList struct {
 	CommonPrefixes []CommonPrefix
 	Contents       []*Content
@@ -60,6 +96,74 @@ func (b *ObjectList) AddPrefix(prefix string) {
 	b.CommonPrefixes = append(b.CommonPrefixes, CommonPrefix{Prefix: prefix})
 }
 
+// ListBucketKeys builds a page of a bucket listing, for Backends that can list
+// their keys in the order S3 lists them.
+//
+// next returns the keys of the bucket in order, and false once there are none
+// left. It may start at any key at or before the first one after the marker
+// that matches the prefix, and may stop after the last key with the prefix.
+// content returns the Content listed for a key, or nil if the key is no longer
+// an object; it is only called for the keys that appear in the page.
+func ListBucketKeys(prefix *Prefix, page ListBucketPage, next func() (key string, ok bool, err error), content func(key string) (*Content, error)) (*ObjectList, error) {
+	if prefix == nil {
+		prefix = &Prefix{}
+	}
+
+	var response = NewObjectList()
+	var match PrefixMatch
+	var cnt int64
+
+	// If the previous page ended with a common prefix, the marker is that
+	// prefix, and none of the keys it covers should be listed again:
+	var lastMatchedPart string
+	if page.HasMarker {
+		lastMatchedPart = page.Marker
+	}
+
+	for {
+		key, ok, err := next()
+		if err != nil {
+			return nil, err
+		} else if !ok {
+			break
+		}
+
+		if page.HasMarker && key <= page.Marker {
+			continue
+		} else if !prefix.Match(key, &match) {
+			continue
+		} else if match.CommonPrefix && match.MatchedPart <= lastMatchedPart {
+			continue // Should not count towards keys
+		}
+
+		if page.MaxKeys > 0 && cnt >= page.MaxKeys {
+			// There is at least one more item after the page:
+			response.IsTruncated = true
+			return response, nil
+		}
+
+		if match.CommonPrefix {
+			response.AddPrefix(match.MatchedPart)
+			lastMatchedPart = match.MatchedPart
+			response.NextMarker = match.MatchedPart
+
+		} else {
+			item, err := content(key)
+			if err != nil {
+				return nil, err
+			} else if item == nil {
+				continue
+			}
+			response.Add(item)
+			response.NextMarker = key
+		}
+		cnt++
+	}
+
+	response.NextMarker = ""
+	return response, nil
+}
+
 type ObjectDeleteResult struct {
 	// Specifies whether the versioned object that was permanently deleted was
 	// (true) or was not (false) a delete marker. In a simple DELETE, this
@@ -153,8 +257,14 @@ type Backend interface {
 	// not, depending on how it was configured, retry the same request with no page.
 	// We have observed (though not yet confirmed) that simple clients tend to
 	// work fine if you ignore the pagination request, but this may not suit
-	// your application. Not all backends bundled with gofakes3 correctly
-	// support this pagination yet, but that will change.
+	// your application. All the backends bundled with gofakes3 support
+	// pagination.
+	//
+	// A page must hold no more than page.MaxKeys Contents and CommonPrefixes
+	// between them. If there is more to list, IsTruncated must be true and
+	// NextMarker must be the last key or common prefix in the page; the
+	// keys rolled up into a common prefix that is used as the Marker must not
+	// be listed again.
 	ListBucket(name string, prefix *Prefix, page ListBucketPage) (*ObjectList, error)
 
 	// CreateBucket creates the bucket if it does not already exist. The name
@@ -232,12 +342,53 @@ type Backend interface {
 	DeleteMulti(bucketName string, objects ...string) (MultiDeleteResult, error)
 }
 
+// ContextBackend may be optionally implemented by a Backend so that reading
+// and writing the contents of an object stops when the request is cancelled,
+// for example because the client disconnected. If the Backend implements
+// ContextBackend, GoFakeS3 calls these methods instead of GetObject and
+// PutObject, passing the context of the HTTP request.
+//
+// Backends should check ctx.Err() between chunks of a large read or write and
+// return it if it is not nil. The Contents of an Object returned by
+// GetObjectContext may be wrapped with ContextReadCloser() to stop the
+// response body being read once the request is cancelled.
+//
+// Unlike the other optional interfaces, ContextBackend is not looked for
+// behind a BackendMiddleware, as that would bypass the middleware.
+type ContextBackend interface {
+	GetObjectContext(ctx context.Context, bucketName, objectName string, rangeRequest *ObjectRangeRequest) (*Object, error)
+	PutObjectContext(ctx context.Context, bucketName, key string, meta map[string]string, input io.Reader, size int64) (PutObjectResult, error)
+}
+
+// getObjectContext calls GetObjectContext if b implements ContextBackend,
+// otherwise GetObject.
+func getObjectContext(ctx context.Context, b Backend, bucketName, objectName string, rangeRequest *ObjectRangeRequest) (*Object, error) {
+	if cb, ok := b.(ContextBackend); ok {
+		return cb.GetObjectContext(ctx, bucketName, objectName, rangeRequest)
+	}
+	return b.GetObject(bucketName, objectName, rangeRequest)
+}
+
+// putObjectContext calls PutObjectContext if b implements ContextBackend,
+// otherwise PutObject.
+func putObjectContext(ctx context.Context, b Backend, bucketName, key string, meta map[string]string, input io.Reader, size int64) (PutObjectResult, error) {
+	if cb, ok := b.(ContextBackend); ok {
+		return cb.PutObjectContext(ctx, bucketName, key, meta, input, size)
+	}
+	return b.PutObject(bucketName, key, meta, input, size)
+}
+
 // VersionedBackend may be optionally implemented by a Backend in order to support
 // operations on S3 object versions.
 //
 // If you don't implement VersionedBackend, requests to GoFakeS3 that attempt to
 // make use of versions will return ErrNotImplemented if GoFakesS3 is unable to
 // find another way to satisfy the request.
+//
+// The version ID passed to the methods of VersionedBackend may be the string
+// "null", which refers to the version stored while versioning was not enabled
+// on the bucket, if there is one. If versioning is suspended, PutObject and
+// DeleteObject should replace the null version rather than create a new one.
 type VersionedBackend interface {
 	// VersioningConfiguration must return a gofakes3.ErrNoSuchBucket error if the bucket
 	// does not exist. See gofakes3.BucketNotFound() for a convenient way to create one.
@@ -248,6 +399,9 @@ type VersionedBackend interface {
 
 	// SetVersioningConfiguration must return a gofakes3.ErrNoSuchBucket error if the bucket
 	// does not exist. See gofakes3.BucketNotFound() for a convenient way to create one.
+	//
+	// An empty Status or MFADelete leaves that part of the configuration
+	// unchanged.
 	SetVersioningConfiguration(bucket string, v VersioningConfiguration) error
 
 	// GetObject must return a gofakes3.ErrNoSuchKey error if the object does
@@ -309,6 +463,322 @@ type VersionedBackend interface {
 	ListBucketVersions(bucketName string, prefix *Prefix, page *ListBucketVersionsPage) (*ListBucketVersionsResult, error)
 }
 
+// TaggingBackend may be optionally implemented by a Backend in order to support
+// the object tagging subresource ('?tagging').
+//
+// If you don't implement TaggingBackend, requests to GoFakeS3 that attempt to
+// read or modify object tags will return ErrNotImplemented.
+//
+// Tags belong to a single version of an object. An empty versionID refers to
+// the latest version; all methods must return a gofakes3.ErrNoSuchVersion
+// error if a versionID is passed that does not exist, and a
+// gofakes3.ErrMethodNotAllowed error if it refers to a delete marker.
+type TaggingBackend interface {
+	// GetObjectTagging returns the tags currently associated with the object.
+	// If the object has no tags, an empty or nil map should be returned.
+	//
+	// GetObjectTagging must return a gofakes3.ErrNoSuchBucket error if the bucket
+	// does not exist, and a gofakes3.ErrNoSuchKey error if the object does not
+	// exist.
+	GetObjectTagging(bucketName, objectName string, versionID VersionID) (map[string]string, error)
+
+	// PutObjectTagging replaces the full set of tags associated with the
+	// object. The tags will have been validated by GoFakeS3 before they are
+	// passed to the Backend.
+	//
+	// PutObjectTagging must return a gofakes3.ErrNoSuchBucket error if the bucket
+	// does not exist, and a gofakes3.ErrNoSuchKey error if the object does not
+	// exist.
+	PutObjectTagging(bucketName, objectName string, versionID VersionID, tags map[string]string) error
+
+	// DeleteObjectTagging removes all tags from the object.
+	//
+	// DeleteObjectTagging must return a gofakes3.ErrNoSuchBucket error if the
+	// bucket does not exist, and a gofakes3.ErrNoSuchKey error if the object
+	// does not exist.
+	DeleteObjectTagging(bucketName, objectName string, versionID VersionID) error
+}
+
+// ACLBackend may be optionally implemented by a Backend in order to support
+// the bucket and object acl subresources ('?acl'), and the x-amz-acl and
+// x-amz-grant-* headers. If a Backend does not implement it, requests to the
+// subresources fail with ErrNotImplemented and the headers are ignored.
+//
+// Buckets and objects without an ACL are private. The ACL of an object must
+// be discarded when the object is replaced, and that of a bucket when it is
+// deleted.
+//
+// All methods must return a gofakes3.ErrNoSuchBucket error if the bucket does
+// not exist. The object methods must return a gofakes3.ErrNoSuchKey error if
+// the object does not exist, and a gofakes3.ErrNoSuchVersion error if the
+// version does not exist. An empty versionID refers to the latest version.
+type ACLBackend interface {
+	// BucketACL returns nil, and no error, if the bucket has no ACL.
+	BucketACL(bucketName string) (*AccessControlPolicy, error)
+
+	SetBucketACL(bucketName string, acl AccessControlPolicy) error
+
+	// ObjectACL returns nil, and no error, if the object has no ACL.
+	ObjectACL(bucketName, objectName string, versionID VersionID) (*AccessControlPolicy, error)
+
+	SetObjectACL(bucketName, objectName string, versionID VersionID, acl AccessControlPolicy) error
+}
+
+// BucketTaggingBackend may be optionally implemented by a Backend in order to
+// support the bucket tagging subresource. If a Backend does not implement it,
+// those requests fail with ErrNotImplemented.
+//
+// The tags of a bucket must be discarded when it is deleted.
+//
+// All methods must return a gofakes3.ErrNoSuchBucket error if the bucket does
+// not exist.
+type BucketTaggingBackend interface {
+	// BucketTagging returns an empty or nil map, and no error, if the bucket
+	// has no tags.
+	BucketTagging(bucketName string) (map[string]string, error)
+
+	// SetBucketTagging replaces the full set of tags associated with the
+	// bucket. The tags will have been validated by GoFakeS3 before they are
+	// passed to the Backend.
+	SetBucketTagging(bucketName string, tags map[string]string) error
+
+	DeleteBucketTagging(bucketName string) error
+}
+
+// WebsiteBackend may be optionally implemented by a Backend in order to
+// support the bucket website subresource, and serving buckets from website
+// endpoints (see WithHostBucket). If a Backend does not implement it, those
+// requests fail with ErrNotImplemented.
+//
+// All methods must return a gofakes3.ErrNoSuchBucket error if the bucket does
+// not exist.
+type WebsiteBackend interface {
+	// BucketWebsite returns nil, and no error, if the bucket has no website
+	// configuration.
+	BucketWebsite(bucketName string) (*WebsiteConfiguration, error)
+
+	SetBucketWebsite(bucketName string, config WebsiteConfiguration) error
+
+	DeleteBucketWebsite(bucketName string) error
+}
+
+// RequestPaymentBackend may be optionally implemented by a Backend in order to
+// support the bucket requestPayment subresource. If a Backend does not
+// implement it, those requests fail with ErrNotImplemented, and the bucket
+// owner pays for every request.
+//
+// All methods must return a gofakes3.ErrNoSuchBucket error if the bucket does
+// not exist.
+type RequestPaymentBackend interface {
+	// BucketRequestPayment returns PayerBucketOwner, and no error, if the
+	// payer has never been set.
+	BucketRequestPayment(bucketName string) (Payer, error)
+
+	SetBucketRequestPayment(bucketName string, payer Payer) error
+}
+
+// BucketConfigBackend may be optionally implemented by a Backend in order to
+// store the bucket configurations that GoFakeS3 accepts but does not act on:
+// the accelerate, analytics, inventory, logging, metrics and notification
+// subresources, and the ownership controls and public access block, which
+// GoFakeS3 enforces itself.
+// If a Backend does not implement it, those requests fail with
+// ErrNotImplemented.
+//
+// Each configuration is an opaque XML document, identified by the name of its
+// subresource, which GoFakeS3 will have validated. A Backend only needs to
+// hand it back unchanged.
+//
+// All methods must return a gofakes3.ErrNoSuchBucket error if the bucket does
+// not exist.
+type BucketConfigBackend interface {
+	// BucketConfig returns nil, and no error, if the configuration has never
+	// been set.
+	BucketConfig(bucketName, subresource string) ([]byte, error)
+
+	// SetBucketConfig deletes the configuration if config is nil.
+	SetBucketConfig(bucketName, subresource string, config []byte) error
+}
+
+// PolicyBackend may be optionally implemented by a Backend in order to
+// support the bucket policy subresource. If a Backend does not implement it,
+// those requests fail with ErrNotImplemented.
+//
+// Policies are stored as the raw JSON document supplied by the client; they
+// are not evaluated.
+//
+// All methods must return a gofakes3.ErrNoSuchBucket error if the bucket does
+// not exist.
+type PolicyBackend interface {
+	// BucketPolicy returns nil, and no error, if the bucket has no policy.
+	BucketPolicy(bucketName string) ([]byte, error)
+
+	SetBucketPolicy(bucketName string, policy []byte) error
+
+	DeleteBucketPolicy(bucketName string) error
+}
+
+// CORSBackend may be optionally implemented by a Backend in order to support
+// the bucket CORS subresource. If a Backend does not implement it, those
+// requests fail with ErrNotImplemented, and GoFakeS3 answers every cross-origin
+// request according to the configuration set with WithCORS, or with permissive
+// server-wide CORS headers if there is none.
+//
+// All methods must return a gofakes3.ErrNoSuchBucket error if the bucket does
+// not exist.
+type CORSBackend interface {
+	// BucketCORS returns nil, and no error, if the bucket has no CORS
+	// configuration.
+	BucketCORS(bucketName string) (*CORSConfiguration, error)
+
+	SetBucketCORS(bucketName string, config CORSConfiguration) error
+
+	DeleteBucketCORS(bucketName string) error
+}
+
+// LifecycleBackend may be optionally implemented by a Backend in order to
+// support the bucket lifecycle subresource. If a Backend does not implement
+// it, those requests fail with ErrNotImplemented and the lifecycle sweeper
+// (see WithLifecycleSweep) does nothing.
+//
+// All methods must return a gofakes3.ErrNoSuchBucket error if the bucket does
+// not exist.
+type LifecycleBackend interface {
+	// BucketLifecycleConfiguration returns nil, and no error, if the bucket
+	// has no lifecycle configuration.
+	BucketLifecycleConfiguration(bucketName string) (*LifecycleConfiguration, error)
+
+	SetBucketLifecycleConfiguration(bucketName string, config LifecycleConfiguration) error
+
+	DeleteBucketLifecycleConfiguration(bucketName string) error
+}
+
+// ObjectLockBackend may be optionally implemented by a Backend in order to
+// support the object lock retention and legal hold subresources. If a
+// Backend does not implement it, those requests fail with ErrNotImplemented.
+//
+// For all methods, an empty versionID refers to the latest version of the
+// object. The methods must return a gofakes3.ErrNoSuchBucket error if the
+// bucket does not exist, a gofakes3.ErrNoSuchKey error if the object does not
+// exist, and a gofakes3.ErrNoSuchVersion error if the version does not exist.
+//
+// GoFakeS3 enforces the rules around deleting locked objects and shortening
+// retention periods; the Backend only needs to store the values.
+type ObjectLockBackend interface {
+	// GetObjectRetention returns nil, and no error, if the object version
+	// has no retention.
+	GetObjectRetention(bucketName, objectName string, versionID VersionID) (*ObjectRetention, error)
+
+	PutObjectRetention(bucketName, objectName string, versionID VersionID, retention ObjectRetention) error
+
+	// GetObjectLegalHold returns an empty status, and no error, if the
+	// object version has never had a legal hold set.
+	GetObjectLegalHold(bucketName, objectName string, versionID VersionID) (ObjectLockLegalHoldStatus, error)
+
+	PutObjectLegalHold(bucketName, objectName string, versionID VersionID, status ObjectLockLegalHoldStatus) error
+}
+
+// RestoreBackend may be optionally implemented by a Backend in order to
+// support RestoreObject, which makes an object in the GLACIER or DEEP_ARCHIVE
+// storage class readable for a number of days. If a Backend does not
+// implement it, restore requests fail with ErrNotImplemented, and archived
+// objects can be read like any other.
+//
+// For all methods, an empty versionID refers to the latest version of the
+// object. The methods must return a gofakes3.ErrNoSuchBucket error if the
+// bucket does not exist, a gofakes3.ErrNoSuchKey error if the object does not
+// exist, and a gofakes3.ErrNoSuchVersion error if the version does not exist.
+//
+// GoFakeS3 decides when a restore completes and expires; the Backend only
+// needs to store the values.
+type RestoreBackend interface {
+	// GetObjectRestore returns nil, and no error, if a restore of the object
+	// version has never been requested.
+	GetObjectRestore(bucketName, objectName string, versionID VersionID) (*ObjectRestore, error)
+
+	PutObjectRestore(bucketName, objectName string, versionID VersionID, restore ObjectRestore) error
+}
+
+// MultipartBackend may be optionally implemented by a Backend in order to
+// store the parts of multipart uploads itself. If a Backend does not implement
+// it, GoFakeS3 holds the parts in memory until the upload is completed, so an
+// upload needs as much memory as the object it creates.
+//
+// GoFakeS3 still keeps track of the uploads and validates their parts; the
+// Backend only needs to store the contents of each part. Parts are identified
+// by the bucket, the upload ID and the part number: upload IDs are unique
+// across all buckets, but not across restarts of GoFakeS3.
+//
+// All methods must return a gofakes3.ErrNoSuchBucket error if the bucket does
+// not exist.
+type MultipartBackend interface {
+	// PutMultipartPart stores a part of an upload, replacing any part with the
+	// same number. Exactly size bytes are read from input; if reading input
+	// fails, the error must be returned and any part previously stored with
+	// the same number must be kept.
+	PutMultipartPart(bucketName string, uploadID UploadID, partNumber int, input io.Reader, size int64) error
+
+	// CompleteMultipart stores the given parts of an upload, in order, as a
+	// new object of the given size, as PutObject would, then removes all of
+	// the upload's parts, including any that were not used.
+	CompleteMultipart(bucketName, objectName string, uploadID UploadID, partNumbers []int, meta map[string]string, size int64) (PutObjectResult, error)
+
+	// AbortMultipart removes all of the parts of an upload. It must not
+	// return an error if no parts were stored.
+	AbortMultipart(bucketName string, uploadID UploadID) error
+}
+
+// MultipartStateBackend may be implemented by a MultipartBackend that can
+// persist the multipart uploads in progress along with their parts, for
+// example in a snapshot, so that they can be completed after a restart.
+//
+// GoFakeS3 holds the uploads themselves, so New passes them to
+// SetMultipartUploads when it is created with a MultipartStateBackend.
+type MultipartStateBackend interface {
+	MultipartBackend
+
+	// SetMultipartUploads gives the Backend access to the uploads in progress
+	// in GoFakeS3. If the Backend restored any uploads before it was called,
+	// it should pass them to uploads.Restore.
+	SetMultipartUploads(uploads MultipartUploads)
+}
+
+// MultipartUploads gives a MultipartStateBackend access to the multipart
+// uploads in progress in GoFakeS3.
+type MultipartUploads interface {
+	// Uploads returns every upload in progress, ordered by bucket, object
+	// and initiation time.
+	Uploads() []MultipartUploadState
+
+	// Restore replaces every upload in progress with uploads, which were
+	// returned by Uploads. The parts of the uploads must already be stored
+	// in the Backend.
+	Restore(uploads []MultipartUploadState) error
+}
+
+// MultipartUploadState describes a multipart upload in progress, apart from
+// the contents of its parts, which are stored in the MultipartBackend.
+type MultipartUploadState struct {
+	ID                UploadID
+	Bucket            string
+	Object            string
+	Meta              map[string]string
+	Initiated         time.Time
+	ChecksumAlgorithm string
+	Parts             []MultipartPartState
+}
+
+// MultipartPartState describes a part of a MultipartUploadState.
+type MultipartPartState struct {
+	PartNumber   int
+	ETag         string
+	Size         int64
+	LastModified time.Time
+
+	// Checksum is only set if the upload has a ChecksumAlgorithm.
+	Checksum []byte
+}
+
 func MergeMetadata(db Backend, bucketName string, objectName string, meta map[string]string) error {
 	// get potential existing object to potentially carry metadata over
 	existingObj, err := db.GetObject(bucketName, objectName, nil)
@@ -320,6 +790,11 @@ func MergeMetadata(db Backend, bucketName string, objectName string, meta map[st
 	// carry over metadata if it exists
 	if existingObj != nil {
 		for k, v := range existingObj.Metadata {
+			// The ETag and parts stored for a multipart object describe its
+			// contents, which are being replaced:
+			if k == ETagMetaKey || k == objectPartsMetaKey {
+				continue
+			}
 			// new metadata overwrites old but keep the rest
 			// TODO: check how metadata can be deleted?!
 			if _, ok := meta[k]; !ok {
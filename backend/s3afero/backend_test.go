@@ -7,10 +7,13 @@ import (
 	"fmt"
 	"io/ioutil"
 	"os"
+	"path/filepath"
 	"reflect"
 	"testing"
+	"time"
 
 	"github.com/johannesboyne/gofakes3"
+	"github.com/johannesboyne/gofakes3/gofakes3test"
 	"github.com/spf13/afero"
 )
 
@@ -317,3 +320,360 @@ func TestMultiCreateBucket(t *testing.T) {
 		t.Fatal()
 	}
 }
+
+func TestMultiBucketCreationDate(t *testing.T) {
+	fs := afero.NewMemMapFs()
+	created := time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)
+	timeSource := gofakes3.FixedTimeSource(created)
+
+	multi, err := MultiBucket(fs, MultiWithTimeSource(timeSource))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := multi.CreateBucket("test"); err != nil {
+		t.Fatal(err)
+	}
+
+	// The date is stored with the bucket, so it survives the Backend being
+	// opened again, however much later:
+	timeSource.Advance(time.Hour)
+	multi, err = MultiBucket(fs, MultiWithTimeSource(timeSource))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := multi.PutObject("test", "object", nil, bytes.NewReader([]byte("hello")), 5); err != nil {
+		t.Fatal(err)
+	}
+
+	buckets, err := multi.ListBuckets()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(buckets) != 1 || !buckets[0].CreationDate.Time.Equal(created) {
+		t.Fatal("unexpected buckets:", buckets)
+	}
+}
+
+type failingReader struct{}
+
+func (failingReader) Read(p []byte) (n int, err error) {
+	return 0, fmt.Errorf("read failed")
+}
+
+func TestMultipart(t *testing.T) {
+	backends := testingBackends(t)
+
+	for _, backend := range backends {
+		t.Run(fmt.Sprintf("%T", backend), func(t *testing.T) {
+			mb := backend.(gofakes3.MultipartBackend)
+
+			put := func(partNumber int, contents string) {
+				t.Helper()
+				if err := mb.PutMultipartPart("test", "1", partNumber, bytes.NewReader([]byte(contents)), int64(len(contents))); err != nil {
+					t.Fatal(err)
+				}
+			}
+			put(1, "hello ")
+			put(2, "wrold")
+			put(2, "world")
+			put(3, "unused")
+
+			// A part that can not be read must not replace the stored part:
+			if err := mb.PutMultipartPart("test", "1", 1, failingReader{}, 6); err == nil {
+				t.Fatal("expected error")
+			}
+
+			meta := map[string]string{"foo": "bar"}
+			if _, err := mb.CompleteMultipart("test", "obj", "1", []int{1, 2}, meta, 11); err != nil {
+				t.Fatal(err)
+			}
+
+			obj, err := backend.GetObject("test", "obj", nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer obj.Contents.Close()
+			result, err := ioutil.ReadAll(obj.Contents)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(result) != "hello world" {
+				t.Fatal(string(result), "!=", "hello world")
+			}
+			if obj.Metadata["foo"] != "bar" {
+				t.Fatal("metadata not stored:", obj.Metadata)
+			}
+
+			// All of the parts are removed once the upload is completed:
+			if _, err := mb.CompleteMultipart("test", "obj", "1", []int{3}, meta, 6); !gofakes3.HasErrorCode(err, gofakes3.ErrInvalidPart) {
+				t.Fatal("expected ErrInvalidPart, found", err)
+			}
+
+			put(1, "aborted")
+			if err := mb.AbortMultipart("test", "1"); err != nil {
+				t.Fatal(err)
+			}
+			if _, err := mb.CompleteMultipart("test", "obj", "1", []int{1}, meta, 7); !gofakes3.HasErrorCode(err, gofakes3.ErrInvalidPart) {
+				t.Fatal("expected ErrInvalidPart, found", err)
+			}
+
+			if err := mb.PutMultipartPart("nope", "1", 1, bytes.NewReader(nil), 0); !gofakes3.HasErrorCode(err, gofakes3.ErrNoSuchBucket) {
+				t.Fatal("expected ErrNoSuchBucket, found", err)
+			}
+		})
+	}
+}
+
+func TestUntrackedObject(t *testing.T) {
+	singleFs, multiFs := afero.NewMemMapFs(), afero.NewMemMapFs()
+	single, err := SingleBucket("test", singleFs, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	multi, err := MultiBucket(multiFs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := multi.CreateBucket("test"); err != nil {
+		t.Fatal(err)
+	}
+
+	// Files written to the Fs outside gofakes3 have no metadata, so their
+	// hashes are calculated when they are first read:
+	for _, tc := range []struct {
+		backend  gofakes3.Backend
+		fs       afero.Fs
+		path     string
+		contents string
+	}{
+		{single, singleFs, "empty", ""},
+		{single, singleFs, "full", "contents"},
+		{multi, multiFs, "buckets/test/empty", ""},
+		{multi, multiFs, "buckets/test/full", "contents"},
+	} {
+		t.Run(fmt.Sprintf("%T/%s", tc.backend, tc.path), func(t *testing.T) {
+			if err := afero.WriteFile(tc.fs, tc.path, []byte(tc.contents), 0666); err != nil {
+				t.Fatal(err)
+			}
+			hash := md5.Sum([]byte(tc.contents))
+
+			obj, err := tc.backend.GetObject("test", filepath.Base(tc.path), nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer obj.Contents.Close()
+			result, err := ioutil.ReadAll(obj.Contents)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(result) != tc.contents {
+				t.Fatal(string(result), "!=", tc.contents)
+			}
+			if !bytes.Equal(obj.Hash, hash[:]) {
+				t.Fatal(hex.EncodeToString(obj.Hash), "!=", hex.EncodeToString(hash[:]))
+			}
+		})
+	}
+}
+
+func TestConformance(t *testing.T) {
+	timeSource := gofakes3.FixedTimeSource(time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC))
+
+	t.Run("multi", func(t *testing.T) {
+		gofakes3test.RunBackendConformance(t, func(t *testing.T) gofakes3.Backend {
+			multi, err := MultiBucket(afero.NewMemMapFs(), MultiWithTimeSource(timeSource))
+			if err != nil {
+				t.Fatal(err)
+			}
+			return multi
+		}, gofakes3test.WithTimeSource(timeSource))
+	})
+
+	t.Run("single", func(t *testing.T) {
+		gofakes3test.RunBackendConformance(t, func(t *testing.T) gofakes3.Backend {
+			single, err := SingleBucket("test", afero.NewMemMapFs(), nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			return single
+		}, gofakes3test.WithSingleBucket("test"))
+	})
+	t.Run("multi/sidecar", func(t *testing.T) {
+		gofakes3test.RunBackendConformance(t, func(t *testing.T) gofakes3.Backend {
+			multi, err := MultiBucket(afero.NewMemMapFs(), MultiWithSidecarMeta(), MultiWithTimeSource(timeSource))
+			if err != nil {
+				t.Fatal(err)
+			}
+			return multi
+		}, gofakes3test.WithTimeSource(timeSource))
+	})
+
+	t.Run("single/sidecar", func(t *testing.T) {
+		gofakes3test.RunBackendConformance(t, func(t *testing.T) gofakes3.Backend {
+			single, err := SingleBucket("test", afero.NewMemMapFs(), nil, SingleWithSidecarMeta())
+			if err != nil {
+				t.Fatal(err)
+			}
+			return single
+		}, gofakes3test.WithSingleBucket("test"))
+	})
+}
+
+func TestSidecarMeta(t *testing.T) {
+	for _, tc := range []struct {
+		name    string
+		backend func(t *testing.T, fs afero.Fs) gofakes3.Backend
+		root    string
+	}{
+		{"multi", func(t *testing.T, fs afero.Fs) gofakes3.Backend {
+			multi, err := MultiBucket(fs, MultiWithSidecarMeta())
+			if err != nil {
+				t.Fatal(err)
+			}
+			if ok, _ := multi.BucketExists("test"); !ok {
+				if err := multi.CreateBucket("test"); err != nil {
+					t.Fatal(err)
+				}
+			}
+			return multi
+		}, "buckets/test/"},
+		{"single", func(t *testing.T, fs afero.Fs) gofakes3.Backend {
+			single, err := SingleBucket("test", fs, nil, SingleWithSidecarMeta())
+			if err != nil {
+				t.Fatal(err)
+			}
+			return single
+		}, ""},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			fs := afero.NewMemMapFs()
+			backend := tc.backend(t, fs)
+
+			meta := map[string]string{"Content-Type": "text/csv", "X-Amz-Meta-Foo": "bar"}
+			contents := []byte("a,b,c")
+			if _, err := backend.PutObject("test", "dir/obj", meta, bytes.NewReader(contents), int64(len(contents))); err != nil {
+				t.Fatal(err)
+			}
+			if ok, _ := afero.Exists(fs, tc.root+"dir/obj"+SidecarSuffix); !ok {
+				t.Fatal("sidecar not written")
+			}
+
+			// The metadata is read back from the sidecar by a new backend:
+			backend = tc.backend(t, fs)
+			obj, err := backend.HeadObject("test", "dir/obj")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(obj.Metadata, meta) {
+				t.Fatal(obj.Metadata, "!=", meta)
+			}
+			if hash := md5.Sum(contents); !bytes.Equal(obj.Hash, hash[:]) {
+				t.Fatal(hex.EncodeToString(obj.Hash), "!=", hex.EncodeToString(hash[:]))
+			}
+
+			list, err := backend.ListBucket("test", nil, gofakes3.ListBucketPage{})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(list.Contents) != 1 || list.Contents[0].Key != "dir/obj" {
+				t.Fatal("unexpected listing:", list.Contents)
+			}
+
+			// Sidecars are not objects:
+			if _, err := backend.HeadObject("test", "dir/obj"+SidecarSuffix); !gofakes3.HasErrorCode(err, gofakes3.ErrNoSuchKey) {
+				t.Fatal("expected ErrNoSuchKey, found", err)
+			}
+			if _, err := backend.PutObject("test", "x"+SidecarSuffix, nil, bytes.NewReader(nil), 0); !gofakes3.HasErrorCode(err, gofakes3.ErrInvalidArgument) {
+				t.Fatal("expected ErrInvalidArgument, found", err)
+			}
+
+			// Without a sidecar, the Content-Type is detected from the contents:
+			if err := afero.WriteFile(fs, tc.root+"untracked", []byte("<html><body></body></html>"), 0666); err != nil {
+				t.Fatal(err)
+			}
+			obj, err = backend.HeadObject("test", "untracked")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if ct := obj.Metadata["Content-Type"]; ct != "text/html; charset=utf-8" {
+				t.Fatal("unexpected Content-Type", ct)
+			}
+
+			if _, err := backend.DeleteObject("test", "dir/obj"); err != nil {
+				t.Fatal(err)
+			}
+			if ok, _ := afero.Exists(fs, tc.root+"dir/obj"+SidecarSuffix); ok {
+				t.Fatal("sidecar not deleted")
+			}
+		})
+	}
+}
+
+func TestObjectKeyConfinedToBucket(t *testing.T) {
+	for _, tc := range []struct {
+		name    string
+		backend func(fs afero.Fs) (gofakes3.Backend, error)
+		root    string
+	}{
+		{"multi", func(fs afero.Fs) (gofakes3.Backend, error) {
+			multi, err := MultiBucket(fs)
+			if err == nil {
+				err = multi.CreateBucket("test")
+			}
+			return multi, err
+		}, "buckets/test/"},
+		{"single", func(fs afero.Fs) (gofakes3.Backend, error) {
+			return SingleBucket("test", afero.NewBasePathFs(fs, "bucket"), nil)
+		}, "bucket/"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			fs := afero.NewMemMapFs()
+			if err := afero.WriteFile(fs, "secret", []byte("secret"), 0666); err != nil {
+				t.Fatal(err)
+			}
+			backend, err := tc.backend(fs)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			for _, key := range []string{
+				"../secret",
+				"../../secret",
+				"dir/../../secret",
+				"dir/../file",
+				"./file",
+				"/secret",
+				"/",
+				"dir/",
+				"dir//file",
+				"dir\\..\\..\\secret",
+			} {
+				if _, err := backend.PutObject("test", key, nil, bytes.NewReader([]byte("overwritten")), 11); !gofakes3.HasErrorCode(err, gofakes3.ErrInvalidArgument) {
+					t.Fatalf("put %q: expected ErrInvalidArgument, found %v", key, err)
+				}
+				if _, err := backend.GetObject("test", key, nil); !gofakes3.HasErrorCode(err, gofakes3.ErrInvalidArgument) {
+					t.Fatalf("get %q: expected ErrInvalidArgument, found %v", key, err)
+				}
+				if _, err := backend.HeadObject("test", key); !gofakes3.HasErrorCode(err, gofakes3.ErrInvalidArgument) {
+					t.Fatalf("head %q: expected ErrInvalidArgument, found %v", key, err)
+				}
+				if _, err := backend.DeleteObject("test", key); !gofakes3.HasErrorCode(err, gofakes3.ErrInvalidArgument) {
+					t.Fatalf("delete %q: expected ErrInvalidArgument, found %v", key, err)
+				}
+			}
+
+			if data, err := afero.ReadFile(fs, "secret"); err != nil || string(data) != "secret" {
+				t.Fatal("file outside the bucket was changed:", string(data), err)
+			}
+
+			// Keys with slashes are still stored in nested directories:
+			contents := []byte("nested")
+			if _, err := backend.PutObject("test", "a/b/c..d/.e", nil, bytes.NewReader(contents), int64(len(contents))); err != nil {
+				t.Fatal(err)
+			}
+			if data, err := afero.ReadFile(fs, tc.root+"a/b/c..d/.e"); err != nil || !bytes.Equal(data, contents) {
+				t.Fatal("nested object not stored:", string(data), err)
+			}
+		})
+	}
+}
@@ -3,19 +3,33 @@ package s3afero
 import (
 	"crypto/md5"
 	"io"
+	"net/http"
 	"path/filepath"
 
 	"github.com/spf13/afero"
 )
 
-func hashFile(fs afero.Fs, path string) (hash []byte, err error) {
+// hashFile returns the MD5 hash of the file at path, along with the
+// Content-Type detected from the start of it.
+func hashFile(fs afero.Fs, path string) (hash []byte, contentType string, err error) {
 	f, err := fs.Open(filepath.FromSlash(path))
 	if err != nil {
-		return nil, err
+		return nil, "", err
 	}
 	defer f.Close()
 
+	// http.DetectContentType considers at most the first 512 bytes:
+	head := make([]byte, 512)
+	n, err := io.ReadFull(f, head)
+	if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+		return nil, "", err
+	}
+	head = head[:n]
+
 	h := md5.New()
-	io.Copy(h, f)
-	return h.Sum(nil), nil
+	h.Write(head)
+	if _, err := io.Copy(h, f); err != nil {
+		return nil, "", err
+	}
+	return h.Sum(nil), http.DetectContentType(head), nil
 }
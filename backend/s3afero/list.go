@@ -0,0 +1,60 @@
+package s3afero
+
+import (
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/johannesboyne/gofakes3"
+	"github.com/spf13/afero"
+)
+
+// listObjects lists a page of the objects in fs, in which each file is an
+// object whose key is its slash-separated path.
+//
+// The filesystem does not return keys in the order S3 lists them ('a-b' comes
+// before 'a/b' in S3, but after it in a walk), so every key under the
+// directory containing the prefix is gathered and sorted before the page is
+// built. Files for which hidden returns true are not objects, and are left
+// out. content is only called for the keys that appear in the page.
+func listObjects(fs afero.Fs, prefix *gofakes3.Prefix, page gofakes3.ListBucketPage, hidden func(key string) bool, content func(key string, info os.FileInfo) (*gofakes3.Content, error)) (*gofakes3.ObjectList, error) {
+	// Only the directory containing the prefix needs to be walked:
+	root := ""
+	if idx := strings.LastIndexByte(prefix.Prefix, '/'); idx >= 0 {
+		root = prefix.Prefix[:idx]
+	}
+
+	var keys []string
+	var infos = map[string]os.FileInfo{}
+
+	if err := afero.Walk(fs, filepath.FromSlash(root), func(path string, info os.FileInfo, err error) error {
+		if os.IsNotExist(err) && path == filepath.FromSlash(root) {
+			return nil // Nothing has been stored under the prefix
+		} else if err != nil || info.IsDir() {
+			return err
+		}
+		key := filepath.ToSlash(path)
+		if strings.HasPrefix(key, prefix.Prefix) && !hidden(key) {
+			keys = append(keys, key)
+			infos[key] = info
+		}
+		return nil
+
+	}); err != nil {
+		return nil, err
+	}
+
+	sort.Strings(keys)
+
+	var i int
+	return gofakes3.ListBucketKeys(prefix, page, func() (string, bool, error) {
+		if i >= len(keys) {
+			return "", false, nil
+		}
+		i++
+		return keys[i-1], true, nil
+	}, func(key string) (*gofakes3.Content, error) {
+		return content(key, infos[key])
+	})
+}
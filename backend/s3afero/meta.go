@@ -5,6 +5,7 @@ import (
 	"encoding/json"
 	"hash/fnv"
 	"os"
+	"path"
 	"path/filepath"
 	"strings"
 	"time"
@@ -20,26 +21,50 @@ type Metadata struct {
 	Meta    map[string]string
 }
 
+// bucketMetadata is stored for each bucket created by a MultiBucketBackend.
+type bucketMetadata struct {
+	CreationDate time.Time
+}
+
 type metaPath struct {
 	bucket string
 	object string
 }
 
 func (mp metaPath) FilePath() string {
-	return filepath.Join(mp.bucket, mp.object)
+	return filepath.Join(mp.bucket, filepath.FromSlash(mp.object))
 }
 
+// SidecarSuffix is appended to the path of an object to find its metadata
+// when the metadata is stored in sidecar files next to the objects. Files
+// with this suffix are not listed as objects.
+const SidecarSuffix = ".gofakes3-meta.json"
+
+// sidecarTempSuffix is the suffix of a sidecar while it is being written.
+const sidecarTempSuffix = SidecarSuffix + ".tmp"
+
+// objectHasher returns the MD5 hash of the contents of an object, and the
+// Content-Type detected from them.
+type objectHasher func(bucket string, object string) (hash []byte, contentType string, err error)
+
 type metaStore struct {
 	fs          afero.Fs
 	modTimeCalc modTimeCalc
 	modTimeRes  time.Duration
+	hashObject  objectHasher
+
+	// If sidecar is not nil, metadata is stored in sidecar files in fs, at
+	// the slash-separated path it returns for an object, rather than under
+	// a mangled name in a directory for each bucket.
+	sidecar func(bucket, object string) string
 }
 
-func newMetaStore(fs afero.Fs, modTimeCalc modTimeCalc) *metaStore {
+func newMetaStore(fs afero.Fs, modTimeCalc modTimeCalc, hashObject objectHasher) *metaStore {
 	b := &metaStore{
 		fs:          fs,
 		modTimeCalc: modTimeCalc,
 		modTimeRes:  -1,
+		hashObject:  hashObject,
 	}
 	return b
 }
@@ -54,7 +79,19 @@ func (ms *metaStore) getModTimeRes() (dur time.Duration, err error) {
 	return ms.modTimeRes, nil
 }
 
+// isSidecar reports whether the slash-separated key names a sidecar rather
+// than an object, which is only possible if the metadata is stored in
+// sidecar files.
+func (ms *metaStore) isSidecar(key string) bool {
+	return ms.sidecar != nil &&
+		(strings.HasSuffix(key, SidecarSuffix) || strings.HasSuffix(key, sidecarTempSuffix))
+}
+
 func (ms *metaStore) metaPath(bucket string, object string) metaPath {
+	if ms.sidecar != nil {
+		return metaPath{object: ms.sidecar(bucket, object) + SidecarSuffix}
+	}
+
 	// FIXME: may need to add path segments but that may be a thing of the past:
 	// https://stackoverflow.com/questions/466521/how-many-files-can-i-put-in-a-directory
 	h := fnv.New128a()
@@ -65,6 +102,42 @@ func (ms *metaStore) metaPath(bucket string, object string) metaPath {
 	return metaPath{bucket, object + "-" + hex.EncodeToString(h.Sum(nil))}
 }
 
+// bucketMetaPath is the path of the metadata of the bucket itself, which can
+// not be mistaken for the metadata of an object: in a sidecar, it is the
+// metadata of the object with an empty key, and otherwise, it lacks the hash
+// suffix of the name of every object's metadata.
+func (ms *metaStore) bucketMetaPath(bucket string) metaPath {
+	if ms.sidecar != nil {
+		return metaPath{object: path.Join(ms.sidecar(bucket, ""), SidecarSuffix)}
+	}
+	return metaPath{bucket: bucket, object: "bucket.json"}
+}
+
+// loadBucketMeta returns nil if there is no metadata for the bucket, which
+// is the case if the bucket was not created by gofakes3.
+func (ms *metaStore) loadBucketMeta(bucket string) (*bucketMetadata, error) {
+	bts, err := afero.ReadFile(ms.fs, ms.bucketMetaPath(bucket).FilePath())
+	if os.IsNotExist(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	var meta bucketMetadata
+	if err := json.Unmarshal(bts, &meta); err != nil {
+		return nil, err
+	}
+	return &meta, nil
+}
+
+func (ms *metaStore) saveBucketMeta(bucket string, meta *bucketMetadata) error {
+	bts, err := json.Marshal(meta)
+	if err != nil {
+		return err
+	}
+	return ms.writeFile(ms.bucketMetaPath(bucket), bts)
+}
+
 func (ms *metaStore) loadMeta(bucket string, object string, size int64, mtime time.Time) (*Metadata, error) {
 	metaPath := ms.metaPath(bucket, object)
 	fullPath := metaPath.FilePath()
@@ -75,7 +148,8 @@ func (ms *metaStore) loadMeta(bucket string, object string, size int64, mtime ti
 	}
 
 	var meta Metadata
-	if len(bts) > 0 {
+	var stored = len(bts) > 0
+	if stored {
 		if err := json.Unmarshal(bts, &meta); err != nil {
 			return nil, err
 		}
@@ -90,10 +164,22 @@ func (ms *metaStore) loadMeta(bucket string, object string, size int64, mtime ti
 	if len(meta.Hash) == 0 || meta.Size != size || modDiff < -modRes || modDiff > modRes {
 		meta.Size = size
 		meta.ModTime = mtime
-		meta.Hash, err = hashFile(ms.fs, fullPath)
+		// The object was changed outside gofakes3, or has no metadata, so
+		// the hash of its contents must be calculated again:
+		var contentType string
+		meta.Hash, contentType, err = ms.hashObject(bucket, object)
 		if err != nil {
 			return nil, err
 		}
+
+		// An object without metadata was not stored by gofakes3, so the
+		// best we can do for its Content-Type is to guess it:
+		if !stored && meta.Meta["Content-Type"] == "" {
+			if meta.Meta == nil {
+				meta.Meta = map[string]string{}
+			}
+			meta.Meta["Content-Type"] = contentType
+		}
 		if err := ms.saveMeta(metaPath, &meta); err != nil {
 			return nil, err
 		}
@@ -107,11 +193,25 @@ func (ms *metaStore) saveMeta(path metaPath, meta *Metadata) error {
 	if err != nil {
 		return err
 	}
+	return ms.writeFile(path, bts)
+}
+
+func (ms *metaStore) writeFile(path metaPath, bts []byte) error {
 	if err := ms.fs.MkdirAll(filepath.Dir(path.FilePath()), 0777); err != nil {
 		return err
 	}
 
-	return afero.WriteFile(ms.fs, path.FilePath(), bts, 0666)
+	// The metadata is written to a temporary file and renamed into place so
+	// that it is never seen half-written, even if gofakes3 stops mid-write:
+	tmp := path.FilePath() + ".tmp"
+	if err := afero.WriteFile(ms.fs, tmp, bts, 0666); err != nil {
+		return err
+	}
+	if err := ms.fs.Rename(tmp, path.FilePath()); err != nil {
+		ms.fs.Remove(tmp)
+		return err
+	}
+	return nil
 }
 
 func (ms *metaStore) deleteMeta(path metaPath) error {
@@ -2,14 +2,12 @@ package s3afero
 
 import (
 	"crypto/md5"
-	"encoding/hex"
 	"fmt"
 	"io"
 	"log"
 	"os"
 	"path"
 	"path/filepath"
-	"strings"
 	"sync"
 
 	"github.com/johannesboyne/gofakes3"
@@ -20,7 +18,11 @@ import (
 // MultiBucketBackend is a gofakes3.Backend that allows you to create multiple
 // buckets within the same afero.Fs. Buckets are stored under the `/buckets`
 // subdirectory. Metadata is stored in the `/metadata` subdirectory by default,
-// but any afero.Fs can be used.
+// but any afero.Fs can be used. With MultiWithSidecarMeta, the metadata of
+// each object is stored in a file next to it instead.
+//
+// The parts of multipart uploads are stored in the `/multipart`
+// subdirectory until the upload is completed or aborted.
 //
 // It is STRONGLY recommended that the metadata Fs is not contained within the
 // `/buckets` subdirectory as that could make a significant mess, but this is
@@ -31,17 +33,22 @@ type MultiBucketBackend struct {
 	baseFs    afero.Fs
 	bucketFs  afero.Fs
 	metaStore *metaStore
+	parts     *partStore
 	dirMode   os.FileMode
 
+	timeSource gofakes3.TimeSource
+
 	// FIXME(bw): values in here should not be used beyond the configuration
 	// step; maybe this can be cleaned up later using a builder struct or
 	// something.
 	configOnly struct {
-		metaFs afero.Fs
+		metaFs      afero.Fs
+		sidecarMeta bool
 	}
 }
 
 var _ gofakes3.Backend = &MultiBucketBackend{}
+var _ gofakes3.MultipartBackend = &MultiBucketBackend{}
 
 func MultiBucket(fs afero.Fs, opts ...MultiOption) (*MultiBucketBackend, error) {
 	if err := ensureNoOsFs("fs", fs); err != nil {
@@ -51,6 +58,7 @@ func MultiBucket(fs afero.Fs, opts ...MultiOption) (*MultiBucketBackend, error)
 	b := &MultiBucketBackend{
 		baseFs:   fs,
 		bucketFs: afero.NewBasePathFs(fs, "buckets"),
+		parts:    &partStore{fs: afero.NewBasePathFs(fs, "multipart")},
 		dirMode:  0700,
 	}
 	for _, opt := range opts {
@@ -58,11 +66,27 @@ func MultiBucket(fs afero.Fs, opts ...MultiOption) (*MultiBucketBackend, error)
 			return nil, err
 		}
 	}
+	if b.timeSource == nil {
+		b.timeSource = gofakes3.DefaultTimeSource()
+	}
+
+	hashObject := func(bucket, object string) ([]byte, string, error) {
+		return hashFile(b.bucketFs, path.Join(bucket, object))
+	}
+
+	if b.configOnly.sidecarMeta {
+		if b.configOnly.metaFs != nil {
+			return nil, fmt.Errorf("gofakes3: MultiWithMetaFs can not be used with MultiWithSidecarMeta")
+		}
+		b.metaStore = newMetaStore(b.bucketFs, modTimeFsCalc(fs), hashObject)
+		b.metaStore.sidecar = func(bucket, object string) string { return path.Join(bucket, object) }
 
-	if b.configOnly.metaFs == nil {
-		b.configOnly.metaFs = afero.NewBasePathFs(fs, "metadata")
+	} else {
+		if b.configOnly.metaFs == nil {
+			b.configOnly.metaFs = afero.NewBasePathFs(fs, "metadata")
+		}
+		b.metaStore = newMetaStore(b.configOnly.metaFs, modTimeFsCalc(fs), hashObject)
 	}
-	b.metaStore = newMetaStore(b.configOnly.metaFs, modTimeFsCalc(fs))
 
 	return b, nil
 }
@@ -82,15 +106,23 @@ func (db *MultiBucketBackend) ListBuckets() ([]gofakes3.BucketInfo, error) {
 			continue
 		}
 
+		meta, err := db.metaStore.loadBucketMeta(dirEntry.Name())
+		if err != nil {
+			return nil, err
+		}
+
+		// A bucket that was not created by CreateBucket has no creation date.
+		// "Birth time" is not available cross-platform, so the ModTime of its
+		// directory is the least-worst option, even though it changes when
+		// objects are added to the top level of the bucket:
+		created := dirEntry.ModTime()
+		if meta != nil {
+			created = meta.CreationDate
+		}
+
 		buckets = append(buckets, gofakes3.BucketInfo{
-			Name: dirEntry.Name(),
-
-			// FIXME: "birth time" is not available cross-platform.
-			// https://github.com/djherbis/times provides access to it on supported
-			// platforms, but that wouldn't really be compatible with afero.
-			// ModTime and some documented caveats might be the least-worst
-			// option for this particular backend:
-			CreationDate: gofakes3.NewContentTime(dirEntry.ModTime()),
+			Name:         dirEntry.Name(),
+			CreationDate: gofakes3.NewContentTime(created),
 		})
 	}
 
@@ -104,68 +136,10 @@ func (db *MultiBucketBackend) ListBucket(bucket string, prefix *gofakes3.Prefix,
 	if err := gofakes3.ValidateBucketName(bucket); err != nil {
 		return nil, gofakes3.BucketNotFound(bucket)
 	}
-	if !page.IsEmpty() {
-		return nil, gofakes3.ErrInternalPageNotImplemented
-	}
 
 	db.lock.Lock()
 	defer db.lock.Unlock()
 
-	path, part, ok := prefix.FilePrefix()
-	if ok {
-		return db.getBucketWithFilePrefixLocked(bucket, path, part)
-	} else {
-		return db.getBucketWithArbitraryPrefixLocked(bucket, prefix)
-	}
-}
-
-func (db *MultiBucketBackend) getBucketWithFilePrefixLocked(bucket string, prefixPath, prefixPart string) (*gofakes3.ObjectList, error) {
-	bucketPath := path.Join(bucket, prefixPath)
-
-	dirEntries, err := afero.ReadDir(db.bucketFs, filepath.FromSlash(bucketPath))
-	if os.IsNotExist(err) {
-		return nil, gofakes3.BucketNotFound(bucket)
-	} else if err != nil {
-		return nil, err
-	}
-
-	response := gofakes3.NewObjectList()
-
-	for _, entry := range dirEntries {
-		object := entry.Name()
-
-		// Expected use of 'path'; see the "Path Handling" subheading in doc.go:
-		objectPath := path.Join(prefixPath, object)
-
-		if prefixPart != "" && !strings.HasPrefix(object, prefixPart) {
-			continue
-		}
-
-		if entry.IsDir() {
-			response.AddPrefix(path.Join(prefixPath, prefixPart))
-
-		} else {
-			size := entry.Size()
-			mtime := entry.ModTime()
-
-			meta, err := db.metaStore.loadMeta(bucket, objectPath, size, mtime)
-			if err != nil {
-				return nil, err
-			}
-
-			response.Add(&gofakes3.Content{
-				Key:          objectPath,
-				LastModified: gofakes3.NewContentTime(mtime),
-				ETag:         `"` + hex.EncodeToString(meta.Hash) + `"`,
-				Size:         size,
-			})
-		}
-	}
-
-	return response, nil
-}
-
-func (db *MultiBucketBackend) getBucketWithArbitraryPrefixLocked(bucket string, prefix *gofakes3.Prefix) (*gofakes3.ObjectList, error) {
 	stat, err := db.bucketFs.Stat(filepath.FromSlash(bucket))
 	if os.IsNotExist(err) {
 		return nil, gofakes3.BucketNotFound(bucket)
@@ -175,45 +149,23 @@ func (db *MultiBucketBackend) getBucketWithArbitraryPrefixLocked(bucket string,
 		return nil, fmt.Errorf("gofakes3: expected %q to be a bucket path", bucket)
 	}
 
-	response := gofakes3.NewObjectList()
-
-	if err := afero.Walk(db.bucketFs, filepath.FromSlash(bucket), func(path string, info os.FileInfo, err error) error {
-		if err != nil || info.IsDir() {
-			return err
-		}
-
-		objectPath := filepath.ToSlash(path)
-		parts := strings.SplitN(objectPath, "/", 2)
-		if len(parts) != 2 {
-			panic(fmt.Errorf("unexpected path %q", path)) // should never happen
-		}
-		objectName := parts[1]
-
-		if !prefix.Match(objectName, nil) {
-			return nil
-		}
-
+	bucketFs := afero.NewBasePathFs(db.bucketFs, filepath.FromSlash(bucket))
+	return listObjects(bucketFs, prefix, page, db.metaStore.isSidecar, func(objectName string, info os.FileInfo) (*gofakes3.Content, error) {
 		size := info.Size()
 		mtime := info.ModTime()
 		meta, err := db.metaStore.loadMeta(bucket, objectName, size, mtime)
 		if err != nil {
-			return err
+			return nil, err
 		}
 
-		response.Add(&gofakes3.Content{
+		return &gofakes3.Content{
 			Key:          objectName,
 			LastModified: gofakes3.NewContentTime(mtime),
-			ETag:         `"` + hex.EncodeToString(meta.Hash) + `"`,
+			ETag:         gofakes3.ObjectETag(meta.Hash, meta.Meta),
+			StorageClass: gofakes3.ObjectStorageClass(meta.Meta),
 			Size:         size,
-		})
-
-		return nil
-
-	}); err != nil {
-		return nil, err
-	}
-
-	return response, nil
+		}, nil
+	})
 }
 
 func (db *MultiBucketBackend) CreateBucket(name string) error {
@@ -224,7 +176,7 @@ func (db *MultiBucketBackend) CreateBucket(name string) error {
 		if err := db.bucketFs.MkdirAll(name, db.dirMode); err != nil {
 			return err
 		}
-		return nil
+		return db.metaStore.saveBucketMeta(name, &bucketMetadata{CreationDate: db.timeSource.Now()})
 	} else if err != nil {
 		return err
 	} else {
@@ -237,11 +189,21 @@ func (db *MultiBucketBackend) DeleteBucket(name string) (rerr error) {
 	defer db.lock.Unlock()
 
 	entries, err := afero.ReadDir(db.bucketFs, name)
-	if err != nil {
+	if os.IsNotExist(err) {
+		return gofakes3.BucketNotFound(name)
+	} else if err != nil {
 		return err
 	}
 
-	if len(entries) > 0 {
+	// The metadata of the bucket itself may be a sidecar in its directory,
+	// which does not stop it being empty:
+	var objects int
+	for _, entry := range entries {
+		if !db.metaStore.isSidecar(entry.Name()) {
+			objects++
+		}
+	}
+	if objects > 0 {
 		// This check is slightly racy. If another service outside gofakes3
 		// changes the filesystem between this check and the call to Remove,
 		// the bucket may be deleted even though there are items in it. You
@@ -288,6 +250,13 @@ func (db *MultiBucketBackend) HeadObject(bucketName, objectName string) (*gofake
 
 	fullPath := path.Join(bucketName, objectName)
 
+	if err := checkObjectKey(objectName); err != nil {
+		return nil, err
+	}
+	if db.metaStore.isSidecar(objectName) {
+		return nil, gofakes3.KeyNotFound(objectName)
+	}
+
 	stat, err := db.bucketFs.Stat(filepath.FromSlash(fullPath))
 	if os.IsNotExist(err) {
 		return nil, gofakes3.KeyNotFound(objectName)
@@ -303,11 +272,12 @@ func (db *MultiBucketBackend) HeadObject(bucketName, objectName string) (*gofake
 	}
 
 	return &gofakes3.Object{
-		Name:     objectName,
-		Hash:     meta.Hash,
-		Metadata: meta.Meta,
-		Size:     size,
-		Contents: s3io.NoOpReadCloser{},
+		Name:         objectName,
+		Hash:         meta.Hash,
+		Metadata:     meta.Meta,
+		Size:         size,
+		LastModified: mtime,
+		Contents:     s3io.NoOpReadCloser{},
 	}, nil
 }
 
@@ -325,6 +295,13 @@ func (db *MultiBucketBackend) GetObject(bucketName, objectName string, rangeRequ
 
 	fullPath := path.Join(bucketName, objectName)
 
+	if err := checkObjectKey(objectName); err != nil {
+		return nil, err
+	}
+	if db.metaStore.isSidecar(objectName) {
+		return nil, gofakes3.KeyNotFound(objectName)
+	}
+
 	f, err := db.bucketFs.Open(filepath.FromSlash(fullPath))
 	if os.IsNotExist(err) {
 		return nil, gofakes3.KeyNotFound(objectName)
@@ -365,12 +342,13 @@ func (db *MultiBucketBackend) GetObject(bucketName, objectName string, rangeRequ
 	}
 
 	return &gofakes3.Object{
-		Name:     objectName,
-		Hash:     meta.Hash,
-		Metadata: meta.Meta,
-		Range:    rnge,
-		Size:     size,
-		Contents: rdr,
+		Name:         objectName,
+		Hash:         meta.Hash,
+		Metadata:     meta.Meta,
+		Range:        rnge,
+		Size:         size,
+		LastModified: mtime,
+		Contents:     rdr,
 	}, nil
 }
 
@@ -380,6 +358,13 @@ func (db *MultiBucketBackend) PutObject(
 	input io.Reader, size int64,
 ) (result gofakes3.PutObjectResult, err error) {
 
+	if err := checkObjectKey(objectName); err != nil {
+		return result, err
+	}
+	if db.metaStore.isSidecar(objectName) {
+		return result, gofakes3.ErrorInvalidArgument("key", objectName, "Object keys ending with "+SidecarSuffix+" are reserved for metadata")
+	}
+
 	err = gofakes3.MergeMetadata(db, bucketName, objectName, meta)
 	if err != nil {
 		return result, err
@@ -468,6 +453,13 @@ func (db *MultiBucketBackend) DeleteObject(bucketName, objectName string) (resul
 }
 
 func (db *MultiBucketBackend) deleteObjectLocked(bucketName, objectName string) error {
+	if err := checkObjectKey(objectName); err != nil {
+		return err
+	}
+	if db.metaStore.isSidecar(objectName) {
+		return nil // There is no such object, but the sidecar must not be removed
+	}
+
 	fullPath := path.Join(bucketName, objectName)
 
 	// S3 does not report an error when attemping to delete a key that does not exist, so
@@ -512,3 +504,51 @@ func (db *MultiBucketBackend) DeleteMulti(bucketName string, objects ...string)
 
 	return result, nil
 }
+
+// PutMultipartPart implements gofakes3.MultipartBackend.
+func (db *MultiBucketBackend) PutMultipartPart(bucketName string, uploadID gofakes3.UploadID, partNumber int, input io.Reader, size int64) error {
+	if err := db.ensureBucketExists(bucketName); err != nil {
+		return err
+	}
+	return db.parts.put(bucketName, uploadID, partNumber, input, size)
+}
+
+// CompleteMultipart implements gofakes3.MultipartBackend. The parts are
+// copied into the object one at a time.
+func (db *MultiBucketBackend) CompleteMultipart(bucketName, objectName string, uploadID gofakes3.UploadID, partNumbers []int, meta map[string]string, size int64) (result gofakes3.PutObjectResult, err error) {
+	if err := db.ensureBucketExists(bucketName); err != nil {
+		return result, err
+	}
+
+	rdr, err := db.parts.open(bucketName, uploadID, partNumbers)
+	if err != nil {
+		return result, err
+	}
+	defer rdr.Close()
+
+	if result, err = db.PutObject(bucketName, objectName, meta, rdr, size); err != nil {
+		return result, err
+	}
+	return result, db.parts.remove(bucketName, uploadID)
+}
+
+// AbortMultipart implements gofakes3.MultipartBackend.
+func (db *MultiBucketBackend) AbortMultipart(bucketName string, uploadID gofakes3.UploadID) error {
+	if err := db.ensureBucketExists(bucketName); err != nil {
+		return err
+	}
+	return db.parts.remove(bucketName, uploadID)
+}
+
+func (db *MultiBucketBackend) ensureBucketExists(bucketName string) error {
+	db.lock.Lock()
+	defer db.lock.Unlock()
+
+	exists, err := afero.Exists(db.bucketFs, bucketName)
+	if err != nil {
+		return err
+	} else if !exists {
+		return gofakes3.BucketNotFound(bucketName)
+	}
+	return nil
+}
@@ -1,6 +1,7 @@
 package s3afero
 
 import (
+	"github.com/johannesboyne/gofakes3"
 	"github.com/spf13/afero"
 )
 
@@ -16,4 +17,44 @@ func MultiWithMetaFs(fs afero.Fs) MultiOption {
 	}
 }
 
+// MultiWithSidecarMeta stores the metadata of each object in a sidecar file
+// next to it, named as the object with SidecarSuffix appended, so that the
+// metadata is kept alongside the objects in the same Fs. It can not be used
+// with MultiWithMetaFs.
+func MultiWithSidecarMeta() MultiOption {
+	return func(b *MultiBucketBackend) error {
+		b.configOnly.sidecarMeta = true
+		return nil
+	}
+}
+
+// MultiWithTimeSource sets the TimeSource used to record the creation date of
+// each bucket. The default is gofakes3.DefaultTimeSource().
+func MultiWithTimeSource(timeSource gofakes3.TimeSource) MultiOption {
+	return func(b *MultiBucketBackend) error {
+		b.timeSource = timeSource
+		return nil
+	}
+}
+
 type SingleOption func(b *SingleBucketBackend) error
+
+// SingleWithTimeSource sets the TimeSource used for the creation date of the
+// bucket while its Fs is empty. The default is gofakes3.DefaultTimeSource().
+func SingleWithTimeSource(timeSource gofakes3.TimeSource) SingleOption {
+	return func(b *SingleBucketBackend) error {
+		b.timeSource = timeSource
+		return nil
+	}
+}
+
+// SingleWithSidecarMeta stores the metadata of each object in a sidecar file
+// next to it, named as the object with SidecarSuffix appended, so that the
+// metadata persists in the bucket's Fs. The metaFs passed to SingleBucket
+// then only holds the parts of multipart uploads.
+func SingleWithSidecarMeta() SingleOption {
+	return func(b *SingleBucketBackend) error {
+		b.configOnly.sidecarMeta = true
+		return nil
+	}
+}
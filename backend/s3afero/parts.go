@@ -0,0 +1,116 @@
+package s3afero
+
+import (
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+
+	"github.com/johannesboyne/gofakes3"
+	"github.com/spf13/afero"
+)
+
+// partStore holds the parts of multipart uploads in an afero.Fs, with a
+// directory for each upload, so that they do not need to be held in memory.
+type partStore struct {
+	fs afero.Fs
+}
+
+func (ps *partStore) uploadDir(bucket string, uploadID gofakes3.UploadID) string {
+	return filepath.Join(bucket, string(uploadID))
+}
+
+func (ps *partStore) partPath(bucket string, uploadID gofakes3.UploadID, partNumber int) string {
+	return filepath.Join(ps.uploadDir(bucket, uploadID), strconv.Itoa(partNumber))
+}
+
+// put writes the part to a temporary file, which only replaces the part once
+// all of it has been written.
+func (ps *partStore) put(bucket string, uploadID gofakes3.UploadID, partNumber int, input io.Reader, size int64) error {
+	dir := ps.uploadDir(bucket, uploadID)
+	if err := ps.fs.MkdirAll(dir, 0700); err != nil {
+		return err
+	}
+
+	f, err := afero.TempFile(ps.fs, dir, "part")
+	if err != nil {
+		return err
+	}
+	n, err := io.Copy(f, input)
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil && n != size {
+		err = gofakes3.ErrIncompleteBody
+	}
+	if err != nil {
+		ps.fs.Remove(f.Name())
+		return err
+	}
+
+	return ps.fs.Rename(f.Name(), ps.partPath(bucket, uploadID, partNumber))
+}
+
+// open returns a reader over the given parts, in order. Only one part is open
+// at a time.
+func (ps *partStore) open(bucket string, uploadID gofakes3.UploadID, partNumbers []int) (io.ReadCloser, error) {
+	paths := make([]string, 0, len(partNumbers))
+	for _, partNumber := range partNumbers {
+		path := ps.partPath(bucket, uploadID, partNumber)
+		if _, err := ps.fs.Stat(path); os.IsNotExist(err) {
+			return nil, gofakes3.ErrInvalidPart
+		} else if err != nil {
+			return nil, err
+		}
+		paths = append(paths, path)
+	}
+	return &partsReader{fs: ps.fs, paths: paths}, nil
+}
+
+// remove removes all of the parts of an upload.
+func (ps *partStore) remove(bucket string, uploadID gofakes3.UploadID) error {
+	if err := ps.fs.RemoveAll(ps.uploadDir(bucket, uploadID)); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+type partsReader struct {
+	fs    afero.Fs
+	paths []string
+	cur   afero.File
+}
+
+func (pr *partsReader) Read(p []byte) (n int, err error) {
+	for {
+		if pr.cur == nil {
+			if len(pr.paths) == 0 {
+				return 0, io.EOF
+			}
+			if pr.cur, err = pr.fs.Open(pr.paths[0]); err != nil {
+				return 0, err
+			}
+			pr.paths = pr.paths[1:]
+		}
+
+		n, err = pr.cur.Read(p)
+		if err != io.EOF {
+			return n, err
+		}
+		if err := pr.Close(); err != nil {
+			return n, err
+		}
+		if n > 0 {
+			return n, nil
+		}
+	}
+}
+
+func (pr *partsReader) Close() error {
+	if pr.cur == nil {
+		return nil
+	}
+	err := pr.cur.Close()
+	pr.cur = nil
+	return err
+}
@@ -2,14 +2,10 @@ package s3afero
 
 import (
 	"crypto/md5"
-	"encoding/hex"
-	"fmt"
 	"io"
 	"log"
 	"os"
-	"path"
 	"path/filepath"
-	"strings"
 	"sync"
 	"time"
 
@@ -23,7 +19,11 @@ import (
 //
 // A second afero.Fs, metaFs, may be passed; if this is nil,
 // afero.NewMemMapFs() is used and the metadata will not persist between
-// restarts of gofakes3.
+// restarts of gofakes3. With SingleWithSidecarMeta, the metadata of each
+// object is stored in a file next to it in fs instead.
+//
+// The parts of multipart uploads are stored in the `/_multipart`
+// subdirectory of metaFs until the upload is completed or aborted.
 //
 // It is STRONGLY recommended that the metadata Fs is not contained within the
 // `/buckets` subdirectory as that could make a significant mess, but this is
@@ -33,10 +33,20 @@ type SingleBucketBackend struct {
 	lock      sync.Mutex
 	fs        afero.Fs
 	metaStore *metaStore
+	parts     *partStore
 	name      string
+
+	// timeSource is only used for the creation date of the bucket, if its
+	// Fs does not exist yet; objects are dated by the Fs.
+	timeSource gofakes3.TimeSource
+
+	configOnly struct {
+		sidecarMeta bool
+	}
 }
 
 var _ gofakes3.Backend = &SingleBucketBackend{}
+var _ gofakes3.MultipartBackend = &SingleBucketBackend{}
 
 func SingleBucket(name string, fs afero.Fs, metaFs afero.Fs, opts ...SingleOption) (*SingleBucketBackend, error) {
 	if err := ensureNoOsFs("fs", fs); err != nil {
@@ -55,16 +65,33 @@ func SingleBucket(name string, fs afero.Fs, metaFs afero.Fs, opts ...SingleOptio
 		return nil, err
 	}
 
+	hashObject := func(bucket, object string) ([]byte, string, error) {
+		return hashFile(fs, object)
+	}
+
 	b := &SingleBucketBackend{
-		name:      name,
-		fs:        fs,
-		metaStore: newMetaStore(metaFs, modTimeFsCalc(fs)),
+		name: name,
+		fs:   fs,
+
+		// Metadata is stored under the bucket name, so the underscore
+		// guarantees no overlap with it:
+		parts: &partStore{fs: afero.NewBasePathFs(metaFs, "_multipart")},
 	}
 	for _, opt := range opts {
 		if err := opt(b); err != nil {
 			return nil, err
 		}
 	}
+	if b.timeSource == nil {
+		b.timeSource = gofakes3.DefaultTimeSource()
+	}
+
+	if b.configOnly.sidecarMeta {
+		b.metaStore = newMetaStore(fs, modTimeFsCalc(fs), hashObject)
+		b.metaStore.sidecar = func(bucket, object string) string { return object }
+	} else {
+		b.metaStore = newMetaStore(metaFs, modTimeFsCalc(fs), hashObject)
+	}
 
 	return b, nil
 }
@@ -77,7 +104,7 @@ func (db *SingleBucketBackend) ListBuckets() ([]gofakes3.BucketInfo, error) {
 
 	stat, err := db.fs.Stat("")
 	if os.IsNotExist(err) {
-		created = time.Now()
+		created = db.timeSource.Now()
 	} else if err != nil {
 		return nil, err
 	} else {
@@ -98,103 +125,26 @@ func (db *SingleBucketBackend) ListBucket(bucket string, prefix *gofakes3.Prefix
 	if prefix == nil {
 		prefix = emptyPrefix
 	}
-	if !page.IsEmpty() {
-		return nil, gofakes3.ErrInternalPageNotImplemented
-	}
 
 	db.lock.Lock()
 	defer db.lock.Unlock()
 
-	path, part, ok := prefix.FilePrefix()
-	if ok {
-		return db.getBucketWithFilePrefixLocked(bucket, path, part)
-	} else {
-		return db.getBucketWithArbitraryPrefixLocked(bucket, prefix)
-	}
-}
-
-func (db *SingleBucketBackend) getBucketWithFilePrefixLocked(bucket string, prefixPath, prefixPart string) (*gofakes3.ObjectList, error) {
-	dirEntries, err := afero.ReadDir(db.fs, filepath.FromSlash(prefixPath))
-	if err != nil {
-		return nil, err
-	}
-
-	response := gofakes3.NewObjectList()
-
-	for _, entry := range dirEntries {
-		object := entry.Name()
-
-		// Expected use of 'path'; see the "Path Handling" subheading in doc.go:
-		objectPath := path.Join(prefixPath, object)
-
-		if prefixPart != "" && !strings.HasPrefix(object, prefixPart) {
-			continue
-		}
-
-		if entry.IsDir() {
-			response.AddPrefix(path.Join(prefixPath, prefixPart))
-
-		} else {
-			size := entry.Size()
-			mtime := entry.ModTime()
-
-			meta, err := db.metaStore.loadMeta(bucket, objectPath, size, mtime)
-			if err != nil {
-				return nil, err
-			}
-
-			response.Add(&gofakes3.Content{
-				Key:          objectPath,
-				LastModified: gofakes3.NewContentTime(mtime),
-				ETag:         `"` + hex.EncodeToString(meta.Hash) + `"`,
-				Size:         size,
-			})
-		}
-	}
-
-	return response, nil
-}
-
-func (db *SingleBucketBackend) getBucketWithArbitraryPrefixLocked(bucket string, prefix *gofakes3.Prefix) (*gofakes3.ObjectList, error) {
-	response := gofakes3.NewObjectList()
-
-	if err := afero.Walk(db.fs, filepath.FromSlash(bucket), func(path string, info os.FileInfo, err error) error {
-		if err != nil || info.IsDir() {
-			return err
-		}
-
-		objectPath := filepath.ToSlash(path)
-		parts := strings.SplitN(objectPath, "/", 2)
-		if len(parts) != 2 {
-			panic(fmt.Errorf("unexpected path %q", path)) // should never happen
-		}
-		objectName := parts[1]
-
-		if !prefix.Match(objectName, nil) {
-			return nil
-		}
-
+	return listObjects(db.fs, prefix, page, db.metaStore.isSidecar, func(objectName string, info os.FileInfo) (*gofakes3.Content, error) {
 		size := info.Size()
 		mtime := info.ModTime()
 		meta, err := db.metaStore.loadMeta(bucket, objectName, size, mtime)
 		if err != nil {
-			return err
+			return nil, err
 		}
 
-		response.Add(&gofakes3.Content{
+		return &gofakes3.Content{
 			Key:          objectName,
 			LastModified: gofakes3.NewContentTime(mtime),
-			ETag:         `"` + hex.EncodeToString(meta.Hash) + `"`,
+			ETag:         gofakes3.ObjectETag(meta.Hash, meta.Meta),
+			StorageClass: gofakes3.ObjectStorageClass(meta.Meta),
 			Size:         size,
-		})
-
-		return nil
-
-	}); err != nil {
-		return nil, err
-	}
-
-	return response, nil
+		}, nil
+	})
 }
 
 func (db *SingleBucketBackend) HeadObject(bucketName, objectName string) (*gofakes3.Object, error) {
@@ -205,6 +155,13 @@ func (db *SingleBucketBackend) HeadObject(bucketName, objectName string) (*gofak
 	db.lock.Lock()
 	defer db.lock.Unlock()
 
+	if err := checkObjectKey(objectName); err != nil {
+		return nil, err
+	}
+	if db.metaStore.isSidecar(objectName) {
+		return nil, gofakes3.KeyNotFound(objectName)
+	}
+
 	stat, err := db.fs.Stat(filepath.FromSlash(objectName))
 	if os.IsNotExist(err) {
 		return nil, gofakes3.KeyNotFound(objectName)
@@ -220,11 +177,12 @@ func (db *SingleBucketBackend) HeadObject(bucketName, objectName string) (*gofak
 	}
 
 	return &gofakes3.Object{
-		Name:     objectName,
-		Hash:     meta.Hash,
-		Metadata: meta.Meta,
-		Size:     size,
-		Contents: s3io.NoOpReadCloser{},
+		Name:         objectName,
+		Hash:         meta.Hash,
+		Metadata:     meta.Meta,
+		Size:         size,
+		LastModified: mtime,
+		Contents:     s3io.NoOpReadCloser{},
 	}, nil
 }
 
@@ -236,6 +194,13 @@ func (db *SingleBucketBackend) GetObject(bucketName, objectName string, rangeReq
 	db.lock.Lock()
 	defer db.lock.Unlock()
 
+	if err := checkObjectKey(objectName); err != nil {
+		return nil, err
+	}
+	if db.metaStore.isSidecar(objectName) {
+		return nil, gofakes3.KeyNotFound(objectName)
+	}
+
 	f, err := db.fs.Open(filepath.FromSlash(objectName))
 	if os.IsNotExist(err) {
 		return nil, gofakes3.KeyNotFound(objectName)
@@ -276,12 +241,13 @@ func (db *SingleBucketBackend) GetObject(bucketName, objectName string, rangeReq
 	}
 
 	return &gofakes3.Object{
-		Name:     objectName,
-		Hash:     meta.Hash,
-		Metadata: meta.Meta,
-		Size:     size,
-		Range:    rnge,
-		Contents: rdr,
+		Name:         objectName,
+		Hash:         meta.Hash,
+		Metadata:     meta.Meta,
+		Size:         size,
+		LastModified: mtime,
+		Range:        rnge,
+		Contents:     rdr,
 	}, nil
 }
 
@@ -295,6 +261,13 @@ func (db *SingleBucketBackend) PutObject(
 		return result, gofakes3.BucketNotFound(bucketName)
 	}
 
+	if err := checkObjectKey(objectName); err != nil {
+		return result, err
+	}
+	if db.metaStore.isSidecar(objectName) {
+		return result, gofakes3.ErrorInvalidArgument("key", objectName, "Object keys ending with "+SidecarSuffix+" are reserved for metadata")
+	}
+
 	err = gofakes3.MergeMetadata(db, bucketName, objectName, meta)
 	if err != nil {
 		return result, err
@@ -397,6 +370,13 @@ func (db *SingleBucketBackend) DeleteObject(bucketName, objectName string) (resu
 }
 
 func (db *SingleBucketBackend) deleteObjectLocked(bucketName, objectName string) error {
+	if err := checkObjectKey(objectName); err != nil {
+		return err
+	}
+	if db.metaStore.isSidecar(objectName) {
+		return nil // There is no such object, but the sidecar must not be removed
+	}
+
 	// S3 does not report an error when attemping to delete a key that does not exist, so
 	// we need to skip IsNotExist errors.
 	if err := db.fs.Remove(filepath.FromSlash(objectName)); err != nil && !os.IsNotExist(err) {
@@ -424,3 +404,38 @@ func (db *SingleBucketBackend) DeleteBucket(name string) error {
 func (db *SingleBucketBackend) BucketExists(name string) (exists bool, err error) {
 	return db.name == name, nil
 }
+
+// PutMultipartPart implements gofakes3.MultipartBackend.
+func (db *SingleBucketBackend) PutMultipartPart(bucketName string, uploadID gofakes3.UploadID, partNumber int, input io.Reader, size int64) error {
+	if bucketName != db.name {
+		return gofakes3.BucketNotFound(bucketName)
+	}
+	return db.parts.put(bucketName, uploadID, partNumber, input, size)
+}
+
+// CompleteMultipart implements gofakes3.MultipartBackend. The parts are
+// copied into the object one at a time.
+func (db *SingleBucketBackend) CompleteMultipart(bucketName, objectName string, uploadID gofakes3.UploadID, partNumbers []int, meta map[string]string, size int64) (result gofakes3.PutObjectResult, err error) {
+	if bucketName != db.name {
+		return result, gofakes3.BucketNotFound(bucketName)
+	}
+
+	rdr, err := db.parts.open(bucketName, uploadID, partNumbers)
+	if err != nil {
+		return result, err
+	}
+	defer rdr.Close()
+
+	if result, err = db.PutObject(bucketName, objectName, meta, rdr, size); err != nil {
+		return result, err
+	}
+	return result, db.parts.remove(bucketName, uploadID)
+}
+
+// AbortMultipart implements gofakes3.MultipartBackend.
+func (db *SingleBucketBackend) AbortMultipart(bucketName string, uploadID gofakes3.UploadID) error {
+	if bucketName != db.name {
+		return gofakes3.BucketNotFound(bucketName)
+	}
+	return db.parts.remove(bucketName, uploadID)
+}
@@ -34,6 +34,26 @@ func (rwc *readerWithCloser) Close() error {
 	return nil
 }
 
+// checkObjectKey returns an InvalidArgument error if the object can not be
+// stored at the path named by its key. Each '/' separated segment of the key
+// is a directory or file name relative to the root of the bucket, so segments
+// that would resolve elsewhere ('.' and '..'), or that are empty (as in
+// '/abs', 'a//b' and 'dir/'), can not be mapped to the filesystem without
+// escaping the bucket or merging with another key.
+//
+// Backslashes are rejected too, as some afero.Fs implementations treat them
+// as separators (see doc.go).
+func checkObjectKey(key string) error {
+	for _, segment := range strings.Split(key, "/") {
+		if segment == "" || segment == "." || segment == ".." {
+			return gofakes3.ErrorInvalidArgument("key", key, "Object key can not be stored as a path inside the bucket")
+		} else if strings.Contains(segment, "\\") {
+			return gofakes3.ErrorInvalidArgument("key", key, "Object key may not contain a backslash")
+		}
+	}
+	return nil
+}
+
 // ensureNoOsFs makes a best-effort attempt to ensure you haven't used
 // afero.OsFs directly in any of these backends; to do so would risk exposing
 // you to RemoveAll against your `/` directory.
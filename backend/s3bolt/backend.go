@@ -2,14 +2,13 @@ package s3bolt
 
 import (
 	"bytes"
+	"context"
 	"crypto/md5"
-	"encoding/hex"
 	"fmt"
 	"io"
 	"log"
 
 	"github.com/johannesboyne/gofakes3"
-	"github.com/johannesboyne/gofakes3/internal/s3io"
 	bolt "go.etcd.io/bbolt"
 	"gopkg.in/mgo.v2/bson"
 )
@@ -19,12 +18,15 @@ var (
 )
 
 type Backend struct {
-	bolt           *bolt.DB
-	timeSource     gofakes3.TimeSource
-	metaBucketName []byte
+	bolt                *bolt.DB
+	timeSource          gofakes3.TimeSource
+	metaBucketName      []byte
+	multipartBucketName []byte
 }
 
 var _ gofakes3.Backend = &Backend{}
+var _ gofakes3.ContextBackend = &Backend{}
+var _ gofakes3.MultipartBackend = &Backend{}
 
 type Option func(b *Backend)
 
@@ -40,13 +42,32 @@ func NewFile(file string, opts ...Option) (*Backend, error) {
 	if err != nil {
 		return nil, err
 	}
-	return New(db, opts...), nil
+	b := newBackend(db, opts...)
+	if err := b.upgrade(); err != nil {
+		db.Close()
+		return nil, err
+	}
+	return b, nil
 }
 
+// New returns a Backend that stores its buckets in the bolt database, which
+// is upgraded first if it was written by an older version of this package.
+// As New can not return an error, a failed upgrade is logged and the
+// database is used as it is; use NewFile to have it reported instead.
 func New(bolt *bolt.DB, opts ...Option) *Backend {
+	b := newBackend(bolt, opts...)
+	if err := b.upgrade(); err != nil {
+		log.Println("gofakes3: bolt database upgrade failed:", err)
+	}
+	return b
+}
+
+func newBackend(bolt *bolt.DB, opts ...Option) *Backend {
 	b := &Backend{
 		bolt:           bolt,
 		metaBucketName: []byte("_meta"), // Underscore guarantees no overlap with legal S3 bucket names
+
+		multipartBucketName: []byte("_multipart"),
 	}
 	for _, opt := range opts {
 		opt(b)
@@ -59,7 +80,6 @@ func New(bolt *bolt.DB, opts ...Option) *Backend {
 
 // metaBucket returns a utility that manages access to the metadata bucket.
 // The returned struct is valid only for the lifetime of the bolt.Tx.
-// The metadata bucket may not exist if this is an older database.
 func (db *Backend) metaBucket(tx *bolt.Tx) (*metaBucket, error) {
 	var bucket *bolt.Bucket
 	var err error
@@ -72,7 +92,8 @@ func (db *Backend) metaBucket(tx *bolt.Tx) (*metaBucket, error) {
 	} else {
 		bucket = tx.Bucket(db.metaBucketName)
 		if bucket == nil {
-			// FIXME: support legacy databases; remove when versioning is supported.
+			// An older database is only left without a metadata bucket if
+			// it was opened read-only, or its upgrade failed:
 			return nil, nil
 		}
 	}
@@ -84,6 +105,61 @@ func (db *Backend) metaBucket(tx *bolt.Tx) (*metaBucket, error) {
 	}, nil
 }
 
+// upgrade brings a database written by an older version of this package up
+// to the current schemaVersion. Read-only databases are left as they are.
+func (db *Backend) upgrade() error {
+	if db.bolt.IsReadOnly() {
+		return nil
+	}
+
+	return db.bolt.Update(func(tx *bolt.Tx) error {
+		metaBucket, err := db.metaBucket(tx)
+		if err != nil {
+			return err
+		}
+		version, err := metaBucket.schemaVersion()
+		if err != nil {
+			return err
+		} else if version >= schemaVersion {
+			return nil
+		}
+
+		if version < 1 {
+			// The creation date of a bucket created before it was recorded
+			// is lost, so the time of the upgrade is recorded instead; that
+			// at least stops it changing every time the buckets are listed:
+			var names []string
+			if err := tx.ForEach(func(name []byte, _ *bolt.Bucket) error {
+				if !db.isInternalBucket(name) {
+					names = append(names, string(name))
+				}
+				return nil
+			}); err != nil {
+				return err
+			}
+
+			now := db.timeSource.Now()
+			for _, name := range names {
+				if info, err := metaBucket.s3Bucket(name); err != nil {
+					return err
+				} else if info == nil {
+					if err := metaBucket.createS3Bucket(name, now); err != nil {
+						return err
+					}
+				}
+			}
+		}
+
+		return metaBucket.setSchemaVersion(schemaVersion)
+	})
+}
+
+// isInternalBucket reports whether the bolt bucket is used by the Backend
+// itself, rather than holding the objects of an S3 bucket.
+func (db *Backend) isInternalBucket(name []byte) bool {
+	return bytes.Equal(name, db.metaBucketName) || bytes.Equal(name, db.multipartBucketName)
+}
+
 func (db *Backend) ListBuckets() ([]gofakes3.BucketInfo, error) {
 	var buckets []gofakes3.BucketInfo
 
@@ -94,19 +170,17 @@ func (db *Backend) ListBuckets() ([]gofakes3.BucketInfo, error) {
 		}
 
 		return tx.ForEach(func(name []byte, _ *bolt.Bucket) error {
-			if bytes.Equal(name, db.metaBucketName) {
+			if db.isInternalBucket(name) {
 				return nil
 			}
 
 			nameStr := string(name)
 			info := gofakes3.BucketInfo{Name: nameStr}
 
-			// Attempt to assign metadata. If it isn't found, we will just
-			// pretend that's fine for now. This is to support existing
-			// databases that have buckets created without associated metadata.
-			//
-			// FIXME: clean this up when there is an upgrade script to expect
-			// that it exists
+			// Attempt to assign metadata. It is only missing if the database
+			// was written by an older version of this package and could not
+			// be upgraded (see upgrade), in which case we just pretend that's
+			// fine.
 			if metaBucket != nil {
 				bucketInfo, err := metaBucket.s3Bucket(nameStr)
 				if err != nil {
@@ -134,11 +208,7 @@ func (db *Backend) ListBucket(name string, prefix *gofakes3.Prefix, page gofakes
 	if prefix == nil {
 		prefix = emptyPrefix
 	}
-	if !page.IsEmpty() {
-		return nil, gofakes3.ErrInternalPageNotImplemented
-	}
-
-	objects := gofakes3.NewObjectList()
+	var objects *gofakes3.ObjectList
 
 	err := db.bolt.View(func(tx *bolt.Tx) error {
 		b := tx.Bucket([]byte(name))
@@ -146,37 +216,50 @@ func (db *Backend) ListBucket(name string, prefix *gofakes3.Prefix, page gofakes
 			return gofakes3.BucketNotFound(name)
 		}
 
-		c := b.Cursor()
-		var match gofakes3.PrefixMatch
-
-		for k, v := c.First(); k != nil; k, v = c.Next() {
-			key := string(k)
-			if !prefix.Match(key, &match) {
-				continue
-
-			} else if match.CommonPrefix {
-				objects.AddPrefix(match.MatchedPart)
+		// Keys are sorted, so the listing starts with the first key after
+		// the marker that could match the prefix, and ends with the last:
+		start := prefix.Prefix
+		if page.HasMarker && page.Marker > start {
+			start = page.Marker
+		}
 
-			} else {
-				var b boltObject
-				err := bson.Unmarshal(v, &b)
-				if err != nil {
-					return fmt.Errorf("gofakes3: could not unmarshal object %q: %v", string(k[:]), err)
-				}
-				item := &gofakes3.Content{
-					Key:          string(k[:]),
-					ETag:         `"` + hex.EncodeToString(b.Hash[:]) + `"`,
-					Size:         b.Size,
-					LastModified: gofakes3.NewContentTime(b.LastModified.UTC()),
-				}
-				objects.Add(item)
+		c := b.Cursor()
+		k, v := c.Seek([]byte(start))
+
+		// value is that of the key next returned last, which content is
+		// called for:
+		var value []byte
+		next := func() (string, bool, error) {
+			if k == nil || !bytes.HasPrefix(k, []byte(prefix.Prefix)) {
+				return "", false, nil
 			}
+			key := string(k)
+			value = v
+			k, v = c.Next()
+			return key, true, nil
 		}
 
-		return nil
+		var err error
+		objects, err = gofakes3.ListBucketKeys(prefix, page, next, func(key string) (*gofakes3.Content, error) {
+			var b boltObjectInfo
+			if err := bson.Unmarshal(value, &b); err != nil {
+				return nil, fmt.Errorf("gofakes3: could not unmarshal object %q: %v", key, err)
+			}
+			return &gofakes3.Content{
+				Key:          key,
+				ETag:         gofakes3.ObjectETag(b.Hash, b.Metadata),
+				StorageClass: gofakes3.ObjectStorageClass(b.Metadata),
+				Size:         b.Size,
+				LastModified: gofakes3.NewContentTime(b.LastModified.UTC()),
+			}, nil
+		})
+		return err
 	})
+	if err != nil {
+		return nil, err
+	}
 
-	return objects, err
+	return objects, nil
 }
 
 func (db *Backend) CreateBucket(name string) error {
@@ -207,7 +290,7 @@ func (db *Backend) CreateBucket(name string) error {
 func (db *Backend) DeleteBucket(name string) error {
 	nameBts := []byte(name)
 
-	if bytes.Equal(nameBts, db.metaBucketName) {
+	if db.isInternalBucket(nameBts) {
 		return gofakes3.ResourceError(gofakes3.ErrInvalidBucketName, name)
 	}
 
@@ -229,13 +312,8 @@ func (db *Backend) DeleteBucket(name string) error {
 			if err != nil {
 				return err
 			}
-
-			// FIXME: assumes a legacy database, where the bucket may not exist. Clean
-			// this up when there is a DB upgrade script.
-			if metaBucket != nil {
-				if err := metaBucket.deleteS3Bucket(name); err != nil {
-					return err
-				}
+			if err := metaBucket.deleteS3Bucket(name); err != nil {
+				return err
 			}
 		}
 
@@ -253,42 +331,62 @@ func (db *Backend) BucketExists(name string) (exists bool, err error) {
 }
 
 func (db *Backend) HeadObject(bucketName, objectName string) (*gofakes3.Object, error) {
-	obj, err := db.GetObject(bucketName, objectName, nil)
+	var t boltObjectInfo
+
+	err := db.bolt.View(func(tx *bolt.Tx) error {
+		return db.getObject(tx, bucketName, objectName, &t)
+	})
 	if err != nil {
 		return nil, err
 	}
-	obj.Contents = s3io.NoOpReadCloser{}
-	return obj, nil
+
+	return t.Object(objectName), nil
 }
 
-func (db *Backend) GetObject(bucketName, objectName string, rangeRequest *gofakes3.ObjectRangeRequest) (*gofakes3.Object, error) {
-	var t boltObject
+// getObject unmarshals the object into t, which is either a boltObject or,
+// if the contents are not needed, a boltObjectInfo.
+func (db *Backend) getObject(tx *bolt.Tx, bucketName, objectName string, t interface{}) error {
+	b := tx.Bucket([]byte(bucketName))
+	if b == nil {
+		return gofakes3.BucketNotFound(bucketName)
+	}
 
-	err := db.bolt.View(func(tx *bolt.Tx) error {
-		b := tx.Bucket([]byte(bucketName))
-		if b == nil {
-			return gofakes3.BucketNotFound(bucketName)
-		}
+	v := b.Get([]byte(objectName))
+	if v == nil {
+		return gofakes3.KeyNotFound(objectName)
+	}
 
-		v := b.Get([]byte(objectName))
-		if v == nil {
-			return gofakes3.KeyNotFound(objectName)
-		}
+	if err := bson.Unmarshal(v, t); err != nil {
+		return fmt.Errorf("gofakes3: could not unmarshal object at %q/%q: %v", bucketName, objectName, err)
+	}
 
-		if err := bson.Unmarshal(v, &t); err != nil {
-			return fmt.Errorf("gofakes3: could not unmarshal object at %q/%q: %v", bucketName, objectName, err)
-		}
+	return nil
+}
 
-		return nil
-	})
+func (db *Backend) GetObject(bucketName, objectName string, rangeRequest *gofakes3.ObjectRangeRequest) (*gofakes3.Object, error) {
+	return db.GetObjectContext(context.Background(), bucketName, objectName, rangeRequest)
+}
 
+// GetObjectContext implements gofakes3.ContextBackend; reading the Contents
+// of the returned Object fails once ctx is cancelled.
+func (db *Backend) GetObjectContext(ctx context.Context, bucketName, objectName string, rangeRequest *gofakes3.ObjectRangeRequest) (*gofakes3.Object, error) {
+	var t boltObject
+
+	err := db.bolt.View(func(tx *bolt.Tx) error {
+		return db.getObject(tx, bucketName, objectName, &t)
+	})
 	if err != nil {
 		return nil, err
 	}
 
 	// FIXME: objectName here is a bit of a hack; this can be cleaned up when we have a
 	// database migration script.
-	return t.Object(objectName, rangeRequest)
+	obj, err := t.Object(objectName, rangeRequest)
+	if err != nil {
+		return nil, err
+	}
+	obj.Contents = gofakes3.ContextReadCloser(ctx, obj.Contents)
+	return obj, nil
 }
 
 func (db *Backend) PutObject(
@@ -296,8 +394,19 @@ func (db *Backend) PutObject(
 	meta map[string]string,
 	input io.Reader, size int64,
 ) (result gofakes3.PutObjectResult, err error) {
+	return db.PutObjectContext(context.Background(), bucketName, objectName, meta, input, size)
+}
+
+// PutObjectContext implements gofakes3.ContextBackend; it stops reading input
+// once ctx is cancelled.
+func (db *Backend) PutObjectContext(
+	ctx context.Context,
+	bucketName, objectName string,
+	meta map[string]string,
+	input io.Reader, size int64,
+) (result gofakes3.PutObjectResult, err error) {
 
-	bts, err := gofakes3.ReadAll(input, size)
+	bts, err := gofakes3.ReadAllContext(ctx, input, size)
 	if err != nil {
 		return result, err
 	}
@@ -317,12 +426,14 @@ func (db *Backend) PutObject(
 		}
 
 		data, err := bson.Marshal(&boltObject{
-			Name:         objectName,
-			Metadata:     meta,
-			Size:         int64(len(bts)),
-			LastModified: mod,
-			Contents:     bts,
-			Hash:         hash[:],
+			boltObjectInfo: boltObjectInfo{
+				Name:         objectName,
+				Metadata:     meta,
+				Size:         int64(len(bts)),
+				LastModified: mod,
+				Hash:         hash[:],
+			},
+			Contents: bts,
 		})
 		if err != nil {
 			return err
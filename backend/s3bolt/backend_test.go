@@ -0,0 +1,214 @@
+package s3bolt
+
+import (
+	"bytes"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/johannesboyne/gofakes3"
+	"github.com/johannesboyne/gofakes3/gofakes3test"
+	bolt "go.etcd.io/bbolt"
+	"gopkg.in/mgo.v2/bson"
+)
+
+func TestConformance(t *testing.T) {
+	timeSource := gofakes3.FixedTimeSource(time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC))
+	gofakes3test.RunBackendConformance(t, func(t *testing.T) gofakes3.Backend {
+		db, err := NewFile(filepath.Join(t.TempDir(), "bolt.db"), WithTimeSource(timeSource))
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { db.bolt.Close() })
+		return db
+	}, gofakes3test.WithTimeSource(timeSource))
+}
+
+func TestReopen(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "bolt.db")
+	created := time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)
+
+	db, err := NewFile(file, WithTimeSource(gofakes3.FixedTimeSource(created)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := db.CreateBucket("bucket"); err != nil {
+		t.Fatal(err)
+	}
+	meta := map[string]string{"Content-Type": "text/csv", "X-Amz-Meta-Foo": "bar"}
+	if _, err := db.PutObject("bucket", "object", meta, bytes.NewReader([]byte("a,b")), 3); err != nil {
+		t.Fatal(err)
+	}
+	db.bolt.Close()
+
+	db, err = NewFile(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.bolt.Close()
+
+	obj, err := db.HeadObject("bucket", "object")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(obj.Metadata, meta) {
+		t.Fatal(obj.Metadata, "!=", meta)
+	}
+	if obj.Size != 3 {
+		t.Fatal("size", obj.Size, "!=", 3)
+	}
+
+	list, err := db.ListBucket("bucket", nil, gofakes3.ListBucketPage{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(list.Contents) != 1 || !list.Contents[0].LastModified.Time.Equal(created) {
+		t.Fatal("unexpected listing:", list.Contents)
+	}
+
+	buckets, err := db.ListBuckets()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(buckets) != 1 || !buckets[0].CreationDate.Time.Equal(created) {
+		t.Fatal("unexpected buckets:", buckets)
+	}
+}
+
+func TestUpgrade(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "bolt.db")
+
+	// Databases written before the "_meta" bucket existed only hold a bolt
+	// bucket of objects for each S3 bucket:
+	legacy, err := bolt.Open(file, 0600, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := legacy.Update(func(tx *bolt.Tx) error {
+		b, err := tx.CreateBucket([]byte("legacy"))
+		if err != nil {
+			return err
+		}
+		data, err := bson.Marshal(&boltObject{
+			boltObjectInfo: boltObjectInfo{Name: "object", Metadata: map[string]string{"Content-Type": "text/plain"}, Size: 4},
+			Contents:       []byte("body"),
+		})
+		if err != nil {
+			return err
+		}
+		return b.Put([]byte("object"), data)
+	}); err != nil {
+		t.Fatal(err)
+	}
+	legacy.Close()
+
+	upgraded := time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)
+	timeSource := gofakes3.FixedTimeSource(upgraded)
+	db, err := NewFile(file, WithTimeSource(timeSource))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.bolt.Close()
+
+	// The creation date recorded by the upgrade does not change with time:
+	timeSource.Advance(time.Hour)
+	buckets, err := db.ListBuckets()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(buckets) != 1 || buckets[0].Name != "legacy" || !buckets[0].CreationDate.Time.Equal(upgraded) {
+		t.Fatal("unexpected buckets:", buckets)
+	}
+
+	obj, err := db.HeadObject("legacy", "object")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if obj.Metadata["Content-Type"] != "text/plain" {
+		t.Fatal("unexpected metadata:", obj.Metadata)
+	}
+
+	if err := db.bolt.View(func(tx *bolt.Tx) error {
+		mb, err := db.metaBucket(tx)
+		if err != nil {
+			return err
+		}
+		if version, err := mb.schemaVersion(); err != nil || version != schemaVersion {
+			t.Fatal("schema version", version, "!=", schemaVersion, err)
+		}
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestEncodeObject(t *testing.T) {
+	info := boltObjectInfo{
+		Name:         "object",
+		Metadata:     map[string]string{"Content-Type": "text/csv"},
+		LastModified: time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC),
+		Size:         7,
+		Hash:         []byte{1, 2, 3},
+	}
+	expected, err := bson.Marshal(&boltObject{boltObjectInfo: info, Contents: []byte("a,b,c,d")})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	found, err := encodeObject(info, func(buf []byte) []byte {
+		return append(append(buf, "a,b,"...), "c,d"...)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(found, expected) {
+		t.Fatalf("%q != %q", found, expected)
+	}
+
+	if _, err := encodeObject(info, func(buf []byte) []byte { return append(buf, "a,b"...) }); err == nil {
+		t.Fatal("expected an error for contents shorter than the size")
+	}
+}
+
+func TestCompleteMultipart(t *testing.T) {
+	db, err := NewFile(filepath.Join(t.TempDir(), "bolt.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.bolt.Close()
+
+	if err := db.CreateBucket("bucket"); err != nil {
+		t.Fatal(err)
+	}
+	for partNumber, part := range []string{"a,b,", "c,d"} {
+		if err := db.PutMultipartPart("bucket", "upload", partNumber+1, bytes.NewReader([]byte(part)), int64(len(part))); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := db.CompleteMultipart("bucket", "object", "upload", []int{1, 2}, map[string]string{}, 7); err != nil {
+		t.Fatal(err)
+	}
+
+	obj, err := db.GetObject("bucket", "object", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer obj.Contents.Close()
+	contents, err := gofakes3.ReadAll(obj.Contents, obj.Size)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(contents) != "a,b,c,d" {
+		t.Fatal("contents", string(contents), "!=", "a,b,c,d")
+	}
+
+	if err := db.bolt.View(func(tx *bolt.Tx) error {
+		return tx.Bucket(db.multipartBucketName).ForEach(func(k, v []byte) error {
+			t.Errorf("part %q was not deleted", k)
+			return nil
+		})
+	}); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,126 @@
+package s3bolt
+
+import (
+	"bytes"
+	"crypto/md5"
+	"fmt"
+	"io"
+
+	"github.com/johannesboyne/gofakes3"
+	bolt "go.etcd.io/bbolt"
+)
+
+func multipartPrefix(bucketName string, uploadID gofakes3.UploadID) []byte {
+	return []byte(bucketName + "/" + string(uploadID) + "/")
+}
+
+func multipartKey(bucketName string, uploadID gofakes3.UploadID, partNumber int) []byte {
+	return append(multipartPrefix(bucketName, uploadID), fmt.Sprintf("%05d", partNumber)...)
+}
+
+// PutMultipartPart implements gofakes3.MultipartBackend. Parts are stored in
+// the database until the upload is completed or aborted, so they do not need
+// to be held in memory between requests.
+func (db *Backend) PutMultipartPart(bucketName string, uploadID gofakes3.UploadID, partNumber int, input io.Reader, size int64) error {
+	bts, err := gofakes3.ReadAll(input, size)
+	if err != nil {
+		return err
+	}
+
+	return db.bolt.Update(func(tx *bolt.Tx) error {
+		if tx.Bucket([]byte(bucketName)) == nil {
+			return gofakes3.BucketNotFound(bucketName)
+		}
+		parts, err := tx.CreateBucketIfNotExists(db.multipartBucketName)
+		if err != nil {
+			return err
+		}
+		return parts.Put(multipartKey(bucketName, uploadID, partNumber), bts)
+	})
+}
+
+// CompleteMultipart implements gofakes3.MultipartBackend. Objects are stored
+// as a single value, so the parts are copied once, straight into the encoded
+// value, which is put and the parts deleted in the same transaction.
+func (db *Backend) CompleteMultipart(bucketName, objectName string, uploadID gofakes3.UploadID, partNumbers []int, meta map[string]string, size int64) (result gofakes3.PutObjectResult, err error) {
+	err = gofakes3.MergeMetadata(db, bucketName, objectName, meta)
+	if err != nil {
+		return result, err
+	}
+
+	mod := db.timeSource.Now()
+
+	return result, db.bolt.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte(bucketName))
+		if b == nil {
+			return gofakes3.BucketNotFound(bucketName)
+		}
+		parts := tx.Bucket(db.multipartBucketName)
+		if parts == nil {
+			return gofakes3.ErrInvalidPart
+		}
+
+		// The values are only valid for the life of the transaction, which
+		// is long enough to copy them into the object:
+		contents := make([][]byte, 0, len(partNumbers))
+		hash := md5.New()
+		for _, partNumber := range partNumbers {
+			part := parts.Get(multipartKey(bucketName, uploadID, partNumber))
+			if part == nil {
+				return gofakes3.ErrInvalidPart
+			}
+			hash.Write(part)
+			contents = append(contents, part)
+		}
+
+		data, err := encodeObject(boltObjectInfo{
+			Name:         objectName,
+			Metadata:     meta,
+			Size:         size,
+			LastModified: mod,
+			Hash:         hash.Sum(nil),
+		}, func(buf []byte) []byte {
+			for _, part := range contents {
+				buf = append(buf, part...)
+			}
+			return buf
+		})
+		if err != nil {
+			return err
+		}
+		if err := b.Put([]byte(objectName), data); err != nil {
+			return err
+		}
+		return deleteParts(parts, bucketName, uploadID)
+	})
+}
+
+// AbortMultipart implements gofakes3.MultipartBackend.
+func (db *Backend) AbortMultipart(bucketName string, uploadID gofakes3.UploadID) error {
+	return db.bolt.Update(func(tx *bolt.Tx) error {
+		if tx.Bucket([]byte(bucketName)) == nil {
+			return gofakes3.BucketNotFound(bucketName)
+		}
+		parts := tx.Bucket(db.multipartBucketName)
+		if parts == nil {
+			return nil
+		}
+		return deleteParts(parts, bucketName, uploadID)
+	})
+}
+
+func deleteParts(parts *bolt.Bucket, bucketName string, uploadID gofakes3.UploadID) error {
+	// Keys can not be deleted while the cursor is iterating over them:
+	var keys [][]byte
+	prefix := multipartPrefix(bucketName, uploadID)
+	c := parts.Cursor()
+	for k, _ := c.Seek(prefix); k != nil && bytes.HasPrefix(k, prefix); k, _ = c.Next() {
+		keys = append(keys, append([]byte(nil), k...))
+	}
+	for _, k := range keys {
+		if err := parts.Delete(k); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -5,9 +5,18 @@ package s3bolt
 // change without notice or version number changes.
 //
 // This may change in the future.
+//
+// Each S3 bucket is a bolt bucket holding a boltObject for each of its keys,
+// which carries the object's metadata alongside its contents. The "_meta"
+// bolt bucket holds a boltBucket record for each S3 bucket, and the version
+// of the schema the database was last written with, so that databases
+// written by older versions of this package can be upgraded when opened.
 
 import (
 	"bytes"
+	"encoding/binary"
+	"fmt"
+	"math"
 	"time"
 
 	"github.com/johannesboyne/gofakes3"
@@ -16,19 +25,75 @@ import (
 	"gopkg.in/mgo.v2/bson"
 )
 
+// schemaVersion is the version of the schema written by this package:
+//
+//	0: buckets created before the "_meta" bucket existed have no boltBucket.
+//	1: every bucket has a boltBucket.
+const schemaVersion = 1
+
+var schemaKey = []byte("schema")
+
+type boltSchema struct {
+	Version int
+}
+
 type boltBucket struct {
 	CreationDate time.Time
 }
 
-type boltObject struct {
+// boltObjectInfo is everything stored for an object except its contents,
+// so that HEAD requests and listings need not copy the contents out of the
+// database.
+type boltObjectInfo struct {
 	Name         string
 	Metadata     map[string]string
 	LastModified time.Time
 	Size         int64
-	Contents     []byte
 	Hash         []byte
 }
 
+type boltObject struct {
+	boltObjectInfo `bson:",inline"`
+	Contents       []byte
+}
+
+// encodeObject returns the bson encoding of a boltObject with the given info,
+// which is what bson.Marshal would return, but with the contents appended to
+// the encoded value by write rather than copied in from a []byte. write must
+// append exactly info.Size bytes.
+//
+// bson.Marshal would need a copy of the contents of their own, which for a
+// multipart object would have to be assembled from its parts first.
+func encodeObject(info boltObjectInfo, write func(buf []byte) []byte) ([]byte, error) {
+	head, err := bson.Marshal(&info)
+	if err != nil {
+		return nil, err
+	}
+
+	// The contents are a binary element ("\x05", the name, the int32 length in
+	// bytes and the "\x00" subtype) that replaces the head's terminating
+	// "\x00":
+	const name = "contents"
+	size := len(head) + 1 + len(name) + 1 + 4 + 1 + int(info.Size)
+	if info.Size < 0 || int64(size) > math.MaxInt32 {
+		return nil, fmt.Errorf("s3bolt: object size %d is out of range", info.Size)
+	}
+
+	buf := make([]byte, 0, size)
+	buf = append(buf, head[:len(head)-1]...)
+	buf = append(buf, 0x05)
+	buf = append(buf, name...)
+	buf = append(buf, 0, 0, 0, 0, 0, 0)
+	binary.LittleEndian.PutUint32(buf[len(buf)-5:], uint32(info.Size))
+	buf = write(buf)
+	buf = append(buf, 0)
+	if len(buf) != size {
+		return nil, fmt.Errorf("s3bolt: object contents are %d bytes, expected %d", int64(len(buf)-size)+info.Size, info.Size)
+	}
+	binary.LittleEndian.PutUint32(buf, uint32(size))
+	return buf, nil
+}
+
 func (b *boltObject) Object(objectName string, rangeRequest *gofakes3.ObjectRangeRequest) (*gofakes3.Object, error) {
 	data := b.Contents
 
@@ -41,14 +106,22 @@ func (b *boltObject) Object(objectName string, rangeRequest *gofakes3.ObjectRang
 		data = data[rnge.Start : rnge.Start+rnge.Length]
 	}
 
+	obj := b.boltObjectInfo.Object(objectName)
+	obj.Contents = s3io.BytesReaderWithDummyCloser{Reader: bytes.NewReader(data)}
+	obj.Range = rnge
+	return obj, nil
+}
+
+// Object returns the object without its contents.
+func (b *boltObjectInfo) Object(objectName string) *gofakes3.Object {
 	return &gofakes3.Object{
-		Name:     objectName,
-		Metadata: b.Metadata,
-		Size:     b.Size,
-		Contents: s3io.ReaderWithDummyCloser{bytes.NewReader(data)},
-		Range:    rnge,
-		Hash:     b.Hash,
-	}, nil
+		Name:         objectName,
+		Metadata:     b.Metadata,
+		Size:         b.Size,
+		Contents:     s3io.NoOpReadCloser{},
+		Hash:         b.Hash,
+		LastModified: b.LastModified,
+	}
 }
 
 func bucketMetaKey(name string) []byte {
@@ -79,10 +152,13 @@ func (mb *metaBucket) createS3Bucket(bucket string, at time.Time) error {
 	return nil
 }
 
+// s3Bucket returns nil if there is no metadata for the bucket, which upgrade
+// relies on to find the buckets to record it for. Outside of upgrade, that
+// only happens in an older database that was opened read-only, or whose
+// upgrade failed, so was left as it was.
 func (mb *metaBucket) s3Bucket(bucket string) (*boltBucket, error) {
 	bts := mb.bucket.Get(bucketMetaKey(bucket))
 	if bts == nil {
-		// FIXME: should return an error once database upgrades are supported.
 		return nil, nil
 	}
 
@@ -92,3 +168,24 @@ func (mb *metaBucket) s3Bucket(bucket string) (*boltBucket, error) {
 	}
 	return &bb, nil
 }
+
+func (mb *metaBucket) schemaVersion() (int, error) {
+	bts := mb.bucket.Get(schemaKey)
+	if bts == nil {
+		return 0, nil
+	}
+
+	var bs boltSchema
+	if err := bson.Unmarshal(bts, &bs); err != nil {
+		return 0, err
+	}
+	return bs.Version, nil
+}
+
+func (mb *metaBucket) setSchemaVersion(version int) error {
+	data, err := bson.Marshal(&boltSchema{Version: version})
+	if err != nil {
+		return err
+	}
+	return mb.bucket.Put(schemaKey, data)
+}
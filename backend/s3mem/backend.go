@@ -1,10 +1,12 @@
 package s3mem
 
 import (
+	"container/list"
+	"context"
 	"crypto/md5"
-	"encoding/hex"
 	"io"
 	"sync"
+	"time"
 
 	"github.com/johannesboyne/gofakes3"
 	"github.com/johannesboyne/gofakes3/internal/goskipiter"
@@ -22,11 +24,35 @@ type Backend struct {
 	versionSeed      int64
 	versionSeedSet   bool
 	versionScratch   []byte
+	assignVersionID  func() string
+	persistFile      string
+	uploads          gofakes3.MultipartUploads
+	restoredUploads  []gofakes3.MultipartUploadState
+	objectTTL        time.Duration
+	memoryLimit      int64
+	memoryLimitMode  MemoryLimitMode
+	memory           *memoryUsage
+	stopReclaim      chan struct{}
+	closeOnce        sync.Once
 	lock             sync.RWMutex
 }
 
 var _ gofakes3.Backend = &Backend{}
 var _ gofakes3.VersionedBackend = &Backend{}
+var _ gofakes3.TaggingBackend = &Backend{}
+var _ gofakes3.BucketTaggingBackend = &Backend{}
+var _ gofakes3.ACLBackend = &Backend{}
+var _ gofakes3.ObjectLockBackend = &Backend{}
+var _ gofakes3.RestoreBackend = &Backend{}
+var _ gofakes3.LifecycleBackend = &Backend{}
+var _ gofakes3.CORSBackend = &Backend{}
+var _ gofakes3.PolicyBackend = &Backend{}
+var _ gofakes3.WebsiteBackend = &Backend{}
+var _ gofakes3.RequestPaymentBackend = &Backend{}
+var _ gofakes3.BucketConfigBackend = &Backend{}
+var _ gofakes3.ContextBackend = &Backend{}
+var _ gofakes3.MultipartBackend = &Backend{}
+var _ gofakes3.MultipartStateBackend = &Backend{}
 
 type Option func(b *Backend)
 
@@ -34,11 +60,50 @@ func WithTimeSource(timeSource gofakes3.TimeSource) Option {
 	return func(b *Backend) { b.timeSource = timeSource }
 }
 
+// WithObjectTTL makes every object version expire once ttl has passed since it
+// was put, according to the TimeSource passed to WithTimeSource. Expired
+// objects are treated as if they had been deleted, and the memory they use is
+// reclaimed in the background until Backend.Close() is called.
+//
+// This is intended for testing clients that should cope with objects
+// disappearing; use a lifecycle configuration for behaviour closer to S3's.
+func WithObjectTTL(ttl time.Duration) Option {
+	return func(b *Backend) { b.objectTTL = ttl }
+}
+
 func WithVersionSeed(seed int64) Option {
 	return func(b *Backend) { b.versionSeed = seed; b.versionSeedSet = true }
 }
 
+// WithVersionGenerator replaces the random version IDs given to new object
+// versions with the IDs returned by next, which must be unique, for example
+// to make the IDs in a test predictable:
+//
+//	var n int
+//	s3mem.New(s3mem.WithVersionGenerator(func() string {
+//		n++
+//		return fmt.Sprintf("v%d", n)
+//	}))
+//
+// next is called with the backend's lock held. The IDs need not sort in the
+// order they were generated. It is not called for the 'null' version stored
+// while versioning is suspended, and its state is not included in a Snapshot.
+func WithVersionGenerator(next func() string) Option {
+	return func(b *Backend) { b.assignVersionID = next }
+}
+
+// New creates an empty Backend, or one containing the snapshot passed to
+// WithPersistFile. New panics if the snapshot can not be loaded; use Open to
+// handle the error instead.
 func New(opts ...Option) *Backend {
+	b, err := Open(opts...)
+	if err != nil {
+		panic(err)
+	}
+	return b
+}
+
+func newBackend(opts ...Option) *Backend {
 	b := &Backend{
 		buckets: make(map[string]*bucket),
 	}
@@ -55,9 +120,50 @@ func New(opts ...Option) *Backend {
 			b.versionGenerator = newVersionGenerator(uint64(b.timeSource.Now().UnixNano()), 0)
 		}
 	}
+	b.memory = &memoryUsage{}
+	if b.memoryLimit > 0 && b.memoryLimitMode == MemoryLimitEvict {
+		b.memory.lru = list.New()
+	}
+	if b.objectTTL > 0 {
+		b.stopReclaim = make(chan struct{})
+		go b.runReclaimer()
+	}
 	return b
 }
 
+// runReclaimer periodically discards the objects that have expired since the
+// last run, until the backend is closed.
+func (db *Backend) runReclaimer() {
+	interval := db.objectTTL
+	if interval < time.Second {
+		interval = time.Second
+	} else if interval > time.Minute {
+		interval = time.Minute
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-db.stopReclaim:
+			return
+		case <-ticker.C:
+			db.reclaimExpired()
+		}
+	}
+}
+
+func (db *Backend) reclaimExpired() {
+	db.lock.Lock()
+	defer db.lock.Unlock()
+
+	now := db.timeSource.Now()
+	for _, bucket := range db.buckets {
+		bucket.reclaimExpired(now)
+	}
+}
+
 func (db *Backend) ListBuckets() ([]gofakes3.BucketInfo, error) {
 	db.lock.RLock()
 	defer db.lock.RUnlock()
@@ -90,44 +196,65 @@ func (db *Backend) ListBucket(name string, prefix *gofakes3.Prefix, page gofakes
 	var iter = goskipiter.New(storedBucket.objects.Iterator())
 	var match gofakes3.PrefixMatch
 
+	// Seek finds the first key at or after the marker; the marker itself is
+	// skipped below, as it may not be the name of an object:
 	if page.Marker != "" {
 		iter.Seek(page.Marker)
-		iter.Next() // Move to the next item after the Marker
 	}
 
 	var cnt int64 = 0
+	var now = db.timeSource.Now()
 
 	var lastMatchedPart string
 
 	for iter.Next() {
 		item := iter.Value().(*bucketObject)
 
-		if !prefix.Match(item.data.name, &match) {
+		if item.live(now) == nil {
+			continue
+		} else if page.Marker != "" && item.data.name <= page.Marker {
+			continue
+		} else if !prefix.Match(item.data.name, &match) {
 			continue
 		} else if item.data.deleteMarker {
 			continue
-		} else if match.CommonPrefix {
-			if match.MatchedPart == lastMatchedPart {
-				continue // Should not count towards keys
-			}
+		}
+
+		// Keys are sorted, so all the keys in a common prefix are listed
+		// together. The marker may be a common prefix returned as the
+		// NextMarker of a previous page, in which case the keys it rolls up
+		// must not be listed again:
+		if match.CommonPrefix && (match.MatchedPart == lastMatchedPart || match.MatchedPart <= page.Marker) {
+			continue // Should not count towards keys
+		}
+
+		// The page is only truncated if there is something left to list,
+		// which is not the case merely because there are more keys:
+		if page.MaxKeys > 0 && cnt >= page.MaxKeys {
+			response.IsTruncated = true
+			break
+		}
+
+		if match.CommonPrefix {
 			response.AddPrefix(match.MatchedPart)
 			lastMatchedPart = match.MatchedPart
+			response.NextMarker = match.MatchedPart
 
 		} else {
 			response.Add(&gofakes3.Content{
 				Key:          item.data.name,
 				LastModified: gofakes3.NewContentTime(item.data.lastModified),
-				ETag:         `"` + hex.EncodeToString(item.data.hash) + `"`,
+				ETag:         gofakes3.ObjectETag(item.data.hash, item.data.metadata),
+				StorageClass: gofakes3.ObjectStorageClass(item.data.metadata),
 				Size:         int64(len(item.data.body)),
 			})
-		}
-
-		cnt++
-		if page.MaxKeys > 0 && cnt >= page.MaxKeys {
 			response.NextMarker = item.data.name
-			response.IsTruncated = iter.Next()
-			break
 		}
+		cnt++
+	}
+
+	if !response.IsTruncated {
+		response.NextMarker = ""
 	}
 
 	return response, nil
@@ -141,7 +268,7 @@ func (db *Backend) CreateBucket(name string) error {
 		return gofakes3.ResourceError(gofakes3.ErrBucketAlreadyExists, name)
 	}
 
-	db.buckets[name] = newBucket(name, db.timeSource.Now(), db.nextVersion)
+	db.buckets[name] = newBucket(name, db.timeSource.Now(), db.nextVersion, db.nextAssignedVersion, db.memory)
 	return nil
 }
 
@@ -153,10 +280,15 @@ func (db *Backend) DeleteBucket(name string) error {
 		return gofakes3.ErrNoSuchBucket
 	}
 
+	// Expired objects should not prevent the bucket from being deleted:
+	db.buckets[name].reclaimExpired(db.timeSource.Now())
 	if db.buckets[name].objects.Len() > 0 {
 		return gofakes3.ResourceError(gofakes3.ErrBucketNotEmpty, name)
 	}
 
+	for uploadID := range db.buckets[name].parts {
+		db.buckets[name].dropParts(uploadID)
+	}
 	delete(db.buckets, name)
 
 	return nil
@@ -178,14 +310,27 @@ func (db *Backend) HeadObject(bucketName, objectName string) (*gofakes3.Object,
 	}
 
 	obj := bucket.object(objectName)
-	if obj == nil || obj.data.deleteMarker {
+	if obj == nil || obj.live(db.timeSource.Now()) == nil || obj.data.deleteMarker {
 		return nil, gofakes3.KeyNotFound(objectName)
 	}
 
-	return obj.data.toObject(nil, false)
+	result, err := obj.data.toObject(nil, false)
+	if err != nil {
+		return nil, err
+	}
+	if bucket.versioning == gofakes3.VersioningNone {
+		result.VersionID = ""
+	}
+	return result, nil
 }
 
 func (db *Backend) GetObject(bucketName, objectName string, rangeRequest *gofakes3.ObjectRangeRequest) (*gofakes3.Object, error) {
+	return db.GetObjectContext(context.Background(), bucketName, objectName, rangeRequest)
+}
+
+// GetObjectContext implements gofakes3.ContextBackend; reading the Contents
+// of the returned Object fails once ctx is cancelled.
+func (db *Backend) GetObjectContext(ctx context.Context, bucketName, objectName string, rangeRequest *gofakes3.ObjectRangeRequest) (*gofakes3.Object, error) {
 	db.lock.RLock()
 	defer db.lock.RUnlock()
 
@@ -195,7 +340,7 @@ func (db *Backend) GetObject(bucketName, objectName string, rangeRequest *gofake
 	}
 
 	obj := bucket.object(objectName)
-	if obj == nil || obj.data.deleteMarker {
+	if obj == nil || obj.live(db.timeSource.Now()) == nil || obj.data.deleteMarker {
 		// FIXME: If the current version of the object is a delete marker,
 		// Amazon S3 behaves as if the object was deleted and includes
 		// x-amz-delete-marker: true in the response.
@@ -205,27 +350,42 @@ func (db *Backend) GetObject(bucketName, objectName string, rangeRequest *gofake
 		return nil, gofakes3.KeyNotFound(objectName)
 	}
 
+	db.memory.used(obj.data)
 	result, err := obj.data.toObject(rangeRequest, true)
 	if err != nil {
 		return nil, err
 	}
 
-	if bucket.versioning != gofakes3.VersioningEnabled {
+	if bucket.versioning == gofakes3.VersioningNone {
 		result.VersionID = ""
 	}
+	result.Contents = gofakes3.ContextReadCloser(ctx, result.Contents)
 
 	return result, nil
 }
 
 func (db *Backend) PutObject(bucketName, objectName string, meta map[string]string, input io.Reader, size int64) (result gofakes3.PutObjectResult, err error) {
+	return db.PutObjectContext(context.Background(), bucketName, objectName, meta, input, size)
+}
+
+// PutObjectContext implements gofakes3.ContextBackend; it stops reading input
+// once ctx is cancelled.
+func (db *Backend) PutObjectContext(ctx context.Context, bucketName, objectName string, meta map[string]string, input io.Reader, size int64) (result gofakes3.PutObjectResult, err error) {
 	// No need to lock the backend while we read the data into memory; it holds
 	// the write lock open unnecessarily, and could be blocked for an unreasonably
 	// long time by a connection timing out:
-	bts, err := gofakes3.ReadAll(input, size)
+	bts, err := gofakes3.ReadAllContext(ctx, input, size)
 	if err != nil {
 		return result, err
 	}
+	return db.putObject(bucketName, objectName, meta, bts, "")
+}
 
+// putObject stores bts as the contents of the object. If uploadID is not
+// empty, bts was assembled from the parts of that multipart upload, which are
+// discarded in the same step; the memory they use counts as freed when
+// making room for bts.
+func (db *Backend) putObject(bucketName, objectName string, meta map[string]string, bts []byte, uploadID gofakes3.UploadID) (result gofakes3.PutObjectResult, err error) {
 	err = gofakes3.MergeMetadata(db, bucketName, objectName, meta)
 	if err != nil {
 		return result, err
@@ -240,21 +400,31 @@ func (db *Backend) PutObject(bucketName, objectName string, meta map[string]stri
 	}
 
 	hash := md5.Sum(bts)
+	now := db.timeSource.Now()
 
 	item := &bucketData{
 		name:         objectName,
 		body:         bts,
 		hash:         hash[:],
-		etag:         `"` + hex.EncodeToString(hash[:]) + `"`,
+		etag:         gofakes3.ObjectETag(hash[:], meta),
 		metadata:     meta,
-		lastModified: db.timeSource.Now(),
+		lastModified: now,
+	}
+	if db.objectTTL > 0 {
+		item.expires = now.Add(db.objectTTL)
 	}
 
+	if err := db.reserve(int64(len(bts)), func() int64 { return bucket.replaced(objectName) + bucket.partsSize(uploadID) }); err != nil {
+		return result, err
+	}
 	bucket.put(objectName, item)
+	if uploadID != "" {
+		bucket.dropParts(uploadID)
+	}
 
-	if bucket.versioning == gofakes3.VersioningEnabled {
+	if bucket.versioning != gofakes3.VersioningNone {
 		// versionID is assigned in bucket.put()
-		result.VersionID = item.versionID
+		result.VersionID = item.id()
 	}
 
 	return result, nil
@@ -292,6 +462,7 @@ func (db *Backend) DeleteMulti(bucketName string, objects ...string) (result gof
 			if errres.Code == gofakes3.ErrInternal {
 				// FIXME: log
 			}
+			errres.Key = object
 
 			result.Error = append(result.Error, errres)
 
@@ -331,6 +502,7 @@ func (db *Backend) DeleteMultiVersions(bucketName string, objects ...gofakes3.Ob
 			if errres.Code == gofakes3.ErrInternal {
 				// FIXME: log
 			}
+			errres.Key, errres.VersionID = object.Key, object.VersionID
 
 			result.Error = append(result.Error, errres)
 
@@ -352,15 +524,12 @@ func (db *Backend) VersioningConfiguration(bucketName string) (versioning gofake
 	}
 
 	versioning.Status = bucket.versioning
+	versioning.MFADelete = bucket.mfaDelete
 
 	return versioning, nil
 }
 
 func (db *Backend) SetVersioningConfiguration(bucketName string, v gofakes3.VersioningConfiguration) error {
-	if v.MFADelete.Enabled() {
-		return gofakes3.ErrNotImplemented
-	}
-
 	db.lock.Lock()
 	defer db.lock.Unlock()
 
@@ -369,7 +538,14 @@ func (db *Backend) SetVersioningConfiguration(bucketName string, v gofakes3.Vers
 		return gofakes3.BucketNotFound(bucketName)
 	}
 
-	bucket.setVersioning(v.Enabled())
+	// Either part of the configuration may be left out to leave it as it
+	// was:
+	if v.Status != gofakes3.VersioningNone {
+		bucket.setVersioning(v.Enabled())
+	}
+	if v.MFADelete != gofakes3.MFADeleteNone {
+		bucket.mfaDelete = v.MFADelete
+	}
 
 	return nil
 }
@@ -393,8 +569,11 @@ func (db *Backend) GetObjectVersion(
 	ver, err := bucket.objectVersion(objectName, versionID)
 	if err != nil {
 		return nil, err
+	} else if ver.expired(db.timeSource.Now()) {
+		return nil, gofakes3.ErrNoSuchVersion
 	}
 
+	db.memory.used(ver)
 	return ver.toObject(rangeRequest, true)
 }
 
@@ -414,6 +593,8 @@ func (db *Backend) HeadObjectVersion(bucketName, objectName string, versionID go
 	ver, err := bucket.objectVersion(objectName, versionID)
 	if err != nil {
 		return nil, err
+	} else if ver.expired(db.timeSource.Now()) {
+		return nil, gofakes3.ErrNoSuchVersion
 	}
 
 	return ver.toObject(nil, false)
@@ -455,98 +636,606 @@ func (db *Backend) ListBucketVersions(
 
 	var iter = goskipiter.New(bucket.objects.Iterator())
 	var match gofakes3.PrefixMatch
+	var now = db.timeSource.Now()
+	var cnt int64
 
-	if page.KeyMarker != "" {
-		if !prefix.Match(page.KeyMarker, &match) {
-			// FIXME: NO idea what S3 would do here.
-			return result, gofakes3.ErrInternal
-		}
+	if page.HasKeyMarker {
 		iter.Seek(page.KeyMarker)
 	}
 
-	var truncated = false
-	var first = true
-	var cnt int64 = 0
-
-	// FIXME: The S3 docs have this to say on the topic of result ordering:
-	//   "The following request returns objects in the order they were stored,
-	//   returning the most recently stored object first starting with the value
-	//   for key-marker."
-	//
-	// OK so this method....
-	// - Returns objects in the order they were stored
-	// - Returning the most recently stored object first
-	//
-	// This makes no sense at all!
+	// full reports whether max-keys has been reached, in which case the
+	// listing is truncated as there is at least one more item to return:
+	full := func() bool {
+		if page.MaxKeys > 0 && cnt >= page.MaxKeys {
+			result.IsTruncated = true
+			return true
+		}
+		cnt++
+		return false
+	}
 
+	// Versions are listed by key, and then newest first. The key marker
+	// itself is only listed if there is a version ID marker, starting with the
+	// version after it:
 	for iter.Next() {
 		object := iter.Value().(*bucketObject)
 
+		if page.HasKeyMarker && (object.name < page.KeyMarker ||
+			(object.name == page.KeyMarker && !page.HasVersionIDMarker)) {
+			continue
+		}
 		if !prefix.Match(object.name, &match) {
 			continue
 		}
 
 		if match.CommonPrefix {
+			// The common prefix was already listed if it sorts before the
+			// marker, which may be the NextKeyMarker of a previous page:
+			if result.HasPrefix(match.MatchedPart) || (page.HasKeyMarker && match.MatchedPart <= page.KeyMarker) {
+				continue
+			}
+			if full() {
+				break
+			}
 			result.AddPrefix(match.MatchedPart)
+			result.NextKeyMarker, result.NextVersionIDMarker = match.MatchedPart, ""
 			continue
 		}
 
-		versions := iter.Value().(*bucketObject).Iterator()
-		if first {
-			if page.VersionIDMarker != "" {
-				if !versions.Seek(page.VersionIDMarker) {
-					// FIXME: log
-					return result, gofakes3.ErrInternal
-				}
+		// The IDs reported to clients do not necessarily sort, so the version
+		// ID marker is compared using the generated versionID behind it:
+		markerID := page.VersionIDMarker
+		if object.name == page.KeyMarker && page.HasVersionIDMarker {
+			if version := object.version(markerID); version != nil {
+				markerID = version.versionID
 			}
-			first = false
 		}
 
-		for versions.Next() {
-			version := versions.Value()
+		for _, version := range object.newestFirst() {
+			versionID := version.id()
+			if bucket.versioning == gofakes3.VersioningNone { // S300005
+				versionID = ""
+			}
+
+			if object.name == page.KeyMarker && page.HasVersionIDMarker &&
+				(versionID == "" || version.versionID >= markerID) {
+				continue
+			}
+			if version.expired(now) {
+				continue
+			}
+			if full() {
+				goto done
+			}
 
 			if version.deleteMarker {
-				marker := &gofakes3.DeleteMarker{
+				result.Versions = append(result.Versions, &gofakes3.DeleteMarker{
 					Key:          version.name,
+					VersionID:    versionID,
 					IsLatest:     version == object.data,
 					LastModified: gofakes3.NewContentTime(version.lastModified),
-				}
-				if bucket.versioning != gofakes3.VersioningNone { // S300005
-					marker.VersionID = version.versionID
-				}
-				result.Versions = append(result.Versions, marker)
-
+				})
 			} else {
-				resultVer := &gofakes3.Version{
+				result.Versions = append(result.Versions, &gofakes3.Version{
 					Key:          version.name,
+					VersionID:    versionID,
 					IsLatest:     version == object.data,
 					LastModified: gofakes3.NewContentTime(version.lastModified),
 					Size:         int64(len(version.body)),
+					StorageClass: gofakes3.ObjectStorageClass(version.metadata),
 					ETag:         version.etag,
-				}
-				if bucket.versioning != gofakes3.VersioningNone { // S300005
-					resultVer.VersionID = version.versionID
-				}
-				result.Versions = append(result.Versions, resultVer)
-			}
-
-			cnt++
-			if page.MaxKeys > 0 && cnt >= page.MaxKeys {
-				truncated = versions.Next()
-				goto done
+				})
 			}
+			result.NextKeyMarker, result.NextVersionIDMarker = version.name, versionID
 		}
 	}
 
 done:
-	result.IsTruncated = truncated || iter.Next()
+	if !result.IsTruncated {
+		result.NextKeyMarker, result.NextVersionIDMarker = "", ""
+	}
 
 	return result, nil
 }
 
+func (db *Backend) GetObjectTagging(bucketName, objectName string, versionID gofakes3.VersionID) (map[string]string, error) {
+	db.lock.RLock()
+	defer db.lock.RUnlock()
+
+	item, err := db.taggableObjectLocked(bucketName, objectName, versionID)
+	if err != nil {
+		return nil, err
+	}
+
+	tags := make(map[string]string, len(item.tags))
+	for k, v := range item.tags {
+		tags[k] = v
+	}
+	return tags, nil
+}
+
+func (db *Backend) PutObjectTagging(bucketName, objectName string, versionID gofakes3.VersionID, tags map[string]string) error {
+	db.lock.Lock()
+	defer db.lock.Unlock()
+
+	item, err := db.taggableObjectLocked(bucketName, objectName, versionID)
+	if err != nil {
+		return err
+	}
+
+	item.tags = make(map[string]string, len(tags))
+	for k, v := range tags {
+		item.tags[k] = v
+	}
+	return nil
+}
+
+func (db *Backend) DeleteObjectTagging(bucketName, objectName string, versionID gofakes3.VersionID) error {
+	db.lock.Lock()
+	defer db.lock.Unlock()
+
+	item, err := db.taggableObjectLocked(bucketName, objectName, versionID)
+	if err != nil {
+		return err
+	}
+
+	item.tags = nil
+	return nil
+}
+
+func (db *Backend) GetObjectRetention(bucketName, objectName string, versionID gofakes3.VersionID) (*gofakes3.ObjectRetention, error) {
+	db.lock.RLock()
+	defer db.lock.RUnlock()
+
+	item, err := db.lockableObjectLocked(bucketName, objectName, versionID)
+	if err != nil || item.retention == nil {
+		return nil, err
+	}
+
+	retention := *item.retention
+	return &retention, nil
+}
+
+func (db *Backend) PutObjectRetention(bucketName, objectName string, versionID gofakes3.VersionID, retention gofakes3.ObjectRetention) error {
+	db.lock.Lock()
+	defer db.lock.Unlock()
+
+	item, err := db.lockableObjectLocked(bucketName, objectName, versionID)
+	if err != nil {
+		return err
+	}
+
+	item.retention = &retention
+	return nil
+}
+
+func (db *Backend) GetObjectLegalHold(bucketName, objectName string, versionID gofakes3.VersionID) (gofakes3.ObjectLockLegalHoldStatus, error) {
+	db.lock.RLock()
+	defer db.lock.RUnlock()
+
+	item, err := db.lockableObjectLocked(bucketName, objectName, versionID)
+	if err != nil {
+		return "", err
+	}
+	return item.legalHold, nil
+}
+
+func (db *Backend) PutObjectLegalHold(bucketName, objectName string, versionID gofakes3.VersionID, status gofakes3.ObjectLockLegalHoldStatus) error {
+	db.lock.Lock()
+	defer db.lock.Unlock()
+
+	item, err := db.lockableObjectLocked(bucketName, objectName, versionID)
+	if err != nil {
+		return err
+	}
+
+	item.legalHold = status
+	return nil
+}
+
+func (db *Backend) GetObjectRestore(bucketName, objectName string, versionID gofakes3.VersionID) (*gofakes3.ObjectRestore, error) {
+	db.lock.RLock()
+	defer db.lock.RUnlock()
+
+	item, err := db.lockableObjectLocked(bucketName, objectName, versionID)
+	if err != nil || item.restore == nil {
+		return nil, err
+	}
+
+	restore := *item.restore
+	return &restore, nil
+}
+
+func (db *Backend) PutObjectRestore(bucketName, objectName string, versionID gofakes3.VersionID, restore gofakes3.ObjectRestore) error {
+	db.lock.Lock()
+	defer db.lock.Unlock()
+
+	item, err := db.lockableObjectLocked(bucketName, objectName, versionID)
+	if err != nil {
+		return err
+	}
+
+	item.restore = &restore
+	return nil
+}
+
+func (db *Backend) BucketWebsite(bucketName string) (*gofakes3.WebsiteConfiguration, error) {
+	db.lock.RLock()
+	defer db.lock.RUnlock()
+
+	bucket := db.buckets[bucketName]
+	if bucket == nil {
+		return nil, gofakes3.BucketNotFound(bucketName)
+	}
+	if bucket.website == nil {
+		return nil, nil
+	}
+
+	config := *bucket.website
+	config.RoutingRules = append([]gofakes3.WebsiteRoutingRule(nil), config.RoutingRules...)
+	return &config, nil
+}
+
+func (db *Backend) SetBucketWebsite(bucketName string, config gofakes3.WebsiteConfiguration) error {
+	db.lock.Lock()
+	defer db.lock.Unlock()
+
+	bucket := db.buckets[bucketName]
+	if bucket == nil {
+		return gofakes3.BucketNotFound(bucketName)
+	}
+
+	config.RoutingRules = append([]gofakes3.WebsiteRoutingRule(nil), config.RoutingRules...)
+	bucket.website = &config
+	return nil
+}
+
+func (db *Backend) DeleteBucketWebsite(bucketName string) error {
+	db.lock.Lock()
+	defer db.lock.Unlock()
+
+	bucket := db.buckets[bucketName]
+	if bucket == nil {
+		return gofakes3.BucketNotFound(bucketName)
+	}
+
+	bucket.website = nil
+	return nil
+}
+
+func (db *Backend) BucketRequestPayment(bucketName string) (gofakes3.Payer, error) {
+	db.lock.RLock()
+	defer db.lock.RUnlock()
+
+	bucket := db.buckets[bucketName]
+	if bucket == nil {
+		return "", gofakes3.BucketNotFound(bucketName)
+	}
+	if bucket.payer == "" {
+		return gofakes3.PayerBucketOwner, nil
+	}
+	return bucket.payer, nil
+}
+
+func (db *Backend) SetBucketRequestPayment(bucketName string, payer gofakes3.Payer) error {
+	db.lock.Lock()
+	defer db.lock.Unlock()
+
+	bucket := db.buckets[bucketName]
+	if bucket == nil {
+		return gofakes3.BucketNotFound(bucketName)
+	}
+
+	bucket.payer = payer
+	return nil
+}
+
+func (db *Backend) BucketConfig(bucketName, subresource string) ([]byte, error) {
+	db.lock.RLock()
+	defer db.lock.RUnlock()
+
+	bucket := db.buckets[bucketName]
+	if bucket == nil {
+		return nil, gofakes3.BucketNotFound(bucketName)
+	}
+
+	config, ok := bucket.configs[subresource]
+	if !ok {
+		return nil, nil
+	}
+	return append([]byte(nil), config...), nil
+}
+
+func (db *Backend) SetBucketConfig(bucketName, subresource string, config []byte) error {
+	db.lock.Lock()
+	defer db.lock.Unlock()
+
+	bucket := db.buckets[bucketName]
+	if bucket == nil {
+		return gofakes3.BucketNotFound(bucketName)
+	}
+
+	if config == nil {
+		delete(bucket.configs, subresource)
+		return nil
+	}
+	if bucket.configs == nil {
+		bucket.configs = map[string][]byte{}
+	}
+	bucket.configs[subresource] = append([]byte(nil), config...)
+	return nil
+}
+
+func (db *Backend) BucketPolicy(bucketName string) ([]byte, error) {
+	db.lock.RLock()
+	defer db.lock.RUnlock()
+
+	bucket := db.buckets[bucketName]
+	if bucket == nil {
+		return nil, gofakes3.BucketNotFound(bucketName)
+	}
+	if bucket.policy == nil {
+		return nil, nil
+	}
+	return append([]byte(nil), bucket.policy...), nil
+}
+
+func (db *Backend) SetBucketPolicy(bucketName string, policy []byte) error {
+	db.lock.Lock()
+	defer db.lock.Unlock()
+
+	bucket := db.buckets[bucketName]
+	if bucket == nil {
+		return gofakes3.BucketNotFound(bucketName)
+	}
+
+	bucket.policy = append([]byte(nil), policy...)
+	return nil
+}
+
+func (db *Backend) DeleteBucketPolicy(bucketName string) error {
+	db.lock.Lock()
+	defer db.lock.Unlock()
+
+	bucket := db.buckets[bucketName]
+	if bucket == nil {
+		return gofakes3.BucketNotFound(bucketName)
+	}
+
+	bucket.policy = nil
+	return nil
+}
+
+func (db *Backend) BucketTagging(bucketName string) (map[string]string, error) {
+	db.lock.RLock()
+	defer db.lock.RUnlock()
+
+	bucket := db.buckets[bucketName]
+	if bucket == nil {
+		return nil, gofakes3.BucketNotFound(bucketName)
+	}
+
+	tags := make(map[string]string, len(bucket.tags))
+	for k, v := range bucket.tags {
+		tags[k] = v
+	}
+	return tags, nil
+}
+
+func (db *Backend) SetBucketTagging(bucketName string, tags map[string]string) error {
+	db.lock.Lock()
+	defer db.lock.Unlock()
+
+	bucket := db.buckets[bucketName]
+	if bucket == nil {
+		return gofakes3.BucketNotFound(bucketName)
+	}
+
+	bucket.tags = make(map[string]string, len(tags))
+	for k, v := range tags {
+		bucket.tags[k] = v
+	}
+	return nil
+}
+
+func (db *Backend) DeleteBucketTagging(bucketName string) error {
+	db.lock.Lock()
+	defer db.lock.Unlock()
+
+	bucket := db.buckets[bucketName]
+	if bucket == nil {
+		return gofakes3.BucketNotFound(bucketName)
+	}
+
+	bucket.tags = nil
+	return nil
+}
+
+func (db *Backend) BucketACL(bucketName string) (*gofakes3.AccessControlPolicy, error) {
+	db.lock.RLock()
+	defer db.lock.RUnlock()
+
+	bucket := db.buckets[bucketName]
+	if bucket == nil {
+		return nil, gofakes3.BucketNotFound(bucketName)
+	}
+	return copyACL(bucket.acl), nil
+}
+
+func (db *Backend) SetBucketACL(bucketName string, acl gofakes3.AccessControlPolicy) error {
+	db.lock.Lock()
+	defer db.lock.Unlock()
+
+	bucket := db.buckets[bucketName]
+	if bucket == nil {
+		return gofakes3.BucketNotFound(bucketName)
+	}
+	bucket.acl = copyACL(&acl)
+	return nil
+}
+
+func (db *Backend) ObjectACL(bucketName, objectName string, versionID gofakes3.VersionID) (*gofakes3.AccessControlPolicy, error) {
+	db.lock.RLock()
+	defer db.lock.RUnlock()
+
+	item, err := db.lockableObjectLocked(bucketName, objectName, versionID)
+	if err != nil {
+		return nil, err
+	}
+	return copyACL(item.acl), nil
+}
+
+func (db *Backend) SetObjectACL(bucketName, objectName string, versionID gofakes3.VersionID, acl gofakes3.AccessControlPolicy) error {
+	db.lock.Lock()
+	defer db.lock.Unlock()
+
+	item, err := db.lockableObjectLocked(bucketName, objectName, versionID)
+	if err != nil {
+		return err
+	}
+	item.acl = copyACL(&acl)
+	return nil
+}
+
+func copyACL(acl *gofakes3.AccessControlPolicy) *gofakes3.AccessControlPolicy {
+	if acl == nil {
+		return nil
+	}
+	out := *acl
+	if acl.Owner != nil {
+		owner := *acl.Owner
+		out.Owner = &owner
+	}
+	out.Grants = append([]gofakes3.Grant(nil), acl.Grants...)
+	return &out
+}
+
+func (db *Backend) BucketCORS(bucketName string) (*gofakes3.CORSConfiguration, error) {
+	db.lock.RLock()
+	defer db.lock.RUnlock()
+
+	bucket := db.buckets[bucketName]
+	if bucket == nil {
+		return nil, gofakes3.BucketNotFound(bucketName)
+	}
+	if bucket.cors == nil {
+		return nil, nil
+	}
+
+	config := *bucket.cors
+	config.Rules = append([]gofakes3.CORSRule(nil), config.Rules...)
+	return &config, nil
+}
+
+func (db *Backend) SetBucketCORS(bucketName string, config gofakes3.CORSConfiguration) error {
+	db.lock.Lock()
+	defer db.lock.Unlock()
+
+	bucket := db.buckets[bucketName]
+	if bucket == nil {
+		return gofakes3.BucketNotFound(bucketName)
+	}
+
+	config.Rules = append([]gofakes3.CORSRule(nil), config.Rules...)
+	bucket.cors = &config
+	return nil
+}
+
+func (db *Backend) DeleteBucketCORS(bucketName string) error {
+	db.lock.Lock()
+	defer db.lock.Unlock()
+
+	bucket := db.buckets[bucketName]
+	if bucket == nil {
+		return gofakes3.BucketNotFound(bucketName)
+	}
+
+	bucket.cors = nil
+	return nil
+}
+
+func (db *Backend) BucketLifecycleConfiguration(bucketName string) (*gofakes3.LifecycleConfiguration, error) {
+	db.lock.RLock()
+	defer db.lock.RUnlock()
+
+	bucket := db.buckets[bucketName]
+	if bucket == nil {
+		return nil, gofakes3.BucketNotFound(bucketName)
+	}
+	if bucket.lifecycle == nil {
+		return nil, nil
+	}
+
+	config := *bucket.lifecycle
+	config.Rules = append([]gofakes3.LifecycleRule(nil), config.Rules...)
+	return &config, nil
+}
+
+func (db *Backend) SetBucketLifecycleConfiguration(bucketName string, config gofakes3.LifecycleConfiguration) error {
+	db.lock.Lock()
+	defer db.lock.Unlock()
+
+	bucket := db.buckets[bucketName]
+	if bucket == nil {
+		return gofakes3.BucketNotFound(bucketName)
+	}
+
+	config.Rules = append([]gofakes3.LifecycleRule(nil), config.Rules...)
+	bucket.lifecycle = &config
+	return nil
+}
+
+func (db *Backend) DeleteBucketLifecycleConfiguration(bucketName string) error {
+	db.lock.Lock()
+	defer db.lock.Unlock()
+
+	bucket := db.buckets[bucketName]
+	if bucket == nil {
+		return gofakes3.BucketNotFound(bucketName)
+	}
+
+	bucket.lifecycle = nil
+	return nil
+}
+
+// lockableObjectLocked returns the requested version of an object, or the
+// latest version if versionID is empty. It assumes the backend's lock is
+// acquired.
+func (db *Backend) lockableObjectLocked(bucketName, objectName string, versionID gofakes3.VersionID) (*bucketData, error) {
+	bucket := db.buckets[bucketName]
+	if bucket == nil {
+		return nil, gofakes3.BucketNotFound(bucketName)
+	}
+	return bucket.objectVersion(objectName, versionID)
+}
+
+// taggableObjectLocked returns a version of an object, or the latest if
+// versionID is empty, provided it is not a delete marker. It assumes the
+// backend's lock is acquired.
+func (db *Backend) taggableObjectLocked(bucketName, objectName string, versionID gofakes3.VersionID) (*bucketData, error) {
+	item, err := db.lockableObjectLocked(bucketName, objectName, versionID)
+	if err != nil {
+		return nil, err
+	}
+	if item.deleteMarker {
+		return nil, gofakes3.ErrMethodNotAllowed
+	}
+	return item, nil
+}
+
 // nextVersion assumes the backend's lock is acquired
 func (db *Backend) nextVersion() gofakes3.VersionID {
 	v, scr := db.versionGenerator.Next(db.versionScratch)
 	db.versionScratch = scr
 	return v
 }
+
+// nextAssignedVersion returns the ID from the function passed to
+// WithVersionGenerator, or the empty string if there isn't one. It assumes the
+// backend's lock is acquired.
+func (db *Backend) nextAssignedVersion() gofakes3.VersionID {
+	if db.assignVersionID == nil {
+		return ""
+	}
+	return gofakes3.VersionID(db.assignVersionID())
+}
@@ -0,0 +1,109 @@
+package s3mem
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/johannesboyne/gofakes3"
+	"github.com/johannesboyne/gofakes3/gofakes3test"
+)
+
+func TestConformance(t *testing.T) {
+	timeSource := gofakes3.FixedTimeSource(time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC))
+	gofakes3test.RunBackendConformance(t, func(t *testing.T) gofakes3.Backend {
+		db := New(WithTimeSource(timeSource))
+		t.Cleanup(func() { db.Close() })
+		return db
+	}, gofakes3test.WithTimeSource(timeSource))
+}
+
+func TestListBucketCommonPrefixes(t *testing.T) {
+	keys := []string{
+		"a",
+		"a/",
+		"a/b/c",
+		"a/b/d",
+		"a/b/e/f",
+		"a/c",
+		"a--b--c",
+		"a--b--d",
+		"b/c",
+	}
+
+	db := New()
+	defer db.Close()
+	if err := db.CreateBucket("bucket"); err != nil {
+		t.Fatal(err)
+	}
+	for _, key := range keys {
+		putString(t, db, "bucket", key, "body")
+	}
+
+	for _, tc := range []struct {
+		name     string
+		prefix   string
+		delim    string
+		contents []string
+		prefixes []string
+	}{
+		{name: "empty prefix", prefix: "", delim: "/",
+			contents: []string{"a", "a--b--c", "a--b--d"},
+			prefixes: []string{"a/", "b/"}},
+		{name: "prefix without trailing delimiter", prefix: "a", delim: "/",
+			contents: []string{"a", "a--b--c", "a--b--d"},
+			prefixes: []string{"a/"}},
+		{name: "trailing-slash prefix", prefix: "a/", delim: "/",
+			contents: []string{"a/", "a/c"},
+			prefixes: []string{"a/b/"}},
+		{name: "nested prefix", prefix: "a/b/", delim: "/",
+			contents: []string{"a/b/c", "a/b/d"},
+			prefixes: []string{"a/b/e/"}},
+		{name: "partial segment", prefix: "a/b/e", delim: "/",
+			prefixes: []string{"a/b/e/"}},
+		{name: "exact key", prefix: "a/b/c", delim: "/",
+			contents: []string{"a/b/c"}},
+		{name: "multi-segment delimiter", prefix: "", delim: "--",
+			contents: []string{"a", "a/", "a/b/c", "a/b/d", "a/b/e/f", "a/c", "b/c"},
+			prefixes: []string{"a--"}},
+		{name: "multi-segment delimiter with prefix", prefix: "a--", delim: "--",
+			prefixes: []string{"a--b--"}},
+		{name: "prefix ending inside delimiter", prefix: "a-", delim: "--",
+			prefixes: []string{"a--b--"}},
+		{name: "no match", prefix: "nope", delim: "/"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			prefix := gofakes3.NewPrefix(&tc.prefix, &tc.delim)
+
+			// Each page holds a single key or common prefix, which must
+			// still add up to the full listing:
+			for _, maxKeys := range []int64{0, 1} {
+				var contents, prefixes []string
+				var page = gofakes3.ListBucketPage{MaxKeys: maxKeys}
+				for {
+					list, err := db.ListBucket("bucket", &prefix, page)
+					if err != nil {
+						t.Fatal(err)
+					}
+					for _, v := range list.Contents {
+						contents = append(contents, v.Key)
+					}
+					for _, v := range list.CommonPrefixes {
+						prefixes = append(prefixes, v.Prefix)
+					}
+					if !list.IsTruncated {
+						break
+					}
+					page.HasMarker, page.Marker = true, list.NextMarker
+				}
+
+				if !reflect.DeepEqual(contents, tc.contents) {
+					t.Fatalf("max keys %d: contents:\nexp: %q\ngot: %q", maxKeys, tc.contents, contents)
+				}
+				if !reflect.DeepEqual(prefixes, tc.prefixes) {
+					t.Fatalf("max keys %d: prefixes:\nexp: %q\ngot: %q", maxKeys, tc.prefixes, prefixes)
+				}
+			}
+		})
+	}
+}
@@ -2,6 +2,7 @@ package s3mem
 
 import (
 	"bytes"
+	"container/list"
 	"io"
 	"time"
 
@@ -12,23 +13,44 @@ import (
 
 type versionGenFunc func() gofakes3.VersionID
 
+// nullVersionID is the version ID S3 reports for the null version of an
+// object, which is the version stored while versioning was not enabled.
+const nullVersionID gofakes3.VersionID = "null"
+
 type versioningStatus int
 
 type bucket struct {
 	name         string
 	versioning   gofakes3.VersioningStatus
+	mfaDelete    gofakes3.MFADeleteStatus
 	versionGen   versionGenFunc
+	assignID     versionGenFunc
 	creationDate gofakes3.ContentTime
+	policy       []byte
+	tags         map[string]string
+	acl          *gofakes3.AccessControlPolicy
+	website      *gofakes3.WebsiteConfiguration
+	payer        gofakes3.Payer
+	configs      map[string][]byte
+	cors         *gofakes3.CORSConfiguration
+	lifecycle    *gofakes3.LifecycleConfiguration
 
 	objects *skiplist.SkipList
+	memory  *memoryUsage
+
+	// parts holds the contents of the parts of multipart uploads, by upload
+	// ID and part number. See MultipartBackend.
+	parts map[gofakes3.UploadID]map[int][]byte
 }
 
-func newBucket(name string, at time.Time, versionGen versionGenFunc) *bucket {
+func newBucket(name string, at time.Time, versionGen, assignID versionGenFunc, memory *memoryUsage) *bucket {
 	return &bucket{
 		name:         name,
 		creationDate: gofakes3.NewContentTime(at),
 		versionGen:   versionGen,
+		assignID:     assignID,
 		objects:      skiplist.NewStringMap(),
+		memory:       memory,
 	}
 }
 
@@ -38,77 +60,65 @@ type bucketObject struct {
 	versions *skiplist.SkipList
 }
 
-func (b *bucketObject) Iterator() *bucketObjectIterator {
-	var iter skiplist.Iterator
-	if b.versions != nil {
-		iter = b.versions.Iterator()
-	}
-
-	return &bucketObjectIterator{
-		data: b.data,
-		iter: iter,
+// live returns the current version of the object, or nil if it has been
+// removed or has expired.
+func (b *bucketObject) live(now time.Time) *bucketData {
+	if b.data == nil || b.data.expired(now) {
+		return nil
 	}
+	return b.data
 }
 
-type bucketObjectIterator struct {
-	data     *bucketData
-	iter     skiplist.Iterator
-	cur      *bucketData
-	seenData bool
-	done     bool
-}
-
-func (b *bucketObjectIterator) Seek(key gofakes3.VersionID) bool {
-	if b.iter.Seek(key) {
-		return true
+// newestFirst returns every version of the object, starting with the current
+// one. Version IDs are sortable, so the older versions are in the order they
+// were stored.
+func (b *bucketObject) newestFirst() []*bucketData {
+	var versions []*bucketData
+	if b.data != nil {
+		versions = append(versions, b.data)
 	}
-
-	b.iter = nil
-	if b.data != nil && b.data.versionID == key {
-		return true
+	if b.versions != nil {
+		for iter := b.versions.SeekToLast(); iter != nil; {
+			versions = append(versions, iter.Value().(*bucketData))
+			if !iter.Previous() {
+				iter.Close()
+				break
+			}
+		}
 	}
-
-	b.data = nil
-	b.done = true
-
-	return false
+	return versions
 }
 
-func (b *bucketObjectIterator) Next() bool {
-	if b.done {
-		return false
-	}
-
-	if b.iter != nil {
-		iterAlive := b.iter.Next()
-		if iterAlive {
-			b.cur = b.iter.Value().(*bucketData)
-			return true
+// version returns the version of the object with the given ID, as reported
+// by bucketData.id(), or nil if there is no such version.
+func (b *bucketObject) version(versionID gofakes3.VersionID) *bucketData {
+	for _, version := range b.newestFirst() {
+		if version.id() == versionID {
+			return version
 		}
-
-		b.iter.Close()
-		b.iter = nil
 	}
-
-	if b.data != nil {
-		b.cur = b.data
-		b.data = nil
-		return true
-	}
-
-	b.done = true
-	return false
+	return nil
 }
 
-func (b *bucketObjectIterator) Close() {
-	if b.iter != nil {
-		b.iter.Close()
+// nullVersion returns the null version of the object, or nil if it does not
+// have one.
+func (b *bucketObject) nullVersion() *bucketData {
+	for _, version := range b.newestFirst() {
+		if version.null {
+			return version
+		}
 	}
-	b.done = true
+	return nil
 }
 
-func (b *bucketObjectIterator) Value() *bucketData {
-	return b.cur
+// archive makes data a noncurrent version of the object.
+func (b *bucketObject) archive(data *bucketData) {
+	if b.versions == nil {
+		b.versions = skiplist.NewCustomMap(func(l, r interface{}) bool {
+			return l.(gofakes3.VersionID) < r.(gofakes3.VersionID)
+		})
+	}
+	b.versions.Set(data.versionID, data)
 }
 
 type bucketData struct {
@@ -120,6 +130,42 @@ type bucketData struct {
 	hash         []byte
 	etag         string
 	metadata     map[string]string
+	tags         map[string]string
+	acl          *gofakes3.AccessControlPolicy
+	retention    *gofakes3.ObjectRetention
+	legalHold    gofakes3.ObjectLockLegalHoldStatus
+	restore      *gofakes3.ObjectRestore
+
+	// null is set if the version was stored while versioning was not
+	// enabled. The versionID of a null version is still generated, so that
+	// it sorts with the other versions, but S3 reports it as "null".
+	null bool
+
+	// assignedID is set if the backend was created with
+	// WithVersionGenerator, in which case S3 reports it instead of the
+	// versionID, which is still used to sort the versions.
+	assignedID gofakes3.VersionID
+
+	// expires is set if the backend was created with WithObjectTTL.
+	expires time.Time
+
+	// lru is the version's element of memoryUsage.lru, if the backend
+	// evicts object versions.
+	lru *list.Element
+}
+
+func (bi *bucketData) expired(now time.Time) bool {
+	return !bi.expires.IsZero() && !now.Before(bi.expires)
+}
+
+// id returns the version ID reported to S3 clients for the version.
+func (bi *bucketData) id() gofakes3.VersionID {
+	if bi.null {
+		return nullVersionID
+	} else if bi.assignedID != "" {
+		return bi.assignedID
+	}
+	return bi.versionID
 }
 
 func (bi *bucketData) toObject(rangeRequest *gofakes3.ObjectRangeRequest, withBody bool) (obj *gofakes3.Object, err error) {
@@ -142,7 +188,7 @@ func (bi *bucketData) toObject(rangeRequest *gofakes3.ObjectRangeRequest, withBo
 
 		// The data slice should be completely replaced if the bucket item is edited, so
 		// it should be safe to return the data slice directly.
-		contents = s3io.ReaderWithDummyCloser{bytes.NewReader(data)}
+		contents = s3io.BytesReaderWithDummyCloser{Reader: bytes.NewReader(data)}
 
 	} else {
 		contents = s3io.NoOpReadCloser{}
@@ -154,8 +200,9 @@ func (bi *bucketData) toObject(rangeRequest *gofakes3.ObjectRangeRequest, withBo
 		Metadata:       bi.metadata,
 		Size:           sz,
 		Range:          rnge,
+		LastModified:   bi.lastModified,
 		IsDeleteMarker: bi.deleteMarker,
-		VersionID:      bi.versionID,
+		VersionID:      bi.id(),
 		Contents:       contents,
 	}, nil
 }
@@ -184,29 +231,26 @@ func (b *bucket) objectVersion(objectName string, versionID gofakes3.VersionID)
 	}
 
 	if versionID == "" {
-		if obj.data.deleteMarker {
+		if obj.data == nil || obj.data.deleteMarker {
 			return nil, gofakes3.KeyNotFound(objectName)
 		}
 		return obj.data, nil
 	}
 
-	if obj.data != nil && obj.data.versionID == versionID {
-		return obj.data, nil
-	}
-	if obj.versions == nil {
+	version := obj.version(versionID)
+	if version == nil {
 		return nil, gofakes3.ErrNoSuchVersion
 	}
-	versionIface, _ := obj.versions.Get(versionID)
-	if versionIface == nil {
-		return nil, gofakes3.ErrNoSuchVersion
-	}
-
-	return versionIface.(*bucketData), nil
+	return version, nil
 }
 
 func (b *bucket) put(name string, item *bucketData) {
 	// Always generate a version for convenience; we can just mask it on return.
 	item.versionID = b.versionGen()
+	item.null = b.versioning != gofakes3.VersioningEnabled
+	if !item.null {
+		item.assignedID = b.assignID()
+	}
 
 	object := b.object(name)
 	if object == nil {
@@ -214,34 +258,76 @@ func (b *bucket) put(name string, item *bucketData) {
 		b.objects.Set(name, object)
 	}
 
-	if b.versioning == gofakes3.VersioningEnabled {
+	switch b.versioning {
+	case gofakes3.VersioningNone:
+		if object.data != nil {
+			b.memory.dropped(object.data)
+		}
+
+	case gofakes3.VersioningEnabled:
 		if object.data != nil {
-			if object.versions == nil {
-				object.versions = skiplist.NewCustomMap(func(l, r interface{}) bool {
-					return l.(gofakes3.VersionID) < r.(gofakes3.VersionID)
-				})
+			object.archive(object.data)
+		}
+
+	case gofakes3.VersioningSuspended:
+		// The item replaces the null version, which may be noncurrent if
+		// versioning was enabled after it was stored. Other versions are kept:
+		if object.data != nil && !object.data.null {
+			object.archive(object.data)
+		}
+		if version := object.nullVersion(); version != nil {
+			if version != object.data {
+				object.versions.Delete(version.versionID)
 			}
-			object.versions.Set(object.data.versionID, object.data)
+			b.memory.dropped(version)
 		}
 	}
 
 	object.data = item
+	b.memory.stored(b, item)
 }
 
-func (b *bucket) rm(name string, at time.Time) (result gofakes3.ObjectDeleteResult, rerr error) {
+// replaced returns the size of the contents that putting the named object
+// would discard. When versioning is suspended, that is the null version, which
+// may be noncurrent.
+func (b *bucket) replaced(name string) int64 {
 	object := b.object(name)
 	if object == nil {
+		return 0
+	}
+
+	switch b.versioning {
+	case gofakes3.VersioningNone:
+		if object.data != nil {
+			return int64(len(object.data.body))
+		}
+	case gofakes3.VersioningSuspended:
+		if version := object.nullVersion(); version != nil {
+			return int64(len(version.body))
+		}
+	}
+	return 0
+}
+
+func (b *bucket) rm(name string, at time.Time) (result gofakes3.ObjectDeleteResult, rerr error) {
+	object := b.object(name)
+	if object == nil && b.versioning == gofakes3.VersioningNone {
 		// S3 does not report an error when attemping to delete a key that does not exist
 		return result, nil
 	}
 
-	if b.versioning == gofakes3.VersioningEnabled {
+	if b.versioning != gofakes3.VersioningNone {
+		// A key that does not exist gets a delete marker too. If versioning
+		// is suspended, the delete marker is the null version:
 		item := &bucketData{lastModified: at, name: name, deleteMarker: true}
 		b.put(name, item)
 		result.IsDeleteMarker = true
-		result.VersionID = item.versionID
+		result.VersionID = item.id()
 
 	} else {
+		if object.data != nil {
+			b.memory.dropped(object.data)
+		}
 		object.data = nil
 		if object.versions == nil || object.versions.Len() == 0 {
 			b.objects.Delete(name)
@@ -255,22 +341,32 @@ func (b *bucket) rmVersion(name string, versionID gofakes3.VersionID, at time.Ti
 	object := b.object(name)
 	if object == nil {
 		return result, nil
+	}
 
-	} else if object.data != nil && object.data.versionID == versionID {
-		result.VersionID = versionID
-		result.IsDeleteMarker = object.data.deleteMarker
+	version := object.version(versionID)
+	if version == nil {
+		// S3 does not report an error when attemping to delete a key that does not exist
+		return result, nil
+	}
+	result.VersionID = version.id()
+	result.IsDeleteMarker = version.deleteMarker
+	b.memory.dropped(version)
+
+	if version == object.data {
 		object.data = nil
 
-	} else if object.versions != nil {
-		versionIface, ok := object.versions.Delete(versionID)
-		if !ok {
-			// S3 does not report an error when attemping to delete a key that does not exist
-			return result, nil
+		// The previous version, if there is one, becomes the current version:
+		if object.versions != nil {
+			if last := object.versions.SeekToLast(); last != nil {
+				object.data = last.Value().(*bucketData)
+				object.versions.Delete(last.Key())
+			}
 		}
 
-		version := versionIface.(*bucketData)
-		result.VersionID = version.versionID
-		result.IsDeleteMarker = version.deleteMarker
+	} else {
+		// Versions are stored by their generated versionID, which may not be
+		// the ID reported to clients:
+		object.versions.Delete(version.versionID)
 	}
 
 	if object.data == nil && (object.versions == nil || object.versions.Len() == 0) {
@@ -279,3 +375,39 @@ func (b *bucket) rmVersion(name string, versionID gofakes3.VersionID, at time.Ti
 
 	return result, nil
 }
+
+// reclaimExpired removes every object version that has expired.
+func (b *bucket) reclaimExpired(now time.Time) {
+	var empty []string
+
+	iter := b.objects.Iterator()
+	for iter.Next() {
+		object := iter.Value().(*bucketObject)
+		if object.data != nil && object.data.expired(now) {
+			b.memory.dropped(object.data)
+			object.data = nil
+		}
+		if object.versions != nil {
+			var expired []interface{}
+			versions := object.versions.Iterator()
+			for versions.Next() {
+				if version := versions.Value().(*bucketData); version.expired(now) {
+					b.memory.dropped(version)
+					expired = append(expired, versions.Key())
+				}
+			}
+			versions.Close()
+			for _, id := range expired {
+				object.versions.Delete(id)
+			}
+		}
+		if object.data == nil && (object.versions == nil || object.versions.Len() == 0) {
+			empty = append(empty, object.name)
+		}
+	}
+	iter.Close()
+
+	for _, name := range empty {
+		b.objects.Delete(name)
+	}
+}
@@ -0,0 +1,44 @@
+package s3mem
+
+import (
+	"context"
+	"io/ioutil"
+	"strings"
+	"testing"
+)
+
+func TestPutObjectContextCancelled(t *testing.T) {
+	db := New()
+	if err := db.CreateBucket("bucket"); err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := db.PutObjectContext(ctx, "bucket", "object", map[string]string{}, strings.NewReader("hello"), 5); err != context.Canceled {
+		t.Fatal("expected context.Canceled, found", err)
+	}
+	if _, err := db.HeadObject("bucket", "object"); err == nil {
+		t.Fatal("object was stored")
+	}
+}
+
+func TestGetObjectContextCancelled(t *testing.T) {
+	db := New()
+	if err := db.CreateBucket("bucket"); err != nil {
+		t.Fatal(err)
+	}
+	putString(t, db, "bucket", "object", "hello")
+
+	ctx, cancel := context.WithCancel(context.Background())
+	obj, err := db.GetObjectContext(ctx, "bucket", "object", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer obj.Contents.Close()
+
+	cancel()
+	if _, err := ioutil.ReadAll(obj.Contents); err != context.Canceled {
+		t.Fatal("expected context.Canceled, found", err)
+	}
+}
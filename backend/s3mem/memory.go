@@ -0,0 +1,197 @@
+package s3mem
+
+import (
+	"container/list"
+	"sort"
+	"sync"
+
+	"github.com/johannesboyne/gofakes3"
+)
+
+// MemoryLimitMode selects what a Backend created with WithMemoryLimit does
+// when storing an object or a multipart part would take it over the limit.
+type MemoryLimitMode int
+
+const (
+	// MemoryLimitReject fails the put with a 503 SlowDown error, which S3
+	// returns when it can not keep up, and which a client that fills up the
+	// storage should handle. This is the default.
+	MemoryLimitReject MemoryLimitMode = iota
+
+	// MemoryLimitEvict discards the least recently used object versions until
+	// there is room. An object version is used when it is put, or when its
+	// contents are read with GetObject or GetObjectVersion.
+	//
+	// Eviction ignores versioning and object lock: evicting the current
+	// version of an object makes the previous version, if any, current,
+	// without leaving a delete marker. Multipart parts are never evicted; if
+	// they alone leave no room, the put is rejected as with
+	// MemoryLimitReject.
+	MemoryLimitEvict
+)
+
+// WithMemoryLimit limits the total size of the object versions and multipart
+// parts held by the Backend to bytes. Only their contents are counted, not
+// their metadata. A put that would exceed the limit is handled according to
+// the mode passed to WithMemoryLimitMode. The limit is not applied to the
+// objects restored by WithPersistFile.
+func WithMemoryLimit(bytes int64) Option {
+	return func(b *Backend) { b.memoryLimit = bytes }
+}
+
+// WithMemoryLimitMode selects what happens when the limit passed to
+// WithMemoryLimit would be exceeded. It has no effect without a limit.
+func WithMemoryLimitMode(mode MemoryLimitMode) Option {
+	return func(b *Backend) { b.memoryLimitMode = mode }
+}
+
+// memoryUsage tracks the size of the contents held by a Backend, and, if it
+// evicts object versions, the order they were last used in. It is shared by
+// the Backend's buckets.
+type memoryUsage struct {
+	// size is only changed with the Backend's write lock held.
+	size int64
+
+	// mu protects lru and the elements of bucketData, which are also moved
+	// by reads holding only the Backend's read lock. lru is nil unless the
+	// Backend evicts object versions; its front is the most recently used
+	// version.
+	mu  sync.Mutex
+	lru *list.List
+}
+
+type lruEntry struct {
+	bucket *bucket
+	data   *bucketData
+}
+
+// stored counts data, which has just been added to b.
+func (m *memoryUsage) stored(b *bucket, data *bucketData) {
+	m.size += int64(len(data.body))
+	if m.lru == nil || data.deleteMarker {
+		return
+	}
+	m.mu.Lock()
+	data.lru = m.lru.PushFront(&lruEntry{bucket: b, data: data})
+	m.mu.Unlock()
+}
+
+// dropped stops counting data, which has just been removed from its bucket.
+func (m *memoryUsage) dropped(data *bucketData) {
+	m.size -= int64(len(data.body))
+	m.forget(data)
+}
+
+// forget removes data from the versions that can be evicted.
+func (m *memoryUsage) forget(data *bucketData) {
+	if m.lru == nil {
+		return
+	}
+	m.mu.Lock()
+	if data.lru != nil {
+		m.lru.Remove(data.lru)
+		data.lru = nil
+	}
+	m.mu.Unlock()
+}
+
+// used marks data as the most recently used version. Unlike stored and
+// dropped, it may be called with only the Backend's read lock held.
+func (m *memoryUsage) used(data *bucketData) {
+	if m.lru == nil {
+		return
+	}
+	m.mu.Lock()
+	if data.lru != nil {
+		m.lru.MoveToFront(data.lru)
+	}
+	m.mu.Unlock()
+}
+
+// leastRecentlyUsed returns the version to evict next, or nil if there is
+// none.
+func (m *memoryUsage) leastRecentlyUsed() *lruEntry {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.lru == nil || m.lru.Len() == 0 {
+		return nil
+	}
+	return m.lru.Back().Value.(*lruEntry)
+}
+
+// recount discards what is being tracked, and starts again with the contents
+// of buckets, which have been restored from a snapshot. The buckets' object
+// versions are treated as used in the order they were modified.
+func (m *memoryUsage) recount(buckets map[string]*bucket) {
+	m.size = 0
+	if m.lru != nil {
+		m.mu.Lock()
+		m.lru.Init()
+		m.mu.Unlock()
+	}
+
+	var versions []lruEntry
+	for _, b := range buckets {
+		iter := b.objects.Iterator()
+		for iter.Next() {
+			for _, data := range iter.Value().(*bucketObject).newestFirst() {
+				versions = append(versions, lruEntry{bucket: b, data: data})
+			}
+		}
+		iter.Close()
+		for _, parts := range b.parts {
+			for _, part := range parts {
+				m.size += int64(len(part))
+			}
+		}
+	}
+
+	sort.SliceStable(versions, func(i, j int) bool {
+		return versions[i].data.lastModified.Before(versions[j].data.lastModified)
+	})
+	for _, v := range versions {
+		m.stored(v.bucket, v.data)
+	}
+}
+
+// reserve makes room for n more bytes, less the bytes returned by replaced,
+// which are freed when the n bytes are stored. When evicting, replaced is
+// called again after each eviction, as it may have been the version evicted.
+//
+// It must be called with the Backend's write lock held.
+func (db *Backend) reserve(n int64, replaced func() int64) error {
+	if db.memoryLimit <= 0 {
+		return nil
+	}
+
+	for {
+		var free int64
+		if replaced != nil {
+			free = replaced()
+		}
+		if db.memory.size-free+n <= db.memoryLimit {
+			return nil
+		}
+
+		var victim *lruEntry
+		if db.memoryLimitMode == MemoryLimitEvict && n <= db.memoryLimit {
+			victim = db.memory.leastRecentlyUsed()
+		}
+		if victim == nil {
+			return gofakes3.ErrorMessagef(gofakes3.ErrSlowDown,
+				"The backend's memory limit of %d bytes has been reached", db.memoryLimit)
+		}
+		db.evict(victim)
+	}
+}
+
+func (db *Backend) evict(victim *lruEntry) {
+	data := victim.data
+	victim.bucket.rmVersion(data.name, data.id(), db.timeSource.Now())
+
+	// rmVersion drops the version; this only guards against evicting it
+	// forever if it could not be found:
+	if data.lru != nil {
+		db.memory.forget(data)
+	}
+}
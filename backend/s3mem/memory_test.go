@@ -0,0 +1,248 @@
+package s3mem
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/johannesboyne/gofakes3"
+)
+
+// checkMemory fails the test if the size tracked by db does not match the
+// contents it holds.
+func checkMemory(t *testing.T, db *Backend, expected int64) {
+	t.Helper()
+
+	db.lock.RLock()
+	defer db.lock.RUnlock()
+
+	var size int64
+	for _, b := range db.buckets {
+		iter := b.objects.Iterator()
+		for iter.Next() {
+			for _, data := range iter.Value().(*bucketObject).newestFirst() {
+				size += int64(len(data.body))
+			}
+		}
+		iter.Close()
+		for _, parts := range b.parts {
+			for _, part := range parts {
+				size += int64(len(part))
+			}
+		}
+	}
+	if size != db.memory.size {
+		t.Fatalf("tracked size %d != stored size %d", db.memory.size, size)
+	}
+	if expected >= 0 && size != expected {
+		t.Fatalf("expected size %d, found %d", expected, size)
+	}
+}
+
+func putOrError(db *Backend, bucket, key, contents string) error {
+	_, err := db.PutObject(bucket, key, map[string]string{}, strings.NewReader(contents), int64(len(contents)))
+	return err
+}
+
+func TestMemoryLimitReject(t *testing.T) {
+	db := New(WithMemoryLimit(10))
+	defer db.Close()
+	if err := db.CreateBucket("bucket"); err != nil {
+		t.Fatal(err)
+	}
+
+	putString(t, db, "bucket", "a", "123456")
+	if err := putOrError(db, "bucket", "b", "123456"); !gofakes3.HasErrorCode(err, gofakes3.ErrSlowDown) {
+		t.Fatal("expected ErrSlowDown, found", err)
+	}
+	checkMemory(t, db, 6)
+
+	// Replacing an object frees its contents:
+	putString(t, db, "bucket", "a", "1234567890")
+	checkMemory(t, db, 10)
+
+	if _, err := db.DeleteObject("bucket", "a"); err != nil {
+		t.Fatal(err)
+	}
+	putString(t, db, "bucket", "b", "123456")
+	checkMemory(t, db, 6)
+
+	// Multipart parts count towards the limit until the upload is aborted:
+	if err := db.PutMultipartPart("bucket", "upload", 1, strings.NewReader("1234"), 4); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.PutMultipartPart("bucket", "upload", 2, strings.NewReader("1"), 1); !gofakes3.HasErrorCode(err, gofakes3.ErrSlowDown) {
+		t.Fatal("expected ErrSlowDown, found", err)
+	}
+	checkMemory(t, db, 10)
+	if err := db.AbortMultipart("bucket", "upload"); err != nil {
+		t.Fatal(err)
+	}
+	checkMemory(t, db, 6)
+}
+
+func TestMemoryLimitVersions(t *testing.T) {
+	db := New(WithMemoryLimit(10))
+	defer db.Close()
+	if err := db.CreateBucket("bucket"); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.SetVersioningConfiguration("bucket", gofakes3.VersioningConfiguration{Status: gofakes3.VersioningEnabled}); err != nil {
+		t.Fatal(err)
+	}
+
+	// Noncurrent versions are kept, so they still count:
+	v1 := putString(t, db, "bucket", "object", "12345")
+	putString(t, db, "bucket", "object", "12345")
+	if err := putOrError(db, "bucket", "object", "1"); !gofakes3.HasErrorCode(err, gofakes3.ErrSlowDown) {
+		t.Fatal("expected ErrSlowDown, found", err)
+	}
+	if _, err := db.DeleteObject("bucket", "object"); err != nil {
+		t.Fatal(err)
+	}
+	checkMemory(t, db, 10)
+
+	if _, err := db.DeleteObjectVersion("bucket", "object", v1); err != nil {
+		t.Fatal(err)
+	}
+	checkMemory(t, db, 5)
+
+	// Once versioning is suspended, a put replaces the null version only:
+	if err := db.SetVersioningConfiguration("bucket", gofakes3.VersioningConfiguration{Status: gofakes3.VersioningSuspended}); err != nil {
+		t.Fatal(err)
+	}
+	putString(t, db, "bucket", "object", "123")
+	putString(t, db, "bucket", "object", "12345")
+	checkMemory(t, db, 10)
+}
+
+func TestMemoryLimitEvict(t *testing.T) {
+	db := New(WithMemoryLimit(10), WithMemoryLimitMode(MemoryLimitEvict))
+	defer db.Close()
+	if err := db.CreateBucket("bucket"); err != nil {
+		t.Fatal(err)
+	}
+
+	putString(t, db, "bucket", "a", "1234")
+	putString(t, db, "bucket", "b", "1234")
+	getString(t, db, "bucket", "a", "")
+	putString(t, db, "bucket", "c", "1234")
+	checkMemory(t, db, 8)
+
+	if _, err := db.HeadObject("bucket", "b"); !gofakes3.HasErrorCode(err, gofakes3.ErrNoSuchKey) {
+		t.Fatal("expected b to be evicted, found", err)
+	}
+	for _, key := range []string{"a", "c"} {
+		if got := getString(t, db, "bucket", key, ""); got != "1234" {
+			t.Fatal(key, got, "!= 1234")
+		}
+	}
+
+	// An object larger than the limit is rejected without evicting anything:
+	if err := putOrError(db, "bucket", "d", "12345678901"); !gofakes3.HasErrorCode(err, gofakes3.ErrSlowDown) {
+		t.Fatal("expected ErrSlowDown, found", err)
+	}
+	checkMemory(t, db, 8)
+
+	// Multipart parts are not evicted, but objects are evicted to make room
+	// for them:
+	if err := db.PutMultipartPart("bucket", "upload", 1, strings.NewReader("123456"), 6); err != nil {
+		t.Fatal(err)
+	}
+	checkMemory(t, db, 10)
+	if _, err := db.HeadObject("bucket", "a"); !gofakes3.HasErrorCode(err, gofakes3.ErrNoSuchKey) {
+		t.Fatal("expected a to be evicted, found", err)
+	}
+	if err := putOrError(db, "bucket", "e", "12345"); !gofakes3.HasErrorCode(err, gofakes3.ErrSlowDown) {
+		t.Fatal("expected ErrSlowDown, found", err)
+	}
+}
+
+func TestMemoryLimitCompleteMultipart(t *testing.T) {
+	for _, mode := range []MemoryLimitMode{MemoryLimitReject, MemoryLimitEvict} {
+		db := New(WithMemoryLimit(10), WithMemoryLimitMode(mode))
+		defer db.Close()
+		if err := db.CreateBucket("bucket"); err != nil {
+			t.Fatal(err)
+		}
+		putString(t, db, "bucket", "other", "123")
+
+		// The parts already hold the object's contents, which move over to it
+		// rather than taking up the memory a second time:
+		for i, part := range []string{"1234", "567"} {
+			if err := db.PutMultipartPart("bucket", "upload", i+1, strings.NewReader(part), int64(len(part))); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if _, err := db.CompleteMultipart("bucket", "object", "upload", []int{1, 2}, map[string]string{}, 7); err != nil {
+			t.Fatalf("mode %d: %v", mode, err)
+		}
+		checkMemory(t, db, 10)
+		for key, expected := range map[string]string{"object": "1234567", "other": "123"} {
+			if got := getString(t, db, "bucket", key, ""); got != expected {
+				t.Fatalf("mode %d: %s: %q != %q", mode, key, got, expected)
+			}
+		}
+	}
+}
+
+func TestMemoryLimitConcurrent(t *testing.T) {
+	const limit = 1000
+	db := New(WithMemoryLimit(limit), WithMemoryLimitMode(MemoryLimitEvict))
+	defer db.Close()
+	if err := db.CreateBucket("bucket"); err != nil {
+		t.Fatal(err)
+	}
+
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			for j := 0; j < 100; j++ {
+				key := fmt.Sprintf("%d/%d", i, j%20)
+				if err := putOrError(db, "bucket", key, strings.Repeat("x", j)); err != nil {
+					t.Error(err)
+					return
+				}
+				if obj, err := db.GetObject("bucket", fmt.Sprintf("%d/%d", (i+1)%8, j%20), nil); err == nil {
+					obj.Contents.Close()
+				} else if !gofakes3.HasErrorCode(err, gofakes3.ErrNoSuchKey) {
+					t.Error(err)
+					return
+				}
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	checkMemory(t, db, -1)
+	if db.memory.size > limit {
+		t.Fatalf("size %d exceeds limit %d", db.memory.size, limit)
+	}
+}
+
+func TestMemoryLimitRestore(t *testing.T) {
+	src := populatedBackend(t)
+	defer src.Close()
+	var buf bytes.Buffer
+	if err := src.Snapshot(&buf); err != nil {
+		t.Fatal(err)
+	}
+
+	db := New(WithMemoryLimit(1<<20), WithMemoryLimitMode(MemoryLimitEvict))
+	defer db.Close()
+	if err := db.CreateBucket("discarded"); err != nil {
+		t.Fatal(err)
+	}
+	putString(t, db, "discarded", "object", "discarded")
+	if err := db.Restore(&buf); err != nil {
+		t.Fatal(err)
+	}
+	checkMemory(t, db, src.memory.size)
+	if n := db.memory.lru.Len(); n == 0 {
+		t.Fatal("restored versions can not be evicted")
+	}
+}
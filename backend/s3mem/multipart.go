@@ -0,0 +1,111 @@
+package s3mem
+
+import (
+	"bytes"
+	"io"
+
+	"github.com/johannesboyne/gofakes3"
+)
+
+// PutMultipartPart implements gofakes3.MultipartBackend. The parts are held in
+// memory by the bucket, as the objects are, and count towards WithMemoryLimit.
+func (db *Backend) PutMultipartPart(bucketName string, uploadID gofakes3.UploadID, partNumber int, input io.Reader, size int64) error {
+	bts, err := gofakes3.ReadAll(input, size)
+	if err != nil {
+		return err
+	}
+
+	db.lock.Lock()
+	defer db.lock.Unlock()
+
+	bucket := db.buckets[bucketName]
+	if bucket == nil {
+		return gofakes3.BucketNotFound(bucketName)
+	}
+	if err := db.reserve(int64(len(bts)), func() int64 { return int64(len(bucket.parts[uploadID][partNumber])) }); err != nil {
+		return err
+	}
+	if bucket.parts == nil {
+		bucket.parts = map[gofakes3.UploadID]map[int][]byte{}
+	}
+	if bucket.parts[uploadID] == nil {
+		bucket.parts[uploadID] = map[int][]byte{}
+	}
+	bucket.memory.size += int64(len(bts) - len(bucket.parts[uploadID][partNumber]))
+	bucket.parts[uploadID][partNumber] = bts
+	return nil
+}
+
+// CompleteMultipart implements gofakes3.MultipartBackend.
+func (db *Backend) CompleteMultipart(bucketName, objectName string, uploadID gofakes3.UploadID, partNumbers []int, meta map[string]string, size int64) (result gofakes3.PutObjectResult, err error) {
+	var readers []io.Reader
+	{
+		db.lock.RLock()
+		bucket := db.buckets[bucketName]
+		if bucket == nil {
+			db.lock.RUnlock()
+			return result, gofakes3.BucketNotFound(bucketName)
+		}
+		for _, partNumber := range partNumbers {
+			bts, ok := bucket.parts[uploadID][partNumber]
+			if !ok {
+				db.lock.RUnlock()
+				return result, gofakes3.ErrInvalidPart
+			}
+			readers = append(readers, bytes.NewReader(bts))
+		}
+		db.lock.RUnlock()
+	}
+
+	bts, err := gofakes3.ReadAll(io.MultiReader(readers...), size)
+	if err != nil {
+		return result, err
+	}
+	return db.putObject(bucketName, objectName, meta, bts, uploadID)
+}
+
+// AbortMultipart implements gofakes3.MultipartBackend.
+func (db *Backend) AbortMultipart(bucketName string, uploadID gofakes3.UploadID) error {
+	db.lock.Lock()
+	defer db.lock.Unlock()
+
+	bucket := db.buckets[bucketName]
+	if bucket == nil {
+		return gofakes3.BucketNotFound(bucketName)
+	}
+	bucket.dropParts(uploadID)
+	return nil
+}
+
+// partsSize returns the size of the parts of the upload.
+func (b *bucket) partsSize(uploadID gofakes3.UploadID) (size int64) {
+	for _, part := range b.parts[uploadID] {
+		size += int64(len(part))
+	}
+	return size
+}
+
+// dropParts discards the parts of the upload.
+func (b *bucket) dropParts(uploadID gofakes3.UploadID) {
+	for _, part := range b.parts[uploadID] {
+		b.memory.size -= int64(len(part))
+	}
+	delete(b.parts, uploadID)
+}
+
+// SetMultipartUploads implements gofakes3.MultipartStateBackend, so that the
+// uploads in progress are included in a Snapshot with their parts. Uploads
+// restored before it was called are passed to uploads.
+func (db *Backend) SetMultipartUploads(uploads gofakes3.MultipartUploads) {
+	db.lock.Lock()
+	restored := db.restoredUploads
+	db.uploads, db.restoredUploads = uploads, nil
+	db.lock.Unlock()
+
+	if restored != nil {
+		// Restore has already validated the uploads, so this can not fail:
+		if err := uploads.Restore(restored); err != nil {
+			panic(err)
+		}
+	}
+}
@@ -0,0 +1,404 @@
+package s3mem
+
+import (
+	"encoding/gob"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math/big"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+
+	"github.com/johannesboyne/gofakes3"
+	"github.com/ryszard/goskiplist/skiplist"
+)
+
+// Snapshots are a gob-encoded snapshotHeader followed by a snapshot. The
+// version must be incremented whenever the snapshot types change in a way gob
+// can't cope with.
+const (
+	snapshotFormat  = "gofakes3/s3mem"
+	snapshotVersion = 1
+)
+
+type snapshotHeader struct {
+	Format  string
+	Version int
+}
+
+type snapshot struct {
+	VersionState uint64
+	VersionNext  *big.Int
+	Buckets      []snapshotBucket
+
+	// Uploads are the multipart uploads in progress, whose parts are stored
+	// with their buckets.
+	Uploads []gofakes3.MultipartUploadState
+}
+
+type snapshotBucket struct {
+	Name         string
+	CreationDate time.Time
+	Versioning   gofakes3.VersioningStatus
+	MFADelete    gofakes3.MFADeleteStatus
+	Policy       []byte
+	Tags         map[string]string
+	ACL          *gofakes3.AccessControlPolicy
+	Website      *gofakes3.WebsiteConfiguration
+	Payer        gofakes3.Payer
+	Configs      map[string][]byte
+	CORS         *gofakes3.CORSConfiguration
+	Lifecycle    *gofakes3.LifecycleConfiguration
+	Objects      []snapshotObject
+	Parts        map[gofakes3.UploadID]map[int][]byte
+}
+
+type snapshotObject struct {
+	Name string
+
+	// Current is nil if the current version was deleted by version ID.
+	Current *snapshotData
+
+	// Versions contains the noncurrent versions, sorted by VersionID.
+	Versions []snapshotData
+}
+
+type snapshotData struct {
+	LastModified time.Time
+	VersionID    gofakes3.VersionID
+	Null         bool
+	AssignedID   gofakes3.VersionID
+	DeleteMarker bool
+	Body         []byte
+	Hash         []byte
+	ETag         string
+	Metadata     map[string]string
+	Tags         map[string]string
+	ACL          *gofakes3.AccessControlPolicy
+	Retention    *gofakes3.ObjectRetention
+	LegalHold    gofakes3.ObjectLockLegalHoldStatus
+	Restore      *gofakes3.ObjectRestore
+	Expires      time.Time
+}
+
+// WithPersistFile loads the backend's contents from a snapshot file when it is
+// created, if the file exists, and saves a snapshot to it when the backend
+// is closed with Backend.Close().
+//
+// Use Open rather than New to handle errors loading the file.
+func WithPersistFile(path string) Option {
+	return func(b *Backend) { b.persistFile = path }
+}
+
+// Open creates a Backend in the same way as New, but returns an error rather
+// than panicking if the file passed to WithPersistFile can not be loaded.
+func Open(opts ...Option) (*Backend, error) {
+	b := newBackend(opts...)
+	if b.persistFile == "" {
+		return b, nil
+	}
+
+	f, err := os.Open(b.persistFile)
+	if os.IsNotExist(err) {
+		return b, nil
+	} else if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	if err := b.Restore(f); err != nil {
+		return nil, fmt.Errorf("s3mem: could not load %q: %v", b.persistFile, err)
+	}
+	return b, nil
+}
+
+// Close stops reclaiming the memory of objects expired by WithObjectTTL, and
+// saves a snapshot to the file passed to WithPersistFile, replacing the file's
+// previous contents only once the snapshot is complete.
+//
+// The Backend remains usable after Close, so it may be called again to save a
+// newer snapshot.
+func (db *Backend) Close() error {
+	db.closeOnce.Do(func() {
+		if db.stopReclaim != nil {
+			close(db.stopReclaim)
+		}
+	})
+
+	if db.persistFile == "" {
+		return nil
+	}
+
+	f, err := ioutil.TempFile(filepath.Dir(db.persistFile), filepath.Base(db.persistFile)+".*.tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name()) // Fails harmlessly once the file is renamed
+
+	if err := db.Snapshot(f); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	return os.Rename(f.Name(), db.persistFile)
+}
+
+// Snapshot writes all buckets, their configuration, every version of every
+// object, and the multipart uploads in progress with their parts to w, in a
+// form that can be loaded with Restore.
+//
+// Writes block while the snapshot is taken, so it is a consistent view of the
+// backend at a single point in time. GoFakeS3 holds the uploads themselves,
+// so they are only included once the backend has been passed to
+// gofakes3.New, or if they were restored by Restore.
+func (db *Backend) Snapshot(w io.Writer) error {
+	db.lock.RLock()
+	defer db.lock.RUnlock()
+
+	var snap snapshot
+	snap.VersionState, snap.VersionNext = db.versionGenerator.snapshot()
+
+	// Uploads to buckets that have since been deleted have lost their parts:
+	uploads := db.restoredUploads
+	if db.uploads != nil {
+		uploads = db.uploads.Uploads()
+	}
+	parts := map[string]map[gofakes3.UploadID]map[int][]byte{}
+	for _, upload := range uploads {
+		bucket := db.buckets[upload.Bucket]
+		if bucket == nil {
+			continue
+		}
+		if uploadParts := bucket.parts[upload.ID]; uploadParts != nil {
+			if parts[upload.Bucket] == nil {
+				parts[upload.Bucket] = map[gofakes3.UploadID]map[int][]byte{}
+			}
+			parts[upload.Bucket][upload.ID] = uploadParts
+		}
+		snap.Uploads = append(snap.Uploads, upload)
+	}
+
+	names := make([]string, 0, len(db.buckets))
+	for name := range db.buckets {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		bucket := db.buckets[name]
+		sb := snapshotBucket{
+			Name:         bucket.name,
+			CreationDate: bucket.creationDate.Time,
+			Versioning:   bucket.versioning,
+			MFADelete:    bucket.mfaDelete,
+			Policy:       bucket.policy,
+			Tags:         bucket.tags,
+			ACL:          bucket.acl,
+			Website:      bucket.website,
+			Payer:        bucket.payer,
+			Configs:      bucket.configs,
+			CORS:         bucket.cors,
+			Lifecycle:    bucket.lifecycle,
+			Parts:        parts[name],
+		}
+
+		iter := bucket.objects.Iterator()
+		for iter.Next() {
+			object := iter.Value().(*bucketObject)
+			so := snapshotObject{Name: object.name}
+			if object.data != nil {
+				data := snapshotDataFrom(object.data)
+				so.Current = &data
+			}
+			if object.versions != nil {
+				versions := object.versions.Iterator()
+				for versions.Next() {
+					so.Versions = append(so.Versions, snapshotDataFrom(versions.Value().(*bucketData)))
+				}
+				versions.Close()
+			}
+			sb.Objects = append(sb.Objects, so)
+		}
+		iter.Close()
+
+		snap.Buckets = append(snap.Buckets, sb)
+	}
+
+	enc := gob.NewEncoder(w)
+	if err := enc.Encode(snapshotHeader{Format: snapshotFormat, Version: snapshotVersion}); err != nil {
+		return err
+	}
+	return enc.Encode(&snap)
+}
+
+// Restore replaces the entire contents of the backend with a snapshot written
+// by Snapshot, including the multipart uploads in progress in the GoFakeS3
+// the backend was passed to. If the snapshot can not be read, an error is
+// returned and the backend is left unchanged.
+func (db *Backend) Restore(r io.Reader) error {
+	dec := gob.NewDecoder(r)
+
+	var header snapshotHeader
+	if err := dec.Decode(&header); err != nil {
+		return fmt.Errorf("s3mem: not a valid snapshot: %v", err)
+	}
+	if header.Format != snapshotFormat {
+		return fmt.Errorf("s3mem: not a valid snapshot: unexpected format %q", header.Format)
+	}
+	if header.Version != snapshotVersion {
+		return fmt.Errorf("s3mem: unsupported snapshot version %d, expected %d", header.Version, snapshotVersion)
+	}
+
+	var snap snapshot
+	if err := dec.Decode(&snap); err != nil {
+		return fmt.Errorf("s3mem: corrupt snapshot: %v", err)
+	}
+
+	// The buckets need the version generator to exist already, but nothing
+	// can use them until they are swapped in below:
+	buckets, err := db.restoreBuckets(&snap)
+	if err != nil {
+		return fmt.Errorf("s3mem: corrupt snapshot: %v", err)
+	}
+	if err := validateUploads(snap.Uploads, buckets); err != nil {
+		return fmt.Errorf("s3mem: corrupt snapshot: %v", err)
+	}
+
+	db.lock.Lock()
+	db.buckets = buckets
+	db.memory.recount(buckets)
+	db.versionGenerator.restore(snap.VersionState, snap.VersionNext)
+	uploads := db.uploads
+	if uploads == nil {
+		db.restoredUploads = snap.Uploads
+	}
+	db.lock.Unlock()
+
+	// The uploader has a lock of its own, which Snapshot takes with the
+	// backend's held, so it must not be taken the other way around:
+	if uploads != nil {
+		return uploads.Restore(snap.Uploads)
+	}
+	return nil
+}
+
+// validateUploads returns an error if the uploads could not be restored, so
+// that Restore fails before it changes anything.
+func validateUploads(uploads []gofakes3.MultipartUploadState, buckets map[string]*bucket) error {
+	seen := map[gofakes3.UploadID]bool{}
+	for _, upload := range uploads {
+		if err := upload.Validate(); err != nil {
+			return err
+		} else if buckets[upload.Bucket] == nil {
+			return fmt.Errorf("upload %q to missing bucket %q", upload.ID, upload.Bucket)
+		} else if seen[upload.ID] {
+			return fmt.Errorf("duplicate upload %q", upload.ID)
+		}
+		seen[upload.ID] = true
+	}
+	return nil
+}
+
+func (db *Backend) restoreBuckets(snap *snapshot) (map[string]*bucket, error) {
+	buckets := make(map[string]*bucket, len(snap.Buckets))
+
+	for _, sb := range snap.Buckets {
+		if sb.Name == "" {
+			return nil, errors.New("bucket without a name")
+		} else if buckets[sb.Name] != nil {
+			return nil, fmt.Errorf("duplicate bucket %q", sb.Name)
+		}
+
+		bucket := newBucket(sb.Name, sb.CreationDate, db.nextVersion, db.nextAssignedVersion, db.memory)
+		bucket.versioning = sb.Versioning
+		bucket.mfaDelete = sb.MFADelete
+		bucket.policy = sb.Policy
+		bucket.tags = sb.Tags
+		bucket.acl = sb.ACL
+		bucket.website = sb.Website
+		bucket.payer = sb.Payer
+		bucket.configs = sb.Configs
+		bucket.cors = sb.CORS
+		bucket.lifecycle = sb.Lifecycle
+		bucket.parts = sb.Parts
+
+		for _, so := range sb.Objects {
+			if so.Name == "" {
+				return nil, fmt.Errorf("object without a name in bucket %q", sb.Name)
+			} else if bucket.object(so.Name) != nil {
+				return nil, fmt.Errorf("duplicate object %q in bucket %q", so.Name, sb.Name)
+			} else if so.Current == nil && len(so.Versions) == 0 {
+				return nil, fmt.Errorf("object %q in bucket %q has no versions", so.Name, sb.Name)
+			}
+
+			object := &bucketObject{name: so.Name}
+			if so.Current != nil {
+				object.data = so.Current.bucketData(so.Name)
+			}
+			if len(so.Versions) > 0 {
+				object.versions = skiplist.NewCustomMap(func(l, r interface{}) bool {
+					return l.(gofakes3.VersionID) < r.(gofakes3.VersionID)
+				})
+				for i := range so.Versions {
+					version := so.Versions[i].bucketData(so.Name)
+					if _, exists := object.versions.Get(version.versionID); exists ||
+						(object.data != nil && object.data.versionID == version.versionID) {
+						return nil, fmt.Errorf("duplicate version %q of object %q in bucket %q", version.versionID, so.Name, sb.Name)
+					}
+					object.versions.Set(version.versionID, version)
+				}
+			}
+			bucket.objects.Set(so.Name, object)
+		}
+
+		buckets[sb.Name] = bucket
+	}
+
+	return buckets, nil
+}
+
+func snapshotDataFrom(data *bucketData) snapshotData {
+	return snapshotData{
+		LastModified: data.lastModified,
+		VersionID:    data.versionID,
+		Null:         data.null,
+		AssignedID:   data.assignedID,
+		DeleteMarker: data.deleteMarker,
+		Body:         data.body,
+		Hash:         data.hash,
+		ETag:         data.etag,
+		Metadata:     data.metadata,
+		Tags:         data.tags,
+		ACL:          data.acl,
+		Retention:    data.retention,
+		LegalHold:    data.legalHold,
+		Restore:      data.restore,
+		Expires:      data.expires,
+	}
+}
+
+func (sd *snapshotData) bucketData(name string) *bucketData {
+	return &bucketData{
+		name:         name,
+		lastModified: sd.LastModified,
+		versionID:    sd.VersionID,
+		null:         sd.Null,
+		assignedID:   sd.AssignedID,
+		deleteMarker: sd.DeleteMarker,
+		body:         sd.Body,
+		hash:         sd.Hash,
+		etag:         sd.ETag,
+		metadata:     sd.Metadata,
+		tags:         sd.Tags,
+		acl:          sd.ACL,
+		retention:    sd.Retention,
+		legalHold:    sd.LegalHold,
+		restore:      sd.Restore,
+		expires:      sd.Expires,
+	}
+}
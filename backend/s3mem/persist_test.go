@@ -0,0 +1,331 @@
+package s3mem
+
+import (
+	"bytes"
+	"encoding/gob"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/johannesboyne/gofakes3"
+)
+
+func putString(t *testing.T, db *Backend, bucket, key, contents string) gofakes3.VersionID {
+	t.Helper()
+	result, err := db.PutObject(bucket, key, map[string]string{"X-Amz-Meta-Key": key}, strings.NewReader(contents), int64(len(contents)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return result.VersionID
+}
+
+func getString(t *testing.T, db *Backend, bucket, key string, version gofakes3.VersionID) string {
+	t.Helper()
+	var obj *gofakes3.Object
+	var err error
+	if version != "" {
+		obj, err = db.GetObjectVersion(bucket, key, version, nil)
+	} else {
+		obj, err = db.GetObject(bucket, key, nil)
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer obj.Contents.Close()
+	bts, err := ioutil.ReadAll(obj.Contents)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(bts)
+}
+
+func populatedBackend(t *testing.T) *Backend {
+	t.Helper()
+
+	db := New(WithVersionSeed(0), WithTimeSource(gofakes3.FixedTimeSource(time.Date(2018, 1, 1, 0, 0, 0, 0, time.UTC))))
+	for _, bucket := range []string{"plain", "versioned", "suspended"} {
+		if err := db.CreateBucket(bucket); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	putString(t, db, "plain", "a", "hello")
+	putString(t, db, "plain", "b/c", "world")
+	if err := db.PutObjectTagging("plain", "a", "", map[string]string{"tag": "value"}); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := db.SetVersioningConfiguration("versioned", gofakes3.VersioningConfiguration{Status: gofakes3.VersioningEnabled}); err != nil {
+		t.Fatal(err)
+	}
+	putString(t, db, "versioned", "object", "v1")
+	putString(t, db, "versioned", "object", "v2")
+	if _, err := db.DeleteObject("versioned", "object"); err != nil {
+		t.Fatal(err)
+	}
+	putString(t, db, "versioned", "locked", "locked")
+	if err := db.PutObjectLegalHold("versioned", "locked", "", gofakes3.LegalHoldOn); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := db.SetVersioningConfiguration("suspended", gofakes3.VersioningConfiguration{Status: gofakes3.VersioningEnabled}); err != nil {
+		t.Fatal(err)
+	}
+	putString(t, db, "suspended", "object", "versioned")
+	if err := db.SetVersioningConfiguration("suspended", gofakes3.VersioningConfiguration{Status: gofakes3.VersioningSuspended}); err != nil {
+		t.Fatal(err)
+	}
+	putString(t, db, "suspended", "object", "null")
+
+	if err := db.SetBucketPolicy("plain", []byte(`{"Statement":[]}`)); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.SetBucketTagging("plain", map[string]string{"team": "storage"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.SetBucketACL("plain", gofakes3.AccessControlPolicy{Grants: []gofakes3.Grant{
+		{Grantee: gofakes3.Grantee{Type: gofakes3.GranteeGroup, URI: gofakes3.GroupAllUsers}, Permission: gofakes3.PermissionRead},
+	}}); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.SetBucketCORS("plain", gofakes3.CORSConfiguration{Rules: []gofakes3.CORSRule{
+		{AllowedOrigins: []string{"*"}, AllowedMethods: []string{"GET"}},
+	}}); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.SetBucketWebsite("plain", gofakes3.WebsiteConfiguration{
+		IndexDocument: &gofakes3.WebsiteIndexDocument{Suffix: "index.html"},
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.SetBucketRequestPayment("plain", gofakes3.PayerRequester); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.SetBucketConfig("plain", "accelerate", []byte("<AccelerateConfiguration><Status>Enabled</Status></AccelerateConfiguration>")); err != nil {
+		t.Fatal(err)
+	}
+	days := gofakes3.LifecycleConfiguration{Rules: []gofakes3.LifecycleRule{
+		{ID: "expire", Status: gofakes3.LifecycleEnabled, Expiration: &gofakes3.LifecycleExpiration{Days: 1}},
+	}}
+	if err := db.SetBucketLifecycleConfiguration("plain", days); err != nil {
+		t.Fatal(err)
+	}
+
+	return db
+}
+
+func TestSnapshotRestore(t *testing.T) {
+	db := populatedBackend(t)
+
+	var buf bytes.Buffer
+	if err := db.Snapshot(&buf); err != nil {
+		t.Fatal(err)
+	}
+
+	restored := New()
+	if err := restored.Restore(bytes.NewReader(buf.Bytes())); err != nil {
+		t.Fatal(err)
+	}
+
+	buckets, err := db.ListBuckets()
+	if err != nil {
+		t.Fatal(err)
+	}
+	restoredBuckets, err := restored.ListBuckets()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, list := range [][]gofakes3.BucketInfo{buckets, restoredBuckets} {
+		sort.Slice(list, func(i, j int) bool { return list[i].Name < list[j].Name })
+	}
+	if !reflect.DeepEqual(buckets, restoredBuckets) {
+		t.Fatal(restoredBuckets, "!=", buckets)
+	}
+
+	for _, bucket := range []string{"plain", "versioned", "suspended"} {
+		versions, err := db.ListBucketVersions(bucket, nil, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		restoredVersions, err := restored.ListBucketVersions(bucket, nil, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(versions, restoredVersions) {
+			t.Fatal(bucket, restoredVersions, "!=", versions)
+		}
+
+		for _, item := range versions.Versions {
+			v, ok := item.(*gofakes3.Version)
+			if !ok {
+				continue
+			}
+			if got, want := getString(t, restored, bucket, v.Key, v.VersionID), getString(t, db, bucket, v.Key, v.VersionID); got != want {
+				t.Fatal(bucket, v.Key, v.VersionID, got, "!=", want)
+			}
+		}
+	}
+
+	obj, err := restored.HeadObject("plain", "a")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if obj.Metadata["X-Amz-Meta-Key"] != "a" {
+		t.Fatal("metadata not restored", obj.Metadata)
+	}
+	if tags, err := restored.GetObjectTagging("plain", "a", ""); err != nil || tags["tag"] != "value" {
+		t.Fatal("tags not restored", tags, err)
+	}
+	if hold, err := restored.GetObjectLegalHold("versioned", "locked", ""); err != nil || hold != gofakes3.LegalHoldOn {
+		t.Fatal("legal hold not restored", hold, err)
+	}
+	if _, err := restored.GetObject("versioned", "object", nil); !gofakes3.HasErrorCode(err, gofakes3.ErrNoSuchKey) {
+		t.Fatal("expected delete marker to be restored, found", err)
+	}
+	if got := getString(t, restored, "suspended", "object", "null"); got != "null" {
+		t.Fatal("null version not restored", got)
+	}
+
+	if policy, err := restored.BucketPolicy("plain"); err != nil || string(policy) != `{"Statement":[]}` {
+		t.Fatal("policy not restored", string(policy), err)
+	}
+	if tags, err := restored.BucketTagging("plain"); err != nil || tags["team"] != "storage" {
+		t.Fatal("tags not restored", tags, err)
+	}
+	if acl, err := restored.BucketACL("plain"); err != nil || acl == nil || len(acl.Grants) != 1 {
+		t.Fatal("acl not restored", acl, err)
+	}
+	for _, config := range []func(*Backend) (interface{}, error){
+		func(db *Backend) (interface{}, error) { return db.BucketCORS("plain") },
+		func(db *Backend) (interface{}, error) { return db.BucketWebsite("plain") },
+		func(db *Backend) (interface{}, error) { return db.BucketRequestPayment("plain") },
+		func(db *Backend) (interface{}, error) { return db.BucketConfig("plain", "accelerate") },
+		func(db *Backend) (interface{}, error) { return db.BucketLifecycleConfiguration("plain") },
+	} {
+		want, err := config(db)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got, err := config(restored)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(got, want) {
+			t.Fatal(got, "!=", want)
+		}
+	}
+
+	// Versions created after restoring must sort after the restored ones:
+	before, err := restored.ListBucketVersions("versioned", &gofakes3.Prefix{HasPrefix: true, Prefix: "object"}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	next := putString(t, restored, "versioned", "object", "v3")
+	for _, item := range before.Versions {
+		if item.GetVersionID() >= next {
+			t.Fatal("restored version", item.GetVersionID(), "does not sort before new version", next)
+		}
+	}
+	if got := getString(t, restored, "versioned", "object", ""); got != "v3" {
+		t.Fatal(got, "!= v3")
+	}
+}
+
+func TestRestoreInvalid(t *testing.T) {
+	db := populatedBackend(t)
+
+	var valid bytes.Buffer
+	if err := db.Snapshot(&valid); err != nil {
+		t.Fatal(err)
+	}
+
+	var wrongVersion bytes.Buffer
+	{
+		enc := gob.NewEncoder(&wrongVersion)
+		if err := enc.Encode(snapshotHeader{Format: snapshotFormat, Version: snapshotVersion + 1}); err != nil {
+			t.Fatal(err)
+		}
+		if err := enc.Encode(&snapshot{Buckets: []snapshotBucket{{Name: "other"}}}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	var missingBucket bytes.Buffer
+	{
+		enc := gob.NewEncoder(&missingBucket)
+		if err := enc.Encode(snapshotHeader{Format: snapshotFormat, Version: snapshotVersion}); err != nil {
+			t.Fatal(err)
+		}
+		if err := enc.Encode(&snapshot{
+			Buckets: []snapshotBucket{{Name: "other"}},
+			Uploads: []gofakes3.MultipartUploadState{{ID: "1", Bucket: "missing", Object: "object"}},
+		}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	for name, data := range map[string][]byte{
+		"empty":     nil,
+		"garbage":   []byte("this is not a snapshot"),
+		"truncated": valid.Bytes()[:valid.Len()/2],
+		"version":   wrongVersion.Bytes(),
+		"upload":    missingBucket.Bytes(),
+	} {
+		t.Run(name, func(t *testing.T) {
+			if err := db.Restore(bytes.NewReader(data)); err == nil {
+				t.Fatal("expected error")
+			} else if name == "version" && !strings.Contains(err.Error(), "unsupported snapshot version") {
+				t.Fatal("unexpected error", err)
+			}
+
+			// The backend must be unchanged:
+			if got := getString(t, db, "plain", "a", ""); got != "hello" {
+				t.Fatal(got, "!= hello")
+			}
+			if exists, _ := db.BucketExists("other"); exists {
+				t.Fatal("bucket from failed restore exists")
+			}
+		})
+	}
+}
+
+func TestPersistFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "gofakes3-s3mem-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	file := filepath.Join(dir, "snapshot")
+
+	db, err := Open(WithPersistFile(file))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := db.CreateBucket("bucket"); err != nil {
+		t.Fatal(err)
+	}
+	putString(t, db, "bucket", "object", "persisted")
+	if err := db.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	db, err = Open(WithPersistFile(file))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := getString(t, db, "bucket", "object", ""); got != "persisted" {
+		t.Fatal(got, "!= persisted")
+	}
+
+	if err := ioutil.WriteFile(file, []byte("corrupt"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := Open(WithPersistFile(file)); err == nil {
+		t.Fatal("expected error opening corrupt file")
+	}
+}
@@ -0,0 +1,99 @@
+package s3mem
+
+import (
+	"testing"
+	"time"
+
+	"github.com/johannesboyne/gofakes3"
+)
+
+func TestObjectTTL(t *testing.T) {
+	clock := gofakes3.FixedTimeSource(time.Date(2018, 1, 1, 0, 0, 0, 0, time.UTC))
+	db := New(WithTimeSource(clock), WithObjectTTL(time.Minute))
+	defer db.Close()
+
+	if err := db.CreateBucket("bucket"); err != nil {
+		t.Fatal(err)
+	}
+	putString(t, db, "bucket", "object", "hello")
+
+	clock.Advance(59 * time.Second)
+	if got := getString(t, db, "bucket", "object", ""); got != "hello" {
+		t.Fatal(got, "!= hello")
+	}
+	putString(t, db, "bucket", "later", "world")
+
+	clock.Advance(time.Second)
+	if _, err := db.GetObject("bucket", "object", nil); !gofakes3.HasErrorCode(err, gofakes3.ErrNoSuchKey) {
+		t.Fatal("expected ErrNoSuchKey, found", err)
+	}
+	if _, err := db.HeadObject("bucket", "object"); !gofakes3.HasErrorCode(err, gofakes3.ErrNoSuchKey) {
+		t.Fatal("expected ErrNoSuchKey, found", err)
+	}
+	list, err := db.ListBucket("bucket", nil, gofakes3.ListBucketPage{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(list.Contents) != 1 || list.Contents[0].Key != "later" {
+		t.Fatal("unexpected contents", list.Contents)
+	}
+
+	db.reclaimExpired()
+	if n := db.buckets["bucket"].objects.Len(); n != 1 {
+		t.Fatal("expected 1 object after reclaiming, found", n)
+	}
+
+	// Replacing an object restarts its TTL:
+	putString(t, db, "bucket", "object", "again")
+	clock.Advance(30 * time.Second)
+	if got := getString(t, db, "bucket", "object", ""); got != "again" {
+		t.Fatal(got, "!= again")
+	}
+
+	// A bucket containing only expired objects can be deleted:
+	clock.Advance(time.Minute)
+	if err := db.DeleteBucket("bucket"); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestObjectTTLVersions(t *testing.T) {
+	clock := gofakes3.FixedTimeSource(time.Date(2018, 1, 1, 0, 0, 0, 0, time.UTC))
+	db := New(WithTimeSource(clock), WithObjectTTL(time.Minute))
+	defer db.Close()
+
+	if err := db.CreateBucket("bucket"); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.SetVersioningConfiguration("bucket", gofakes3.VersioningConfiguration{Status: gofakes3.VersioningEnabled}); err != nil {
+		t.Fatal(err)
+	}
+
+	v1 := putString(t, db, "bucket", "object", "v1")
+	clock.Advance(30 * time.Second)
+	v2 := putString(t, db, "bucket", "object", "v2")
+	clock.Advance(30 * time.Second)
+
+	if _, err := db.GetObjectVersion("bucket", "object", v1, nil); !gofakes3.HasErrorCode(err, gofakes3.ErrNoSuchVersion) {
+		t.Fatal("expected ErrNoSuchVersion, found", err)
+	}
+	if got := getString(t, db, "bucket", "object", v2); got != "v2" {
+		t.Fatal(got, "!= v2")
+	}
+	versions, err := db.ListBucketVersions("bucket", nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(versions.Versions) != 1 || versions.Versions[0].GetVersionID() != v2 {
+		t.Fatal("unexpected versions", versions.Versions)
+	}
+
+	clock.Advance(30 * time.Second)
+	db.reclaimExpired()
+	if _, err := db.GetObject("bucket", "object", nil); !gofakes3.HasErrorCode(err, gofakes3.ErrNoSuchKey) {
+		t.Fatal("expected ErrNoSuchKey, found", err)
+	}
+	if n := db.buckets["bucket"].objects.Len(); n != 0 {
+		t.Fatal("expected no objects after reclaiming, found", n)
+	}
+}
@@ -65,3 +65,22 @@ func (v *versionGenerator) Next(scratch []byte) (gofakes3.VersionID, []byte) {
 
 	return gofakes3.VersionID(fmt.Sprintf("3/%s", base32.HexEncoding.EncodeToString(scratch))), scratch
 }
+
+// snapshot returns the generator's state, so that a restored generator
+// continues the same sequence of IDs.
+func (v *versionGenerator) snapshot() (state uint64, next *big.Int) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	return v.state, new(big.Int).Set(v.next)
+}
+
+func (v *versionGenerator) restore(state uint64, next *big.Int) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	v.state = state
+	if next != nil {
+		v.next = new(big.Int).Set(next)
+	} else {
+		v.next = new(big.Int)
+	}
+}
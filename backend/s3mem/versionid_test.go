@@ -1,6 +1,8 @@
 package s3mem
 
 import (
+	"fmt"
+	"reflect"
 	"strings"
 	"testing"
 
@@ -19,3 +21,75 @@ func TestVersionID(t *testing.T) {
 		last = next
 	}
 }
+
+func TestWithVersionGenerator(t *testing.T) {
+	var n int
+	db := New(WithVersionGenerator(func() string {
+		n++
+		return fmt.Sprintf("v%d", n)
+	}))
+	if err := db.CreateBucket("bucket"); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.SetVersioningConfiguration("bucket", gofakes3.VersioningConfiguration{Status: gofakes3.VersioningEnabled}); err != nil {
+		t.Fatal(err)
+	}
+
+	// More than 9 versions, so that the IDs do not sort in the order they
+	// were generated:
+	var expected []gofakes3.VersionID
+	for i := 1; i <= 11; i++ {
+		contents := fmt.Sprintf("body %d", i)
+		if v := putString(t, db, "bucket", "object", contents); v != gofakes3.VersionID(fmt.Sprintf("v%d", i)) {
+			t.Fatal("unexpected version", v)
+		}
+		expected = append([]gofakes3.VersionID{gofakes3.VersionID(fmt.Sprintf("v%d", i))}, expected...)
+	}
+	if got := getString(t, db, "bucket", "object", "v2"); got != "body 2" {
+		t.Fatal(got, "!= body 2")
+	}
+	if got := getString(t, db, "bucket", "object", ""); got != "body 11" {
+		t.Fatal(got, "!= body 11")
+	}
+
+	// The null version does not use the generator:
+	if err := db.SetVersioningConfiguration("bucket", gofakes3.VersioningConfiguration{Status: gofakes3.VersioningSuspended}); err != nil {
+		t.Fatal(err)
+	}
+	if v := putString(t, db, "bucket", "object", "null"); v != "null" || n != 11 {
+		t.Fatal("unexpected version", v, "after", n, "generated")
+	}
+	expected = append([]gofakes3.VersionID{"null"}, expected...)
+
+	versions, err := db.ListBucketVersions("bucket", nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var found []gofakes3.VersionID
+	for _, item := range versions.Versions {
+		found = append(found, item.GetVersionID())
+	}
+	if !reflect.DeepEqual(found, expected) {
+		t.Fatal("versions not newest first:", found)
+	}
+
+	// Pages continue after the version ID marker:
+	page, err := db.ListBucketVersions("bucket", nil, &gofakes3.ListBucketVersionsPage{
+		KeyMarker: "object", HasKeyMarker: true,
+		VersionIDMarker: "v10", HasVersionIDMarker: true,
+		MaxKeys: 2,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(page.Versions) != 2 || page.Versions[0].GetVersionID() != "v9" || page.Versions[1].GetVersionID() != "v8" {
+		t.Fatal("unexpected page", page.Versions)
+	}
+
+	if _, err := db.DeleteObjectVersion("bucket", "object", "v10"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.GetObjectVersion("bucket", "object", "v10", nil); !gofakes3.HasErrorCode(err, gofakes3.ErrNoSuchVersion) {
+		t.Fatal("expected ErrNoSuchVersion, found", err)
+	}
+}
@@ -0,0 +1,415 @@
+package s3redis
+
+import (
+	"context"
+	"crypto/md5"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/johannesboyne/gofakes3"
+	"github.com/johannesboyne/gofakes3/internal/s3io"
+	"github.com/redis/go-redis/v9"
+)
+
+var (
+	emptyPrefix = &gofakes3.Prefix{}
+)
+
+// Backend stores buckets and objects in Redis, so that several GoFakeS3
+// servers can share them.
+//
+// Object contents are written and read in chunks rather than all at once.
+// Versioning is not supported.
+//
+// The keys used by a Backend are described in schema.go. All of them are
+// accessed from Lua scripts that do not declare every key they use, so Redis
+// Cluster is not supported.
+type Backend struct {
+	client     *redis.Client
+	prefix     string
+	timeSource gofakes3.TimeSource
+}
+
+var _ gofakes3.Backend = &Backend{}
+
+type Option func(b *Backend)
+
+func WithTimeSource(timeSource gofakes3.TimeSource) Option {
+	return func(b *Backend) { b.timeSource = timeSource }
+}
+
+// WithKeyPrefix sets the string that begins every key the Backend uses,
+// which is "gofakes3" by default. Backends with different prefixes can share
+// a Redis database without seeing each other's buckets.
+func WithKeyPrefix(prefix string) Option {
+	return func(b *Backend) { b.prefix = prefix }
+}
+
+// New creates a Backend that uses an existing Redis client, so the caller
+// controls its connection settings. The client is not closed by the Backend.
+func New(client *redis.Client, opts ...Option) *Backend {
+	b := &Backend{
+		client: client,
+		prefix: "gofakes3",
+	}
+	for _, opt := range opts {
+		opt(b)
+	}
+	if b.timeSource == nil {
+		b.timeSource = gofakes3.DefaultTimeSource()
+	}
+	return b
+}
+
+func (db *Backend) ListBuckets() ([]gofakes3.BucketInfo, error) {
+	ctx := context.Background()
+
+	all, err := db.client.HGetAll(ctx, db.bucketsKey()).Result()
+	if err != nil {
+		return nil, err
+	}
+
+	buckets := make([]gofakes3.BucketInfo, 0, len(all))
+	for name, created := range all {
+		at, err := decodeTime(created)
+		if err != nil {
+			return nil, fmt.Errorf("gofakes3: could not parse creation date of bucket %q: %v", name, err)
+		}
+		buckets = append(buckets, gofakes3.BucketInfo{
+			Name:         name,
+			CreationDate: gofakes3.NewContentTime(at),
+		})
+	}
+
+	sort.Slice(buckets, func(i, j int) bool { return buckets[i].Name < buckets[j].Name })
+	return buckets, nil
+}
+
+func (db *Backend) ListBucket(name string, prefix *gofakes3.Prefix, page gofakes3.ListBucketPage) (*gofakes3.ObjectList, error) {
+	if prefix == nil {
+		prefix = emptyPrefix
+	}
+
+	ctx := context.Background()
+
+	if exists, err := db.BucketExists(name); err != nil {
+		return nil, err
+	} else if !exists {
+		return nil, gofakes3.BucketNotFound(name)
+	}
+
+	// Keys are all stored with the same score, so they can be scanned in
+	// lexicographical order with ZRANGEBYLEX, starting after the marker:
+	min, max := "-", "+"
+	if prefix.HasPrefix && prefix.Prefix != "" {
+		min = "[" + prefix.Prefix
+		max = "(" + prefix.Prefix + "\xff" // No UTF-8 key contains 0xff
+	}
+	if page.HasMarker && (min == "-" || page.Marker >= prefix.Prefix) {
+		min = "(" + page.Marker
+	}
+
+	var keys []string
+	var done bool
+	next := func() (string, bool, error) {
+		for len(keys) == 0 {
+			if done {
+				return "", false, nil
+			}
+			var err error
+			keys, err = db.client.ZRangeByLex(ctx, db.keysKey(name), &redis.ZRangeBy{
+				Min: min, Max: max, Count: listBatchSize,
+			}).Result()
+			if err != nil {
+				return "", false, err
+			}
+			done = len(keys) < listBatchSize
+			if len(keys) > 0 {
+				min = "(" + keys[len(keys)-1]
+			}
+		}
+		key := keys[0]
+		keys = keys[1:]
+		return key, true, nil
+	}
+
+	return gofakes3.ListBucketKeys(prefix, page, next, func(key string) (*gofakes3.Content, error) {
+		obj, err := db.object(ctx, name, key)
+		if err != nil {
+			return nil, err
+		} else if obj == nil {
+			return nil, nil // Deleted since the keys were listed
+		}
+		return &gofakes3.Content{
+			Key:          key,
+			LastModified: gofakes3.NewContentTime(obj.modified),
+			ETag:         gofakes3.ObjectETag(obj.hash, obj.meta),
+			StorageClass: gofakes3.ObjectStorageClass(obj.meta),
+			Size:         obj.size,
+		}, nil
+	})
+}
+
+func (db *Backend) CreateBucket(name string) error {
+	created, err := db.client.HSetNX(context.Background(), db.bucketsKey(), name, encodeTime(db.timeSource.Now())).Result()
+	if err != nil {
+		return err
+	}
+	if !created {
+		return gofakes3.ResourceError(gofakes3.ErrBucketAlreadyExists, name)
+	}
+	return nil
+}
+
+func (db *Backend) DeleteBucket(name string) error {
+	err := deleteBucketScript.Run(context.Background(), db.client,
+		[]string{db.bucketsKey(), db.keysKey(name)},
+		name).Err()
+	return db.scriptError(err, name)
+}
+
+func (db *Backend) BucketExists(name string) (exists bool, err error) {
+	return db.client.HExists(context.Background(), db.bucketsKey(), name).Result()
+}
+
+func (db *Backend) HeadObject(bucketName, objectName string) (*gofakes3.Object, error) {
+	obj, err := db.getObject(bucketName, objectName)
+	if err != nil {
+		return nil, err
+	}
+
+	return &gofakes3.Object{
+		Name:         objectName,
+		Metadata:     obj.meta,
+		Size:         obj.size,
+		Contents:     s3io.NoOpReadCloser{},
+		Hash:         obj.hash,
+		LastModified: obj.modified,
+	}, nil
+}
+
+func (db *Backend) GetObject(bucketName, objectName string, rangeRequest *gofakes3.ObjectRangeRequest) (*gofakes3.Object, error) {
+	obj, err := db.getObject(bucketName, objectName)
+	if err != nil {
+		return nil, err
+	}
+
+	rnge, err := rangeRequest.Range(obj.size)
+	if err != nil {
+		return nil, err
+	}
+
+	contents := &dataReader{
+		client: db.client,
+		key:    obj.dataKey,
+		remain: obj.size,
+	}
+	if rnge != nil {
+		contents.offset, contents.remain = rnge.Start, rnge.Length
+	}
+
+	return &gofakes3.Object{
+		Name:         objectName,
+		Metadata:     obj.meta,
+		Size:         obj.size,
+		Contents:     contents,
+		Range:        rnge,
+		Hash:         obj.hash,
+		LastModified: obj.modified,
+	}, nil
+}
+
+func (db *Backend) PutObject(
+	bucketName, objectName string,
+	meta map[string]string,
+	input io.Reader, size int64,
+) (result gofakes3.PutObjectResult, err error) {
+
+	ctx := context.Background()
+
+	if exists, err := db.BucketExists(bucketName); err != nil {
+		return result, err
+	} else if !exists {
+		return result, gofakes3.BucketNotFound(bucketName)
+	}
+
+	err = gofakes3.MergeMetadata(db, bucketName, objectName, meta)
+	if err != nil {
+		return result, err
+	}
+	metaJSON, err := encodeMeta(meta)
+	if err != nil {
+		return result, err
+	}
+
+	id, err := db.client.Incr(ctx, db.idsKey()).Result()
+	if err != nil {
+		return result, err
+	}
+	dataKey := db.dataKey(id)
+
+	hash, err := db.writeData(ctx, dataKey, input, size)
+	if err == nil {
+		err = putScript.Run(ctx, db.client,
+			[]string{db.bucketsKey(), db.keysKey(bucketName), db.objectKey(bucketName, objectName), dataKey},
+			bucketName, objectName, size, encodeTime(db.timeSource.Now()), hex.EncodeToString(hash), metaJSON,
+			staleDataTTL.Milliseconds()).Err()
+	}
+	if err != nil {
+		if derr := db.client.Del(ctx, dataKey).Err(); derr != nil {
+			log.Println("gofakes3: could not delete data of failed upload:", derr)
+		}
+		return result, db.scriptError(err, bucketName)
+	}
+
+	return result, nil
+}
+
+// writeData copies exactly size bytes from input to key in chunks, returning
+// the MD5 hash of the data. Like gofakes3.ReadAll(), it reads input until
+// io.EOF, so that any checks performed by the reader at the end of the input
+// are applied.
+func (db *Backend) writeData(ctx context.Context, key string, input io.Reader, size int64) (hash []byte, err error) {
+	hasher := md5.New()
+	buf := make([]byte, chunkSize)
+	var written int64
+
+	for {
+		n, rerr := io.ReadFull(input, buf)
+		if n > 0 {
+			written += int64(n)
+			if written > size {
+				return nil, gofakes3.ErrIncompleteBody
+			}
+			hasher.Write(buf[:n])
+
+			_, err := db.client.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+				pipe.Append(ctx, key, string(buf[:n]))
+				pipe.PExpire(ctx, key, pendingDataTTL)
+				return nil
+			})
+			if err != nil {
+				return nil, err
+			}
+		}
+
+		if rerr == io.EOF || rerr == io.ErrUnexpectedEOF {
+			break
+		} else if rerr != nil {
+			return nil, rerr
+		}
+	}
+
+	if written != size {
+		return nil, gofakes3.ErrIncompleteBody
+	}
+	return hasher.Sum(nil), nil
+}
+
+func (db *Backend) DeleteObject(bucketName, objectName string) (result gofakes3.ObjectDeleteResult, rerr error) {
+	err := deleteScript.Run(context.Background(), db.client,
+		[]string{db.bucketsKey(), db.keysKey(bucketName), db.objectKey(bucketName, objectName)},
+		bucketName, objectName, staleDataTTL.Milliseconds()).Err()
+	return result, db.scriptError(err, bucketName)
+}
+
+func (db *Backend) DeleteMulti(bucketName string, objects ...string) (result gofakes3.MultiDeleteResult, err error) {
+	if exists, err := db.BucketExists(bucketName); err != nil {
+		return result, err
+	} else if !exists {
+		return result, gofakes3.BucketNotFound(bucketName)
+	}
+
+	for _, object := range objects {
+		if _, err := db.DeleteObject(bucketName, object); err != nil {
+			log.Println("delete object failed:", err)
+			result.Error = append(result.Error, gofakes3.ErrorResult{
+				Code:    gofakes3.ErrInternal,
+				Message: gofakes3.ErrInternal.Message(),
+				Key:     object,
+			})
+
+		} else {
+			result.Deleted = append(result.Deleted, gofakes3.ObjectID{
+				Key: object,
+			})
+		}
+	}
+
+	return result, nil
+}
+
+// getObject fetches the fields of an object, returning ErrNoSuchKey or
+// ErrNoSuchBucket if it does not exist.
+func (db *Backend) getObject(bucketName, objectName string) (*redisObject, error) {
+	obj, err := db.object(context.Background(), bucketName, objectName)
+	if err != nil {
+		return nil, err
+	}
+	if obj == nil {
+		if exists, err := db.BucketExists(bucketName); err != nil {
+			return nil, err
+		} else if !exists {
+			return nil, gofakes3.BucketNotFound(bucketName)
+		}
+		return nil, gofakes3.KeyNotFound(objectName)
+	}
+	return obj, nil
+}
+
+// object fetches the fields of an object, or nil if it does not exist.
+func (db *Backend) object(ctx context.Context, bucketName, objectName string) (*redisObject, error) {
+	vals, err := db.client.HMGet(ctx, db.objectKey(bucketName, objectName), objectFields...).Result()
+	if err != nil {
+		return nil, err
+	}
+
+	fields := make(map[string]string, len(objectFields))
+	for i, v := range vals {
+		if s, ok := v.(string); ok {
+			fields[objectFields[i]] = s
+		}
+	}
+	if fields[fieldData] == "" {
+		return nil, nil
+	}
+
+	obj := &redisObject{dataKey: fields[fieldData]}
+	if obj.size, err = strconv.ParseInt(fields[fieldSize], 10, 64); err == nil {
+		if obj.modified, err = decodeTime(fields[fieldModified]); err == nil {
+			if obj.hash, err = hex.DecodeString(fields[fieldHash]); err == nil {
+				err = json.Unmarshal([]byte(fields[fieldMeta]), &obj.meta)
+			}
+		}
+	}
+	if err != nil {
+		return nil, fmt.Errorf("gofakes3: could not decode object at %q/%q: %v", bucketName, objectName, err)
+	}
+
+	return obj, nil
+}
+
+// scriptError converts the errors returned by the scripts in schema.go into
+// the errors expected from a gofakes3.Backend.
+func (db *Backend) scriptError(err error, bucketName string) error {
+	var rerr redis.Error
+	if err == nil || !errors.As(err, &rerr) {
+		return err
+	}
+	// Redis may add a generic error code to the script's message:
+	switch strings.TrimPrefix(rerr.Error(), "ERR ") {
+	case scriptNoSuchBucket:
+		return gofakes3.BucketNotFound(bucketName)
+	case scriptBucketNotEmpty:
+		return gofakes3.ResourceError(gofakes3.ErrBucketNotEmpty, bucketName)
+	default:
+		return err
+	}
+}
@@ -0,0 +1,311 @@
+package s3redis
+
+import (
+	"bytes"
+	"crypto/md5"
+	"io/ioutil"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/johannesboyne/gofakes3"
+	"github.com/johannesboyne/gofakes3/gofakes3test"
+	"github.com/redis/go-redis/v9"
+)
+
+func testingBackend(t *testing.T, opts ...Option) (*Backend, *miniredis.Miniredis) {
+	t.Helper()
+
+	srv := miniredis.RunT(t)
+	client := redis.NewClient(&redis.Options{Addr: srv.Addr()})
+	t.Cleanup(func() { client.Close() })
+
+	backend := New(client, opts...)
+	if err := backend.CreateBucket("test"); err != nil {
+		t.Fatal(err)
+	}
+	return backend, srv
+}
+
+func putString(t *testing.T, backend *Backend, bucket, key, contents string) {
+	t.Helper()
+	if _, err := backend.PutObject(bucket, key, nil, strings.NewReader(contents), int64(len(contents))); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestConformance(t *testing.T) {
+	timeSource := gofakes3.FixedTimeSource(time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC))
+	gofakes3test.RunBackendConformance(t, func(t *testing.T) gofakes3.Backend {
+		srv := miniredis.RunT(t)
+		client := redis.NewClient(&redis.Options{Addr: srv.Addr()})
+		t.Cleanup(func() { client.Close() })
+		return New(client, WithTimeSource(timeSource))
+	}, gofakes3test.WithTimeSource(timeSource))
+}
+
+func TestPutGet(t *testing.T) {
+	backend, _ := testingBackend(t)
+
+	meta := map[string]string{
+		"foo": "bar",
+	}
+
+	// Large enough to need several chunks:
+	contents := bytes.Repeat([]byte("0123456789"), chunkSize/4)
+	if _, err := backend.PutObject("test", "yep", meta, bytes.NewReader(contents), int64(len(contents))); err != nil {
+		t.Fatal(err)
+	}
+	hash := md5.Sum(contents)
+
+	obj, err := backend.GetObject("test", "yep", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer obj.Contents.Close()
+
+	if !reflect.DeepEqual(obj.Metadata, meta) {
+		t.Fatal(obj.Metadata, "!=", meta)
+	}
+	result, err := ioutil.ReadAll(obj.Contents)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(contents, result) {
+		t.Fatal("contents do not match; found", len(result), "bytes, expected", len(contents))
+	}
+	if obj.Size != int64(len(contents)) {
+		t.Fatal(obj.Size, "!=", len(contents))
+	}
+	if !bytes.Equal(obj.Hash, hash[:]) {
+		t.Fatal(obj.Hash, "!=", hash)
+	}
+
+	rnge := &gofakes3.ObjectRangeRequest{Start: chunkSize - 5, End: chunkSize + 4}
+	obj, err = backend.GetObject("test", "yep", rnge)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer obj.Contents.Close()
+	result, err = ioutil.ReadAll(obj.Contents)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expected := contents[chunkSize-5 : chunkSize+5]; !bytes.Equal(result, expected) {
+		t.Fatal(string(result), "!=", string(expected))
+	}
+
+	head, err := backend.HeadObject("test", "yep")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if head.Size != int64(len(contents)) || !reflect.DeepEqual(head.Metadata, meta) {
+		t.Fatal("unexpected head", head)
+	}
+}
+
+func TestPutEmpty(t *testing.T) {
+	backend, _ := testingBackend(t)
+	putString(t, backend, "test", "empty", "")
+
+	obj, err := backend.GetObject("test", "empty", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer obj.Contents.Close()
+	result, err := ioutil.ReadAll(obj.Contents)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(result) != 0 || obj.Size != 0 {
+		t.Fatal("expected empty object, found", len(result), obj.Size)
+	}
+}
+
+func TestPutIncomplete(t *testing.T) {
+	backend, srv := testingBackend(t)
+
+	_, err := backend.PutObject("test", "short", nil, strings.NewReader("abc"), 4)
+	if !gofakes3.HasErrorCode(err, gofakes3.ErrIncompleteBody) {
+		t.Fatal("expected ErrIncompleteBody, found", err)
+	}
+	_, err = backend.PutObject("test", "long", nil, strings.NewReader("abcde"), 4)
+	if !gofakes3.HasErrorCode(err, gofakes3.ErrIncompleteBody) {
+		t.Fatal("expected ErrIncompleteBody, found", err)
+	}
+
+	if _, err := backend.HeadObject("test", "short"); !gofakes3.HasErrorCode(err, gofakes3.ErrNoSuchKey) {
+		t.Fatal("expected ErrNoSuchKey, found", err)
+	}
+	for _, key := range srv.Keys() {
+		if strings.HasPrefix(key, "gofakes3:data:") {
+			t.Fatal("data of failed upload was not removed:", key)
+		}
+	}
+}
+
+func TestPutMissingBucket(t *testing.T) {
+	backend, _ := testingBackend(t)
+	_, err := backend.PutObject("nope", "object", nil, strings.NewReader("abc"), 3)
+	if !gofakes3.HasErrorCode(err, gofakes3.ErrNoSuchBucket) {
+		t.Fatal("expected ErrNoSuchBucket, found", err)
+	}
+	if _, err := backend.GetObject("nope", "object", nil); !gofakes3.HasErrorCode(err, gofakes3.ErrNoSuchBucket) {
+		t.Fatal("expected ErrNoSuchBucket, found", err)
+	}
+}
+
+func TestReplaceWhileReading(t *testing.T) {
+	backend, srv := testingBackend(t)
+	putString(t, backend, "test", "object", "original")
+
+	obj, err := backend.GetObject("test", "object", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer obj.Contents.Close()
+
+	putString(t, backend, "test", "object", "replaced")
+
+	// The replaced contents are still readable for a while:
+	result, err := ioutil.ReadAll(obj.Contents)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(result) != "original" {
+		t.Fatal(string(result), "!= original")
+	}
+
+	obj, err = backend.GetObject("test", "object", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer obj.Contents.Close()
+	if _, err := backend.DeleteObject("test", "object"); err != nil {
+		t.Fatal(err)
+	}
+	srv.FastForward(staleDataTTL + 1)
+
+	if _, err := ioutil.ReadAll(obj.Contents); err == nil {
+		t.Fatal("expected error reading expired contents")
+	}
+	if _, err := backend.HeadObject("test", "object"); !gofakes3.HasErrorCode(err, gofakes3.ErrNoSuchKey) {
+		t.Fatal("expected ErrNoSuchKey, found", err)
+	}
+}
+
+func TestBuckets(t *testing.T) {
+	backend, _ := testingBackend(t)
+
+	if err := backend.CreateBucket("test"); !gofakes3.HasErrorCode(err, gofakes3.ErrBucketAlreadyExists) {
+		t.Fatal("expected ErrBucketAlreadyExists, found", err)
+	}
+	if err := backend.CreateBucket("another"); err != nil {
+		t.Fatal(err)
+	}
+
+	buckets, err := backend.ListBuckets()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(buckets) != 2 || buckets[0].Name != "another" || buckets[1].Name != "test" {
+		t.Fatal("unexpected buckets", buckets)
+	}
+
+	putString(t, backend, "test", "object", "hello")
+	if err := backend.DeleteBucket("test"); !gofakes3.HasErrorCode(err, gofakes3.ErrBucketNotEmpty) {
+		t.Fatal("expected ErrBucketNotEmpty, found", err)
+	}
+	if err := backend.DeleteBucket("nope"); !gofakes3.HasErrorCode(err, gofakes3.ErrNoSuchBucket) {
+		t.Fatal("expected ErrNoSuchBucket, found", err)
+	}
+
+	result, err := backend.DeleteMulti("test", "object", "missing")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(result.Deleted) != 2 || len(result.Error) != 0 {
+		t.Fatal("unexpected result", result)
+	}
+	if err := backend.DeleteBucket("test"); err != nil {
+		t.Fatal(err)
+	}
+	if exists, err := backend.BucketExists("test"); err != nil || exists {
+		t.Fatal("bucket still exists", err)
+	}
+}
+
+func TestListBucketPage(t *testing.T) {
+	backend, _ := testingBackend(t)
+	for _, key := range []string{"a", "b/1", "b/2", "c", "d/1", "e"} {
+		putString(t, backend, "test", key, key)
+	}
+
+	list := func(prefix *gofakes3.Prefix, page gofakes3.ListBucketPage) (keys []string, result *gofakes3.ObjectList) {
+		t.Helper()
+		result, err := backend.ListBucket("test", prefix, page)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, p := range result.CommonPrefixes {
+			keys = append(keys, p.Prefix)
+		}
+		for _, c := range result.Contents {
+			keys = append(keys, c.Key)
+		}
+		return keys, result
+	}
+
+	keys, _ := list(nil, gofakes3.ListBucketPage{})
+	if expected := []string{"a", "b/1", "b/2", "c", "d/1", "e"}; !reflect.DeepEqual(keys, expected) {
+		t.Fatal(keys, "!=", expected)
+	}
+
+	delim := &gofakes3.Prefix{HasDelimiter: true, Delimiter: "/"}
+	var all []string
+	page := gofakes3.ListBucketPage{MaxKeys: 2}
+	for {
+		keys, result := list(delim, page)
+		all = append(all, keys...)
+		if !result.IsTruncated {
+			break
+		}
+		page.Marker, page.HasMarker = result.NextMarker, true
+	}
+	sort.Strings(all)
+	if expected := []string{"a", "b/", "c", "d/", "e"}; !reflect.DeepEqual(all, expected) {
+		t.Fatal(all, "!=", expected)
+	}
+
+	keys, result := list(&gofakes3.Prefix{HasPrefix: true, Prefix: "b/"}, gofakes3.ListBucketPage{MaxKeys: 2})
+	if expected := []string{"b/1", "b/2"}; !reflect.DeepEqual(keys, expected) || result.IsTruncated {
+		t.Fatal(keys, "!=", expected, result.IsTruncated)
+	}
+}
+
+func TestSharedDatabase(t *testing.T) {
+	backend, srv := testingBackend(t)
+	putString(t, backend, "test", "object", "shared")
+
+	client := redis.NewClient(&redis.Options{Addr: srv.Addr()})
+	defer client.Close()
+
+	other := New(client)
+	obj, err := other.GetObject("test", "object", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer obj.Contents.Close()
+	result, err := ioutil.ReadAll(obj.Contents)
+	if err != nil || string(result) != "shared" {
+		t.Fatal("unexpected contents", string(result), err)
+	}
+
+	isolated := New(client, WithKeyPrefix("other"))
+	if exists, err := isolated.BucketExists("test"); err != nil || exists {
+		t.Fatal("bucket visible with a different prefix", err)
+	}
+}
@@ -0,0 +1,11 @@
+module github.com/johannesboyne/gofakes3/backend/s3redis
+
+go 1.16
+
+require (
+	github.com/alicebob/miniredis/v2 v2.32.1
+	github.com/johannesboyne/gofakes3 v0.0.0-00010101000000-000000000000
+	github.com/redis/go-redis/v9 v9.7.3
+)
+
+replace github.com/johannesboyne/gofakes3 => ../../
@@ -0,0 +1,49 @@
+package s3redis
+
+import (
+	"context"
+	"io"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// dataReader reads the contents of an object from a data key in chunks, using
+// GETRANGE.
+type dataReader struct {
+	client *redis.Client
+	key    string
+	offset int64
+	remain int64
+}
+
+func (r *dataReader) Read(p []byte) (n int, err error) {
+	if r.remain <= 0 {
+		return 0, io.EOF
+	}
+	if int64(len(p)) > r.remain {
+		p = p[:r.remain]
+	}
+	if len(p) > chunkSize {
+		p = p[:chunkSize]
+	}
+
+	data, err := r.client.GetRange(context.Background(), r.key, r.offset, r.offset+int64(len(p))-1).Result()
+	if err != nil {
+		return 0, err
+	}
+	if len(data) == 0 {
+		// The contents expired before they could be read, which happens if
+		// the object was replaced or deleted long enough ago:
+		return 0, io.ErrUnexpectedEOF
+	}
+
+	n = copy(p, data)
+	r.offset += int64(n)
+	r.remain -= int64(n)
+	return n, nil
+}
+
+func (r *dataReader) Close() error {
+	r.remain = 0
+	return nil
+}
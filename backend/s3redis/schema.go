@@ -0,0 +1,160 @@
+package s3redis
+
+// The layout of the keys in Redis is described in here. External users of the
+// data should consider this an internal implementation detail, subject to
+// change without notice or version number changes.
+//
+// All keys begin with the prefix passed to WithKeyPrefix ("gofakes3" by
+// default). S3 bucket names may not contain a ':', so the bucket name always
+// ends at the first ':' that follows it:
+//
+//	<prefix>:buckets                  Hash of bucket name to creation time (unix nanoseconds)
+//	<prefix>:bucket:<bucket>:keys     Sorted set of the object keys in the bucket, all with score 0
+//	<prefix>:object:<bucket>:<key>    Hash of the object's fields (see objectFields)
+//	<prefix>:data:<id>                String containing the object's contents
+//	<prefix>:ids                      Counter used to allocate data IDs
+//
+// Object contents are stored separately from the object so that they can be
+// written and read in chunks. Each PutObject writes its contents to a new data
+// key, which is swapped into the object when the upload is complete. The
+// replaced data key is not deleted straight away, but left to expire after
+// staleDataTTL so readers that are part way through it can finish.
+
+import (
+	"encoding/json"
+	"strconv"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+const (
+	// chunkSize is the amount of object data written or read with each
+	// command, so that large objects are not held in memory all at once.
+	chunkSize = 1 << 20
+
+	// listBatchSize is the number of keys fetched at once by ListBucket.
+	listBatchSize = 1000
+
+	// staleDataTTL is how long the contents of an object that has been
+	// replaced or deleted remain readable.
+	staleDataTTL = time.Minute
+
+	// pendingDataTTL limits how long the contents of an upload that never
+	// completes (for example, because the process exited) are kept.
+	pendingDataTTL = time.Hour
+)
+
+// Hash fields of an object key:
+const (
+	fieldData     = "data"     // Key containing the contents
+	fieldSize     = "size"     // Size of the contents in bytes
+	fieldModified = "modified" // Last modified time (unix nanoseconds)
+	fieldHash     = "hash"     // MD5 hash of the contents, hex encoded
+	fieldMeta     = "meta"     // Metadata, JSON encoded
+)
+
+var objectFields = []string{fieldData, fieldSize, fieldModified, fieldHash, fieldMeta}
+
+// Errors returned by the scripts with redis.error_reply():
+const (
+	scriptNoSuchBucket   = "NoSuchBucket"
+	scriptBucketNotEmpty = "BucketNotEmpty"
+)
+
+func (db *Backend) bucketsKey() string { return db.prefix + ":buckets" }
+func (db *Backend) idsKey() string     { return db.prefix + ":ids" }
+
+func (db *Backend) keysKey(bucket string) string {
+	return db.prefix + ":bucket:" + bucket + ":keys"
+}
+
+func (db *Backend) objectKey(bucket, object string) string {
+	return db.prefix + ":object:" + bucket + ":" + object
+}
+
+func (db *Backend) dataKey(id int64) string {
+	return db.prefix + ":data:" + strconv.FormatInt(id, 10)
+}
+
+// putScript replaces an object with a completed upload.
+//
+//	KEYS: buckets, bucket keys, object, new data
+//	ARGV: bucket, object, size, modified, hash, meta, stale TTL (ms)
+var putScript = redis.NewScript(`
+if redis.call('HEXISTS', KEYS[1], ARGV[1]) == 0 then
+	return redis.error_reply('NoSuchBucket')
+end
+local old = redis.call('HGET', KEYS[3], 'data')
+if old and old ~= KEYS[4] then
+	redis.call('PEXPIRE', old, ARGV[7])
+end
+redis.call('PERSIST', KEYS[4])
+redis.call('DEL', KEYS[3])
+redis.call('HSET', KEYS[3],
+	'data', KEYS[4], 'size', ARGV[3], 'modified', ARGV[4], 'hash', ARGV[5], 'meta', ARGV[6])
+redis.call('ZADD', KEYS[2], 0, ARGV[2])
+return 1
+`)
+
+// deleteScript deletes an object if it exists.
+//
+//	KEYS: buckets, bucket keys, object
+//	ARGV: bucket, object, stale TTL (ms)
+var deleteScript = redis.NewScript(`
+if redis.call('HEXISTS', KEYS[1], ARGV[1]) == 0 then
+	return redis.error_reply('NoSuchBucket')
+end
+local old = redis.call('HGET', KEYS[3], 'data')
+if old then
+	redis.call('PEXPIRE', old, ARGV[3])
+end
+redis.call('DEL', KEYS[3])
+redis.call('ZREM', KEYS[2], ARGV[2])
+return 1
+`)
+
+// deleteBucketScript deletes a bucket if it exists and is empty.
+//
+//	KEYS: buckets, bucket keys
+//	ARGV: bucket
+var deleteBucketScript = redis.NewScript(`
+if redis.call('HEXISTS', KEYS[1], ARGV[1]) == 0 then
+	return redis.error_reply('NoSuchBucket')
+end
+if redis.call('ZCARD', KEYS[2]) > 0 then
+	return redis.error_reply('BucketNotEmpty')
+end
+redis.call('HDEL', KEYS[1], ARGV[1])
+redis.call('DEL', KEYS[2])
+return 1
+`)
+
+// redisObject holds the fields of an object key, without its contents.
+type redisObject struct {
+	dataKey  string
+	size     int64
+	modified time.Time
+	hash     []byte
+	meta     map[string]string
+}
+
+func encodeMeta(meta map[string]string) (string, error) {
+	if meta == nil {
+		meta = map[string]string{}
+	}
+	bts, err := json.Marshal(meta)
+	return string(bts), err
+}
+
+func encodeTime(t time.Time) string {
+	return strconv.FormatInt(t.UnixNano(), 10)
+}
+
+func decodeTime(s string) (time.Time, error) {
+	ns, err := strconv.ParseInt(s, 10, 64)
+	if err != nil {
+		return time.Time{}, err
+	}
+	return time.Unix(0, ns).UTC(), nil
+}
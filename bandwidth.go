@@ -0,0 +1,114 @@
+package gofakes3
+
+import (
+	"context"
+	"net/http"
+	"time"
+)
+
+// throttle returns w, limited to the rate passed to WithBandwidthLimit while
+// responding to r. If there is no limit, w is returned unchanged.
+func (g *GoFakeS3) throttle(w http.ResponseWriter, r *http.Request) http.ResponseWriter {
+	if g.bandwidthLimit <= 0 {
+		return w
+	}
+	return newThrottledWriter(r.Context(), w, g.bandwidthLimit, g.timeSource)
+}
+
+// throttledWriter is a token bucket that holds back writes to an
+// http.ResponseWriter until enough bytes have been earned to send them.
+//
+// Tokens are earned as the clock moves forward, and for the time spent
+// waiting for them. The latter keeps the transfer going at the expected rate
+// when the clock is a FixedTimeSource that never moves on its own; the
+// clock's time is read again after each wait, so with a real clock the wait
+// is not counted twice.
+type throttledWriter struct {
+	http.ResponseWriter
+	ctx   context.Context
+	clock TimeSource
+
+	// rate is in bytes per second. Writes are split into chunks of at most
+	// burst bytes, the most the bucket holds, so that the client sees steady
+	// progress rather than long pauses between large chunks.
+	rate   int64
+	burst  int64
+	tokens float64
+	last   time.Time
+}
+
+func newThrottledWriter(ctx context.Context, w http.ResponseWriter, rate int64, clock TimeSource) *throttledWriter {
+	// Ten chunks per second, but never more than copyContents writes at once:
+	burst := rate / 10
+	if burst < 1 {
+		burst = 1
+	} else if burst > copyBufferSize {
+		burst = copyBufferSize
+	}
+
+	// The bucket starts empty, so that sending n bytes takes n/rate:
+	return &throttledWriter{
+		ResponseWriter: w,
+		ctx:            ctx,
+		clock:          clock,
+		rate:           rate,
+		burst:          burst,
+		last:           clock.Now(),
+	}
+}
+
+func (t *throttledWriter) Write(b []byte) (n int, err error) {
+	for len(b) > 0 {
+		chunk := b
+		if int64(len(chunk)) > t.burst {
+			chunk = chunk[:t.burst]
+		}
+		if err := t.wait(len(chunk)); err != nil {
+			return n, err
+		}
+
+		written, err := t.ResponseWriter.Write(chunk)
+		n += written
+		if err != nil {
+			return n, err
+		}
+		if f, ok := t.ResponseWriter.(http.Flusher); ok {
+			f.Flush()
+		}
+		b = b[len(chunk):]
+	}
+	return n, nil
+}
+
+// wait blocks until there are enough tokens to send n bytes, then spends
+// them. It returns ctx.Err() if the request is cancelled first.
+func (t *throttledWriter) wait(n int) error {
+	t.refill()
+	if need := float64(n) - t.tokens; need > 0 {
+		d := time.Duration(need / float64(t.rate) * float64(time.Second))
+		timer := time.NewTimer(d)
+		defer timer.Stop()
+		select {
+		case <-timer.C:
+		case <-t.ctx.Done():
+			return t.ctx.Err()
+		}
+		t.tokens += need
+		t.last = t.clock.Now()
+	}
+	t.tokens -= float64(n)
+	return nil
+}
+
+// refill earns the tokens for the time that has passed on the clock since
+// the last refill.
+func (t *throttledWriter) refill() {
+	now := t.clock.Now()
+	if elapsed := now.Sub(t.last); elapsed > 0 {
+		t.tokens += elapsed.Seconds() * float64(t.rate)
+		if max := float64(t.burst); t.tokens > max {
+			t.tokens = max
+		}
+	}
+	t.last = now
+}
@@ -0,0 +1,630 @@
+package gofakes3
+
+import (
+	"encoding/base64"
+	"encoding/xml"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+)
+
+// bucketConfig is a bucket configuration that GoFakeS3 stores with a
+// BucketConfigBackend and returns unchanged, but does not otherwise act on.
+type bucketConfig interface {
+	setXmlns(ns string)
+
+	// validate returns an error if S3 would refuse the configuration.
+	validate() error
+}
+
+// bucketConfigResource describes a bucket subresource whose configuration is
+// set and read as a whole and stored by a BucketConfigBackend.
+type bucketConfigResource struct {
+	operations map[string]string
+
+	// new returns an empty configuration, which is what GET returns if the
+	// configuration has never been set, unless missing is set.
+	new func() bucketConfig
+
+	// missing is the error returned by a GET if the configuration has never
+	// been set, or has been deleted. Configurations without one can not be
+	// deleted.
+	missing ErrorCode
+}
+
+// bucketConfigs lists the bucket subresources stored by a BucketConfigBackend,
+// by their query parameter. To add one, define its configuration in
+// messages.go and add it here.
+var bucketConfigs = map[string]bucketConfigResource{
+	"accelerate": {
+		operations: map[string]string{"GET": "GetBucketAccelerateConfiguration", "PUT": "PutBucketAccelerateConfiguration"},
+		new:        func() bucketConfig { return &AccelerateConfiguration{} },
+	},
+	"logging": {
+		operations: map[string]string{"GET": "GetBucketLogging", "PUT": "PutBucketLogging"},
+		new:        func() bucketConfig { return &BucketLoggingStatus{} },
+	},
+	"notification": {
+		operations: map[string]string{"GET": "GetBucketNotificationConfiguration", "PUT": "PutBucketNotificationConfiguration"},
+		new:        func() bucketConfig { return &NotificationConfiguration{} },
+	},
+	ownershipControlsSubresource: {
+		operations: map[string]string{"GET": "GetBucketOwnershipControls", "PUT": "PutBucketOwnershipControls", "DELETE": "DeleteBucketOwnershipControls"},
+		new:        func() bucketConfig { return &OwnershipControls{} },
+		missing:    ErrOwnershipControlsNotFound,
+	},
+	replicationSubresource: {
+		operations: map[string]string{"GET": "GetBucketReplication", "PUT": "PutBucketReplication", "DELETE": "DeleteBucketReplication"},
+		new:        func() bucketConfig { return &ReplicationConfiguration{} },
+		missing:    ErrReplicationConfigurationNotFound,
+	},
+	publicAccessBlockSubresource: {
+		operations: map[string]string{"GET": "GetPublicAccessBlock", "PUT": "PutPublicAccessBlock", "DELETE": "DeletePublicAccessBlock"},
+		new:        func() bucketConfig { return &PublicAccessBlockConfiguration{} },
+		missing:    ErrNoSuchPublicAccessBlockConfiguration,
+	},
+}
+
+// bucketConfigSubresource returns the bucketConfigs subresource the request's
+// query string selects, if any.
+func bucketConfigSubresource(query url.Values) (subresource string, ok bool) {
+	for sub := range bucketConfigs {
+		if _, ok := query[sub]; ok {
+			return sub, true
+		}
+	}
+	return "", false
+}
+
+// bucketConfigOperations returns the operations of the bucketConfigs
+// subresource the request's query string selects, or nil.
+func bucketConfigOperations(query url.Values) map[string]string {
+	if sub, ok := bucketConfigSubresource(query); ok {
+		return bucketConfigs[sub].operations
+	}
+	return nil
+}
+
+// routeBucketConfig operates on routes that contain one of the bucketConfigs
+// subresources in the query string and have only a bucket path segment.
+func (g *GoFakeS3) routeBucketConfig(bucket, subresource string, w http.ResponseWriter, r *http.Request) error {
+	switch r.Method {
+	case "GET":
+		return g.getBucketConfig(bucket, subresource, w, r)
+	case "PUT":
+		return g.putBucketConfig(bucket, subresource, w, r)
+	case "DELETE":
+		if bucketConfigs[subresource].missing == "" {
+			return ErrMethodNotAllowed
+		}
+		return g.deleteBucketConfig(bucket, subresource, w, r)
+	default:
+		return ErrMethodNotAllowed
+	}
+}
+
+func (g *GoFakeS3) getBucketConfig(bucket, subresource string, w http.ResponseWriter, r *http.Request) error {
+	g.log.Print(LogInfo, "GET BUCKET CONFIG:", bucket, subresource)
+
+	if g.configs == nil {
+		return ErrNotImplemented
+	}
+
+	stored, err := g.configs.BucketConfig(bucket, subresource)
+	if err != nil {
+		return err
+	}
+
+	resource := bucketConfigs[subresource]
+	if stored == nil && resource.missing != "" {
+		return ResourceError(resource.missing, bucket)
+	}
+	config := resource.new()
+	if stored != nil {
+		if err := xml.Unmarshal(stored, config); err != nil {
+			return err
+		}
+	}
+	config.setXmlns("http://s3.amazonaws.com/doc/2006-03-01/")
+	return g.xmlEncoder(w).Encode(config)
+}
+
+func (g *GoFakeS3) putBucketConfig(bucket, subresource string, w http.ResponseWriter, r *http.Request) error {
+	g.log.Print(LogInfo, "PUT BUCKET CONFIG:", bucket, subresource)
+
+	if g.configs == nil {
+		return ErrNotImplemented
+	}
+
+	config := bucketConfigs[subresource].new()
+	if err := g.xmlDecodeBody(r.Body, config); err != nil {
+		return err
+	}
+	if err := config.validate(); err != nil {
+		return err
+	}
+
+	// The configuration is stored as it will be returned, less the namespace,
+	// so that anything GoFakeS3 does not understand is dropped:
+	config.setXmlns("")
+	stored, err := xml.Marshal(config)
+	if err != nil {
+		return err
+	}
+	return g.configs.SetBucketConfig(bucket, subresource, stored)
+}
+
+func (g *GoFakeS3) deleteBucketConfig(bucket, subresource string, w http.ResponseWriter, r *http.Request) error {
+	g.log.Print(LogInfo, "DELETE BUCKET CONFIG:", bucket, subresource)
+
+	if g.configs == nil {
+		return ErrNotImplemented
+	}
+	if err := g.configs.SetBucketConfig(bucket, subresource, nil); err != nil {
+		return err
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+	return nil
+}
+
+// idBucketConfig is a bucket configuration of which a bucket may have
+// several, identified by their ID. Like a bucketConfig, GoFakeS3 stores it
+// and returns it unchanged, but does not otherwise act on it.
+type idBucketConfig interface {
+	bucketConfig
+	configID() string
+}
+
+// idBucketConfigResource describes a bucket subresource whose configurations
+// are set, read and deleted one at a time by the 'id' in the query string,
+// and listed by a GET without one.
+//
+// All of a bucket's configurations of the same kind are stored together by a
+// BucketConfigBackend, under the name of the subresource.
+type idBucketConfigResource struct {
+	// operations includes "LIST", the operation of a GET without an 'id'.
+	operations map[string]string
+
+	// listName is the name of the root element of the LIST response.
+	listName string
+
+	new func() idBucketConfig
+}
+
+// maxIDBucketConfigs is the number of configurations returned by each page
+// of a LIST.
+const maxIDBucketConfigs = 100
+
+// idBucketConfigs lists the bucket subresources made up of configurations
+// identified by their ID, by their query parameter. To add one, define its
+// configuration in messages.go and add it here.
+var idBucketConfigs = map[string]idBucketConfigResource{
+	"analytics": {
+		operations: map[string]string{
+			"GET": "GetBucketAnalyticsConfiguration", "PUT": "PutBucketAnalyticsConfiguration",
+			"DELETE": "DeleteBucketAnalyticsConfiguration", "LIST": "ListBucketAnalyticsConfigurations",
+		},
+		listName: "ListBucketAnalyticsConfigurationResult",
+		new:      func() idBucketConfig { return &AnalyticsConfiguration{} },
+	},
+	"inventory": {
+		operations: map[string]string{
+			"GET": "GetBucketInventoryConfiguration", "PUT": "PutBucketInventoryConfiguration",
+			"DELETE": "DeleteBucketInventoryConfiguration", "LIST": "ListBucketInventoryConfigurations",
+		},
+		listName: "ListInventoryConfigurationsResult",
+		new:      func() idBucketConfig { return &InventoryConfiguration{} },
+	},
+	"metrics": {
+		operations: map[string]string{
+			"GET": "GetBucketMetricsConfiguration", "PUT": "PutBucketMetricsConfiguration",
+			"DELETE": "DeleteBucketMetricsConfiguration", "LIST": "ListBucketMetricsConfigurations",
+		},
+		listName: "ListMetricsConfigurationsResult",
+		new:      func() idBucketConfig { return &MetricsConfiguration{} },
+	},
+}
+
+// idBucketConfigSubresource returns the idBucketConfigs subresource the
+// request's query string selects, if any.
+func idBucketConfigSubresource(query url.Values) (subresource string, ok bool) {
+	for sub := range idBucketConfigs {
+		if _, ok := query[sub]; ok {
+			return sub, true
+		}
+	}
+	return "", false
+}
+
+// idBucketConfigOperations returns the operations of the idBucketConfigs
+// subresource the request's query string selects, or nil.
+func idBucketConfigOperations(query url.Values) map[string]string {
+	if sub, ok := idBucketConfigSubresource(query); ok {
+		return idBucketConfigs[sub].operations
+	}
+	return nil
+}
+
+// storedIDBucketConfigs is how the configurations of an idBucketConfigs
+// subresource are stored: each one as it was marshalled, sorted by ID.
+type storedIDBucketConfigs struct {
+	XMLName xml.Name               `xml:"Configurations"`
+	Configs []storedIDBucketConfig `xml:"Configuration"`
+}
+
+type storedIDBucketConfig struct {
+	ID     string `xml:"Id,attr"`
+	Config []byte `xml:",innerxml"`
+}
+
+// find returns the index of the configuration with the given ID, or of the
+// first one after it if there is none, and whether it was found.
+func (c *storedIDBucketConfigs) find(id string) (int, bool) {
+	i := sort.Search(len(c.Configs), func(i int) bool { return c.Configs[i].ID >= id })
+	return i, i < len(c.Configs) && c.Configs[i].ID == id
+}
+
+// listIDBucketConfigsResult is the response to a LIST of an idBucketConfigs
+// subresource; the name of each configuration's element is taken from its
+// XMLName.
+type listIDBucketConfigsResult struct {
+	XMLName xml.Name
+	Xmlns   string `xml:"xmlns,attr"`
+
+	Configs     []idBucketConfig
+	IsTruncated bool `xml:"IsTruncated"`
+
+	// ContinuationToken is included if it was sent with the request.
+	ContinuationToken     string `xml:"ContinuationToken,omitempty"`
+	NextContinuationToken string `xml:"NextContinuationToken,omitempty"`
+}
+
+// routeIDBucketConfig operates on routes that contain one of the
+// idBucketConfigs subresources in the query string and have only a bucket
+// path segment.
+func (g *GoFakeS3) routeIDBucketConfig(bucket, subresource string, w http.ResponseWriter, r *http.Request) error {
+	id := r.URL.Query().Get("id")
+	switch {
+	case r.Method == "GET" && id == "":
+		return g.listIDBucketConfigs(bucket, subresource, w, r)
+	case r.Method == "GET":
+		return g.getIDBucketConfig(bucket, subresource, id, w, r)
+	case r.Method == "PUT":
+		return g.putIDBucketConfig(bucket, subresource, id, w, r)
+	case r.Method == "DELETE":
+		return g.deleteIDBucketConfig(bucket, subresource, id, w, r)
+	default:
+		return ErrMethodNotAllowed
+	}
+}
+
+// idBucketConfigs returns the bucket's configurations of the subresource.
+func (g *GoFakeS3) idBucketConfigs(bucket, subresource string) (*storedIDBucketConfigs, error) {
+	if g.configs == nil {
+		return nil, ErrNotImplemented
+	}
+
+	var configs storedIDBucketConfigs
+	stored, err := g.configs.BucketConfig(bucket, subresource)
+	if err != nil {
+		return nil, err
+	}
+	if stored != nil {
+		if err := xml.Unmarshal(stored, &configs); err != nil {
+			return nil, err
+		}
+	}
+	return &configs, nil
+}
+
+func (g *GoFakeS3) setIDBucketConfigs(bucket, subresource string, configs *storedIDBucketConfigs) error {
+	stored, err := xml.Marshal(configs)
+	if err != nil {
+		return err
+	}
+	return g.configs.SetBucketConfig(bucket, subresource, stored)
+}
+
+func (g *GoFakeS3) listIDBucketConfigs(bucket, subresource string, w http.ResponseWriter, r *http.Request) error {
+	g.log.Print(LogInfo, "LIST BUCKET CONFIGS:", bucket, subresource)
+
+	configs, err := g.idBucketConfigs(bucket, subresource)
+	if err != nil {
+		return err
+	}
+
+	// The continuation token hides the ID of the first configuration of the
+	// next page:
+	resource := idBucketConfigs[subresource]
+	result := listIDBucketConfigsResult{
+		XMLName:           xml.Name{Local: resource.listName},
+		Xmlns:             "http://s3.amazonaws.com/doc/2006-03-01/",
+		ContinuationToken: r.URL.Query().Get("continuation-token"),
+	}
+	var start string
+	if result.ContinuationToken != "" {
+		tok, err := base64.URLEncoding.DecodeString(result.ContinuationToken)
+		if err != nil {
+			return ErrorInvalidArgument("continuation-token", result.ContinuationToken, "The continuation token provided is incorrect")
+		}
+		start = string(tok)
+	}
+
+	first, _ := configs.find(start)
+	page := configs.Configs[first:]
+	if len(page) > maxIDBucketConfigs {
+		result.IsTruncated = true
+		result.NextContinuationToken = base64.URLEncoding.EncodeToString([]byte(page[maxIDBucketConfigs].ID))
+		page = page[:maxIDBucketConfigs]
+	}
+	for _, stored := range page {
+		config := resource.new()
+		if err := xml.Unmarshal(stored.Config, config); err != nil {
+			return err
+		}
+		result.Configs = append(result.Configs, config)
+	}
+	return g.xmlEncoder(w).Encode(result)
+}
+
+func (g *GoFakeS3) getIDBucketConfig(bucket, subresource, id string, w http.ResponseWriter, r *http.Request) error {
+	g.log.Print(LogInfo, "GET BUCKET CONFIG:", bucket, subresource, id)
+
+	configs, err := g.idBucketConfigs(bucket, subresource)
+	if err != nil {
+		return err
+	}
+	i, ok := configs.find(id)
+	if !ok {
+		return ResourceError(ErrNoSuchConfiguration, id)
+	}
+
+	config := idBucketConfigs[subresource].new()
+	if err := xml.Unmarshal(configs.Configs[i].Config, config); err != nil {
+		return err
+	}
+	config.setXmlns("http://s3.amazonaws.com/doc/2006-03-01/")
+	return g.xmlEncoder(w).Encode(config)
+}
+
+func (g *GoFakeS3) putIDBucketConfig(bucket, subresource, id string, w http.ResponseWriter, r *http.Request) error {
+	g.log.Print(LogInfo, "PUT BUCKET CONFIG:", bucket, subresource, id)
+
+	if id == "" {
+		return ErrorInvalidArgument("id", id, "The id parameter is required")
+	}
+	config := idBucketConfigs[subresource].new()
+	if err := g.xmlDecodeBody(r.Body, config); err != nil {
+		return err
+	}
+	if config.configID() != id {
+		return ErrorInvalidArgument("id", id, "The Id in the configuration does not match the id parameter")
+	}
+	if err := config.validate(); err != nil {
+		return err
+	}
+
+	// As with bucketConfigs, the configuration is stored without the
+	// namespace, so that anything GoFakeS3 does not understand is dropped:
+	config.setXmlns("")
+	marshalled, err := xml.Marshal(config)
+	if err != nil {
+		return err
+	}
+
+	g.idConfigsMu.Lock()
+	defer g.idConfigsMu.Unlock()
+
+	configs, err := g.idBucketConfigs(bucket, subresource)
+	if err != nil {
+		return err
+	}
+	stored := storedIDBucketConfig{ID: id, Config: marshalled}
+	if i, ok := configs.find(id); ok {
+		configs.Configs[i] = stored
+	} else {
+		configs.Configs = append(configs.Configs, storedIDBucketConfig{})
+		copy(configs.Configs[i+1:], configs.Configs[i:])
+		configs.Configs[i] = stored
+	}
+	return g.setIDBucketConfigs(bucket, subresource, configs)
+}
+
+func (g *GoFakeS3) deleteIDBucketConfig(bucket, subresource, id string, w http.ResponseWriter, r *http.Request) error {
+	g.log.Print(LogInfo, "DELETE BUCKET CONFIG:", bucket, subresource, id)
+
+	if id == "" {
+		return ErrorInvalidArgument("id", id, "The id parameter is required")
+	}
+
+	g.idConfigsMu.Lock()
+	defer g.idConfigsMu.Unlock()
+
+	configs, err := g.idBucketConfigs(bucket, subresource)
+	if err != nil {
+		return err
+	}
+	i, ok := configs.find(id)
+	if !ok {
+		return ResourceError(ErrNoSuchConfiguration, id)
+	}
+	configs.Configs = append(configs.Configs[:i], configs.Configs[i+1:]...)
+	if err := g.setIDBucketConfigs(bucket, subresource, configs); err != nil {
+		return err
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+	return nil
+}
+
+func (c *AccelerateConfiguration) setXmlns(ns string) { c.Xmlns = ns }
+
+func (c *AccelerateConfiguration) validate() error {
+	if c.Status != "Enabled" && c.Status != "Suspended" {
+		return ErrMalformedXML
+	}
+	return nil
+}
+
+func (c *BucketLoggingStatus) setXmlns(ns string) { c.Xmlns = ns }
+
+func (c *BucketLoggingStatus) validate() error {
+	if c.LoggingEnabled != nil && c.LoggingEnabled.TargetBucket == "" {
+		return ErrMalformedXML
+	}
+	return nil
+}
+
+func (c *NotificationConfiguration) setXmlns(ns string) { c.Xmlns = ns }
+
+func (c *NotificationConfiguration) validate() error {
+	validate := func(arn string, events []string, filter *NotificationFilter) error {
+		if arn == "" || len(events) == 0 {
+			return ErrMalformedXML
+		}
+		if filter != nil {
+			for _, rule := range filter.KeyFilterRules {
+				if rule.Name != "prefix" && rule.Name != "suffix" {
+					return ErrorMessage(ErrInvalidArgument, "filter rule name must be either prefix or suffix")
+				}
+			}
+		}
+		return nil
+	}
+
+	for _, topic := range c.TopicConfigurations {
+		if err := validate(topic.TopicARN, topic.Events, topic.Filter); err != nil {
+			return err
+		}
+	}
+	for _, queue := range c.QueueConfigurations {
+		if err := validate(queue.QueueARN, queue.Events, queue.Filter); err != nil {
+			return err
+		}
+	}
+	for _, lambda := range c.LambdaFunctionConfigurations {
+		if err := validate(lambda.LambdaFunctionARN, lambda.Events, lambda.Filter); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (c *OwnershipControls) setXmlns(ns string) { c.Xmlns = ns }
+
+func (c *OwnershipControls) validate() error {
+	if len(c.Rules) != 1 || !c.Rules[0].ObjectOwnership.valid() {
+		return ErrMalformedXML
+	}
+	return nil
+}
+
+func (c *PublicAccessBlockConfiguration) setXmlns(ns string) { c.Xmlns = ns }
+
+func (c *PublicAccessBlockConfiguration) validate() error { return nil }
+
+func (c *ReplicationConfiguration) setXmlns(ns string) { c.Xmlns = ns }
+
+func (c *ReplicationConfiguration) validate() error {
+	if c.Role == "" || len(c.Rules) == 0 || len(c.Rules) > MaxReplicationRules {
+		return ErrMalformedXML
+	}
+	if !strings.HasPrefix(c.Role, "arn:") {
+		return ErrorInvalidArgument("Role", c.Role, "Invalid ARN")
+	}
+
+	ids := map[string]bool{}
+	for _, rule := range c.Rules {
+		if !rule.Status.valid() || rule.Destination.Bucket == "" {
+			return ErrMalformedXML
+		}
+		if rule.Prefix != nil && rule.Filter != nil {
+			return ErrMalformedXML
+		}
+		if f := rule.Filter; f != nil && countSet(f.Prefix != nil, f.Tag != nil, f.And != nil) > 1 {
+			return ErrMalformedXML
+		}
+		for _, opt := range []*ReplicationStatusConfiguration{rule.DeleteMarkerReplication, rule.ExistingObjectReplication} {
+			if opt != nil && !opt.Status.valid() {
+				return ErrMalformedXML
+			}
+		}
+		if !strings.HasPrefix(rule.Destination.Bucket, "arn:aws:s3:::") {
+			return ErrorInvalidArgument("Bucket", rule.Destination.Bucket, "Invalid bucket ARN")
+		}
+		if rule.ID != "" {
+			if ids[rule.ID] {
+				return ErrorMessage(ErrInvalidArgument, "Rule Id must be unique")
+			}
+			ids[rule.ID] = true
+		}
+	}
+	return nil
+}
+
+func (c *AnalyticsConfiguration) setXmlns(ns string) { c.Xmlns = ns }
+
+func (c *AnalyticsConfiguration) configID() string { return c.ID }
+
+func (c *AnalyticsConfiguration) validate() error {
+	if f := c.Filter; f != nil && countSet(f.Prefix != nil, f.Tag != nil, f.And != nil) > 1 {
+		return ErrMalformedXML
+	}
+	if export := c.StorageClassAnalysis.DataExport; export != nil {
+		dest := export.Destination.S3BucketDestination
+		if export.OutputSchemaVersion != "V_1" || dest.Bucket == "" || dest.Format != "CSV" {
+			return ErrMalformedXML
+		}
+	}
+	return nil
+}
+
+func (c *InventoryConfiguration) setXmlns(ns string) { c.Xmlns = ns }
+
+func (c *InventoryConfiguration) configID() string { return c.ID }
+
+func (c *InventoryConfiguration) validate() error {
+	dest := c.Destination.S3BucketDestination
+	if dest.Bucket == "" {
+		return ErrMalformedXML
+	}
+	switch dest.Format {
+	case "CSV", "ORC", "Parquet":
+	default:
+		return ErrMalformedXML
+	}
+	if enc := dest.Encryption; enc != nil && countSet(enc.SSES3 != nil, enc.SSEKMS != nil) != 1 {
+		return ErrMalformedXML
+	}
+	if c.Schedule.Frequency != "Daily" && c.Schedule.Frequency != "Weekly" {
+		return ErrMalformedXML
+	}
+	if c.IncludedObjectVersions != "All" && c.IncludedObjectVersions != "Current" {
+		return ErrMalformedXML
+	}
+	return nil
+}
+
+func (c *MetricsConfiguration) setXmlns(ns string) { c.Xmlns = ns }
+
+func (c *MetricsConfiguration) configID() string { return c.ID }
+
+func (c *MetricsConfiguration) validate() error {
+	if f := c.Filter; f != nil && countSet(f.Prefix != nil, f.Tag != nil, f.AccessPointARN != nil, f.And != nil) > 1 {
+		return ErrMalformedXML
+	}
+	return nil
+}
+
+// countSet returns how many of the fields of a configuration, of which only
+// one may be given, are set.
+func countSet(set ...bool) (n int) {
+	for _, ok := range set {
+		if ok {
+			n++
+		}
+	}
+	return n
+}
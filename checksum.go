@@ -0,0 +1,153 @@
+package gofakes3
+
+import (
+	"crypto/sha1"
+	"crypto/sha256"
+	"encoding/base64"
+	"fmt"
+	"hash"
+	"hash/crc32"
+	"net/http"
+	"strings"
+)
+
+// checksumAlgorithm is one of the additional checksum algorithms S3 supports
+// alongside Content-MD5.
+//
+// https://docs.aws.amazon.com/AmazonS3/latest/userguide/checking-object-integrity.html
+type checksumAlgorithm string
+
+const (
+	checksumCRC32  checksumAlgorithm = "CRC32"
+	checksumCRC32C checksumAlgorithm = "CRC32C"
+	checksumSHA1   checksumAlgorithm = "SHA1"
+	checksumSHA256 checksumAlgorithm = "SHA256"
+)
+
+var checksumAlgorithms = []checksumAlgorithm{checksumCRC32, checksumCRC32C, checksumSHA1, checksumSHA256}
+
+var crc32cTable = crc32.MakeTable(crc32.Castagnoli)
+
+// checksumAlgorithmHeader is sent with CreateMultipartUpload to choose the
+// algorithm used for the parts of the upload.
+const checksumAlgorithmHeader = "X-Amz-Checksum-Algorithm"
+
+func parseChecksumAlgorithm(v string) (checksumAlgorithm, error) {
+	alg := checksumAlgorithm(strings.ToUpper(v))
+	for _, known := range checksumAlgorithms {
+		if alg == known {
+			return alg, nil
+		}
+	}
+	return "", ErrorInvalidArgument(checksumAlgorithmHeader, v, "Checksum algorithm provided is unsupported. Please try again with any of the valid types: [CRC32, CRC32C, SHA1, SHA256]")
+}
+
+// header returns the canonical name of the header holding a checksum
+// calculated with this algorithm, i.e. 'X-Amz-Checksum-Crc32'.
+func (alg checksumAlgorithm) header() string {
+	return http.CanonicalHeaderKey("x-amz-checksum-" + string(alg))
+}
+
+func (alg checksumAlgorithm) newHash() hash.Hash {
+	switch alg {
+	case checksumCRC32:
+		return crc32.NewIEEE()
+	case checksumCRC32C:
+		return crc32.New(crc32cTable)
+	case checksumSHA1:
+		return sha1.New()
+	case checksumSHA256:
+		return sha256.New()
+	default:
+		panic(fmt.Errorf("gofakes3: unknown checksum algorithm %q", alg))
+	}
+}
+
+func (alg checksumAlgorithm) sum(data []byte) []byte {
+	h := alg.newHash()
+	h.Write(data)
+	return h.Sum(nil)
+}
+
+// composite calculates the checksum of a multipart upload from the checksums
+// of its parts: S3 uses the checksum of the concatenated binary checksums of
+// each part, followed by '-' and the number of parts.
+func (alg checksumAlgorithm) composite(parts [][]byte) string {
+	h := alg.newHash()
+	for _, part := range parts {
+		h.Write(part)
+	}
+	return fmt.Sprintf("%s-%d", base64.StdEncoding.EncodeToString(h.Sum(nil)), len(parts))
+}
+
+// checksum is a checksum supplied by the client in one of the
+// 'x-amz-checksum-*' headers.
+type checksum struct {
+	algorithm checksumAlgorithm
+	value     []byte
+}
+
+func (c *checksum) Base64() string {
+	return base64.StdEncoding.EncodeToString(c.value)
+}
+
+// checksumFromHeaders returns the checksum supplied with the request, if any.
+// Only one checksum may be supplied.
+func checksumFromHeaders(headers http.Header) (*checksum, error) {
+	var found *checksum
+
+	for _, alg := range checksumAlgorithms {
+		v := headers.Get(alg.header())
+		if v == "" {
+			continue
+		}
+		if found != nil {
+			return nil, ErrorMessage(ErrInvalidRequest, "Expecting a single x-amz-checksum- header. Multiple checksum Types are not allowed.")
+		}
+
+		value, err := base64.StdEncoding.DecodeString(v)
+		if err != nil || len(value) != alg.newHash().Size() {
+			return nil, ErrorMessagef(ErrInvalidRequest, "Value for %s header is invalid.", strings.ToLower(alg.header()))
+		}
+		found = &checksum{algorithm: alg, value: value}
+	}
+
+	return found, nil
+}
+
+// Checksums holds the additional checksums of an object or part, at most one
+// of which is set. It is embedded in the responses that report them.
+type Checksums struct {
+	ChecksumCRC32  string `xml:"ChecksumCRC32,omitempty"`
+	ChecksumCRC32C string `xml:"ChecksumCRC32C,omitempty"`
+	ChecksumSHA1   string `xml:"ChecksumSHA1,omitempty"`
+	ChecksumSHA256 string `xml:"ChecksumSHA256,omitempty"`
+}
+
+func (c *Checksums) field(alg checksumAlgorithm) *string {
+	switch alg {
+	case checksumCRC32:
+		return &c.ChecksumCRC32
+	case checksumCRC32C:
+		return &c.ChecksumCRC32C
+	case checksumSHA1:
+		return &c.ChecksumSHA1
+	case checksumSHA256:
+		return &c.ChecksumSHA256
+	default:
+		return nil
+	}
+}
+
+func (c *Checksums) get(alg checksumAlgorithm) string {
+	if f := c.field(alg); f != nil {
+		return *f
+	}
+	return ""
+}
+
+func (c *Checksums) set(alg checksumAlgorithm, value string) {
+	if f := c.field(alg); f != nil {
+		*f = value
+	}
+}
@@ -4,12 +4,43 @@ import (
 	"fmt"
 	"io"
 	"io/ioutil"
+	"net/http"
+	"strconv"
 )
 
+// streamingPayload is the value of the 'x-amz-content-sha256' header sent
+// with uploads that use the 'aws-chunked' encoding.
+//
+// https://docs.aws.amazon.com/AmazonS3/latest/API/sigv4-streaming.html
+const streamingPayload = "STREAMING-AWS4-HMAC-SHA256-PAYLOAD"
+
+// isStreamingUpload reports whether the request body is framed in signed
+// chunks, which must be decoded with newChunkedReader.
+func isStreamingUpload(headers http.Header) bool {
+	return headers.Get("X-Amz-Content-Sha256") == streamingPayload
+}
+
+// decodedContentLength returns the size of the payload of a streaming upload,
+// which is sent in the 'x-amz-decoded-content-length' header as the
+// Content-Length includes the chunk framing.
+func decodedContentLength(headers http.Header) (int64, error) {
+	size, err := strconv.ParseInt(headers.Get("X-Amz-Decoded-Content-Length"), 10, 64)
+	if err != nil || size < 0 {
+		return 0, ErrMissingContentLength
+	}
+	return size, nil
+}
+
+// chunkedReader strips the chunk framing from a streaming upload. The chunk
+// signatures are not verified.
+//
+// If the stream ends before the final, empty chunk is read, ErrIncompleteBody
+// is returned.
 type chunkedReader struct {
 	inner         io.Reader
 	chunkRemain   int
 	notFirstChunk bool
+	done          bool
 }
 
 func newChunkedReader(inner io.Reader) *chunkedReader {
@@ -23,7 +54,10 @@ func newChunkedReader(inner io.Reader) *chunkedReader {
 func (r *chunkedReader) Read(p []byte) (n int, err error) {
 	sizeToRead := len(p)
 	for sizeToRead > 0 {
-		if r.chunkRemain > sizeToRead {
+		if r.done {
+			return n, io.EOF
+
+		} else if r.chunkRemain > sizeToRead {
 			r.chunkRemain -= sizeToRead
 			// read sizeToRead bytes from inner reader
 			// to p, start from n.
@@ -32,7 +66,7 @@ func (r *chunkedReader) Read(p []byte) (n int, err error) {
 			sizeToRead -= innerN
 			n += innerN
 			if err != nil {
-				return n, err
+				return n, incompleteOnEOF(err)
 			}
 		} else if r.chunkRemain > 0 {
 			// read until this chunk ends
@@ -41,7 +75,7 @@ func (r *chunkedReader) Read(p []byte) (n int, err error) {
 			n += innerN
 			sizeToRead -= innerN
 			if err != nil {
-				return n, err
+				return n, incompleteOnEOF(err)
 			}
 		} else {
 			if !r.notFirstChunk {
@@ -51,21 +85,33 @@ func (r *chunkedReader) Read(p []byte) (n int, err error) {
 				// skip last chunk's b"\r\n"
 				_, err = io.CopyN(ioutil.Discard, r.inner, 2)
 				if err != nil {
-					return n, err
+					return n, incompleteOnEOF(err)
 				}
 			}
 			// read next chunk header
 			chunkSize := 0
 			_, err = fmt.Fscanf(r.inner, "%x;", &chunkSize)
 			if err != nil {
-				return n, err
+				return n, incompleteOnEOF(err)
 			}
 			r.chunkRemain = chunkSize
 			_, err = io.CopyN(ioutil.Discard, r.inner, 16+64+2) // "chunk-signature=" + sizeOfHash + "\r\n"
 			if err != nil {
-				return n, err
+				return n, incompleteOnEOF(err)
+			}
+			if chunkSize == 0 {
+				// The final chunk is empty; the trailing "\r\n" that follows it
+				// is optional as far as we are concerned.
+				r.done = true
 			}
 		}
 	}
 	return n, nil
 }
+
+func incompleteOnEOF(err error) error {
+	if err == io.EOF || err == io.ErrUnexpectedEOF {
+		return ErrIncompleteBody
+	}
+	return err
+}
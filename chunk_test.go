@@ -114,3 +114,19 @@ func TestChunkedUploadFail(t *testing.T) {
 	assert.Equal(t, 0, n)
 
 }
+
+func TestChunkedUploadIncomplete(t *testing.T) {
+	// The stream ends part way through a chunk:
+	payload := "400;chunk-signature=0055627c9e194cb4542bae2aa5492e3c1575bbb81b612b7d234b86a503ef5497\r\n"
+	payload += strings.Repeat("a", 100)
+	buf, err := ioutil.ReadAll(newChunkedReader(strings.NewReader(payload)))
+	assert.Equal(t, ErrIncompleteBody, err)
+	assert.Equal(t, strings.Repeat("a", 100), string(buf))
+
+	// The stream ends without the final empty chunk:
+	payload = "400;chunk-signature=0055627c9e194cb4542bae2aa5492e3c1575bbb81b612b7d234b86a503ef5497\r\n"
+	payload += strings.Repeat("a", 1024) + "\r\n"
+	buf, err = ioutil.ReadAll(newChunkedReader(strings.NewReader(payload)))
+	assert.Equal(t, ErrIncompleteBody, err)
+	assert.Equal(t, strings.Repeat("a", 1024), string(buf))
+}
@@ -0,0 +1,15 @@
+module github.com/johannesboyne/gofakes3/cmd/gofakes3
+
+go 1.16
+
+require (
+	github.com/johannesboyne/gofakes3 v0.0.0-00010101000000-000000000000
+	github.com/johannesboyne/gofakes3/backend/s3redis v0.0.0-00010101000000-000000000000
+	github.com/redis/go-redis/v9 v9.7.3
+	github.com/spf13/afero v1.2.1
+)
+
+replace (
+	github.com/johannesboyne/gofakes3 => ../../
+	github.com/johannesboyne/gofakes3/backend/s3redis => ../../backend/s3redis
+)
@@ -1,21 +1,28 @@
 package main
 
 import (
+	"context"
 	"expvar"
 	"flag"
 	"fmt"
+	"io"
 	"log"
 	"net"
 	"net/http"
 	httppprof "net/http/pprof"
 	"os"
+	"os/signal"
+	"path"
 	"runtime/pprof"
+	"syscall"
 	"time"
 
 	"github.com/johannesboyne/gofakes3"
 	"github.com/johannesboyne/gofakes3/backend/s3afero"
 	"github.com/johannesboyne/gofakes3/backend/s3bolt"
 	"github.com/johannesboyne/gofakes3/backend/s3mem"
+	"github.com/johannesboyne/gofakes3/backend/s3redis"
+	"github.com/redis/go-redis/v9"
 	"github.com/spf13/afero"
 )
 
@@ -35,15 +42,26 @@ type fakeS3Flags struct {
 	fixedTimeStr  string
 	noIntegrity   bool
 	hostBucket    bool
+	hostBase      string
 	autoBucket    bool
+	autoBucketPat string
+	region        string
+	bandwidth     int64
+	ownership     string
 	quiet         bool
 
 	boltDb         string
+	memPersist     string
+	memTTL         time.Duration
+	memLimit       int64
+	memEvict       bool
 	directFsPath   string
 	directFsMeta   string
 	directFsBucket string
 	fsPath         string
 	fsMeta         string
+	redisURL       string
+	redisPrefix    string
 
 	debugCPU  string
 	debugHost string
@@ -55,20 +73,32 @@ func (f *fakeS3Flags) attach(flagSet *flag.FlagSet) {
 	flagSet.StringVar(&f.initialBucket, "initialbucket", "", "If passed, this bucket will be created on startup if it does not already exist.")
 	flagSet.BoolVar(&f.noIntegrity, "no-integrity", false, "Pass this flag to disable Content-MD5 validation when uploading.")
 	flagSet.BoolVar(&f.hostBucket, "hostbucket", false, "If passed, the bucket name will be extracted from the first segment of the hostname, rather than the first part of the URL path.")
+	flagSet.StringVar(&f.hostBase, "hostbucket.base", "", "If passed, the bucket name will be extracted from hostnames that are subdomains of this domain (e.g. 'mybucket.s3.localhost' for 's3.localhost'). Other hostnames use the first part of the URL path.")
 	flagSet.BoolVar(&f.autoBucket, "autobucket", false, "If passed, nonexistent buckets will be created on first use instead of raising an error")
+	flagSet.StringVar(&f.autoBucketPat, "autobucket.pattern", "", "If passed with -autobucket, only nonexistent buckets whose names match this pattern (e.g. 'fixture-*') are created on first write.")
+	flagSet.StringVar(&f.region, "region", "", "Region reported for all buckets. If passed, CreateBucket requests for other regions are rejected. Defaults to us-east-1.")
+	flagSet.Int64Var(&f.bandwidth, "bandwidth", 0, "If passed, the bodies of GET object responses are sent at about this many bytes per second.")
+	flagSet.StringVar(&f.ownership, "objectownership", "", "ObjectOwnership of buckets created without an x-amz-object-ownership header. Defaults to BucketOwnerEnforced, which disables ACLs; pass ObjectWriter to allow them.")
 
 	// Logging
 	flagSet.BoolVar(&f.quiet, "quiet", false, "If passed, log messages are not printed to stderr")
 
 	// Backend specific:
-	flagSet.StringVar(&f.backendKind, "backend", "", "Backend to use to store data (memory, bolt, directfs, fs)")
+	flagSet.StringVar(&f.backendKind, "backend", "", "Backend to use to store data (memory, bolt, directfs, fs, redis)")
 	flagSet.StringVar(&f.boltDb, "bolt.db", "locals3.db", "Database path / name when using bolt backend")
+	flagSet.StringVar(&f.memPersist, "mem.persist", "", "Optional file for the memory backend. If passed, the contents are loaded from this file on startup and saved to it on shutdown.")
+	flagSet.DurationVar(&f.memTTL, "mem.ttl", 0, "If passed, objects stored by the memory backend are deleted once this much time has passed since they were put.")
+	flagSet.Int64Var(&f.memLimit, "mem.limit", 0, "If passed, the memory backend holds at most this many bytes of object contents, and rejects puts that would exceed it with a 503 SlowDown error.")
+	flagSet.BoolVar(&f.memEvict, "mem.evict", false, "If passed with -mem.limit, the least recently used objects are evicted to make room instead of rejecting puts.")
 	flagSet.StringVar(&f.directFsPath, "directfs.path", "", "File path to serve using S3. You should not modify the contents of this path outside gofakes3 while it is running as it can cause inconsistencies.")
 	flagSet.StringVar(&f.directFsMeta, "directfs.meta", "", "Optional path for storing S3 metadata for your bucket. If not passed, metadata will not persist between restarts of gofakes3.")
 	flagSet.StringVar(&f.directFsBucket, "directfs.bucket", "mybucket", "Name of the bucket for your file path; this will be the only supported bucket by the 'directfs' backend for the duration of your run.")
 	flagSet.StringVar(&f.fsPath, "fs.path", "", "Path to your S3 buckets. Buckets are stored under the '/buckets' subpath.")
 	flagSet.StringVar(&f.fsMeta, "fs.meta", "", "Optional path for storing S3 metadata for your buckets. Defaults to the '/metadata' subfolder of -fs.path if not passed.")
 
+	flagSet.StringVar(&f.redisURL, "redis.url", "redis://localhost:6379/0", "URL of the Redis server when using the redis backend")
+	flagSet.StringVar(&f.redisPrefix, "redis.prefix", "gofakes3", "Prefix for all keys stored by the redis backend")
+
 	// Debugging:
 	flagSet.StringVar(&f.debugHost, "debug.host", "", "Run the debug server on this host")
 	flagSet.StringVar(&f.debugCPU, "debug.cpu", "", "Create CPU profile in this file")
@@ -151,16 +181,45 @@ func run() error {
 		}
 		log.Println("using bolt backend with file", values.boltDb)
 
+	case "redis":
+		opts, err := redis.ParseURL(values.redisURL)
+		if err != nil {
+			return err
+		}
+		backend = s3redis.New(redis.NewClient(opts),
+			s3redis.WithTimeSource(timeSource),
+			s3redis.WithKeyPrefix(values.redisPrefix))
+		log.Println("using redis backend at", opts.Addr)
+
 	case "mem", "memory":
-		if values.initialBucket == "" {
+		if values.initialBucket == "" && values.memPersist == "" {
 			log.Println("no buckets available; consider passing -initialbucket")
 		}
-		backend = s3mem.New(s3mem.WithTimeSource(timeSource))
-		log.Println("using memory backend")
+		limitMode := s3mem.MemoryLimitReject
+		if values.memEvict {
+			limitMode = s3mem.MemoryLimitEvict
+		}
+		var err error
+		backend, err = s3mem.Open(
+			s3mem.WithTimeSource(timeSource),
+			s3mem.WithPersistFile(values.memPersist),
+			s3mem.WithObjectTTL(values.memTTL),
+			s3mem.WithMemoryLimit(values.memLimit),
+			s3mem.WithMemoryLimitMode(limitMode))
+		if err != nil {
+			return err
+		}
+		if values.memPersist != "" {
+			log.Println("using memory backend persisted to", values.memPersist)
+		} else {
+			log.Println("using memory backend")
+		}
 
 	case "fs":
+		var options []s3afero.MultiOption
 		if timeSource != nil {
-			log.Println("warning: time source not supported by this backend")
+			log.Println("warning: time source only used for bucket creation dates by this backend")
+			options = append(options, s3afero.MultiWithTimeSource(timeSource))
 		}
 
 		baseFs, err := s3afero.FsPath(values.fsPath)
@@ -168,7 +227,6 @@ func run() error {
 			return fmt.Errorf("gofakes3: could not create -fs.path: %v", err)
 		}
 
-		var options []s3afero.MultiOption
 		if values.fsMeta != "" {
 			metaFs, err := s3afero.FsPath(values.fsMeta)
 			if err != nil {
@@ -189,8 +247,10 @@ func run() error {
 		if values.autoBucket {
 			return fmt.Errorf("gofakes3: -autobucket not supported by directfs")
 		}
+		var options []s3afero.SingleOption
 		if timeSource != nil {
-			log.Println("warning: time source not supported by this backend")
+			log.Println("warning: time source only used for bucket creation dates by this backend")
+			options = append(options, s3afero.SingleWithTimeSource(timeSource))
 		}
 
 		baseFs, err := s3afero.FsPath(values.directFsPath)
@@ -208,7 +268,7 @@ func run() error {
 			log.Println("using ephemeral memory backend for metadata; this will not persist. See -directfs.metapath flag if you need persistence.")
 		}
 
-		backend, err = s3afero.SingleBucket(values.directFsBucket, baseFs, metaFs)
+		backend, err = s3afero.SingleBucket(values.directFsBucket, baseFs, metaFs, options...)
 		if err != nil {
 			return err
 		}
@@ -229,28 +289,76 @@ func run() error {
 		logger = gofakes3.DiscardLog()
 	}
 
-	faker := gofakes3.New(backend,
+	opts := []gofakes3.Option{
 		gofakes3.WithIntegrityCheck(!values.noIntegrity),
 		gofakes3.WithTimeSkewLimit(timeSkewLimit),
 		gofakes3.WithTimeSource(timeSource),
 		gofakes3.WithLogger(logger),
 		gofakes3.WithHostBucket(values.hostBucket),
 		gofakes3.WithAutoBucket(values.autoBucket),
-	)
+		gofakes3.WithRegion(values.region),
+		gofakes3.WithBandwidthLimit(values.bandwidth),
+	}
+	if values.ownership != "" {
+		switch ownership := gofakes3.ObjectOwnership(values.ownership); ownership {
+		case gofakes3.ObjectOwnershipBucketOwnerEnforced, gofakes3.ObjectOwnershipBucketOwnerPreferred, gofakes3.ObjectOwnershipObjectWriter:
+			opts = append(opts, gofakes3.WithObjectOwnership(ownership))
+		default:
+			return fmt.Errorf("gofakes3: invalid -objectownership: %q", values.ownership)
+		}
+	}
+	if values.hostBase != "" {
+		opts = append(opts, gofakes3.WithHostBucketBase(values.hostBase))
+	}
+	if values.autoBucket && values.autoBucketPat != "" {
+		if _, err := path.Match(values.autoBucketPat, ""); err != nil {
+			return fmt.Errorf("gofakes3: invalid -autobucket.pattern: %v", err)
+		}
+		opts = append(opts, gofakes3.WithAutoBucketFunc(func(name string) bool {
+			ok, _ := path.Match(values.autoBucketPat, name)
+			return ok
+		}))
+	}
+
+	faker := gofakes3.New(backend, opts...)
+
+	defer faker.Close()
 
-	return listenAndServe(values.host, faker.Server())
+	if err := listenAndServe(values.host, faker); err != nil {
+		return err
+	}
+
+	// Backends that persist their contents on Close are only closed on a
+	// graceful shutdown:
+	if closer, ok := backend.(io.Closer); ok {
+		return closer.Close()
+	}
+	return nil
 }
 
-func listenAndServe(addr string, handler http.Handler) error {
+// listenAndServe serves until an interrupt or SIGTERM is received, then
+// waits for the requests being handled to finish.
+func listenAndServe(addr string, faker *gofakes3.GoFakeS3) error {
 	listener, err := net.Listen("tcp", addr)
 	if err != nil {
 		return err
 	}
 
 	log.Println("using port:", listener.Addr().(*net.TCPAddr).Port)
-	server := &http.Server{Addr: addr, Handler: handler}
 
-	return server.Serve(listener)
+	shutdown := make(chan error, 1)
+	go func() {
+		signals := make(chan os.Signal, 1)
+		signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+		<-signals
+		log.Println("shutting down")
+		shutdown <- faker.Shutdown(context.Background())
+	}()
+
+	if err := faker.Serve(listener); err != http.ErrServerClosed {
+		return err
+	}
+	return <-shutdown
 }
 
 func profile(values fakeS3Flags) (func(), error) {
@@ -0,0 +1,90 @@
+package gofakes3
+
+import (
+	"compress/gzip"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// shouldCompress reports whether the body of obj should be gzipped in
+// response to r, according to the settings passed to WithResponseCompression.
+// Responses that already have a Content-Encoding, from the stored object or
+// the response-content-encoding parameter, are never compressed.
+func (g *GoFakeS3) shouldCompress(obj *Object, r *http.Request) bool {
+	// An empty object is sent as it is, so that the response has an explicit
+	// 'Content-Length: 0':
+	if !g.compress || obj.Size == 0 || obj.Size < g.compressMinBytes {
+		return false
+	}
+
+	// Byte offsets in a range request refer to the stored object, which
+	// would be meaningless if applied to the compressed response. The same
+	// goes for the Content-Range of a part:
+	if r.Header.Get("Range") != "" || r.URL.Query().Get("partNumber") != "" {
+		return false
+	}
+	if obj.Metadata["Content-Encoding"] != "" || r.URL.Query().Get("response-content-encoding") != "" {
+		return false
+	}
+	return acceptsGzip(r.Header.Get("Accept-Encoding"))
+}
+
+// writeCompressed writes body to w with 'Content-Encoding: gzip'. There is
+// no Content-Length, so the response is sent using chunked transfer encoding.
+func writeCompressed(w http.ResponseWriter, body io.Reader) error {
+	hdr := w.Header()
+	hdr.Del("Content-Length")
+	hdr.Set("Content-Encoding", "gzip")
+	hdr.Add("Vary", "Accept-Encoding")
+
+	gz := gzip.NewWriter(w)
+	if _, err := copyContents(gz, body); err != nil {
+		return err
+	}
+	return gz.Close()
+}
+
+// acceptsGzip reports whether the value of an Accept-Encoding header allows a
+// gzip response. An explicit "gzip;q=0" takes precedence over "*".
+func acceptsGzip(accept string) bool {
+	gzipQ, starQ := -1.0, -1.0
+	for _, part := range strings.Split(accept, ",") {
+		params := strings.Split(part, ";")
+		q := 1.0
+		for _, param := range params[1:] {
+			param = strings.TrimSpace(param)
+			if strings.HasPrefix(param, "q=") {
+				if v, err := strconv.ParseFloat(param[2:], 64); err == nil {
+					q = v
+				}
+			}
+		}
+
+		switch strings.ToLower(strings.TrimSpace(params[0])) {
+		case "gzip", "x-gzip":
+			gzipQ = q
+		case "*":
+			starQ = q
+		}
+	}
+
+	if gzipQ >= 0 {
+		return gzipQ > 0
+	}
+	return starQ > 0
+}
+
+// withoutAWSChunked removes "aws-chunked" from the list of codings in a
+// Content-Encoding header.
+func withoutAWSChunked(encoding string) string {
+	var codings []string
+	for _, coding := range strings.Split(encoding, ",") {
+		coding = strings.TrimSpace(coding)
+		if coding != "" && !strings.EqualFold(coding, "aws-chunked") {
+			codings = append(codings, coding)
+		}
+	}
+	return strings.Join(codings, ",")
+}
@@ -0,0 +1,44 @@
+package gofakes3
+
+import "testing"
+
+func TestAcceptsGzip(t *testing.T) {
+	for _, tc := range []struct {
+		accept string
+		ok     bool
+	}{
+		{"", false},
+		{"identity", false},
+		{"gzip", true},
+		{"GZIP", true},
+		{"x-gzip", true},
+		{"br, gzip;q=0.5", true},
+		{"gzip;q=0", false},
+		{"gzip; q=0.0, br", false},
+		{"*", true},
+		{"*;q=0", false},
+		{"gzip;q=0, *", false},
+		{"deflate, *;q=0.1", true},
+	} {
+		t.Run(tc.accept, func(t *testing.T) {
+			if ok := acceptsGzip(tc.accept); ok != tc.ok {
+				t.Fatal(ok, "!=", tc.ok)
+			}
+		})
+	}
+}
+
+func TestWithoutAWSChunked(t *testing.T) {
+	for in, out := range map[string]string{
+		"":                  "",
+		"aws-chunked":       "",
+		"aws-chunked,gzip":  "gzip",
+		"gzip, aws-chunked": "gzip",
+		"gzip":              "gzip",
+		"br,gzip":           "br,gzip",
+	} {
+		if found := withoutAWSChunked(in); found != out {
+			t.Fatalf("%q: %q != %q", in, found, out)
+		}
+	}
+}
@@ -19,15 +19,29 @@ const (
 	// the disparity!
 	DefaultMetadataSizeLimit = 2000
 
-	// Like DefaultMetadataSizeLimit, the docs don't specify MB or MiB, so we
-	// will accept 5MB for now. The Go client SDK rejects 5MB with the error
-	// "part size must be at least 5242880 bytes", which is a hint that it
-	// has been interpreted as MiB at least _somewhere_, but we should remain
-	// liberal in what we accept in the face of ambiguity.
-	DefaultUploadPartSize = 5 * 1000 * 1000
+	// DefaultUploadPartSize is the minimum size of every part of a multipart
+	// upload but the last, unless WithMinPartSize is used. Like
+	// DefaultMetadataSizeLimit, the docs used to not specify MB or MiB, but
+	// https://docs.aws.amazon.com/AmazonS3/latest/userguide/qfacts.html now
+	// says "Part size: 5 MiB to 5 GiB", and the Go client SDK rejects 5MB
+	// with the error "part size must be at least 5242880 bytes".
+	DefaultUploadPartSize = 5 << 20
 
 	DefaultSkewLimit = 15 * time.Minute
 
+	// DefaultRegion is the region GoFakeS3 reports if WithRegion is not used.
+	// Buckets in this region have an empty LocationConstraint.
+	DefaultRegion = "us-east-1"
+
+	// DefaultOwnerID is the canonical user ID of the owner of all buckets and
+	// objects if WithOwner is not used.
+	DefaultOwnerID = "fe7272ea58be830e56fe1663b10fafef"
+
+	// From https://docs.aws.amazon.com/AmazonS3/latest/API/API_ListBuckets.html:
+	//	"Maximum number of buckets to be returned in response. [...] Valid
+	//	Range: Minimum value of 1. Maximum value of 10000."
+	MaxBucketsLimit = 10000
+
 	MaxUploadsLimit       = 1000
 	DefaultMaxUploads     = 1000
 	MaxUploadPartsLimit   = 1000
@@ -39,6 +53,33 @@ const (
 	MaxBucketVersionKeys        = 1000
 	DefaultMaxBucketVersionKeys = 1000
 
+	// From https://docs.aws.amazon.com/AmazonS3/latest/API/API_DeleteObjects.html:
+	//	"The request can contain a list of up to 1000 keys that you want to
+	//	delete."
+	MaxDeleteObjects = 1000
+
 	// From the docs: "Part numbers can be any number from 1 to 10,000, inclusive."
 	MaxUploadPartNumber = 10000
+
+	// From https://docs.aws.amazon.com/AmazonS3/latest/userguide/qfacts.html:
+	//	"Part size: 5 MiB to 5 GiB."
+	MaxUploadPartSize = 5 << 30
+
+	// From https://docs.aws.amazon.com/AmazonS3/latest/userguide/upload-objects.html:
+	//	"With a single PUT operation, you can upload a single object up to
+	//	5 GB in size."
+	MaxPutObjectSize = 5 << 30
+
+	// From https://docs.aws.amazon.com/AmazonS3/latest/dev/object-tagging.html:
+	//	"You can associate up to 10 tags with an object. Tags associated with an
+	//	object must have unique tag keys."
+	//	"A tag key can be up to 128 Unicode characters in length and tag values
+	//	can be up to 256 Unicode characters in length."
+	MaxObjectTags        = 10
+	MaxObjectTagKeyLen   = 128
+	MaxObjectTagValueLen = 256
+
+	// From https://docs.aws.amazon.com/AmazonS3/latest/userguide/CostAllocTagging.html:
+	//	"You can add up to 50 tags to an S3 bucket."
+	MaxBucketTags = 50
 )
@@ -2,6 +2,7 @@ package gofakes3
 
 import (
 	"net/http"
+	"strconv"
 	"strings"
 )
 
@@ -10,9 +11,13 @@ var (
 		"Accept",
 		"Accept-Encoding",
 		"Authorization",
+		"Cache-Control",
 		"Content-Disposition",
+		"Content-Encoding",
+		"Content-Language",
 		"Content-Length",
 		"Content-Type",
+		"Expires",
 		"X-Amz-Date",
 		"X-Amz-User-Agent",
 		"X-CSRF-Token",
@@ -27,20 +32,237 @@ var (
 	corsHeadersString = strings.Join(corsHeaders, ", ")
 )
 
+// From https://docs.aws.amazon.com/AmazonS3/latest/dev/cors.html:
+//
+//	"You can add up to 100 rules to the configuration."
+const MaxCORSRules = 100
+
+var corsMethods = map[string]bool{
+	"GET":    true,
+	"PUT":    true,
+	"HEAD":   true,
+	"POST":   true,
+	"DELETE": true,
+}
+
+// withCORS adds CORS headers to every response. If the bucket a request
+// refers to has a CORS configuration, or one was set with WithCORS,
+// cross-origin requests (those carrying an Origin header) and preflight
+// requests are checked against the configuration's rules. Otherwise,
+// permissive server-wide headers are sent.
 type withCORS struct {
-	r   http.Handler
-	log Logger
+	r http.Handler
+	g *GoFakeS3
 }
 
 func (s *withCORS) ServeHTTP(w http.ResponseWriter, r *http.Request) {
-	w.Header().Set("Access-Control-Allow-Origin", "*")
-	w.Header().Set("Access-Control-Allow-Methods", "POST, GET, OPTIONS, PUT, DELETE, HEAD")
-	w.Header().Set("Access-Control-Allow-Headers", corsHeadersString)
-	w.Header().Set("Access-Control-Expose-Headers", "ETag")
+	origin := r.Header.Get("Origin")
+
+	var config *CORSConfiguration
+	if r.Method == "OPTIONS" || origin != "" {
+		var err error
+		if config, err = s.bucketCORS(r); err != nil {
+			s.g.httpError(w, r, err)
+			return
+		}
+	}
+
+	if config == nil {
+		w.Header().Set("Access-Control-Allow-Origin", "*")
+		w.Header().Set("Access-Control-Allow-Methods", "POST, GET, OPTIONS, PUT, DELETE, HEAD")
+		w.Header().Set("Access-Control-Allow-Headers", corsHeadersString)
+		w.Header().Set("Access-Control-Expose-Headers", "ETag")
+
+		if r.Method == "OPTIONS" {
+			return
+		}
 
-	if r.Method == "OPTIONS" {
+	} else if r.Method == "OPTIONS" {
+		if err := s.preflight(config, w, r); err != nil {
+			s.g.httpError(w, r, err)
+		}
 		return
+
+	} else {
+		// The response depends on the origin, whether or not it is allowed:
+		w.Header().Set("Vary", "Origin")
+		if rule, allowOrigin := config.match(origin, r.Method, nil); rule != nil {
+			rule.writeHeaders(w.Header(), allowOrigin)
+		}
 	}
 
 	s.r.ServeHTTP(w, r)
 }
+
+// bucketCORS returns the CORS configuration of the bucket the request refers
+// to, falling back to the one set with WithCORS, or nil if there is none.
+func (s *withCORS) bucketCORS(r *http.Request) (*CORSConfiguration, error) {
+	bucket := strings.SplitN(strings.Trim(r.URL.Path, "/"), "/", 2)[0]
+	if s.g.cors == nil || bucket == "" {
+		return s.g.corsConfig, nil
+	}
+
+	// Requests to buckets that don't exist are left for the router to reject:
+	config, err := s.g.cors.BucketCORS(bucket)
+	if err != nil && !HasErrorCode(err, ErrNoSuchBucket) {
+		return nil, err
+	} else if config == nil {
+		return s.g.corsConfig, nil
+	}
+	return config, nil
+}
+
+func (s *withCORS) preflight(config *CORSConfiguration, w http.ResponseWriter, r *http.Request) error {
+	origin := r.Header.Get("Origin")
+	method := r.Header.Get("Access-Control-Request-Method")
+	if origin == "" {
+		return ErrorMessage(ErrInvalidRequest, "Insufficient information. Origin request header needed.")
+	}
+	if method == "" {
+		return ErrorMessage(ErrInvalidRequest, "Invalid Access-Control-Request-Method: null")
+	}
+
+	var headers []string
+	for _, hdr := range strings.Split(r.Header.Get("Access-Control-Request-Headers"), ",") {
+		if hdr = strings.TrimSpace(hdr); hdr != "" {
+			headers = append(headers, hdr)
+		}
+	}
+
+	rule, allowOrigin := config.match(origin, method, headers)
+	if rule == nil {
+		return ErrorMessage(ErrAccessForbidden, "CORSResponse: This CORS request is not allowed. "+
+			"This is usually because the evalution of Origin, request method / Access-Control-Request-Method "+
+			"or Access-Control-Request-Headers are not whitelisted by the resource's CORS spec.")
+	}
+
+	hdr := w.Header()
+	rule.writeHeaders(hdr, allowOrigin)
+	if len(headers) > 0 {
+		hdr.Set("Access-Control-Allow-Headers", strings.Join(headers, ", "))
+	}
+	if rule.MaxAgeSeconds > 0 {
+		hdr.Set("Access-Control-Max-Age", strconv.Itoa(rule.MaxAgeSeconds))
+	}
+	hdr.Set("Vary", "Origin, Access-Control-Request-Headers, Access-Control-Request-Method")
+
+	return nil
+}
+
+// match returns the first rule that allows the origin, method and headers,
+// along with the value for the Access-Control-Allow-Origin header, or nil if
+// no rule matches.
+func (config *CORSConfiguration) match(origin, method string, headers []string) (rule *CORSRule, allowOrigin string) {
+	if origin == "" {
+		return nil, ""
+	}
+
+next:
+	for i := range config.Rules {
+		rule := &config.Rules[i]
+
+		allowOrigin := ""
+		for _, allowed := range rule.AllowedOrigins {
+			if allowed == "*" {
+				allowOrigin = "*"
+				break
+			} else if corsWildcardMatch(allowed, origin) {
+				allowOrigin = origin
+				break
+			}
+		}
+		if allowOrigin == "" {
+			continue
+		}
+
+		methodOK := false
+		for _, allowed := range rule.AllowedMethods {
+			if allowed == method {
+				methodOK = true
+				break
+			}
+		}
+		if !methodOK {
+			continue
+		}
+
+		for _, hdr := range headers {
+			headerOK := false
+			for _, allowed := range rule.AllowedHeaders {
+				if corsWildcardMatch(strings.ToLower(allowed), strings.ToLower(hdr)) {
+					headerOK = true
+					break
+				}
+			}
+			if !headerOK {
+				continue next
+			}
+		}
+
+		return rule, allowOrigin
+	}
+
+	return nil, ""
+}
+
+func (rule *CORSRule) writeHeaders(hdr http.Header, allowOrigin string) {
+	hdr.Set("Access-Control-Allow-Origin", allowOrigin)
+	hdr.Set("Access-Control-Allow-Methods", strings.Join(rule.AllowedMethods, ", "))
+	if allowOrigin != "*" {
+		hdr.Set("Access-Control-Allow-Credentials", "true")
+	}
+
+	// The ETag is always exposed, as the SDKs need it to check uploads:
+	expose := []string{"ETag"}
+	for _, name := range rule.ExposeHeaders {
+		if !strings.EqualFold(name, "ETag") {
+			expose = append(expose, name)
+		}
+	}
+	hdr.Set("Access-Control-Expose-Headers", strings.Join(expose, ", "))
+}
+
+// corsWildcardMatch matches value against a pattern that may contain a single
+// '*' wildcard, as used by AllowedOrigin and AllowedHeader.
+func corsWildcardMatch(pattern, value string) bool {
+	idx := strings.IndexByte(pattern, '*')
+	if idx < 0 {
+		return pattern == value
+	}
+	prefix, suffix := pattern[:idx], pattern[idx+1:]
+	return len(value) >= len(prefix)+len(suffix) &&
+		strings.HasPrefix(value, prefix) &&
+		strings.HasSuffix(value, suffix)
+}
+
+func validateCORSConfiguration(config *CORSConfiguration) error {
+	if len(config.Rules) == 0 || len(config.Rules) > MaxCORSRules {
+		return ErrMalformedXML
+	}
+
+	for _, rule := range config.Rules {
+		if len(rule.AllowedMethods) == 0 || len(rule.AllowedOrigins) == 0 || rule.MaxAgeSeconds < 0 {
+			return ErrMalformedXML
+		}
+		for _, method := range rule.AllowedMethods {
+			if !corsMethods[method] {
+				return ErrorMessagef(ErrInvalidRequest,
+					"Found unsupported HTTP method in CORS config. Unsupported method is %s", method)
+			}
+		}
+		for _, origin := range rule.AllowedOrigins {
+			if strings.Count(origin, "*") > 1 {
+				return ErrorMessagef(ErrInvalidRequest,
+					"AllowedOrigin \"%s\" can not have more than one wildcard.", origin)
+			}
+		}
+		for _, header := range rule.AllowedHeaders {
+			if strings.Count(header, "*") > 1 {
+				return ErrorMessagef(ErrInvalidRequest,
+					"AllowedHeader \"%s\" can not have more than one wildcard.", header)
+			}
+		}
+	}
+
+	return nil
+}
@@ -15,14 +15,43 @@ import (
 const (
 	ErrNone ErrorCode = ""
 
+	// Raised when an object lock (retention or legal hold) prevents an
+	// operation, or when authentication is enabled (see WithAuthentication)
+	// and a request is not permitted.
+	ErrAccessDenied ErrorCode = "AccessDenied"
+
+	// Raised when a CORS request does not match any of the rules in the
+	// bucket's CORS configuration.
+	ErrAccessForbidden ErrorCode = "AccessForbidden"
+
+	// The Authorization header could not be parsed.
+	ErrAuthorizationHeaderMalformed ErrorCode = "AuthorizationHeaderMalformed"
+
+	// The query parameters of a presigned URL could not be parsed.
+	ErrAuthorizationQueryParametersError ErrorCode = "AuthorizationQueryParametersError"
+
 	// The Content-MD5 you specified did not match what we received.
 	ErrBadDigest ErrorCode = "BadDigest"
 
+	// The bucket passed to CreateBucket was created by a different owner.
 	ErrBucketAlreadyExists ErrorCode = "BucketAlreadyExists"
 
+	// The bucket passed to CreateBucket was already created by the same
+	// owner, outside us-east-1.
+	ErrBucketAlreadyOwnedByYou ErrorCode = "BucketAlreadyOwnedByYou"
+
 	// Raised when attempting to delete a bucket that still contains items.
 	ErrBucketNotEmpty ErrorCode = "BucketNotEmpty"
 
+	// Your proposed upload exceeds the maximum allowed object size. See
+	// ErrorEntityTooLarge() for a helper function for this error.
+	ErrEntityTooLarge ErrorCode = "EntityTooLarge"
+
+	// A part of a multipart upload other than the last is smaller than the
+	// minimum part size. See ErrorEntityTooSmall() for a helper function for
+	// this error.
+	ErrEntityTooSmall ErrorCode = "EntityTooSmall"
+
 	// "Indicates that the versioning configuration specified in the request is invalid"
 	ErrIllegalVersioningConfiguration ErrorCode = "IllegalVersioningConfigurationException"
 
@@ -40,6 +69,9 @@ const (
 	// only for reference.
 	ErrInlineDataTooLarge ErrorCode = "InlineDataTooLarge"
 
+	// The AWS access key ID you provided does not exist in our records.
+	ErrInvalidAccessKeyID ErrorCode = "InvalidAccessKeyId"
+
 	ErrInvalidArgument ErrorCode = "InvalidArgument"
 
 	// https://docs.aws.amazon.com/AmazonS3/latest/dev/BucketRestrictions.html#bucketnamingrules
@@ -48,7 +80,27 @@ const (
 	// The Content-MD5 you specified is not valid.
 	ErrInvalidDigest ErrorCode = "InvalidDigest"
 
-	ErrInvalidRange         ErrorCode = "InvalidRange"
+	// The LocationConstraint in a CreateBucket request does not match the
+	// region set with WithRegion.
+	ErrInvalidLocationConstraint ErrorCode = "InvalidLocationConstraint"
+
+	ErrInvalidRange ErrorCode = "InvalidRange"
+
+	// The storage class you specified is not valid.
+	ErrInvalidStorageClass ErrorCode = "InvalidStorageClass"
+
+	// The operation is not valid for the object's storage class, for example
+	// when reading an archived object that has not been restored.
+	ErrInvalidObjectState ErrorCode = "InvalidObjectState"
+
+	// The tag provided was not a valid tag. Raised when the tag set exceeds
+	// the maximum number of tags, or a key or value is too long.
+	ErrInvalidTag ErrorCode = "InvalidTag"
+
+	// Raised for a number of malformed requests, for example when attempting
+	// to copy an object onto itself without changing anything.
+	ErrInvalidRequest ErrorCode = "InvalidRequest"
+
 	ErrInvalidToken         ErrorCode = "InvalidToken"
 	ErrKeyTooLong           ErrorCode = "KeyTooLongError" // This is not a typo: Error is part of the string, but redundant in the constant name
 	ErrMalformedPOSTRequest ErrorCode = "MalformedPOSTRequest"
@@ -62,21 +114,71 @@ const (
 	// specified in order by part number.
 	ErrInvalidPartOrder ErrorCode = "InvalidPartOrder"
 
+	// The partNumber of a GET or HEAD request is greater than the number of
+	// parts in the object.
+	ErrInvalidPartNumber ErrorCode = "InvalidPartNumber"
+
 	ErrInvalidURI ErrorCode = "InvalidURI"
 
 	ErrMetadataTooLarge ErrorCode = "MetadataTooLarge"
 	ErrMethodNotAllowed ErrorCode = "MethodNotAllowed"
 	ErrMalformedXML     ErrorCode = "MalformedXML"
 
+	// The bucket has ACLs disabled by ObjectOwnershipBucketOwnerEnforced, so
+	// only ACLs that grant the owner full control can be set.
+	ErrAccessControlListNotSupported ErrorCode = "AccessControlListNotSupported"
+
+	// A bucket can not be created with an ACL that grants access to others
+	// if its ObjectOwnership is BucketOwnerEnforced.
+	ErrInvalidBucketAclWithObjectOwnership ErrorCode = "InvalidBucketAclWithObjectOwnership"
+
+	// The bucket does not have ownership controls.
+	ErrOwnershipControlsNotFound ErrorCode = "OwnershipControlsNotFoundError"
+
+	// The bucket does not have a replication configuration.
+	ErrReplicationConfigurationNotFound ErrorCode = "ReplicationConfigurationNotFoundError"
+
+	// The policy is not valid JSON, or is missing required elements.
+	ErrMalformedPolicy ErrorCode = "MalformedPolicy"
+
+	// The ACL is not valid XML, or contains an invalid grant.
+	ErrMalformedACLError ErrorCode = "MalformedACLError"
+
 	// You must provide the Content-Length HTTP header.
 	ErrMissingContentLength ErrorCode = "MissingContentLength"
 
+	// The specified configuration, such as an inventory configuration, does
+	// not exist.
+	ErrNoSuchConfiguration ErrorCode = "NoSuchConfiguration"
+
+	// The CORS configuration does not exist.
+	ErrNoSuchCORSConfiguration ErrorCode = "NoSuchCORSConfiguration"
+
 	// See BucketNotFound() for a helper function for this error:
 	ErrNoSuchBucket ErrorCode = "NoSuchBucket"
 
+	// The specified bucket does not have a bucket policy.
+	ErrNoSuchBucketPolicy ErrorCode = "NoSuchBucketPolicy"
+
 	// See KeyNotFound() for a helper function for this error:
 	ErrNoSuchKey ErrorCode = "NoSuchKey"
 
+	// The lifecycle configuration does not exist.
+	ErrNoSuchLifecycleConfiguration ErrorCode = "NoSuchLifecycleConfiguration"
+
+	// The bucket does not have any tags.
+	ErrNoSuchTagSet ErrorCode = "NoSuchTagSet"
+
+	// The specified object does not have an object lock retention or legal
+	// hold configured.
+	ErrNoSuchObjectLockConfiguration ErrorCode = "NoSuchObjectLockConfiguration"
+
+	// The specified bucket does not have a public access block.
+	ErrNoSuchPublicAccessBlockConfiguration ErrorCode = "NoSuchPublicAccessBlockConfiguration"
+
+	// The specified bucket does not have a website configuration.
+	ErrNoSuchWebsiteConfiguration ErrorCode = "NoSuchWebsiteConfiguration"
+
 	// The specified multipart upload does not exist. The upload ID might be
 	// invalid, or the multipart upload might have been aborted or completed.
 	ErrNoSuchUpload ErrorCode = "NoSuchUpload"
@@ -86,9 +188,33 @@ const (
 	// No need to retransmit the object
 	ErrNotModified ErrorCode = "NotModified"
 
+	// At least one of the preconditions you specified did not hold, for
+	// example If-Match or If-Unmodified-Since.
+	ErrPreconditionFailed ErrorCode = "PreconditionFailed"
+
 	ErrRequestTimeTooSkewed ErrorCode = "RequestTimeTooSkewed"
-	ErrTooManyBuckets       ErrorCode = "TooManyBuckets"
-	ErrNotImplemented       ErrorCode = "NotImplemented"
+
+	// A RestoreObject request was sent for an object that is already being
+	// restored.
+	ErrRestoreAlreadyInProgress ErrorCode = "RestoreAlreadyInProgress"
+
+	// The request signature we calculated does not match the signature you
+	// provided.
+	ErrSignatureDoesNotMatch ErrorCode = "SignatureDoesNotMatch"
+
+	// Returned by GoFakeS3 once Shutdown has been called.
+	ErrServiceUnavailable ErrorCode = "ServiceUnavailable"
+
+	// Reduce your request rate. GoFakeS3 never returns this itself, but it
+	// may be injected with FaultBackend.
+	ErrSlowDown ErrorCode = "SlowDown"
+
+	ErrTooManyBuckets ErrorCode = "TooManyBuckets"
+	ErrNotImplemented ErrorCode = "NotImplemented"
+
+	// The x-amz-content-sha256 header did not match the SHA-256 of the
+	// request body.
+	ErrXAmzContentSHA256Mismatch ErrorCode = "XAmzContentSHA256Mismatch"
 
 	ErrInternal ErrorCode = "InternalError"
 )
@@ -197,6 +323,44 @@ func ErrorInvalidArgument(name, value, message string) error {
 		ArgumentName:  name, ArgumentValue: value}
 }
 
+// ErrorEntityTooLargeResponse is the body of an EntityTooLarge error, which
+// reports the size of the upload and the largest size allowed.
+type ErrorEntityTooLargeResponse struct {
+	ErrorResponse
+
+	ProposedSize   int64 `xml:"ProposedSize"`
+	MaxSizeAllowed int64 `xml:"MaxSizeAllowed"`
+}
+
+func ErrorEntityTooLarge(proposedSize, maxSizeAllowed int64) error {
+	return &ErrorEntityTooLargeResponse{
+		ErrorResponse:  ErrorResponse{Code: ErrEntityTooLarge, Message: ErrEntityTooLarge.Message()},
+		ProposedSize:   proposedSize,
+		MaxSizeAllowed: maxSizeAllowed,
+	}
+}
+
+// ErrorEntityTooSmallResponse is the body of an EntityTooSmall error, which
+// reports the part of a multipart upload that is too small.
+type ErrorEntityTooSmallResponse struct {
+	ErrorResponse
+
+	ProposedSize   int64  `xml:"ProposedSize"`
+	MinSizeAllowed int64  `xml:"MinSizeAllowed"`
+	PartNumber     int    `xml:"PartNumber"`
+	ETag           string `xml:"ETag"`
+}
+
+func ErrorEntityTooSmall(partNumber int, etag string, proposedSize, minSizeAllowed int64) error {
+	return &ErrorEntityTooSmallResponse{
+		ErrorResponse:  ErrorResponse{Code: ErrEntityTooSmall, Message: ErrEntityTooSmall.Message()},
+		ProposedSize:   proposedSize,
+		MinSizeAllowed: minSizeAllowed,
+		PartNumber:     partNumber,
+		ETag:           etag,
+	}
+}
+
 // ErrorCode represents an S3 error code, documented here:
 // https://docs.aws.amazon.com/AmazonS3/latest/API/ErrorResponses.html
 type ErrorCode string
@@ -217,14 +381,60 @@ func (e InternalErrorCode) Error() string        { return string(ErrInternal) }
 // know!
 func (e ErrorCode) Message() string {
 	switch e {
+	case ErrBucketAlreadyExists:
+		return "The requested bucket name is not available. The bucket namespace is shared by all users of the system. Please select a different name and try again."
+	case ErrBucketAlreadyOwnedByYou:
+		return "Your previous request to create the named bucket succeeded and you already own it."
+	case ErrEntityTooLarge:
+		return "Your proposed upload exceeds the maximum allowed size"
+	case ErrEntityTooSmall:
+		return "Your proposed upload is smaller than the minimum allowed object size."
 	case ErrInvalidBucketName:
 		return `Bucket name must match the regex "^[a-zA-Z0-9.\-_]{1,255}$"`
 	case ErrNoSuchBucket:
 		return "The specified bucket does not exist"
 	case ErrRequestTimeTooSkewed:
 		return "The difference between the request time and the current time is too large"
+	case ErrSignatureDoesNotMatch:
+		return "The request signature we calculated does not match the signature you provided. Check your key and signing method."
+	case ErrInvalidAccessKeyID:
+		return "The AWS Access Key Id you provided does not exist in our records."
 	case ErrMalformedXML:
 		return "The XML you provided was not well-formed or did not validate against our published schema"
+	case ErrPreconditionFailed:
+		return "At least one of the pre-conditions you specified did not hold"
+	case ErrInvalidLocationConstraint:
+		return "The specified location-constraint is not valid"
+	case ErrInvalidStorageClass:
+		return "The storage class you specified is not valid"
+	case ErrInvalidObjectState:
+		return "The operation is not valid for the object's storage class"
+	case ErrRestoreAlreadyInProgress:
+		return "Object restore is already in progress"
+	case ErrNoSuchTagSet:
+		return "The TagSet does not exist"
+	case ErrMalformedACLError:
+		return "The XML you provided was not well-formed or did not validate against our published schema"
+	case ErrServiceUnavailable:
+		return "Service is unable to handle request."
+	case ErrSlowDown:
+		return "Please reduce your request rate."
+	case ErrInvalidPartNumber:
+		return "The requested partnumber is not satisfiable"
+	case ErrAccessDenied:
+		return "Access Denied"
+	case ErrInternal:
+		return "We encountered an internal error. Please try again."
+	case ErrNotImplemented:
+		return "A header you provided implies functionality that is not implemented"
+	case ErrAccessControlListNotSupported:
+		return "The bucket does not allow ACLs"
+	case ErrInvalidBucketAclWithObjectOwnership:
+		return "Bucket cannot have ACLs set with ObjectOwnership's BucketOwnerEnforced setting"
+	case ErrOwnershipControlsNotFound:
+		return "The bucket ownership controls were not found"
+	case ErrReplicationConfigurationNotFound:
+		return "The replication configuration was not found"
 	default:
 		return ""
 	}
@@ -233,39 +443,70 @@ func (e ErrorCode) Message() string {
 func (e ErrorCode) Status() int {
 	switch e {
 	case ErrBucketAlreadyExists,
-		ErrBucketNotEmpty:
+		ErrBucketAlreadyOwnedByYou,
+		ErrBucketNotEmpty,
+		ErrRestoreAlreadyInProgress:
 		return http.StatusConflict
 
-	case ErrBadDigest,
+	case ErrAccessControlListNotSupported,
+		ErrAuthorizationHeaderMalformed,
+		ErrAuthorizationQueryParametersError,
+		ErrBadDigest,
+		ErrEntityTooLarge,
+		ErrEntityTooSmall,
 		ErrIllegalVersioningConfiguration,
 		ErrIncompleteBody,
 		ErrIncorrectNumberOfFilesInPostRequest,
 		ErrInlineDataTooLarge,
 		ErrInvalidArgument,
+		ErrInvalidBucketAclWithObjectOwnership,
 		ErrInvalidBucketName,
 		ErrInvalidDigest,
+		ErrInvalidLocationConstraint,
 		ErrInvalidPart,
 		ErrInvalidPartOrder,
+		ErrInvalidRequest,
+		ErrInvalidStorageClass,
+		ErrInvalidTag,
 		ErrInvalidToken,
 		ErrInvalidURI,
 		ErrKeyTooLong,
 		ErrMetadataTooLarge,
 		ErrMethodNotAllowed,
+		ErrMalformedACLError,
+		ErrMalformedPolicy,
 		ErrMalformedPOSTRequest,
 		ErrMalformedXML,
-		ErrTooManyBuckets:
+		ErrTooManyBuckets,
+		ErrXAmzContentSHA256Mismatch:
 		return http.StatusBadRequest
 
-	case ErrRequestTimeTooSkewed:
+	case ErrAccessDenied,
+		ErrAccessForbidden,
+		ErrInvalidAccessKeyID,
+		ErrInvalidObjectState,
+		ErrRequestTimeTooSkewed,
+		ErrSignatureDoesNotMatch:
 		return http.StatusForbidden
 
-	case ErrInvalidRange:
+	case ErrInvalidPartNumber,
+		ErrInvalidRange:
 		return http.StatusRequestedRangeNotSatisfiable
 
 	case ErrNoSuchBucket,
+		ErrNoSuchBucketPolicy,
+		ErrNoSuchConfiguration,
+		ErrNoSuchCORSConfiguration,
 		ErrNoSuchKey,
+		ErrNoSuchLifecycleConfiguration,
+		ErrNoSuchObjectLockConfiguration,
+		ErrNoSuchPublicAccessBlockConfiguration,
+		ErrNoSuchTagSet,
 		ErrNoSuchUpload,
-		ErrNoSuchVersion:
+		ErrNoSuchVersion,
+		ErrNoSuchWebsiteConfiguration,
+		ErrOwnershipControlsNotFound,
+		ErrReplicationConfigurationNotFound:
 		return http.StatusNotFound
 
 	case ErrNotImplemented:
@@ -274,9 +515,16 @@ func (e ErrorCode) Status() int {
 	case ErrNotModified:
 		return http.StatusNotModified
 
+	case ErrPreconditionFailed:
+		return http.StatusPreconditionFailed
+
 	case ErrMissingContentLength:
 		return http.StatusLengthRequired
 
+	case ErrServiceUnavailable,
+		ErrSlowDown:
+		return http.StatusServiceUnavailable
+
 	case ErrInternal:
 		return http.StatusInternalServerError
 	}
@@ -328,17 +576,20 @@ func KeyNotFound(key string) error       { return ResourceError(ErrNoSuchKey, ke
 
 type requestTimeTooSkewedResponse struct {
 	ErrorResponse
+	RequestTime                string
 	ServerTime                 time.Time
 	MaxAllowedSkewMilliseconds durationAsMilliseconds
 }
 
 var _ errorResponse = &requestTimeTooSkewedResponse{}
 
-func requestTimeTooSkewed(at time.Time, max time.Duration) error {
+// requestTimeTooSkewed reports a request sent at requestTime, which is the
+// value of its date header, as received by the server at 'at'.
+func requestTimeTooSkewed(at time.Time, requestTime string, max time.Duration) error {
 	code := ErrRequestTimeTooSkewed
 	return &requestTimeTooSkewedResponse{
 		ErrorResponse{Code: code, Message: code.Message()},
-		at, durationAsMilliseconds(max),
+		requestTime, at, durationAsMilliseconds(max),
 	}
 }
 
@@ -7,7 +7,7 @@ import (
 )
 
 func TestErrorCustomResponseMarshalsAsExpected(t *testing.T) {
-	resp := requestTimeTooSkewed(time.Time{}, 123)
+	resp := requestTimeTooSkewed(time.Time{}, "20000101T000000Z", 123)
 	out, err := xml.Marshal(resp)
 	if err != nil {
 		t.Fatal(err)
@@ -18,6 +18,7 @@ func TestErrorCustomResponseMarshalsAsExpected(t *testing.T) {
 	expected := `<Error>` +
 		`<Code>RequestTimeTooSkewed</Code>` +
 		`<Message>The difference between the request time and the current time is too large</Message>` +
+		`<RequestTime>20000101T000000Z</RequestTime>` +
 		`<ServerTime>0001-01-01T00:00:00Z</ServerTime>` +
 		`<MaxAllowedSkewMilliseconds>0</MaxAllowedSkewMilliseconds>` +
 		`</Error>`
@@ -0,0 +1,112 @@
+package gofakes3
+
+import (
+	"bytes"
+	"context"
+	"crypto/md5"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"io/ioutil"
+)
+
+// ETagFunc computes the ETag of an object or part from its contents. The ETag
+// is returned without quotes. See WithETagFunc.
+type ETagFunc func(r io.Reader) (string, error)
+
+// MD5ETag is the ETagFunc used by default. It returns the hex-encoded MD5 hash
+// of the contents, as S3 does for objects that are not encrypted with SSE-KMS.
+func MD5ETag(r io.Reader) (string, error) {
+	hash := md5.New()
+	if _, err := io.Copy(hash, r); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(hash.Sum(nil)), nil
+}
+
+// etagFunc returns the ETagFunc passed to WithETagFunc, or MD5ETag.
+func (g *GoFakeS3) etagFunc() ETagFunc {
+	if g.etag != nil {
+		return g.etag
+	}
+	return MD5ETag
+}
+
+// objectETag reads input if an ETagFunc was passed to WithETagFunc, and stores
+// the ETag of its contents in meta so that GET, HEAD and listings return it.
+// It returns the reader to pass to the Backend in place of input, and the
+// ETag. If the default MD5 hash is used, input is returned as it is, along
+// with an empty ETag; the caller should use the hash of the contents.
+func (g *GoFakeS3) objectETag(ctx context.Context, meta map[string]string, input io.Reader, size int64) (io.Reader, string, error) {
+	if g.etag == nil {
+		return input, "", nil
+	}
+	body, err := ReadAllContext(ctx, input, size)
+	if err != nil {
+		return nil, "", err
+	}
+	etag, err := g.etag(bytes.NewReader(body))
+	if err != nil {
+		return nil, "", err
+	}
+	meta[ETagMetaKey] = `"` + etag + `"`
+	return bytes.NewReader(body), etag, nil
+}
+
+// etagReader passes through the data read from r while an ETagFunc computes the
+// ETag of a copy of it, so that the ETag of a part can be computed as the part
+// is streamed to a MultipartBackend.
+type etagReader struct {
+	io.Reader
+	pw   *io.PipeWriter
+	done chan struct{}
+	etag string
+	err  error
+}
+
+func newETagReader(r io.Reader, fn ETagFunc) *etagReader {
+	pr, pw := io.Pipe()
+	er := &etagReader{
+		Reader: io.TeeReader(r, pw),
+		pw:     pw,
+		done:   make(chan struct{}),
+	}
+	go func() {
+		defer close(er.done)
+		er.etag, er.err = fn(pr)
+
+		// fn may not read all of its input, but the reads from r block until
+		// the copy has been consumed:
+		io.Copy(ioutil.Discard, pr)
+	}()
+	return er
+}
+
+// finish returns the ETag once the reader is no longer used. readErr is the
+// error, if any, that stopped the data from being read; it is passed on to
+// the ETagFunc.
+func (er *etagReader) finish(readErr error) (string, error) {
+	er.pw.CloseWithError(readErr)
+	<-er.done
+	return er.etag, er.err
+}
+
+// multipartETag returns the ETag of an object completed from parts with the
+// given ETags: the ETag of the parts' ETags followed by the number of parts,
+// as S3 does: "<md5(md5(p1)+md5(p2)...)>-N". Part ETags that are hex-encoded
+// are decoded first, so that with MD5ETag the result matches S3.
+func multipartETag(etag ETagFunc, partETags []string) (string, error) {
+	var hashes []byte
+	for _, partETag := range partETags {
+		if hash, err := hex.DecodeString(partETag); err == nil {
+			hashes = append(hashes, hash...)
+		} else {
+			hashes = append(hashes, partETag...)
+		}
+	}
+	hash, err := etag(bytes.NewReader(hashes))
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("%s-%d", hash, len(partETags)), nil
+}
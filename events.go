@@ -0,0 +1,57 @@
+package gofakes3
+
+import "time"
+
+// EventType is the name of an S3 event notification, such as
+// "s3:ObjectCreated:Put".
+//
+// https://docs.aws.amazon.com/AmazonS3/latest/userguide/notification-how-to-event-types-and-destinations.html
+type EventType string
+
+const (
+	EventObjectCreatedPut                     EventType = "s3:ObjectCreated:Put"
+	EventObjectCreatedPost                    EventType = "s3:ObjectCreated:Post"
+	EventObjectCreatedCopy                    EventType = "s3:ObjectCreated:Copy"
+	EventObjectCreatedCompleteMultipartUpload EventType = "s3:ObjectCreated:CompleteMultipartUpload"
+	EventObjectRemovedDelete                  EventType = "s3:ObjectRemoved:Delete"
+	EventObjectRemovedDeleteMarkerCreated     EventType = "s3:ObjectRemoved:DeleteMarkerCreated"
+)
+
+// Event describes a change to an object, passed to the function given to
+// WithEventHook.
+type Event struct {
+	Type   EventType
+	Bucket string
+	Key    string
+
+	// VersionID is the version that was created or deleted, or the version
+	// of the delete marker that was created. It is empty if the bucket is
+	// not versioned.
+	VersionID VersionID
+
+	// Size and ETag are only set for ObjectCreated events. ETag is the
+	// hex-encoded ETag, without quotes.
+	Size int64
+	ETag string
+
+	// Time is the time of the change, according to the TimeSource passed to
+	// WithTimeSource.
+	Time time.Time
+}
+
+// emit passes ev to the hook set with WithEventHook, if there is one. A panic
+// in the hook is logged rather than allowed to take down the server.
+func (g *GoFakeS3) emit(ev Event) {
+	if g.eventHook == nil {
+		return
+	}
+
+	defer func() {
+		if r := recover(); r != nil {
+			g.log.Print(LogErr, "event hook panicked:", ev.Type, ev.Bucket, ev.Key, r)
+		}
+	}()
+
+	ev.Time = g.timeSource.Now()
+	g.eventHook(ev)
+}
@@ -0,0 +1,318 @@
+package gofakes3
+
+import (
+	"context"
+	"io"
+	"math/rand"
+	"sync"
+	"time"
+)
+
+// FaultOption configures a FaultBackend.
+type FaultOption func(f *faultBackend)
+
+// WithFaultSeed seeds the random source that decides which calls fail, so
+// that a test sees the same failures each time it runs. The default seed is 0.
+func WithFaultSeed(seed int64) FaultOption {
+	return func(f *faultBackend) { f.seed = seed }
+}
+
+// WithFaultError sets the error returned by calls that fail. The default is
+// ErrInternal, which clients see as a 500 InternalError.
+//
+// The error is passed through GoFakeS3's normal error handling, so it should
+// be an ErrorCode or an error created with ErrorMessage() or similar.
+func WithFaultError(err error) FaultOption {
+	return func(f *faultBackend) { f.err = err }
+}
+
+// WithFaultProbability makes calls to the given Backend methods fail with a
+// probability between 0 and 1. If no methods are given, the probability
+// applies to all of them:
+//
+//	gofakes3.WithFaultProbability(0.1, "GetObject", "PutObject")
+func WithFaultProbability(probability float64, methods ...string) FaultOption {
+	return func(f *faultBackend) {
+		f.forMethods(methods, func(fault *methodFault) { fault.probability = probability })
+	}
+}
+
+// WithFaultLatency delays each call to the given Backend methods, or to all
+// of them if no methods are given, by d before it is passed on. The delay is
+// cut short if the request is cancelled.
+func WithFaultLatency(d time.Duration, methods ...string) FaultOption {
+	return func(f *faultBackend) {
+		f.forMethods(methods, func(fault *methodFault) { fault.latency = d })
+	}
+}
+
+// FaultBackend returns a BackendMiddleware that injects errors and latency
+// into calls to the methods of Backend, for testing how clients cope with an
+// unreliable S3. Without any options it passes every call through unchanged.
+//
+// Only the methods of Backend are affected; calls to optional interfaces such
+// as VersionedBackend go directly to the wrapped Backend.
+func FaultBackend(opts ...FaultOption) BackendMiddleware {
+	return func(b Backend) Backend {
+		f := &faultBackend{
+			backend: b,
+			err:     ErrInternal,
+			methods: map[string]*methodFault{},
+		}
+		for _, opt := range opts {
+			opt(f)
+		}
+		f.rand = rand.New(rand.NewSource(f.seed))
+		return f
+	}
+}
+
+// faultBackendMethods lists the methods of Backend, which are the methods
+// affected when WithFaultProbability or WithFaultLatency is given none.
+var faultBackendMethods = []string{
+	"ListBuckets",
+	"ListBucket",
+	"CreateBucket",
+	"BucketExists",
+	"DeleteBucket",
+	"GetObject",
+	"HeadObject",
+	"DeleteObject",
+	"PutObject",
+	"DeleteMulti",
+}
+
+type methodFault struct {
+	probability float64
+	latency     time.Duration
+}
+
+type faultBackend struct {
+	backend Backend
+	seed    int64
+	err     error
+	methods map[string]*methodFault
+
+	mu   sync.Mutex
+	rand *rand.Rand
+}
+
+var _ interface {
+	Backend
+	BackendUnwrapper
+	ContextBackend
+} = &faultBackend{}
+
+func (f *faultBackend) Unwrap() Backend { return f.backend }
+
+func (f *faultBackend) forMethods(methods []string, set func(fault *methodFault)) {
+	if len(methods) == 0 {
+		methods = faultBackendMethods
+	}
+	for _, method := range methods {
+		fault := f.methods[method]
+		if fault == nil {
+			fault = &methodFault{}
+			f.methods[method] = fault
+		}
+		set(fault)
+	}
+}
+
+// fault waits for the latency configured for method, then decides whether
+// the call fails. The random source is only used by methods that may fail,
+// so that adding latency does not change which calls fail.
+func (f *faultBackend) fault(ctx context.Context, method string) error {
+	fault := f.methods[method]
+	if fault == nil {
+		return nil
+	}
+	if fault.latency > 0 {
+		timer := time.NewTimer(fault.latency)
+		defer timer.Stop()
+		select {
+		case <-timer.C:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+	if fault.probability <= 0 {
+		return nil
+	}
+
+	f.mu.Lock()
+	failed := f.rand.Float64() < fault.probability
+	f.mu.Unlock()
+
+	if failed {
+		return f.err
+	}
+	return nil
+}
+
+func (f *faultBackend) ListBuckets() ([]BucketInfo, error) {
+	if err := f.fault(context.Background(), "ListBuckets"); err != nil {
+		return nil, err
+	}
+	return f.backend.ListBuckets()
+}
+
+func (f *faultBackend) ListBucket(name string, prefix *Prefix, page ListBucketPage) (*ObjectList, error) {
+	if err := f.fault(context.Background(), "ListBucket"); err != nil {
+		return nil, err
+	}
+	return f.backend.ListBucket(name, prefix, page)
+}
+
+func (f *faultBackend) CreateBucket(name string) error {
+	if err := f.fault(context.Background(), "CreateBucket"); err != nil {
+		return err
+	}
+	return f.backend.CreateBucket(name)
+}
+
+func (f *faultBackend) BucketExists(name string) (bool, error) {
+	if err := f.fault(context.Background(), "BucketExists"); err != nil {
+		return false, err
+	}
+	return f.backend.BucketExists(name)
+}
+
+func (f *faultBackend) DeleteBucket(name string) error {
+	if err := f.fault(context.Background(), "DeleteBucket"); err != nil {
+		return err
+	}
+	return f.backend.DeleteBucket(name)
+}
+
+func (f *faultBackend) GetObject(bucketName, objectName string, rangeRequest *ObjectRangeRequest) (*Object, error) {
+	if err := f.fault(context.Background(), "GetObject"); err != nil {
+		return nil, err
+	}
+	return f.backend.GetObject(bucketName, objectName, rangeRequest)
+}
+
+func (f *faultBackend) GetObjectContext(ctx context.Context, bucketName, objectName string, rangeRequest *ObjectRangeRequest) (*Object, error) {
+	if err := f.fault(ctx, "GetObject"); err != nil {
+		return nil, err
+	}
+	return getObjectContext(ctx, f.backend, bucketName, objectName, rangeRequest)
+}
+
+func (f *faultBackend) HeadObject(bucketName, objectName string) (*Object, error) {
+	if err := f.fault(context.Background(), "HeadObject"); err != nil {
+		return nil, err
+	}
+	return f.backend.HeadObject(bucketName, objectName)
+}
+
+func (f *faultBackend) DeleteObject(bucketName, objectName string) (ObjectDeleteResult, error) {
+	if err := f.fault(context.Background(), "DeleteObject"); err != nil {
+		return ObjectDeleteResult{}, err
+	}
+	return f.backend.DeleteObject(bucketName, objectName)
+}
+
+func (f *faultBackend) PutObject(bucketName, key string, meta map[string]string, input io.Reader, size int64) (PutObjectResult, error) {
+	if err := f.fault(context.Background(), "PutObject"); err != nil {
+		return PutObjectResult{}, err
+	}
+	return f.backend.PutObject(bucketName, key, meta, input, size)
+}
+
+func (f *faultBackend) PutObjectContext(ctx context.Context, bucketName, key string, meta map[string]string, input io.Reader, size int64) (PutObjectResult, error) {
+	if err := f.fault(ctx, "PutObject"); err != nil {
+		return PutObjectResult{}, err
+	}
+	return putObjectContext(ctx, f.backend, bucketName, key, meta, input, size)
+}
+
+func (f *faultBackend) DeleteMulti(bucketName string, objects ...string) (MultiDeleteResult, error) {
+	if err := f.fault(context.Background(), "DeleteMulti"); err != nil {
+		return MultiDeleteResult{}, err
+	}
+	return f.backend.DeleteMulti(bucketName, objects...)
+}
+
+// FaultConfig configures the faults WithFaultInjector injects into requests
+// before they are handled, to simulate a degraded S3.
+type FaultConfig struct {
+	// Operations maps the name of an S3 API action, as reported to Metrics
+	// (such as "PutObject"), to the faults injected into requests for it.
+	// The faults for "*" apply to every action that is not listed.
+	Operations map[string]OperationFault
+
+	// Seed seeds the random source that decides which requests fail, so that
+	// a test sees the same failures each time it runs.
+	Seed int64
+}
+
+// OperationFault is the fault injected into requests for an S3 API action.
+type OperationFault struct {
+	// Latency delays each request by this long before it is handled. The
+	// delay is cut short if the request is cancelled.
+	Latency time.Duration
+
+	// Probability is the chance, between 0 and 1, that a request fails
+	// with Error instead of being handled.
+	Probability float64
+
+	// Error is returned by requests that fail. It should be ErrSlowDown,
+	// which clients see as a 503 SlowDown, or ErrInternal, which is the
+	// default and is seen as a 500 InternalError.
+	Error ErrorCode
+}
+
+type faultInjector struct {
+	operations map[string]OperationFault
+
+	mu   sync.Mutex
+	rand *rand.Rand
+}
+
+func newFaultInjector(cfg FaultConfig) *faultInjector {
+	operations := make(map[string]OperationFault, len(cfg.Operations))
+	for op, fault := range cfg.Operations {
+		if fault.Error == "" {
+			fault.Error = ErrInternal
+		}
+		operations[op] = fault
+	}
+	return &faultInjector{
+		operations: operations,
+		rand:       rand.New(rand.NewSource(cfg.Seed)),
+	}
+}
+
+// inject waits for the latency configured for operation, then decides
+// whether the request fails. Like faultBackend.fault, the random source is
+// only used by operations that may fail.
+func (f *faultInjector) inject(ctx context.Context, operation string) error {
+	fault, ok := f.operations[operation]
+	if !ok {
+		if fault, ok = f.operations["*"]; !ok {
+			return nil
+		}
+	}
+	if fault.Latency > 0 {
+		timer := time.NewTimer(fault.Latency)
+		defer timer.Stop()
+		select {
+		case <-timer.C:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+	if fault.Probability <= 0 {
+		return nil
+	}
+
+	f.mu.Lock()
+	failed := f.rand.Float64() < fault.Probability
+	f.mu.Unlock()
+
+	if failed {
+		return fault.Error
+	}
+	return nil
+}
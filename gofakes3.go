@@ -2,19 +2,22 @@ package gofakes3
 
 import (
 	"bytes"
+	"context"
+	"crypto/rand"
 	"encoding/base64"
 	"encoding/hex"
 	"encoding/xml"
 	"fmt"
 	"io"
 	"io/ioutil"
-	"math"
+	"net"
 	"net/http"
 	"net/textproto"
 	"net/url"
+	"sort"
 	"strconv"
 	"strings"
-	"sync/atomic"
+	"sync"
 	"time"
 )
 
@@ -23,20 +26,70 @@ import (
 //
 // Logic is delegated to other components, like Backend or uploader.
 type GoFakeS3 struct {
-	requestID uint64
-
-	storage   Backend
-	versioned VersionedBackend
+	requestID func() string
+
+	storage    Backend
+	versioned  VersionedBackend
+	tagging    TaggingBackend
+	bucketTags BucketTaggingBackend
+	acl        ACLBackend
+	objectLock ObjectLockBackend
+	restore    RestoreBackend
+	lifecycle  LifecycleBackend
+	cors       CORSBackend
+	policy     PolicyBackend
+	website    WebsiteBackend
+	payment    RequestPaymentBackend
+	configs    BucketConfigBackend
+	multipart  MultipartBackend
 
 	timeSource              TimeSource
 	timeSkew                time.Duration
+	requireRequestTime      bool
 	metadataSizeLimit       int
 	integrityCheck          bool
 	failOnUnimplementedPage bool
 	hostBucket              bool
+	hostBucketBase          string
 	autoBucket              bool
+	autoBucketFunc          func(name string) bool
+	bucketNames             BucketNameValidation
+	authKeys                map[string]string
+	region                  string
+	owner                   *UserInfo
+	corsConfig              *CORSConfiguration
+	metrics                 Metrics
+	stats                   *serverStats
+	faults                  *faultInjector
+	mfa                     *mfaDevice
+	eventHook               func(Event)
+	compress                bool
+	compressMinBytes        int64
+	bandwidthLimit          int64
+	defaultObjectOwnership  ObjectOwnership
+	etag                    ETagFunc
+	maxUploadSize           int64
+	minPartSize             int64
 	uploader                *uploader
 	log                     Logger
+
+	lifecycleSweep  time.Duration
+	multipartExpiry time.Duration
+	stopSweep       chan struct{}
+	closeOnce       sync.Once
+
+	// inFlight counts the requests being handled and the sweepers, so that
+	// Shutdown can wait for them. Once shuttingDown is set, no more are
+	// added, and Serve refuses to start more servers.
+	inFlight     sync.WaitGroup
+	shutdownMu   sync.Mutex
+	shuttingDown bool
+	servers      []*http.Server
+	addr         net.Addr
+
+	// idConfigsMu serialises changes to the idBucketConfigs, as all of a
+	// bucket's configurations of the same kind are stored together.
+	idConfigsMu sync.Mutex
 }
 
 // New creates a new GoFakeS3 using the supplied Backend. Backends are pluggable.
@@ -48,12 +101,59 @@ func New(backend Backend, options ...Option) *GoFakeS3 {
 		timeSkew:          DefaultSkewLimit,
 		metadataSizeLimit: DefaultMetadataSizeLimit,
 		integrityCheck:    true,
+		minPartSize:       DefaultUploadPartSize,
 		uploader:          newUploader(),
-		requestID:         0,
+		stats:             newServerStats(),
+		requestID:         randomRequestID,
 	}
 
-	// versioned MUST be set before options as one of the options disables it:
-	s3.versioned, _ = backend.(VersionedBackend)
+	// versioned MUST be set before options as one of the options disables it.
+	// The optional interfaces are taken from the outermost Backend that
+	// implements them, so a BackendMiddleware only needs to implement the
+	// methods it wraps:
+	for b := backend; b != nil; b = unwrapBackend(b) {
+		if s3.versioned == nil {
+			s3.versioned, _ = b.(VersionedBackend)
+		}
+		if s3.tagging == nil {
+			s3.tagging, _ = b.(TaggingBackend)
+		}
+		if s3.bucketTags == nil {
+			s3.bucketTags, _ = b.(BucketTaggingBackend)
+		}
+		if s3.acl == nil {
+			s3.acl, _ = b.(ACLBackend)
+		}
+		if s3.objectLock == nil {
+			s3.objectLock, _ = b.(ObjectLockBackend)
+		}
+		if s3.restore == nil {
+			s3.restore, _ = b.(RestoreBackend)
+		}
+		if s3.lifecycle == nil {
+			s3.lifecycle, _ = b.(LifecycleBackend)
+		}
+		if s3.cors == nil {
+			s3.cors, _ = b.(CORSBackend)
+		}
+		if s3.policy == nil {
+			s3.policy, _ = b.(PolicyBackend)
+		}
+		if s3.website == nil {
+			s3.website, _ = b.(WebsiteBackend)
+		}
+		if s3.payment == nil {
+			s3.payment, _ = b.(RequestPaymentBackend)
+		}
+		if s3.configs == nil {
+			s3.configs, _ = b.(BucketConfigBackend)
+		}
+		if s3.multipart == nil {
+			s3.multipart, _ = b.(MultipartBackend)
+		}
+	}
+	s3.owner = defaultOwner()
+	s3.defaultObjectOwnership = ObjectOwnershipBucketOwnerEnforced
 
 	for _, opt := range options {
 		opt(s3)
@@ -64,19 +164,138 @@ func New(backend Backend, options ...Option) *GoFakeS3 {
 	if s3.timeSource == nil {
 		s3.timeSource = DefaultTimeSource()
 	}
+	if bm, ok := s3.metrics.(BackendMetrics); ok {
+		bm.ObserveBackend(s3.storage)
+	}
+	if state, ok := s3.multipart.(MultipartStateBackend); ok {
+		state.SetMultipartUploads(s3.uploader)
+	}
+
+	s3.stopSweep = make(chan struct{})
+	if s3.lifecycleSweep > 0 {
+		s3.inFlight.Add(1)
+		go s3.runLifecycleSweeper(s3.lifecycleSweep)
+	}
+	if s3.multipartExpiry > 0 {
+		s3.inFlight.Add(1)
+		go s3.runMultipartSweeper(s3.multipartExpiry)
+	}
 
 	return s3
 }
 
-func (g *GoFakeS3) nextRequestID() uint64 {
-	return atomic.AddUint64(&g.requestID, 1)
+// Close stops any background work started by GoFakeS3, such as the lifecycle
+// and multipart upload sweepers. It does not close the Backend.
+func (g *GoFakeS3) Close() error {
+	g.closeOnce.Do(func() { close(g.stopSweep) })
+	return nil
+}
+
+// Shutdown stops GoFakeS3 from accepting new requests, which fail with
+// ErrServiceUnavailable, and stops the sweepers. The servers started by Serve
+// stop listening, and close their connections once they are idle. It then
+// waits for the requests being handled, and any sweep in progress, to finish
+// or for ctx to be done, in which case it returns ctx.Err().
+//
+// Once Shutdown has returned nil, GoFakeS3 will not modify the Backend again,
+// so its contents may be inspected or snapshotted. Like Close, Shutdown does
+// not close the Backend, or any other http.Server serving GoFakeS3.
+func (g *GoFakeS3) Shutdown(ctx context.Context) error {
+	g.shutdownMu.Lock()
+	g.shuttingDown = true
+	servers := g.servers
+	g.shutdownMu.Unlock()
+	g.Close()
+
+	for _, srv := range servers {
+		if err := srv.Shutdown(ctx); err != nil {
+			return err
+		}
+	}
+
+	done := make(chan struct{})
+	go func() {
+		g.inFlight.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Serve accepts connections on l, which may be a TCP listener on an ephemeral
+// port or a Unix socket, and serves the handler returned by Server on them. It
+// blocks until Shutdown is called, then returns http.ErrServerClosed, or until
+// l fails. Serve may be called more than once, with different listeners.
+//
+// If Shutdown has already been called, Serve closes l and returns
+// http.ErrServerClosed at once.
+func (g *GoFakeS3) Serve(l net.Listener) error {
+	srv := &http.Server{Handler: g.Server()}
+
+	g.shutdownMu.Lock()
+	if g.shuttingDown {
+		g.shutdownMu.Unlock()
+		l.Close()
+		return http.ErrServerClosed
+	}
+	g.servers = append(g.servers, srv)
+	g.addr = l.Addr()
+	g.shutdownMu.Unlock()
+
+	// If Shutdown is called before srv starts serving, srv.Serve returns
+	// http.ErrServerClosed without accepting any connections:
+	return srv.Serve(l)
+}
+
+// Addr returns the address of the listener most recently passed to Serve,
+// such as the port the operating system chose for a listener on port 0, or
+// nil if Serve has not been called.
+func (g *GoFakeS3) Addr() net.Addr {
+	g.shutdownMu.Lock()
+	defer g.shutdownMu.Unlock()
+	return g.addr
+}
+
+// startRequest adds a request to inFlight, unless GoFakeS3 is shutting down.
+func (g *GoFakeS3) startRequest() bool {
+	g.shutdownMu.Lock()
+	defer g.shutdownMu.Unlock()
+	if g.shuttingDown {
+		return false
+	}
+	g.inFlight.Add(1)
+	return true
+}
+
+// randomRequestID is the default generator of the "x-amz-request-id"
+// header, which S3 fills with 16 random hex digits.
+func randomRequestID() string {
+	var b [8]byte
+	if _, err := rand.Read(b[:]); err != nil {
+		panic(err)
+	}
+	return fmt.Sprintf("%016X", b[:])
+}
+
+type requestIDKey struct{}
+
+// requestIDFromContext returns the ID assigned to a request by
+// requestIDMiddleware.
+func requestIDFromContext(ctx context.Context) string {
+	id, _ := ctx.Value(requestIDKey{}).(string)
+	return id
 }
 
 // Create the AWS S3 API
 func (g *GoFakeS3) Server() http.Handler {
-	var handler http.Handler = &withCORS{r: http.HandlerFunc(g.routeBase), log: g.log}
+	var handler http.Handler = &withCORS{r: http.HandlerFunc(g.routeBase), g: g}
 
-	if g.timeSkew != 0 {
+	if g.timeSkew != 0 || g.requireRequestTime {
 		handler = g.timeSkewMiddleware(handler)
 	}
 
@@ -84,20 +303,78 @@ func (g *GoFakeS3) Server() http.Handler {
 		handler = g.hostBucketMiddleware(handler)
 	}
 
-	return handler
+	if g.authKeys != nil {
+		handler = g.authMiddleware(handler)
+	}
+
+	handler = g.statsMiddleware(handler)
+	handler = g.inFlightMiddleware(handler)
+
+	return g.requestIDMiddleware(handler)
+}
+
+// requestIDMiddleware assigns an ID to each request, which is returned in the
+// "x-amz-request-id" and "x-amz-id-2" headers of every response, and in the
+// RequestId of error responses. The "Date" header of the response is set from
+// the TimeSource, rather than by net/http, so it agrees with the other dates
+// the server reports.
+func (g *GoFakeS3) requestIDMiddleware(handler http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, rq *http.Request) {
+		id := g.requestID()
+		g.log.Print(LogInfo, "REQUEST", id+":", rq.Method, rq.URL)
+
+		hdr := w.Header()
+		hdr.Set("x-amz-id-2", base64.StdEncoding.EncodeToString([]byte(id+id+id+id))) // x-amz-id-2 is 48 bytes of random stuff
+		hdr.Set("x-amz-request-id", id)
+		hdr.Set("Server", "AmazonS3")
+		hdr.Set("Date", g.timeSource.Now().UTC().Format(http.TimeFormat))
+
+		handler.ServeHTTP(w, rq.WithContext(context.WithValue(rq.Context(), requestIDKey{}, id)))
+	})
+}
+
+// inFlightMiddleware tracks the requests being handled for Shutdown, and
+// rejects requests once it has been called.
+func (g *GoFakeS3) inFlightMiddleware(handler http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, rq *http.Request) {
+		if !g.startRequest() {
+			w.Header().Set("Connection", "close")
+			g.httpError(w, rq, ErrServiceUnavailable)
+			return
+		}
+		defer g.inFlight.Done()
+
+		handler.ServeHTTP(w, rq)
+	})
 }
 
+// timeSkewMiddleware rejects requests whose "x-amz-date" header, or failing
+// that, "Date" header, differs from the server's TimeSource by more than the
+// limit set with WithTimeSkewLimit. Requests without either header are only
+// rejected if WithRequestTimeRequired was passed.
 func (g *GoFakeS3) timeSkewMiddleware(handler http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, rq *http.Request) {
-		timeHdr := rq.Header.Get("x-amz-date")
+		rqTime, timeHdr, err := requestTime(rq)
+		if err != nil {
+			g.httpError(w, rq, err)
+			return
+		}
+
+		if timeHdr == "" {
+			// Presigned URLs carry their time in the query, and are checked
+			// by checkPresignedExpiry instead. Browsers do not add a date to
+			// CORS preflight requests:
+			if g.requireRequestTime && rq.URL.Query().Get("X-Amz-Date") == "" && rq.Method != http.MethodOptions {
+				g.httpError(w, rq, errNoRequestTime)
+				return
+			}
 
-		if timeHdr != "" {
-			rqTime, _ := time.Parse("20060102T150405Z", timeHdr)
+		} else if g.timeSkew != 0 {
 			at := g.timeSource.Now()
 			skew := at.Sub(rqTime)
 
 			if skew < -g.timeSkew || skew > g.timeSkew {
-				g.httpError(w, rq, requestTimeTooSkewed(at, g.timeSkew))
+				g.httpError(w, rq, requestTimeTooSkewed(at, timeHdr, g.timeSkew))
 				return
 			}
 		}
@@ -106,14 +383,44 @@ func (g *GoFakeS3) timeSkewMiddleware(handler http.Handler) http.Handler {
 	})
 }
 
+var errNoRequestTime = ErrorMessage(ErrAccessDenied, "AWS authentication requires a valid Date or x-amz-date header")
+
+// requestTime returns the time the client says it sent rq at, from the
+// "x-amz-date" header if it has one, otherwise the "Date" header, along with
+// the value of the header. If rq has neither, timeHdr is empty.
+func requestTime(rq *http.Request) (at time.Time, timeHdr string, err error) {
+	if timeHdr = rq.Header.Get("x-amz-date"); timeHdr != "" {
+		at, err = time.Parse(sigV4TimeFormat, timeHdr)
+	} else if timeHdr = rq.Header.Get("Date"); timeHdr != "" {
+		at, err = http.ParseTime(timeHdr)
+	}
+	if err != nil {
+		return at, timeHdr, errNoRequestTime
+	}
+	return at, timeHdr, nil
+}
+
 // hostBucketMiddleware forces the server to use VirtualHost-style bucket URLs:
 // https://docs.aws.amazon.com/AmazonS3/latest/dev/UsingBucket.html
+//
+// If a base domain was set with WithHostBucketBase, requests to any other
+// host are left as path-style requests.
 func (g *GoFakeS3) hostBucketMiddleware(handler http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, rq *http.Request) {
-		parts := strings.SplitN(rq.Host, ".", 2)
-		bucket := parts[0]
+		bucket, website, ok := g.hostBucketName(rq.Host)
+		if !ok {
+			handler.ServeHTTP(w, rq)
+			return
+		}
 
 		p := rq.URL.Path
+		if website && g.website != nil {
+			if err := g.serveWebsite(bucket, p, w, rq); err != nil {
+				g.httpError(w, rq, err)
+			}
+			return
+		}
+
 		rq.URL.Path = "/" + bucket
 		if p != "/" {
 			rq.URL.Path += p
@@ -124,15 +431,80 @@ func (g *GoFakeS3) hostBucketMiddleware(handler http.Handler) http.Handler {
 	})
 }
 
+// hostBucketName extracts the bucket from the Host header of a
+// VirtualHost-style request, and reports whether the request was made to the
+// bucket's website endpoint. ok is false if the request should be treated as
+// a path-style request.
+//
+// Without a base domain, the bucket is the first label of the host. With one,
+// it is everything before the base domain, which allows bucket names that
+// contain dots; a host that is the base domain itself, an IP address,
+// 'localhost' or that does not end with the base domain has no bucket.
+func (g *GoFakeS3) hostBucketName(host string) (bucket string, website bool, ok bool) {
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+
+	if g.hostBucketBase == "" {
+		parts := strings.SplitN(host, ".", 2)
+		return parts[0], len(parts) == 2 && isWebsiteHost(parts[1]), true
+	}
+
+	host = strings.ToLower(host)
+	if host == "localhost" || net.ParseIP(host) != nil {
+		return "", false, false
+	}
+	bucket = strings.TrimSuffix(host, "."+g.hostBucketBase)
+	if bucket == host || bucket == "" {
+		return "", false, false
+	}
+
+	// The website endpoint sits between the bucket and the base domain, as
+	// in 'mybucket.s3-website.s3.localhost':
+	if idx := strings.LastIndexByte(bucket, '.'); idx > 0 && isWebsiteHost(bucket[idx+1:]) {
+		return bucket[:idx], true, true
+	}
+	return bucket, false, true
+}
+
+// requestScheme returns the scheme the client used to make the request. The
+// X-Forwarded-Proto header set by a TLS-terminating proxy takes precedence
+// over whether the request reached GoFakeS3 itself over TLS.
+func requestScheme(r *http.Request) string {
+	proto := strings.TrimSpace(strings.SplitN(r.Header.Get("X-Forwarded-Proto"), ",", 2)[0])
+	if proto = strings.ToLower(proto); proto == "http" || proto == "https" {
+		return proto
+	} else if r.TLS != nil {
+		return "https"
+	}
+	return "http"
+}
+
+// objectURL returns the URL of the object, as the client that made the
+// request would address it: VirtualHost-style requests get a VirtualHost-style
+// URL.
+func (g *GoFakeS3) objectURL(r *http.Request, bucket, object string) string {
+	u := url.URL{Scheme: requestScheme(r), Host: r.Host, Path: "/" + bucket + "/" + object}
+	if g.hostBucket {
+		if hostBucket, _, ok := g.hostBucketName(r.Host); ok && hostBucket == bucket {
+			u.Path = "/" + object
+		}
+	}
+	return u.String()
+}
+
 func (g *GoFakeS3) httpError(w http.ResponseWriter, r *http.Request, err error) {
-	resp := ensureErrorResponse(err, "") // FIXME: request id
+	id := requestIDFromContext(r.Context())
+	resp := ensureErrorResponse(err, id)
 	if resp.ErrorCode() == ErrInternal {
-		g.log.Print(LogErr, err)
+		g.log.Print(LogErr, "REQUEST", id+":", err)
 	}
 
-	w.WriteHeader(resp.ErrorCode().Status())
+	status := resp.ErrorCode().Status()
+	w.WriteHeader(status)
 
-	if r.Method != http.MethodHead {
+	// A 304 must not contain a body:
+	if r.Method != http.MethodHead && status != http.StatusNotModified {
 		if err := g.xmlEncoder(w).Encode(resp); err != nil {
 			g.log.Print(LogErr, err)
 			return
@@ -146,14 +518,45 @@ func (g *GoFakeS3) listBuckets(w http.ResponseWriter, r *http.Request) error {
 		return err
 	}
 
+	q := r.URL.Query()
 	s := &Storage{
-		Xmlns:   "http://s3.amazonaws.com/doc/2006-03-01/",
-		Buckets: buckets,
-		Owner: &UserInfo{
-			ID:          "fe7272ea58be830e56fe1663b10fafef",
-			DisplayName: "GoFakeS3",
-		},
+		Xmlns:  "http://s3.amazonaws.com/doc/2006-03-01/",
+		Owner:  g.owner,
+		Prefix: q.Get("prefix"),
+	}
+	if s.Prefix == "" && q.Get("max-buckets") == "" && q.Get("continuation-token") == "" {
+		s.Buckets = buckets
+		return g.xmlEncoder(w).Encode(s)
+	}
+
+	maxBuckets, err := parseClampedInt(q.Get("max-buckets"), MaxBucketsLimit, 1, MaxBucketsLimit)
+	if err != nil {
+		return ErrorInvalidArgument("max-buckets", q.Get("max-buckets"), "Provided max-buckets not an integer or within integer range")
+	}
+
+	// The continuation token hides the name of the first bucket of the next
+	// page:
+	var start string
+	if tok := q.Get("continuation-token"); tok != "" {
+		name, err := base64.URLEncoding.DecodeString(tok)
+		if err != nil {
+			return ErrorInvalidArgument("continuation-token", tok, "The continuation token provided is incorrect")
+		}
+		start = string(name)
+	}
+
+	sorted := make(Buckets, 0, len(buckets))
+	for _, bucket := range buckets {
+		if strings.HasPrefix(bucket.Name, s.Prefix) && bucket.Name >= start {
+			sorted = append(sorted, bucket)
+		}
 	}
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i].Name < sorted[j].Name })
+	if int64(len(sorted)) > maxBuckets {
+		s.ContinuationToken = base64.URLEncoding.EncodeToString([]byte(sorted[maxBuckets].Name))
+		sorted = sorted[:maxBuckets]
+	}
+	s.Buckets = sorted
 
 	return g.xmlEncoder(w).Encode(s)
 }
@@ -172,10 +575,6 @@ func (g *GoFakeS3) listBuckets(w http.ResponseWriter, r *http.Request) error {
 func (g *GoFakeS3) listBucket(bucketName string, w http.ResponseWriter, r *http.Request) error {
 	g.log.Print(LogInfo, "LIST BUCKET")
 
-	if err := g.ensureBucketExists(bucketName); err != nil {
-		return err
-	}
-
 	q := r.URL.Query()
 	prefix := prefixFromQuery(q)
 	page, err := listBucketPageFromQuery(q)
@@ -183,11 +582,16 @@ func (g *GoFakeS3) listBucket(bucketName string, w http.ResponseWriter, r *http.
 		return err
 	}
 
+	urlEncoded, err := urlEncodingFromQuery(q)
+	if err != nil {
+		return err
+	}
+
 	isVersion2 := q.Get("list-type") == "2"
 
 	g.log.Print(LogInfo, "bucketname:", bucketName, "prefix:", prefix, "page:", fmt.Sprintf("%+v", page))
 
-	objects, err := g.storage.ListBucket(bucketName, &prefix, page)
+	objects, err := g.listBucketPage(bucketName, &prefix, page)
 	if err != nil {
 		if err == ErrInternalPageNotImplemented && !g.failOnUnimplementedPage {
 			// We have observed (though not yet confirmed) that simple clients
@@ -229,6 +633,17 @@ func (g *GoFakeS3) listBucket(bucketName string, w http.ResponseWriter, r *http.
 			// into GoFakeS3 to spare backend implementers the trouble.
 			result.NextMarker = objects.NextMarker
 		}
+
+		// V1 listings always include the owner, unlike V2, which only does so
+		// when asked to with 'fetch-owner':
+		for _, v := range result.Contents {
+			if v.Owner == nil {
+				v.Owner = g.owner
+			}
+		}
+		if urlEncoded {
+			result.urlEncode()
+		}
 		return g.xmlEncoder(w).Encode(result)
 
 	} else {
@@ -238,45 +653,66 @@ func (g *GoFakeS3) listBucket(bucketName string, w http.ResponseWriter, r *http.
 			StartAfter:           q.Get("start-after"),
 			ContinuationToken:    q.Get("continuation-token"),
 		}
-		if objects.NextMarker != "" {
+
+		if objects.IsTruncated {
+			// Backends are not required to supply a NextMarker if there is no
+			// delimiter, in which case the last key is what a V1 client would
+			// use as the next marker anyway:
+			next := objects.NextMarker
+			if next == "" && len(objects.Contents) > 0 {
+				next = objects.Contents[len(objects.Contents)-1].Key
+			}
+
 			// We are just cheating with these continuation tokens; they're just the NextMarker
 			// from v1 in disguise! That may change at any time and should not be relied upon
 			// though.
-			result.NextContinuationToken = base64.URLEncoding.EncodeToString([]byte(objects.NextMarker))
+			if next != "" {
+				result.NextContinuationToken = base64.URLEncoding.EncodeToString([]byte(next))
+			}
 		}
 
 		// On the topic of "fetch-owner", the AWS docs say, in typically vague style:
 		// "If you want the owner information in the response, you can specify
 		// this parameter with the value set to true."
 		//
-		// What does the bare word 'true' mean when we're talking about a query
-		// string parameter, which can only be a string? Does it mean the word
-		// 'true'? Does it mean 'any truthy string'? Does it mean only the key
-		// needs to be present (i.e. '?fetch-owner'), which we are assuming
-		// for now? This is why you need proper technical writers.
-		//
-		// Probably need to hit up the s3assumer at some point, but until then, here's
-		// another FIXME!
-		if _, ok := q["fetch-owner"]; !ok {
-			for _, v := range result.Contents {
+		// The owner is omitted unless the value parses as a true boolean, so
+		// '?fetch-owner=false' and a missing parameter behave identically.
+		fetchOwner, _ := strconv.ParseBool(q.Get("fetch-owner"))
+		for _, v := range result.Contents {
+			if !fetchOwner {
 				v.Owner = nil
+			} else if v.Owner == nil {
+				v.Owner = g.owner
 			}
 		}
 
+		// The continuation token is opaque, so it is not encoded:
+		if urlEncoded {
+			result.urlEncode()
+		}
 		return g.xmlEncoder(w).Encode(result)
 	}
 }
 
+// listBucketPage lists a page of the bucket. Backends ignore a MaxKeys of 0,
+// but S3 answers max-keys=0 with an empty page that is not truncated, so the
+// Backend is not asked for one.
+func (g *GoFakeS3) listBucketPage(bucketName string, prefix *Prefix, page ListBucketPage) (*ObjectList, error) {
+	if page.MaxKeys == 0 {
+		return NewObjectList(), nil
+	}
+	return g.storage.ListBucket(bucketName, prefix, page)
+}
+
 func (g *GoFakeS3) getBucketLocation(bucketName string, w http.ResponseWriter, r *http.Request) error {
 	g.log.Print(LogInfo, "GET BUCKET LOCATION")
 
-	if err := g.ensureBucketExists(bucketName); err != nil { // S300006
-		return err
-	}
-
+	// Buckets in us-east-1 have no LocationConstraint, for historical reasons:
 	result := GetBucketLocation{
-		Xmlns:              "http://s3.amazonaws.com/doc/2006-03-01/",
-		LocationConstraint: "",
+		Xmlns: "http://s3.amazonaws.com/doc/2006-03-01/",
+	}
+	if region := g.bucketRegion(); region != DefaultRegion {
+		result.LocationConstraint = region
 	}
 
 	return g.xmlEncoder(w).Encode(result)
@@ -287,16 +723,16 @@ func (g *GoFakeS3) listBucketVersions(bucketName string, w http.ResponseWriter,
 		return ErrNotImplemented
 	}
 
-	if err := g.ensureBucketExists(bucketName); err != nil {
-		return err
-	}
-
 	q := r.URL.Query()
 	prefix := prefixFromQuery(q)
 	page, err := listBucketVersionsPageFromQuery(q)
 	if err != nil {
 		return err
 	}
+	urlEncoded, err := urlEncodingFromQuery(q)
+	if err != nil {
+		return err
+	}
 
 	// S300004:
 	if page.HasVersionIDMarker {
@@ -326,6 +762,23 @@ func (g *GoFakeS3) listBucketVersions(bucketName string, w http.ResponseWriter,
 		if ver.GetVersionID() == "" {
 			ver.setVersionID("null")
 		}
+
+		switch ver := ver.(type) {
+		case *Version:
+			if ver.Owner == nil {
+				ver.Owner = g.owner
+			}
+		case *DeleteMarker:
+			if ver.Owner == nil {
+				ver.Owner = g.owner
+			}
+		}
+	}
+	if bucket.IsTruncated && bucket.NextVersionIDMarker == "" && !bucket.HasPrefix(bucket.NextKeyMarker) {
+		bucket.NextVersionIDMarker = "null"
+	}
+	if urlEncoded {
+		bucket.urlEncode()
 	}
 
 	return g.xmlEncoder(w).Encode(bucket)
@@ -335,10 +788,34 @@ func (g *GoFakeS3) listBucketVersions(bucketName string, w http.ResponseWriter,
 func (g *GoFakeS3) createBucket(bucket string, w http.ResponseWriter, r *http.Request) error {
 	g.log.Print(LogInfo, "CREATE BUCKET:", bucket)
 
-	if err := ValidateBucketName(bucket); err != nil {
+	if err := g.validateBucketName(bucket); err != nil {
+		return err
+	}
+	if err := g.checkLocationConstraint(r); err != nil {
+		return err
+	}
+	acl, err := g.aclFromHeaders(r.Header)
+	if err != nil {
+		return err
+	}
+	ownership, err := g.objectOwnershipFromHeader(r.Header)
+	if err != nil {
+		return err
+	}
+	if ownership == ObjectOwnershipBucketOwnerEnforced && acl != nil && !acl.isOwnerOnly(g.owner) {
+		return ErrInvalidBucketAclWithObjectOwnership
+	}
+	if err := g.storage.CreateBucket(bucket); IsAlreadyExists(err) {
+		if err := g.recreateBucket(bucket, acl, err); err != nil {
+			return err
+		}
+		w.Header().Set("Location", "/"+bucket)
+		w.Write([]byte{})
+		return nil
+	} else if err != nil {
 		return err
 	}
-	if err := g.storage.CreateBucket(bucket); err != nil {
+	if err := g.initBucket(bucket, ownership, acl); err != nil {
 		return err
 	}
 
@@ -347,14 +824,31 @@ func (g *GoFakeS3) createBucket(bucket string, w http.ResponseWriter, r *http.Re
 	return nil
 }
 
+// initBucket stores what GoFakeS3 keeps about a bucket it has just created,
+// either for CreateBucket or with WithAutoBucket: its owner, and its
+// ObjectOwnership and ACL, unless they are empty.
+func (g *GoFakeS3) initBucket(bucket string, ownership ObjectOwnership, acl *AccessControlPolicy) error {
+	if err := g.setBucketOwner(bucket); err != nil {
+		return err
+	}
+	if ownership != "" {
+		if err := g.setObjectOwnership(bucket, ownership); err != nil {
+			return err
+		}
+	}
+	if acl != nil {
+		if err := g.acl.SetBucketACL(bucket, *acl); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
 // DeleteBucket deletes the bucket in the underlying backend, if and only if it
 // contains no items.
 func (g *GoFakeS3) deleteBucket(bucket string, w http.ResponseWriter, r *http.Request) error {
 	g.log.Print(LogInfo, "DELETE BUCKET:", bucket)
 
-	if err := g.ensureBucketExists(bucket); err != nil {
-		return err
-	}
 	if err := g.storage.DeleteBucket(bucket); err != nil {
 		return err
 	}
@@ -368,11 +862,77 @@ func (g *GoFakeS3) headBucket(bucket string, w http.ResponseWriter, r *http.Requ
 	g.log.Print(LogInfo, "HEAD BUCKET", bucket)
 	g.log.Print(LogInfo, "bucketname:", bucket)
 
-	if err := g.ensureBucketExists(bucket); err != nil {
+	w.Header().Set("x-amz-bucket-region", g.bucketRegion())
+	w.Write([]byte{})
+	return nil
+}
+
+// recreateBucket handles a CreateBucket request for a bucket that already
+// exists, for which the Backend returned exists. S3 only accepts it from the
+// bucket's owner in us-east-1, where the bucket's ACL is reset to the one
+// requested, for compatibility with clients that predate
+// BucketAlreadyOwnedByYou.
+func (g *GoFakeS3) recreateBucket(bucket string, acl *AccessControlPolicy, exists error) error {
+	owner, err := g.bucketOwner(bucket)
+	if err != nil {
 		return err
 	}
+	if owner != g.owner.ID {
+		return exists
+	}
+	if g.bucketRegion() != DefaultRegion {
+		return ResourceError(ErrBucketAlreadyOwnedByYou, bucket)
+	}
 
-	w.Write([]byte{})
+	if g.acl == nil {
+		return nil
+	}
+	if err := g.checkRequestedACL(bucket, acl); err != nil {
+		return err
+	}
+	if acl == nil {
+		acl, _ = cannedACL(ACLPrivate, g.owner)
+	}
+	return g.acl.SetBucketACL(bucket, *acl)
+}
+
+// bucketRegion returns the region set with WithRegion, or DefaultRegion.
+func (g *GoFakeS3) bucketRegion() string {
+	if g.region == "" {
+		return DefaultRegion
+	}
+	return g.region
+}
+
+// checkLocationConstraint fails with ErrInvalidLocationConstraint if the
+// CreateBucketConfiguration in the body of a CreateBucket request does not
+// match the region set with WithRegion. Any LocationConstraint is accepted if
+// WithRegion was not used, as the SDKs send the client's region, which
+// existing users may not have configured to match.
+func (g *GoFakeS3) checkLocationConstraint(r *http.Request) error {
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		return err
+	}
+	if len(bytes.TrimSpace(body)) == 0 {
+		return nil
+	}
+
+	var config CreateBucketConfiguration
+	if err := xml.Unmarshal(body, &config); err != nil {
+		return ErrorMessage(ErrMalformedXML, err.Error())
+	}
+
+	if g.region == "" || config.LocationConstraint == "" {
+		return nil
+	}
+
+	// Buckets are created in us-east-1 by leaving LocationConstraint out, so
+	// S3 rejects it if it is given explicitly:
+	if config.LocationConstraint != g.region || g.region == DefaultRegion {
+		return ErrorMessagef(ErrInvalidLocationConstraint,
+			"The specified location-constraint %q is not valid for this server, which is in %q", config.LocationConstraint, g.region)
+	}
 	return nil
 }
 
@@ -386,31 +946,51 @@ func (g *GoFakeS3) getObject(
 
 	g.log.Print(LogInfo, "GET OBJECT", "Bucket:", bucket, "Object:", object)
 
-	if err := g.ensureBucketExists(bucket); err != nil {
+	partNumber, err := parsePartNumber(r)
+	if err != nil {
 		return err
 	}
-
-	rnge, err := parseRangeHeader(r.Header.Get("Range"))
+	rnges, err := parseRangesHeader(r.Header.Get("Range"))
 	if err != nil {
-		return err
+		return g.rangeNotSatisfiable(bucket, object, versionID, w, err)
+	}
+
+	// Multiple ranges are sliced out of the full object once it has been
+	// retrieved; backends only ever see a single range:
+	var rnge *ObjectRangeRequest
+	if len(rnges) == 1 {
+		rnge = &rnges[0]
+	}
+
+	// A part is fetched as the range it covers, which is only known once the
+	// parts of the object have been read from its metadata. A delete marker
+	// is left for writeGetOrHeadObjectResponse to report:
+	var partsCount int
+	if partNumber > 0 {
+		head, err := g.headObjectOrVersion(bucket, object, versionID)
+		if err != nil {
+			return err
+		}
+		if !head.IsDeleteMarker {
+			if rnge, partsCount, err = partRange(head, partNumber); err != nil {
+				return err
+			}
+		}
 	}
 
 	var obj *Object
 
 	{ // get object from backend
 		if versionID == "" {
-			obj, err = g.storage.GetObject(bucket, object, rnge)
-			if err != nil {
-				return err
-			}
+			obj, err = getObjectContext(r.Context(), g.storage, bucket, object, rnge)
 		} else {
 			if g.versioned == nil {
 				return ErrNotImplemented
 			}
 			obj, err = g.versioned.GetObjectVersion(bucket, object, versionID, rnge)
-			if err != nil {
-				return err
-			}
+		}
+		if err != nil {
+			return g.rangeNotSatisfiable(bucket, object, versionID, w, err)
 		}
 	}
 
@@ -423,42 +1003,146 @@ func (g *GoFakeS3) getObject(
 	if err := g.writeGetOrHeadObjectResponse(obj, w, r); err != nil {
 		return err
 	}
+	if err := g.writeTaggingCount(bucket, object, obj.VersionID, w); err != nil {
+		return err
+	}
+	if err := g.writeObjectLockHeaders(bucket, object, obj.VersionID, w); err != nil {
+		return err
+	}
+	if err := g.writeRestoreHeader(bucket, object, obj, true, w); err != nil {
+		return err
+	}
+	if err := g.writeReplicationHeader(bucket, object, obj.VersionID, w); err != nil {
+		return err
+	}
+	if partsCount > 0 {
+		w.Header().Set("x-amz-mp-parts-count", strconv.Itoa(partsCount))
+	}
+
+	w = g.throttle(w, r)
+	if len(rnges) > 1 {
+		return g.writeObjectRanges(obj, rnges, w)
+	}
+	if g.shouldCompress(obj, r) {
+		return writeCompressed(w, obj.Contents)
+	}
 
-	// Writes Content-Length, and Content-Range if applicable:
+	// Writes Content-Length, and Content-Range and the 206 status if
+	// applicable:
 	obj.Range.writeHeader(obj.Size, w)
 
-	if _, err := io.Copy(w, obj.Contents); err != nil {
+	if _, err := copyContents(w, obj.Contents); err != nil {
 		return err
 	}
 
 	return nil
 }
 
-// writeGetOrHeadObjectResponse contains shared logic for constructing headers for
-// a HEAD and a GET request for a /bucket/object URL.
-func (g *GoFakeS3) writeGetOrHeadObjectResponse(obj *Object, w http.ResponseWriter, r *http.Request) error {
-	// "If the current version of the object is a delete marker, Amazon S3
-	// behaves as if the object was deleted and includes x-amz-delete-marker:
-	// true in the response."
-	if obj.IsDeleteMarker {
-		w.Header().Set("x-amz-version-id", string(obj.VersionID))
-		w.Header().Set("x-amz-delete-marker", "true")
-		return KeyNotFound(obj.Name)
+// writeObjectRanges responds to a GET request for more than one range. obj
+// must contain the full object. Unsatisfiable ranges are dropped; if none are
+// left, the request fails with ErrInvalidRange.
+//
+// If obj.Contents implements io.ReaderAt, only the ranges are read from it.
+// Otherwise, the whole object is read into memory first.
+func (g *GoFakeS3) writeObjectRanges(obj *Object, rnges []ObjectRangeRequest, w http.ResponseWriter) error {
+	var satisfiable []*ObjectRange
+	for i := range rnges {
+		if rnge, err := rnges[i].Range(obj.Size); err == nil {
+			satisfiable = append(satisfiable, rnge)
+		}
 	}
-
-	for mk, mv := range obj.Metadata {
-		w.Header().Set(mk, mv)
+	if len(satisfiable) == 0 {
+		w.Header().Set("Content-Range", fmt.Sprintf("bytes */%d", obj.Size))
+		return ErrInvalidRange
 	}
 
-	if obj.VersionID != "" {
+	body, ok := obj.Contents.(io.ReaderAt)
+	if !ok {
+		bts, err := ReadAll(obj.Contents, obj.Size)
+		if err != nil {
+			return err
+		}
+		body = bytes.NewReader(bts)
+	}
+
+	contentType := w.Header().Get("Content-Type")
+	return writeMultipartRanges(w, body, obj.Size, contentType, satisfiable)
+}
+
+// rangeNotSatisfiable adds the 'Content-Range: bytes */<size>' header
+// required by RFC 7233 if err is ErrInvalidRange. Any other error is returned
+// untouched.
+func (g *GoFakeS3) rangeNotSatisfiable(bucket, object string, versionID VersionID, w http.ResponseWriter, err error) error {
+	if !HasErrorCode(err, ErrInvalidRange) {
+		return err
+	}
+
+	var obj *Object
+	var herr error
+	if versionID == "" {
+		obj, herr = g.storage.HeadObject(bucket, object)
+	} else if g.versioned != nil {
+		obj, herr = g.versioned.HeadObjectVersion(bucket, object, versionID)
+	}
+	if herr != nil {
+		return herr
+	}
+
+	if obj != nil {
+		w.Header().Set("Content-Range", fmt.Sprintf("bytes */%d", obj.Size))
+	}
+	return err
+}
+
+// writeGetOrHeadObjectResponse contains shared logic for constructing headers for
+// a HEAD and a GET request for a /bucket/object URL.
+func (g *GoFakeS3) writeGetOrHeadObjectResponse(obj *Object, w http.ResponseWriter, r *http.Request) error {
+	// "If the current version of the object is a delete marker, Amazon S3
+	// behaves as if the object was deleted and includes x-amz-delete-marker:
+	// true in the response."
+	if obj.IsDeleteMarker {
+		w.Header().Set("x-amz-version-id", string(obj.VersionID))
+		w.Header().Set("x-amz-delete-marker", "true")
+		return KeyNotFound(obj.Name)
+	}
+
+	if err := checkSSECustomerKey(obj.Metadata, r.Header, ""); err != nil {
+		return err
+	}
+
+	for mk, mv := range obj.Metadata {
+		if mk == objectPartsMetaKey {
+			continue
+		}
+		// S3 only reports the storage class if it is not STANDARD:
+		if mk == StorageClassMetaKey && StorageClass(mv) == StorageStandard {
+			continue
+		}
+		w.Header().Set(mk, mv)
+	}
+
+	// S3 stores objects uploaded without a Content-Type as
+	// 'binary/octet-stream'; without this, net/http would sniff the type of a
+	// GET response, and a HEAD response would have none:
+	if w.Header().Get("Content-Type") == "" {
+		w.Header().Set("Content-Type", "binary/octet-stream")
+	}
+	writeResponseOverrides(r.URL.Query(), w)
+
+	if obj.VersionID != "" {
 		w.Header().Set("x-amz-version-id", string(obj.VersionID))
 	}
 
-	etag := `"` + hex.EncodeToString(obj.Hash) + `"`
+	etag := ObjectETag(obj.Hash, obj.Metadata)
 	w.Header().Set("ETag", etag)
 
-	if r.Header.Get("If-None-Match") == etag {
-		return ErrNotModified
+	lastModified := objectLastModified(obj)
+	if lastModified != "" {
+		w.Header().Set("Last-Modified", lastModified)
+	}
+
+	if err := checkConditionalHeaders(r.Header, etag, lastModified); err != nil {
+		return err
 	}
 
 	w.Header().Set("Accept-Ranges", "bytes")
@@ -466,6 +1150,134 @@ func (g *GoFakeS3) writeGetOrHeadObjectResponse(obj *Object, w http.ResponseWrit
 	return nil
 }
 
+// responseOverrides maps the query parameters that override headers of a GET
+// or HEAD object response to the header they replace.
+var responseOverrides = map[string]string{
+	"response-cache-control":       "Cache-Control",
+	"response-content-disposition": "Content-Disposition",
+	"response-content-encoding":    "Content-Encoding",
+	"response-content-language":    "Content-Language",
+	"response-content-type":        "Content-Type",
+	"response-expires":             "Expires",
+}
+
+// writeResponseOverrides replaces the stored metadata of an object with the
+// values of any response-* query parameters, which presigned URLs use to
+// force a download with a particular filename, for example.
+func writeResponseOverrides(query url.Values, w http.ResponseWriter) {
+	for param, header := range responseOverrides {
+		if v := query.Get(param); v != "" {
+			w.Header().Set(header, v)
+		}
+	}
+}
+
+// checkConditionalHeaders evaluates the If-Match, If-Unmodified-Since,
+// If-None-Match and If-Modified-Since headers in the order prescribed by
+// RFC 7232, section 6. It returns ErrPreconditionFailed or ErrNotModified
+// if the object should not be returned.
+//
+// The date conditions are ignored if the lastModified header value can not
+// be parsed, or if the corresponding ETag condition is present.
+func checkConditionalHeaders(headers http.Header, etag string, lastModified string) error {
+	modified, modifiedErr := parseHeaderTime(lastModified)
+
+	if ifMatch := headers.Get("If-Match"); ifMatch != "" {
+		if !etagListMatches(ifMatch, etag, false) {
+			return ErrPreconditionFailed
+		}
+	} else if since, err := parseHeaderTime(headers.Get("If-Unmodified-Since")); err == nil && modifiedErr == nil {
+		if modified.After(since) {
+			return ErrPreconditionFailed
+		}
+	}
+
+	if ifNoneMatch := headers.Get("If-None-Match"); ifNoneMatch != "" {
+		if etagListMatches(ifNoneMatch, etag, true) {
+			return ErrNotModified
+		}
+	} else if since, err := parseHeaderTime(headers.Get("If-Modified-Since")); err == nil && modifiedErr == nil {
+		if !modified.After(since) {
+			return ErrNotModified
+		}
+	}
+
+	return nil
+}
+
+// etagListMatches reports whether etag is in the comma separated list of
+// entity tags found in an If-Match or If-None-Match header. The wildcard "*"
+// matches any etag.
+//
+// S3 ETags are always strong, so when weak is false, any weak candidate
+// ('W/"..."') fails to match. Candidates are accepted with or without the
+// surrounding quotes as some clients strip them.
+func etagListMatches(list string, etag string, weak bool) bool {
+	etag = strings.Trim(etag, `"`)
+
+	for _, candidate := range strings.Split(list, ",") {
+		candidate = strings.TrimSpace(candidate)
+		if candidate == "*" {
+			return true
+		}
+		if strings.HasPrefix(candidate, "W/") {
+			if !weak {
+				continue
+			}
+			candidate = candidate[2:]
+		}
+		if strings.Trim(candidate, `"`) == etag {
+			return true
+		}
+	}
+	return false
+}
+
+// writeObjectLockHeaders sets the x-amz-object-lock-* headers if the Backend
+// supports object locks and the object version has a retention or legal hold.
+func (g *GoFakeS3) writeObjectLockHeaders(bucket, object string, versionID VersionID, w http.ResponseWriter) error {
+	if g.objectLock == nil {
+		return nil
+	}
+
+	retention, err := g.objectLock.GetObjectRetention(bucket, object, versionID)
+	if err != nil {
+		return err
+	}
+	if retention != nil && retention.Mode != "" {
+		w.Header().Set("x-amz-object-lock-mode", string(retention.Mode))
+		w.Header().Set("x-amz-object-lock-retain-until-date", retention.RetainUntilDate.UTC().Format(time.RFC3339))
+	}
+
+	hold, err := g.objectLock.GetObjectLegalHold(bucket, object, versionID)
+	if err != nil {
+		return err
+	}
+	if hold != "" {
+		w.Header().Set("x-amz-object-lock-legal-hold", string(hold))
+	}
+
+	return nil
+}
+
+// writeTaggingCount sets the x-amz-tagging-count header if the Backend
+// supports tagging and the version of the object has at least one tag.
+func (g *GoFakeS3) writeTaggingCount(bucket, object string, versionID VersionID, w http.ResponseWriter) error {
+	if g.tagging == nil {
+		return nil
+	}
+
+	tags, err := g.tagging.GetObjectTagging(bucket, object, versionID)
+	if err != nil {
+		return err
+	}
+
+	if len(tags) > 0 {
+		w.Header().Set("x-amz-tagging-count", strconv.Itoa(len(tags)))
+	}
+	return nil
+}
+
 // headObject retrieves only meta information of an object and not the whole.
 func (g *GoFakeS3) headObject(
 	bucket, object string,
@@ -476,38 +1288,80 @@ func (g *GoFakeS3) headObject(
 
 	g.log.Print(LogInfo, "HEAD OBJECT", bucket, object)
 
-	if err := g.ensureBucketExists(bucket); err != nil {
+	partNumber, err := parsePartNumber(r)
+	if err != nil {
 		return err
 	}
 
-	obj, err := g.storage.HeadObject(bucket, object)
+	obj, err := g.headObjectOrVersion(bucket, object, versionID)
 	if err != nil {
 		return err
 	}
-	if obj == nil {
-		g.log.Print(LogErr, "unexpected nil object for key", bucket, object)
-		return ErrInternal
-	}
 	defer obj.Contents.Close()
 
 	if err := g.writeGetOrHeadObjectResponse(obj, w, r); err != nil {
 		return err
 	}
+	if err := g.writeTaggingCount(bucket, object, obj.VersionID, w); err != nil {
+		return err
+	}
+	if err := g.writeObjectLockHeaders(bucket, object, obj.VersionID, w); err != nil {
+		return err
+	}
+	if err := g.writeRestoreHeader(bucket, object, obj, false, w); err != nil {
+		return err
+	}
+	if err := g.writeReplicationHeader(bucket, object, obj.VersionID, w); err != nil {
+		return err
+	}
+
+	var rnge *ObjectRange
+	if partNumber > 0 {
+		rangeRequest, partsCount, err := partRange(obj, partNumber)
+		if err != nil {
+			return err
+		}
+		if rnge, err = rangeRequest.Range(obj.Size); err != nil {
+			return err
+		}
+		if partsCount > 0 {
+			w.Header().Set("x-amz-mp-parts-count", strconv.Itoa(partsCount))
+		}
+	}
 
-	w.Header().Set("Content-Length", fmt.Sprintf("%d", obj.Size))
+	// Set explicitly, as net/http does not add a Content-Length to a HEAD
+	// response without a body:
+	rnge.writeHeader(obj.Size, w)
 
 	return nil
 }
 
+// headObjectOrVersion returns the object without its contents, or the given
+// version of it if versionID is not empty.
+func (g *GoFakeS3) headObjectOrVersion(bucket, object string, versionID VersionID) (obj *Object, err error) {
+	if versionID == "" {
+		obj, err = g.storage.HeadObject(bucket, object)
+	} else {
+		if g.versioned == nil {
+			return nil, ErrNotImplemented
+		}
+		obj, err = g.versioned.HeadObjectVersion(bucket, object, versionID)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if obj == nil {
+		g.log.Print(LogErr, "unexpected nil object for key", bucket, object)
+		return nil, ErrInternal
+	}
+	return obj, nil
+}
+
 // createObjectBrowserUpload allows objects to be created from a multipart upload initiated
 // by a browser form.
 func (g *GoFakeS3) createObjectBrowserUpload(bucket string, w http.ResponseWriter, r *http.Request) error {
 	g.log.Print(LogInfo, "CREATE OBJECT THROUGH BROWSER UPLOAD")
 
-	if err := g.ensureBucketExists(bucket); err != nil {
-		return err
-	}
-
 	const _24MB = (1 << 20) * 24 // maximum amount of memory before temp files are used
 	if err := r.ParseMultipartForm(_24MB); nil != err {
 		return ErrMalformedPOSTRequest
@@ -518,6 +1372,9 @@ func (g *GoFakeS3) createObjectBrowserUpload(bucket string, w http.ResponseWrite
 		return ErrIncorrectNumberOfFilesInPostRequest
 	}
 	key := keyValues[0]
+	if err := g.validateObjectKey(key); err != nil {
+		return err
+	}
 
 	g.log.Print(LogInfo, "(BUC)", bucket)
 	g.log.Print(LogInfo, "(KEY)", key)
@@ -538,34 +1395,101 @@ func (g *GoFakeS3) createObjectBrowserUpload(bucket string, w http.ResponseWrite
 	if err != nil {
 		return err
 	}
+	acl, err := g.aclFromHeaders(http.Header{"X-Amz-Acl": r.MultipartForm.Value["acl"]})
+	if err != nil {
+		return err
+	}
+	if err := g.checkRequestedACL(bucket, acl); err != nil {
+		return err
+	}
 
 	if len(key) > KeySizeLimit {
 		return ResourceError(ErrKeyTooLong, key)
 	}
 
+	if err := g.checkUploadSize(fileHeader.Size); err != nil {
+		return err
+	}
+
 	// FIXME: how does Content-MD5 get sent when using the browser? does it?
 	rdr, err := newHashingReader(infile, "")
 	if err != nil {
 		return err
 	}
 
-	result, err := g.storage.PutObject(bucket, key, meta, rdr, fileHeader.Size)
+	result, err := putObjectContext(r.Context(), g.storage, bucket, key, meta, rdr, fileHeader.Size)
 	if err != nil {
 		return err
 	}
+	if err := g.storeObjectACL(bucket, key, result.VersionID, acl); err != nil {
+		return err
+	}
+	etag := hex.EncodeToString(rdr.Sum(nil))
+	g.emit(Event{Type: EventObjectCreatedPost, Bucket: bucket, Key: key, VersionID: result.VersionID, Size: fileHeader.Size, ETag: etag})
+
 	if result.VersionID != "" {
 		w.Header().Set("x-amz-version-id", string(result.VersionID))
 	}
 
-	w.Header().Set("ETag", `"`+hex.EncodeToString(rdr.Sum(nil))+`"`)
+	w.Header().Set("ETag", `"`+etag+`"`)
+	return nil
+}
+
+// checkUploadSize returns ErrEntityTooLarge if an object of the given size is
+// larger than allowed by WithMaxUploadSize.
+func (g *GoFakeS3) checkUploadSize(size int64) error {
+	if g.maxUploadSize > 0 && size > g.maxUploadSize {
+		return ErrorEntityTooLarge(size, g.maxUploadSize)
+	}
 	return nil
 }
 
+// continueUpload sends the interim '100 Continue' response to a client that
+// sent 'Expect: 100-continue' and is waiting for it before sending the body.
+// It must only be called once everything but the body has been checked, so
+// that a request that would fail gets the error instead; net/http closes the
+// connection after an error if the body was not read.
+//
+// net/http only sends '100 Continue' when the body is first read, which a
+// Backend may not do until it has done other work, so the client would wait
+// for its own timeout first. Reading nothing from the body sends it straight
+// away, and does nothing for a request that did not ask for it. If the client
+// gives up and closes the connection, the read of the body fails.
+func continueUpload(r *http.Request) {
+	if r.Body != nil {
+		r.Body.Read(nil)
+	}
+}
+
+// readUnknownLength reads all of a body whose size was not sent with the
+// request, so that it can be passed to a Backend with its size. The body may
+// be no larger than MaxPutObjectSize, or the limit set by WithMaxUploadSize.
+func (g *GoFakeS3) readUnknownLength(ctx context.Context, r io.Reader) (io.Reader, int64, error) {
+	max := int64(MaxPutObjectSize)
+	if g.maxUploadSize > 0 && g.maxUploadSize < max {
+		max = g.maxUploadSize
+	}
+	body, err := ioutil.ReadAll(&maxSizeReader{r: &contextReader{ctx: ctx, r: r}, max: max})
+	if err != nil {
+		return nil, 0, err
+	}
+	return bytes.NewReader(body), int64(len(body)), nil
+}
+
+// maxPartSize returns the size of the largest part that may be uploaded,
+// which is MaxUploadPartSize unless WithMaxUploadSize allows less.
+func (g *GoFakeS3) maxPartSize() int64 {
+	if g.maxUploadSize > 0 && g.maxUploadSize < MaxUploadPartSize {
+		return g.maxUploadSize
+	}
+	return MaxUploadPartSize
+}
+
 // CreateObject creates a new S3 object.
 func (g *GoFakeS3) createObject(bucket, object string, w http.ResponseWriter, r *http.Request) (err error) {
 	g.log.Print(LogInfo, "CREATE OBJECT:", bucket, object)
 
-	if err := g.ensureBucketExists(bucket); err != nil {
+	if err := g.validateObjectKey(object); err != nil {
 		return err
 	}
 
@@ -573,40 +1497,57 @@ func (g *GoFakeS3) createObject(bucket, object string, w http.ResponseWriter, r
 	if err != nil {
 		return err
 	}
+	if _, err := sseCustomerKeyFromHeaders(r.Header, ""); err != nil {
+		return err
+	}
+	acl, err := g.aclFromHeaders(r.Header)
+	if err != nil {
+		return err
+	}
+	if err := g.checkRequestedACL(bucket, acl); err != nil {
+		return err
+	}
 
 	if _, ok := meta["X-Amz-Copy-Source"]; ok {
-		return g.copyObject(bucket, object, meta, w, r)
+		return g.copyObject(bucket, object, meta, acl, w, r)
 	}
 
-	contentLength := r.Header.Get("Content-Length")
-	if contentLength == "" {
+	// A body sent with 'Transfer-Encoding: chunked' has no Content-Length;
+	// its size is only known once all of it has been read:
+	var size int64 = -1
+	if contentLength := r.Header.Get("Content-Length"); contentLength != "" {
+		size, err = strconv.ParseInt(contentLength, 10, 64)
+		if err != nil || size < 0 {
+			w.WriteHeader(http.StatusBadRequest) // XXX: no code for this, according to s3tests
+			return nil
+		}
+	} else if len(r.TransferEncoding) == 0 || r.TransferEncoding[0] != "chunked" {
 		return ErrMissingContentLength
 	}
 
-	size, err := strconv.ParseInt(contentLength, 10, 64)
-	if err != nil || size < 0 {
-		w.WriteHeader(http.StatusBadRequest) // XXX: no code for this, according to s3tests
-		return nil
-	}
-
 	if len(object) > KeySizeLimit {
 		return ResourceError(ErrKeyTooLong, object)
 	}
 
 	var md5Base64 string
+	var cs *checksum
 	if g.integrityCheck {
 		md5Base64 = r.Header.Get("Content-MD5")
 
 		if _, ok := r.Header[textproto.CanonicalMIMEHeaderKey("Content-MD5")]; ok && md5Base64 == "" {
 			return ErrInvalidDigest // Satisfies s3tests
 		}
+
+		if cs, err = checksumFromHeaders(r.Header); err != nil {
+			return err
+		}
 	}
 
 	var reader io.Reader
 
-	if sha, ok := meta["X-Amz-Content-Sha256"]; ok && sha == "STREAMING-AWS4-HMAC-SHA256-PAYLOAD" {
+	if isStreamingUpload(r.Header) {
 		reader = newChunkedReader(r.Body)
-		size, err = strconv.ParseInt(meta["X-Amz-Decoded-Content-Length"], 10, 64)
+		size, err = decodedContentLength(r.Header)
 		if err != nil {
 			w.WriteHeader(http.StatusBadRequest) // XXX: no code for this, according to s3tests
 			return nil
@@ -615,6 +1556,19 @@ func (g *GoFakeS3) createObject(bucket, object string, w http.ResponseWriter, r
 		reader = r.Body
 	}
 
+	if err := g.checkUploadSize(size); err != nil {
+		return err
+	}
+	if g.maxUploadSize > 0 {
+		reader = &maxSizeReader{r: reader, max: g.maxUploadSize}
+	}
+
+	// The checksum is stored with the rest of the X-Amz-* headers in the
+	// metadata, which is how it is returned by GET and HEAD:
+	if cs != nil {
+		reader = newChecksumReader(reader, cs)
+	}
+
 	// hashingReader is still needed to get the ETag even if integrityCheck
 	// is set to false:
 	rdr, err := newHashingReader(reader, md5Base64)
@@ -623,26 +1577,45 @@ func (g *GoFakeS3) createObject(bucket, object string, w http.ResponseWriter, r
 		return err
 	}
 
-	result, err := g.storage.PutObject(bucket, object, meta, rdr, size)
+	continueUpload(r)
+	var body io.Reader = rdr
+	if size < 0 {
+		if body, size, err = g.readUnknownLength(r.Context(), rdr); err != nil {
+			return err
+		}
+	}
+
+	input, etag, err := g.objectETag(r.Context(), meta, body, size)
+	if err != nil {
+		return err
+	}
+
+	result, err := putObjectContext(r.Context(), g.storage, bucket, object, meta, input, size)
 	if err != nil {
 		return err
 	}
+	if err := g.storeObjectACL(bucket, object, result.VersionID, acl); err != nil {
+		return err
+	}
+	if etag == "" {
+		etag = hex.EncodeToString(rdr.Sum(nil))
+	}
+	g.emit(Event{Type: EventObjectCreatedPut, Bucket: bucket, Key: object, VersionID: result.VersionID, Size: size, ETag: etag})
 
 	if result.VersionID != "" {
 		g.log.Print(LogInfo, "CREATED VERSION:", bucket, object, result.VersionID)
 		w.Header().Set("x-amz-version-id", string(result.VersionID))
 	}
-	w.Header().Set("ETag", `"`+hex.EncodeToString(rdr.Sum(nil))+`"`)
+	w.Header().Set("ETag", `"`+etag+`"`)
+	if cs != nil {
+		w.Header().Set(cs.algorithm.header(), cs.Base64())
+	}
 
 	return nil
 }
 
 // CopyObject copies an existing S3 object
-func (g *GoFakeS3) copyObject(bucket, object string, meta map[string]string, w http.ResponseWriter, r *http.Request) (err error) {
-	if err := g.ensureBucketExists(bucket); err != nil {
-		return err
-	}
-
+func (g *GoFakeS3) copyObject(bucket, object string, meta map[string]string, acl *AccessControlPolicy, w http.ResponseWriter, r *http.Request) (err error) {
 	source := meta["X-Amz-Copy-Source"]
 	g.log.Print(LogInfo, "COPY:", source, "TO", bucket, object)
 
@@ -650,334 +1623,1496 @@ func (g *GoFakeS3) copyObject(bucket, object string, meta map[string]string, w h
 		return ResourceError(ErrKeyTooLong, object)
 	}
 
-	// XXX No support for versionId subresource
-	parts := strings.SplitN(strings.TrimPrefix(source, "/"), "/", 2)
-	srcBucket := parts[0]
-	srcKey := strings.SplitN(parts[1], "?", 2)[0]
+	srcBucket, srcKey, srcVersionID, err := parseCopySource(source)
+	if err != nil {
+		return err
+	}
 
-	srcKey, err = url.QueryUnescape(srcKey)
+	metadataDirective, err := copyDirective(r.Header, "x-amz-metadata-directive")
 	if err != nil {
 		return err
 	}
-	srcObj, err := g.storage.GetObject(srcBucket, srcKey, nil)
+	taggingDirective, err := copyDirective(r.Header, "x-amz-tagging-directive")
 	if err != nil {
 		return err
 	}
 
-	if srcObj == nil {
-		g.log.Print(LogErr, "unexpected nil object for key", bucket, object)
-		return ErrInternal
+	// S3 refuses to copy an object onto itself unless something about it is
+	// being replaced. Copying a noncurrent version onto the object is not a
+	// copy onto itself; it makes that version current again:
+	if srcBucket == bucket && srcKey == object &&
+		metadataDirective == copyDirectiveCopy && taggingDirective == copyDirectiveCopy &&
+		meta[StorageClassMetaKey] == "" && r.Header.Get(sseCustomerAlgorithmHeader) == "" {
+		current, err := g.isCurrentVersion(bucket, object, srcVersionID)
+		if err != nil {
+			return err
+		}
+		if current {
+			return ErrorMessage(ErrInvalidRequest, "This copy request is illegal because it is trying to copy an "+
+				"object to itself without changing the object's metadata, storage class, website redirect "+
+				"location or encryption attributes.")
+		}
+	}
+
+	var tags map[string]string
+	if g.tagging != nil && taggingDirective == copyDirectiveReplace {
+		tags, err = taggingHeader(r.Header.Get("x-amz-tagging"))
+		if err != nil {
+			return err
+		}
+	}
+
+	srcObj, err := g.getCopySource(r.Context(), srcBucket, srcKey, srcVersionID, nil, r.Header)
+	if err != nil {
+		return err
 	}
 	defer srcObj.Contents.Close()
 
-	// XXX No support for delete marker
-	// "If the current version of the object is a delete marker, Amazon S3
-	// behaves as if the object was deleted."
+	// With the COPY directive, the metadata is merged; with REPLACE, only the
+	// metadata supplied with the request is used. ACL is never preserved, and
+	// nor is the storage class: the copy is STANDARD unless the request asks
+	// for another.
+	//
+	// The source's SSE-C parameters are not carried over either; the copy is
+	// only encrypted if the request supplies a key for it.
+	if metadataDirective == copyDirectiveCopy {
+		for k, v := range srcObj.Metadata {
+			// The copy is not a multipart object, so its ETag is the hash
+			// of its contents:
+			if k == sseCustomerAlgorithmHeader || k == sseCustomerKeyMD5Header || k == ETagMetaKey || k == objectPartsMetaKey {
+				continue
+			}
+			if _, found := meta[k]; !found && k != "X-Amz-Acl" && k != StorageClassMetaKey {
+				meta[k] = v
+			}
+		}
+	}
+
+	if g.tagging != nil && taggingDirective == copyDirectiveCopy {
+		tags, err = g.tagging.GetObjectTagging(srcBucket, srcKey, srcObj.VersionID)
+		if err != nil {
+			return err
+		}
+	}
+
+	input, etag, err := g.objectETag(r.Context(), meta, srcObj.Contents, srcObj.Size)
+	if err != nil {
+		return err
+	}
+
+	result, err := putObjectContext(r.Context(), g.storage, bucket, object, meta, input, srcObj.Size)
+	if err != nil {
+		return err
+	}
+	if err := g.storeObjectACL(bucket, object, result.VersionID, acl); err != nil {
+		return err
+	}
 
-	// merge metadata, ACL is not preserved
-	for k, v := range srcObj.Metadata {
-		if _, found := meta[k]; !found && k != "X-Amz-Acl" {
-			meta[k] = v
+	if g.tagging != nil {
+		if err := g.tagging.PutObjectTagging(bucket, object, result.VersionID, tags); err != nil {
+			return err
 		}
 	}
 
-	result, err := g.storage.PutObject(bucket, object, meta, srcObj.Contents, srcObj.Size)
+	if etag == "" {
+		etag = hex.EncodeToString(srcObj.Hash)
+	}
+	g.emit(Event{Type: EventObjectCreatedCopy, Bucket: bucket, Key: object, VersionID: result.VersionID, Size: srcObj.Size, ETag: etag})
+
+	if srcObj.VersionID != "" {
+		w.Header().Set("x-amz-copy-source-version-id", string(srcObj.VersionID))
+	}
+	if result.VersionID != "" {
+		g.log.Print(LogInfo, "CREATED VERSION:", bucket, object, result.VersionID)
+		w.Header().Set("x-amz-version-id", string(result.VersionID))
+	}
+
+	// The copy's Last-Modified was set when the request's metadata was read,
+	// and is what HEAD will report; the result should agree with it:
+	lastModified, err := parseHeaderTime(meta["Last-Modified"])
+	if err != nil {
+		lastModified = g.timeSource.Now()
+	}
+
+	return g.xmlEncoder(w).Encode(CopyObjectResult{
+		ETag:         `"` + etag + `"`,
+		LastModified: NewContentTime(lastModified),
+	})
+}
+
+// isCurrentVersion reports whether versionID is the current version of the
+// object, which an empty versionID always is if the object exists. It returns
+// false if the object does not exist, which is left for the caller to report.
+func (g *GoFakeS3) isCurrentVersion(bucket, object string, versionID VersionID) (bool, error) {
+	obj, err := g.storage.HeadObject(bucket, object)
+	if HasErrorCode(err, ErrNoSuchKey) {
+		return false, nil
+	} else if err != nil {
+		return false, err
+	}
+	if versionID == "" {
+		return true, nil
+	} else if obj.VersionID == "" {
+		return versionID == "null", nil
+	}
+	return obj.VersionID == versionID, nil
+}
+
+// getCopySource retrieves the source object of CopyObject or UploadPartCopy,
+// and checks it against the SSE-C and x-amz-copy-source-if-* headers of the
+// request. The caller must close the object's Contents.
+func (g *GoFakeS3) getCopySource(ctx context.Context, bucket, key string, versionID VersionID, rnge *ObjectRangeRequest, headers http.Header) (obj *Object, err error) {
+	// Without a VersionedBackend, the only version of an object is the null
+	// version:
+	if versionID == "null" && g.versioned == nil {
+		versionID = ""
+	}
+
+	if versionID == "" {
+		obj, err = getObjectContext(ctx, g.storage, bucket, key, rnge)
+	} else {
+		if g.versioned == nil {
+			return nil, ErrNotImplemented
+		}
+		obj, err = g.versioned.GetObjectVersion(bucket, key, versionID, rnge)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if obj == nil {
+		g.log.Print(LogErr, "unexpected nil object for key", bucket, key)
+		return nil, ErrInternal
+	}
+
+	if err := checkCopySource(obj, versionID, headers); err != nil {
+		obj.Contents.Close()
+		return nil, err
+	}
+	if err := g.checkRestored(bucket, key, obj); err != nil {
+		obj.Contents.Close()
+		return nil, err
+	}
+	return obj, nil
+}
+
+func checkCopySource(obj *Object, versionID VersionID, headers http.Header) error {
+	if obj.IsDeleteMarker {
+		if versionID != "" {
+			return ErrorMessage(ErrInvalidRequest, "The source of a copy request may not specifically refer to a delete marker by version id.")
+		}
+		return KeyNotFound(obj.Name)
+	}
+
+	if err := checkSSECustomerKey(obj.Metadata, headers, copySourceHeaderPrefix); err != nil {
+		return err
+	}
+
+	// The x-amz-copy-source-if-* headers follow the same rules as their
+	// GET equivalents, except that S3 reports every failure as
+	// PreconditionFailed rather than NotModified:
+	conditions := make(http.Header)
+	for _, h := range []string{"If-Match", "If-None-Match", "If-Modified-Since", "If-Unmodified-Since"} {
+		if v := headers.Get(copySourceHeaderPrefix + h); v != "" {
+			conditions.Set(h, v)
+		}
+	}
+	err := checkConditionalHeaders(conditions, ObjectETag(obj.Hash, obj.Metadata), objectLastModified(obj))
+	if HasErrorCode(err, ErrNotModified) {
+		return ErrPreconditionFailed
+	}
+	return err
+}
+
+const (
+	copyDirectiveCopy    = "COPY"
+	copyDirectiveReplace = "REPLACE"
+)
+
+// copyDirective reads one of the x-amz-metadata-directive or
+// x-amz-tagging-directive headers, which default to COPY if not supplied.
+func copyDirective(headers http.Header, header string) (string, error) {
+	switch v := headers.Get(header); v {
+	case "", copyDirectiveCopy:
+		return copyDirectiveCopy, nil
+	case copyDirectiveReplace:
+		return copyDirectiveReplace, nil
+	default:
+		return "", ErrorInvalidArgument(header, v, "Unknown directive")
+	}
+}
+
+// taggingHeader parses the URL-encoded tag set passed in the x-amz-tagging
+// header, i.e. "Key1=Value1&Key2=Value2".
+func taggingHeader(value string) (map[string]string, error) {
+	query, err := url.ParseQuery(value)
+	if err != nil {
+		return nil, ErrorMessage(ErrInvalidArgument, "The header 'x-amz-tagging' shall be encoded as UTF-8 then URLEncoded URL query parameters without tag name duplicates.")
+	}
+
+	var tagging Tagging
+	for k, vs := range query {
+		for _, v := range vs {
+			tagging.TagSet = append(tagging.TagSet, Tag{Key: k, Value: v})
+		}
+	}
+	return tagging.Tags()
+}
+
+// parseCopySource splits the value of the x-amz-copy-source header into the
+// source bucket, the unescaped source key, and the version ID given by an
+// optional '?versionId=' suffix.
+func parseCopySource(source string) (bucket, key string, versionID VersionID, err error) {
+	invalid := ErrorInvalidArgument("x-amz-copy-source", source,
+		"Copy Source must mention the source bucket and key: sourcebucket/sourcekey")
+
+	// A '?' in the key must be escaped, so the first one starts the query:
+	path, query := source, ""
+	if idx := strings.IndexByte(source, '?'); idx >= 0 {
+		path, query = source[:idx], source[idx+1:]
+	}
+	if query != "" {
+		values, err := url.ParseQuery(query)
+		if err != nil {
+			return "", "", "", invalid
+		}
+		// Unlike versionFromQuery, this keeps the 'null' version, which
+		// getCopySource resolves:
+		versionID = VersionID(values.Get("versionId"))
+	}
+
+	path, err = url.QueryUnescape(path)
+	if err != nil {
+		return "", "", "", invalid
+	}
+
+	parts := strings.SplitN(strings.TrimPrefix(path, "/"), "/", 2)
+	if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+		return "", "", "", invalid
+	}
+	return parts[0], parts[1], versionID, nil
+}
+
+func (g *GoFakeS3) deleteObject(bucket, object string, w http.ResponseWriter, r *http.Request) error {
+	g.log.Print(LogInfo, "DELETE:", bucket, object)
+
+	if err := g.checkObjectLock(bucket, object, "", bypassGovernanceRetention(r)); err != nil {
+		return err
+	}
+
+	result, err := g.storage.DeleteObject(bucket, object)
+	if err != nil {
+		return err
+	}
+
+	if result.IsDeleteMarker {
+		g.emit(Event{Type: EventObjectRemovedDeleteMarkerCreated, Bucket: bucket, Key: object, VersionID: result.VersionID})
+	} else {
+		g.emit(Event{Type: EventObjectRemovedDelete, Bucket: bucket, Key: object, VersionID: result.VersionID})
+	}
+
+	writeDeleteHeaders(w, result)
+	w.WriteHeader(http.StatusNoContent)
+	return nil
+}
+
+// writeDeleteHeaders sets the headers describing the version removed or the
+// delete marker created by a delete. Unversioned buckets return no version
+// ID, so neither header is set for them.
+func writeDeleteHeaders(w http.ResponseWriter, result ObjectDeleteResult) {
+	if result.VersionID == "" && !result.IsDeleteMarker {
+		return
+	}
+	if result.VersionID != "" {
+		w.Header().Set("x-amz-version-id", string(result.VersionID))
+	}
+	if result.IsDeleteMarker {
+		w.Header().Set("x-amz-delete-marker", "true")
+	} else {
+		w.Header().Set("x-amz-delete-marker", "false")
+	}
+}
+
+func (g *GoFakeS3) deleteObjectVersion(bucket, object string, version VersionID, w http.ResponseWriter, r *http.Request) error {
+	if g.versioned == nil {
+		return ErrNotImplemented
+	}
+
+	g.log.Print(LogInfo, "DELETE VERSION:", bucket, object, version)
+	if err := g.checkMFADelete(bucket, r); err != nil {
+		return err
+	}
+
+	if err := g.checkObjectLock(bucket, object, version, bypassGovernanceRetention(r)); err != nil {
+		return err
+	}
+
+	result, err := g.versioned.DeleteObjectVersion(bucket, object, version)
+	if err != nil {
+		return err
+	}
+	g.log.Print(LogInfo, "DELETED VERSION:", bucket, object, version)
+	g.emit(Event{Type: EventObjectRemovedDelete, Bucket: bucket, Key: object, VersionID: version})
+
+	writeDeleteHeaders(w, result)
+	w.WriteHeader(http.StatusNoContent)
+	return nil
+}
+
+// deleteMulti deletes multiple S3 objects from the bucket.
+// https://docs.aws.amazon.com/AmazonS3/latest/API/multiobjectdeleteapi.html
+func (g *GoFakeS3) deleteMulti(bucket string, w http.ResponseWriter, r *http.Request) error {
+	g.log.Print(LogInfo, "delete multi", bucket)
+
+	var in DeleteRequest
+
+	defer r.Body.Close()
+	dc := xml.NewDecoder(r.Body)
+	if err := dc.Decode(&in); err != nil {
+		return ErrorMessage(ErrMalformedXML, err.Error())
+	}
+	if len(in.Objects) == 0 || len(in.Objects) > MaxDeleteObjects {
+		return ErrMalformedXML
+	}
+
+	// Each object is deleted independently of the others, so an object that
+	// can not be deleted is reported as an error in the result rather than
+	// failing the request. Versions that can not be deleted without MFA, or
+	// that are protected by an object lock, are reported here. The rest are
+	// passed through to the backend:
+	mfaDelete, err := g.mfaDeleteEnabled(bucket)
+	if err != nil {
+		return err
+	}
+	var mfaErr error
+	if mfaDelete {
+		mfaErr = g.checkMFA(r)
+	}
+
+	var locked []ErrorResult
+	var unlocked = in.Objects[:0]
+	var bypass = bypassGovernanceRetention(r)
+	for _, o := range in.Objects {
+		if o.VersionID != "" && mfaErr != nil {
+			locked = append(locked, g.deleteErrorResult(bucket, o, mfaErr))
+		} else if err := g.checkObjectLock(bucket, o.Key, VersionID(o.VersionID), bypass); err != nil {
+			locked = append(locked, g.deleteErrorResult(bucket, o, err))
+		} else {
+			unlocked = append(unlocked, o)
+		}
+	}
+	in.Objects = unlocked
+
+	var out MultiDeleteResult
+	if g.versioned == nil {
+		out = g.deleteMultiUnversioned(bucket, in.Objects)
+	} else {
+		out = g.deleteEachObject(bucket, in.Objects)
+	}
+
+	for _, o := range out.Deleted {
+		if o.DeleteMarker && o.VersionID == "" {
+			g.emit(Event{Type: EventObjectRemovedDeleteMarkerCreated, Bucket: bucket, Key: o.Key, VersionID: VersionID(o.DeleteMarkerVersionID)})
+		} else {
+			g.emit(Event{Type: EventObjectRemovedDelete, Bucket: bucket, Key: o.Key, VersionID: VersionID(o.VersionID)})
+		}
+	}
+
+	out.Error = append(out.Error, locked...)
+
+	if in.Quiet {
+		out.Deleted = nil
+	}
+
+	return g.xmlEncoder(w).Encode(out)
+}
+
+// deleteMultiUnversioned passes the objects to Backend.DeleteMulti. Objects
+// with a version ID are reported as errors, as the Backend does not support
+// versions.
+func (g *GoFakeS3) deleteMultiUnversioned(bucket string, objects []ObjectID) (out MultiDeleteResult) {
+	var versioned []ErrorResult
+	var unversioned []ObjectID
+	for _, o := range objects {
+		if o.VersionID != "" {
+			versioned = append(versioned, g.deleteErrorResult(bucket, o, ErrNotImplemented))
+		} else {
+			unversioned = append(unversioned, o)
+		}
+	}
+
+	if len(unversioned) > 0 {
+		keys := make([]string, len(unversioned))
+		for i, o := range unversioned {
+			keys[i] = o.Key
+		}
+
+		result, err := g.storage.DeleteMulti(bucket, keys...)
+		if err != nil {
+			// The Backend failed the whole batch without saying which object
+			// was at fault, so each one is tried on its own instead:
+			g.log.Print(LogErr, "delete multi failed:", bucket, err)
+			out = g.deleteEachObject(bucket, unversioned)
+
+		} else {
+			out.Deleted = result.Deleted
+			for _, errResult := range result.Error {
+				if errResult.Code == ErrNoSuchKey {
+					out.Deleted = append(out.Deleted, ObjectID{Key: errResult.Key})
+				} else {
+					out.Error = append(out.Error, errResult)
+				}
+			}
+		}
+	}
+
+	out.Error = append(out.Error, versioned...)
+	return out
+}
+
+// deleteEachObject deletes each object in turn, so that the result can
+// report the delete markers that were created or deleted. Objects with a
+// version ID are deleted with DeleteObjectVersion, the rest with DeleteObject,
+// which creates a delete marker if the bucket is versioned.
+//
+// Like S3, an object or version that does not exist is reported as deleted.
+func (g *GoFakeS3) deleteEachObject(bucket string, objects []ObjectID) (out MultiDeleteResult) {
+	for _, o := range objects {
+		var result ObjectDeleteResult
+		var err error
+		if o.VersionID != "" {
+			result, err = g.versioned.DeleteObjectVersion(bucket, o.Key, VersionID(o.VersionID))
+		} else {
+			result, err = g.storage.DeleteObject(bucket, o.Key)
+		}
+
+		if HasErrorCode(err, ErrNoSuchKey) || HasErrorCode(err, ErrNoSuchVersion) {
+			result, err = ObjectDeleteResult{}, nil
+		} else if err != nil {
+			out.Error = append(out.Error, g.deleteErrorResult(bucket, o, err))
+			continue
+		}
+
+		deleted := ObjectID{Key: o.Key, VersionID: o.VersionID}
+		if result.IsDeleteMarker {
+			deleted.DeleteMarker = true
+			deleted.DeleteMarkerVersionID = string(result.VersionID)
+		}
+		out.Deleted = append(out.Deleted, deleted)
+	}
+	return out
+}
+
+// deleteErrorResult reports why an object in a multi-delete request could not
+// be deleted.
+func (g *GoFakeS3) deleteErrorResult(bucket string, o ObjectID, err error) ErrorResult {
+	result := ErrorResultFromError(err)
+	if result.Code == ErrInternal {
+		g.log.Print(LogErr, "delete multi failed:", bucket, o.Key, o.VersionID, err)
+	}
+	result.Key, result.VersionID = o.Key, o.VersionID
+	return result
+}
+
+func (g *GoFakeS3) getObjectTagging(bucket, object string, versionID VersionID, w http.ResponseWriter, r *http.Request) error {
+	g.log.Print(LogInfo, "GET OBJECT TAGGING:", bucket, object, versionID)
+
+	if g.tagging == nil {
+		return ErrNotImplemented
+	}
+
+	tags, err := g.tagging.GetObjectTagging(bucket, object, versionID)
+	if err != nil {
+		return err
+	}
+	if versionID != "" {
+		w.Header().Set("x-amz-version-id", string(versionID))
+	}
+
+	return g.xmlEncoder(w).Encode(NewTagging(tags))
+}
+
+func (g *GoFakeS3) putObjectTagging(bucket, object string, versionID VersionID, w http.ResponseWriter, r *http.Request) error {
+	g.log.Print(LogInfo, "PUT OBJECT TAGGING:", bucket, object, versionID)
+
+	if g.tagging == nil {
+		return ErrNotImplemented
+	}
+
+	var in Tagging
+	if err := g.xmlDecodeBody(r.Body, &in); err != nil {
+		return err
+	}
+
+	tags, err := in.Tags()
+	if err != nil {
+		return err
+	}
+
+	if err := g.tagging.PutObjectTagging(bucket, object, versionID, tags); err != nil {
+		return err
+	}
+	if versionID != "" {
+		w.Header().Set("x-amz-version-id", string(versionID))
+	}
+	return nil
+}
+
+func (g *GoFakeS3) deleteObjectTagging(bucket, object string, versionID VersionID, w http.ResponseWriter, r *http.Request) error {
+	g.log.Print(LogInfo, "DELETE OBJECT TAGGING:", bucket, object, versionID)
+
+	if g.tagging == nil {
+		return ErrNotImplemented
+	}
+
+	if err := g.tagging.DeleteObjectTagging(bucket, object, versionID); err != nil {
+		return err
+	}
+	if versionID != "" {
+		w.Header().Set("x-amz-version-id", string(versionID))
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+	return nil
+}
+
+func (g *GoFakeS3) getBucketACL(bucket string, w http.ResponseWriter, r *http.Request) error {
+	g.log.Print(LogInfo, "GET BUCKET ACL:", bucket)
+
+	if g.acl == nil {
+		return ErrNotImplemented
+	}
+
+	acl, err := g.acl.BucketACL(bucket)
+	if err != nil {
+		return err
+	}
+	if acl, err = g.effectiveACL(bucket, acl); err != nil {
+		return err
+	}
+	return g.writeACL(w, acl)
+}
+
+func (g *GoFakeS3) putBucketACL(bucket string, w http.ResponseWriter, r *http.Request) error {
+	g.log.Print(LogInfo, "PUT BUCKET ACL:", bucket)
+
+	if g.acl == nil {
+		return ErrNotImplemented
+	}
+
+	acl, err := g.aclFromRequest(r)
+	if err != nil {
+		return err
+	}
+	if err := g.checkRequestedACL(bucket, acl); err != nil {
+		return err
+	}
+	return g.acl.SetBucketACL(bucket, *acl)
+}
+
+func (g *GoFakeS3) getObjectACL(bucket, object string, versionID VersionID, w http.ResponseWriter, r *http.Request) error {
+	g.log.Print(LogInfo, "GET OBJECT ACL:", bucket, object, versionID)
+
+	if g.acl == nil {
+		return ErrNotImplemented
+	}
+
+	acl, err := g.acl.ObjectACL(bucket, object, versionID)
+	if err != nil {
+		return err
+	}
+	if acl, err = g.effectiveACL(bucket, acl); err != nil {
+		return err
+	}
+	if versionID != "" {
+		w.Header().Set("x-amz-version-id", string(versionID))
+	}
+	return g.writeACL(w, acl)
+}
+
+func (g *GoFakeS3) putObjectACL(bucket, object string, versionID VersionID, w http.ResponseWriter, r *http.Request) error {
+	g.log.Print(LogInfo, "PUT OBJECT ACL:", bucket, object, versionID)
+
+	if g.acl == nil {
+		return ErrNotImplemented
+	}
+
+	acl, err := g.aclFromRequest(r)
+	if err != nil {
+		return err
+	}
+	if err := g.checkRequestedACL(bucket, acl); err != nil {
+		return err
+	}
+	if err := g.acl.SetObjectACL(bucket, object, versionID, *acl); err != nil {
+		return err
+	}
+	if versionID != "" {
+		w.Header().Set("x-amz-version-id", string(versionID))
+	}
+	return nil
+}
+
+func (g *GoFakeS3) getBucketTagging(bucket string, w http.ResponseWriter, r *http.Request) error {
+	g.log.Print(LogInfo, "GET BUCKET TAGGING:", bucket)
+
+	if g.bucketTags == nil {
+		return ErrNotImplemented
+	}
+
+	tags, err := g.bucketTags.BucketTagging(bucket)
+	if err != nil {
+		return err
+	}
+	if len(tags) == 0 {
+		return ResourceError(ErrNoSuchTagSet, bucket)
+	}
+
+	return g.xmlEncoder(w).Encode(NewTagging(tags))
+}
+
+func (g *GoFakeS3) putBucketTagging(bucket string, w http.ResponseWriter, r *http.Request) error {
+	g.log.Print(LogInfo, "PUT BUCKET TAGGING:", bucket)
+
+	if g.bucketTags == nil {
+		return ErrNotImplemented
+	}
+
+	var in Tagging
+	if err := g.xmlDecodeBody(r.Body, &in); err != nil {
+		return err
+	}
+
+	tags, err := in.BucketTags()
+	if err != nil {
+		return err
+	}
+
+	if err := g.bucketTags.SetBucketTagging(bucket, tags); err != nil {
+		return err
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+	return nil
+}
+
+func (g *GoFakeS3) deleteBucketTagging(bucket string, w http.ResponseWriter, r *http.Request) error {
+	g.log.Print(LogInfo, "DELETE BUCKET TAGGING:", bucket)
+
+	if g.bucketTags == nil {
+		return ErrNotImplemented
+	}
+
+	if err := g.bucketTags.DeleteBucketTagging(bucket); err != nil {
+		return err
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+	return nil
+}
+
+// checkObjectLock returns ErrAccessDenied if deleting the object version would
+// violate its legal hold or retention. Deletes that only create a delete
+// marker in a versioned bucket are always allowed, as they do not remove any
+// data.
+//
+// A GOVERNANCE mode retention is ignored if bypass is true, which is set by
+// sending the 'x-amz-bypass-governance-retention: true' header.
+func (g *GoFakeS3) checkObjectLock(bucket, object string, versionID VersionID, bypass bool) error {
+	if g.objectLock == nil {
+		return nil
+	}
+
+	if versionID == "" && g.versioned != nil {
+		config, err := g.versioned.VersioningConfiguration(bucket)
+		if err != nil {
+			return err
+		}
+		if config.Enabled() {
+			return nil
+		}
+	}
+
+	hold, err := g.objectLock.GetObjectLegalHold(bucket, object, versionID)
+	if HasErrorCode(err, ErrNoSuchKey) || HasErrorCode(err, ErrNoSuchVersion) {
+		return nil // Let the delete decide what to do with missing objects
+	} else if err != nil {
+		return err
+	}
+	if hold == LegalHoldOn {
+		return ErrorMessage(ErrAccessDenied, "Object is under a legal hold and cannot be deleted")
+	}
+
+	retention, err := g.objectLock.GetObjectRetention(bucket, object, versionID)
+	if err != nil {
+		return err
+	}
+	if !retention.Active(g.timeSource.Now()) {
+		return nil
+	}
+	if retention.Mode == ObjectLockGovernance && bypass {
+		return nil
+	}
+	return ErrorMessagef(ErrAccessDenied, "Object is WORM protected and cannot be deleted until %s",
+		retention.RetainUntilDate.UTC().Format(time.RFC3339))
+}
+
+func bypassGovernanceRetention(r *http.Request) bool {
+	bypass, _ := strconv.ParseBool(r.Header.Get("x-amz-bypass-governance-retention"))
+	return bypass
+}
+
+func (g *GoFakeS3) getObjectRetention(bucket, object string, versionID VersionID, w http.ResponseWriter, r *http.Request) error {
+	g.log.Print(LogInfo, "GET OBJECT RETENTION:", bucket, object, versionID)
+
+	if g.objectLock == nil {
+		return ErrNotImplemented
+	}
+
+	retention, err := g.objectLock.GetObjectRetention(bucket, object, versionID)
+	if err != nil {
+		return err
+	}
+	if retention == nil || retention.Mode == "" {
+		return ErrorMessage(ErrNoSuchObjectLockConfiguration, "The specified object does not have a ObjectLock configuration")
+	}
+
+	out := *retention
+	out.Xmlns = "http://s3.amazonaws.com/doc/2006-03-01/"
+	return g.xmlEncoder(w).Encode(out)
+}
+
+func (g *GoFakeS3) putObjectRetention(bucket, object string, versionID VersionID, w http.ResponseWriter, r *http.Request) error {
+	g.log.Print(LogInfo, "PUT OBJECT RETENTION:", bucket, object, versionID)
+
+	if g.objectLock == nil {
+		return ErrNotImplemented
+	}
+
+	var in ObjectRetention
+	if err := g.xmlDecodeBody(r.Body, &in); err != nil {
+		return err
+	}
+	if in.Mode != ObjectLockGovernance && in.Mode != ObjectLockCompliance {
+		return ErrMalformedXML
+	}
+
+	now := g.timeSource.Now()
+	if !in.RetainUntilDate.After(now) {
+		return ErrorMessage(ErrInvalidArgument, "The retain until date must be in the future!")
+	}
+
+	existing, err := g.objectLock.GetObjectRetention(bucket, object, versionID)
+	if err != nil {
+		return err
+	}
+
+	// An active retention may only be extended, unless it is in GOVERNANCE
+	// mode and the request bypasses it:
+	if existing.Active(now) {
+		weakened := in.RetainUntilDate.Before(existing.RetainUntilDate.Time) || in.Mode != existing.Mode
+		if weakened && (existing.Mode == ObjectLockCompliance || !bypassGovernanceRetention(r)) {
+			return ErrorMessage(ErrAccessDenied, "Access Denied because object protected by object lock.")
+		}
+	}
+
+	return g.objectLock.PutObjectRetention(bucket, object, versionID, ObjectRetention{
+		Mode:            in.Mode,
+		RetainUntilDate: in.RetainUntilDate,
+	})
+}
+
+func (g *GoFakeS3) getObjectLegalHold(bucket, object string, versionID VersionID, w http.ResponseWriter, r *http.Request) error {
+	g.log.Print(LogInfo, "GET OBJECT LEGAL HOLD:", bucket, object, versionID)
+
+	if g.objectLock == nil {
+		return ErrNotImplemented
+	}
+
+	status, err := g.objectLock.GetObjectLegalHold(bucket, object, versionID)
+	if err != nil {
+		return err
+	}
+	if status == "" {
+		return ErrorMessage(ErrNoSuchObjectLockConfiguration, "The specified object does not have a ObjectLock configuration")
+	}
+
+	return g.xmlEncoder(w).Encode(ObjectLegalHold{
+		Xmlns:  "http://s3.amazonaws.com/doc/2006-03-01/",
+		Status: status,
+	})
+}
+
+func (g *GoFakeS3) putObjectLegalHold(bucket, object string, versionID VersionID, w http.ResponseWriter, r *http.Request) error {
+	g.log.Print(LogInfo, "PUT OBJECT LEGAL HOLD:", bucket, object, versionID)
+
+	if g.objectLock == nil {
+		return ErrNotImplemented
+	}
+
+	var in ObjectLegalHold
+	if err := g.xmlDecodeBody(r.Body, &in); err != nil {
+		return err
+	}
+	if in.Status != LegalHoldOn && in.Status != LegalHoldOff {
+		return ErrMalformedXML
+	}
+
+	return g.objectLock.PutObjectLegalHold(bucket, object, versionID, in.Status)
+}
+
+func (g *GoFakeS3) initiateMultipartUpload(bucket, object string, w http.ResponseWriter, r *http.Request) error {
+	g.log.Print(LogInfo, "initiate multipart upload", bucket, object)
+
+	meta, err := metadataHeaders(r.Header, g.timeSource.Now(), g.metadataSizeLimit)
+	if err != nil {
+		return err
+	}
+	if _, err := sseCustomerKeyFromHeaders(r.Header, ""); err != nil {
+		return err
+	}
+	// The ACL headers are kept in the metadata until the upload is completed:
+	acl, err := g.aclFromHeaders(r.Header)
+	if err != nil {
+		return err
+	}
+	if err := g.checkRequestedACL(bucket, acl); err != nil {
+		return err
+	}
+	if err := g.validateObjectKey(object); err != nil {
+		return err
+	}
+
+	var alg checksumAlgorithm
+	if v := r.Header.Get(checksumAlgorithmHeader); v != "" {
+		if alg, err = parseChecksumAlgorithm(v); err != nil {
+			return err
+		}
+		w.Header().Set(checksumAlgorithmHeader, string(alg))
+	}
+	delete(meta, checksumAlgorithmHeader)
+
+	upload := g.uploader.Begin(bucket, object, meta, g.timeSource.Now(), alg)
+	out := InitiateMultipartUpload{
+		UploadID: upload.ID,
+		Bucket:   bucket,
+		Key:      object,
+	}
+	return g.xmlEncoder(w).Encode(out)
+}
+
+// From the docs:
+//
+//	A part number uniquely identifies a part and also defines its position
+//	within the object being created. If you upload a new part using the same
+//	part number that was used with a previous part, the previously uploaded part
+//	is overwritten. Each part must be at least 5 MB in size, except the last
+//	part. There is no size limit on the last part of your multipart upload.
+func (g *GoFakeS3) putMultipartUploadPart(bucket, object string, uploadID UploadID, w http.ResponseWriter, r *http.Request) error {
+	g.log.Print(LogInfo, "put multipart upload", bucket, object, uploadID)
+
+	partNumber, err := strconv.ParseInt(r.URL.Query().Get("partNumber"), 10, 0)
+	if err != nil || partNumber <= 0 || partNumber > MaxUploadPartNumber {
+		return ErrInvalidPart
+	}
+
+	if source := r.Header.Get("x-amz-copy-source"); source != "" {
+		return g.copyMultipartUploadPart(bucket, object, uploadID, int(partNumber), source, w, r)
+	}
+
+	size, err := strconv.ParseInt(r.Header.Get("Content-Length"), 10, 64)
+	if err != nil || size <= 0 {
+		return ErrMissingContentLength
+	}
+
+	upload, err := g.uploader.Get(bucket, object, uploadID)
+	if err != nil {
+		// FIXME: What happens with S3 when you abort a multipart upload while
+		// part uploads are still in progress? In this case, we will retain the
+		// reference to the part even though another request goroutine may
+		// delete it; it will be available for GC when this function finishes.
+		return err
+	}
+
+	defer r.Body.Close()
+	var rdr io.Reader = r.Body
+
+	if isStreamingUpload(r.Header) {
+		if size, err = decodedContentLength(r.Header); err != nil {
+			return err
+		}
+		rdr = newChunkedReader(r.Body)
+	}
+
+	maxSize := g.maxPartSize()
+	if size > maxSize {
+		return ErrorEntityTooLarge(size, maxSize)
+	}
+	rdr = &maxSizeReader{r: rdr, max: maxSize}
+
+	if g.integrityCheck {
+		md5Base64 := r.Header.Get("Content-MD5")
+		if _, ok := r.Header[textproto.CanonicalMIMEHeaderKey("Content-MD5")]; ok && md5Base64 == "" {
+			return ErrInvalidDigest // Satisfies s3tests
+		}
+
+		if md5Base64 != "" {
+			var err error
+			rdr, err = newHashingReader(rdr, md5Base64)
+			if err != nil {
+				return err
+			}
+		}
+
+		cs, err := checksumFromHeaders(r.Header)
+		if err != nil {
+			return err
+		}
+		if cs != nil {
+			if upload.ChecksumAlgorithm != "" && cs.algorithm != upload.ChecksumAlgorithm {
+				return ErrorMessagef(ErrInvalidRequest,
+					"Checksum Type mismatch occurred, expected checksum Type: %s, actual checksum Type: %s",
+					strings.ToLower(string(upload.ChecksumAlgorithm)), strings.ToLower(string(cs.algorithm)))
+			}
+			rdr = newChecksumReader(rdr, cs)
+		}
+	}
+
+	continueUpload(r)
+	part, err := g.putPart(upload, int(partNumber), g.timeSource.Now(), rdr, size)
+	if err != nil {
+		return err
+	}
+
+	w.Header().Add("ETag", part.ETag)
+	if part.Checksum != nil {
+		w.Header().Set(upload.ChecksumAlgorithm.header(), base64.StdEncoding.EncodeToString(part.Checksum))
+	}
+	return nil
+}
+
+// copyMultipartUploadPart implements UploadPartCopy, which populates a part
+// using some or all of an existing object instead of the request body. The
+// optional x-amz-copy-source-range header selects the bytes to copy, which
+// are streamed from the Backend to the part.
+//
+// https://docs.aws.amazon.com/AmazonS3/latest/API/API_UploadPartCopy.html
+func (g *GoFakeS3) copyMultipartUploadPart(
+	bucket, object string,
+	uploadID UploadID,
+	partNumber int,
+	source string,
+	w http.ResponseWriter,
+	r *http.Request,
+) error {
+	g.log.Print(LogInfo, "copy multipart upload part", bucket, object, uploadID, "FROM", source)
+
+	upload, err := g.uploader.Get(bucket, object, uploadID)
+	if err != nil {
+		return err
+	}
+
+	srcBucket, srcKey, srcVersionID, err := parseCopySource(source)
+	if err != nil {
+		return err
+	}
+
+	rnge, err := parseCopySourceRange(r.Header.Get("x-amz-copy-source-range"))
+	if err != nil {
+		return err
+	}
+
+	srcObj, err := g.getCopySource(r.Context(), srcBucket, srcKey, srcVersionID, rnge, r.Header)
+	if err != nil {
+		return err
+	}
+	defer srcObj.Contents.Close()
+
+	if srcObj.VersionID != "" {
+		w.Header().Set("x-amz-copy-source-version-id", string(srcObj.VersionID))
+	}
+
+	window, err := rnge.copySourceRange(srcObj.Size)
+	if err != nil {
+		return err
+	}
+
+	size := srcObj.Size
+	contents := io.Reader(srcObj.Contents)
+	if window != nil {
+		size = window.Length
+
+		// A Backend that ignored the range has returned the whole object,
+		// of which only the window is copied:
+		if srcObj.Range == nil {
+			if _, err := io.CopyN(ioutil.Discard, contents, window.Start); err != nil {
+				return err
+			}
+		}
+		contents = io.LimitReader(contents, window.Length)
+	}
+	if maxSize := g.maxPartSize(); size > maxSize {
+		return ErrorEntityTooLarge(size, maxSize)
+	}
+
+	at := g.timeSource.Now()
+	part, err := g.putPart(upload, partNumber, at, contents, size)
+	if err != nil {
+		return err
+	}
+
+	return g.xmlEncoder(w).Encode(CopyPartResult{
+		ETag:         part.ETag,
+		LastModified: NewContentTime(at),
+	})
+}
+
+func (g *GoFakeS3) abortMultipartUpload(bucket, object string, uploadID UploadID, w http.ResponseWriter, r *http.Request) error {
+	g.log.Print(LogInfo, "abort multipart upload", bucket, object, uploadID)
+	upload, err := g.uploader.Complete(bucket, object, uploadID)
+	if err != nil {
+		return err
+	}
+	if err := g.discardUpload(upload); err != nil {
+		return err
+	}
+	w.WriteHeader(http.StatusNoContent)
+	return nil
+}
+
+func (g *GoFakeS3) completeMultipartUpload(bucket, object string, uploadID UploadID, w http.ResponseWriter, r *http.Request) error {
+	g.log.Print(LogInfo, "complete multipart upload", bucket, object, uploadID)
+
+	var in CompleteMultipartUploadRequest
+	if err := g.xmlDecodeBody(r.Body, &in); err != nil {
+		return err
+	}
+
+	upload, err := g.uploader.Get(bucket, object, uploadID)
+	if err != nil {
+		return err
+	}
+
+	// As in S3, the upload is only removed once the object has been stored,
+	// so that an invalid request can be corrected and retried, and a failed
+	// one retried or aborted. Parts that were uploaded but not listed are
+	// discarded with it:
+	assembled, err := upload.Reassemble(&in, g.etagFunc(), g.minPartSize)
+	if err != nil {
+		return err
+	}
+	if err := g.checkUploadSize(assembled.Size); err != nil {
+		return err
+	}
+	acl, err := g.aclFromMetadata(upload.Meta)
+	if err != nil {
+		return err
+	}
+	if err := g.checkRequestedACL(bucket, acl); err != nil {
+		return err
+	}
+	etag, checksum := assembled.ETag, assembled.Checksum
+	encodedParts, err := encodeObjectParts(assembled.Parts)
+	if err != nil {
+		return err
+	}
+
+	// The ETag and checksum of the object are not those of its contents, so
+	// they are stored in the metadata, which is returned in the headers of GET
+	// and HEAD requests. The parts are stored there too, for GetObjectAttributes
+	// and for GET and HEAD requests with a partNumber:
+	meta := make(map[string]string, len(upload.Meta)+3)
+	for k, v := range upload.Meta {
+		meta[k] = v
+	}
+	meta[ETagMetaKey] = `"` + etag + `"`
+	meta[objectPartsMetaKey] = encodedParts
+	if checksum != "" {
+		meta[upload.ChecksumAlgorithm.header()] = checksum
+	}
+
+	var result PutObjectResult
+	if g.multipart != nil {
+		partNumbers := make([]int, 0, len(assembled.Parts))
+		for _, part := range assembled.Parts {
+			partNumbers = append(partNumbers, part.PartNumber)
+		}
+		result, err = g.multipart.CompleteMultipart(bucket, object, uploadID, partNumbers, meta, assembled.Size)
+	} else {
+		result, err = putObjectContext(r.Context(), g.storage, bucket, object, meta, assembled.Body, assembled.Size)
+	}
+	if err != nil {
+		return err
+	}
+	if _, err := g.uploader.Complete(bucket, object, uploadID); err != nil {
+		return err
+	}
+	if err := g.storeObjectACL(bucket, object, result.VersionID, acl); err != nil {
+		return err
+	}
+	g.emit(Event{Type: EventObjectCreatedCompleteMultipartUpload, Bucket: bucket, Key: object, VersionID: result.VersionID, Size: assembled.Size, ETag: etag})
+
+	if result.VersionID != "" {
+		w.Header().Set("x-amz-version-id", string(result.VersionID))
+	}
+
+	out := &CompleteMultipartUploadResult{
+		Location: g.objectURL(r, bucket, object),
+		ETag:     `"` + etag + `"`,
+		Bucket:   bucket,
+		Key:      object,
+	}
+	out.set(upload.ChecksumAlgorithm, checksum)
+	return g.xmlEncoder(w).Encode(out)
+}
+
+func (g *GoFakeS3) listMultipartUploads(bucket string, w http.ResponseWriter, r *http.Request) error {
+	query := r.URL.Query()
+	prefix := prefixFromQuery(query)
+	marker := uploadListMarkerFromQuery(query)
+	urlEncoded, err := urlEncodingFromQuery(query)
+	if err != nil {
+		return err
+	}
+
+	maxUploads, err := parseClampedInt(query.Get("max-uploads"), DefaultMaxUploads, 0, MaxUploadsLimit)
+	if err != nil {
+		return ErrInvalidURI
+	}
+	if maxUploads == 0 {
+		maxUploads = DefaultMaxUploads
+	}
+
+	out, err := g.uploader.List(bucket, marker, prefix, maxUploads)
+	if err != nil {
+		return err
+	}
+	for i := range out.Uploads {
+		out.Uploads[i].Initiator = g.owner
+		out.Uploads[i].Owner = g.owner
+	}
+	if urlEncoded {
+		out.urlEncode()
+	}
+
+	return g.xmlEncoder(w).Encode(out)
+}
+
+func (g *GoFakeS3) listMultipartUploadParts(bucket, object string, uploadID UploadID, w http.ResponseWriter, r *http.Request) error {
+	query := r.URL.Query()
+
+	// There are no parts after the last part number, so larger markers are
+	// clamped to that rather than allowed to overflow when the parts after the
+	// marker are looked up. A page of 0 parts would always be truncated, so
+	// clients following it would never get any further:
+	marker, err := parseClampedInt(query.Get("part-number-marker"), 0, 0, MaxUploadPartNumber)
 	if err != nil {
-		return err
+		return ErrInvalidURI
 	}
 
-	if srcObj.VersionID != "" {
-		w.Header().Set("x-amz-copy-source-version-id", string(srcObj.VersionID))
+	maxParts, err := parseClampedInt(query.Get("max-parts"), DefaultMaxUploadParts, 1, MaxUploadPartsLimit)
+	if err != nil {
+		return ErrInvalidURI
 	}
-	if result.VersionID != "" {
-		g.log.Print(LogInfo, "CREATED VERSION:", bucket, object, result.VersionID)
-		w.Header().Set("x-amz-version-id", string(result.VersionID))
+
+	out, err := g.uploader.ListParts(bucket, object, uploadID, int(marker), maxParts)
+	if err != nil {
+		return err
 	}
+	out.Initiator = g.owner
+	out.Owner = g.owner
 
-	return g.xmlEncoder(w).Encode(CopyObjectResult{
-		ETag:         `"` + hex.EncodeToString(srcObj.Hash) + `"`,
-		LastModified: NewContentTime(g.timeSource.Now()),
-	})
+	return g.xmlEncoder(w).Encode(out)
 }
 
-func (g *GoFakeS3) deleteObject(bucket, object string, w http.ResponseWriter, r *http.Request) error {
-	g.log.Print(LogInfo, "DELETE:", bucket, object)
-	if err := g.ensureBucketExists(bucket); err != nil {
-		return err
+func (g *GoFakeS3) getBucketWebsite(bucket string, w http.ResponseWriter, r *http.Request) error {
+	g.log.Print(LogInfo, "GET BUCKET WEBSITE:", bucket)
+
+	if g.website == nil {
+		return ErrNotImplemented
 	}
 
-	result, err := g.storage.DeleteObject(bucket, object)
+	config, err := g.website.BucketWebsite(bucket)
 	if err != nil {
 		return err
 	}
-
-	if result.IsDeleteMarker {
-		w.Header().Set("x-amz-delete-marker", "true")
-	} else {
-		w.Header().Set("x-amz-delete-marker", "false")
-	}
-
-	if result.VersionID != "" {
-		w.Header().Set("x-amz-version-id", string(result.VersionID))
+	if config == nil {
+		return ResourceError(ErrNoSuchWebsiteConfiguration, bucket)
 	}
 
-	w.WriteHeader(http.StatusNoContent)
-	return nil
+	out := *config
+	out.Xmlns = "http://s3.amazonaws.com/doc/2006-03-01/"
+	return g.xmlEncoder(w).Encode(out)
 }
 
-func (g *GoFakeS3) deleteObjectVersion(bucket, object string, version VersionID, w http.ResponseWriter, r *http.Request) error {
-	if g.versioned == nil {
+func (g *GoFakeS3) putBucketWebsite(bucket string, w http.ResponseWriter, r *http.Request) error {
+	g.log.Print(LogInfo, "PUT BUCKET WEBSITE:", bucket)
+
+	if g.website == nil {
 		return ErrNotImplemented
 	}
 
-	g.log.Print(LogInfo, "DELETE VERSION:", bucket, object, version)
-	if err := g.ensureBucketExists(bucket); err != nil {
+	var in WebsiteConfiguration
+	if err := g.xmlDecodeBody(r.Body, &in); err != nil {
 		return err
 	}
-
-	result, err := g.versioned.DeleteObjectVersion(bucket, object, version)
-	if err != nil {
+	if err := validateWebsiteConfiguration(&in); err != nil {
 		return err
 	}
-	g.log.Print(LogInfo, "DELETED VERSION:", bucket, object, version)
+	in.Xmlns = ""
 
-	if result.IsDeleteMarker {
-		w.Header().Set("x-amz-delete-marker", "true")
-	} else {
-		w.Header().Set("x-amz-delete-marker", "false")
+	return g.website.SetBucketWebsite(bucket, in)
+}
+
+func (g *GoFakeS3) deleteBucketWebsite(bucket string, w http.ResponseWriter, r *http.Request) error {
+	g.log.Print(LogInfo, "DELETE BUCKET WEBSITE:", bucket)
+
+	if g.website == nil {
+		return ErrNotImplemented
 	}
 
-	if result.VersionID != "" {
-		w.Header().Set("x-amz-version-id", string(result.VersionID))
+	if err := g.website.DeleteBucketWebsite(bucket); err != nil {
+		return err
 	}
 
 	w.WriteHeader(http.StatusNoContent)
 	return nil
 }
 
-// deleteMulti deletes multiple S3 objects from the bucket.
-// https://docs.aws.amazon.com/AmazonS3/latest/API/multiobjectdeleteapi.html
-func (g *GoFakeS3) deleteMulti(bucket string, w http.ResponseWriter, r *http.Request) error {
-	g.log.Print(LogInfo, "delete multi", bucket)
+func (g *GoFakeS3) getBucketRequestPayment(bucket string, w http.ResponseWriter, r *http.Request) error {
+	g.log.Print(LogInfo, "GET BUCKET REQUEST PAYMENT:", bucket)
 
-	if err := g.ensureBucketExists(bucket); err != nil {
-		return err
+	if g.payment == nil {
+		return ErrNotImplemented
 	}
 
-	var in DeleteRequest
-
-	defer r.Body.Close()
-	dc := xml.NewDecoder(r.Body)
-	if err := dc.Decode(&in); err != nil {
-		return ErrorMessage(ErrMalformedXML, err.Error())
+	payer, err := g.payment.BucketRequestPayment(bucket)
+	if err != nil {
+		return err
 	}
 
-	var err error
-	var out MultiDeleteResult
-	if g.versioned == nil {
-		keys := make([]string, len(in.Objects))
-		for i, o := range in.Objects {
-			keys[i] = o.Key
-		}
+	return g.xmlEncoder(w).Encode(RequestPaymentConfiguration{
+		Xmlns: "http://s3.amazonaws.com/doc/2006-03-01/",
+		Payer: payer,
+	})
+}
 
-		out, err = g.storage.DeleteMulti(bucket, keys...)
-	} else {
-		out, err = g.versioned.DeleteMultiVersions(bucket, in.Objects...)
+func (g *GoFakeS3) putBucketRequestPayment(bucket string, w http.ResponseWriter, r *http.Request) error {
+	g.log.Print(LogInfo, "PUT BUCKET REQUEST PAYMENT:", bucket)
+
+	if g.payment == nil {
+		return ErrNotImplemented
 	}
 
-	if err != nil {
+	var in RequestPaymentConfiguration
+	if err := g.xmlDecodeBody(r.Body, &in); err != nil {
 		return err
 	}
-
-	if in.Quiet {
-		out.Deleted = nil
+	if !in.Payer.valid() {
+		return ErrMalformedXML
 	}
 
-	return g.xmlEncoder(w).Encode(out)
+	return g.payment.SetBucketRequestPayment(bucket, in.Payer)
 }
 
-func (g *GoFakeS3) initiateMultipartUpload(bucket, object string, w http.ResponseWriter, r *http.Request) error {
-	g.log.Print(LogInfo, "initiate multipart upload", bucket, object)
-
-	meta, err := metadataHeaders(r.Header, g.timeSource.Now(), g.metadataSizeLimit)
-	if err != nil {
-		return err
+// checkRequestPayer refuses requests for the objects in a Requester Pays
+// bucket that do not acknowledge, with the 'x-amz-request-payer' header, that
+// the requester will be charged for them.
+//
+// https://docs.aws.amazon.com/AmazonS3/latest/userguide/ObjectsinRequesterPaysBuckets.html
+func (g *GoFakeS3) checkRequestPayer(bucket string, w http.ResponseWriter, r *http.Request) error {
+	if g.payment == nil {
+		return nil
 	}
-	if err := g.ensureBucketExists(bucket); err != nil {
+
+	// Requests to buckets that don't exist are left for the router to reject:
+	payer, err := g.payment.BucketRequestPayment(bucket)
+	if HasErrorCode(err, ErrNoSuchBucket) {
+		return nil
+	} else if err != nil {
 		return err
+	} else if payer != PayerRequester {
+		return nil
 	}
 
-	upload := g.uploader.Begin(bucket, object, meta, g.timeSource.Now())
-	out := InitiateMultipartUpload{
-		UploadID: upload.ID,
-		Bucket:   bucket,
-		Key:      object,
+	if !strings.EqualFold(r.Header.Get("x-amz-request-payer"), "requester") {
+		return ErrorMessage(ErrAccessDenied, "Access Denied for requester pays bucket")
 	}
-	return g.xmlEncoder(w).Encode(out)
+	w.Header().Set("x-amz-request-charged", "requester")
+	return nil
 }
 
-// From the docs:
-//
-//	A part number uniquely identifies a part and also defines its position
-//	within the object being created. If you upload a new part using the same
-//	part number that was used with a previous part, the previously uploaded part
-//	is overwritten. Each part must be at least 5 MB in size, except the last
-//	part. There is no size limit on the last part of your multipart upload.
-func (g *GoFakeS3) putMultipartUploadPart(bucket, object string, uploadID UploadID, w http.ResponseWriter, r *http.Request) error {
-	g.log.Print(LogInfo, "put multipart upload", bucket, object, uploadID)
-
-	partNumber, err := strconv.ParseInt(r.URL.Query().Get("partNumber"), 10, 0)
-	if err != nil || partNumber <= 0 || partNumber > MaxUploadPartNumber {
-		return ErrInvalidPart
-	}
+func (g *GoFakeS3) getBucketPolicy(bucket string, w http.ResponseWriter, r *http.Request) error {
+	g.log.Print(LogInfo, "GET BUCKET POLICY:", bucket)
 
-	size, err := strconv.ParseInt(r.Header.Get("Content-Length"), 10, 64)
-	if err != nil || size <= 0 {
-		return ErrMissingContentLength
+	if g.policy == nil {
+		return ErrNotImplemented
 	}
 
-	upload, err := g.uploader.Get(bucket, object, uploadID)
+	policy, err := g.policy.BucketPolicy(bucket)
 	if err != nil {
-		// FIXME: What happens with S3 when you abort a multipart upload while
-		// part uploads are still in progress? In this case, we will retain the
-		// reference to the part even though another request goroutine may
-		// delete it; it will be available for GC when this function finishes.
 		return err
 	}
+	if policy == nil {
+		return ResourceError(ErrNoSuchBucketPolicy, bucket)
+	}
 
-	defer r.Body.Close()
-	var rdr io.Reader = r.Body
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Content-Length", strconv.Itoa(len(policy)))
+	_, err = w.Write(policy)
+	return err
+}
 
-	if g.integrityCheck {
-		md5Base64 := r.Header.Get("Content-MD5")
-		if _, ok := r.Header[textproto.CanonicalMIMEHeaderKey("Content-MD5")]; ok && md5Base64 == "" {
-			return ErrInvalidDigest // Satisfies s3tests
-		}
+func (g *GoFakeS3) putBucketPolicy(bucket string, w http.ResponseWriter, r *http.Request) error {
+	g.log.Print(LogInfo, "PUT BUCKET POLICY:", bucket)
 
-		if md5Base64 != "" {
-			var err error
-			rdr, err = newHashingReader(rdr, md5Base64)
-			if err != nil {
-				return err
-			}
-		}
+	if g.policy == nil {
+		return ErrNotImplemented
 	}
 
-	body, err := ReadAll(rdr, size)
+	defer r.Body.Close()
+	policy, err := ioutil.ReadAll(io.LimitReader(r.Body, MaxBucketPolicySize+1))
 	if err != nil {
 		return err
 	}
-
-	if int64(len(body)) != r.ContentLength {
-		return ErrIncompleteBody
+	if err := validateBucketPolicy(policy); err != nil {
+		return err
+	}
+	if err := g.checkPublicPolicy(bucket, policy); err != nil {
+		return err
 	}
 
-	etag, err := upload.AddPart(int(partNumber), g.timeSource.Now(), body)
-	if err != nil {
+	if err := g.policy.SetBucketPolicy(bucket, policy); err != nil {
 		return err
 	}
 
-	w.Header().Add("ETag", etag)
+	w.WriteHeader(http.StatusNoContent)
 	return nil
 }
 
-func (g *GoFakeS3) abortMultipartUpload(bucket, object string, uploadID UploadID, w http.ResponseWriter, r *http.Request) error {
-	g.log.Print(LogInfo, "abort multipart upload", bucket, object, uploadID)
-	if _, err := g.uploader.Complete(bucket, object, uploadID); err != nil {
+func (g *GoFakeS3) deleteBucketPolicy(bucket string, w http.ResponseWriter, r *http.Request) error {
+	g.log.Print(LogInfo, "DELETE BUCKET POLICY:", bucket)
+
+	if g.policy == nil {
+		return ErrNotImplemented
+	}
+
+	if err := g.policy.DeleteBucketPolicy(bucket); err != nil {
 		return err
 	}
+
 	w.WriteHeader(http.StatusNoContent)
 	return nil
 }
 
-func (g *GoFakeS3) completeMultipartUpload(bucket, object string, uploadID UploadID, w http.ResponseWriter, r *http.Request) error {
-	g.log.Print(LogInfo, "complete multipart upload", bucket, object, uploadID)
+func (g *GoFakeS3) getBucketCORS(bucket string, w http.ResponseWriter, r *http.Request) error {
+	g.log.Print(LogInfo, "GET BUCKET CORS:", bucket)
 
-	var in CompleteMultipartUploadRequest
-	if err := g.xmlDecodeBody(r.Body, &in); err != nil {
-		return err
+	if g.cors == nil {
+		return ErrNotImplemented
 	}
 
-	upload, err := g.uploader.Complete(bucket, object, uploadID)
+	config, err := g.cors.BucketCORS(bucket)
 	if err != nil {
 		return err
 	}
+	if config == nil {
+		return ResourceError(ErrNoSuchCORSConfiguration, bucket)
+	}
 
-	fileBody, etag, err := upload.Reassemble(&in)
-	if err != nil {
-		return err
+	out := *config
+	out.Xmlns = "http://s3.amazonaws.com/doc/2006-03-01/"
+	return g.xmlEncoder(w).Encode(out)
+}
+
+func (g *GoFakeS3) putBucketCORS(bucket string, w http.ResponseWriter, r *http.Request) error {
+	g.log.Print(LogInfo, "PUT BUCKET CORS:", bucket)
+
+	if g.cors == nil {
+		return ErrNotImplemented
 	}
 
-	result, err := g.storage.PutObject(bucket, object, upload.Meta, bytes.NewReader(fileBody), int64(len(fileBody)))
-	if err != nil {
+	var in CORSConfiguration
+	if err := g.xmlDecodeBody(r.Body, &in); err != nil {
 		return err
 	}
-	if result.VersionID != "" {
-		w.Header().Set("x-amz-version-id", string(result.VersionID))
+	if err := validateCORSConfiguration(&in); err != nil {
+		return err
 	}
+	in.Xmlns = ""
 
-	return g.xmlEncoder(w).Encode(&CompleteMultipartUploadResult{
-		ETag:   etag,
-		Bucket: bucket,
-		Key:    object,
-	})
+	return g.cors.SetBucketCORS(bucket, in)
 }
 
-func (g *GoFakeS3) listMultipartUploads(bucket string, w http.ResponseWriter, r *http.Request) error {
-	if err := g.ensureBucketExists(bucket); err != nil {
+func (g *GoFakeS3) deleteBucketCORS(bucket string, w http.ResponseWriter, r *http.Request) error {
+	g.log.Print(LogInfo, "DELETE BUCKET CORS:", bucket)
+
+	if g.cors == nil {
+		return ErrNotImplemented
+	}
+
+	if err := g.cors.DeleteBucketCORS(bucket); err != nil {
 		return err
 	}
 
-	query := r.URL.Query()
-	prefix := prefixFromQuery(query)
-	marker := uploadListMarkerFromQuery(query)
+	w.WriteHeader(http.StatusNoContent)
+	return nil
+}
 
-	maxUploads, err := parseClampedInt(query.Get("max-uploads"), DefaultMaxUploads, 0, MaxUploadsLimit)
-	if err != nil {
-		return ErrInvalidURI
-	}
-	if maxUploads == 0 {
-		maxUploads = DefaultMaxUploads
+func (g *GoFakeS3) getBucketLifecycle(bucket string, w http.ResponseWriter, r *http.Request) error {
+	g.log.Print(LogInfo, "GET BUCKET LIFECYCLE:", bucket)
+
+	if g.lifecycle == nil {
+		return ErrNotImplemented
 	}
 
-	out, err := g.uploader.List(bucket, marker, prefix, maxUploads)
+	config, err := g.lifecycle.BucketLifecycleConfiguration(bucket)
 	if err != nil {
 		return err
 	}
+	if config == nil {
+		return ResourceError(ErrNoSuchLifecycleConfiguration, bucket)
+	}
 
+	out := *config
+	out.Xmlns = "http://s3.amazonaws.com/doc/2006-03-01/"
 	return g.xmlEncoder(w).Encode(out)
 }
 
-func (g *GoFakeS3) listMultipartUploadParts(bucket, object string, uploadID UploadID, w http.ResponseWriter, r *http.Request) error {
-	if err := g.ensureBucketExists(bucket); err != nil {
+func (g *GoFakeS3) putBucketLifecycle(bucket string, w http.ResponseWriter, r *http.Request) error {
+	g.log.Print(LogInfo, "PUT BUCKET LIFECYCLE:", bucket)
+
+	if g.lifecycle == nil {
+		return ErrNotImplemented
+	}
+
+	var in LifecycleConfiguration
+	if err := g.xmlDecodeBody(r.Body, &in); err != nil {
+		return err
+	}
+	if err := validateLifecycleConfiguration(&in); err != nil {
 		return err
 	}
+	in.Xmlns = ""
 
-	query := r.URL.Query()
+	return g.lifecycle.SetBucketLifecycleConfiguration(bucket, in)
+}
 
-	marker, err := parseClampedInt(query.Get("part-number-marker"), 0, 0, math.MaxInt64)
-	if err != nil {
-		return ErrInvalidURI
-	}
+func (g *GoFakeS3) deleteBucketLifecycle(bucket string, w http.ResponseWriter, r *http.Request) error {
+	g.log.Print(LogInfo, "DELETE BUCKET LIFECYCLE:", bucket)
 
-	maxParts, err := parseClampedInt(query.Get("max-parts"), DefaultMaxUploadParts, 0, MaxUploadPartsLimit)
-	if err != nil {
-		return ErrInvalidURI
+	if g.lifecycle == nil {
+		return ErrNotImplemented
 	}
 
-	out, err := g.uploader.ListParts(bucket, object, uploadID, int(marker), maxParts)
-	if err != nil {
+	if err := g.lifecycle.DeleteBucketLifecycleConfiguration(bucket); err != nil {
 		return err
 	}
 
-	return g.xmlEncoder(w).Encode(out)
+	w.WriteHeader(http.StatusNoContent)
+	return nil
 }
 
 func (g *GoFakeS3) getBucketVersioning(bucket string, w http.ResponseWriter, r *http.Request) error {
-	if err := g.ensureBucketExists(bucket); err != nil { // S300007
-		return err
-	}
-
 	var config VersioningConfiguration
 
 	if g.versioned != nil {
@@ -988,45 +3123,70 @@ func (g *GoFakeS3) getBucketVersioning(bucket string, w http.ResponseWriter, r *
 		}
 	}
 
+	config.Xmlns = "http://s3.amazonaws.com/doc/2006-03-01/"
 	return g.xmlEncoder(w).Encode(config)
 }
 
 func (g *GoFakeS3) putBucketVersioning(bucket string, w http.ResponseWriter, r *http.Request) error {
-	if err := g.ensureBucketExists(bucket); err != nil { // S300007
-		return err
-	}
-
 	var in VersioningConfiguration
 	if err := g.xmlDecodeBody(r.Body, &in); err != nil {
 		return err
 	}
 
 	if g.versioned == nil {
-		if in.MFADelete == MFADeleteEnabled || in.Status == VersioningEnabled {
+		if in.MFADelete.Enabled() || in.Status == VersioningEnabled {
 			// We only need to respond that this is not implemented if there's an
 			// attempt to enable it. If we receive a request to disable it, or an
 			// empty request, that matches the current state and has no effect so
 			// we can accept it.
-			return ErrNotImplemented
+			return ErrorMessage(ErrNotImplemented, "The Backend does not support versioning")
 		} else {
 			return nil
 		}
 	}
 
-	g.log.Print(LogInfo, "PUT VERSIONING:", in.Status)
+	// Enabling MFA Delete, or changing the configuration once it is
+	// enabled, needs MFA:
+	if in.MFADelete.Enabled() && g.mfa == nil {
+		return ErrorMessage(ErrNotImplemented, "MFA Delete can not be enabled without an MFA device; see WithMFA")
+	}
+	if in.MFADelete.Enabled() {
+		if err := g.checkMFA(r); err != nil {
+			return err
+		}
+	} else if err := g.checkMFADelete(bucket, r); err != nil {
+		return err
+	}
+
+	g.log.Print(LogInfo, "PUT VERSIONING:", in.Status, in.MFADelete)
 	return g.versioned.SetVersioningConfiguration(bucket, in)
 }
 
-func (g *GoFakeS3) ensureBucketExists(bucket string) error {
+// ensureBucketExists returns ErrNoSuchBucket if the bucket does not exist,
+// unless WithAutoBucket allows it to be created instead. WithAutoBucketFunc
+// only allows it for the operations that write an object, which pass write.
+//
+// routeBase calls it before every operation on a bucket other than
+// CreateBucket, so the handlers need not check again.
+func (g *GoFakeS3) ensureBucketExists(bucket string, write bool) error {
 	exists, err := g.storage.BucketExists(bucket)
 	if err != nil {
 		return err
 	}
-	if !exists && g.autoBucket {
+	if !exists && g.autoBucket && (g.autoBucketFunc == nil || write && g.autoBucketFunc(bucket)) {
+		if err := g.validateBucketName(bucket); err != nil {
+			return err
+		}
 		if err := g.storage.CreateBucket(bucket); err != nil {
 			g.log.Print(LogErr, "autobucket create failed:", err)
 			return ResourceError(ErrNoSuchBucket, bucket)
 		}
+		// The bucket is set up as CreateBucket would without any headers:
+		ownership, _ := g.objectOwnershipFromHeader(http.Header{})
+		if err := g.initBucket(bucket, ownership, nil); err != nil {
+			return err
+		}
+		g.log.Print(LogInfo, "autobucket created:", bucket)
 	} else if !exists {
 		return ResourceError(ErrNoSuchBucket, bucket)
 	}
@@ -1056,6 +3216,24 @@ func (g *GoFakeS3) xmlDecodeBody(rdr io.ReadCloser, into interface{}) error {
 	return nil
 }
 
+// defaultOwner returns the owner reported for all buckets and objects, unless
+// WithOwner is used.
+func defaultOwner() *UserInfo {
+	return &UserInfo{
+		ID:          DefaultOwnerID,
+		DisplayName: "GoFakeS3",
+	}
+}
+
+// objectLastModified returns the Last-Modified header of the object, which
+// is the time the Backend last wrote it if the Backend reports one.
+func objectLastModified(obj *Object) string {
+	if !obj.LastModified.IsZero() {
+		return formatHeaderTime(obj.LastModified)
+	}
+	return obj.Metadata["Last-Modified"]
+}
+
 func formatHeaderTime(t time.Time) string {
 	// https://github.com/aws/aws-sdk-go/issues/1937 - FIXED
 	// https://github.com/aws/aws-sdk-go-v2/issues/178 - Still open
@@ -1065,6 +3243,17 @@ func formatHeaderTime(t time.Time) string {
 	return tc.Format("Mon, 02 Jan 2006 15:04:05") + " GMT"
 }
 
+// parseHeaderTime parses an HTTP date header. Versions of aws-sdk-go prior to
+// the fix for https://github.com/aws/aws-sdk-go/issues/1937 send the day of
+// the month without the leading zero, which http.ParseTime rejects.
+func parseHeaderTime(value string) (time.Time, error) {
+	t, err := http.ParseTime(value)
+	if err != nil {
+		return time.Parse("Mon, 2 Jan 2006 15:04:05 GMT", value)
+	}
+	return t, nil
+}
+
 func metadataSize(meta map[string]string) int {
 	total := 0
 	for k, v := range meta {
@@ -1073,15 +3262,46 @@ func metadataSize(meta map[string]string) int {
 	return total
 }
 
+// systemMetadataHeaders are the standard HTTP headers S3 stores with an object
+// when it is uploaded, and returns with it when it is read.
+var systemMetadataHeaders = map[string]bool{
+	"Cache-Control":       true,
+	"Content-Disposition": true,
+	"Content-Language":    true,
+	"Content-Type":        true,
+	"Expires":             true,
+}
+
 func metadataHeaders(headers map[string][]string, at time.Time, sizeLimit int) (map[string]string, error) {
 	meta := make(map[string]string)
 	for hk, hv := range headers {
-		if strings.HasPrefix(hk, "X-Amz-") || hk == "Content-Type" || hk == "Content-Disposition" {
+		if hk == sseCustomerKeyHeader || hk == copySourceHeaderPrefix+sseCustomerKeyHeader {
+			continue // Customer-provided encryption keys must never be stored
+		}
+		if hk == "Expires" {
+			// S3 returns the date in the standard format, whichever format
+			// the client sent it in:
+			if expires, err := parseHeaderTime(hv[0]); err == nil {
+				meta[hk] = formatHeaderTime(expires)
+			} else {
+				meta[hk] = hv[0]
+			}
+		} else if strings.HasPrefix(hk, "X-Amz-") || systemMetadataHeaders[hk] {
 			meta[hk] = hv[0]
+		} else if hk == "Content-Encoding" {
+			// 'aws-chunked' describes how a streaming upload was sent, not
+			// the object itself, so S3 does not store it:
+			if encoding := withoutAWSChunked(hv[0]); encoding != "" {
+				meta[hk] = encoding
+			}
 		}
 	}
 	meta["Last-Modified"] = formatHeaderTime(at)
 
+	if class, ok := meta[StorageClassMetaKey]; ok && !StorageClass(class).Valid() {
+		return meta, ErrInvalidStorageClass
+	}
+
 	if sizeLimit > 0 && metadataSize(meta) > sizeLimit {
 		return meta, ErrMetadataTooLarge
 	}
@@ -1089,6 +3309,19 @@ func metadataHeaders(headers map[string][]string, at time.Time, sizeLimit int) (
 	return meta, nil
 }
 
+// urlEncodingFromQuery reports whether a listing should URL encode the keys
+// and prefixes in its response. 'url' is the only encoding-type S3 supports.
+func urlEncodingFromQuery(query url.Values) (bool, error) {
+	switch v := query.Get("encoding-type"); v {
+	case "":
+		return false, nil
+	case encodingTypeURL:
+		return true, nil
+	default:
+		return false, ErrorInvalidArgument("encoding-type", v, "Invalid Encoding Method specified in Request")
+	}
+}
+
 func listBucketPageFromQuery(query url.Values) (page ListBucketPage, rerr error) {
 	maxKeys, err := parseClampedInt(query.Get("max-keys"), DefaultMaxBucketKeys, 0, MaxBucketKeys)
 	if err != nil {
@@ -2,11 +2,13 @@ package gofakes3
 
 import (
 	"bytes"
+	"crypto/tls"
 	"encoding/xml"
 	"fmt"
 	"log"
 	"net/http"
 	"net/http/httptest"
+	"strings"
 	"testing"
 )
 
@@ -57,15 +59,33 @@ func TestHostBucketMiddleware(t *testing.T) {
 	for _, tc := range []struct {
 		in   string
 		host string
+		base string
 		out  string
 	}{
-		{"/", "foo", "/foo"},
-		{"/", "mybucket.localhost", "/mybucket"},
-		{"/object", "mybucket.localhost", "/mybucket/object"},
+		{"/", "foo", "", "/foo"},
+		{"/", "foo:9000", "", "/foo"},
+		{"/", "mybucket.localhost", "", "/mybucket"},
+		{"/object", "mybucket.localhost", "", "/mybucket/object"},
+		{"/object", "mybucket.localhost:9000", "", "/mybucket/object"},
+
+		{"/object", "mybucket.s3.localhost", "s3.localhost", "/mybucket/object"},
+		{"/object", "mybucket.s3.localhost:9000", "s3.localhost", "/mybucket/object"},
+		{"/object", "MyBucket.S3.Localhost", "s3.localhost", "/mybucket/object"},
+		{"/", "my.bucket.s3.localhost", "s3.localhost", "/my.bucket"},
+		{"/mybucket/object", "s3.localhost", "s3.localhost", "/mybucket/object"},
+		{"/mybucket/object", "s3.localhost:9000", "s3.localhost", "/mybucket/object"},
+		{"/mybucket/object", "localhost:9000", "s3.localhost", "/mybucket/object"},
+		{"/mybucket/object", "127.0.0.1:9000", "s3.localhost", "/mybucket/object"},
+		{"/mybucket/object", "[::1]:9000", "s3.localhost", "/mybucket/object"},
+		{"/mybucket/object", "example.com", "s3.localhost", "/mybucket/object"},
 	} {
 		t.Run("", func(t *testing.T) {
 			var g GoFakeS3
 			g.log = DiscardLog()
+			WithHostBucket(true)(&g)
+			if tc.base != "" {
+				WithHostBucketBase(tc.base)(&g)
+			}
 
 			inner := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 				if r.URL.Path != tc.out {
@@ -89,3 +109,39 @@ type failingResponseWriter struct {
 func (w *failingResponseWriter) Write(buf []byte) (n int, err error) {
 	return 0, fmt.Errorf("nope")
 }
+
+func TestRandomRequestID(t *testing.T) {
+	id := randomRequestID()
+	if len(id) != 16 || strings.Trim(id, "0123456789ABCDEF") != "" {
+		t.Fatal("unexpected request ID", id)
+	}
+	if id == randomRequestID() {
+		t.Fatal("request IDs are not random")
+	}
+}
+
+func TestRequestScheme(t *testing.T) {
+	for idx, tc := range []struct {
+		tls       bool
+		forwarded string
+		scheme    string
+	}{
+		{tls: false, scheme: "http"},
+		{tls: true, scheme: "https"},
+		{tls: false, forwarded: "https", scheme: "https"},
+		{tls: false, forwarded: "HTTPS, http", scheme: "https"},
+		{tls: true, forwarded: "http", scheme: "http"},
+		{tls: true, forwarded: "ftp", scheme: "https"},
+	} {
+		rq := httptest.NewRequest("GET", "/", nil)
+		if tc.tls {
+			rq.TLS = &tls.ConnectionState{}
+		}
+		if tc.forwarded != "" {
+			rq.Header.Set("X-Forwarded-Proto", tc.forwarded)
+		}
+		if scheme := requestScheme(rq); scheme != tc.scheme {
+			t.Fatal(idx, "expected", tc.scheme, "found", scheme)
+		}
+	}
+}
@@ -3,24 +3,45 @@ package gofakes3_test
 import (
 	"bufio"
 	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
 	"encoding/xml"
 	"fmt"
+	"hash/crc32"
 	"io"
 	"io/ioutil"
+	"log"
+	"math/rand"
+	"mime"
 	"mime/multipart"
+	"net"
 	"net/http"
+	"net/http/httptest"
 	"net/http/httputil"
 	"net/url"
+	"os"
+	"path/filepath"
 	"reflect"
+	"runtime"
 	"sort"
+	"strconv"
 	"strings"
+	"sync"
 	"testing"
 	"time"
 
 	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/aws/aws-sdk-go/aws/session"
+	v4 "github.com/aws/aws-sdk-go/aws/signer/v4"
 	"github.com/aws/aws-sdk-go/service/s3"
 	"github.com/johannesboyne/gofakes3"
 	"github.com/johannesboyne/gofakes3/backend/s3mem"
+	"github.com/johannesboyne/gofakes3/gofakes3test"
 )
 
 func TestCreateBucket(t *testing.T) {
@@ -51,6 +72,334 @@ func TestCreateBucket(t *testing.T) {
 	}))
 }
 
+func TestCreateBucketExists(t *testing.T) {
+	create := func(svc *s3.S3, bucket string, config *s3.CreateBucketConfiguration) error {
+		_, err := svc.CreateBucket(&s3.CreateBucketInput{Bucket: aws.String(bucket), CreateBucketConfiguration: config})
+		return err
+	}
+
+	t.Run("default region", func(t *testing.T) {
+		ts := newTestServer(t)
+		defer ts.Close()
+		svc := ts.s3Client()
+
+		// Re-creating a bucket you own succeeds, which includes the buckets
+		// created with the Backend:
+		ts.OK(create(svc, "bucket", nil))
+		ts.OK(create(svc, "bucket", nil))
+		ts.OK(create(svc, defaultBucket, nil))
+
+		// ...and resets its ACL:
+		rq, _ := svc.CreateBucketRequest(&s3.CreateBucketInput{Bucket: aws.String("public"), ACL: aws.String("public-read")})
+		rq.HTTPRequest.Header.Set("x-amz-object-ownership", string(gofakes3.ObjectOwnershipObjectWriter))
+		ts.OK(rq.Send())
+		ts.OK(create(svc, "public", nil))
+		out, err := svc.GetBucketAcl(&s3.GetBucketAclInput{Bucket: aws.String("public")})
+		ts.OK(err)
+		if len(out.Grants) != 1 {
+			t.Fatal("expected private bucket, found", out.Grants)
+		}
+	})
+
+	t.Run("other region", func(t *testing.T) {
+		ts := newTestServer(t, withFakerOptions(gofakes3.WithRegion("eu-west-1")))
+		defer ts.Close()
+		svc := ts.s3Client()
+
+		config := &s3.CreateBucketConfiguration{LocationConstraint: aws.String("eu-west-1")}
+		ts.OK(create(svc, "bucket", config))
+		for _, bucket := range []string{"bucket", defaultBucket} {
+			if err := create(svc, bucket, config); !s3HasErrorCode(err, gofakes3.ErrBucketAlreadyOwnedByYou) {
+				t.Fatal("expected BucketAlreadyOwnedByYou for", bucket, "found", err)
+			}
+		}
+	})
+
+	t.Run("other owner", func(t *testing.T) {
+		ts := newTestServer(t)
+		defer ts.Close()
+		other := newTestServer(t,
+			withBackend(ts.backend),
+			withoutInitialBuckets(),
+			withFakerOptions(gofakes3.WithOwner("other", "Other")))
+		defer other.Close()
+
+		ts.OK(create(ts.s3Client(), "mine", nil))
+		ts.OK(create(other.s3Client(), "theirs", nil))
+		for _, tc := range []struct {
+			svc    *s3.S3
+			bucket string
+		}{
+			{ts.s3Client(), "theirs"},
+			{other.s3Client(), "mine"},
+		} {
+			if err := create(tc.svc, tc.bucket, nil); !s3HasErrorCode(err, gofakes3.ErrBucketAlreadyExists) {
+				t.Fatal("expected BucketAlreadyExists for", tc.bucket, "found", err)
+			}
+		}
+	})
+}
+
+func TestMissingBucket(t *testing.T) {
+	ts := newTestServer(t)
+	defer ts.Close()
+	svc := ts.s3Client()
+
+	const bucket = "missing"
+	for _, tc := range []struct {
+		name string
+		call func() error
+	}{
+		{"GetObject", func() error {
+			_, err := svc.GetObject(&s3.GetObjectInput{Bucket: aws.String(bucket), Key: aws.String("object")})
+			return err
+		}},
+		{"PutObject", func() error {
+			_, err := svc.PutObject(&s3.PutObjectInput{Bucket: aws.String(bucket), Key: aws.String("object"), Body: strings.NewReader("hello")})
+			return err
+		}},
+		{"DeleteObject", func() error {
+			_, err := svc.DeleteObject(&s3.DeleteObjectInput{Bucket: aws.String(bucket), Key: aws.String("object")})
+			return err
+		}},
+		{"CopyObject", func() error {
+			_, err := svc.CopyObject(&s3.CopyObjectInput{Bucket: aws.String(bucket), Key: aws.String("object"), CopySource: aws.String(defaultBucket + "/object")})
+			return err
+		}},
+		{"CreateMultipartUpload", func() error {
+			_, err := svc.CreateMultipartUpload(&s3.CreateMultipartUploadInput{Bucket: aws.String(bucket), Key: aws.String("object")})
+			return err
+		}},
+		{"GetObjectTagging", func() error {
+			_, err := svc.GetObjectTagging(&s3.GetObjectTaggingInput{Bucket: aws.String(bucket), Key: aws.String("object")})
+			return err
+		}},
+		{"PutObjectAcl", func() error {
+			_, err := svc.PutObjectAcl(&s3.PutObjectAclInput{Bucket: aws.String(bucket), Key: aws.String("object"), ACL: aws.String("private")})
+			return err
+		}},
+		{"ListObjectVersions", func() error {
+			_, err := svc.ListObjectVersions(&s3.ListObjectVersionsInput{Bucket: aws.String(bucket)})
+			return err
+		}},
+		{"GetBucketVersioning", func() error {
+			_, err := svc.GetBucketVersioning(&s3.GetBucketVersioningInput{Bucket: aws.String(bucket)})
+			return err
+		}},
+		{"GetBucketLifecycleConfiguration", func() error {
+			_, err := svc.GetBucketLifecycleConfiguration(&s3.GetBucketLifecycleConfigurationInput{Bucket: aws.String(bucket)})
+			return err
+		}},
+		{"GetBucketReplication", func() error {
+			_, err := svc.GetBucketReplication(&s3.GetBucketReplicationInput{Bucket: aws.String(bucket)})
+			return err
+		}},
+		{"ListBucketInventoryConfigurations", func() error {
+			_, err := svc.ListBucketInventoryConfigurations(&s3.ListBucketInventoryConfigurationsInput{Bucket: aws.String(bucket)})
+			return err
+		}},
+		{"DeleteBucketTagging", func() error {
+			_, err := svc.DeleteBucketTagging(&s3.DeleteBucketTaggingInput{Bucket: aws.String(bucket)})
+			return err
+		}},
+		{"DeleteObjects", func() error {
+			_, err := svc.DeleteObjects(&s3.DeleteObjectsInput{Bucket: aws.String(bucket), Delete: &s3.Delete{Objects: []*s3.ObjectIdentifier{{Key: aws.String("object")}}}})
+			return err
+		}},
+		{"DeleteObjectVersion", func() error {
+			_, err := svc.DeleteObject(&s3.DeleteObjectInput{Bucket: aws.String(bucket), Key: aws.String("object"), VersionId: aws.String("v1")})
+			return err
+		}},
+		{"GetObjectVersion", func() error {
+			_, err := svc.GetObject(&s3.GetObjectInput{Bucket: aws.String(bucket), Key: aws.String("object"), VersionId: aws.String("v1")})
+			return err
+		}},
+		{"ListParts", func() error {
+			_, err := svc.ListParts(&s3.ListPartsInput{Bucket: aws.String(bucket), Key: aws.String("object"), UploadId: aws.String("upload")})
+			return err
+		}},
+		{"AbortMultipartUpload", func() error {
+			_, err := svc.AbortMultipartUpload(&s3.AbortMultipartUploadInput{Bucket: aws.String(bucket), Key: aws.String("object"), UploadId: aws.String("upload")})
+			return err
+		}},
+		{"ListMultipartUploads", func() error {
+			_, err := svc.ListMultipartUploads(&s3.ListMultipartUploadsInput{Bucket: aws.String(bucket)})
+			return err
+		}},
+		{"GetObjectAcl", func() error {
+			_, err := svc.GetObjectAcl(&s3.GetObjectAclInput{Bucket: aws.String(bucket), Key: aws.String("object")})
+			return err
+		}},
+		{"PutObjectTagging", func() error {
+			_, err := svc.PutObjectTagging(&s3.PutObjectTaggingInput{Bucket: aws.String(bucket), Key: aws.String("object"), Tagging: &s3.Tagging{TagSet: []*s3.Tag{}}})
+			return err
+		}},
+		{"GetObjectRetention", func() error {
+			_, err := svc.GetObjectRetention(&s3.GetObjectRetentionInput{Bucket: aws.String(bucket), Key: aws.String("object")})
+			return err
+		}},
+		{"GetObjectLegalHold", func() error {
+			_, err := svc.GetObjectLegalHold(&s3.GetObjectLegalHoldInput{Bucket: aws.String(bucket), Key: aws.String("object")})
+			return err
+		}},
+		{"RestoreObject", func() error {
+			_, err := svc.RestoreObject(&s3.RestoreObjectInput{Bucket: aws.String(bucket), Key: aws.String("object"), RestoreRequest: &s3.RestoreRequest{Days: aws.Int64(1)}})
+			return err
+		}},
+		{"GetBucketAcl", func() error {
+			_, err := svc.GetBucketAcl(&s3.GetBucketAclInput{Bucket: aws.String(bucket)})
+			return err
+		}},
+		{"GetBucketPolicy", func() error {
+			_, err := svc.GetBucketPolicy(&s3.GetBucketPolicyInput{Bucket: aws.String(bucket)})
+			return err
+		}},
+		{"GetBucketCors", func() error {
+			_, err := svc.GetBucketCors(&s3.GetBucketCorsInput{Bucket: aws.String(bucket)})
+			return err
+		}},
+		{"GetBucketWebsite", func() error {
+			_, err := svc.GetBucketWebsite(&s3.GetBucketWebsiteInput{Bucket: aws.String(bucket)})
+			return err
+		}},
+		{"GetBucketRequestPayment", func() error {
+			_, err := svc.GetBucketRequestPayment(&s3.GetBucketRequestPaymentInput{Bucket: aws.String(bucket)})
+			return err
+		}},
+		{"ListObjectsV2", func() error {
+			_, err := svc.ListObjectsV2(&s3.ListObjectsV2Input{Bucket: aws.String(bucket)})
+			return err
+		}},
+		{"DeleteBucket", func() error {
+			_, err := svc.DeleteBucket(&s3.DeleteBucketInput{Bucket: aws.String(bucket)})
+			return err
+		}},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			if err := tc.call(); !s3HasErrorCode(err, gofakes3.ErrNoSuchBucket) {
+				t.Fatal("expected NoSuchBucket, found", err)
+			}
+		})
+	}
+
+	// HEAD responses have no body, so only the status tells:
+	_, err := svc.HeadObject(&s3.HeadObjectInput{Bucket: aws.String(bucket), Key: aws.String("object")})
+	if rerr, ok := err.(awserr.RequestFailure); !ok || rerr.StatusCode() != http.StatusNotFound {
+		t.Fatal("expected 404, found", err)
+	}
+
+	rs, err := httpClient().Get(ts.url("/" + bucket + "/object"))
+	ts.OK(err)
+	defer rs.Body.Close()
+	body, err := ioutil.ReadAll(rs.Body)
+	ts.OK(err)
+	if rs.StatusCode != http.StatusNotFound ||
+		!strings.Contains(string(body), "<Code>NoSuchBucket</Code>") ||
+		!strings.Contains(string(body), "<Resource>"+bucket+"</Resource>") {
+		t.Fatal("unexpected response", rs.StatusCode, string(body))
+	}
+	if exists, err := ts.backend.BucketExists(bucket); err != nil || exists {
+		t.Fatal("bucket was created", exists, err)
+	}
+}
+
+// countingBackend counts the calls to BucketExists.
+type countingBackend struct {
+	gofakes3.Backend
+	bucketExists int
+}
+
+func (b *countingBackend) BucketExists(name string) (bool, error) {
+	b.bucketExists++
+	return b.Backend.BucketExists(name)
+}
+
+func TestBucketExistsOncePerRequest(t *testing.T) {
+	backend := &countingBackend{Backend: s3mem.New()}
+	ts := newTestServer(t, withBackend(backend))
+	defer ts.Close()
+	svc := ts.s3Client()
+
+	for _, tc := range []struct {
+		name string
+		call func() error
+	}{
+		{"PutObject", func() error {
+			_, err := svc.PutObject(&s3.PutObjectInput{Bucket: aws.String(defaultBucket), Key: aws.String("object"), Body: strings.NewReader("hello")})
+			return err
+		}},
+		{"GetObject", func() error {
+			out, err := svc.GetObject(&s3.GetObjectInput{Bucket: aws.String(defaultBucket), Key: aws.String("object")})
+			if err == nil {
+				out.Body.Close()
+			}
+			return err
+		}},
+		{"ListObjects", func() error {
+			_, err := svc.ListObjects(&s3.ListObjectsInput{Bucket: aws.String(defaultBucket)})
+			return err
+		}},
+		{"GetObjectMissingBucket", func() error {
+			_, err := svc.GetObject(&s3.GetObjectInput{Bucket: aws.String("missing"), Key: aws.String("object")})
+			if s3HasErrorCode(err, gofakes3.ErrNoSuchBucket) {
+				return nil
+			}
+			return err
+		}},
+	} {
+		backend.bucketExists = 0
+		ts.OK(tc.call())
+		if backend.bucketExists != 1 {
+			t.Fatal(tc.name, "checked the bucket exists", backend.bucketExists, "times")
+		}
+	}
+}
+
+func TestBucketNameValidation(t *testing.T) {
+	putObject := func(svc *s3.S3, bucket, key string) error {
+		_, err := svc.PutObject(&s3.PutObjectInput{
+			Bucket: aws.String(bucket),
+			Key:    aws.String(key),
+			Body:   bytes.NewReader([]byte("yep")),
+		})
+		return err
+	}
+
+	t.Run("strict", func(t *testing.T) {
+		ts := newTestServer(t)
+		defer ts.Close()
+		svc := ts.s3Client()
+
+		for _, bucket := range []string{"UPPER_CASE", "a", "xn--yep"} {
+			_, err := svc.CreateBucket(&s3.CreateBucketInput{Bucket: aws.String(bucket)})
+			if !hasErrorCode(err, gofakes3.ErrInvalidBucketName) {
+				t.Fatal("expected InvalidBucketName for", bucket, "found", err)
+			}
+		}
+		if err := putObject(svc, defaultBucket, "n\x01p"); !hasErrorCode(err, gofakes3.ErrInvalidArgument) {
+			t.Fatal("expected InvalidArgument, found", err)
+		}
+		if err := putObject(svc, defaultBucket, strings.Repeat("a", gofakes3.KeySizeLimit+1)); !hasErrorCode(err, gofakes3.ErrKeyTooLong) {
+			t.Fatal("expected KeyTooLongError, found", err)
+		}
+		ts.OK(putObject(svc, defaultBucket, "y/e p"))
+	})
+
+	t.Run("relaxed", func(t *testing.T) {
+		ts := newTestServer(t, withFakerOptions(gofakes3.WithBucketNameValidation(gofakes3.BucketNamesRelaxed)))
+		defer ts.Close()
+		svc := ts.s3Client()
+
+		for _, bucket := range []string{"UPPER_CASE", "a"} {
+			ts.OKAll(svc.CreateBucket(&s3.CreateBucketInput{Bucket: aws.String(bucket)}))
+			ts.OK(putObject(svc, bucket, "n\x01p"))
+		}
+		if err := putObject(svc, defaultBucket, strings.Repeat("a", gofakes3.KeySizeLimit+1)); !hasErrorCode(err, gofakes3.ErrKeyTooLong) {
+			t.Fatal("expected KeyTooLongError, found", err)
+		}
+	})
+}
+
 func TestListBuckets(t *testing.T) {
 	ts := newTestServer(t, withoutInitialBuckets())
 	defer ts.Close()
@@ -107,6 +456,78 @@ func TestListBuckets(t *testing.T) {
 	assertBucketTime("test", defaultDate)
 	assertBucketTime("test2", defaultDate)
 	assertBucketTime("test3", defaultDate.Add(1*time.Minute))
+
+	// A bucket that is deleted and created again gets a new creation date:
+	ts.Advance(1 * time.Minute)
+	ts.OKAll(svc.DeleteBucket(&s3.DeleteBucketInput{Bucket: aws.String("test2")}))
+	ts.OKAll(svc.CreateBucket(&s3.CreateBucketInput{Bucket: aws.String("test2")}))
+	assertBuckets("test", "test2", "test3")
+	assertBucketTime("test", defaultDate)
+	assertBucketTime("test2", defaultDate.Add(2*time.Minute))
+}
+
+func TestListBucketsPages(t *testing.T) {
+	ts := newTestServer(t, withoutInitialBuckets())
+	defer ts.Close()
+
+	var expected []string
+	for i := 0; i < 25; i++ {
+		bucket := fmt.Sprintf("bucket-%02d", i)
+		ts.backendCreateBucket(bucket)
+		expected = append(expected, bucket)
+	}
+	ts.backendCreateBucket("other")
+
+	list := func(query url.Values) gofakes3.Storage {
+		t.Helper()
+		rs, err := httpClient().Get(ts.url("/?" + query.Encode()))
+		ts.OK(err)
+		defer rs.Body.Close()
+		body, err := ioutil.ReadAll(rs.Body)
+		ts.OK(err)
+		if rs.StatusCode != http.StatusOK {
+			t.Fatal("unexpected response", rs.StatusCode, string(body))
+		}
+		var result gofakes3.Storage
+		ts.OK(xml.Unmarshal(body, &result))
+		return result
+	}
+
+	// Without any parameters, all buckets are listed:
+	if found := list(nil); len(found.Buckets) != 26 || found.ContinuationToken != "" || found.Prefix != "" {
+		t.Fatal("unexpected listing", found)
+	}
+
+	for _, maxBuckets := range []string{"1", "7", "25", ""} {
+		var found []string
+		query := url.Values{"prefix": {"bucket-"}}
+		if maxBuckets != "" {
+			query.Set("max-buckets", maxBuckets)
+		}
+		for pages := 0; ; pages++ {
+			if pages > 25 {
+				t.Fatal("too many pages")
+			}
+			result := list(query)
+			if result.Prefix != "bucket-" {
+				t.Fatal("unexpected prefix", result.Prefix)
+			}
+			for _, bucket := range result.Buckets {
+				found = append(found, bucket.Name)
+			}
+			if result.ContinuationToken == "" {
+				break
+			}
+			query.Set("continuation-token", result.ContinuationToken)
+		}
+		if !reflect.DeepEqual(found, expected) {
+			t.Fatalf("max-buckets %q:\nexp: %v\ngot: %v", maxBuckets, expected, found)
+		}
+	}
+
+	if found := list(url.Values{"prefix": {"nope"}}); len(found.Buckets) != 0 {
+		t.Fatal("unexpected buckets", found.Buckets)
+	}
 }
 
 func TestListBucketObjectSize(t *testing.T) {
@@ -207,19 +628,186 @@ func TestCreateObjectMD5(t *testing.T) {
 	}
 }
 
-func TestCreateObjectWithMissingContentLength(t *testing.T) {
+func TestCreateObjectChecksum(t *testing.T) {
 	ts := newTestServer(t)
 	defer ts.Close()
+	svc := ts.s3Client()
+
+	body := []byte("hello")
+	crc := crc32.ChecksumIEEE(body)
+	crcBytes := []byte{byte(crc >> 24), byte(crc >> 16), byte(crc >> 8), byte(crc)}
+	sha := sha256.Sum256(body)
+
+	put := func(hdr map[string]string) (*http.Response, error) {
+		t.Helper()
+		req, _ := svc.PutObjectRequest(&s3.PutObjectInput{
+			Bucket: aws.String(defaultBucket),
+			Key:    aws.String("object"),
+			Body:   bytes.NewReader(body),
+		})
+		for k, v := range hdr {
+			req.HTTPRequest.Header.Set(k, v)
+		}
+		err := req.Send()
+		return req.HTTPResponse, err
+	}
+
+	for _, tc := range []struct {
+		header, value string
+	}{
+		{"x-amz-checksum-crc32", base64.StdEncoding.EncodeToString(crcBytes)},
+		{"x-amz-checksum-sha256", base64.StdEncoding.EncodeToString(sha[:])},
+	} {
+		rs, err := put(map[string]string{tc.header: tc.value})
+		ts.OK(err)
+		if v := rs.Header.Get(tc.header); v != tc.value {
+			t.Fatal("checksum not returned by PUT", tc.header, v)
+		}
+
+		req, _ := svc.HeadObjectRequest(&s3.HeadObjectInput{
+			Bucket: aws.String(defaultBucket),
+			Key:    aws.String("object"),
+		})
+		ts.OK(req.Send())
+		if v := req.HTTPResponse.Header.Get(tc.header); v != tc.value {
+			t.Fatal("checksum not returned by HEAD", tc.header, v)
+		}
+	}
+
+	wrong := sha256.Sum256([]byte("nope"))
+	if _, err := put(map[string]string{"x-amz-checksum-sha256": base64.StdEncoding.EncodeToString(wrong[:])}); !s3HasErrorCode(err, gofakes3.ErrBadDigest) {
+		t.Fatal("expected BadDigest, found", err)
+	}
+	if _, err := put(map[string]string{"x-amz-checksum-crc32c": "quack"}); !s3HasErrorCode(err, gofakes3.ErrInvalidRequest) {
+		t.Fatal("expected InvalidRequest, found", err)
+	}
+	if _, err := put(map[string]string{
+		"x-amz-checksum-crc32":  base64.StdEncoding.EncodeToString(crcBytes),
+		"x-amz-checksum-sha256": base64.StdEncoding.EncodeToString(sha[:]),
+	}); !s3HasErrorCode(err, gofakes3.ErrInvalidRequest) {
+		t.Fatal("expected InvalidRequest, found", err)
+	}
+}
+
+func TestCreateObjectStreaming(t *testing.T) {
+	ts := newTestServer(t, withFakerOptions(gofakes3.WithIntegrityCheck(true)))
+	defer ts.Close()
 	client := ts.rawClient()
-	body := []byte{}
-	rq, err := http.NewRequest("PUT", client.URL(fmt.Sprintf("/%s/yep", defaultBucket)).String(), maskReader(bytes.NewReader(body)))
-	if err != nil {
-		panic(err)
+
+	const sig = ";chunk-signature=ad80c730a21e5b8d04586a2213dd63b9a0e99e0e2307b0ade35a65485a288648\r\n"
+	chunked := func(chunks ...string) []byte {
+		var b bytes.Buffer
+		for _, chunk := range chunks {
+			fmt.Fprintf(&b, "%x%s%s\r\n", len(chunk), sig, chunk)
+		}
+		return b.Bytes()
 	}
-	client.SetHeaders(rq, body)
-	rs, _ := client.Do(rq)
+
+	send := func(path, query string, payload string, body []byte) *http.Response {
+		t.Helper()
+		rq := client.Request("PUT", path, body)
+		rq.URL.RawQuery = query
+		rq.Header.Set("Content-Encoding", "aws-chunked")
+		rq.Header.Set("X-Amz-Content-Sha256", "STREAMING-AWS4-HMAC-SHA256-PAYLOAD")
+		rq.Header.Set("X-Amz-Decoded-Content-Length", strconv.Itoa(len(payload)))
+		rq.Header.Set("Content-Md5", hashMD5Bytes([]byte(payload)).Base64())
+		rs, err := client.Do(rq)
+		ts.OK(err)
+		rs.Body.Close()
+		return rs
+	}
+
+	payload := strings.Repeat("a", 1000) + strings.Repeat("b", 24)
+	if rs := send("/"+defaultBucket+"/object", "", payload, chunked(payload[:1000], payload[1000:], "")); rs.StatusCode != 200 {
+		t.Fatal("unexpected status", rs.StatusCode)
+	}
+	ts.assertObject(defaultBucket, "object", nil, payload)
+
+	// The stream ends before the final chunk:
+	if rs := send("/"+defaultBucket+"/truncated", "", payload, chunked(payload[:1000])); rs.StatusCode != 400 {
+		t.Fatal("unexpected status", rs.StatusCode)
+	}
+	if ts.backendObjectExists(defaultBucket, "truncated") {
+		t.Fatal("unexpected object")
+	}
+
+	// Parts may also be streamed:
+	uploadID := ts.createMultipartUpload(defaultBucket, "multipart", nil)
+	rs := send("/"+defaultBucket+"/multipart", "partNumber=1&uploadId="+uploadID, payload, chunked(payload, ""))
+	if rs.StatusCode != 200 {
+		t.Fatal("unexpected status", rs.StatusCode)
+	}
+	etag := rs.Header.Get("ETag")
+	ts.assertCompleteUpload(defaultBucket, "multipart", uploadID, []*s3.CompletedPart{
+		{ETag: aws.String(etag), PartNumber: aws.Int64(1)},
+	}, payload)
+}
+
+func TestCreateObjectWithMissingContentLength(t *testing.T) {
+	ts := newTestServer(t)
+	defer ts.Close()
+
+	// Without Content-Length or 'Transfer-Encoding: chunked', the size of
+	// the body is unknown:
+	conn, err := net.Dial("tcp", strings.TrimPrefix(ts.server.URL, "http://"))
+	ts.OK(err)
+	defer conn.Close()
+	_, err = fmt.Fprintf(conn, "PUT /%s/yep HTTP/1.1\r\nHost: localhost\r\n\r\n", defaultBucket)
+	ts.OK(err)
+	rs, err := http.ReadResponse(bufio.NewReader(conn), nil)
+	ts.OK(err)
+	rs.Body.Close()
 	if rs.StatusCode != http.StatusLengthRequired {
-		t.Fatal()
+		t.Fatal(rs.StatusCode, "!=", http.StatusLengthRequired)
+	}
+}
+
+func TestCreateObjectWithChunkedBody(t *testing.T) {
+	ts := newTestServer(t, withFakerOptions(gofakes3.WithMaxUploadSize(10)))
+	defer ts.Close()
+	client := ts.rawClient()
+
+	put := func(key string, body []byte, md5 string) *http.Response {
+		t.Helper()
+		// maskReader hides the size of the body, so it is sent with
+		// 'Transfer-Encoding: chunked' and no Content-Length:
+		rq, err := http.NewRequest("PUT", client.URL(fmt.Sprintf("/%s/%s", defaultBucket, key)).String(), maskReader(bytes.NewReader(body)))
+		ts.OK(err)
+		client.SetHeaders(rq, body)
+		rq.Header.Set("Content-Md5", md5)
+		rs, err := client.Do(rq)
+		ts.OK(err)
+		rs.Body.Close()
+		return rs
+	}
+
+	body := []byte("hello")
+	rs := put("object", body, hashMD5Bytes(body).Base64())
+	if rs.StatusCode != http.StatusOK {
+		t.Fatal("unexpected status", rs.StatusCode)
+	}
+	if etag := rs.Header.Get("ETag"); etag != `"`+hashMD5Bytes(body).Hex()+`"` {
+		t.Fatal("unexpected ETag", etag)
+	}
+	ts.assertObject(defaultBucket, "object", nil, "hello")
+
+	obj, err := ts.backend.HeadObject(defaultBucket, "object")
+	ts.OK(err)
+	if obj.Size != int64(len(body)) {
+		t.Fatal("unexpected size", obj.Size)
+	}
+
+	// The integrity check and the size limit still apply:
+	if rs := put("bad-digest", body, hashMD5Bytes([]byte("nope")).Base64()); rs.StatusCode != http.StatusBadRequest {
+		t.Fatal("unexpected status", rs.StatusCode)
+	}
+	if rs := put("too-large", []byte("hello world"), hashMD5Bytes([]byte("hello world")).Base64()); rs.StatusCode != http.StatusBadRequest {
+		t.Fatal("unexpected status", rs.StatusCode)
+	}
+	for _, key := range []string{"bad-digest", "too-large"} {
+		if ts.backendObjectExists(defaultBucket, key) {
+			t.Fatal("object was stored:", key)
+		}
 	}
 }
 
@@ -277,6 +865,88 @@ func TestCreateObjectWithContentDisposition(t *testing.T) {
 	}
 }
 
+func TestObjectSystemMetadata(t *testing.T) {
+	ts := newTestServer(t)
+	defer ts.Close()
+	svc := ts.s3Client()
+
+	expires := time.Date(2019, 1, 1, 0, 0, 0, 0, time.UTC)
+	_, err := svc.PutObject(&s3.PutObjectInput{
+		Bucket:             aws.String(defaultBucket),
+		Key:                aws.String("object"),
+		Body:               bytes.NewReader([]byte("hello")),
+		CacheControl:       aws.String("max-age=3600"),
+		ContentDisposition: aws.String("inline"),
+		ContentEncoding:    aws.String("gzip"),
+		ContentLanguage:    aws.String("en-GB"),
+		Expires:            aws.Time(expires),
+	})
+	ts.OK(err)
+
+	assertHeaders := func(key string, cacheControl, language string) {
+		t.Helper()
+		out, err := svc.HeadObject(&s3.HeadObjectInput{
+			Bucket: aws.String(defaultBucket),
+			Key:    aws.String(key),
+		})
+		ts.OK(err)
+		if v := aws.StringValue(out.CacheControl); v != cacheControl {
+			t.Fatalf("Cache-Control %q, expected %q", v, cacheControl)
+		}
+		if v := aws.StringValue(out.ContentLanguage); v != language {
+			t.Fatalf("Content-Language %q, expected %q", v, language)
+		}
+		if v := aws.StringValue(out.ContentDisposition); v != "inline" {
+			t.Fatalf("Content-Disposition %q, expected %q", v, "inline")
+		}
+		if v := aws.StringValue(out.ContentEncoding); v != "gzip" {
+			t.Fatalf("Content-Encoding %q, expected %q", v, "gzip")
+		}
+		if v := aws.StringValue(out.Expires); v != "Tue, 01 Jan 2019 00:00:00 GMT" {
+			t.Fatalf("Expires %q, expected %q", v, "Tue, 01 Jan 2019 00:00:00 GMT")
+		}
+	}
+	assertHeaders("object", "max-age=3600", "en-GB")
+
+	// The stored values are copied with the object, unless they are replaced:
+	_, err = svc.CopyObject(&s3.CopyObjectInput{
+		Bucket:     aws.String(defaultBucket),
+		Key:        aws.String("copy"),
+		CopySource: aws.String(defaultBucket + "/object"),
+	})
+	ts.OK(err)
+	assertHeaders("copy", "max-age=3600", "en-GB")
+
+	_, err = svc.CopyObject(&s3.CopyObjectInput{
+		Bucket:             aws.String(defaultBucket),
+		Key:                aws.String("replaced"),
+		CopySource:         aws.String(defaultBucket + "/object"),
+		MetadataDirective:  aws.String("REPLACE"),
+		CacheControl:       aws.String("no-cache"),
+		ContentDisposition: aws.String("inline"),
+		ContentEncoding:    aws.String("gzip"),
+		Expires:            aws.Time(expires),
+	})
+	ts.OK(err)
+	assertHeaders("replaced", "no-cache", "")
+
+	// response-* query parameters take precedence over the stored values:
+	out, err := svc.GetObject(&s3.GetObjectInput{
+		Bucket:                  aws.String(defaultBucket),
+		Key:                     aws.String("object"),
+		ResponseCacheControl:    aws.String("no-store"),
+		ResponseContentLanguage: aws.String("fr"),
+	})
+	ts.OK(err)
+	defer out.Body.Close()
+	if v := aws.StringValue(out.CacheControl); v != "no-store" {
+		t.Fatal("unexpected Cache-Control", v)
+	}
+	if v := aws.StringValue(out.ContentLanguage); v != "fr" {
+		t.Fatal("unexpected Content-Language", v)
+	}
+}
+
 func TestCreateObjectMetadataAndObjectTagging(t *testing.T) {
 	ts := newTestServer(t)
 	defer ts.Close()
@@ -360,820 +1030,7083 @@ func TestCreateObjectMetadataAndObjectTagging(t *testing.T) {
 	}
 }
 
-func TestCopyObject(t *testing.T) {
+func TestObjectTagging(t *testing.T) {
 	ts := newTestServer(t)
 	defer ts.Close()
 	svc := ts.s3Client()
 
-	srcMeta := map[string]string{
-		"Content-Type":   "text/plain",
-		"X-Amz-Meta-One": "src",
-		"X-Amz-Meta-Two": "src",
+	ts.backendPutString(defaultBucket, "object", nil, "hello")
+
+	tagSet := func(n int, keyLen int) []*s3.Tag {
+		var tags []*s3.Tag
+		for i := 0; i < n; i++ {
+			key := fmt.Sprintf("%0*d", keyLen, i)
+			tags = append(tags, &s3.Tag{Key: aws.String(key), Value: aws.String("v")})
+		}
+		return tags
 	}
-	ts.backendPutString(defaultBucket, "src-key", srcMeta, "content")
 
-	out, err := svc.CopyObject(&s3.CopyObjectInput{
-		Bucket:     aws.String(defaultBucket),
-		Key:        aws.String("dst-key"),
-		CopySource: aws.String("/" + defaultBucket + "/src-key"),
-		Metadata: map[string]*string{
-			"Two":   aws.String("dst"),
-			"Three": aws.String("dst"),
-		},
+	_, err := svc.PutObjectTagging(&s3.PutObjectTaggingInput{
+		Bucket:  aws.String(defaultBucket),
+		Key:     aws.String("object"),
+		Tagging: &s3.Tagging{TagSet: tagSet(2, 4)},
 	})
 	ts.OK(err)
 
-	if *out.CopyObjectResult.ETag != `"9a0364b9e99bb480dd25e1f0284c8555"` { // md5("content")
-		ts.Fatal("bad etag", *out.CopyObjectResult.ETag)
+	head, err := svc.HeadObject(&s3.HeadObjectInput{
+		Bucket: aws.String(defaultBucket),
+		Key:    aws.String("object"),
+	})
+	ts.OK(err)
+	if v := head.Metadata["X-Amz-Tagging-Count"]; v != nil {
+		ts.Fatal("tagging count leaked into metadata:", *v)
 	}
 
-	obj, err := ts.backend.GetObject(defaultBucket, "dst-key", nil)
+	get, err := svc.GetObject(&s3.GetObjectInput{
+		Bucket: aws.String(defaultBucket),
+		Key:    aws.String("object"),
+	})
 	ts.OK(err)
+	defer get.Body.Close()
+	if get.TagCount == nil || *get.TagCount != 2 {
+		ts.Fatal("unexpected tag count:", get.TagCount)
+	}
 
-	defer obj.Contents.Close()
-	data, err := ioutil.ReadAll(obj.Contents)
-	ts.OK(err)
-
-	if string(data) != "content" {
-		t.Fatal("object copying failed")
+	for idx, tc := range []struct {
+		tags []*s3.Tag
+	}{
+		{tags: tagSet(gofakes3.MaxObjectTags+1, 4)},
+		{tags: tagSet(1, gofakes3.MaxObjectTagKeyLen+1)},
+		{tags: []*s3.Tag{{Key: aws.String("k"), Value: aws.String(strings.Repeat("v", gofakes3.MaxObjectTagValueLen+1))}}},
+	} {
+		t.Run(fmt.Sprintf("invalid/%d", idx), func(t *testing.T) {
+			_, err := svc.PutObjectTagging(&s3.PutObjectTaggingInput{
+				Bucket:  aws.String(defaultBucket),
+				Key:     aws.String("object"),
+				Tagging: &s3.Tagging{TagSet: tc.tags},
+			})
+			if !s3HasErrorCode(err, gofakes3.ErrInvalidTag) {
+				ts.Fatal("expected InvalidTag, found", err)
+			}
+		})
 	}
 
-	if v := obj.Metadata["X-Amz-Meta-One"]; v != "src" {
-		t.Fatalf("bad Content-Type: %q", v)
-	}
+	_, err = svc.DeleteObjectTagging(&s3.DeleteObjectTaggingInput{
+		Bucket: aws.String(defaultBucket),
+		Key:    aws.String("object"),
+	})
+	ts.OK(err)
 
-	if v := obj.Metadata["X-Amz-Meta-Two"]; v != "dst" {
-		t.Fatalf("bad Content-Encoding: %q", v)
+	result, err := svc.GetObjectTagging(&s3.GetObjectTaggingInput{
+		Bucket: aws.String(defaultBucket),
+		Key:    aws.String("object"),
+	})
+	ts.OK(err)
+	if len(result.TagSet) != 0 {
+		ts.Fatal("expected no tags, found", result.TagSet)
 	}
 
-	if v := obj.Metadata["X-Amz-Meta-Three"]; v != "dst" {
-		t.Fatalf("bad Content-Encoding: %q", v)
+	_, err = svc.GetObjectTagging(&s3.GetObjectTaggingInput{
+		Bucket: aws.String(defaultBucket),
+		Key:    aws.String("missing"),
+	})
+	if !s3HasErrorCode(err, gofakes3.ErrNoSuchKey) {
+		ts.Fatal("expected NoSuchKey, found", err)
 	}
 }
 
-func TestCopyObjectWithSpecialChars(t *testing.T) {
-	ts := newTestServer(t)
+func TestObjectTaggingVersions(t *testing.T) {
+	ts := newTestServer(t, withVersioning())
 	defer ts.Close()
 	svc := ts.s3Client()
 
-	srcMeta := map[string]string{
-		"Content-Type": "text/plain",
+	tagSet := []*s3.Tag{
+		{Key: aws.String("a"), Value: aws.String("1")},
+		{Key: aws.String("b"), Value: aws.String("2")},
 	}
-	srcKey := "src+key,with special;chars!?="
-	content := "contents"
-	ts.backendPutString(defaultBucket, srcKey, srcMeta, content)
-	copySource := "/" + defaultBucket + "/" + url.QueryEscape(srcKey)
-	_, err := svc.CopyObject(&s3.CopyObjectInput{
-		Bucket:     aws.String(defaultBucket),
-		Key:        aws.String("dst-key"),
-		CopySource: aws.String(copySource),
+	var versions []*string
+	for idx := range tagSet {
+		out, err := svc.PutObject(&s3.PutObjectInput{
+			Bucket: aws.String(defaultBucket),
+			Key:    aws.String("object"),
+			Body:   bytes.NewReader([]byte("hello")),
+		})
+		ts.OK(err)
+		ts.OKAll(svc.PutObjectTagging(&s3.PutObjectTaggingInput{
+			Bucket:    aws.String(defaultBucket),
+			Key:       aws.String("object"),
+			VersionId: out.VersionId,
+			Tagging:   &s3.Tagging{TagSet: tagSet[:idx+1]},
+		}))
+		versions = append(versions, out.VersionId)
+	}
+	deleted, err := svc.DeleteObject(&s3.DeleteObjectInput{
+		Bucket: aws.String(defaultBucket),
+		Key:    aws.String("object"),
 	})
 	ts.OK(err)
 
-	obj, err := svc.GetObject(&s3.GetObjectInput{
-		Bucket: aws.String(defaultBucket),
-		Key:    aws.String(srcKey),
-	})
-	if err != nil {
-		t.Fatalf("object not found with key %v", srcKey)
+	for idx, version := range versions {
+		get, err := svc.GetObject(&s3.GetObjectInput{
+			Bucket:    aws.String(defaultBucket),
+			Key:       aws.String("object"),
+			VersionId: version,
+		})
+		ts.OK(err)
+		get.Body.Close()
+		if get.TagCount == nil || *get.TagCount != int64(idx+1) {
+			ts.Fatal("unexpected tag count for version", idx, get.TagCount)
+		}
+
+		result, err := svc.GetObjectTagging(&s3.GetObjectTaggingInput{
+			Bucket:    aws.String(defaultBucket),
+			Key:       aws.String("object"),
+			VersionId: version,
+		})
+		ts.OK(err)
+		if len(result.TagSet) != idx+1 {
+			ts.Fatal("unexpected tags for version", idx, result.TagSet)
+		}
+		if aws.StringValue(result.VersionId) != aws.StringValue(version) {
+			ts.Fatal("unexpected version", result.VersionId, "expected", *version)
+		}
 	}
-	objContent, err := ioutil.ReadAll(obj.Body)
+
+	_, err = svc.DeleteObjectTagging(&s3.DeleteObjectTaggingInput{
+		Bucket:    aws.String(defaultBucket),
+		Key:       aws.String("object"),
+		VersionId: versions[0],
+	})
 	ts.OK(err)
-	if !bytes.Equal([]byte(content), objContent) {
-		ts.Fatalf("object contents are different %v!=%v", content, objContent)
+	for idx, expected := range []int{0, 2} {
+		result, err := svc.GetObjectTagging(&s3.GetObjectTaggingInput{
+			Bucket:    aws.String(defaultBucket),
+			Key:       aws.String("object"),
+			VersionId: versions[idx],
+		})
+		ts.OK(err)
+		if len(result.TagSet) != expected {
+			ts.Fatal("unexpected tags for version", idx, result.TagSet)
+		}
+	}
+
+	_, err = svc.GetObjectTagging(&s3.GetObjectTaggingInput{
+		Bucket:    aws.String(defaultBucket),
+		Key:       aws.String("object"),
+		VersionId: deleted.VersionId,
+	})
+	if !s3HasErrorCode(err, gofakes3.ErrMethodNotAllowed) {
+		ts.Fatal("expected MethodNotAllowed, found", err)
+	}
+
+	_, err = svc.GetObjectTagging(&s3.GetObjectTaggingInput{
+		Bucket:    aws.String(defaultBucket),
+		Key:       aws.String("object"),
+		VersionId: aws.String("missing"),
+	})
+	if !s3HasErrorCode(err, gofakes3.ErrNoSuchVersion) {
+		ts.Fatal("expected NoSuchVersion, found", err)
 	}
 }
 
-func TestCopyObjectWithSpecialCharsEscapedInvalied(t *testing.T) {
+func TestBucketTagging(t *testing.T) {
 	ts := newTestServer(t)
 	defer ts.Close()
 	svc := ts.s3Client()
 
-	srcMeta := map[string]string{
-		"Content-Type": "text/plain",
-	}
-	srcKey := "src+key" //encoded srcKey = src%2Bkey
-	content := "contents"
-	ts.backendPutString(defaultBucket, srcKey, srcMeta, content)
-	copySource := "/" + defaultBucket + "/src%2key" //invalid encoding
-	_, err := svc.CopyObject(&s3.CopyObjectInput{
-		Bucket:     aws.String(defaultBucket),
-		Key:        aws.String("dst-key"),
-		CopySource: aws.String(copySource),
-	})
-	if err == nil {
-		t.Fatalf("copy object should return error when copy source encoding is invaid")
+	getTags := func() ([]*s3.Tag, error) {
+		out, err := svc.GetBucketTagging(&s3.GetBucketTaggingInput{Bucket: aws.String(defaultBucket)})
+		if err != nil {
+			return nil, err
+		}
+		return out.TagSet, nil
 	}
-}
 
-func TestDeleteBucket(t *testing.T) {
-	t.Run("delete-empty", func(t *testing.T) {
-		ts := newTestServer(t, withoutInitialBuckets())
-		defer ts.Close()
-		svc := ts.s3Client()
+	if _, err := getTags(); !s3HasErrorCode(err, gofakes3.ErrNoSuchTagSet) {
+		t.Fatal("expected NoSuchTagSet, found", err)
+	}
 
-		ts.backendCreateBucket("test")
-		ts.OKAll(svc.DeleteBucket(&s3.DeleteBucketInput{
-			Bucket: aws.String("test"),
-		}))
+	tags := []*s3.Tag{
+		{Key: aws.String("cost-centre"), Value: aws.String("1234")},
+		{Key: aws.String("team"), Value: aws.String("storage")},
+	}
+	_, err := svc.PutBucketTagging(&s3.PutBucketTaggingInput{
+		Bucket:  aws.String(defaultBucket),
+		Tagging: &s3.Tagging{TagSet: tags},
 	})
+	ts.OK(err)
 
-	t.Run("delete-fails-if-not-empty", func(t *testing.T) {
-		ts := newTestServer(t, withoutInitialBuckets())
-		defer ts.Close()
-		svc := ts.s3Client()
+	found, err := getTags()
+	ts.OK(err)
+	if !reflect.DeepEqual(found, tags) {
+		t.Fatal("unexpected tags", found)
+	}
 
-		ts.backendCreateBucket("test")
-		ts.backendPutString("test", "test", nil, "test")
-		_, err := svc.DeleteBucket(&s3.DeleteBucketInput{
-			Bucket: aws.String("test"),
-		})
-		if !hasErrorCode(err, gofakes3.ErrBucketNotEmpty) {
-			t.Fatal("expected ErrBucketNotEmpty, found", err)
-		}
+	var tooMany []*s3.Tag
+	for i := 0; i <= gofakes3.MaxBucketTags; i++ {
+		tooMany = append(tooMany, &s3.Tag{Key: aws.String(fmt.Sprint(i)), Value: aws.String("v")})
+	}
+	_, err = svc.PutBucketTagging(&s3.PutBucketTaggingInput{
+		Bucket:  aws.String(defaultBucket),
+		Tagging: &s3.Tagging{TagSet: tooMany},
+	})
+	if !s3HasErrorCode(err, gofakes3.ErrInvalidTag) {
+		t.Fatal("expected InvalidTag, found", err)
+	}
+	_, err = svc.PutBucketTagging(&s3.PutBucketTaggingInput{
+		Bucket:  aws.String(defaultBucket),
+		Tagging: &s3.Tagging{TagSet: tooMany[:gofakes3.MaxBucketTags]},
 	})
+	ts.OK(err)
+
+	_, err = svc.DeleteBucketTagging(&s3.DeleteBucketTaggingInput{Bucket: aws.String(defaultBucket)})
+	ts.OK(err)
+	if _, err := getTags(); !s3HasErrorCode(err, gofakes3.ErrNoSuchTagSet) {
+		t.Fatal("expected NoSuchTagSet after delete, found", err)
+	}
+
+	// The tags are discarded with the bucket:
+	ts.OKAll(svc.PutBucketTagging(&s3.PutBucketTaggingInput{
+		Bucket:  aws.String(defaultBucket),
+		Tagging: &s3.Tagging{TagSet: tags},
+	}))
+	ts.OKAll(svc.DeleteBucket(&s3.DeleteBucketInput{Bucket: aws.String(defaultBucket)}))
+	ts.backendCreateBucket(defaultBucket)
+	if _, err := getTags(); !s3HasErrorCode(err, gofakes3.ErrNoSuchTagSet) {
+		t.Fatal("expected NoSuchTagSet after recreating the bucket, found", err)
+	}
+
+	_, err = svc.GetBucketTagging(&s3.GetBucketTaggingInput{Bucket: aws.String("missing")})
+	if !s3HasErrorCode(err, gofakes3.ErrNoSuchBucket) {
+		t.Fatal("expected NoSuchBucket, found", err)
+	}
 }
 
-func TestDeleteMulti(t *testing.T) {
-	deletedKeys := func(rs *s3.DeleteObjectsOutput) []string {
-		deleted := make([]string, len(rs.Deleted))
-		for idx, del := range rs.Deleted {
-			deleted[idx] = *del.Key
-		}
-		sort.Strings(deleted)
-		return deleted
+func TestObjectACL(t *testing.T) {
+	ts := newTestServer(t, withFakerOptions(gofakes3.WithOwner("owner-id", "owner")))
+	defer ts.Close()
+	svc := ts.s3Client()
+
+	ownerGrant := &s3.Grant{
+		Grantee:    &s3.Grantee{Type: aws.String(gofakes3.GranteeCanonicalUser), ID: aws.String("owner-id"), DisplayName: aws.String("owner")},
+		Permission: aws.String("FULL_CONTROL"),
+	}
+	allUsersRead := &s3.Grant{
+		Grantee:    &s3.Grantee{Type: aws.String(gofakes3.GranteeGroup), URI: aws.String(gofakes3.GroupAllUsers)},
+		Permission: aws.String("READ"),
 	}
 
-	assertDeletedKeys := func(t *testing.T, rs *s3.DeleteObjectsOutput, expected ...string) {
+	getACL := func(key string) *s3.GetObjectAclOutput {
 		t.Helper()
-		found := deletedKeys(rs)
-		if !reflect.DeepEqual(found, expected) {
-			t.Fatal("multi deletion failed", found, "!=", expected)
+		out, err := svc.GetObjectAcl(&s3.GetObjectAclInput{Bucket: aws.String(defaultBucket), Key: aws.String(key)})
+		ts.OK(err)
+		if aws.StringValue(out.Owner.ID) != "owner-id" || aws.StringValue(out.Owner.DisplayName) != "owner" {
+			t.Fatal("unexpected owner", out.Owner)
+		}
+		return out
+	}
+	assertGrants := func(key string, expected ...*s3.Grant) {
+		t.Helper()
+		if found := getACL(key).Grants; !reflect.DeepEqual(found, expected) {
+			t.Fatal("unexpected grants for", key, found)
 		}
 	}
+	put := func(key, acl string) error {
+		input := &s3.PutObjectInput{Bucket: aws.String(defaultBucket), Key: aws.String(key), Body: strings.NewReader("hello")}
+		if acl != "" {
+			input.ACL = aws.String(acl)
+		}
+		_, err := svc.PutObject(input)
+		return err
+	}
 
-	t.Run("one-file", func(t *testing.T) {
-		ts := newTestServer(t)
-		defer ts.Close()
-		svc := ts.s3Client()
+	t.Run("canned", func(t *testing.T) {
+		ts.OK(put("private", ""))
+		assertGrants("private", ownerGrant)
 
-		ts.backendPutString(defaultBucket, "foo", nil, "one")
-		ts.backendPutString(defaultBucket, "bar", nil, "two")
-		ts.backendPutString(defaultBucket, "baz", nil, "three")
+		ts.OK(put("public", "public-read"))
+		assertGrants("public", ownerGrant, allUsersRead)
 
-		rs, err := svc.DeleteObjects(&s3.DeleteObjectsInput{
-			Bucket: aws.String(defaultBucket),
-			Delete: &s3.Delete{
-				Objects: []*s3.ObjectIdentifier{
-					{Key: aws.String("foo")},
-				},
-			},
+		// Replacing the object discards its ACL:
+		ts.OK(put("public", ""))
+		assertGrants("public", ownerGrant)
+
+		if err := put("invalid", "nope"); !s3HasErrorCode(err, gofakes3.ErrInvalidArgument) {
+			t.Fatal("expected InvalidArgument, found", err)
+		}
+	})
+
+	t.Run("grant-headers", func(t *testing.T) {
+		_, err := svc.PutObject(&s3.PutObjectInput{
+			Bucket:    aws.String(defaultBucket),
+			Key:       aws.String("granted"),
+			Body:      strings.NewReader("hello"),
+			GrantRead: aws.String(`uri="` + gofakes3.GroupAllUsers + `", id="other"`),
 		})
 		ts.OK(err)
-		assertDeletedKeys(t, rs, "foo")
-		ts.assertLs(defaultBucket, "", nil, []string{"bar", "baz"})
+		assertGrants("granted", allUsersRead, &s3.Grant{
+			Grantee:    &s3.Grantee{Type: aws.String(gofakes3.GranteeCanonicalUser), ID: aws.String("other")},
+			Permission: aws.String("READ"),
+		})
+
+		_, err = svc.PutObject(&s3.PutObjectInput{
+			Bucket:    aws.String(defaultBucket),
+			Key:       aws.String("granted"),
+			Body:      strings.NewReader("hello"),
+			ACL:       aws.String("private"),
+			GrantRead: aws.String(`id="other"`),
+		})
+		if !s3HasErrorCode(err, gofakes3.ErrInvalidRequest) {
+			t.Fatal("expected InvalidRequest, found", err)
+		}
 	})
 
-	t.Run("multiple-files", func(t *testing.T) {
-		ts := newTestServer(t)
-		defer ts.Close()
-		svc := ts.s3Client()
+	t.Run("put-acl", func(t *testing.T) {
+		ts.OK(put("acl", ""))
 
-		ts.backendPutString(defaultBucket, "foo", nil, "one")
-		ts.backendPutString(defaultBucket, "bar", nil, "two")
-		ts.backendPutString(defaultBucket, "baz", nil, "three")
+		_, err := svc.PutObjectAcl(&s3.PutObjectAclInput{
+			Bucket: aws.String(defaultBucket),
+			Key:    aws.String("acl"),
+			AccessControlPolicy: &s3.AccessControlPolicy{
+				Owner:  &s3.Owner{ID: aws.String("owner-id")},
+				Grants: []*s3.Grant{ownerGrant, allUsersRead},
+			},
+		})
+		ts.OK(err)
+		assertGrants("acl", ownerGrant, allUsersRead)
 
-		rs, err := svc.DeleteObjects(&s3.DeleteObjectsInput{
+		ts.OKAll(svc.PutObjectAcl(&s3.PutObjectAclInput{
 			Bucket: aws.String(defaultBucket),
-			Delete: &s3.Delete{
-				Objects: []*s3.ObjectIdentifier{
-					{Key: aws.String("bar")},
-					{Key: aws.String("foo")},
-				},
+			Key:    aws.String("acl"),
+			ACL:    aws.String("private"),
+		}))
+		assertGrants("acl", ownerGrant)
+
+		_, err = svc.PutObjectAcl(&s3.PutObjectAclInput{
+			Bucket: aws.String(defaultBucket),
+			Key:    aws.String("acl"),
+			AccessControlPolicy: &s3.AccessControlPolicy{
+				Owner: &s3.Owner{ID: aws.String("owner-id")},
+				Grants: []*s3.Grant{{
+					Grantee:    &s3.Grantee{Type: aws.String(gofakes3.GranteeGroup), URI: aws.String("http://example.com/group")},
+					Permission: aws.String("READ"),
+				}},
 			},
 		})
+		if !s3HasErrorCode(err, gofakes3.ErrInvalidArgument) {
+			t.Fatal("expected InvalidArgument, found", err)
+		}
+
+		_, err = svc.PutObjectAcl(&s3.PutObjectAclInput{
+			Bucket: aws.String(defaultBucket),
+			Key:    aws.String("missing"),
+			ACL:    aws.String("private"),
+		})
+		if !s3HasErrorCode(err, gofakes3.ErrNoSuchKey) {
+			t.Fatal("expected NoSuchKey, found", err)
+		}
+	})
+
+	t.Run("multipart", func(t *testing.T) {
+		out, err := svc.CreateMultipartUpload(&s3.CreateMultipartUploadInput{
+			Bucket: aws.String(defaultBucket),
+			Key:    aws.String("multipart"),
+			ACL:    aws.String("public-read"),
+		})
 		ts.OK(err)
-		assertDeletedKeys(t, rs, "bar", "foo")
-		ts.assertLs(defaultBucket, "", nil, []string{"baz"})
+		uploadID := aws.StringValue(out.UploadId)
+		part := ts.uploadPart(defaultBucket, "multipart", uploadID, 1, []byte("hello"))
+		ts.assertCompleteUpload(defaultBucket, "multipart", uploadID, []*s3.CompletedPart{part}, []byte("hello"))
+		assertGrants("multipart", ownerGrant, allUsersRead)
+	})
+
+	t.Run("copy", func(t *testing.T) {
+		ts.OK(put("copy-source", "public-read"))
+		ts.OKAll(svc.CopyObject(&s3.CopyObjectInput{
+			Bucket:     aws.String(defaultBucket),
+			Key:        aws.String("copy"),
+			CopySource: aws.String(defaultBucket + "/copy-source"),
+		}))
+		assertGrants("copy", ownerGrant)
 	})
 }
 
-func TestGetBucketLocation(t *testing.T) {
+func TestBucketACL(t *testing.T) {
 	ts := newTestServer(t)
 	defer ts.Close()
 	svc := ts.s3Client()
 
-	ts.backendPutString(defaultBucket, "foo", nil, "one")
+	grants := func(bucket string) []*s3.Grant {
+		t.Helper()
+		out, err := svc.GetBucketAcl(&s3.GetBucketAclInput{Bucket: aws.String(bucket)})
+		ts.OK(err)
+		if aws.StringValue(out.Owner.ID) != gofakes3.DefaultOwnerID {
+			t.Fatal("unexpected owner", out.Owner)
+		}
+		return out.Grants
+	}
 
-	out, err := svc.GetBucketLocation(&s3.GetBucketLocationInput{
-		Bucket: aws.String(defaultBucket),
-	})
-	if err != nil {
-		t.Fatal("get-bucket-location-failed", err)
+	if found := grants(defaultBucket); len(found) != 1 || aws.StringValue(found[0].Permission) != "FULL_CONTROL" {
+		t.Fatal("expected private bucket, found", found)
 	}
 
-	if out.LocationConstraint != nil {
-		t.Fatal("location-constraint-not-empty", *out.LocationConstraint)
+	// New buckets have ACLs disabled, unless they are created with
+	// ObjectWriter ownership:
+	_, err := svc.CreateBucket(&s3.CreateBucketInput{Bucket: aws.String("public"), ACL: aws.String("public-read-write")})
+	if !s3HasErrorCode(err, gofakes3.ErrInvalidBucketAclWithObjectOwnership) {
+		t.Fatal("expected InvalidBucketAclWithObjectOwnership, found", err)
+	}
+	rq, _ := svc.CreateBucketRequest(&s3.CreateBucketInput{Bucket: aws.String("public"), ACL: aws.String("public-read-write")})
+	rq.HTTPRequest.Header.Set("x-amz-object-ownership", string(gofakes3.ObjectOwnershipObjectWriter))
+	ts.OK(rq.Send())
+	found := grants("public")
+	if len(found) != 3 || aws.StringValue(found[1].Grantee.URI) != gofakes3.GroupAllUsers || aws.StringValue(found[2].Permission) != "WRITE" {
+		t.Fatal("unexpected grants", found)
 	}
-}
 
-func TestGetObjectRange(t *testing.T) {
-	assertRange := func(ts *testServer, key string, hdr string, expected []byte, fail bool) {
-		ts.Helper()
-		svc := ts.s3Client()
-		obj, err := svc.GetObject(&s3.GetObjectInput{
-			Bucket: aws.String(defaultBucket),
-			Key:    aws.String(key),
-			Range:  aws.String(hdr),
-		})
-		if fail != (err != nil) {
-			ts.Fatal("failure expected:", fail, "found:", err)
-		}
-		if !fail {
-			ts.OK(err)
-			defer obj.Body.Close()
+	ts.OKAll(svc.PutBucketAcl(&s3.PutBucketAclInput{Bucket: aws.String("public"), ACL: aws.String("private")}))
+	if found := grants("public"); len(found) != 1 {
+		t.Fatal("expected private bucket, found", found)
+	}
 
-			out, err := ioutil.ReadAll(obj.Body)
-			ts.OK(err)
-			if !bytes.Equal(expected, out) {
-				ts.Fatal("range failed", hdr, err)
-			}
-		}
+	_, err = svc.GetBucketAcl(&s3.GetBucketAclInput{Bucket: aws.String("missing")})
+	if !s3HasErrorCode(err, gofakes3.ErrNoSuchBucket) {
+		t.Fatal("expected NoSuchBucket, found", err)
 	}
+}
 
-	in := randomFileBody(1024)
+func TestOwnershipControls(t *testing.T) {
+	ts := newTestServer(t)
+	defer ts.Close()
+	svc := ts.s3Client()
 
-	for idx, tc := range []struct {
-		hdr      string
-		expected []byte
-		fail     bool
-	}{
-		{"bytes=0-", in, false},
-		{"bytes=1-", in[1:], false},
-		{"bytes=0-0", in[:1], false},
-		{"bytes=0-1", in[:2], false},
-		{"bytes=1023-1023", in[1023:1024], false},
-
-		// if the requested end is beyond the real end, returns "remainder of the representation"
-		{"bytes=1023-1025", in[1023:1024], false},
-
-		// if the requested start is beyond the real end, it should fail
-		{"bytes=1024-1024", []byte{}, true},
+	do := func(method, path, body string, hdr http.Header) (int, string) {
+		t.Helper()
+		rq, err := http.NewRequest(method, ts.url(path), strings.NewReader(body))
+		ts.OK(err)
+		for k, v := range hdr {
+			rq.Header[k] = v
+		}
+		rs, err := httpClient().Do(rq)
+		ts.OK(err)
+		defer rs.Body.Close()
+		out, err := ioutil.ReadAll(rs.Body)
+		ts.OK(err)
+		return rs.StatusCode, string(out)
+	}
+	ownership := func(bucket string) gofakes3.ObjectOwnership {
+		t.Helper()
+		status, body := do("GET", bucket+"?ownershipControls", "", nil)
+		if status == http.StatusNotFound && strings.Contains(body, "<Code>OwnershipControlsNotFoundError</Code>") {
+			return ""
+		} else if status != http.StatusOK {
+			t.Fatal("unexpected response", status, body)
+		}
+		var controls gofakes3.OwnershipControls
+		ts.OK(xml.Unmarshal([]byte(body), &controls))
+		return controls.Rules[0].ObjectOwnership
+	}
+	putOwnership := func(bucket string, ownership gofakes3.ObjectOwnership) {
+		t.Helper()
+		body := "<OwnershipControls><Rule><ObjectOwnership>" + string(ownership) + "</ObjectOwnership></Rule></OwnershipControls>"
+		if status, out := do("PUT", bucket+"?ownershipControls", body, nil); status != http.StatusOK {
+			t.Fatal("unexpected response", status, out)
+		}
+	}
+	putObjectACL := func(key string, acl string) error {
+		_, err := svc.PutObjectAcl(&s3.PutObjectAclInput{Bucket: aws.String(defaultBucket), Key: aws.String(key), ACL: aws.String(acl)})
+		return err
+	}
 
-		// suffix-byte-range-spec:
-		{"bytes=-0", []byte{}, true},
-		{"bytes=-1", in[1023:1024], false},
-		{"bytes=-1024", in, false},
-		{"bytes=-1025", in, true},
-	} {
-		t.Run(fmt.Sprintf("%d/%s", idx, tc.hdr), func(t *testing.T) {
-			ts := newTestServer(t)
-			defer ts.Close()
+	// Buckets created with the Backend have no ownership controls, so they
+	// accept ACLs:
+	ts.backendPutString(defaultBucket, "object", nil, "hello")
+	if found := ownership(defaultBucket); found != "" {
+		t.Fatal("unexpected ownership", found)
+	}
+	ts.OK(putObjectACL("object", "public-read"))
 
-			ts.backendPutBytes(defaultBucket, "foo", nil, in)
-			assertRange(ts, "foo", tc.hdr, tc.expected, tc.fail)
-		})
+	putOwnership(defaultBucket, gofakes3.ObjectOwnershipBucketOwnerEnforced)
+	if found := ownership(defaultBucket); found != gofakes3.ObjectOwnershipBucketOwnerEnforced {
+		t.Fatal("unexpected ownership", found)
 	}
-}
 
-func TestGetObjectRangeInvalid(t *testing.T) {
-	assertRangeInvalid := func(ts *testServer, key string, hdr string) {
-		svc := ts.s3Client()
-		_, err := svc.GetObject(&s3.GetObjectInput{
-			Bucket: aws.String(defaultBucket),
-			Key:    aws.String(key),
-			Range:  aws.String(hdr),
+	t.Run("enforced", func(t *testing.T) {
+		if err := putObjectACL("object", "public-read"); !s3HasErrorCode(err, gofakes3.ErrAccessControlListNotSupported) {
+			t.Fatal("expected AccessControlListNotSupported, found", err)
+		}
+		_, err := svc.PutBucketAcl(&s3.PutBucketAclInput{Bucket: aws.String(defaultBucket), ACL: aws.String("authenticated-read")})
+		if !s3HasErrorCode(err, gofakes3.ErrAccessControlListNotSupported) {
+			t.Fatal("expected AccessControlListNotSupported, found", err)
+		}
+		_, err = svc.PutObject(&s3.PutObjectInput{
+			Bucket: aws.String(defaultBucket), Key: aws.String("public"), Body: strings.NewReader("hello"), ACL: aws.String("public-read"),
 		})
-		if !hasErrorCode(err, gofakes3.ErrInvalidRange) {
-			ts.Fatal("expected ErrInvalidRange, found", err)
+		if !s3HasErrorCode(err, gofakes3.ErrAccessControlListNotSupported) {
+			t.Fatal("expected AccessControlListNotSupported, found", err)
 		}
-	}
 
-	in := randomFileBody(1024)
+		// ACLs that only grant the owner full control are still accepted:
+		ts.OKAll(svc.PutObject(&s3.PutObjectInput{
+			Bucket: aws.String(defaultBucket), Key: aws.String("owned"), Body: strings.NewReader("hello"), ACL: aws.String("bucket-owner-full-control"),
+		}))
+		ts.OK(putObjectACL("owned", "private"))
 
-	for idx, tc := range []struct {
-		hdr string
-	}{
-		{"boats=0-0"},
-		{"bytes="},
-	} {
-		t.Run(fmt.Sprintf("%d/%s", idx, tc.hdr), func(t *testing.T) {
-			ts := newTestServer(t)
-			defer ts.Close()
+		// The public-read ACL set before is ignored:
+		out, err := svc.GetObjectAcl(&s3.GetObjectAclInput{Bucket: aws.String(defaultBucket), Key: aws.String("object")})
+		ts.OK(err)
+		if len(out.Grants) != 1 || aws.StringValue(out.Grants[0].Permission) != "FULL_CONTROL" {
+			t.Fatal("expected the ACL to be ignored, found", out.Grants)
+		}
+	})
 
-			ts.backendPutBytes(defaultBucket, "foo", nil, in)
-			assertRangeInvalid(ts, "foo", tc.hdr)
-		})
+	if status, out := do("PUT", defaultBucket+"?ownershipControls", "<OwnershipControls><Rule><ObjectOwnership>Nobody</ObjectOwnership></Rule></OwnershipControls>", nil); status != http.StatusBadRequest ||
+		!strings.Contains(out, "<Code>MalformedXML</Code>") {
+		t.Fatal("expected MalformedXML, found", status, out)
 	}
-}
 
-func TestGetObjectIfNoneMatch(t *testing.T) {
-	objectKey := "foo"
-	assertModified := func(ts *testServer, ifNoneMatch string, shouldModify bool) {
-		svc := ts.s3Client()
-		input := s3.GetObjectInput{
-			Bucket: aws.String(defaultBucket),
-			Key:    aws.String(objectKey),
-		}
-		if ifNoneMatch != "" {
-			input.IfNoneMatch = aws.String(ifNoneMatch)
-		}
+	putOwnership(defaultBucket, gofakes3.ObjectOwnershipObjectWriter)
+	ts.OK(putObjectACL("object", "public-read"))
+	if status, out := do("DELETE", defaultBucket+"?ownershipControls", "", nil); status != http.StatusNoContent {
+		t.Fatal("unexpected response", status, out)
+	}
+	if found := ownership(defaultBucket); found != "" {
+		t.Fatal("unexpected ownership after delete", found)
+	}
 
-		_, err := svc.GetObject(&input)
-		modified := true
-		if err != nil {
-			if !s3HasErrorCode(err, gofakes3.ErrNotModified) {
-				ts.Fatal("get-object-failed", err)
-			}
+	t.Run("create", func(t *testing.T) {
+		ts.OKAll(svc.CreateBucket(&s3.CreateBucketInput{Bucket: aws.String("enforced")}))
+		if found := ownership("enforced"); found != gofakes3.ObjectOwnershipBucketOwnerEnforced {
+			t.Fatal("unexpected default ownership", found)
+		}
 
-			modified = false
+		hdr := http.Header{"X-Amz-Object-Ownership": {string(gofakes3.ObjectOwnershipBucketOwnerPreferred)}}
+		if status, out := do("PUT", "preferred", "", hdr); status != http.StatusOK {
+			t.Fatal("unexpected response", status, out)
+		}
+		if found := ownership("preferred"); found != gofakes3.ObjectOwnershipBucketOwnerPreferred {
+			t.Fatal("unexpected ownership", found)
 		}
 
-		if modified != shouldModify {
-			ts.Fatal("expected modified", shouldModify, "found", modified)
+		hdr = http.Header{"X-Amz-Object-Ownership": {"Nobody"}}
+		if status, out := do("PUT", "invalid", "", hdr); status != http.StatusBadRequest || !strings.Contains(out, "<Code>InvalidArgument</Code>") {
+			t.Fatal("expected InvalidArgument, found", status, out)
 		}
-	}
+	})
 
-	for idx, tc := range []struct {
-		ifNoneMatch  string
-		shouldModify bool
-	}{
-		{shouldModify: true},
-		{ifNoneMatch: `"5d41402abc4b2a76b9719d911017c592"`, shouldModify: false}, // md5("hello")
-		{ifNoneMatch: `"notTheSameEtag"`, shouldModify: true},
-	} {
-		t.Run(fmt.Sprintf("%d/%s", idx, tc.ifNoneMatch), func(t *testing.T) {
-			ts := newTestServer(t)
-			defer ts.Close()
+	t.Run("option", func(t *testing.T) {
+		ts := newTestServer(t, withFakerOptions(gofakes3.WithObjectOwnership(gofakes3.ObjectOwnershipObjectWriter)))
+		defer ts.Close()
+		svc := ts.s3Client()
+		ts.OKAll(svc.CreateBucket(&s3.CreateBucketInput{Bucket: aws.String("public"), ACL: aws.String("public-read")}))
+	})
+}
 
-			ts.backendPutString(defaultBucket, objectKey, nil, "hello")
+func TestObjectLockRetention(t *testing.T) {
+	ts := newTestServer(t)
+	defer ts.Close()
+	svc := ts.s3Client()
 
-			assertModified(ts, tc.ifNoneMatch, tc.shouldModify)
+	until := defaultDate.Add(time.Hour)
+
+	putRetention := func(key, mode string, until time.Time, bypass bool) error {
+		_, err := svc.PutObjectRetention(&s3.PutObjectRetentionInput{
+			Bucket:                    aws.String(defaultBucket),
+			Key:                       aws.String(key),
+			BypassGovernanceRetention: aws.Bool(bypass),
+			Retention: &s3.ObjectLockRetention{
+				Mode:            aws.String(mode),
+				RetainUntilDate: aws.Time(until),
+			},
 		})
+		return err
+	}
+	deleteObject := func(key string, bypass bool) error {
+		_, err := svc.DeleteObject(&s3.DeleteObjectInput{
+			Bucket:                    aws.String(defaultBucket),
+			Key:                       aws.String(key),
+			BypassGovernanceRetention: aws.Bool(bypass),
+		})
+		return err
 	}
-}
 
-func TestCreateObjectBrowserUpload(t *testing.T) {
-	addFile := func(tt gofakes3.TT, w *multipart.Writer, object string, b []byte) {
-		tt.Helper()
-		tt.OK(w.WriteField("key", object))
+	ts.backendPutString(defaultBucket, "governance", nil, "hello")
+	ts.backendPutString(defaultBucket, "compliance", nil, "hello")
+	ts.backendPutString(defaultBucket, "unlocked", nil, "hello")
 
-		mw, err := w.CreateFormFile("file", "upload")
-		tt.OK(err)
-		n, err := mw.Write(b)
-		if n != len(b) {
-			tt.Fatal("len mismatch", n, "!=", len(b))
-		}
-		tt.OK(err)
+	ts.OK(putRetention("governance", "GOVERNANCE", until, false))
+	ts.OK(putRetention("compliance", "COMPLIANCE", until, false))
+
+	out, err := svc.GetObjectRetention(&s3.GetObjectRetentionInput{
+		Bucket: aws.String(defaultBucket),
+		Key:    aws.String("governance"),
+	})
+	ts.OK(err)
+	if v := aws.StringValue(out.Retention.Mode); v != "GOVERNANCE" {
+		t.Fatal("unexpected mode", v)
+	}
+	if v := aws.TimeValue(out.Retention.RetainUntilDate); !v.Equal(until) {
+		t.Fatal("unexpected retain until date", v)
 	}
 
-	upload := func(ts *testServer, bucket string, w *multipart.Writer, body io.Reader) (*http.Response, error) {
-		w.Close()
-		req, err := http.NewRequest("POST", ts.url("/"+bucket), body)
-		ts.OK(err)
-		req.Header.Set("Content-Type", w.FormDataContentType())
-		return httpClient().Do(req)
+	head, err := svc.HeadObject(&s3.HeadObjectInput{
+		Bucket: aws.String(defaultBucket),
+		Key:    aws.String("compliance"),
+	})
+	ts.OK(err)
+	if v := aws.StringValue(head.ObjectLockMode); v != "COMPLIANCE" {
+		t.Fatal("unexpected mode", v)
+	}
+	if v := aws.TimeValue(head.ObjectLockRetainUntilDate); !v.Equal(until) {
+		t.Fatal("unexpected retain until date", v)
 	}
 
-	assertUpload := func(ts *testServer, bucket string, w *multipart.Writer, body io.Reader, etag string) {
-		res, err := upload(ts, bucket, w, body)
-		ts.OK(err)
-		if res.StatusCode != http.StatusOK {
-			ts.Fatal("bad status", res.StatusCode, tryDumpResponse(res, true))
-		}
-		if etag != "" && res.Header.Get("ETag") != etag {
-			ts.Fatal("bad etag", res.Header.Get("ETag"), etag)
-		}
+	_, err = svc.GetObjectRetention(&s3.GetObjectRetentionInput{
+		Bucket: aws.String(defaultBucket),
+		Key:    aws.String("unlocked"),
+	})
+	if !s3HasErrorCode(err, gofakes3.ErrNoSuchObjectLockConfiguration) {
+		t.Fatal("expected NoSuchObjectLockConfiguration, found", err)
 	}
 
-	assertUploadFails := func(ts *testServer, bucket string, w *multipart.Writer, body io.Reader, expectedCode gofakes3.ErrorCode) {
-		res, err := upload(ts, bucket, w, body)
-		ts.OK(err)
-		if res.StatusCode != expectedCode.Status() {
-			ts.Fatal("bad status", res.StatusCode, "!=", expectedCode.Status())
-		}
-		defer res.Body.Close()
-		var errResp gofakes3.ErrorResponse
-		dec := xml.NewDecoder(res.Body)
-		ts.OK(dec.Decode(&errResp))
+	// Retention can only be shortened in GOVERNANCE mode with a bypass:
+	if err := putRetention("compliance", "COMPLIANCE", until.Add(-time.Minute), true); !s3HasErrorCode(err, gofakes3.ErrAccessDenied) {
+		t.Fatal("expected AccessDenied, found", err)
+	}
+	if err := putRetention("governance", "GOVERNANCE", until.Add(-time.Minute), false); !s3HasErrorCode(err, gofakes3.ErrAccessDenied) {
+		t.Fatal("expected AccessDenied, found", err)
+	}
+	ts.OK(putRetention("governance", "GOVERNANCE", until.Add(-time.Minute), true))
 
-		if errResp.Code != expectedCode {
-			ts.Fatal("bad code", errResp.Code, "!=", expectedCode)
-		}
+	if err := deleteObject("governance", false); !s3HasErrorCode(err, gofakes3.ErrAccessDenied) {
+		t.Fatal("expected AccessDenied, found", err)
 	}
+	ts.OK(deleteObject("governance", true))
 
-	t.Run("single-upload", func(t *testing.T) {
-		ts := newTestServer(t)
-		defer ts.Close()
-		var b bytes.Buffer
-		w := multipart.NewWriter(&b)
-		addFile(ts.TT, w, "yep", []byte("stuff"))
-		assertUpload(ts, defaultBucket, w, &b, `"c13d88cb4cb02003daedb8a84e5d272a"`)
-		ts.assertObject(defaultBucket, "yep", nil, "stuff")
-	})
+	if err := deleteObject("compliance", true); !s3HasErrorCode(err, gofakes3.ErrAccessDenied) {
+		t.Fatal("expected AccessDenied, found", err)
+	}
 
-	t.Run("multiple-files-fails", func(t *testing.T) {
-		ts := newTestServer(t)
-		defer ts.Close()
-		var b bytes.Buffer
-		w := multipart.NewWriter(&b)
-		addFile(ts.TT, w, "yep", []byte("stuff"))
-		addFile(ts.TT, w, "nup", []byte("bork"))
-		assertUploadFails(ts, defaultBucket, w, &b, gofakes3.ErrIncorrectNumberOfFilesInPostRequest)
-	})
+	ts.Advance(2 * time.Hour)
+	ts.OK(deleteObject("compliance", false))
+}
 
-	t.Run("key-too-large", func(t *testing.T) {
-		ts := newTestServer(t)
-		defer ts.Close()
-		var b bytes.Buffer
-		w := multipart.NewWriter(&b)
-		addFile(ts.TT, w, strings.Repeat("a", gofakes3.KeySizeLimit+1), []byte("yep"))
-		assertUploadFails(ts, defaultBucket, w, &b, gofakes3.ErrKeyTooLong)
+func TestObjectLockLegalHold(t *testing.T) {
+	ts := newTestServer(t, withVersioning())
+	defer ts.Close()
+	svc := ts.s3Client()
+
+	put, err := svc.PutObject(&s3.PutObjectInput{
+		Bucket: aws.String(defaultBucket),
+		Key:    aws.String("object"),
+		Body:   strings.NewReader("hello"),
 	})
-}
+	ts.OK(err)
 
-func TestVersioning(t *testing.T) {
-	assertVersioning := func(ts *testServer, mfa string, status string) {
-		ts.Helper()
-		svc := ts.s3Client()
-		bv, err := svc.GetBucketVersioning(&s3.GetBucketVersioningInput{Bucket: aws.String(defaultBucket)})
+	putLegalHold := func(status string) {
+		t.Helper()
+		_, err := svc.PutObjectLegalHold(&s3.PutObjectLegalHoldInput{
+			Bucket:    aws.String(defaultBucket),
+			Key:       aws.String("object"),
+			VersionId: put.VersionId,
+			LegalHold: &s3.ObjectLockLegalHold{Status: aws.String(status)},
+		})
 		ts.OK(err)
-		if aws.StringValue(bv.MFADelete) != mfa {
-			ts.Fatal("unexpected MFADelete")
-		}
-		if aws.StringValue(bv.Status) != status {
-			ts.Fatalf("unexpected Status %q, expected %q", aws.StringValue(bv.Status), status)
-		}
 	}
-
-	setVersioning := func(ts *testServer, status gofakes3.VersioningStatus) {
-		ts.Helper()
-		svc := ts.s3Client()
-		ts.OKAll(svc.PutBucketVersioning(&s3.PutBucketVersioningInput{
-			Bucket: aws.String(defaultBucket),
-			VersioningConfiguration: &s3.VersioningConfiguration{
-				Status: aws.String(string(status)),
-			},
-		}))
+	deleteVersion := func() error {
+		_, err := svc.DeleteObject(&s3.DeleteObjectInput{
+			Bucket:                    aws.String(defaultBucket),
+			Key:                       aws.String("object"),
+			VersionId:                 put.VersionId,
+			BypassGovernanceRetention: aws.Bool(true),
+		})
+		return err
 	}
 
-	t.Run("", func(t *testing.T) {
-		ts := newTestServer(t)
-		defer ts.Close()
+	putLegalHold("ON")
 
-		// Bucket that has never been versioned should return empty strings:
-		assertVersioning(ts, "", "")
+	out, err := svc.GetObjectLegalHold(&s3.GetObjectLegalHoldInput{
+		Bucket:    aws.String(defaultBucket),
+		Key:       aws.String("object"),
+		VersionId: put.VersionId,
 	})
+	ts.OK(err)
+	if v := aws.StringValue(out.LegalHold.Status); v != "ON" {
+		t.Fatal("unexpected legal hold status", v)
+	}
 
-	t.Run("enable", func(t *testing.T) {
-		ts := newTestServer(t)
-		defer ts.Close()
+	// A legal hold can not be bypassed:
+	if err := deleteVersion(); !s3HasErrorCode(err, gofakes3.ErrAccessDenied) {
+		t.Fatal("expected AccessDenied, found", err)
+	}
 
-		setVersioning(ts, "Enabled")
-		assertVersioning(ts, "", "Enabled")
+	// Creating a delete marker doesn't remove the locked version:
+	_, err = svc.DeleteObject(&s3.DeleteObjectInput{
+		Bucket: aws.String(defaultBucket),
+		Key:    aws.String("object"),
 	})
+	ts.OK(err)
 
-	t.Run("suspend", func(t *testing.T) {
-		ts := newTestServer(t)
-		defer ts.Close()
+	putLegalHold("OFF")
+	ts.OK(deleteVersion())
+}
 
-		setVersioning(ts, gofakes3.VersioningSuspended)
-		assertVersioning(ts, "", "")
+func TestBucketWebsite(t *testing.T) {
+	ts := newTestServer(t)
+	defer ts.Close()
+	svc := ts.s3Client()
+
+	_, err := svc.GetBucketWebsite(&s3.GetBucketWebsiteInput{Bucket: aws.String(defaultBucket)})
+	if !s3HasErrorCode(err, gofakes3.ErrNoSuchWebsiteConfiguration) {
+		t.Fatal("expected NoSuchWebsiteConfiguration, found", err)
+	}
+
+	_, err = svc.PutBucketWebsite(&s3.PutBucketWebsiteInput{
+		Bucket: aws.String(defaultBucket),
+		WebsiteConfiguration: &s3.WebsiteConfiguration{
+			IndexDocument: &s3.IndexDocument{Suffix: aws.String("index.html")},
+			ErrorDocument: &s3.ErrorDocument{Key: aws.String("error.html")},
+			RoutingRules: []*s3.RoutingRule{{
+				Condition: &s3.Condition{KeyPrefixEquals: aws.String("docs/")},
+				Redirect:  &s3.Redirect{ReplaceKeyPrefixWith: aws.String("documents/")},
+			}},
+		},
+	})
+	ts.OK(err)
+
+	out, err := svc.GetBucketWebsite(&s3.GetBucketWebsiteInput{Bucket: aws.String(defaultBucket)})
+	ts.OK(err)
+	if aws.StringValue(out.IndexDocument.Suffix) != "index.html" ||
+		aws.StringValue(out.ErrorDocument.Key) != "error.html" ||
+		len(out.RoutingRules) != 1 ||
+		aws.StringValue(out.RoutingRules[0].Redirect.ReplaceKeyPrefixWith) != "documents/" {
+		t.Fatal("unexpected website configuration", out)
+	}
+
+	_, err = svc.PutBucketWebsite(&s3.PutBucketWebsiteInput{
+		Bucket: aws.String(defaultBucket),
+		WebsiteConfiguration: &s3.WebsiteConfiguration{
+			IndexDocument: &s3.IndexDocument{Suffix: aws.String("dir/index.html")},
+		},
+	})
+	if !s3HasErrorCode(err, gofakes3.ErrInvalidArgument) {
+		t.Fatal("expected InvalidArgument, found", err)
+	}
+
+	_, err = svc.DeleteBucketWebsite(&s3.DeleteBucketWebsiteInput{Bucket: aws.String(defaultBucket)})
+	ts.OK(err)
+
+	_, err = svc.GetBucketWebsite(&s3.GetBucketWebsiteInput{Bucket: aws.String(defaultBucket)})
+	if !s3HasErrorCode(err, gofakes3.ErrNoSuchWebsiteConfiguration) {
+		t.Fatal("expected NoSuchWebsiteConfiguration, found", err)
+	}
+}
+
+func TestBucketWebsiteEndpoint(t *testing.T) {
+	ts := newTestServer(t, withFakerOptions(gofakes3.WithHostBucket(true)))
+	defer ts.Close()
+
+	html := map[string]string{"Content-Type": "text/html"}
+	ts.backendPutString(defaultBucket, "index.html", html, "home")
+	ts.backendPutString(defaultBucket, "path/index.html", html, "path home")
+	ts.backendPutString(defaultBucket, "error.html", html, "not found")
+
+	get := func(path string) (status int, body string) {
+		t.Helper()
+		rq, err := http.NewRequest("GET", ts.url(path), nil)
+		ts.OK(err)
+		rq.Host = defaultBucket + ".s3-website.localhost"
+		rs, err := httpClient().Do(rq)
+		ts.OK(err)
+		defer rs.Body.Close()
+		b, err := ioutil.ReadAll(rs.Body)
+		ts.OK(err)
+		return rs.StatusCode, string(b)
+	}
+
+	if status, _ := get("/index.html"); status != 404 {
+		t.Fatal("expected 404 without a website configuration, found", status)
+	}
+
+	ts.OK(ts.backend.(gofakes3.WebsiteBackend).SetBucketWebsite(defaultBucket, gofakes3.WebsiteConfiguration{
+		IndexDocument: &gofakes3.WebsiteIndexDocument{Suffix: "index.html"},
+		ErrorDocument: &gofakes3.WebsiteErrorDocument{Key: "error.html"},
+	}))
+
+	for _, tc := range []struct {
+		path   string
+		status int
+		body   string
+	}{
+		{"/", 200, "home"},
+		{"/path/", 200, "path home"},
+		{"/path/index.html", 200, "path home"},
+		{"/missing", 404, "not found"},
+		{"/missing/", 404, "not found"},
+	} {
+		status, body := get(tc.path)
+		if status != tc.status || body != tc.body {
+			t.Fatal("unexpected response for", tc.path, status, body)
+		}
+	}
+}
+
+func TestTLSServer(t *testing.T) {
+	backend := s3mem.New()
+	defer backend.Close()
+	if err := backend.CreateBucket(defaultBucket); err != nil {
+		t.Fatal(err)
+	}
+
+	ts := gofakes3test.NewTLSServer(backend)
+	defer ts.Close()
+	if !strings.HasPrefix(ts.URL, "https://") {
+		t.Fatal("unexpected server URL", ts.URL)
+	}
+
+	config := aws.NewConfig().
+		WithEndpoint(ts.URL).
+		WithRegion("region").
+		WithCredentials(credentials.NewStaticCredentials("dummy-access", "dummy-secret", "")).
+		WithS3ForcePathStyle(true).
+		WithHTTPClient(ts.Client())
+	svc := s3.New(session.New(), config)
+
+	_, err := svc.PutObject(&s3.PutObjectInput{
+		Bucket: aws.String(defaultBucket),
+		Key:    aws.String("object"),
+		Body:   strings.NewReader("hello"),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	out, err := svc.GetObject(&s3.GetObjectInput{Bucket: aws.String(defaultBucket), Key: aws.String("object")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer out.Body.Close()
+	if b, err := ioutil.ReadAll(out.Body); err != nil {
+		t.Fatal(err)
+	} else if string(b) != "hello" {
+		t.Fatal("unexpected body", string(b))
+	}
+
+	// The location of a completed upload uses the scheme of the request:
+	mpu, err := svc.CreateMultipartUpload(&s3.CreateMultipartUploadInput{Bucket: aws.String(defaultBucket), Key: aws.String("upload")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	part, err := svc.UploadPart(&s3.UploadPartInput{
+		Bucket:     aws.String(defaultBucket),
+		Key:        aws.String("upload"),
+		UploadId:   mpu.UploadId,
+		PartNumber: aws.Int64(1),
+		Body:       strings.NewReader("hello"),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	done, err := svc.CompleteMultipartUpload(&s3.CompleteMultipartUploadInput{
+		Bucket:   aws.String(defaultBucket),
+		Key:      aws.String("upload"),
+		UploadId: mpu.UploadId,
+		MultipartUpload: &s3.CompletedMultipartUpload{
+			Parts: []*s3.CompletedPart{{ETag: part.ETag, PartNumber: aws.Int64(1)}},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if loc := aws.StringValue(done.Location); loc != ts.URL+"/"+defaultBucket+"/upload" {
+		t.Fatal("unexpected location", loc)
+	}
+}
+
+func TestHostBucketBase(t *testing.T) {
+	ts := newTestServer(t, withFakerOptions(gofakes3.WithHostBucketBase("s3.localhost")))
+	defer ts.Close()
+	ts.backendPutString(defaultBucket, "object", nil, "hello")
+
+	_, port, err := net.SplitHostPort(strings.TrimPrefix(ts.server.URL, "http://"))
+	ts.OK(err)
+
+	for _, tc := range []struct {
+		host string
+		path string
+	}{
+		{defaultBucket + ".s3.localhost", "/object"},
+		{defaultBucket + ".s3.localhost:" + port, "/object"},
+		{"s3.localhost", "/" + defaultBucket + "/object"},
+		{"s3.localhost:" + port, "/" + defaultBucket + "/object"},
+		{"127.0.0.1:" + port, "/" + defaultBucket + "/object"},
+		{"localhost", "/" + defaultBucket + "/object"},
+	} {
+		t.Run(tc.host, func(t *testing.T) {
+			rq, err := http.NewRequest("GET", ts.url(tc.path), nil)
+			ts.OK(err)
+			rq.Host = tc.host
+			rs, err := httpClient().Do(rq)
+			ts.OK(err)
+			defer rs.Body.Close()
+			b, err := ioutil.ReadAll(rs.Body)
+			ts.OK(err)
+			if rs.StatusCode != http.StatusOK || string(b) != "hello" {
+				t.Fatal("unexpected response", rs.StatusCode, string(b))
+			}
+		})
+	}
+}
+
+func TestBucketPolicy(t *testing.T) {
+	ts := newTestServer(t)
+	defer ts.Close()
+	svc := ts.s3Client()
+
+	_, err := svc.GetBucketPolicy(&s3.GetBucketPolicyInput{Bucket: aws.String(defaultBucket)})
+	if !s3HasErrorCode(err, gofakes3.ErrNoSuchBucketPolicy) {
+		t.Fatal("expected NoSuchBucketPolicy, found", err)
+	}
+
+	const policy = `{
+  "Version": "2012-10-17",
+  "Statement": [{
+    "Effect": "Allow",
+    "Principal": "*",
+    "Action": "s3:GetObject",
+    "Resource": "arn:aws:s3:::` + defaultBucket + `/*"
+  }]
+}`
+	_, err = svc.PutBucketPolicy(&s3.PutBucketPolicyInput{
+		Bucket: aws.String(defaultBucket),
+		Policy: aws.String(policy),
+	})
+	ts.OK(err)
+
+	out, err := svc.GetBucketPolicy(&s3.GetBucketPolicyInput{Bucket: aws.String(defaultBucket)})
+	ts.OK(err)
+	if aws.StringValue(out.Policy) != policy {
+		t.Fatal("policy was not returned verbatim:", aws.StringValue(out.Policy))
+	}
+
+	for _, invalid := range []string{
+		`{"Version": "2012-10-17"`,
+		`{"Version": "2012-10-17"}`,
+		`{"Version": "2012-10-17", "Statement": []}`,
+	} {
+		_, err = svc.PutBucketPolicy(&s3.PutBucketPolicyInput{
+			Bucket: aws.String(defaultBucket),
+			Policy: aws.String(invalid),
+		})
+		if !s3HasErrorCode(err, gofakes3.ErrMalformedPolicy) {
+			t.Fatal("expected MalformedPolicy for", invalid, "found", err)
+		}
+	}
+
+	_, err = svc.DeleteBucketPolicy(&s3.DeleteBucketPolicyInput{Bucket: aws.String(defaultBucket)})
+	ts.OK(err)
+
+	_, err = svc.GetBucketPolicy(&s3.GetBucketPolicyInput{Bucket: aws.String(defaultBucket)})
+	if !s3HasErrorCode(err, gofakes3.ErrNoSuchBucketPolicy) {
+		t.Fatal("expected NoSuchBucketPolicy, found", err)
+	}
+}
+
+func TestBucketCORS(t *testing.T) {
+	ts := newTestServer(t)
+	defer ts.Close()
+	svc := ts.s3Client()
+
+	ts.backendPutString(defaultBucket, "object", nil, "hello")
+
+	request := func(method, origin string, hdr map[string]string) *http.Response {
+		t.Helper()
+		rq, err := http.NewRequest(method, ts.url("/"+defaultBucket+"/object"), nil)
+		ts.OK(err)
+		if origin != "" {
+			rq.Header.Set("Origin", origin)
+		}
+		for k, v := range hdr {
+			rq.Header.Set(k, v)
+		}
+		rs, err := httpClient().Do(rq)
+		ts.OK(err)
+		rs.Body.Close()
+		return rs
+	}
+
+	// Without a configuration, everything is allowed:
+	rs := request("OPTIONS", "http://example.com", map[string]string{"Access-Control-Request-Method": "PUT"})
+	if rs.StatusCode != 200 || rs.Header.Get("Access-Control-Allow-Origin") != "*" {
+		t.Fatal("unexpected preflight response", rs.StatusCode, rs.Header)
+	}
+
+	_, err := svc.GetBucketCors(&s3.GetBucketCorsInput{Bucket: aws.String(defaultBucket)})
+	if !s3HasErrorCode(err, gofakes3.ErrNoSuchCORSConfiguration) {
+		t.Fatal("expected NoSuchCORSConfiguration, found", err)
+	}
+
+	_, err = svc.PutBucketCors(&s3.PutBucketCorsInput{
+		Bucket: aws.String(defaultBucket),
+		CORSConfiguration: &s3.CORSConfiguration{
+			CORSRules: []*s3.CORSRule{{
+				AllowedMethods: []*string{aws.String("GET"), aws.String("PUT")},
+				AllowedOrigins: []*string{aws.String("https://*.example.com")},
+				AllowedHeaders: []*string{aws.String("X-Amz-*")},
+				ExposeHeaders:  []*string{aws.String("ETag"), aws.String("X-Amz-Meta-Foo")},
+				MaxAgeSeconds:  aws.Int64(300),
+			}},
+		},
+	})
+	ts.OK(err)
+
+	out, err := svc.GetBucketCors(&s3.GetBucketCorsInput{Bucket: aws.String(defaultBucket)})
+	ts.OK(err)
+	if len(out.CORSRules) != 1 || len(out.CORSRules[0].AllowedMethods) != 2 ||
+		aws.Int64Value(out.CORSRules[0].MaxAgeSeconds) != 300 {
+		t.Fatal("unexpected CORS configuration", out)
+	}
+
+	rs = request("OPTIONS", "https://www.example.com", map[string]string{
+		"Access-Control-Request-Method":  "PUT",
+		"Access-Control-Request-Headers": "x-amz-date, x-amz-meta-foo",
+	})
+	if rs.StatusCode != 200 {
+		t.Fatal("unexpected status", rs.StatusCode)
+	}
+	if v := rs.Header.Get("Access-Control-Allow-Origin"); v != "https://www.example.com" {
+		t.Fatal("unexpected Access-Control-Allow-Origin", v)
+	}
+	if v := rs.Header.Get("Access-Control-Allow-Methods"); v != "GET, PUT" {
+		t.Fatal("unexpected Access-Control-Allow-Methods", v)
+	}
+	if v := rs.Header.Get("Access-Control-Allow-Headers"); v != "x-amz-date, x-amz-meta-foo" {
+		t.Fatal("unexpected Access-Control-Allow-Headers", v)
+	}
+	if v := rs.Header.Get("Access-Control-Max-Age"); v != "300" {
+		t.Fatal("unexpected Access-Control-Max-Age", v)
+	}
+
+	for _, tc := range []struct {
+		origin, method, headers string
+	}{
+		{"https://evil.com", "GET", ""},
+		{"https://www.example.com", "DELETE", ""},
+		{"https://www.example.com", "GET", "Authorization"},
+	} {
+		rs = request("OPTIONS", tc.origin, map[string]string{
+			"Access-Control-Request-Method":  tc.method,
+			"Access-Control-Request-Headers": tc.headers,
+		})
+		if rs.StatusCode != 403 {
+			t.Fatal("expected preflight to be forbidden", tc, rs.StatusCode)
+		}
+	}
+
+	rs = request("GET", "https://www.example.com", nil)
+	if rs.StatusCode != 200 {
+		t.Fatal("unexpected status", rs.StatusCode)
+	}
+	if v := rs.Header.Get("Access-Control-Expose-Headers"); v != "ETag, X-Amz-Meta-Foo" {
+		t.Fatal("unexpected Access-Control-Expose-Headers", v)
+	}
+
+	// A request from an origin that is not allowed still succeeds, but gets
+	// no CORS headers:
+	rs = request("GET", "https://evil.com", nil)
+	if rs.StatusCode != 200 || rs.Header.Get("Access-Control-Allow-Origin") != "" {
+		t.Fatal("unexpected response", rs.StatusCode, rs.Header)
+	}
+
+	_, err = svc.DeleteBucketCors(&s3.DeleteBucketCorsInput{Bucket: aws.String(defaultBucket)})
+	ts.OK(err)
+
+	_, err = svc.GetBucketCors(&s3.GetBucketCorsInput{Bucket: aws.String(defaultBucket)})
+	if !s3HasErrorCode(err, gofakes3.ErrNoSuchCORSConfiguration) {
+		t.Fatal("expected NoSuchCORSConfiguration, found", err)
+	}
+}
+
+func TestServerCORS(t *testing.T) {
+	ts := newTestServer(t, withFakerOptions(gofakes3.WithCORS(gofakes3.CORSConfiguration{
+		Rules: []gofakes3.CORSRule{{
+			AllowedMethods: []string{"GET", "HEAD"},
+			AllowedOrigins: []string{"https://app.example.com"},
+			ExposeHeaders:  []string{"X-Amz-Meta-Foo"},
+		}},
+	})))
+	defer ts.Close()
+	svc := ts.s3Client()
+
+	ts.backendPutString(defaultBucket, "object", map[string]string{"X-Amz-Meta-Foo": "bar"}, "hello")
+
+	// A cross-origin fetch() from a browser is a plain GET carrying the
+	// page's origin:
+	fetch := func(origin string) *http.Response {
+		t.Helper()
+		rq, err := http.NewRequest("GET", ts.url("/"+defaultBucket+"/object"), nil)
+		ts.OK(err)
+		rq.Header.Set("Origin", origin)
+		rs, err := httpClient().Do(rq)
+		ts.OK(err)
+		rs.Body.Close()
+		if rs.StatusCode != 200 {
+			t.Fatal("unexpected status", rs.StatusCode)
+		}
+		return rs
+	}
+
+	rs := fetch("https://app.example.com")
+	if v := rs.Header.Get("Access-Control-Allow-Origin"); v != "https://app.example.com" {
+		t.Fatal("unexpected Access-Control-Allow-Origin", v)
+	}
+	if v := rs.Header.Get("Access-Control-Expose-Headers"); v != "ETag, X-Amz-Meta-Foo" {
+		t.Fatal("unexpected Access-Control-Expose-Headers", v)
+	}
+	if v := rs.Header.Get("Vary"); v != "Origin" {
+		t.Fatal("unexpected Vary", v)
+	}
+
+	rs = fetch("https://evil.com")
+	if v := rs.Header.Get("Access-Control-Allow-Origin"); v != "" {
+		t.Fatal("unexpected Access-Control-Allow-Origin", v)
+	}
+	if v := rs.Header.Get("Access-Control-Expose-Headers"); v != "" {
+		t.Fatal("unexpected Access-Control-Expose-Headers", v)
+	}
+
+	// A bucket's own configuration replaces the server's:
+	_, err := svc.PutBucketCors(&s3.PutBucketCorsInput{
+		Bucket: aws.String(defaultBucket),
+		CORSConfiguration: &s3.CORSConfiguration{
+			CORSRules: []*s3.CORSRule{{
+				AllowedMethods: []*string{aws.String("GET")},
+				AllowedOrigins: []*string{aws.String("*")},
+			}},
+		},
+	})
+	ts.OK(err)
+
+	rs = fetch("https://evil.com")
+	if v := rs.Header.Get("Access-Control-Allow-Origin"); v != "*" {
+		t.Fatal("unexpected Access-Control-Allow-Origin", v)
+	}
+	if v := rs.Header.Get("Access-Control-Expose-Headers"); v != "ETag" {
+		t.Fatal("unexpected Access-Control-Expose-Headers", v)
+	}
+}
+
+func TestBucketRequestPayment(t *testing.T) {
+	ts := newTestServer(t)
+	defer ts.Close()
+	svc := ts.s3Client()
+
+	ts.backendPutString(defaultBucket, "object", nil, "hello")
+
+	payer := func() string {
+		t.Helper()
+		out, err := svc.GetBucketRequestPayment(&s3.GetBucketRequestPaymentInput{Bucket: aws.String(defaultBucket)})
+		ts.OK(err)
+		return aws.StringValue(out.Payer)
+	}
+	get := func(requestPayer bool) (*s3.GetObjectOutput, error) {
+		input := &s3.GetObjectInput{Bucket: aws.String(defaultBucket), Key: aws.String("object")}
+		if requestPayer {
+			input.RequestPayer = aws.String(s3.RequestPayerRequester)
+		}
+		out, err := svc.GetObject(input)
+		if err == nil {
+			out.Body.Close()
+		}
+		return out, err
+	}
+
+	if v := payer(); v != "BucketOwner" {
+		t.Fatal("unexpected default payer", v)
+	}
+	ts.OKAll(get(false))
+
+	setPayer := func(payer string) error {
+		_, err := svc.PutBucketRequestPayment(&s3.PutBucketRequestPaymentInput{
+			Bucket:                      aws.String(defaultBucket),
+			RequestPaymentConfiguration: &s3.RequestPaymentConfiguration{Payer: aws.String(payer)},
+		})
+		return err
+	}
+	ts.OK(setPayer("Requester"))
+	if v := payer(); v != "Requester" {
+		t.Fatal("unexpected payer", v)
+	}
+
+	if _, err := get(false); !s3HasErrorCode(err, gofakes3.ErrAccessDenied) {
+		t.Fatal("expected AccessDenied without x-amz-request-payer, found", err)
+	}
+	out, err := get(true)
+	ts.OK(err)
+	if v := aws.StringValue(out.RequestCharged); v != "requester" {
+		t.Fatal("unexpected x-amz-request-charged", v)
+	}
+
+	// Bucket operations are not affected:
+	ts.OKAll(svc.ListObjects(&s3.ListObjectsInput{Bucket: aws.String(defaultBucket)}))
+
+	if err := setPayer("Stranger"); !s3HasErrorCode(err, gofakes3.ErrMalformedXML) {
+		t.Fatal("expected MalformedXML, found", err)
+	}
+
+	ts.OK(setPayer("BucketOwner"))
+	ts.OKAll(get(false))
+
+	_, err = svc.GetBucketRequestPayment(&s3.GetBucketRequestPaymentInput{Bucket: aws.String("missing")})
+	if !s3HasErrorCode(err, gofakes3.ErrNoSuchBucket) {
+		t.Fatal("expected NoSuchBucket, found", err)
+	}
+}
+
+func TestBucketConfigSubresources(t *testing.T) {
+	ts := newTestServer(t)
+	defer ts.Close()
+	svc := ts.s3Client()
+	bucket := aws.String(defaultBucket)
+
+	t.Run("accelerate", func(t *testing.T) {
+		out, err := svc.GetBucketAccelerateConfiguration(&s3.GetBucketAccelerateConfigurationInput{Bucket: bucket})
+		ts.OK(err)
+		if out.Status != nil {
+			t.Fatal("unexpected default status", *out.Status)
+		}
+
+		ts.OKAll(svc.PutBucketAccelerateConfiguration(&s3.PutBucketAccelerateConfigurationInput{
+			Bucket:                  bucket,
+			AccelerateConfiguration: &s3.AccelerateConfiguration{Status: aws.String("Enabled")},
+		}))
+		out, err = svc.GetBucketAccelerateConfiguration(&s3.GetBucketAccelerateConfigurationInput{Bucket: bucket})
+		ts.OK(err)
+		if aws.StringValue(out.Status) != "Enabled" {
+			t.Fatal("unexpected status", out.Status)
+		}
+
+		_, err = svc.PutBucketAccelerateConfiguration(&s3.PutBucketAccelerateConfigurationInput{
+			Bucket:                  bucket,
+			AccelerateConfiguration: &s3.AccelerateConfiguration{Status: aws.String("Faster")},
+		})
+		if !s3HasErrorCode(err, gofakes3.ErrMalformedXML) {
+			t.Fatal("expected MalformedXML, found", err)
+		}
+	})
+
+	t.Run("logging", func(t *testing.T) {
+		out, err := svc.GetBucketLogging(&s3.GetBucketLoggingInput{Bucket: bucket})
+		ts.OK(err)
+		if out.LoggingEnabled != nil {
+			t.Fatal("unexpected default logging", out.LoggingEnabled)
+		}
+
+		enabled := &s3.LoggingEnabled{
+			TargetBucket: aws.String("logs"),
+			TargetPrefix: aws.String("mybucket/"),
+			TargetGrants: []*s3.TargetGrant{{
+				Grantee:    &s3.Grantee{Type: aws.String(gofakes3.GranteeGroup), URI: aws.String(gofakes3.GroupLogDelivery)},
+				Permission: aws.String("WRITE"),
+			}},
+		}
+		ts.OKAll(svc.PutBucketLogging(&s3.PutBucketLoggingInput{
+			Bucket:              bucket,
+			BucketLoggingStatus: &s3.BucketLoggingStatus{LoggingEnabled: enabled},
+		}))
+		out, err = svc.GetBucketLogging(&s3.GetBucketLoggingInput{Bucket: bucket})
+		ts.OK(err)
+		if !reflect.DeepEqual(out.LoggingEnabled, enabled) {
+			t.Fatal("unexpected logging", out.LoggingEnabled)
+		}
+
+		// An empty status disables logging:
+		ts.OKAll(svc.PutBucketLogging(&s3.PutBucketLoggingInput{Bucket: bucket, BucketLoggingStatus: &s3.BucketLoggingStatus{}}))
+		out, err = svc.GetBucketLogging(&s3.GetBucketLoggingInput{Bucket: bucket})
+		ts.OK(err)
+		if out.LoggingEnabled != nil {
+			t.Fatal("expected logging to be disabled, found", out.LoggingEnabled)
+		}
+	})
+
+	t.Run("notification", func(t *testing.T) {
+		out, err := svc.GetBucketNotificationConfiguration(&s3.GetBucketNotificationConfigurationRequest{Bucket: bucket})
+		ts.OK(err)
+		if len(out.QueueConfigurations) != 0 || len(out.TopicConfigurations) != 0 || len(out.LambdaFunctionConfigurations) != 0 {
+			t.Fatal("unexpected default configuration", out)
+		}
+
+		queues := []*s3.QueueConfiguration{{
+			Id:       aws.String("uploads"),
+			QueueArn: aws.String("arn:aws:sqs:us-east-1:123456789012:uploads"),
+			Events:   []*string{aws.String("s3:ObjectCreated:*")},
+			Filter: &s3.NotificationConfigurationFilter{Key: &s3.KeyFilter{FilterRules: []*s3.FilterRule{
+				{Name: aws.String("prefix"), Value: aws.String("uploads/")},
+			}}},
+		}}
+		topics := []*s3.TopicConfiguration{{
+			TopicArn: aws.String("arn:aws:sns:us-east-1:123456789012:deletes"),
+			Events:   []*string{aws.String("s3:ObjectRemoved:*")},
+		}}
+		ts.OKAll(svc.PutBucketNotificationConfiguration(&s3.PutBucketNotificationConfigurationInput{
+			Bucket: bucket,
+			NotificationConfiguration: &s3.NotificationConfiguration{
+				QueueConfigurations: queues,
+				TopicConfigurations: topics,
+			},
+		}))
+		out, err = svc.GetBucketNotificationConfiguration(&s3.GetBucketNotificationConfigurationRequest{Bucket: bucket})
+		ts.OK(err)
+		if !reflect.DeepEqual(out.QueueConfigurations, queues) || !reflect.DeepEqual(out.TopicConfigurations, topics) {
+			t.Fatal("unexpected configuration", out)
+		}
+	})
+
+	for _, tc := range []struct {
+		sub, body string
+	}{
+		{"accelerate", "<nope"},
+		{"logging", "<nope"},
+		{"logging", "<BucketLoggingStatus><LoggingEnabled><TargetPrefix>x</TargetPrefix></LoggingEnabled></BucketLoggingStatus>"},
+		{"notification", "<nope"},
+		{"notification", "<NotificationConfiguration><QueueConfiguration><Event>s3:ObjectCreated:*</Event></QueueConfiguration></NotificationConfiguration>"},
+	} {
+		rq, err := http.NewRequest("PUT", ts.url(defaultBucket+"?"+tc.sub), strings.NewReader(tc.body))
+		ts.OK(err)
+		rs, err := httpClient().Do(rq)
+		ts.OK(err)
+		body, _ := ioutil.ReadAll(rs.Body)
+		rs.Body.Close()
+		if rs.StatusCode != http.StatusBadRequest || !bytes.Contains(body, []byte("<Code>MalformedXML</Code>")) {
+			t.Fatal("expected MalformedXML for", tc, rs.StatusCode, string(body))
+		}
+	}
+
+	_, err := svc.GetBucketLogging(&s3.GetBucketLoggingInput{Bucket: aws.String("missing")})
+	if !s3HasErrorCode(err, gofakes3.ErrNoSuchBucket) {
+		t.Fatal("expected NoSuchBucket, found", err)
+	}
+}
+
+func TestBucketInventory(t *testing.T) {
+	ts := newTestServer(t)
+	defer ts.Close()
+	svc := ts.s3Client()
+	bucket := aws.String(defaultBucket)
+
+	inventory := func(id string) *s3.InventoryConfiguration {
+		return &s3.InventoryConfiguration{
+			Id:        aws.String(id),
+			IsEnabled: aws.Bool(true),
+			Filter:    &s3.InventoryFilter{Prefix: aws.String("data/")},
+			Destination: &s3.InventoryDestination{S3BucketDestination: &s3.InventoryS3BucketDestination{
+				AccountId:  aws.String("123456789012"),
+				Bucket:     aws.String("arn:aws:s3:::inventory"),
+				Format:     aws.String("CSV"),
+				Prefix:     aws.String("reports"),
+				Encryption: &s3.InventoryEncryption{SSEKMS: &s3.SSEKMS{KeyId: aws.String("arn:aws:kms:us-east-1:123456789012:key/1")}},
+			}},
+			Schedule:               &s3.InventorySchedule{Frequency: aws.String("Weekly")},
+			IncludedObjectVersions: aws.String("All"),
+			OptionalFields:         []*string{aws.String("Size"), aws.String("ETag")},
+		}
+	}
+	put := func(id string) {
+		t.Helper()
+		ts.OKAll(svc.PutBucketInventoryConfiguration(&s3.PutBucketInventoryConfigurationInput{
+			Bucket:                 bucket,
+			Id:                     aws.String(id),
+			InventoryConfiguration: inventory(id),
+		}))
+	}
+
+	_, err := svc.GetBucketInventoryConfiguration(&s3.GetBucketInventoryConfigurationInput{Bucket: bucket, Id: aws.String("weekly")})
+	if !s3HasErrorCode(err, gofakes3.ErrNoSuchConfiguration) {
+		t.Fatal("expected NoSuchConfiguration, found", err)
+	}
+
+	put("weekly")
+	out, err := svc.GetBucketInventoryConfiguration(&s3.GetBucketInventoryConfigurationInput{Bucket: bucket, Id: aws.String("weekly")})
+	ts.OK(err)
+	if !reflect.DeepEqual(out.InventoryConfiguration, inventory("weekly")) {
+		t.Fatal("unexpected configuration", out.InventoryConfiguration)
+	}
+
+	// Enough configurations to need a second page, put out of order:
+	for i := 149; i >= 0; i-- {
+		put(fmt.Sprintf("config-%03d", i))
+	}
+	var ids []string
+	var token *string
+	for {
+		list, err := svc.ListBucketInventoryConfigurations(&s3.ListBucketInventoryConfigurationsInput{
+			Bucket:            bucket,
+			ContinuationToken: token,
+		})
+		ts.OK(err)
+		for _, config := range list.InventoryConfigurationList {
+			ids = append(ids, aws.StringValue(config.Id))
+		}
+		if !aws.BoolValue(list.IsTruncated) {
+			break
+		}
+		token = list.NextContinuationToken
+	}
+	if len(ids) != 151 || ids[0] != "config-000" || ids[149] != "config-149" || ids[150] != "weekly" {
+		t.Fatal("unexpected ids", ids)
+	}
+
+	ts.OKAll(svc.DeleteBucketInventoryConfiguration(&s3.DeleteBucketInventoryConfigurationInput{Bucket: bucket, Id: aws.String("weekly")}))
+	_, err = svc.GetBucketInventoryConfiguration(&s3.GetBucketInventoryConfigurationInput{Bucket: bucket, Id: aws.String("weekly")})
+	if !s3HasErrorCode(err, gofakes3.ErrNoSuchConfiguration) {
+		t.Fatal("expected NoSuchConfiguration, found", err)
+	}
+	_, err = svc.DeleteBucketInventoryConfiguration(&s3.DeleteBucketInventoryConfigurationInput{Bucket: bucket, Id: aws.String("weekly")})
+	if !s3HasErrorCode(err, gofakes3.ErrNoSuchConfiguration) {
+		t.Fatal("expected NoSuchConfiguration, found", err)
+	}
+
+	for _, body := range []string{
+		"<nope",
+		"<InventoryConfiguration><Id>bad</Id></InventoryConfiguration>",
+		"<InventoryConfiguration><Id>bad</Id><Destination><S3BucketDestination><Bucket>arn:aws:s3:::inventory</Bucket><Format>XLS</Format></S3BucketDestination></Destination>" +
+			"<Schedule><Frequency>Daily</Frequency></Schedule><IncludedObjectVersions>All</IncludedObjectVersions></InventoryConfiguration>",
+	} {
+		rq, err := http.NewRequest("PUT", ts.url(defaultBucket+"?inventory&id=bad"), strings.NewReader(body))
+		ts.OK(err)
+		rs, err := httpClient().Do(rq)
+		ts.OK(err)
+		rsBody, _ := ioutil.ReadAll(rs.Body)
+		rs.Body.Close()
+		if rs.StatusCode != http.StatusBadRequest || !bytes.Contains(rsBody, []byte("<Code>MalformedXML</Code>")) {
+			t.Fatal("expected MalformedXML for", body, rs.StatusCode, string(rsBody))
+		}
+	}
+
+	_, err = svc.PutBucketInventoryConfiguration(&s3.PutBucketInventoryConfigurationInput{
+		Bucket:                 bucket,
+		Id:                     aws.String("other"),
+		InventoryConfiguration: inventory("weekly"),
+	})
+	if !s3HasErrorCode(err, gofakes3.ErrInvalidArgument) {
+		t.Fatal("expected InvalidArgument, found", err)
+	}
+}
+
+func TestBucketAnalytics(t *testing.T) {
+	ts := newTestServer(t)
+	defer ts.Close()
+	svc := ts.s3Client()
+	bucket := aws.String(defaultBucket)
+
+	config := &s3.AnalyticsConfiguration{
+		Id: aws.String("reports"),
+		Filter: &s3.AnalyticsFilter{And: &s3.AnalyticsAndOperator{
+			Prefix: aws.String("data/"),
+			Tags:   []*s3.Tag{{Key: aws.String("team"), Value: aws.String("storage")}},
+		}},
+		StorageClassAnalysis: &s3.StorageClassAnalysis{DataExport: &s3.StorageClassAnalysisDataExport{
+			OutputSchemaVersion: aws.String("V_1"),
+			Destination: &s3.AnalyticsExportDestination{S3BucketDestination: &s3.AnalyticsS3BucketDestination{
+				Bucket:          aws.String("arn:aws:s3:::analytics"),
+				BucketAccountId: aws.String("123456789012"),
+				Format:          aws.String("CSV"),
+				Prefix:          aws.String("reports"),
+			}},
+		}},
+	}
+	ts.OKAll(svc.PutBucketAnalyticsConfiguration(&s3.PutBucketAnalyticsConfigurationInput{
+		Bucket: bucket, Id: config.Id, AnalyticsConfiguration: config,
+	}))
+	ts.OKAll(svc.PutBucketAnalyticsConfiguration(&s3.PutBucketAnalyticsConfigurationInput{
+		Bucket:                 bucket,
+		Id:                     aws.String("all"),
+		AnalyticsConfiguration: &s3.AnalyticsConfiguration{Id: aws.String("all"), StorageClassAnalysis: &s3.StorageClassAnalysis{}},
+	}))
+
+	out, err := svc.GetBucketAnalyticsConfiguration(&s3.GetBucketAnalyticsConfigurationInput{Bucket: bucket, Id: config.Id})
+	ts.OK(err)
+	if !reflect.DeepEqual(out.AnalyticsConfiguration, config) {
+		t.Fatal("unexpected configuration", out.AnalyticsConfiguration)
+	}
+
+	list, err := svc.ListBucketAnalyticsConfigurations(&s3.ListBucketAnalyticsConfigurationsInput{Bucket: bucket})
+	ts.OK(err)
+	if len(list.AnalyticsConfigurationList) != 2 || aws.StringValue(list.AnalyticsConfigurationList[0].Id) != "all" ||
+		!reflect.DeepEqual(list.AnalyticsConfigurationList[1], config) || aws.BoolValue(list.IsTruncated) {
+		t.Fatal("unexpected list", list)
+	}
+
+	ts.OKAll(svc.DeleteBucketAnalyticsConfiguration(&s3.DeleteBucketAnalyticsConfigurationInput{Bucket: bucket, Id: config.Id}))
+	_, err = svc.GetBucketAnalyticsConfiguration(&s3.GetBucketAnalyticsConfigurationInput{Bucket: bucket, Id: config.Id})
+	if !s3HasErrorCode(err, gofakes3.ErrNoSuchConfiguration) {
+		t.Fatal("expected NoSuchConfiguration, found", err)
+	}
+
+	_, err = svc.PutBucketAnalyticsConfiguration(&s3.PutBucketAnalyticsConfigurationInput{
+		Bucket: bucket,
+		Id:     aws.String("bad"),
+		AnalyticsConfiguration: &s3.AnalyticsConfiguration{
+			Id:                   aws.String("bad"),
+			Filter:               &s3.AnalyticsFilter{Prefix: aws.String("a/"), Tag: &s3.Tag{Key: aws.String("k"), Value: aws.String("v")}},
+			StorageClassAnalysis: &s3.StorageClassAnalysis{},
+		},
+	})
+	if !s3HasErrorCode(err, gofakes3.ErrMalformedXML) {
+		t.Fatal("expected MalformedXML, found", err)
+	}
+}
+
+func TestBucketMetrics(t *testing.T) {
+	ts := newTestServer(t)
+	defer ts.Close()
+	svc := ts.s3Client()
+	bucket := aws.String(defaultBucket)
+
+	configs := []*s3.MetricsConfiguration{
+		{Id: aws.String("everything")},
+		{Id: aws.String("prefix"), Filter: &s3.MetricsFilter{Prefix: aws.String("logs/")}},
+		{Id: aws.String("tagged"), Filter: &s3.MetricsFilter{And: &s3.MetricsAndOperator{
+			Prefix: aws.String("data/"),
+			Tags:   []*s3.Tag{{Key: aws.String("team"), Value: aws.String("storage")}},
+		}}},
+	}
+	for i := len(configs) - 1; i >= 0; i-- {
+		ts.OKAll(svc.PutBucketMetricsConfiguration(&s3.PutBucketMetricsConfigurationInput{
+			Bucket: bucket, Id: configs[i].Id, MetricsConfiguration: configs[i],
+		}))
+	}
+
+	out, err := svc.GetBucketMetricsConfiguration(&s3.GetBucketMetricsConfigurationInput{Bucket: bucket, Id: aws.String("tagged")})
+	ts.OK(err)
+	if !reflect.DeepEqual(out.MetricsConfiguration, configs[2]) {
+		t.Fatal("unexpected configuration", out.MetricsConfiguration)
+	}
+
+	list, err := svc.ListBucketMetricsConfigurations(&s3.ListBucketMetricsConfigurationsInput{Bucket: bucket})
+	ts.OK(err)
+	if !reflect.DeepEqual(list.MetricsConfigurationList, configs) {
+		t.Fatal("unexpected list", list.MetricsConfigurationList)
+	}
+
+	ts.OKAll(svc.DeleteBucketMetricsConfiguration(&s3.DeleteBucketMetricsConfigurationInput{Bucket: bucket, Id: aws.String("everything")}))
+	list, err = svc.ListBucketMetricsConfigurations(&s3.ListBucketMetricsConfigurationsInput{Bucket: bucket})
+	ts.OK(err)
+	if len(list.MetricsConfigurationList) != 2 {
+		t.Fatal("unexpected list", list.MetricsConfigurationList)
+	}
+
+	// The SDK refuses to send an empty ID itself:
+	for _, method := range []string{"PUT", "DELETE"} {
+		rq, err := http.NewRequest(method, ts.url(defaultBucket+"?metrics&id="), strings.NewReader("<MetricsConfiguration><Id></Id></MetricsConfiguration>"))
+		ts.OK(err)
+		rs, err := httpClient().Do(rq)
+		ts.OK(err)
+		body, _ := ioutil.ReadAll(rs.Body)
+		rs.Body.Close()
+		if rs.StatusCode != http.StatusBadRequest || !bytes.Contains(body, []byte("<Code>InvalidArgument</Code>")) {
+			t.Fatal("expected InvalidArgument for", method, rs.StatusCode, string(body))
+		}
+	}
+}
+
+func TestBucketLifecycle(t *testing.T) {
+	ts := newTestServer(t)
+	defer ts.Close()
+	svc := ts.s3Client()
+
+	_, err := svc.GetBucketLifecycleConfiguration(&s3.GetBucketLifecycleConfigurationInput{
+		Bucket: aws.String(defaultBucket),
+	})
+	if !s3HasErrorCode(err, gofakes3.ErrNoSuchLifecycleConfiguration) {
+		t.Fatal("expected NoSuchLifecycleConfiguration, found", err)
+	}
+
+	_, err = svc.PutBucketLifecycleConfiguration(&s3.PutBucketLifecycleConfigurationInput{
+		Bucket: aws.String(defaultBucket),
+		LifecycleConfiguration: &s3.BucketLifecycleConfiguration{
+			Rules: []*s3.LifecycleRule{
+				{
+					ID:         aws.String("expire-logs"),
+					Status:     aws.String("Enabled"),
+					Filter:     &s3.LifecycleRuleFilter{Prefix: aws.String("logs/")},
+					Expiration: &s3.LifecycleExpiration{Days: aws.Int64(30)},
+				},
+				{
+					ID:     aws.String("tmp"),
+					Status: aws.String("Disabled"),
+					Filter: &s3.LifecycleRuleFilter{Tag: &s3.Tag{Key: aws.String("tmp"), Value: aws.String("yes")}},
+					AbortIncompleteMultipartUpload: &s3.AbortIncompleteMultipartUpload{
+						DaysAfterInitiation: aws.Int64(7),
+					},
+				},
+			},
+		},
+	})
+	ts.OK(err)
+
+	out, err := svc.GetBucketLifecycleConfiguration(&s3.GetBucketLifecycleConfigurationInput{
+		Bucket: aws.String(defaultBucket),
+	})
+	ts.OK(err)
+	if len(out.Rules) != 2 {
+		t.Fatal("unexpected rules", out.Rules)
+	}
+	if rule := out.Rules[0]; aws.StringValue(rule.ID) != "expire-logs" ||
+		aws.StringValue(rule.Filter.Prefix) != "logs/" ||
+		aws.Int64Value(rule.Expiration.Days) != 30 {
+		t.Fatal("unexpected rule", rule)
+	}
+	if rule := out.Rules[1]; aws.StringValue(rule.Status) != "Disabled" ||
+		aws.StringValue(rule.Filter.Tag.Key) != "tmp" ||
+		aws.Int64Value(rule.AbortIncompleteMultipartUpload.DaysAfterInitiation) != 7 {
+		t.Fatal("unexpected rule", rule)
+	}
+
+	// An expiration needs a positive number of days:
+	_, err = svc.PutBucketLifecycleConfiguration(&s3.PutBucketLifecycleConfigurationInput{
+		Bucket: aws.String(defaultBucket),
+		LifecycleConfiguration: &s3.BucketLifecycleConfiguration{
+			Rules: []*s3.LifecycleRule{{
+				Status:     aws.String("Enabled"),
+				Filter:     &s3.LifecycleRuleFilter{Prefix: aws.String("")},
+				Expiration: &s3.LifecycleExpiration{Days: aws.Int64(-1)},
+			}},
+		},
+	})
+	if !s3HasErrorCode(err, gofakes3.ErrMalformedXML) {
+		t.Fatal("expected MalformedXML, found", err)
+	}
+
+	_, err = svc.DeleteBucketLifecycle(&s3.DeleteBucketLifecycleInput{
+		Bucket: aws.String(defaultBucket),
+	})
+	ts.OK(err)
+
+	_, err = svc.GetBucketLifecycleConfiguration(&s3.GetBucketLifecycleConfigurationInput{
+		Bucket: aws.String(defaultBucket),
+	})
+	if !s3HasErrorCode(err, gofakes3.ErrNoSuchLifecycleConfiguration) {
+		t.Fatal("expected NoSuchLifecycleConfiguration, found", err)
+	}
+}
+
+func TestBucketLifecycleExpiration(t *testing.T) {
+	ts := newTestServer(t)
+	defer ts.Close()
+	svc := ts.s3Client()
+
+	ts.backendPutString(defaultBucket, "logs/old", nil, "old")
+	ts.backendPutString(defaultBucket, "data/keep", nil, "keep")
+
+	_, err := svc.PutBucketLifecycleConfiguration(&s3.PutBucketLifecycleConfigurationInput{
+		Bucket: aws.String(defaultBucket),
+		LifecycleConfiguration: &s3.BucketLifecycleConfiguration{
+			Rules: []*s3.LifecycleRule{{
+				Status:     aws.String("Enabled"),
+				Filter:     &s3.LifecycleRuleFilter{Prefix: aws.String("logs/")},
+				Expiration: &s3.LifecycleExpiration{Days: aws.Int64(1)},
+			}},
+		},
+	})
+	ts.OK(err)
+
+	// Expiry is rounded up to the next midnight UTC, so one day after the
+	// object was created is not enough:
+	ts.Advance(24 * time.Hour)
+	ts.OK(ts.SweepLifecycle())
+	if !ts.backendObjectExists(defaultBucket, "logs/old") {
+		t.Fatal("object expired too early")
+	}
+
+	ts.backendPutString(defaultBucket, "logs/new", nil, "new")
+
+	ts.Advance(12 * time.Hour)
+	ts.OK(ts.SweepLifecycle())
+	if ts.backendObjectExists(defaultBucket, "logs/old") {
+		t.Fatal("expected logs/old to have expired")
+	}
+	if !ts.backendObjectExists(defaultBucket, "logs/new") {
+		t.Fatal("logs/new expired too early")
+	}
+	if !ts.backendObjectExists(defaultBucket, "data/keep") {
+		t.Fatal("object outside of the rule's prefix was expired")
+	}
+}
+
+func TestBucketLifecycleSweeper(t *testing.T) {
+	ts := newTestServer(t, withFakerOptions(gofakes3.WithLifecycleSweep(time.Millisecond)))
+	defer ts.Close()
+
+	ts.OK(ts.GoFakeS3.SweepLifecycle())
+	ts.OK(ts.backend.(gofakes3.LifecycleBackend).SetBucketLifecycleConfiguration(defaultBucket, gofakes3.LifecycleConfiguration{
+		Rules: []gofakes3.LifecycleRule{{
+			Status:     gofakes3.LifecycleEnabled,
+			Expiration: &gofakes3.LifecycleExpiration{Days: 1},
+		}},
+	}))
+
+	// Keep writing while the sweeper runs, to give the race detector
+	// something to look at:
+	for i := 0; i < 100; i++ {
+		ts.backendPutString(defaultBucket, fmt.Sprintf("obj%d", i%10), nil, "hello")
+	}
+
+	ts.Advance(48 * time.Hour)
+	deadline := time.Now().Add(5 * time.Second)
+	for ts.backendObjectExists(defaultBucket, "obj0") {
+		if time.Now().After(deadline) {
+			t.Fatal("sweeper did not expire object")
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestCopyObject(t *testing.T) {
+	ts := newTestServer(t)
+	defer ts.Close()
+	svc := ts.s3Client()
+
+	srcMeta := map[string]string{
+		"Content-Type":   "text/plain",
+		"X-Amz-Meta-One": "src",
+		"X-Amz-Meta-Two": "src",
+	}
+	ts.backendPutString(defaultBucket, "src-key", srcMeta, "content")
+
+	out, err := svc.CopyObject(&s3.CopyObjectInput{
+		Bucket:     aws.String(defaultBucket),
+		Key:        aws.String("dst-key"),
+		CopySource: aws.String("/" + defaultBucket + "/src-key"),
+		Metadata: map[string]*string{
+			"Two":   aws.String("dst"),
+			"Three": aws.String("dst"),
+		},
+	})
+	ts.OK(err)
+
+	if *out.CopyObjectResult.ETag != `"9a0364b9e99bb480dd25e1f0284c8555"` { // md5("content")
+		ts.Fatal("bad etag", *out.CopyObjectResult.ETag)
+	}
+
+	obj, err := ts.backend.GetObject(defaultBucket, "dst-key", nil)
+	ts.OK(err)
+
+	defer obj.Contents.Close()
+	data, err := ioutil.ReadAll(obj.Contents)
+	ts.OK(err)
+
+	if string(data) != "content" {
+		t.Fatal("object copying failed")
+	}
+
+	if v := obj.Metadata["X-Amz-Meta-One"]; v != "src" {
+		t.Fatalf("bad Content-Type: %q", v)
+	}
+
+	if v := obj.Metadata["X-Amz-Meta-Two"]; v != "dst" {
+		t.Fatalf("bad Content-Encoding: %q", v)
+	}
+
+	if v := obj.Metadata["X-Amz-Meta-Three"]; v != "dst" {
+		t.Fatalf("bad Content-Encoding: %q", v)
+	}
+}
+
+func TestCopyObjectMetadataDirective(t *testing.T) {
+	ts := newTestServer(t)
+	defer ts.Close()
+	svc := ts.s3Client()
+
+	srcMeta := map[string]string{
+		"Content-Type":   "text/plain",
+		"X-Amz-Meta-One": "src",
+	}
+	ts.backendPutString(defaultBucket, "src-key", srcMeta, "content")
+
+	out, err := svc.CopyObject(&s3.CopyObjectInput{
+		Bucket:            aws.String(defaultBucket),
+		Key:               aws.String("dst-key"),
+		CopySource:        aws.String("/" + defaultBucket + "/src-key"),
+		MetadataDirective: aws.String("REPLACE"),
+		ContentType:       aws.String("application/json"),
+		Metadata: map[string]*string{
+			"Two": aws.String("dst"),
+		},
+	})
+	ts.OK(err)
+	if *out.CopyObjectResult.ETag != `"9a0364b9e99bb480dd25e1f0284c8555"` { // md5("content")
+		ts.Fatal("bad etag", *out.CopyObjectResult.ETag)
+	}
+	if out.CopyObjectResult.LastModified == nil || out.CopyObjectResult.LastModified.IsZero() {
+		ts.Fatal("missing LastModified")
+	}
+
+	head, err := svc.HeadObject(&s3.HeadObjectInput{
+		Bucket: aws.String(defaultBucket),
+		Key:    aws.String("dst-key"),
+	})
+	ts.OK(err)
+	if _, ok := head.Metadata["One"]; ok {
+		t.Fatal("source metadata should have been replaced:", head.Metadata)
+	}
+	if v := aws.StringValue(head.Metadata["Two"]); v != "dst" {
+		t.Fatalf("bad metadata: %q", v)
+	}
+	if v := aws.StringValue(head.ContentType); v != "application/json" {
+		t.Fatalf("bad Content-Type: %q", v)
+	}
+
+	// Copying to self is only allowed if something is replaced:
+	_, err = svc.CopyObject(&s3.CopyObjectInput{
+		Bucket:     aws.String(defaultBucket),
+		Key:        aws.String("src-key"),
+		CopySource: aws.String("/" + defaultBucket + "/src-key"),
+	})
+	if !s3HasErrorCode(err, gofakes3.ErrInvalidRequest) {
+		t.Fatal("expected InvalidRequest, found", err)
+	}
+
+	_, err = svc.CopyObject(&s3.CopyObjectInput{
+		Bucket:            aws.String(defaultBucket),
+		Key:               aws.String("src-key"),
+		CopySource:        aws.String("/" + defaultBucket + "/src-key"),
+		MetadataDirective: aws.String("REPLACE"),
+		Metadata: map[string]*string{
+			"One": aws.String("replaced"),
+		},
+	})
+	ts.OK(err)
+
+	head, err = svc.HeadObject(&s3.HeadObjectInput{
+		Bucket: aws.String(defaultBucket),
+		Key:    aws.String("src-key"),
+	})
+	ts.OK(err)
+	if v := aws.StringValue(head.Metadata["One"]); v != "replaced" {
+		t.Fatalf("bad metadata: %q", v)
+	}
+
+	_, err = svc.CopyObject(&s3.CopyObjectInput{
+		Bucket:            aws.String(defaultBucket),
+		Key:               aws.String("dst-key"),
+		CopySource:        aws.String("/" + defaultBucket + "/src-key"),
+		MetadataDirective: aws.String("NOPE"),
+	})
+	if !s3HasErrorCode(err, gofakes3.ErrInvalidArgument) {
+		t.Fatal("expected InvalidArgument, found", err)
+	}
+}
+
+func TestCopyObjectToSelfVersion(t *testing.T) {
+	ts := newTestServer(t, withVersioning())
+	defer ts.Close()
+	svc := ts.s3Client()
+
+	put := func(body string) string {
+		t.Helper()
+		out, err := svc.PutObject(&s3.PutObjectInput{Bucket: aws.String(defaultBucket), Key: aws.String("object"), Body: strings.NewReader(body)})
+		ts.OK(err)
+		return aws.StringValue(out.VersionId)
+	}
+	copySelf := func(versionID, directive string) error {
+		_, err := svc.CopyObject(&s3.CopyObjectInput{
+			Bucket:            aws.String(defaultBucket),
+			Key:               aws.String("object"),
+			CopySource:        aws.String("/" + defaultBucket + "/object?versionId=" + versionID),
+			MetadataDirective: aws.String(directive),
+		})
+		return err
+	}
+
+	v1 := put("v1")
+	v2 := put("v2")
+
+	// Naming the current version still copies the object onto itself:
+	for _, directive := range []string{"", "COPY"} {
+		if err := copySelf(v2, directive); !s3HasErrorCode(err, gofakes3.ErrInvalidRequest) {
+			t.Fatalf("directive %q: expected InvalidRequest, found %v", directive, err)
+		}
+	}
+	ts.OK(copySelf(v2, "REPLACE"))
+
+	// ...but a noncurrent version is restored:
+	ts.OK(copySelf(v1, ""))
+	ts.assertObject(defaultBucket, "object", nil, "v1")
+
+	// An object that does not exist is missing rather than copied onto
+	// itself:
+	_, err := svc.CopyObject(&s3.CopyObjectInput{
+		Bucket:     aws.String(defaultBucket),
+		Key:        aws.String("missing"),
+		CopySource: aws.String("/" + defaultBucket + "/missing"),
+	})
+	if !s3HasErrorCode(err, gofakes3.ErrNoSuchKey) {
+		t.Fatal("expected NoSuchKey, found", err)
+	}
+}
+
+func TestStorageClass(t *testing.T) {
+	ts := newTestServer(t, withVersioning())
+	defer ts.Close()
+	svc := ts.s3Client()
+
+	put := func(key, class string) error {
+		t.Helper()
+		in := &s3.PutObjectInput{
+			Bucket: aws.String(defaultBucket),
+			Key:    aws.String(key),
+			Body:   bytes.NewReader([]byte("body")),
+		}
+		if class != "" {
+			in.StorageClass = aws.String(class)
+		}
+		_, err := svc.PutObject(in)
+		return err
+	}
+	copyObject := func(src, dst, class string) error {
+		t.Helper()
+		in := &s3.CopyObjectInput{
+			Bucket:     aws.String(defaultBucket),
+			Key:        aws.String(dst),
+			CopySource: aws.String(defaultBucket + "/" + src),
+		}
+		if class != "" {
+			in.StorageClass = aws.String(class)
+		}
+		_, err := svc.CopyObject(in)
+		return err
+	}
+	assertClass := func(key, expected string) {
+		t.Helper()
+		out, err := svc.HeadObject(&s3.HeadObjectInput{
+			Bucket: aws.String(defaultBucket),
+			Key:    aws.String(key),
+		})
+		ts.OK(err)
+
+		// S3 only sends the header for classes other than STANDARD:
+		var found string
+		if out.StorageClass != nil {
+			found = *out.StorageClass
+		}
+		if expected == "STANDARD" && found != "" {
+			t.Fatalf("unexpected storage class %q for %q", found, key)
+		} else if expected != "STANDARD" && found != expected {
+			t.Fatalf("storage class %q != %q for %q", found, expected, key)
+		}
+	}
+
+	ts.OK(put("standard", ""))
+	ts.OK(put("ia", "STANDARD_IA"))
+	ts.OK(put("glacier", "GLACIER"))
+	if err := put("bad", "NOPE"); !hasErrorCode(err, gofakes3.ErrInvalidStorageClass) {
+		t.Fatal("expected ErrInvalidStorageClass, found", err)
+	}
+
+	assertClass("standard", "STANDARD")
+	assertClass("ia", "STANDARD_IA")
+	assertClass("glacier", "GLACIER")
+
+	// The copy is STANDARD unless another class is asked for, and an object
+	// can be copied onto itself to change its class:
+	ts.OK(copyObject("ia", "ia-copy", ""))
+	assertClass("ia-copy", "STANDARD")
+	ts.OK(copyObject("standard", "standard-copy", "ONEZONE_IA"))
+	assertClass("standard-copy", "ONEZONE_IA")
+	ts.OK(copyObject("standard-copy", "standard-copy", "DEEP_ARCHIVE"))
+	assertClass("standard-copy", "DEEP_ARCHIVE")
+	if err := copyObject("standard", "bad", "NOPE"); !hasErrorCode(err, gofakes3.ErrInvalidStorageClass) {
+		t.Fatal("expected ErrInvalidStorageClass, found", err)
+	}
+
+	expected := map[string]string{
+		"glacier":       "GLACIER",
+		"ia":            "STANDARD_IA",
+		"ia-copy":       "STANDARD",
+		"standard":      "STANDARD",
+		"standard-copy": "DEEP_ARCHIVE",
+	}
+
+	list, err := svc.ListObjectsV2(&s3.ListObjectsV2Input{Bucket: aws.String(defaultBucket)})
+	ts.OK(err)
+	if len(list.Contents) != len(expected) {
+		t.Fatal("unexpected listing", list.Contents)
+	}
+	for _, item := range list.Contents {
+		if class := aws.StringValue(item.StorageClass); class != expected[*item.Key] {
+			t.Fatalf("listed storage class %q != %q for %q", class, expected[*item.Key], *item.Key)
+		}
+	}
+
+	versions, err := svc.ListObjectVersions(&s3.ListObjectVersionsInput{
+		Bucket: aws.String(defaultBucket),
+		Prefix: aws.String("standard-copy"),
+	})
+	ts.OK(err)
+	if len(versions.Versions) != 2 ||
+		aws.StringValue(versions.Versions[0].StorageClass) != "DEEP_ARCHIVE" ||
+		aws.StringValue(versions.Versions[1].StorageClass) != "ONEZONE_IA" {
+		t.Fatal("unexpected versions", versions.Versions)
+	}
+
+	_, err = svc.CreateMultipartUpload(&s3.CreateMultipartUploadInput{
+		Bucket:       aws.String(defaultBucket),
+		Key:          aws.String("upload"),
+		StorageClass: aws.String("INTELLIGENT_TIERING"),
+	})
+	ts.OK(err)
+	uploads, err := svc.ListMultipartUploads(&s3.ListMultipartUploadsInput{Bucket: aws.String(defaultBucket)})
+	ts.OK(err)
+	if len(uploads.Uploads) != 1 || aws.StringValue(uploads.Uploads[0].StorageClass) != "INTELLIGENT_TIERING" {
+		t.Fatal("unexpected uploads", uploads.Uploads)
+	}
+}
+
+func TestCopyObjectTaggingDirective(t *testing.T) {
+	ts := newTestServer(t)
+	defer ts.Close()
+	svc := ts.s3Client()
+
+	ts.backendPutString(defaultBucket, "src-key", nil, "content")
+	_, err := svc.PutObjectTagging(&s3.PutObjectTaggingInput{
+		Bucket: aws.String(defaultBucket),
+		Key:    aws.String("src-key"),
+		Tagging: &s3.Tagging{TagSet: []*s3.Tag{
+			{Key: aws.String("source"), Value: aws.String("yep")},
+		}},
+	})
+	ts.OK(err)
+
+	assertTags := func(key string, expected map[string]string) {
+		t.Helper()
+		out, err := svc.GetObjectTagging(&s3.GetObjectTaggingInput{
+			Bucket: aws.String(defaultBucket),
+			Key:    aws.String(key),
+		})
+		ts.OK(err)
+		found := map[string]string{}
+		for _, tag := range out.TagSet {
+			found[*tag.Key] = *tag.Value
+		}
+		if !reflect.DeepEqual(found, expected) {
+			t.Fatal("tag mismatch:", found, "!=", expected)
+		}
+	}
+
+	_, err = svc.CopyObject(&s3.CopyObjectInput{
+		Bucket:     aws.String(defaultBucket),
+		Key:        aws.String("copied"),
+		CopySource: aws.String("/" + defaultBucket + "/src-key"),
+	})
+	ts.OK(err)
+	assertTags("copied", map[string]string{"source": "yep"})
+
+	_, err = svc.CopyObject(&s3.CopyObjectInput{
+		Bucket:           aws.String(defaultBucket),
+		Key:              aws.String("replaced"),
+		CopySource:       aws.String("/" + defaultBucket + "/src-key"),
+		TaggingDirective: aws.String("REPLACE"),
+		Tagging:          aws.String("a=1&b=2"),
+	})
+	ts.OK(err)
+	assertTags("replaced", map[string]string{"a": "1", "b": "2"})
+
+	// Replacing the tags is enough of a change to allow copying to self:
+	_, err = svc.CopyObject(&s3.CopyObjectInput{
+		Bucket:           aws.String(defaultBucket),
+		Key:              aws.String("src-key"),
+		CopySource:       aws.String("/" + defaultBucket + "/src-key"),
+		TaggingDirective: aws.String("REPLACE"),
+	})
+	ts.OK(err)
+	assertTags("src-key", map[string]string{})
+}
+
+func TestCopyObjectTaggingDirectiveVersion(t *testing.T) {
+	ts := newTestServer(t, withVersioning())
+	defer ts.Close()
+	svc := ts.s3Client()
+
+	var versions []*string
+	for _, value := range []string{"old", "new"} {
+		out, err := svc.PutObject(&s3.PutObjectInput{
+			Bucket: aws.String(defaultBucket),
+			Key:    aws.String("src-key"),
+			Body:   bytes.NewReader([]byte(value)),
+		})
+		ts.OK(err)
+		ts.OKAll(svc.PutObjectTagging(&s3.PutObjectTaggingInput{
+			Bucket:    aws.String(defaultBucket),
+			Key:       aws.String("src-key"),
+			VersionId: out.VersionId,
+			Tagging: &s3.Tagging{TagSet: []*s3.Tag{
+				{Key: aws.String("version"), Value: aws.String(value)},
+			}},
+		}))
+		versions = append(versions, out.VersionId)
+	}
+
+	// The tags of a noncurrent version are copied with it:
+	ts.OKAll(svc.CopyObject(&s3.CopyObjectInput{
+		Bucket:     aws.String(defaultBucket),
+		Key:        aws.String("copied"),
+		CopySource: aws.String("/" + defaultBucket + "/src-key?versionId=" + *versions[0]),
+	}))
+	out, err := svc.GetObjectTagging(&s3.GetObjectTaggingInput{
+		Bucket: aws.String(defaultBucket),
+		Key:    aws.String("copied"),
+	})
+	ts.OK(err)
+	if len(out.TagSet) != 1 || aws.StringValue(out.TagSet[0].Value) != "old" {
+		t.Fatal("unexpected tags", out.TagSet)
+	}
+}
+
+func TestCopyObjectWithSpecialChars(t *testing.T) {
+	ts := newTestServer(t)
+	defer ts.Close()
+	svc := ts.s3Client()
+
+	srcMeta := map[string]string{
+		"Content-Type": "text/plain",
+	}
+	srcKey := "src+key,with special;chars!?="
+	content := "contents"
+	ts.backendPutString(defaultBucket, srcKey, srcMeta, content)
+	copySource := "/" + defaultBucket + "/" + url.QueryEscape(srcKey)
+	_, err := svc.CopyObject(&s3.CopyObjectInput{
+		Bucket:     aws.String(defaultBucket),
+		Key:        aws.String("dst-key"),
+		CopySource: aws.String(copySource),
+	})
+	ts.OK(err)
+
+	obj, err := svc.GetObject(&s3.GetObjectInput{
+		Bucket: aws.String(defaultBucket),
+		Key:    aws.String(srcKey),
+	})
+	if err != nil {
+		t.Fatalf("object not found with key %v", srcKey)
+	}
+	objContent, err := ioutil.ReadAll(obj.Body)
+	ts.OK(err)
+	if !bytes.Equal([]byte(content), objContent) {
+		ts.Fatalf("object contents are different %v!=%v", content, objContent)
+	}
+}
+
+func TestCopyObjectWithSpecialCharsEscapedInvalied(t *testing.T) {
+	ts := newTestServer(t)
+	defer ts.Close()
+	svc := ts.s3Client()
+
+	srcMeta := map[string]string{
+		"Content-Type": "text/plain",
+	}
+	srcKey := "src+key" //encoded srcKey = src%2Bkey
+	content := "contents"
+	ts.backendPutString(defaultBucket, srcKey, srcMeta, content)
+	copySource := "/" + defaultBucket + "/src%2key" //invalid encoding
+	_, err := svc.CopyObject(&s3.CopyObjectInput{
+		Bucket:     aws.String(defaultBucket),
+		Key:        aws.String("dst-key"),
+		CopySource: aws.String(copySource),
+	})
+	if err == nil {
+		t.Fatalf("copy object should return error when copy source encoding is invaid")
+	}
+}
+
+func TestCopyObjectAcrossBuckets(t *testing.T) {
+	ts := newTestServer(t, withVersioning())
+	defer ts.Close()
+	svc := ts.s3Client()
+
+	ts.backendCreateBucket("other")
+	first, err := svc.PutObject(&s3.PutObjectInput{
+		Bucket: aws.String(defaultBucket),
+		Key:    aws.String("dir/src key"),
+		Body:   strings.NewReader("first"),
+	})
+	ts.OK(err)
+	ts.OKAll(svc.PutObject(&s3.PutObjectInput{
+		Bucket: aws.String(defaultBucket),
+		Key:    aws.String("dir/src key"),
+		Body:   strings.NewReader("second"),
+	}))
+
+	// The copy is written after the source, which the result must reflect:
+	ts.Advance(time.Hour)
+
+	out, err := svc.CopyObject(&s3.CopyObjectInput{
+		Bucket:     aws.String("other"),
+		Key:        aws.String("dst"),
+		CopySource: aws.String(url.QueryEscape(defaultBucket+"/dir/src key") + "?versionId=" + aws.StringValue(first.VersionId)),
+	})
+	ts.OK(err)
+	if v := aws.StringValue(out.CopySourceVersionId); v != aws.StringValue(first.VersionId) {
+		t.Fatal("unexpected source version", v)
+	}
+	if lm := aws.TimeValue(out.CopyObjectResult.LastModified); !lm.Equal(defaultDate.Add(time.Hour)) {
+		t.Fatal("unexpected last modified", lm)
+	}
+
+	if v := ts.backendGetString("other", "dst", nil); v != "first" {
+		t.Fatal("unexpected copy", v)
+	}
+	head, err := svc.HeadObject(&s3.HeadObjectInput{Bucket: aws.String("other"), Key: aws.String("dst")})
+	ts.OK(err)
+	if !aws.TimeValue(head.LastModified).Equal(aws.TimeValue(out.CopyObjectResult.LastModified)) {
+		t.Fatal("last modified differs from HEAD", head.LastModified, out.CopyObjectResult.LastModified)
+	}
+
+	_, err = svc.CopyObject(&s3.CopyObjectInput{
+		Bucket:     aws.String("other"),
+		Key:        aws.String("dst"),
+		CopySource: aws.String(defaultBucket + "/dir/src%20key?versionId=nope"),
+	})
+	if !s3HasErrorCode(err, gofakes3.ErrNoSuchVersion) {
+		t.Fatal("expected NoSuchVersion, found", err)
+	}
+}
+
+func TestCopyObjectDestinationVersioning(t *testing.T) {
+	for _, tc := range []struct {
+		name   string
+		status gofakes3.VersioningStatus
+	}{
+		{"unversioned", gofakes3.VersioningNone},
+		{"enabled", gofakes3.VersioningEnabled},
+		{"suspended", gofakes3.VersioningSuspended},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			ts := newTestServer(t)
+			defer ts.Close()
+			svc := ts.s3Client()
+			versioned := ts.backend.(gofakes3.VersionedBackend)
+
+			// The source has a null version, stored before versioning was
+			// enabled, and a later version:
+			ts.backendCreateBucket("source")
+			ts.backendPutString("source", "object", nil, "null body")
+			ts.OK(versioned.SetVersioningConfiguration("source", gofakes3.VersioningConfiguration{Status: gofakes3.VersioningEnabled}))
+			latest, err := svc.PutObject(&s3.PutObjectInput{
+				Bucket: aws.String("source"),
+				Key:    aws.String("object"),
+				Body:   strings.NewReader("latest body"),
+			})
+			ts.OK(err)
+			srcVersion := aws.StringValue(latest.VersionId)
+
+			if tc.status == gofakes3.VersioningSuspended {
+				ts.OK(versioned.SetVersioningConfiguration(defaultBucket, gofakes3.VersioningConfiguration{Status: gofakes3.VersioningEnabled}))
+			}
+			if tc.status != gofakes3.VersioningNone {
+				ts.OK(versioned.SetVersioningConfiguration(defaultBucket, gofakes3.VersioningConfiguration{Status: tc.status}))
+			}
+
+			var copied []string
+			for _, src := range []struct {
+				version string
+				body    string
+			}{
+				{"", "latest body"},
+				{srcVersion, "latest body"},
+				{"null", "null body"},
+			} {
+				source := "source/object"
+				if src.version != "" {
+					source += "?versionId=" + src.version
+				}
+				out, err := svc.CopyObject(&s3.CopyObjectInput{
+					Bucket:     aws.String(defaultBucket),
+					Key:        aws.String("copy"),
+					CopySource: aws.String(source),
+				})
+				ts.OK(err)
+
+				expectedSource := src.version
+				if expectedSource == "" {
+					expectedSource = srcVersion
+				}
+				if v := aws.StringValue(out.CopySourceVersionId); v != expectedSource {
+					t.Fatalf("source version %q, expected %q", v, expectedSource)
+				}
+
+				version := aws.StringValue(out.VersionId)
+				switch tc.status {
+				case gofakes3.VersioningNone:
+					if version != "" {
+						t.Fatalf("unexpected version %q in an unversioned bucket", version)
+					}
+				case gofakes3.VersioningSuspended:
+					if version != "null" {
+						t.Fatalf("unexpected version %q in a suspended bucket", version)
+					}
+				case gofakes3.VersioningEnabled:
+					if version == "" || version == "null" || version == srcVersion {
+						t.Fatalf("expected a new version, found %q", version)
+					}
+					for _, v := range copied {
+						if v == version {
+							t.Fatalf("version %q was reused", version)
+						}
+					}
+				}
+				copied = append(copied, version)
+
+				obj, err := svc.GetObject(&s3.GetObjectInput{Bucket: aws.String(defaultBucket), Key: aws.String("copy")})
+				ts.OK(err)
+				body, err := ioutil.ReadAll(obj.Body)
+				obj.Body.Close()
+				ts.OK(err)
+				if string(body) != src.body {
+					t.Fatalf("copy of %q has body %q, expected %q", src.version, body, src.body)
+				}
+				if aws.StringValue(obj.VersionId) != version {
+					t.Fatalf("GET returned version %q, expected %q", aws.StringValue(obj.VersionId), version)
+				}
+			}
+
+			out, err := svc.ListObjectVersions(&s3.ListObjectVersionsInput{
+				Bucket: aws.String(defaultBucket),
+				Prefix: aws.String("copy"),
+			})
+			ts.OK(err)
+			expectedVersions := 1
+			if tc.status == gofakes3.VersioningEnabled {
+				expectedVersions = len(copied)
+			}
+			if len(out.Versions) != expectedVersions {
+				t.Fatalf("found %d versions, expected %d", len(out.Versions), expectedVersions)
+			}
+		})
+	}
+}
+
+func TestCopyObjectConditional(t *testing.T) {
+	ts := newTestServer(t)
+	defer ts.Close()
+	svc := ts.s3Client()
+
+	ts.OKAll(svc.PutObject(&s3.PutObjectInput{
+		Bucket: aws.String(defaultBucket),
+		Key:    aws.String("src"),
+		Body:   strings.NewReader("content"),
+	}))
+	const etag = `"9a0364b9e99bb480dd25e1f0284c8555"` // md5("content")
+	before, after := defaultDate.Add(-time.Hour), defaultDate.Add(time.Hour)
+
+	for idx, tc := range []struct {
+		input *s3.CopyObjectInput
+		ok    bool
+	}{
+		{&s3.CopyObjectInput{CopySourceIfMatch: aws.String(etag)}, true},
+		{&s3.CopyObjectInput{CopySourceIfMatch: aws.String(`"nope"`)}, false},
+		{&s3.CopyObjectInput{CopySourceIfNoneMatch: aws.String(`"nope"`)}, true},
+		{&s3.CopyObjectInput{CopySourceIfNoneMatch: aws.String(etag)}, false},
+		{&s3.CopyObjectInput{CopySourceIfModifiedSince: aws.Time(before)}, true},
+		{&s3.CopyObjectInput{CopySourceIfModifiedSince: aws.Time(after)}, false},
+		{&s3.CopyObjectInput{CopySourceIfUnmodifiedSince: aws.Time(after)}, true},
+		{&s3.CopyObjectInput{CopySourceIfUnmodifiedSince: aws.Time(before)}, false},
+
+		// If-Match takes precedence over If-Unmodified-Since, and
+		// If-None-Match over If-Modified-Since:
+		{&s3.CopyObjectInput{CopySourceIfMatch: aws.String(etag), CopySourceIfUnmodifiedSince: aws.Time(before)}, true},
+		{&s3.CopyObjectInput{CopySourceIfNoneMatch: aws.String(etag), CopySourceIfModifiedSince: aws.Time(before)}, false},
+	} {
+		t.Run(fmt.Sprint(idx), func(t *testing.T) {
+			dst := fmt.Sprintf("dst-%d", idx)
+			tc.input.Bucket = aws.String(defaultBucket)
+			tc.input.Key = aws.String(dst)
+			tc.input.CopySource = aws.String(defaultBucket + "/src")
+
+			_, err := svc.CopyObject(tc.input)
+			if tc.ok {
+				ts.OK(err)
+			} else if !s3HasErrorCode(err, gofakes3.ErrPreconditionFailed) {
+				t.Fatal("expected PreconditionFailed, found", err)
+			}
+			if exists := ts.backendObjectExists(defaultBucket, dst); exists != tc.ok {
+				t.Fatal("unexpected destination", exists)
+			}
+		})
+	}
+}
+
+func TestDeleteBucket(t *testing.T) {
+	t.Run("delete-empty", func(t *testing.T) {
+		ts := newTestServer(t, withoutInitialBuckets())
+		defer ts.Close()
+		svc := ts.s3Client()
+
+		ts.backendCreateBucket("test")
+		ts.OKAll(svc.DeleteBucket(&s3.DeleteBucketInput{
+			Bucket: aws.String("test"),
+		}))
+	})
+
+	t.Run("delete-fails-if-not-empty", func(t *testing.T) {
+		ts := newTestServer(t, withoutInitialBuckets())
+		defer ts.Close()
+		svc := ts.s3Client()
+
+		ts.backendCreateBucket("test")
+		ts.backendPutString("test", "test", nil, "test")
+		_, err := svc.DeleteBucket(&s3.DeleteBucketInput{
+			Bucket: aws.String("test"),
+		})
+		if !hasErrorCode(err, gofakes3.ErrBucketNotEmpty) {
+			t.Fatal("expected ErrBucketNotEmpty, found", err)
+		}
+	})
+}
+
+func TestDeleteMulti(t *testing.T) {
+	deletedKeys := func(rs *s3.DeleteObjectsOutput) []string {
+		deleted := make([]string, len(rs.Deleted))
+		for idx, del := range rs.Deleted {
+			deleted[idx] = *del.Key
+		}
+		sort.Strings(deleted)
+		return deleted
+	}
+
+	assertDeletedKeys := func(t *testing.T, rs *s3.DeleteObjectsOutput, expected ...string) {
+		t.Helper()
+		found := deletedKeys(rs)
+		if !reflect.DeepEqual(found, expected) {
+			t.Fatal("multi deletion failed", found, "!=", expected)
+		}
+	}
+
+	t.Run("one-file", func(t *testing.T) {
+		ts := newTestServer(t)
+		defer ts.Close()
+		svc := ts.s3Client()
+
+		ts.backendPutString(defaultBucket, "foo", nil, "one")
+		ts.backendPutString(defaultBucket, "bar", nil, "two")
+		ts.backendPutString(defaultBucket, "baz", nil, "three")
+
+		rs, err := svc.DeleteObjects(&s3.DeleteObjectsInput{
+			Bucket: aws.String(defaultBucket),
+			Delete: &s3.Delete{
+				Objects: []*s3.ObjectIdentifier{
+					{Key: aws.String("foo")},
+				},
+			},
+		})
+		ts.OK(err)
+		assertDeletedKeys(t, rs, "foo")
+		ts.assertLs(defaultBucket, "", nil, []string{"bar", "baz"})
+	})
+
+	t.Run("multiple-files", func(t *testing.T) {
+		ts := newTestServer(t)
+		defer ts.Close()
+		svc := ts.s3Client()
+
+		ts.backendPutString(defaultBucket, "foo", nil, "one")
+		ts.backendPutString(defaultBucket, "bar", nil, "two")
+		ts.backendPutString(defaultBucket, "baz", nil, "three")
+
+		rs, err := svc.DeleteObjects(&s3.DeleteObjectsInput{
+			Bucket: aws.String(defaultBucket),
+			Delete: &s3.Delete{
+				Objects: []*s3.ObjectIdentifier{
+					{Key: aws.String("bar")},
+					{Key: aws.String("foo")},
+				},
+			},
+		})
+		ts.OK(err)
+		assertDeletedKeys(t, rs, "bar", "foo")
+		ts.assertLs(defaultBucket, "", nil, []string{"baz"})
+	})
+
+	t.Run("versions", func(t *testing.T) {
+		ts := newTestServer(t, withVersioning())
+		defer ts.Close()
+		svc := ts.s3Client()
+
+		put := func(key string) *string {
+			out, err := svc.PutObject(&s3.PutObjectInput{
+				Bucket: aws.String(defaultBucket),
+				Key:    aws.String(key),
+				Body:   strings.NewReader(key),
+			})
+			ts.OK(err)
+			return out.VersionId
+		}
+		fooVersion := put("foo")
+		put("foo")
+		put("bar")
+
+		rs, err := svc.DeleteObjects(&s3.DeleteObjectsInput{
+			Bucket: aws.String(defaultBucket),
+			Delete: &s3.Delete{
+				Objects: []*s3.ObjectIdentifier{
+					{Key: aws.String("foo"), VersionId: fooVersion},
+					{Key: aws.String("bar")},
+				},
+			},
+		})
+		ts.OK(err)
+		if len(rs.Deleted) != 2 || len(rs.Errors) != 0 {
+			t.Fatal("unexpected result", rs)
+		}
+
+		foo, bar := rs.Deleted[0], rs.Deleted[1]
+		if aws.StringValue(foo.VersionId) != aws.StringValue(fooVersion) || aws.BoolValue(foo.DeleteMarker) {
+			t.Fatal("unexpected result for version", foo)
+		}
+		if !aws.BoolValue(bar.DeleteMarker) || aws.StringValue(bar.DeleteMarkerVersionId) == "" {
+			t.Fatal("expected delete marker", bar)
+		}
+		ts.assertLs(defaultBucket, "", nil, []string{"foo"})
+
+		// Deleting the delete marker by its version is reported as such:
+		rs, err = svc.DeleteObjects(&s3.DeleteObjectsInput{
+			Bucket: aws.String(defaultBucket),
+			Delete: &s3.Delete{
+				Objects: []*s3.ObjectIdentifier{{Key: aws.String("bar"), VersionId: bar.DeleteMarkerVersionId}},
+			},
+		})
+		ts.OK(err)
+		if len(rs.Deleted) != 1 || !aws.BoolValue(rs.Deleted[0].DeleteMarker) ||
+			aws.StringValue(rs.Deleted[0].DeleteMarkerVersionId) != aws.StringValue(bar.DeleteMarkerVersionId) {
+			t.Fatal("unexpected result", rs)
+		}
+	})
+
+	t.Run("quiet", func(t *testing.T) {
+		ts := newTestServer(t, withVersioning())
+		defer ts.Close()
+		svc := ts.s3Client()
+
+		locked, err := svc.PutObject(&s3.PutObjectInput{
+			Bucket: aws.String(defaultBucket),
+			Key:    aws.String("locked"),
+			Body:   strings.NewReader("locked"),
+		})
+		ts.OK(err)
+		ts.OKAll(svc.PutObjectLegalHold(&s3.PutObjectLegalHoldInput{
+			Bucket:    aws.String(defaultBucket),
+			Key:       aws.String("locked"),
+			VersionId: locked.VersionId,
+			LegalHold: &s3.ObjectLockLegalHold{Status: aws.String("ON")},
+		}))
+		ts.backendPutString(defaultBucket, "foo", map[string]string{}, "one")
+
+		rs, err := svc.DeleteObjects(&s3.DeleteObjectsInput{
+			Bucket: aws.String(defaultBucket),
+			Delete: &s3.Delete{
+				Quiet: aws.Bool(true),
+				Objects: []*s3.ObjectIdentifier{
+					{Key: aws.String("foo")},
+					{Key: aws.String("locked"), VersionId: locked.VersionId},
+				},
+			},
+		})
+		ts.OK(err)
+		if len(rs.Deleted) != 0 {
+			t.Fatal("unexpected deleted entries in quiet mode", rs.Deleted)
+		}
+		if len(rs.Errors) != 1 || aws.StringValue(rs.Errors[0].Key) != "locked" ||
+			aws.StringValue(rs.Errors[0].VersionId) != aws.StringValue(locked.VersionId) ||
+			aws.StringValue(rs.Errors[0].Code) != string(gofakes3.ErrAccessDenied) {
+			t.Fatal("unexpected errors", rs.Errors)
+		}
+		ts.assertLs(defaultBucket, "", nil, []string{"locked"})
+	})
+
+	t.Run("missing-keys", func(t *testing.T) {
+		ts := newTestServer(t)
+		defer ts.Close()
+		svc := ts.s3Client()
+
+		ts.backendPutString(defaultBucket, "foo", nil, "one")
+
+		rs, err := svc.DeleteObjects(&s3.DeleteObjectsInput{
+			Bucket: aws.String(defaultBucket),
+			Delete: &s3.Delete{
+				Objects: []*s3.ObjectIdentifier{
+					{Key: aws.String("foo")},
+					{Key: aws.String("missing")},
+				},
+			},
+		})
+		ts.OK(err)
+		if len(rs.Errors) != 0 {
+			t.Fatal("unexpected errors", rs.Errors)
+		}
+		assertDeletedKeys(t, rs, "foo", "missing")
+		ts.assertLs(defaultBucket, "", nil, nil)
+	})
+
+	t.Run("missing-versions", func(t *testing.T) {
+		ts := newTestServer(t, withVersioning())
+		defer ts.Close()
+		svc := ts.s3Client()
+
+		out, err := svc.PutObject(&s3.PutObjectInput{
+			Bucket: aws.String(defaultBucket),
+			Key:    aws.String("foo"),
+			Body:   strings.NewReader("foo"),
+		})
+		ts.OK(err)
+		ts.OKAll(svc.DeleteObject(&s3.DeleteObjectInput{
+			Bucket:    aws.String(defaultBucket),
+			Key:       aws.String("foo"),
+			VersionId: out.VersionId,
+		}))
+
+		// A version that no longer exists is reported as deleted, and a key
+		// that has never existed gets a delete marker:
+		rs, err := svc.DeleteObjects(&s3.DeleteObjectsInput{
+			Bucket: aws.String(defaultBucket),
+			Delete: &s3.Delete{
+				Objects: []*s3.ObjectIdentifier{
+					{Key: aws.String("foo"), VersionId: out.VersionId},
+					{Key: aws.String("missing")},
+				},
+			},
+		})
+		ts.OK(err)
+		if len(rs.Deleted) != 2 || len(rs.Errors) != 0 {
+			t.Fatal("unexpected result", rs)
+		}
+		if foo := rs.Deleted[0]; aws.StringValue(foo.VersionId) != aws.StringValue(out.VersionId) || aws.BoolValue(foo.DeleteMarker) {
+			t.Fatal("unexpected result for version", foo)
+		}
+		if missing := rs.Deleted[1]; !aws.BoolValue(missing.DeleteMarker) {
+			t.Fatal("expected delete marker", missing)
+		}
+	})
+
+	t.Run("partial-failure", func(t *testing.T) {
+		backend := &failingDeleteBackend{
+			Backend: s3mem.New(s3mem.WithTimeSource(gofakes3.FixedTimeSource(defaultDate))),
+			failKey: "broken",
+		}
+		ts := newTestServer(t, withBackend(backend))
+		defer ts.Close()
+		svc := ts.s3Client()
+
+		ts.backendPutString(defaultBucket, "foo", nil, "one")
+		ts.backendPutString(defaultBucket, "broken", nil, "two")
+
+		// The Backend fails the whole batch, so each key is deleted on its
+		// own, and only the one that fails is reported as an error:
+		rs, err := svc.DeleteObjects(&s3.DeleteObjectsInput{
+			Bucket: aws.String(defaultBucket),
+			Delete: &s3.Delete{
+				Objects: []*s3.ObjectIdentifier{
+					{Key: aws.String("foo")},
+					{Key: aws.String("broken")},
+					{Key: aws.String("missing")},
+					{Key: aws.String("foo"), VersionId: aws.String("v1")},
+				},
+			},
+		})
+		ts.OK(err)
+		assertDeletedKeys(t, rs, "foo", "missing")
+
+		errs := map[string]string{}
+		for _, e := range rs.Errors {
+			if aws.StringValue(e.Message) == "" {
+				t.Fatal("error has no message", e)
+			}
+			errs[aws.StringValue(e.Key)] = aws.StringValue(e.Code)
+		}
+		if exp := map[string]string{
+			"broken": string(gofakes3.ErrInternal),
+			"foo":    string(gofakes3.ErrNotImplemented),
+		}; !reflect.DeepEqual(errs, exp) {
+			t.Fatalf("errors:\nexp: %v\ngot: %v", exp, errs)
+		}
+		ts.assertLs(defaultBucket, "", nil, []string{"broken"})
+	})
+
+	t.Run("too-many-keys", func(t *testing.T) {
+		ts := newTestServer(t)
+		defer ts.Close()
+		svc := ts.s3Client()
+
+		objects := make([]*s3.ObjectIdentifier, gofakes3.MaxDeleteObjects+1)
+		for i := range objects {
+			objects[i] = &s3.ObjectIdentifier{Key: aws.String(fmt.Sprintf("key%d", i))}
+		}
+		_, err := svc.DeleteObjects(&s3.DeleteObjectsInput{
+			Bucket: aws.String(defaultBucket),
+			Delete: &s3.Delete{Objects: objects},
+		})
+		if !s3HasErrorCode(err, gofakes3.ErrMalformedXML) {
+			t.Fatal("expected MalformedXML, found", err)
+		}
+	})
+}
+
+// failingDeleteBackend fails DeleteMulti, and fails DeleteObject for failKey.
+type failingDeleteBackend struct {
+	gofakes3.Backend
+	failKey string
+}
+
+func (b *failingDeleteBackend) DeleteMulti(bucketName string, objects ...string) (gofakes3.MultiDeleteResult, error) {
+	return gofakes3.MultiDeleteResult{}, fmt.Errorf("delete multi failed")
+}
+
+func (b *failingDeleteBackend) DeleteObject(bucketName, objectName string) (gofakes3.ObjectDeleteResult, error) {
+	if objectName == b.failKey {
+		return gofakes3.ObjectDeleteResult{}, fmt.Errorf("delete failed")
+	}
+	return b.Backend.DeleteObject(bucketName, objectName)
+}
+
+func TestGetBucketLocation(t *testing.T) {
+	ts := newTestServer(t)
+	defer ts.Close()
+	svc := ts.s3Client()
+
+	ts.backendPutString(defaultBucket, "foo", nil, "one")
+
+	out, err := svc.GetBucketLocation(&s3.GetBucketLocationInput{
+		Bucket: aws.String(defaultBucket),
+	})
+	if err != nil {
+		t.Fatal("get-bucket-location-failed", err)
+	}
+
+	if out.LocationConstraint != nil {
+		t.Fatal("location-constraint-not-empty", *out.LocationConstraint)
+	}
+}
+
+func TestGetObjectRange(t *testing.T) {
+	assertRange := func(ts *testServer, key string, hdr string, expected []byte, fail bool) {
+		ts.Helper()
+		svc := ts.s3Client()
+		obj, err := svc.GetObject(&s3.GetObjectInput{
+			Bucket: aws.String(defaultBucket),
+			Key:    aws.String(key),
+			Range:  aws.String(hdr),
+		})
+		if fail != (err != nil) {
+			ts.Fatal("failure expected:", fail, "found:", err)
+		}
+		if !fail {
+			ts.OK(err)
+			defer obj.Body.Close()
+
+			out, err := ioutil.ReadAll(obj.Body)
+			ts.OK(err)
+			if !bytes.Equal(expected, out) {
+				ts.Fatal("range failed", hdr, err)
+			}
+		}
+	}
+
+	in := randomFileBody(1024)
+
+	for idx, tc := range []struct {
+		hdr      string
+		expected []byte
+		fail     bool
+	}{
+		{"bytes=0-", in, false},
+		{"bytes=1-", in[1:], false},
+		{"bytes=0-0", in[:1], false},
+		{"bytes=0-1", in[:2], false},
+		{"bytes=1023-1023", in[1023:1024], false},
+
+		// if the requested end is beyond the real end, returns "remainder of the representation"
+		{"bytes=1023-1025", in[1023:1024], false},
+
+		// if the requested start is beyond the real end, it should fail
+		{"bytes=1024-1024", []byte{}, true},
+
+		// suffix-byte-range-spec:
+		{"bytes=-0", []byte{}, true},
+		{"bytes=-1", in[1023:1024], false},
+		{"bytes=-1024", in, false},
+		{"bytes=-1025", in, false},
+	} {
+		t.Run(fmt.Sprintf("%d/%s", idx, tc.hdr), func(t *testing.T) {
+			ts := newTestServer(t)
+			defer ts.Close()
+
+			ts.backendPutBytes(defaultBucket, "foo", nil, in)
+			assertRange(ts, "foo", tc.hdr, tc.expected, tc.fail)
+		})
+	}
+}
+
+func TestGetObjectRangeInvalid(t *testing.T) {
+	assertRangeInvalid := func(ts *testServer, key string, hdr string) {
+		svc := ts.s3Client()
+		_, err := svc.GetObject(&s3.GetObjectInput{
+			Bucket: aws.String(defaultBucket),
+			Key:    aws.String(key),
+			Range:  aws.String(hdr),
+		})
+		if !hasErrorCode(err, gofakes3.ErrInvalidRange) {
+			ts.Fatal("expected ErrInvalidRange, found", err)
+		}
+	}
+
+	in := randomFileBody(1024)
+
+	for idx, tc := range []struct {
+		hdr string
+	}{
+		{"boats=0-0"},
+		{"bytes="},
+	} {
+		t.Run(fmt.Sprintf("%d/%s", idx, tc.hdr), func(t *testing.T) {
+			ts := newTestServer(t)
+			defer ts.Close()
+
+			ts.backendPutBytes(defaultBucket, "foo", nil, in)
+			assertRangeInvalid(ts, "foo", tc.hdr)
+		})
+	}
+}
+
+func TestGetObjectRangeHeaders(t *testing.T) {
+	ts := newTestServer(t)
+	defer ts.Close()
+
+	in := randomFileBody(1024)
+	ts.backendPutBytes(defaultBucket, "foo", nil, in)
+
+	for _, tc := range []struct {
+		hdr          string
+		status       int
+		contentRange string
+		body         []byte
+	}{
+		{"bytes=0-9", http.StatusPartialContent, "bytes 0-9/1024", in[:10]},
+		{"bytes=1023-1023", http.StatusPartialContent, "bytes 1023-1023/1024", in[1023:]},
+		{"bytes=1000-2000", http.StatusPartialContent, "bytes 1000-1023/1024", in[1000:]},
+		{"bytes=500-", http.StatusPartialContent, "bytes 500-1023/1024", in[500:]},
+		{"bytes=-500", http.StatusPartialContent, "bytes 524-1023/1024", in[524:]},
+		{"bytes=-2000", http.StatusPartialContent, "bytes 0-1023/1024", in},
+		{"bytes=1024-", http.StatusRequestedRangeNotSatisfiable, "bytes */1024", nil},
+		{"bytes=2000-3000", http.StatusRequestedRangeNotSatisfiable, "bytes */1024", nil},
+		{"bytes=-0", http.StatusRequestedRangeNotSatisfiable, "bytes */1024", nil},
+	} {
+		t.Run(tc.hdr, func(t *testing.T) {
+			rq, err := http.NewRequest("GET", ts.url("/"+defaultBucket+"/foo"), nil)
+			ts.OK(err)
+			rq.Header.Set("Range", tc.hdr)
+			rs, err := httpClient().Do(rq)
+			ts.OK(err)
+			defer rs.Body.Close()
+
+			if rs.StatusCode != tc.status {
+				t.Fatal("unexpected status", rs.StatusCode, "!=", tc.status)
+			}
+			if v := rs.Header.Get("Content-Range"); v != tc.contentRange {
+				t.Fatal("unexpected Content-Range", v, "!=", tc.contentRange)
+			}
+			if tc.body == nil {
+				return
+			}
+			if rs.ContentLength != int64(len(tc.body)) {
+				t.Fatal("unexpected Content-Length", rs.ContentLength, "!=", len(tc.body))
+			}
+			body, err := ioutil.ReadAll(rs.Body)
+			ts.OK(err)
+			if !bytes.Equal(body, tc.body) {
+				t.Fatal("body mismatch")
+			}
+		})
+	}
+}
+
+func TestGetObjectMultipleRanges(t *testing.T) {
+	ts := newTestServer(t)
+	defer ts.Close()
+
+	in := randomFileBody(1024)
+	ts.backendPutBytes(defaultBucket, "foo", map[string]string{"Content-Type": "text/plain"}, in)
+
+	get := func(hdr string) *http.Response {
+		t.Helper()
+		rq, err := http.NewRequest("GET", ts.url("/"+defaultBucket+"/foo"), nil)
+		ts.OK(err)
+		rq.Header.Set("Range", hdr)
+		rs, err := httpClient().Do(rq)
+		ts.OK(err)
+		return rs
+	}
+
+	t.Run("multiple", func(t *testing.T) {
+		rs := get("bytes=0-9,20-29,-5,2000-3000")
+		defer rs.Body.Close()
+
+		if rs.StatusCode != http.StatusPartialContent {
+			t.Fatal("unexpected status", rs.StatusCode)
+		}
+		mediaType, params, err := mime.ParseMediaType(rs.Header.Get("Content-Type"))
+		ts.OK(err)
+		if mediaType != "multipart/byteranges" {
+			t.Fatal("unexpected content type", mediaType)
+		}
+
+		// The unsatisfiable range is dropped:
+		expected := []struct {
+			contentRange string
+			body         []byte
+		}{
+			{"bytes 0-9/1024", in[0:10]},
+			{"bytes 20-29/1024", in[20:30]},
+			{"bytes 1019-1023/1024", in[1019:]},
+		}
+
+		mr := multipart.NewReader(rs.Body, params["boundary"])
+		for idx, exp := range expected {
+			part, err := mr.NextPart()
+			ts.OK(err)
+			if v := part.Header.Get("Content-Range"); v != exp.contentRange {
+				t.Fatal("part", idx, "unexpected Content-Range", v, "!=", exp.contentRange)
+			}
+			if v := part.Header.Get("Content-Type"); v != "text/plain" {
+				t.Fatal("part", idx, "unexpected Content-Type", v)
+			}
+			body, err := ioutil.ReadAll(part)
+			ts.OK(err)
+			if !bytes.Equal(body, exp.body) {
+				t.Fatal("part", idx, "body mismatch")
+			}
+		}
+		if _, err := mr.NextPart(); err != io.EOF {
+			t.Fatal("expected EOF, found", err)
+		}
+	})
+
+	t.Run("unsatisfiable", func(t *testing.T) {
+		for _, hdr := range []string{"bytes=1024-", "bytes=1024-1030,2000-", "boats=0-0"} {
+			rs := get(hdr)
+			rs.Body.Close()
+			if rs.StatusCode != http.StatusRequestedRangeNotSatisfiable {
+				t.Fatal(hdr, "unexpected status", rs.StatusCode)
+			}
+			if v := rs.Header.Get("Content-Range"); v != "bytes */1024" {
+				t.Fatal(hdr, "unexpected Content-Range", v)
+			}
+		}
+	})
+}
+
+func TestSSECustomerKey(t *testing.T) {
+	ts := newTestServer(t)
+	defer ts.Close()
+	svc := ts.s3Client()
+
+	// The SDK refuses to send SSE-C keys to a plain HTTP endpoint:
+	svc.Handlers.Validate.Clear()
+
+	key := strings.Repeat("k", 32)
+	otherKey := strings.Repeat("o", 32)
+
+	_, err := svc.PutObject(&s3.PutObjectInput{
+		Bucket:               aws.String(defaultBucket),
+		Key:                  aws.String("foo"),
+		Body:                 strings.NewReader("hello"),
+		SSECustomerAlgorithm: aws.String("AES256"),
+		SSECustomerKey:       aws.String(key),
+	})
+	ts.OK(err)
+
+	obj, err := ts.backend.HeadObject(defaultBucket, "foo")
+	ts.OK(err)
+	for k := range obj.Metadata {
+		if strings.EqualFold(k, "X-Amz-Server-Side-Encryption-Customer-Key") {
+			t.Fatal("customer key should not be stored")
+		}
+	}
+
+	out, err := svc.GetObject(&s3.GetObjectInput{
+		Bucket:               aws.String(defaultBucket),
+		Key:                  aws.String("foo"),
+		SSECustomerAlgorithm: aws.String("AES256"),
+		SSECustomerKey:       aws.String(key),
+	})
+	ts.OK(err)
+	defer out.Body.Close()
+	if v := aws.StringValue(out.SSECustomerAlgorithm); v != "AES256" {
+		t.Fatal("unexpected algorithm", v)
+	}
+	if v := aws.StringValue(out.SSECustomerKeyMD5); v != hashMD5Bytes([]byte(key)).Base64() {
+		t.Fatal("unexpected key MD5", v)
+	}
+
+	head, err := svc.HeadObject(&s3.HeadObjectInput{
+		Bucket:               aws.String(defaultBucket),
+		Key:                  aws.String("foo"),
+		SSECustomerAlgorithm: aws.String("AES256"),
+		SSECustomerKey:       aws.String(key),
+	})
+	ts.OK(err)
+	if v := aws.StringValue(head.SSECustomerAlgorithm); v != "AES256" {
+		t.Fatal("unexpected algorithm", v)
+	}
+
+	_, err = svc.GetObject(&s3.GetObjectInput{
+		Bucket: aws.String(defaultBucket),
+		Key:    aws.String("foo"),
+	})
+	if !s3HasErrorCode(err, gofakes3.ErrInvalidRequest) {
+		t.Fatal("expected InvalidRequest, found", err)
+	}
+
+	_, err = svc.GetObject(&s3.GetObjectInput{
+		Bucket:               aws.String(defaultBucket),
+		Key:                  aws.String("foo"),
+		SSECustomerAlgorithm: aws.String("AES256"),
+		SSECustomerKey:       aws.String(otherKey),
+	})
+	if !s3HasErrorCode(err, gofakes3.ErrInvalidRequest) {
+		t.Fatal("expected InvalidRequest, found", err)
+	}
+
+	_, err = svc.HeadObject(&s3.HeadObjectInput{
+		Bucket: aws.String(defaultBucket),
+		Key:    aws.String("foo"),
+	})
+	if rerr, ok := err.(awserr.RequestFailure); !ok || rerr.StatusCode() != http.StatusBadRequest {
+		t.Fatal("expected 400, found", err)
+	}
+
+	_, err = svc.PutObject(&s3.PutObjectInput{
+		Bucket:               aws.String(defaultBucket),
+		Key:                  aws.String("bar"),
+		Body:                 strings.NewReader("hello"),
+		SSECustomerAlgorithm: aws.String("ROT13"),
+		SSECustomerKey:       aws.String(key),
+	})
+	if !s3HasErrorCode(err, gofakes3.ErrInvalidArgument) {
+		t.Fatal("expected InvalidArgument, found", err)
+	}
+}
+
+func TestGetObjectIfNoneMatch(t *testing.T) {
+	objectKey := "foo"
+	assertModified := func(ts *testServer, ifNoneMatch string, shouldModify bool) {
+		svc := ts.s3Client()
+		input := s3.GetObjectInput{
+			Bucket: aws.String(defaultBucket),
+			Key:    aws.String(objectKey),
+		}
+		if ifNoneMatch != "" {
+			input.IfNoneMatch = aws.String(ifNoneMatch)
+		}
+
+		_, err := svc.GetObject(&input)
+		modified := true
+		if err != nil {
+			if !s3HasErrorCode(err, gofakes3.ErrNotModified) {
+				ts.Fatal("get-object-failed", err)
+			}
+
+			modified = false
+		}
+
+		if modified != shouldModify {
+			ts.Fatal("expected modified", shouldModify, "found", modified)
+		}
+	}
+
+	for idx, tc := range []struct {
+		ifNoneMatch  string
+		shouldModify bool
+	}{
+		{shouldModify: true},
+		{ifNoneMatch: `"5d41402abc4b2a76b9719d911017c592"`, shouldModify: false}, // md5("hello")
+		{ifNoneMatch: `"notTheSameEtag"`, shouldModify: true},
+	} {
+		t.Run(fmt.Sprintf("%d/%s", idx, tc.ifNoneMatch), func(t *testing.T) {
+			ts := newTestServer(t)
+			defer ts.Close()
+
+			ts.backendPutString(defaultBucket, objectKey, nil, "hello")
+
+			assertModified(ts, tc.ifNoneMatch, tc.shouldModify)
+		})
+	}
+}
+
+func TestGetObjectConditional(t *testing.T) {
+	const etag = `"5d41402abc4b2a76b9719d911017c592"` // md5("hello")
+	before, after := defaultDate.Add(-time.Hour), defaultDate.Add(time.Hour)
+
+	for idx, tc := range []struct {
+		ifMatch, ifNoneMatch               string
+		ifModifiedSince, ifUnmodifiedSince time.Time
+		code                               gofakes3.ErrorCode
+	}{
+		{code: gofakes3.ErrNone},
+		{ifMatch: etag, code: gofakes3.ErrNone},
+		{ifMatch: "W/" + etag, code: gofakes3.ErrPreconditionFailed},
+		{ifMatch: `"nope", ` + etag, code: gofakes3.ErrNone},
+		{ifMatch: "*", code: gofakes3.ErrNone},
+		{ifMatch: `"nope"`, code: gofakes3.ErrPreconditionFailed},
+		{ifUnmodifiedSince: after, code: gofakes3.ErrNone},
+		{ifUnmodifiedSince: defaultDate, code: gofakes3.ErrNone},
+		{ifUnmodifiedSince: before, code: gofakes3.ErrPreconditionFailed},
+		{ifMatch: etag, ifUnmodifiedSince: before, code: gofakes3.ErrNone}, // If-Match takes precedence
+
+		{ifNoneMatch: "W/" + etag, code: gofakes3.ErrNotModified},
+		{ifNoneMatch: "*", code: gofakes3.ErrNotModified},
+		{ifNoneMatch: `"nope"`, code: gofakes3.ErrNone},
+		{ifModifiedSince: before, code: gofakes3.ErrNone},
+		{ifModifiedSince: defaultDate, code: gofakes3.ErrNotModified},
+		{ifModifiedSince: after, code: gofakes3.ErrNotModified},
+		{ifNoneMatch: `"nope"`, ifModifiedSince: after, code: gofakes3.ErrNone}, // If-None-Match takes precedence
+		{ifMatch: `"nope"`, ifNoneMatch: etag, code: gofakes3.ErrPreconditionFailed},
+	} {
+		t.Run(fmt.Sprintf("%d", idx), func(t *testing.T) {
+			ts := newTestServer(t)
+			defer ts.Close()
+			svc := ts.s3Client()
+
+			// Put via the API rather than the backend so Last-Modified is set:
+			_, err := svc.PutObject(&s3.PutObjectInput{
+				Bucket: aws.String(defaultBucket),
+				Key:    aws.String("foo"),
+				Body:   strings.NewReader("hello"),
+			})
+			ts.OK(err)
+
+			get := &s3.GetObjectInput{Bucket: aws.String(defaultBucket), Key: aws.String("foo")}
+			head := &s3.HeadObjectInput{Bucket: aws.String(defaultBucket), Key: aws.String("foo")}
+			if tc.ifMatch != "" {
+				get.IfMatch, head.IfMatch = aws.String(tc.ifMatch), aws.String(tc.ifMatch)
+			}
+			if tc.ifNoneMatch != "" {
+				get.IfNoneMatch, head.IfNoneMatch = aws.String(tc.ifNoneMatch), aws.String(tc.ifNoneMatch)
+			}
+			if !tc.ifModifiedSince.IsZero() {
+				get.IfModifiedSince, head.IfModifiedSince = aws.Time(tc.ifModifiedSince), aws.Time(tc.ifModifiedSince)
+			}
+			if !tc.ifUnmodifiedSince.IsZero() {
+				get.IfUnmodifiedSince, head.IfUnmodifiedSince = aws.Time(tc.ifUnmodifiedSince), aws.Time(tc.ifUnmodifiedSince)
+			}
+
+			assertCode := func(method string, err error) {
+				t.Helper()
+				if tc.code == gofakes3.ErrNone && err != nil {
+					t.Fatal(method, "failed:", err)
+				} else if tc.code != gofakes3.ErrNone && !s3HasErrorCode(err, tc.code) {
+					t.Fatal(method, "expected", tc.code, "found", err)
+				}
+			}
+
+			_, err = svc.GetObject(get)
+			assertCode("GET", err)
+			_, err = svc.HeadObject(head)
+			assertCode("HEAD", err)
+		})
+	}
+}
+
+func TestCreateObjectBrowserUpload(t *testing.T) {
+	addFile := func(tt gofakes3.TT, w *multipart.Writer, object string, b []byte) {
+		tt.Helper()
+		tt.OK(w.WriteField("key", object))
+
+		mw, err := w.CreateFormFile("file", "upload")
+		tt.OK(err)
+		n, err := mw.Write(b)
+		if n != len(b) {
+			tt.Fatal("len mismatch", n, "!=", len(b))
+		}
+		tt.OK(err)
+	}
+
+	upload := func(ts *testServer, bucket string, w *multipart.Writer, body io.Reader) (*http.Response, error) {
+		w.Close()
+		req, err := http.NewRequest("POST", ts.url("/"+bucket), body)
+		ts.OK(err)
+		req.Header.Set("Content-Type", w.FormDataContentType())
+		return httpClient().Do(req)
+	}
+
+	assertUpload := func(ts *testServer, bucket string, w *multipart.Writer, body io.Reader, etag string) {
+		res, err := upload(ts, bucket, w, body)
+		ts.OK(err)
+		if res.StatusCode != http.StatusOK {
+			ts.Fatal("bad status", res.StatusCode, tryDumpResponse(res, true))
+		}
+		if etag != "" && res.Header.Get("ETag") != etag {
+			ts.Fatal("bad etag", res.Header.Get("ETag"), etag)
+		}
+	}
+
+	assertUploadFails := func(ts *testServer, bucket string, w *multipart.Writer, body io.Reader, expectedCode gofakes3.ErrorCode) {
+		res, err := upload(ts, bucket, w, body)
+		ts.OK(err)
+		if res.StatusCode != expectedCode.Status() {
+			ts.Fatal("bad status", res.StatusCode, "!=", expectedCode.Status())
+		}
+		defer res.Body.Close()
+		var errResp gofakes3.ErrorResponse
+		dec := xml.NewDecoder(res.Body)
+		ts.OK(dec.Decode(&errResp))
+
+		if errResp.Code != expectedCode {
+			ts.Fatal("bad code", errResp.Code, "!=", expectedCode)
+		}
+	}
+
+	t.Run("single-upload", func(t *testing.T) {
+		ts := newTestServer(t)
+		defer ts.Close()
+		var b bytes.Buffer
+		w := multipart.NewWriter(&b)
+		addFile(ts.TT, w, "yep", []byte("stuff"))
+		assertUpload(ts, defaultBucket, w, &b, `"c13d88cb4cb02003daedb8a84e5d272a"`)
+		ts.assertObject(defaultBucket, "yep", nil, "stuff")
+	})
+
+	t.Run("multiple-files-fails", func(t *testing.T) {
+		ts := newTestServer(t)
+		defer ts.Close()
+		var b bytes.Buffer
+		w := multipart.NewWriter(&b)
+		addFile(ts.TT, w, "yep", []byte("stuff"))
+		addFile(ts.TT, w, "nup", []byte("bork"))
+		assertUploadFails(ts, defaultBucket, w, &b, gofakes3.ErrIncorrectNumberOfFilesInPostRequest)
+	})
+
+	t.Run("key-too-large", func(t *testing.T) {
+		ts := newTestServer(t)
+		defer ts.Close()
+		var b bytes.Buffer
+		w := multipart.NewWriter(&b)
+		addFile(ts.TT, w, strings.Repeat("a", gofakes3.KeySizeLimit+1), []byte("yep"))
+		assertUploadFails(ts, defaultBucket, w, &b, gofakes3.ErrKeyTooLong)
+	})
+}
+
+func TestVersioning(t *testing.T) {
+	assertVersioning := func(ts *testServer, mfa string, status string) {
+		ts.Helper()
+		svc := ts.s3Client()
+		bv, err := svc.GetBucketVersioning(&s3.GetBucketVersioningInput{Bucket: aws.String(defaultBucket)})
+		ts.OK(err)
+		if aws.StringValue(bv.MFADelete) != mfa {
+			ts.Fatal("unexpected MFADelete")
+		}
+		if aws.StringValue(bv.Status) != status {
+			ts.Fatalf("unexpected Status %q, expected %q", aws.StringValue(bv.Status), status)
+		}
+	}
+
+	setVersioning := func(ts *testServer, status gofakes3.VersioningStatus) {
+		ts.Helper()
+		svc := ts.s3Client()
+		ts.OKAll(svc.PutBucketVersioning(&s3.PutBucketVersioningInput{
+			Bucket: aws.String(defaultBucket),
+			VersioningConfiguration: &s3.VersioningConfiguration{
+				Status: aws.String(string(status)),
+			},
+		}))
+	}
+
+	t.Run("", func(t *testing.T) {
+		ts := newTestServer(t)
+		defer ts.Close()
+
+		// Bucket that has never been versioned should return empty strings:
+		assertVersioning(ts, "", "")
+	})
+
+	t.Run("enable", func(t *testing.T) {
+		ts := newTestServer(t)
+		defer ts.Close()
+
+		setVersioning(ts, "Enabled")
+		assertVersioning(ts, "", "Enabled")
+	})
+
+	t.Run("suspend", func(t *testing.T) {
+		ts := newTestServer(t)
+		defer ts.Close()
+
+		setVersioning(ts, gofakes3.VersioningSuspended)
+		assertVersioning(ts, "", "")
+
+		setVersioning(ts, gofakes3.VersioningEnabled)
+		setVersioning(ts, gofakes3.VersioningSuspended)
+		assertVersioning(ts, "", "Suspended")
+	})
+
+	t.Run("no-versioning-suspend", func(t *testing.T) {
+		ts := newTestServer(t, withFakerOptions(
+			gofakes3.WithoutVersioning(),
+		))
+		defer ts.Close()
+
+		setVersioning(ts, "Suspended")
+		assertVersioning(ts, "", "")
+	})
+
+	t.Run("no-versioning-enable", func(t *testing.T) {
+		ts := newTestServer(t, withFakerOptions(
+			gofakes3.WithoutVersioning(),
+		))
+		defer ts.Close()
+
+		svc := ts.s3Client()
+		_, err := svc.PutBucketVersioning(&s3.PutBucketVersioningInput{
+			Bucket: aws.String(defaultBucket),
+			VersioningConfiguration: &s3.VersioningConfiguration{
+				Status: aws.String("Enabled"),
+			},
+		})
+		if !hasErrorCode(err, gofakes3.ErrNotImplemented) {
+			ts.Fatal("expected ErrNotImplemented, found", err)
+		}
+	})
+
+	t.Run("toggle", func(t *testing.T) {
+		ts := newTestServer(t)
+		defer ts.Close()
+		svc := ts.s3Client()
+
+		putVersion := func() string {
+			ts.Helper()
+			out, err := svc.PutObject(&s3.PutObjectInput{
+				Bucket: aws.String(defaultBucket),
+				Key:    aws.String("object"),
+				Body:   bytes.NewReader([]byte("hello")),
+			})
+			ts.OK(err)
+			return aws.StringValue(out.VersionId)
+		}
+
+		for i := 0; i < 2; i++ {
+			setVersioning(ts, gofakes3.VersioningEnabled)
+			assertVersioning(ts, "", "Enabled")
+			if v := putVersion(); v == "" || v == "null" {
+				t.Fatalf("unexpected version %q while versioning is enabled", v)
+			}
+
+			setVersioning(ts, gofakes3.VersioningSuspended)
+			assertVersioning(ts, "", "Suspended")
+			if v := putVersion(); v != "null" {
+				t.Fatalf("unexpected version %q while versioning is suspended", v)
+			}
+		}
+	})
+
+	t.Run("mfa-delete", func(t *testing.T) {
+		ts := newTestServer(t)
+		defer ts.Close()
+		svc := ts.s3Client()
+
+		setVersioning(ts, gofakes3.VersioningEnabled)
+
+		// Leaving out the Status leaves versioning as it was:
+		ts.OKAll(svc.PutBucketVersioning(&s3.PutBucketVersioningInput{
+			Bucket: aws.String(defaultBucket),
+			VersioningConfiguration: &s3.VersioningConfiguration{
+				MFADelete: aws.String("Disabled"),
+			},
+		}))
+		assertVersioning(ts, "Disabled", "Enabled")
+
+		setVersioning(ts, gofakes3.VersioningSuspended)
+		assertVersioning(ts, "Disabled", "Suspended")
+
+		_, err := svc.PutBucketVersioning(&s3.PutBucketVersioningInput{
+			Bucket: aws.String(defaultBucket),
+			VersioningConfiguration: &s3.VersioningConfiguration{
+				Status: aws.String("Nope"),
+			},
+		})
+		if err == nil {
+			t.Fatal("expected an invalid Status to be rejected")
+		}
+		assertVersioning(ts, "Disabled", "Suspended")
+	})
+
+	t.Run("response", func(t *testing.T) {
+		ts := newTestServer(t)
+		defer ts.Close()
+
+		// A bucket that has never been versioned has an empty
+		// VersioningConfiguration element:
+		rs, err := httpClient().Get(ts.url(defaultBucket + "?versioning"))
+		ts.OK(err)
+		defer rs.Body.Close()
+		b, err := ioutil.ReadAll(rs.Body)
+		ts.OK(err)
+		expected := xml.Header + `<VersioningConfiguration xmlns="http://s3.amazonaws.com/doc/2006-03-01/"></VersioningConfiguration>`
+		if string(b) != expected {
+			t.Fatalf("unexpected response:\n%s", b)
+		}
+	})
+}
+
+func TestMFADelete(t *testing.T) {
+	const serial = "arn:aws:iam::123456789012:mfa/user"
+	ts := newTestServer(t, withFakerOptions(gofakes3.WithMFA(serial, func(token string) bool {
+		return token == "123456"
+	})))
+	defer ts.Close()
+	svc := ts.s3Client()
+
+	putVersioning := func(status, mfaDelete, mfa string) error {
+		t.Helper()
+		config := &s3.VersioningConfiguration{}
+		if status != "" {
+			config.Status = aws.String(status)
+		}
+		if mfaDelete != "" {
+			config.MFADelete = aws.String(mfaDelete)
+		}
+		in := &s3.PutBucketVersioningInput{Bucket: aws.String(defaultBucket), VersioningConfiguration: config}
+		if mfa != "" {
+			in.MFA = aws.String(mfa)
+		}
+		_, err := svc.PutBucketVersioning(in)
+		return err
+	}
+	deleteVersion := func(version, mfa string) error {
+		t.Helper()
+		in := &s3.DeleteObjectInput{Bucket: aws.String(defaultBucket), Key: aws.String("object"), VersionId: aws.String(version)}
+		if mfa != "" {
+			in.MFA = aws.String(mfa)
+		}
+		_, err := svc.DeleteObject(in)
+		return err
+	}
+	putObject := func() string {
+		t.Helper()
+		out, err := svc.PutObject(&s3.PutObjectInput{
+			Bucket: aws.String(defaultBucket),
+			Key:    aws.String("object"),
+			Body:   bytes.NewReader([]byte("hello")),
+		})
+		ts.OK(err)
+		return aws.StringValue(out.VersionId)
+	}
+
+	// Enabling MFA Delete needs MFA:
+	if err := putVersioning("Enabled", "Enabled", ""); !hasErrorCode(err, gofakes3.ErrAccessDenied) {
+		t.Fatal("expected ErrAccessDenied, found", err)
+	}
+	if err := putVersioning("Enabled", "Enabled", serial+" 000000"); !hasErrorCode(err, gofakes3.ErrAccessDenied) {
+		t.Fatal("expected ErrAccessDenied, found", err)
+	}
+	if err := putVersioning("Enabled", "Enabled", "123456"); !hasErrorCode(err, gofakes3.ErrInvalidArgument) {
+		t.Fatal("expected ErrInvalidArgument, found", err)
+	}
+	ts.OK(putVersioning("Enabled", "Enabled", serial+" 123456"))
+
+	config, err := svc.GetBucketVersioning(&s3.GetBucketVersioningInput{Bucket: aws.String(defaultBucket)})
+	ts.OK(err)
+	if aws.StringValue(config.MFADelete) != "Enabled" || aws.StringValue(config.Status) != "Enabled" {
+		t.Fatal("unexpected configuration", config)
+	}
+
+	// Deleting a version needs MFA, but deleting the object does not:
+	first, second := putObject(), putObject()
+	if err := deleteVersion(first, ""); !hasErrorCode(err, gofakes3.ErrAccessDenied) {
+		t.Fatal("expected ErrAccessDenied, found", err)
+	}
+	if err := deleteVersion(first, "nope 123456"); !hasErrorCode(err, gofakes3.ErrAccessDenied) {
+		t.Fatal("expected ErrAccessDenied, found", err)
+	}
+	ts.OK(deleteVersion(first, serial+" 123456"))
+	ts.OKAll(svc.DeleteObject(&s3.DeleteObjectInput{Bucket: aws.String(defaultBucket), Key: aws.String("object")}))
+
+	out, err := svc.DeleteObjects(&s3.DeleteObjectsInput{
+		Bucket: aws.String(defaultBucket),
+		Delete: &s3.Delete{Objects: []*s3.ObjectIdentifier{
+			{Key: aws.String("object"), VersionId: aws.String(second)},
+		}},
+	})
+	ts.OK(err)
+	if len(out.Errors) != 1 || aws.StringValue(out.Errors[0].Code) != string(gofakes3.ErrAccessDenied) {
+		t.Fatal("unexpected result", out)
+	}
+
+	// Changing the configuration needs MFA once MFA Delete is enabled:
+	if err := putVersioning("Suspended", "", ""); !hasErrorCode(err, gofakes3.ErrAccessDenied) {
+		t.Fatal("expected ErrAccessDenied, found", err)
+	}
+	ts.OK(putVersioning("Enabled", "Disabled", serial+" 123456"))
+	ts.OK(putVersioning("Suspended", "", ""))
+	ts.OK(deleteVersion(second, ""))
+}
+
+func TestMFADeleteWithoutDevice(t *testing.T) {
+	ts := newTestServer(t)
+	defer ts.Close()
+	svc := ts.s3Client()
+
+	_, err := svc.PutBucketVersioning(&s3.PutBucketVersioningInput{
+		Bucket: aws.String(defaultBucket),
+		MFA:    aws.String("serial 123456"),
+		VersioningConfiguration: &s3.VersioningConfiguration{
+			Status:    aws.String("Enabled"),
+			MFADelete: aws.String("Enabled"),
+		},
+	})
+	if !hasErrorCode(err, gofakes3.ErrNotImplemented) {
+		t.Fatal("expected ErrNotImplemented, found", err)
+	}
+}
+
+func TestObjectVersions(t *testing.T) {
+	create := func(ts *testServer, bucket, key string, contents []byte, version string) {
+		ts.Helper()
+		svc := ts.s3Client()
+		out, err := svc.PutObject(&s3.PutObjectInput{
+			Bucket: aws.String(bucket),
+			Key:    aws.String(key),
+			Body:   bytes.NewReader(contents),
+		})
+		ts.OK(err)
+		if aws.StringValue(out.VersionId) != version {
+			t.Fatal("version ID mismatch. found:", aws.StringValue(out.VersionId), "expected:", version)
+		}
+	}
+
+	get := func(ts *testServer, bucket, key string, contents []byte, version string) {
+		ts.Helper()
+		svc := ts.s3Client()
+		input := &s3.GetObjectInput{
+			Bucket: aws.String(bucket),
+			Key:    aws.String(key),
+		}
+		if version != "" {
+			input.VersionId = aws.String(version)
+		}
+		out, err := svc.GetObject(input)
+		ts.OK(err)
+		defer out.Body.Close()
+		bts, err := ioutil.ReadAll(out.Body)
+		ts.OK(err)
+		if !bytes.Equal(bts, contents) {
+			ts.Fatal("body mismatch. found:", string(bts), "expected:", string(contents))
+		}
+	}
+
+	deleteVersion := func(ts *testServer, bucket, key, version string) {
+		ts.Helper()
+		svc := ts.s3Client()
+		input := &s3.DeleteObjectInput{
+			Bucket: aws.String(bucket),
+			Key:    aws.String(key),
+		}
+		if version != "" {
+			input.VersionId = aws.String(version)
+		}
+		ts.OKAll(svc.DeleteObject(input))
+	}
+
+	deleteDirect := func(ts *testServer, bucket, key, version string) {
+		ts.Helper()
+		svc := ts.s3Client()
+		input := &s3.DeleteObjectInput{
+			Bucket: aws.String(bucket),
+			Key:    aws.String(key),
+		}
+		out, err := svc.DeleteObject(input)
+		ts.OK(err)
+		if aws.StringValue(out.VersionId) != version {
+			t.Fatal("version ID mismatch. found:", aws.StringValue(out.VersionId), "expected:", version)
+		}
+	}
+
+	list := func(ts *testServer, bucket string, versions ...string) {
+		ts.Helper()
+		svc := ts.s3Client()
+		out, err := svc.ListObjectVersions(&s3.ListObjectVersionsInput{Bucket: aws.String(bucket)})
+		ts.OK(err)
+
+		var found []string
+		for _, ver := range out.Versions {
+			found = append(found, aws.StringValue(ver.VersionId))
+		}
+		for _, ver := range out.DeleteMarkers {
+			found = append(found, aws.StringValue(ver.VersionId))
+		}
+
+		// Unfortunately, the S3 client API destroys the order of Versions and
+		// DeleteMarkers, which are sibling elements in the XML body but separated
+		// into different lists by the client:
+		sort.Strings(found)
+		sort.Strings(versions)
+		if !reflect.DeepEqual(found, versions) {
+			ts.Fatal("versions mismatch. found:", found, "expected:", versions)
+		}
+	}
+
+	// XXX: version IDs are brittle; we control the seed, but the format may
+	// change at any time.
+	const v1 = "3/60O30C1G60O30C1G60O30C1G60O30C1G60O30C1G60O30C1H03F9QN5V72K21OG="
+	const v2 = "3/60O30C1G60O30C1G60O30C1G60O30C1G60O30C1G60O30C1I00G5II3TDAF7GRG="
+	const v3 = "3/60O30C1G60O30C1G60O30C1G60O30C1G60O30C1G60O30C1J01VFV0CD31ES81G="
+
+	t.Run("put-list-delete-versions", func(t *testing.T) {
+		ts := newTestServer(t, withVersioning())
+		defer ts.Close()
+
+		create(ts, defaultBucket, "object", []byte("body 1"), v1)
+		get(ts, defaultBucket, "object", []byte("body 1"), "")
+		list(ts, defaultBucket, v1)
+
+		create(ts, defaultBucket, "object", []byte("body 2"), v2)
+		get(ts, defaultBucket, "object", []byte("body 2"), "")
+		list(ts, defaultBucket, v1, v2)
+
+		create(ts, defaultBucket, "object", []byte("body 3"), v3)
+		get(ts, defaultBucket, "object", []byte("body 3"), "")
+		list(ts, defaultBucket, v1, v2, v3)
+
+		get(ts, defaultBucket, "object", []byte("body 1"), v1)
+		get(ts, defaultBucket, "object", []byte("body 2"), v2)
+		get(ts, defaultBucket, "object", []byte("body 3"), v3)
+		get(ts, defaultBucket, "object", []byte("body 3"), "")
+
+		deleteVersion(ts, defaultBucket, "object", v1)
+		list(ts, defaultBucket, v2, v3)
+		deleteVersion(ts, defaultBucket, "object", v2)
+		list(ts, defaultBucket, v3)
+		deleteVersion(ts, defaultBucket, "object", v3)
+		list(ts, defaultBucket)
+	})
+
+	t.Run("delete-direct", func(t *testing.T) {
+		ts := newTestServer(t, withVersioning())
+		defer ts.Close()
+
+		create(ts, defaultBucket, "object", []byte("body 1"), v1)
+		list(ts, defaultBucket, v1)
+		create(ts, defaultBucket, "object", []byte("body 2"), v2)
+		list(ts, defaultBucket, v1, v2)
+
+		get(ts, defaultBucket, "object", []byte("body 2"), "")
+
+		deleteDirect(ts, defaultBucket, "object", v3)
+		list(ts, defaultBucket, v1, v2, v3)
+
+		svc := ts.s3Client()
+		_, err := svc.GetObject(&s3.GetObjectInput{
+			Bucket: aws.String(defaultBucket),
+			Key:    aws.String("object"),
+		})
+		if !hasErrorCode(err, gofakes3.ErrNoSuchKey) {
+			ts.Fatal("expected ErrNoSuchKey, found", err)
+		}
+	})
+
+	t.Run("list-never-versioned", func(t *testing.T) {
+		ts := newTestServer(t, withVersioning())
+		defer ts.Close()
+
+		const neverVerBucket = "neverver"
+		ts.backendCreateBucket(neverVerBucket)
+
+		ts.backendPutString(neverVerBucket, "object", nil, "body 1")
+		list(ts, neverVerBucket, "null") // S300005
+	})
+
+	t.Run("suspended", func(t *testing.T) {
+		ts := newTestServer(t, withVersioning())
+		defer ts.Close()
+		svc := ts.s3Client()
+
+		create(ts, defaultBucket, "object", []byte("body 1"), v1)
+
+		ts.OKAll(svc.PutBucketVersioning(&s3.PutBucketVersioningInput{
+			Bucket: aws.String(defaultBucket),
+			VersioningConfiguration: &s3.VersioningConfiguration{
+				Status: aws.String(string(gofakes3.VersioningSuspended)),
+			},
+		}))
+
+		// Puts replace the null version, but keep the versions stored while
+		// versioning was enabled:
+		create(ts, defaultBucket, "object", []byte("body 2"), "null")
+		list(ts, defaultBucket, v1, "null")
+		create(ts, defaultBucket, "object", []byte("body 3"), "null")
+		list(ts, defaultBucket, v1, "null")
+		get(ts, defaultBucket, "object", []byte("body 3"), "")
+		get(ts, defaultBucket, "object", []byte("body 3"), "null")
+		get(ts, defaultBucket, "object", []byte("body 1"), v1)
+
+		// A delete replaces the null version with a delete marker:
+		deleteDirect(ts, defaultBucket, "object", "null")
+		list(ts, defaultBucket, v1, "null")
+		_, err := svc.GetObject(&s3.GetObjectInput{
+			Bucket: aws.String(defaultBucket),
+			Key:    aws.String("object"),
+		})
+		if !hasErrorCode(err, gofakes3.ErrNoSuchKey) {
+			ts.Fatal("expected ErrNoSuchKey, found", err)
+		}
+		get(ts, defaultBucket, "object", []byte("body 1"), v1)
+
+		// Deleting the delete marker by its 'null' version ID restores v1:
+		deleteVersion(ts, defaultBucket, "object", "null")
+		list(ts, defaultBucket, v1)
+		get(ts, defaultBucket, "object", []byte("body 1"), "")
+	})
+
+	t.Run("null-version-before-enabled", func(t *testing.T) {
+		ts := newTestServer(t)
+		defer ts.Close()
+		svc := ts.s3Client()
+
+		assertLatest := func(expected string) {
+			ts.Helper()
+			out, err := svc.ListObjectVersions(&s3.ListObjectVersionsInput{Bucket: aws.String(defaultBucket)})
+			ts.OK(err)
+			var latest []string
+			for _, ver := range out.Versions {
+				if aws.BoolValue(ver.IsLatest) {
+					latest = append(latest, aws.StringValue(ver.VersionId))
+				}
+			}
+			if len(latest) != 1 || latest[0] != expected {
+				ts.Fatal("latest versions mismatch. found:", latest, "expected:", expected)
+			}
+		}
+		head := func(version string) {
+			ts.Helper()
+			out, err := svc.HeadObject(&s3.HeadObjectInput{
+				Bucket:    aws.String(defaultBucket),
+				Key:       aws.String("object"),
+				VersionId: aws.String(version),
+			})
+			ts.OK(err)
+			if aws.StringValue(out.VersionId) != version {
+				ts.Fatal("version ID mismatch. found:", aws.StringValue(out.VersionId), "expected:", version)
+			}
+		}
+
+		// The object stored before versioning was enabled is the null
+		// version, which is the latest until it is overwritten:
+		create(ts, defaultBucket, "object", []byte("body 0"), "")
+		ts.OKAll(svc.PutBucketVersioning(&s3.PutBucketVersioningInput{
+			Bucket: aws.String(defaultBucket),
+			VersioningConfiguration: &s3.VersioningConfiguration{
+				Status: aws.String(string(gofakes3.VersioningEnabled)),
+			},
+		}))
+		list(ts, defaultBucket, "null")
+		assertLatest("null")
+		get(ts, defaultBucket, "object", []byte("body 0"), "null")
+		get(ts, defaultBucket, "object", []byte("body 0"), "")
+		head("null")
+
+		// s3mem generates a version ID for every put, including the one
+		// that stored the null version:
+		create(ts, defaultBucket, "object", []byte("body 1"), v2)
+		list(ts, defaultBucket, v2, "null")
+		assertLatest(v2)
+		get(ts, defaultBucket, "object", []byte("body 0"), "null")
+		get(ts, defaultBucket, "object", []byte("body 1"), "")
+		head("null")
+
+		deleteVersion(ts, defaultBucket, "object", "null")
+		list(ts, defaultBucket, v2)
+		assertLatest(v2)
+		get(ts, defaultBucket, "object", []byte("body 1"), "")
+
+		_, err := svc.GetObject(&s3.GetObjectInput{
+			Bucket:    aws.String(defaultBucket),
+			Key:       aws.String("object"),
+			VersionId: aws.String("null"),
+		})
+		if !hasErrorCode(err, gofakes3.ErrNoSuchVersion) {
+			ts.Fatal("expected ErrNoSuchVersion, found", err)
+		}
+	})
+}
+
+func TestListBucketVersionsPages(t *testing.T) {
+	type item struct {
+		XMLName   xml.Name
+		Key       string `xml:"Key"`
+		VersionID string `xml:"VersionId"`
+		IsLatest  bool   `xml:"IsLatest"`
+	}
+	type result struct {
+		IsTruncated         bool   `xml:"IsTruncated"`
+		NextKeyMarker       string `xml:"NextKeyMarker"`
+		NextVersionIDMarker string `xml:"NextVersionIdMarker"`
+		Items               []item `xml:",any"`
+	}
+	list := func(ts *testServer, query url.Values) (out result) {
+		ts.Helper()
+		rs, err := httpClient().Get(ts.url(defaultBucket + "?versions&" + query.Encode()))
+		ts.OK(err)
+		defer rs.Body.Close()
+		if rs.StatusCode != http.StatusOK {
+			ts.Fatal("unexpected status", rs.StatusCode)
+		}
+		ts.OK(xml.NewDecoder(rs.Body).Decode(&out))
+
+		// Drop the elements that are not versions, like <Name>:
+		items := out.Items[:0]
+		for _, it := range out.Items {
+			if it.XMLName.Local == "Version" || it.XMLName.Local == "DeleteMarker" {
+				items = append(items, it)
+			}
+		}
+		out.Items = items
+		return out
+	}
+
+	ts := newTestServer(t, withVersioning())
+	defer ts.Close()
+	svc := ts.s3Client()
+
+	put := func(key string) string {
+		out, err := svc.PutObject(&s3.PutObjectInput{
+			Bucket: aws.String(defaultBucket),
+			Key:    aws.String(key),
+			Body:   strings.NewReader(key),
+		})
+		ts.OK(err)
+		return aws.StringValue(out.VersionId)
+	}
+	a1, a2 := put("a"), put("a")
+	del, err := svc.DeleteObject(&s3.DeleteObjectInput{Bucket: aws.String(defaultBucket), Key: aws.String("a")})
+	ts.OK(err)
+	a3 := aws.StringValue(del.VersionId)
+	b1, c1 := put("b"), put("c")
+
+	expected := []item{
+		{XMLName: xml.Name{Local: "DeleteMarker"}, Key: "a", VersionID: a3, IsLatest: true},
+		{XMLName: xml.Name{Local: "Version"}, Key: "a", VersionID: a2},
+		{XMLName: xml.Name{Local: "Version"}, Key: "a", VersionID: a1},
+		{XMLName: xml.Name{Local: "Version"}, Key: "b", VersionID: b1, IsLatest: true},
+		{XMLName: xml.Name{Local: "Version"}, Key: "c", VersionID: c1, IsLatest: true},
+	}
+	for i := range expected {
+		expected[i].XMLName.Space = "http://s3.amazonaws.com/doc/2006-03-01/"
+	}
+
+	t.Run("all", func(t *testing.T) {
+		out := list(ts, url.Values{})
+		if out.IsTruncated || out.NextKeyMarker != "" || out.NextVersionIDMarker != "" {
+			t.Fatal("unexpected truncation", out)
+		}
+		if !reflect.DeepEqual(out.Items, expected) {
+			t.Fatal(out.Items, "!=", expected)
+		}
+	})
+
+	t.Run("pages", func(t *testing.T) {
+		var found []item
+		query := url.Values{"max-keys": {"2"}}
+		for pages := 1; ; pages++ {
+			out := list(ts, query)
+			found = append(found, out.Items...)
+			if !out.IsTruncated {
+				if pages != 3 {
+					t.Fatal("unexpected number of pages", pages)
+				}
+				break
+			}
+			if last := out.Items[len(out.Items)-1]; out.NextKeyMarker != last.Key || out.NextVersionIDMarker != last.VersionID {
+				t.Fatal("unexpected markers", out.NextKeyMarker, out.NextVersionIDMarker)
+			}
+			query.Set("key-marker", out.NextKeyMarker)
+			query.Set("version-id-marker", out.NextVersionIDMarker)
+		}
+		if !reflect.DeepEqual(found, expected) {
+			t.Fatal(found, "!=", expected)
+		}
+	})
+
+	t.Run("key-marker", func(t *testing.T) {
+		out := list(ts, url.Values{"key-marker": {"a"}})
+		if !reflect.DeepEqual(out.Items, expected[3:]) {
+			t.Fatal(out.Items, "!=", expected[3:])
+		}
+	})
+
+	t.Run("delete-current-version", func(t *testing.T) {
+		c2 := put("c")
+		ts.OKAll(svc.DeleteObject(&s3.DeleteObjectInput{Bucket: aws.String(defaultBucket), Key: aws.String("c"), VersionId: aws.String(c2)}))
+
+		// The previous version becomes the current version:
+		out := list(ts, url.Values{"key-marker": {"b"}})
+		if !reflect.DeepEqual(out.Items, expected[4:]) {
+			t.Fatal(out.Items, "!=", expected[4:])
+		}
+		if ts.backendGetString(defaultBucket, "c", nil) != "c" {
+			t.Fatal("unexpected contents")
+		}
+	})
+}
+
+func TestListBucketPages(t *testing.T) {
+	createData := func(ts *testServer, prefix string, n int64) []string {
+		keys := make([]string, n)
+		for i := int64(0); i < n; i++ {
+			key := fmt.Sprintf("%s%d", prefix, i)
+			ts.backendPutString(defaultBucket, key, nil, fmt.Sprintf("body-%d", i))
+			keys[i] = key
+		}
+		return keys
+	}
+
+	assertKeys := func(ts *testServer, rs *listBucketResult, keys ...string) {
+		found := make([]string, len(rs.Contents))
+		for i := 0; i < len(rs.Contents); i++ {
+			found[i] = aws.StringValue(rs.Contents[i].Key)
+		}
+		if !reflect.DeepEqual(found, keys) {
+			t.Fatal("key mismatch:", keys, "!=", found)
+		}
+	}
+
+	for idx, tc := range []struct {
+		keys, pageKeys int64
+	}{
+		{9, 2},
+		{8, 3},
+		{7, 4},
+		{6, 5},
+		{5, 6},
+	} {
+		t.Run(fmt.Sprintf("list-page-basic/%d", idx), func(t *testing.T) {
+			ts := newTestServer(t)
+			defer ts.Close()
+			keys := createData(ts, "", tc.keys)
+
+			rs := ts.mustListBucketV1Pages(nil, tc.pageKeys, "")
+			if len(rs.CommonPrefixes) > 0 {
+				t.Fatal()
+			}
+			assertKeys(ts, rs, keys...)
+
+			rs = ts.mustListBucketV2Pages(nil, tc.pageKeys, "")
+			if len(rs.CommonPrefixes) > 0 {
+				t.Fatal()
+			}
+			assertKeys(ts, rs, keys...)
+		})
+
+		t.Run(fmt.Sprintf("list-page-prefix/%d", idx), func(t *testing.T) {
+			ts := newTestServer(t)
+			defer ts.Close()
+
+			// junk keys with no prefix to ensure that we are actually limiting the output.
+			// these should not show up in the output.
+			createData(ts, "", tc.keys)
+
+			// these are the actual keys we expect to see:
+			keys := createData(ts, "test", tc.keys)
+
+			prefix := gofakes3.NewPrefix(aws.String("test"), nil)
+
+			rs := ts.mustListBucketV1Pages(&prefix, tc.pageKeys, "")
+			if len(rs.CommonPrefixes) > 0 {
+				t.Fatal()
+			}
+			assertKeys(ts, rs, keys...)
+
+			rs = ts.mustListBucketV2Pages(&prefix, tc.pageKeys, "")
+			if len(rs.CommonPrefixes) > 0 {
+				t.Fatal()
+			}
+			assertKeys(ts, rs, keys...)
+		})
+
+		t.Run(fmt.Sprintf("list-page-prefix-delim/%d", idx), func(t *testing.T) {
+			ts := newTestServer(t)
+			defer ts.Close()
+
+			// junk keys with no prefix to ensure that we are actually limiting the output.
+			// these should not show up in the output.
+			createData(ts, "", tc.keys)
+
+			// these are the actual keys we expect to see:
+			keys := createData(ts, "test/", tc.keys)
+
+			// add some common prefixes:
+			createData(ts, "test/prefix1/", 2)
+			createData(ts, "test/prefix2/", 2)
+
+			prefix := gofakes3.NewFolderPrefix("test/")
+
+			rs := ts.mustListBucketV1Pages(&prefix, tc.pageKeys, "")
+			assertKeys(ts, rs, keys...)
+
+			rs = ts.mustListBucketV2Pages(&prefix, tc.pageKeys, "")
+			assertKeys(ts, rs, keys...)
+
+			// FIXME: there are some unanswered questions for the assumer about
+			// how CommonPrefixes interacts with paging; CommonPrefixes should be
+			// checked once we've established how S3 actually behaves.
+		})
+	}
+}
+
+func TestListBucketV2ContinuationToken(t *testing.T) {
+	ts := newTestServer(t)
+	defer ts.Close()
+	svc := ts.s3Client()
+
+	for _, key := range []string{"a", "b", "c", "d/1", "d/2"} {
+		ts.backendPutString(defaultBucket, key, nil, "body")
+	}
+
+	rs, err := svc.ListObjectsV2(&s3.ListObjectsV2Input{
+		Bucket:     aws.String(defaultBucket),
+		MaxKeys:    aws.Int64(2),
+		FetchOwner: aws.Bool(true),
+	})
+	ts.OK(err)
+	if !aws.BoolValue(rs.IsTruncated) || aws.Int64Value(rs.KeyCount) != 2 {
+		ts.Fatal("unexpected first page:", rs)
+	}
+	if aws.StringValue(rs.NextContinuationToken) == "" {
+		ts.Fatal("missing continuation token")
+	}
+	for _, obj := range rs.Contents {
+		if obj.Owner == nil {
+			ts.Fatal("missing owner for", *obj.Key)
+		}
+	}
+
+	rs, err = svc.ListObjectsV2(&s3.ListObjectsV2Input{
+		Bucket:            aws.String(defaultBucket),
+		Delimiter:         aws.String("/"),
+		ContinuationToken: rs.NextContinuationToken,
+		FetchOwner:        aws.Bool(false),
+	})
+	ts.OK(err)
+	if aws.BoolValue(rs.IsTruncated) || rs.NextContinuationToken != nil {
+		ts.Fatal("unexpected truncated second page:", rs)
+	}
+	if len(rs.Contents) != 1 || *rs.Contents[0].Key != "c" || rs.Contents[0].Owner != nil {
+		ts.Fatal("unexpected contents:", rs.Contents)
+	}
+
+	// KeyCount includes CommonPrefixes:
+	if len(rs.CommonPrefixes) != 1 || aws.Int64Value(rs.KeyCount) != 2 {
+		ts.Fatal("unexpected key count:", aws.Int64Value(rs.KeyCount))
+	}
+}
+
+func TestListBucketURLEncoding(t *testing.T) {
+	ts := newTestServer(t, withVersioning())
+	defer ts.Close()
+	svc := ts.s3Client()
+
+	keys := []string{"a&b", "dir one/a b", "dir one/c+d", "ünïcødé🤡"}
+	for _, key := range keys {
+		ts.backendPutString(defaultBucket, key, nil, "body")
+	}
+
+	unescape := func(v *string) string {
+		t.Helper()
+		out, err := url.QueryUnescape(aws.StringValue(v))
+		ts.OK(err)
+		return out
+	}
+
+	// Each listed key must be fetchable once decoded:
+	assertKeys := func(encodingType *string, found []string, prefixes ...string) {
+		t.Helper()
+		if aws.StringValue(encodingType) != "url" {
+			t.Fatal("unexpected encoding type", aws.StringValue(encodingType))
+		}
+		exp := []string{keys[0], keys[3]}
+		if !reflect.DeepEqual(found, exp) {
+			t.Fatalf("keys:\nexp: %q\ngot: %q", exp, found)
+		}
+		if !reflect.DeepEqual(prefixes, []string{"dir one/"}) {
+			t.Fatalf("unexpected prefixes %q", prefixes)
+		}
+		for _, key := range found {
+			ts.OKAll(svc.GetObject(&s3.GetObjectInput{Bucket: aws.String(defaultBucket), Key: aws.String(key)}))
+		}
+	}
+
+	// List Objects V1, with a delimiter that must itself be encoded:
+	{
+		rs, err := svc.ListObjects(&s3.ListObjectsInput{
+			Bucket:       aws.String(defaultBucket),
+			Delimiter:    aws.String(" "),
+			EncodingType: aws.String("url"),
+		})
+		ts.OK(err)
+		if aws.StringValue(rs.Delimiter) != "+" {
+			t.Fatal("delimiter not encoded:", aws.StringValue(rs.Delimiter))
+		}
+		var found, prefixes []string
+		for _, v := range rs.Contents {
+			found = append(found, unescape(v.Key))
+		}
+		for _, v := range rs.CommonPrefixes {
+			prefixes = append(prefixes, unescape(v.Prefix))
+		}
+		// The delimiter is a space, so every key in "dir one/" rolls up:
+		if !reflect.DeepEqual(prefixes, []string{"dir "}) || len(found) != 2 {
+			t.Fatalf("unexpected listing %q %q", found, prefixes)
+		}
+	}
+
+	// List Objects V2:
+	{
+		rs, err := svc.ListObjectsV2(&s3.ListObjectsV2Input{
+			Bucket:       aws.String(defaultBucket),
+			Delimiter:    aws.String("/"),
+			EncodingType: aws.String("url"),
+		})
+		ts.OK(err)
+		var found, prefixes []string
+		for _, v := range rs.Contents {
+			if strings.ContainsAny(aws.StringValue(v.Key), "& ") {
+				t.Fatal("key not encoded:", aws.StringValue(v.Key))
+			}
+			found = append(found, unescape(v.Key))
+		}
+		for _, v := range rs.CommonPrefixes {
+			prefixes = append(prefixes, unescape(v.Prefix))
+		}
+		assertKeys(rs.EncodingType, found, prefixes...)
+	}
+
+	// List Object Versions:
+	{
+		rs, err := svc.ListObjectVersions(&s3.ListObjectVersionsInput{
+			Bucket:       aws.String(defaultBucket),
+			Delimiter:    aws.String("/"),
+			EncodingType: aws.String("url"),
+		})
+		ts.OK(err)
+		var found, prefixes []string
+		for _, v := range rs.Versions {
+			found = append(found, unescape(v.Key))
+		}
+		for _, v := range rs.CommonPrefixes {
+			prefixes = append(prefixes, unescape(v.Prefix))
+		}
+		assertKeys(rs.EncodingType, found, prefixes...)
+	}
+
+	// The prefix is encoded, but the '/' separator is left alone:
+	{
+		rs, err := svc.ListObjectsV2(&s3.ListObjectsV2Input{
+			Bucket:       aws.String(defaultBucket),
+			Prefix:       aws.String("dir one/"),
+			EncodingType: aws.String("url"),
+		})
+		ts.OK(err)
+		if aws.StringValue(rs.Prefix) != "dir+one/" {
+			t.Fatal("prefix not encoded:", aws.StringValue(rs.Prefix))
+		}
+		var found []string
+		for _, v := range rs.Contents {
+			found = append(found, unescape(v.Key))
+		}
+		if !reflect.DeepEqual(found, keys[1:3]) {
+			t.Fatalf("unexpected keys %q", found)
+		}
+	}
+
+	// Nothing is encoded unless encoding-type is given:
+	{
+		rs, err := svc.ListObjectsV2(&s3.ListObjectsV2Input{Bucket: aws.String(defaultBucket)})
+		ts.OK(err)
+		if rs.EncodingType != nil || aws.StringValue(rs.Contents[0].Key) != "a&b" {
+			t.Fatal("unexpected encoding:", rs)
+		}
+	}
+
+	// 'url' is the only supported encoding type:
+	{
+		_, err := svc.ListObjectsV2(&s3.ListObjectsV2Input{
+			Bucket:       aws.String(defaultBucket),
+			EncodingType: aws.String("nope"),
+		})
+		if !hasErrorCode(err, gofakes3.ErrInvalidArgument) {
+			t.Fatal("expected InvalidArgument, found", err)
+		}
+	}
+}
+
+// Ensure that a backend that does not support pagination can use the fallback if enabled:
+func TestListBucketPagesFallback(t *testing.T) {
+	createData := func(ts *testServer, prefix string, n int64) []string {
+		keys := make([]string, n)
+		for i := int64(0); i < n; i++ {
+			key := fmt.Sprintf("%s%d", prefix, i)
+			ts.backendPutString(defaultBucket, key, nil, fmt.Sprintf("body-%d", i))
+			keys[i] = key
+		}
+		return keys
+	}
+
+	t.Run("fallback-disabled", func(t *testing.T) {
+		ts := newTestServer(t,
+			withBackend(&backendWithUnimplementedPaging{s3mem.New()}),
+			withFakerOptions(gofakes3.WithUnimplementedPageError()),
+		)
+		defer ts.Close()
+		createData(ts, "", 5)
+		_, err := ts.listBucketV1Pages(nil, 2, "")
+		if !hasErrorCode(err, gofakes3.ErrNotImplemented) {
+			t.Fatal(err)
+		}
+	})
+
+	t.Run("fallback-enabled", func(t *testing.T) {
+		ts := newTestServer(t, withBackend(&backendWithUnimplementedPaging{s3mem.New()}))
+		defer ts.Close()
+		createData(ts, "", 5)
+		r := ts.mustListBucketV1Pages(nil, 2, "")
+
+		// Without pagination, should just fall back to returning all keys:
+		if len(r.Contents) != 5 {
+			t.Fatal()
+		}
+	})
+}
+
+func tryDumpResponse(rs *http.Response, body bool) string {
+	b, _ := httputil.DumpResponse(rs, body)
+	return string(b)
+}
+
+func TestAuthentication(t *testing.T) {
+	ts := newTestServer(t, withFakerOptions(
+		gofakes3.WithAuthentication(map[string]string{"dummy-access": "dummy-secret"}),
+	))
+	defer ts.Close()
+	svc := ts.s3Client()
+
+	ts.backendPutString(defaultBucket, "object", nil, "hello")
+
+	clientWithCreds := func(access, secret string) *s3.S3 {
+		svc := ts.s3Client()
+		svc.Config.Credentials = credentials.NewStaticCredentials(access, secret, "")
+		return svc
+	}
+
+	getObject := func(svc *s3.S3) error {
+		out, err := svc.GetObject(&s3.GetObjectInput{
+			Bucket: aws.String(defaultBucket),
+			Key:    aws.String("object"),
+		})
+		if err == nil {
+			out.Body.Close()
+		}
+		return err
+	}
+
+	anonymousStatus := func() int {
+		rs, err := httpClient().Get(ts.url(defaultBucket + "/object"))
+		ts.OK(err)
+		rs.Body.Close()
+		return rs.StatusCode
+	}
+
+	t.Run("signed", func(t *testing.T) {
+		if err := getObject(svc); err != nil {
+			t.Fatal(err)
+		}
+		_, err := svc.PutObject(&s3.PutObjectInput{
+			Bucket: aws.String(defaultBucket),
+			Key:    aws.String("put"),
+			Body:   bytes.NewReader([]byte("world")),
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+	})
+
+	t.Run("wrong-secret", func(t *testing.T) {
+		err := getObject(clientWithCreds("dummy-access", "wrong"))
+		if !s3HasErrorCode(err, gofakes3.ErrSignatureDoesNotMatch) {
+			t.Fatal("expected SignatureDoesNotMatch, found", err)
+		}
+	})
+
+	t.Run("unknown-key", func(t *testing.T) {
+		err := getObject(clientWithCreds("unknown", "dummy-secret"))
+		if !s3HasErrorCode(err, gofakes3.ErrInvalidAccessKeyID) {
+			t.Fatal("expected InvalidAccessKeyId, found", err)
+		}
+	})
+
+	t.Run("presigned", func(t *testing.T) {
+		req, _ := svc.GetObjectRequest(&s3.GetObjectInput{
+			Bucket: aws.String(defaultBucket),
+			Key:    aws.String("object"),
+		})
+		presigned, err := req.Presign(15 * time.Minute)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		rs, err := httpClient().Get(presigned)
+		if err != nil {
+			t.Fatal(err)
+		}
+		body, _ := ioutil.ReadAll(rs.Body)
+		rs.Body.Close()
+		if rs.StatusCode != http.StatusOK || string(body) != "hello" {
+			t.Fatal("unexpected response", rs.StatusCode, string(body))
+		}
+
+		rs, err = httpClient().Get(presigned + "&foo=bar")
+		if err != nil {
+			t.Fatal(err)
+		}
+		rs.Body.Close()
+		if rs.StatusCode != http.StatusForbidden {
+			t.Fatal("expected 403 for tampered URL, found", rs.StatusCode)
+		}
+	})
+
+	t.Run("presigned-expired", func(t *testing.T) {
+		rq, err := http.NewRequest("GET", ts.url(defaultBucket+"/object"), nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		signer := v4.NewSigner(credentials.NewStaticCredentials("dummy-access", "dummy-secret", ""))
+		if _, err := signer.Presign(rq, nil, "s3", "region", 15*time.Minute, defaultDate.Add(-time.Hour)); err != nil {
+			t.Fatal(err)
+		}
+
+		rs, err := httpClient().Do(rq)
+		if err != nil {
+			t.Fatal(err)
+		}
+		body, _ := ioutil.ReadAll(rs.Body)
+		rs.Body.Close()
+		if rs.StatusCode != http.StatusForbidden || !bytes.Contains(body, []byte("<Code>AccessDenied</Code>")) {
+			t.Fatal("expected AccessDenied, found", rs.StatusCode, string(body))
+		}
+	})
+
+	t.Run("tampered-body", func(t *testing.T) {
+		rq, err := http.NewRequest("PUT", ts.url(defaultBucket+"/tampered"), nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		signer := v4.NewSigner(credentials.NewStaticCredentials("dummy-access", "dummy-secret", ""))
+		if _, err := signer.Sign(rq, bytes.NewReader([]byte("signed")), "s3", "region", time.Now()); err != nil {
+			t.Fatal(err)
+		}
+		rq.Body = ioutil.NopCloser(bytes.NewReader([]byte("sent!!")))
+		rq.ContentLength = 6
+
+		rs, err := httpClient().Do(rq)
+		if err != nil {
+			t.Fatal(err)
+		}
+		body, _ := ioutil.ReadAll(rs.Body)
+		rs.Body.Close()
+		if rs.StatusCode != http.StatusBadRequest || !bytes.Contains(body, []byte("<Code>XAmzContentSHA256Mismatch</Code>")) {
+			t.Fatal("expected XAmzContentSHA256Mismatch, found", rs.StatusCode, string(body))
+		}
+		if ts.backendObjectExists(defaultBucket, "tampered") {
+			t.Fatal("object with tampered body was stored")
+		}
+	})
+
+	t.Run("anonymous", func(t *testing.T) {
+		if status := anonymousStatus(); status != http.StatusForbidden {
+			t.Fatal("expected 403 without policy, found", status)
+		}
+
+		_, err := svc.PutBucketPolicy(&s3.PutBucketPolicyInput{
+			Bucket: aws.String(defaultBucket),
+			Policy: aws.String(`{
+				"Version": "2012-10-17",
+				"Statement": [{
+					"Effect": "Allow",
+					"Principal": "*",
+					"Action": ["s3:GetObject"],
+					"Resource": ["arn:aws:s3:::` + defaultBucket + `/*"]
+				}]
+			}`),
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if status := anonymousStatus(); status != http.StatusOK {
+			t.Fatal("expected 200 with public-read policy, found", status)
+		}
+
+		rs, err := httpClient().Get(ts.url(defaultBucket))
+		if err != nil {
+			t.Fatal(err)
+		}
+		rs.Body.Close()
+		if rs.StatusCode != http.StatusForbidden {
+			t.Fatal("expected anonymous listing to be denied, found", rs.StatusCode)
+		}
+	})
+}
+
+func TestAuthenticationACL(t *testing.T) {
+	ts := newTestServer(t, withFakerOptions(
+		gofakes3.WithAuthentication(map[string]string{"dummy-access": "dummy-secret"}),
+	))
+	defer ts.Close()
+	svc := ts.s3Client()
+
+	anonymousStatus := func(method, path string) int {
+		t.Helper()
+		rq, err := http.NewRequest(method, ts.url(path), strings.NewReader("anonymous"))
+		ts.OK(err)
+		rs, err := httpClient().Do(rq)
+		ts.OK(err)
+		rs.Body.Close()
+		return rs.StatusCode
+	}
+
+	for _, key := range []string{"private", "public"} {
+		input := &s3.PutObjectInput{Bucket: aws.String(defaultBucket), Key: aws.String(key), Body: strings.NewReader("hello")}
+		if key == "public" {
+			input.ACL = aws.String("public-read")
+		}
+		ts.OKAll(svc.PutObject(input))
+	}
+
+	if status := anonymousStatus("GET", defaultBucket+"/public"); status != http.StatusOK {
+		t.Fatal("expected 200 for public-read object, found", status)
+	}
+	if status := anonymousStatus("GET", defaultBucket+"/private"); status != http.StatusForbidden {
+		t.Fatal("expected 403 for private object, found", status)
+	}
+	if status := anonymousStatus("GET", defaultBucket+"/public?acl"); status != http.StatusForbidden {
+		t.Fatal("expected 403 for the ACL of a public-read object, found", status)
+	}
+	if status := anonymousStatus("PUT", defaultBucket+"/public"); status != http.StatusForbidden {
+		t.Fatal("expected 403 for PUT to a private bucket, found", status)
+	}
+
+	ts.OKAll(svc.PutBucketAcl(&s3.PutBucketAclInput{Bucket: aws.String(defaultBucket), ACL: aws.String("public-read-write")}))
+	if status := anonymousStatus("GET", defaultBucket); status != http.StatusOK {
+		t.Fatal("expected 200 for listing a public-read-write bucket, found", status)
+	}
+	if status := anonymousStatus("PUT", defaultBucket+"/anonymous"); status != http.StatusOK {
+		t.Fatal("expected 200 for PUT to a public-read-write bucket, found", status)
+	}
+
+	// A policy that denies everyone overrides the ACLs:
+	ts.OKAll(svc.PutBucketPolicy(&s3.PutBucketPolicyInput{
+		Bucket: aws.String(defaultBucket),
+		Policy: aws.String(`{
+			"Version": "2012-10-17",
+			"Statement": [
+				{
+					"Effect": "Deny",
+					"Principal": {"AWS": "*"},
+					"Action": "s3:GetObject",
+					"Resource": "arn:aws:s3:::` + defaultBucket + `/public"
+				},
+				{
+					"Effect": "Deny",
+					"Principal": "*",
+					"Action": "s3:ListBucket",
+					"Resource": "arn:aws:s3:::` + defaultBucket + `"
+				}
+			]
+		}`),
+	}))
+	if status := anonymousStatus("GET", defaultBucket+"/public"); status != http.StatusForbidden {
+		t.Fatal("expected 403 for a public-read object denied by the policy, found", status)
+	}
+	if status := anonymousStatus("GET", defaultBucket); status != http.StatusForbidden {
+		t.Fatal("expected 403 for listing a bucket denied by the policy, found", status)
+	}
+	if status := anonymousStatus("GET", defaultBucket+"/anonymous"); status != http.StatusForbidden {
+		t.Fatal("expected 403 for a private object, found", status)
+	}
+}
+
+func TestPublicAccessBlock(t *testing.T) {
+	ts := newTestServer(t, withFakerOptions(
+		gofakes3.WithAuthentication(map[string]string{"dummy-access": "dummy-secret"}),
+	))
+	defer ts.Close()
+	svc := ts.s3Client()
+	bucket := aws.String(defaultBucket)
+
+	anonymousStatus := func(path string) int {
+		t.Helper()
+		rs, err := httpClient().Get(ts.url(path))
+		ts.OK(err)
+		rs.Body.Close()
+		return rs.StatusCode
+	}
+	putBlock := func(block s3.PublicAccessBlockConfiguration) {
+		t.Helper()
+		ts.OKAll(svc.PutPublicAccessBlock(&s3.PutPublicAccessBlockInput{Bucket: bucket, PublicAccessBlockConfiguration: &block}))
+	}
+	publicPolicy := aws.String(`{
+		"Version": "2012-10-17",
+		"Statement": [{
+			"Effect": "Allow",
+			"Principal": "*",
+			"Action": "s3:GetObject",
+			"Resource": "arn:aws:s3:::` + defaultBucket + `/policy/*"
+		}]
+	}`)
+
+	_, err := svc.GetPublicAccessBlock(&s3.GetPublicAccessBlockInput{Bucket: bucket})
+	if !s3HasErrorCode(err, gofakes3.ErrNoSuchPublicAccessBlockConfiguration) {
+		t.Fatal("expected NoSuchPublicAccessBlockConfiguration, found", err)
+	}
+
+	block := s3.PublicAccessBlockConfiguration{
+		BlockPublicAcls:       aws.Bool(true),
+		IgnorePublicAcls:      aws.Bool(false),
+		BlockPublicPolicy:     aws.Bool(true),
+		RestrictPublicBuckets: aws.Bool(false),
+	}
+	putBlock(block)
+	out, err := svc.GetPublicAccessBlock(&s3.GetPublicAccessBlockInput{Bucket: bucket})
+	ts.OK(err)
+	if !reflect.DeepEqual(out.PublicAccessBlockConfiguration, &block) {
+		t.Fatal("unexpected configuration", out.PublicAccessBlockConfiguration)
+	}
+
+	t.Run("block", func(t *testing.T) {
+		_, err := svc.PutBucketPolicy(&s3.PutBucketPolicyInput{Bucket: bucket, Policy: publicPolicy})
+		if !s3HasErrorCode(err, gofakes3.ErrAccessDenied) {
+			t.Fatal("expected AccessDenied for a public policy, found", err)
+		}
+		ts.OKAll(svc.PutBucketPolicy(&s3.PutBucketPolicyInput{Bucket: bucket, Policy: aws.String(`{
+			"Statement": [{
+				"Effect": "Allow",
+				"Principal": {"AWS": "arn:aws:iam::123456789012:root"},
+				"Action": "s3:GetObject",
+				"Resource": "arn:aws:s3:::` + defaultBucket + `/*"
+			}]
+		}`)}))
+
+		_, err = svc.PutBucketAcl(&s3.PutBucketAclInput{Bucket: bucket, ACL: aws.String("public-read")})
+		if !s3HasErrorCode(err, gofakes3.ErrAccessDenied) {
+			t.Fatal("expected AccessDenied for a public-read bucket ACL, found", err)
+		}
+		_, err = svc.PutObject(&s3.PutObjectInput{
+			Bucket: bucket, Key: aws.String("object"), Body: strings.NewReader("hello"), ACL: aws.String("authenticated-read"),
+		})
+		if !s3HasErrorCode(err, gofakes3.ErrAccessDenied) {
+			t.Fatal("expected AccessDenied for an authenticated-read object, found", err)
+		}
+		if ts.backendObjectExists(defaultBucket, "object") {
+			t.Fatal("object with a blocked ACL was stored")
+		}
+		_, err = svc.CreateMultipartUpload(&s3.CreateMultipartUploadInput{Bucket: bucket, Key: aws.String("object"), ACL: aws.String("public-read")})
+		if !s3HasErrorCode(err, gofakes3.ErrAccessDenied) {
+			t.Fatal("expected AccessDenied for a public-read multipart upload, found", err)
+		}
+		ts.OKAll(svc.PutObject(&s3.PutObjectInput{
+			Bucket: bucket, Key: aws.String("object"), Body: strings.NewReader("hello"), ACL: aws.String("private"),
+		}))
+	})
+
+	// Once the block is deleted, the bucket can be made public, to check
+	// that restricting and ignoring stop anonymous access:
+	ts.OKAll(svc.DeletePublicAccessBlock(&s3.DeletePublicAccessBlockInput{Bucket: bucket}))
+	_, err = svc.GetPublicAccessBlock(&s3.GetPublicAccessBlockInput{Bucket: bucket})
+	if !s3HasErrorCode(err, gofakes3.ErrNoSuchPublicAccessBlockConfiguration) {
+		t.Fatal("expected NoSuchPublicAccessBlockConfiguration, found", err)
+	}
+	ts.OKAll(svc.PutBucketPolicy(&s3.PutBucketPolicyInput{Bucket: bucket, Policy: publicPolicy}))
+	ts.OKAll(svc.PutObject(&s3.PutObjectInput{Bucket: bucket, Key: aws.String("policy/object"), Body: strings.NewReader("hello")}))
+	ts.OKAll(svc.PutObject(&s3.PutObjectInput{
+		Bucket: bucket, Key: aws.String("acl"), Body: strings.NewReader("hello"), ACL: aws.String("public-read"),
+	}))
+
+	t.Run("restrict", func(t *testing.T) {
+		for _, tc := range []struct {
+			block       s3.PublicAccessBlockConfiguration
+			policy, acl int
+		}{
+			{s3.PublicAccessBlockConfiguration{}, http.StatusOK, http.StatusOK},
+			{s3.PublicAccessBlockConfiguration{RestrictPublicBuckets: aws.Bool(true)}, http.StatusForbidden, http.StatusOK},
+			{s3.PublicAccessBlockConfiguration{IgnorePublicAcls: aws.Bool(true)}, http.StatusOK, http.StatusForbidden},
+		} {
+			putBlock(tc.block)
+			if status := anonymousStatus(defaultBucket + "/policy/object"); status != tc.policy {
+				t.Fatal(tc.block, "expected", tc.policy, "for an object the policy allows, found", status)
+			}
+			if status := anonymousStatus(defaultBucket + "/acl"); status != tc.acl {
+				t.Fatal(tc.block, "expected", tc.acl, "for a public-read object, found", status)
+			}
+		}
+	})
+}
+
+func TestBucketReplication(t *testing.T) {
+	ts := newTestServer(t, withVersioning())
+	defer ts.Close()
+	svc := ts.s3Client()
+
+	status := func(key string) string {
+		t.Helper()
+		head, err := svc.HeadObject(&s3.HeadObjectInput{Bucket: aws.String(defaultBucket), Key: aws.String(key)})
+		ts.OK(err)
+		return aws.StringValue(head.ReplicationStatus)
+	}
+	put := func(config *s3.ReplicationConfiguration) error {
+		_, err := svc.PutBucketReplication(&s3.PutBucketReplicationInput{Bucket: aws.String(defaultBucket), ReplicationConfiguration: config})
+		return err
+	}
+
+	_, err := svc.GetBucketReplication(&s3.GetBucketReplicationInput{Bucket: aws.String(defaultBucket)})
+	if !s3HasErrorCode(err, gofakes3.ErrReplicationConfigurationNotFound) {
+		t.Fatal("expected ReplicationConfigurationNotFoundError, found", err)
+	}
+	ts.backendPutString(defaultBucket, "logs/a", nil, "hello")
+	ts.backendPutString(defaultBucket, "data/b", nil, "hello")
+	ts.OKAll(svc.PutObjectTagging(&s3.PutObjectTaggingInput{
+		Bucket:  aws.String(defaultBucket),
+		Key:     aws.String("data/b"),
+		Tagging: &s3.Tagging{TagSet: []*s3.Tag{{Key: aws.String("replicate"), Value: aws.String("yes")}}},
+	}))
+	if found := status("logs/a"); found != "" {
+		t.Fatal("unexpected replication status", found)
+	}
+
+	dest := &s3.Destination{Bucket: aws.String("arn:aws:s3:::backup"), StorageClass: aws.String("STANDARD_IA")}
+	config := &s3.ReplicationConfiguration{
+		Role: aws.String("arn:aws:iam::123456789012:role/replication"),
+		Rules: []*s3.ReplicationRule{
+			{
+				ID:                      aws.String("logs"),
+				Priority:                aws.Int64(1),
+				Filter:                  &s3.ReplicationRuleFilter{Prefix: aws.String("logs/")},
+				Status:                  aws.String("Enabled"),
+				Destination:             dest,
+				DeleteMarkerReplication: &s3.DeleteMarkerReplication{Status: aws.String("Disabled")},
+			},
+			{
+				ID:                      aws.String("tagged"),
+				Priority:                aws.Int64(2),
+				Filter:                  &s3.ReplicationRuleFilter{Tag: &s3.Tag{Key: aws.String("replicate"), Value: aws.String("yes")}},
+				Status:                  aws.String("Disabled"),
+				Destination:             dest,
+				DeleteMarkerReplication: &s3.DeleteMarkerReplication{Status: aws.String("Disabled")},
+			},
+		},
+	}
+	ts.OK(put(config))
+
+	out, err := svc.GetBucketReplication(&s3.GetBucketReplicationInput{Bucket: aws.String(defaultBucket)})
+	ts.OK(err)
+	if !reflect.DeepEqual(out.ReplicationConfiguration, config) {
+		t.Fatalf("replication configuration:\nexp: %v\ngot: %v", config, out.ReplicationConfiguration)
+	}
+
+	// Only objects an enabled rule applies to are reported as replicated:
+	if found := status("logs/a"); found != "COMPLETED" {
+		t.Fatal("unexpected replication status", found)
+	}
+	if found := status("data/b"); found != "" {
+		t.Fatal("unexpected replication status", found)
+	}
+	config.Rules[1].Status = aws.String("Enabled")
+	ts.OK(put(config))
+	if found := status("data/b"); found != "COMPLETED" {
+		t.Fatal("unexpected replication status", found)
+	}
+	obj, err := svc.GetObject(&s3.GetObjectInput{Bucket: aws.String(defaultBucket), Key: aws.String("data/b")})
+	ts.OK(err)
+	obj.Body.Close()
+	if found := aws.StringValue(obj.ReplicationStatus); found != "COMPLETED" {
+		t.Fatal("unexpected replication status", found)
+	}
+
+	for _, invalid := range []*s3.ReplicationConfiguration{
+		{Role: aws.String("arn:aws:iam::123456789012:role/replication")},
+		{Role: aws.String("nope"), Rules: config.Rules},
+		{Role: aws.String(""), Rules: config.Rules},
+	} {
+		// The SDK refuses to send a configuration without the required
+		// fields, so they are checked by the server only if sent as XML:
+		rq, _ := svc.PutBucketReplicationRequest(&s3.PutBucketReplicationInput{Bucket: aws.String(defaultBucket), ReplicationConfiguration: invalid})
+		rq.Handlers.Validate.Clear()
+		if err := rq.Send(); !s3HasErrorCode(err, gofakes3.ErrMalformedXML) && !s3HasErrorCode(err, gofakes3.ErrInvalidArgument) {
+			t.Fatal("expected configuration to be refused, found", err)
+		}
+	}
+
+	ts.OKAll(svc.DeleteBucketReplication(&s3.DeleteBucketReplicationInput{Bucket: aws.String(defaultBucket)}))
+	_, err = svc.GetBucketReplication(&s3.GetBucketReplicationInput{Bucket: aws.String(defaultBucket)})
+	if !s3HasErrorCode(err, gofakes3.ErrReplicationConfigurationNotFound) {
+		t.Fatal("expected ReplicationConfigurationNotFoundError, found", err)
+	}
+	if found := status("logs/a"); found != "" {
+		t.Fatal("unexpected replication status", found)
+	}
+}
+
+func TestAuthenticationPresignedExpiry(t *testing.T) {
+	for idx, tc := range []struct {
+		skew     time.Duration
+		signedAt time.Time
+		code     gofakes3.ErrorCode
+		message  string
+	}{
+		{skew: 0, signedAt: defaultDate.Add(-10 * time.Minute)},
+		{skew: 0, signedAt: defaultDate.Add(-15 * time.Minute)},
+		{skew: 0, signedAt: defaultDate.Add(-16 * time.Minute), code: gofakes3.ErrAccessDenied, message: "Request has expired"},
+		{skew: 0, signedAt: defaultDate.Add(time.Hour)},
+		{skew: 5 * time.Minute, signedAt: defaultDate.Add(-19 * time.Minute)},
+		{skew: 5 * time.Minute, signedAt: defaultDate.Add(-21 * time.Minute), code: gofakes3.ErrAccessDenied, message: "Request has expired"},
+		{skew: 5 * time.Minute, signedAt: defaultDate.Add(4 * time.Minute)},
+		{skew: 5 * time.Minute, signedAt: defaultDate.Add(6 * time.Minute), code: gofakes3.ErrAccessDenied, message: "Request is not valid yet"},
+	} {
+		t.Run(fmt.Sprintf("%d", idx), func(t *testing.T) {
+			ts := newTestServer(t, withFakerOptions(
+				gofakes3.WithAuthentication(map[string]string{"dummy-access": "dummy-secret"}),
+				gofakes3.WithTimeSkewLimit(tc.skew),
+			))
+			defer ts.Close()
+			ts.backendPutString(defaultBucket, "object", nil, "hello")
+
+			rq, err := http.NewRequest("GET", ts.url(defaultBucket+"/object"), nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			signer := v4.NewSigner(credentials.NewStaticCredentials("dummy-access", "dummy-secret", ""))
+			if _, err := signer.Presign(rq, nil, "s3", "region", 15*time.Minute, tc.signedAt); err != nil {
+				t.Fatal(err)
+			}
+
+			rs, err := httpClient().Do(rq)
+			if err != nil {
+				t.Fatal(err)
+			}
+			body, _ := ioutil.ReadAll(rs.Body)
+			rs.Body.Close()
+
+			if tc.code == "" {
+				if rs.StatusCode != http.StatusOK {
+					t.Fatal("unexpected status", rs.StatusCode, string(body))
+				}
+				return
+			}
+			if rs.StatusCode != tc.code.Status() ||
+				!bytes.Contains(body, []byte("<Code>"+string(tc.code)+"</Code>")) ||
+				!bytes.Contains(body, []byte(tc.message)) {
+				t.Fatal("expected", tc.code, tc.message, "found", rs.StatusCode, string(body))
+			}
+		})
+	}
+}
+
+type recordingMetrics struct {
+	mu         sync.Mutex
+	operations []string
+	backend    gofakes3.Backend
+}
+
+func (m *recordingMetrics) ObserveOperation(operation string, code gofakes3.ErrorCode, duration time.Duration) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.operations = append(m.operations, operation+":"+string(code))
+}
+
+func (m *recordingMetrics) ObserveBackend(backend gofakes3.Backend) {
+	m.backend = backend
+}
+
+func TestMetrics(t *testing.T) {
+	metrics := &recordingMetrics{}
+	ts := newTestServer(t, withFakerOptions(gofakes3.WithMetrics(metrics)))
+	svc := ts.s3Client()
+
+	if metrics.backend != ts.backend {
+		t.Fatal("backend not passed to ObserveBackend")
+	}
+
+	ts.backendPutString(defaultBucket, "object", nil, "hello")
+	ts.OKAll(svc.ListBuckets(&s3.ListBucketsInput{}))
+	ts.OKAll(svc.ListObjectsV2(&s3.ListObjectsV2Input{Bucket: aws.String(defaultBucket)}))
+	ts.OKAll(svc.GetObject(&s3.GetObjectInput{Bucket: aws.String(defaultBucket), Key: aws.String("object")}))
+	ts.OKAll(svc.CopyObject(&s3.CopyObjectInput{
+		Bucket:     aws.String(defaultBucket),
+		Key:        aws.String("copy"),
+		CopySource: aws.String(defaultBucket + "/object"),
+	}))
+	_, err := svc.HeadObject(&s3.HeadObjectInput{Bucket: aws.String(defaultBucket), Key: aws.String("missing")})
+	if err == nil {
+		t.Fatal("expected error")
+	}
+	ts.OKAll(svc.DeleteObjects(&s3.DeleteObjectsInput{
+		Bucket: aws.String(defaultBucket),
+		Delete: &s3.Delete{Objects: []*s3.ObjectIdentifier{{Key: aws.String("copy")}}},
+	}))
+	ts.OKAll(svc.GetBucketVersioning(&s3.GetBucketVersioningInput{Bucket: aws.String(defaultBucket)}))
+	upload, err := svc.CreateMultipartUpload(&s3.CreateMultipartUploadInput{Bucket: aws.String(defaultBucket), Key: aws.String("upload")})
+	ts.OK(err)
+	ts.OKAll(svc.AbortMultipartUpload(&s3.AbortMultipartUploadInput{
+		Bucket: aws.String(defaultBucket), Key: aws.String("upload"), UploadId: upload.UploadId,
+	}))
+
+	// Operations are observed after the response is written, so wait for the
+	// handlers to finish:
+	ts.Close()
+
+	expected := []string{
+		"AbortMultipartUpload:",
+		"CopyObject:",
+		"CreateMultipartUpload:",
+		"DeleteObjects:",
+		"GetBucketVersioning:",
+		"GetObject:",
+		"HeadObject:NoSuchKey",
+		"ListBuckets:",
+		"ListObjectsV2:",
+	}
+	sort.Strings(metrics.operations)
+	if !reflect.DeepEqual(metrics.operations, expected) {
+		t.Fatal(metrics.operations, "!=", expected)
+	}
+}
+
+func TestLoggingBackend(t *testing.T) {
+	var buf bytes.Buffer
+	logger := gofakes3.StdLog(log.New(&buf, "", 0))
+	mem := s3mem.New(s3mem.WithTimeSource(gofakes3.FixedTimeSource(defaultDate)))
+	ts := newTestServer(t, withBackend(gofakes3.WrapBackend(mem, gofakes3.LoggingBackend(logger))))
+	defer ts.Close()
+	svc := ts.s3Client()
+
+	ts.OKAll(svc.PutObject(&s3.PutObjectInput{
+		Bucket: aws.String(defaultBucket),
+		Key:    aws.String("object"),
+		Body:   bytes.NewReader([]byte("hello")),
+	}))
+	_, err := svc.HeadObject(&s3.HeadObjectInput{Bucket: aws.String(defaultBucket), Key: aws.String("missing")})
+	if !s3HasErrorCode(err, gofakes3.ErrorCode("NotFound")) {
+		t.Fatal("expected NotFound, found", err)
+	}
+
+	// Optional interfaces of the wrapped backend are still found:
+	ts.OKAll(svc.PutObjectTagging(&s3.PutObjectTaggingInput{
+		Bucket:  aws.String(defaultBucket),
+		Key:     aws.String("object"),
+		Tagging: &s3.Tagging{TagSet: []*s3.Tag{{Key: aws.String("k"), Value: aws.String("v")}}},
+	}))
+	tags, err := mem.GetObjectTagging(defaultBucket, "object", "")
+	ts.OK(err)
+	if tags["k"] != "v" {
+		t.Fatal("tags not stored in the wrapped backend:", tags)
+	}
+
+	out := buf.String()
+	for _, expected := range []string{
+		"INFO BACKEND CreateBucket: mybucket\n",
+		"INFO BACKEND PutObject: mybucket object 5\n",
+		"INFO BACKEND HeadObject: mybucket missing\n",
+		"WARN BACKEND HeadObject: NoSuchKey",
+	} {
+		if !strings.Contains(out, expected) {
+			t.Fatalf("log does not contain %q:\n%s", expected, out)
+		}
+	}
+}
+
+func TestFaultBackend(t *testing.T) {
+	newBackend := func(opts ...gofakes3.FaultOption) gofakes3.Backend {
+		mem := s3mem.New(s3mem.WithTimeSource(gofakes3.FixedTimeSource(defaultDate)))
+		return gofakes3.WrapBackend(mem, gofakes3.FaultBackend(opts...))
+	}
+
+	t.Run("pass-through", func(t *testing.T) {
+		ts := newTestServer(t, withBackend(newBackend()))
+		defer ts.Close()
+		ts.backendPutString(defaultBucket, "object", map[string]string{}, "hello")
+		if ts.backendGetString(defaultBucket, "object", nil) != "hello" {
+			t.Fatal("unexpected contents")
+		}
+	})
+
+	t.Run("error", func(t *testing.T) {
+		ts := newTestServer(t, withBackend(newBackend(gofakes3.WithFaultProbability(1, "GetObject"))))
+		defer ts.Close()
+		ts.backendPutString(defaultBucket, "object", map[string]string{}, "hello")
+
+		rs, err := httpClient().Get(ts.url(defaultBucket + "/object"))
+		ts.OK(err)
+		defer rs.Body.Close()
+		if rs.StatusCode != http.StatusInternalServerError {
+			t.Fatal("unexpected status", rs.StatusCode)
+		}
+		var errResp gofakes3.ErrorResponse
+		ts.OK(xml.NewDecoder(rs.Body).Decode(&errResp))
+		if errResp.Code != gofakes3.ErrInternal {
+			t.Fatal("unexpected code", errResp.Code)
+		}
+
+		// Other methods are unaffected:
+		if !ts.backendObjectExists(defaultBucket, "object") {
+			t.Fatal("object does not exist")
+		}
+	})
+
+	t.Run("custom-error", func(t *testing.T) {
+		ts := newTestServer(t, withBackend(newBackend(
+			gofakes3.WithFaultProbability(1, "HeadObject"),
+			gofakes3.WithFaultError(gofakes3.ErrSlowDown),
+		)))
+		defer ts.Close()
+		ts.backendPutString(defaultBucket, "object", map[string]string{}, "hello")
+
+		rs, err := httpClient().Head(ts.url(defaultBucket + "/object"))
+		ts.OK(err)
+		rs.Body.Close()
+		if rs.StatusCode != http.StatusServiceUnavailable {
+			t.Fatal("unexpected status", rs.StatusCode)
+		}
+	})
+
+	t.Run("seeded", func(t *testing.T) {
+		failures := func(seed int64) (out []bool) {
+			b := newBackend(gofakes3.WithFaultSeed(seed), gofakes3.WithFaultProbability(0.5))
+			for i := 0; i < 32; i++ {
+				_, err := b.ListBuckets()
+				out = append(out, err != nil)
+			}
+			return out
+		}
+		first := failures(1)
+		if !reflect.DeepEqual(first, failures(1)) {
+			t.Fatal("failures differ with the same seed")
+		}
+		if reflect.DeepEqual(first, failures(2)) {
+			t.Fatal("failures do not depend on the seed")
+		}
+	})
+
+	t.Run("latency", func(t *testing.T) {
+		latency := 20 * time.Millisecond
+		b := newBackend(gofakes3.WithFaultLatency(latency, "ListBuckets"))
+
+		start := time.Now()
+		ts := gofakes3.TT{t}
+		ts.OKAll(b.ListBuckets())
+		if time.Since(start) < latency {
+			t.Fatal("ListBuckets was not delayed")
+		}
+	})
+}
+
+func TestFaultInjector(t *testing.T) {
+	newServer := func(ops map[string]gofakes3.OperationFault) *testServer {
+		return newTestServer(t, withFakerOptions(gofakes3.WithFaultInjector(gofakes3.FaultConfig{Operations: ops})))
+	}
+
+	t.Run("error", func(t *testing.T) {
+		ts := newServer(map[string]gofakes3.OperationFault{
+			"PutObject": {Probability: 1, Error: gofakes3.ErrSlowDown},
+			"*":         {Probability: 1},
+		})
+		defer ts.Close()
+		ts.backendPutString(defaultBucket, "object", nil, "hello")
+
+		for _, tc := range []struct {
+			method string
+			status int
+			code   gofakes3.ErrorCode
+		}{
+			{"PUT", http.StatusServiceUnavailable, gofakes3.ErrSlowDown},
+			{"GET", http.StatusInternalServerError, gofakes3.ErrInternal},
+		} {
+			rq, err := http.NewRequest(tc.method, ts.url(defaultBucket+"/object"), strings.NewReader("changed"))
+			ts.OK(err)
+			rs, err := httpClient().Do(rq)
+			ts.OK(err)
+			defer rs.Body.Close()
+			if rs.StatusCode != tc.status {
+				t.Fatal(tc.method, "unexpected status", rs.StatusCode)
+			}
+			var errResp gofakes3.ErrorResponse
+			ts.OK(xml.NewDecoder(rs.Body).Decode(&errResp))
+			if errResp.Code != tc.code {
+				t.Fatal(tc.method, "unexpected code", errResp.Code)
+			}
+		}
+
+		// The failed request was not handled:
+		if ts.backendGetString(defaultBucket, "object", nil) != "hello" {
+			t.Fatal("object was changed")
+		}
+	})
+
+	t.Run("other-operations", func(t *testing.T) {
+		ts := newServer(map[string]gofakes3.OperationFault{
+			"DeleteObject": {Probability: 1},
+		})
+		defer ts.Close()
+		svc := ts.s3Client()
+
+		_, err := svc.PutObject(&s3.PutObjectInput{
+			Bucket: aws.String(defaultBucket),
+			Key:    aws.String("object"),
+			Body:   bytes.NewReader([]byte("hello")),
+		})
+		ts.OK(err)
+		_, err = svc.DeleteObject(&s3.DeleteObjectInput{
+			Bucket: aws.String(defaultBucket),
+			Key:    aws.String("object"),
+		})
+		if !hasErrorCode(err, gofakes3.ErrInternal) {
+			t.Fatal("expected ErrInternal, found", err)
+		}
+	})
+
+	t.Run("latency", func(t *testing.T) {
+		latency := 20 * time.Millisecond
+		ts := newServer(map[string]gofakes3.OperationFault{
+			"ListBuckets": {Latency: latency},
+		})
+		defer ts.Close()
+
+		start := time.Now()
+		rs, err := httpClient().Get(ts.url("/"))
+		ts.OK(err)
+		rs.Body.Close()
+		if rs.StatusCode != http.StatusOK {
+			t.Fatal("unexpected status", rs.StatusCode)
+		}
+		if time.Since(start) < latency {
+			t.Fatal("ListBuckets was not delayed")
+		}
+	})
+
+	t.Run("latency-cancelled", func(t *testing.T) {
+		ts := newServer(map[string]gofakes3.OperationFault{
+			"*": {Latency: time.Hour},
+		})
+		defer ts.Close()
+
+		ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+		defer cancel()
+		rq, err := http.NewRequest("GET", ts.url("/"), nil)
+		ts.OK(err)
+		if _, err := httpClient().Do(rq.WithContext(ctx)); err == nil {
+			t.Fatal("expected request to time out")
+		}
+	})
+}
+
+// blockingBackend blocks in GetObjectContext until the request is cancelled.
+type blockingBackend struct {
+	gofakes3.Backend
+	started chan struct{}
+	done    chan error
+}
+
+func (b *blockingBackend) GetObjectContext(ctx context.Context, bucketName, objectName string, rangeRequest *gofakes3.ObjectRangeRequest) (*gofakes3.Object, error) {
+	close(b.started)
+	<-ctx.Done()
+	b.done <- ctx.Err()
+	return nil, ctx.Err()
+}
+
+func (b *blockingBackend) PutObjectContext(ctx context.Context, bucketName, key string, meta map[string]string, input io.Reader, size int64) (gofakes3.PutObjectResult, error) {
+	return b.PutObject(bucketName, key, meta, input, size)
+}
+
+func TestContextBackendCancelled(t *testing.T) {
+	backend := &blockingBackend{
+		Backend: s3mem.New(s3mem.WithTimeSource(gofakes3.FixedTimeSource(defaultDate))),
+		started: make(chan struct{}),
+		done:    make(chan error, 1),
+	}
+	ts := newTestServer(t, withBackend(backend))
+	defer ts.Close()
+	ts.backendPutString(defaultBucket, "object", map[string]string{}, "hello")
+
+	ctx, cancel := context.WithCancel(context.Background())
+	rq, err := http.NewRequest("GET", ts.url(defaultBucket+"/object"), nil)
+	ts.OK(err)
+	go func() {
+		rs, err := httpClient().Do(rq.WithContext(ctx))
+		if err == nil {
+			rs.Body.Close()
+		}
+	}()
+
+	<-backend.started
+	cancel()
+	select {
+	case err := <-backend.done:
+		if err != context.Canceled {
+			t.Fatal("expected context.Canceled, found", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("backend was not cancelled")
+	}
+}
+
+// gatedBackend blocks in PutObject until release is closed.
+type gatedBackend struct {
+	gofakes3.Backend
+	started chan struct{}
+	release chan struct{}
+}
+
+func (b *gatedBackend) PutObject(bucketName, key string, meta map[string]string, input io.Reader, size int64) (gofakes3.PutObjectResult, error) {
+	close(b.started)
+	<-b.release
+	return b.Backend.PutObject(bucketName, key, meta, input, size)
+}
+
+func TestShutdown(t *testing.T) {
+	backend := &gatedBackend{
+		Backend: s3mem.New(s3mem.WithTimeSource(gofakes3.FixedTimeSource(defaultDate))),
+		started: make(chan struct{}),
+		release: make(chan struct{}),
+	}
+	ts := newTestServer(t, withBackend(backend))
+	defer ts.Close()
+	svc := ts.s3Client()
+
+	putErr := make(chan error, 1)
+	go func() {
+		_, err := svc.PutObject(&s3.PutObjectInput{
+			Bucket: aws.String(defaultBucket),
+			Key:    aws.String("object"),
+			Body:   bytes.NewReader([]byte("hello")),
+		})
+		putErr <- err
+	}()
+	<-backend.started
+
+	// The PutObject request is still in flight:
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if err := ts.Shutdown(ctx); err != context.DeadlineExceeded {
+		t.Fatal("expected context.DeadlineExceeded, found", err)
+	}
+
+	// New requests are rejected:
+	rs, err := httpClient().Get(ts.url(defaultBucket + "/object"))
+	ts.OK(err)
+	rs.Body.Close()
+	if rs.StatusCode != http.StatusServiceUnavailable {
+		t.Fatal("unexpected status", rs.StatusCode)
+	}
+
+	close(backend.release)
+	ts.OK(ts.Shutdown(context.Background()))
+	if !ts.backendObjectExists(defaultBucket, "object") {
+		t.Fatal("object was not stored before Shutdown returned")
+	}
+	ts.OK(<-putErr)
+}
+
+func TestServe(t *testing.T) {
+	// base is the URL of the server, which the client must be able to dial:
+	serve := func(t *testing.T, l net.Listener, base string, client *http.Client) {
+		backend := s3mem.New()
+		defer backend.Close()
+		if err := backend.CreateBucket(defaultBucket); err != nil {
+			t.Fatal(err)
+		}
+
+		faker := gofakes3.New(backend)
+		served := make(chan error, 1)
+		go func() { served <- faker.Serve(l) }()
+
+		rs, err := client.Head(base + "/" + defaultBucket)
+		if err != nil {
+			t.Fatal(err)
+		}
+		rs.Body.Close()
+		if rs.StatusCode != http.StatusOK {
+			t.Fatal("unexpected status", rs.StatusCode)
+		}
+		if addr := faker.Addr(); addr == nil || addr.String() != l.Addr().String() {
+			t.Fatal("unexpected address", addr)
+		}
+
+		if err := faker.Shutdown(context.Background()); err != nil {
+			t.Fatal(err)
+		}
+		if err := <-served; err != http.ErrServerClosed {
+			t.Fatal("expected http.ErrServerClosed, found", err)
+		}
+		if _, err := client.Head(base + "/" + defaultBucket); err == nil {
+			t.Fatal("expected the listener to be closed")
+		}
+	}
+
+	t.Run("ephemeral port", func(t *testing.T) {
+		l, err := net.Listen("tcp", "127.0.0.1:0")
+		if err != nil {
+			t.Fatal(err)
+		}
+		serve(t, l, "http://"+l.Addr().String(), httpClient())
+	})
+
+	t.Run("unix socket", func(t *testing.T) {
+		dir, err := ioutil.TempDir("", "gofakes3")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer os.RemoveAll(dir)
+		socket := filepath.Join(dir, "s3.sock")
+		l, err := net.Listen("unix", socket)
+		if err != nil {
+			t.Skip("unix sockets are not supported:", err)
+		}
+
+		client := &http.Client{Transport: &http.Transport{
+			DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+				return (&net.Dialer{}).DialContext(ctx, "unix", socket)
+			},
+		}}
+		serve(t, l, "http://s3", client)
+	})
+
+	t.Run("concurrent shutdown", func(t *testing.T) {
+		faker := gofakes3.New(s3mem.New())
+
+		var wg sync.WaitGroup
+		errs := make(chan error, 10)
+		for i := 0; i < cap(errs); i++ {
+			l, err := net.Listen("tcp", "127.0.0.1:0")
+			if err != nil {
+				t.Fatal(err)
+			}
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				errs <- faker.Serve(l)
+			}()
+		}
+		if err := faker.Shutdown(context.Background()); err != nil {
+			t.Fatal(err)
+		}
+		wg.Wait()
+		close(errs)
+		for err := range errs {
+			if err != http.ErrServerClosed {
+				t.Fatal("expected http.ErrServerClosed, found", err)
+			}
+		}
+
+		// Serve refuses to start once GoFakeS3 has been shut down:
+		l, err := net.Listen("tcp", "127.0.0.1:0")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := faker.Serve(l); err != http.ErrServerClosed {
+			t.Fatal("expected http.ErrServerClosed, found", err)
+		}
+		if _, err := l.Accept(); err == nil {
+			t.Fatal("expected the listener to be closed")
+		}
+	})
+}
+
+func TestExpectContinue(t *testing.T) {
+	backend := &gatedBackend{
+		Backend: s3mem.New(s3mem.WithTimeSource(gofakes3.FixedTimeSource(defaultDate))),
+		started: make(chan struct{}),
+		release: make(chan struct{}),
+	}
+	ts := newTestServer(t, withBackend(backend))
+	defer ts.Close()
+	var release sync.Once
+	defer release.Do(func() { close(backend.release) })
+
+	put := func(path string) (*bufio.Reader, net.Conn) {
+		t.Helper()
+		conn, err := net.Dial("tcp", strings.TrimPrefix(ts.server.URL, "http://"))
+		ts.OK(err)
+		_, err = fmt.Fprintf(conn, "PUT %s HTTP/1.1\r\nHost: localhost\r\nContent-Length: 5\r\nExpect: 100-continue\r\n\r\n", path)
+		ts.OK(err)
+		ts.OK(conn.SetReadDeadline(time.Now().Add(5 * time.Second)))
+		return bufio.NewReader(conn), conn
+	}
+
+	// A request that fails gets the error instead of '100 Continue':
+	rd, conn := put("/nope/object")
+	defer conn.Close()
+	rs, err := http.ReadResponse(rd, nil)
+	ts.OK(err)
+	rs.Body.Close()
+	if rs.StatusCode != http.StatusNotFound {
+		t.Fatal("unexpected status", rs.StatusCode)
+	}
+
+	// '100 Continue' is sent before the Backend is ready to read the body:
+	rd, conn = put("/" + defaultBucket + "/object")
+	defer conn.Close()
+	for _, expected := range []string{"HTTP/1.1 100 Continue\r\n", "\r\n"} {
+		line, err := rd.ReadString('\n')
+		ts.OK(err)
+		if line != expected {
+			t.Fatalf("unexpected response %q", line)
+		}
+	}
+	select {
+	case <-backend.started:
+	case <-time.After(5 * time.Second):
+		t.Fatal("PutObject was not called")
+	}
+	_, err = conn.Write([]byte("hello"))
+	ts.OK(err)
+	release.Do(func() { close(backend.release) })
+
+	rs, err = http.ReadResponse(rd, nil)
+	ts.OK(err)
+	rs.Body.Close()
+	if rs.StatusCode != http.StatusOK {
+		t.Fatal("unexpected status", rs.StatusCode)
+	}
+	ts.assertObject(defaultBucket, "object", nil, "hello")
+}
+
+func TestRequestID(t *testing.T) {
+	var buf bytes.Buffer
+	var mu sync.Mutex
+	var next int
+	ts := newTestServer(t, withFakerOptions(
+		gofakes3.WithLogger(gofakes3.StdLog(log.New(&buf, "", 0), gofakes3.LogInfo)),
+		gofakes3.WithTimeSkewLimit(time.Minute),
+		gofakes3.WithRequestID(func() string {
+			mu.Lock()
+			defer mu.Unlock()
+			next++
+			return fmt.Sprintf("REQUEST%d", next)
+		}),
+	))
+	defer ts.Close()
+	ts.backendPutString(defaultBucket, "object", map[string]string{}, "hello")
+
+	get := func(path string, header http.Header) (*http.Response, gofakes3.ErrorResponse) {
+		t.Helper()
+		rq, err := http.NewRequest("GET", ts.url(path), nil)
+		ts.OK(err)
+		for k, v := range header {
+			rq.Header[k] = v
+		}
+		rs, err := httpClient().Do(rq)
+		ts.OK(err)
+		defer rs.Body.Close()
+
+		var errResp gofakes3.ErrorResponse
+		if rs.StatusCode != http.StatusOK {
+			ts.OK(xml.NewDecoder(rs.Body).Decode(&errResp))
+		}
+		return rs, errResp
+	}
+	checkHeaders := func(rs *http.Response, id string) {
+		t.Helper()
+		if found := rs.Header.Get("x-amz-request-id"); found != id {
+			t.Fatal("x-amz-request-id", found, "!=", id)
+		}
+		if rs.Header.Get("x-amz-id-2") == "" {
+			t.Fatal("missing x-amz-id-2")
+		}
+	}
+
+	rs, _ := get(defaultBucket+"/object", nil)
+	checkHeaders(rs, "REQUEST1")
+
+	rs, errResp := get(defaultBucket+"/missing", nil)
+	checkHeaders(rs, "REQUEST2")
+	if errResp.Code != gofakes3.ErrNoSuchKey || errResp.RequestID != "REQUEST2" {
+		t.Fatal("unexpected error", errResp.Code, errResp.RequestID)
+	}
+
+	// Errors returned by middleware outside the router also have an ID:
+	rs, errResp = get(defaultBucket+"/object", http.Header{"X-Amz-Date": {"20000101T000000Z"}})
+	checkHeaders(rs, "REQUEST3")
+	if errResp.Code != gofakes3.ErrRequestTimeTooSkewed || errResp.RequestID != "REQUEST3" {
+		t.Fatal("unexpected error", errResp.Code, errResp.RequestID)
+	}
+
+	if !strings.Contains(buf.String(), "INFO REQUEST REQUEST2: GET /mybucket/missing\n") {
+		t.Fatal("request ID not logged:", buf.String())
+	}
+}
+
+func TestEventHook(t *testing.T) {
+	var mu sync.Mutex
+	var events []gofakes3.Event
+	hook := func(ev gofakes3.Event) {
+		mu.Lock()
+		defer mu.Unlock()
+		events = append(events, ev)
+	}
+
+	ts := newTestServer(t, withVersioning(), withFakerOptions(gofakes3.WithEventHook(hook)))
+	defer ts.Close()
+	svc := ts.s3Client()
+
+	put, err := svc.PutObject(&s3.PutObjectInput{
+		Bucket: aws.String(defaultBucket),
+		Key:    aws.String("object"),
+		Body:   bytes.NewReader([]byte("hello")),
+	})
+	ts.OK(err)
+	ts.OKAll(svc.CopyObject(&s3.CopyObjectInput{
+		Bucket:     aws.String(defaultBucket),
+		Key:        aws.String("copy"),
+		CopySource: aws.String(defaultBucket + "/object"),
+	}))
 
-		setVersioning(ts, gofakes3.VersioningEnabled)
-		setVersioning(ts, gofakes3.VersioningSuspended)
-		assertVersioning(ts, "", "Suspended")
+	uploadID := ts.createMultipartUpload(defaultBucket, "upload", nil)
+	part := ts.uploadPart(defaultBucket, "upload", uploadID, 1, []byte("multipart"))
+	ts.assertCompleteUpload(defaultBucket, "upload", uploadID, []*s3.CompletedPart{part}, "multipart")
+
+	del, err := svc.DeleteObject(&s3.DeleteObjectInput{Bucket: aws.String(defaultBucket), Key: aws.String("object")})
+	ts.OK(err)
+	ts.OKAll(svc.DeleteObject(&s3.DeleteObjectInput{
+		Bucket: aws.String(defaultBucket), Key: aws.String("object"), VersionId: put.VersionId,
+	}))
+	ts.OKAll(svc.DeleteObjects(&s3.DeleteObjectsInput{
+		Bucket: aws.String(defaultBucket),
+		Delete: &s3.Delete{Objects: []*s3.ObjectIdentifier{{Key: aws.String("copy")}, {Key: aws.String("upload")}}},
+	}))
+
+	// Failed requests must not produce events:
+	_, err = svc.DeleteObject(&s3.DeleteObjectInput{Bucket: aws.String("nope"), Key: aws.String("object")})
+	if !s3HasErrorCode(err, gofakes3.ErrNoSuchBucket) {
+		t.Fatal("expected ErrNoSuchBucket, found", err)
+	}
+
+	type summary struct {
+		Type gofakes3.EventType
+		Key  string
+		Size int64
+		ETag string
+	}
+	expected := []summary{
+		{gofakes3.EventObjectCreatedPut, "object", 5, hashMD5Bytes([]byte("hello")).Hex()},
+		{gofakes3.EventObjectCreatedCopy, "copy", 5, hashMD5Bytes([]byte("hello")).Hex()},
+		{gofakes3.EventObjectCreatedCompleteMultipartUpload, "upload", 9, "9c4588807dc1ac3883f9700c285fb855-1"}, // md5(md5("multipart"))-1
+		{gofakes3.EventObjectRemovedDeleteMarkerCreated, "object", 0, ""},
+		{gofakes3.EventObjectRemovedDelete, "object", 0, ""},
+		{gofakes3.EventObjectRemovedDeleteMarkerCreated, "copy", 0, ""},
+		{gofakes3.EventObjectRemovedDeleteMarkerCreated, "upload", 0, ""},
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	var found []summary
+	for _, ev := range events {
+		if ev.Bucket != defaultBucket || !ev.Time.Equal(defaultDate) {
+			t.Fatal("unexpected event", ev)
+		}
+		found = append(found, summary{ev.Type, ev.Key, ev.Size, ev.ETag})
+	}
+	if !reflect.DeepEqual(found, expected) {
+		t.Fatal(found, "!=", expected)
+	}
+
+	if events[0].VersionID != gofakes3.VersionID(*put.VersionId) {
+		t.Fatal("put version", events[0].VersionID, "!=", *put.VersionId)
+	}
+	if events[3].VersionID != gofakes3.VersionID(*del.VersionId) {
+		t.Fatal("delete marker version", events[3].VersionID, "!=", *del.VersionId)
+	}
+	if events[4].VersionID != gofakes3.VersionID(*put.VersionId) {
+		t.Fatal("deleted version", events[4].VersionID, "!=", *put.VersionId)
+	}
+}
+
+func TestEventHookPanic(t *testing.T) {
+	hook := func(ev gofakes3.Event) { panic("boom") }
+	ts := newTestServer(t, withFakerOptions(gofakes3.WithEventHook(hook)))
+	defer ts.Close()
+
+	ts.OKAll(ts.s3Client().PutObject(&s3.PutObjectInput{
+		Bucket: aws.String(defaultBucket),
+		Key:    aws.String("object"),
+		Body:   bytes.NewReader([]byte("hello")),
+	}))
+	if !ts.backendObjectExists(defaultBucket, "object") {
+		t.Fatal("object not created")
+	}
+}
+
+func TestZeroByteObject(t *testing.T) {
+	const emptyETag = `"d41d8cd98f00b204e9800998ecf8427e"`
+
+	for _, tc := range bundledBackends() {
+		t.Run(tc.name, func(t *testing.T) {
+			ts := newTestServer(t, tc.options(t)...)
+			defer ts.Close()
+			svc := ts.s3Client()
+
+			out, err := svc.PutObject(&s3.PutObjectInput{
+				Bucket: aws.String(defaultBucket),
+				Key:    aws.String("empty"),
+				Body:   bytes.NewReader(nil),
+			})
+			ts.OK(err)
+			if etag := aws.StringValue(out.ETag); etag != emptyETag {
+				t.Fatal("unexpected PUT ETag", etag)
+			}
+
+			for _, method := range []string{"HEAD", "GET"} {
+				rq, err := http.NewRequest(method, ts.url("/"+defaultBucket+"/empty"), nil)
+				ts.OK(err)
+				rs, err := httpClient().Do(rq)
+				ts.OK(err)
+				body, err := ioutil.ReadAll(rs.Body)
+				rs.Body.Close()
+				ts.OK(err)
+
+				if rs.StatusCode != http.StatusOK {
+					t.Fatal(method, "unexpected status", rs.StatusCode)
+				}
+				if etag := rs.Header.Get("ETag"); etag != emptyETag {
+					t.Fatal(method, "unexpected ETag", etag)
+				}
+				if length := rs.Header.Get("Content-Length"); length != "0" {
+					t.Fatalf("%s: unexpected Content-Length %q", method, length)
+				}
+				if len(body) != 0 {
+					t.Fatal(method, "unexpected body", body)
+				}
+			}
+		})
+	}
+}
+
+// Every Backend must page through a listing in the same way, as GoFakeS3 does
+// not fall back to an unpaged listing here.
+func TestListBucketMaxKeys(t *testing.T) {
+	// None of these is a directory of another, so they suit s3afero too:
+	keys := []string{"c0", "a/c/e", "b", "a-b", "c/d", "a/b", "a/c/d"}
+
+	for _, backend := range bundledBackends() {
+		t.Run(backend.name, func(t *testing.T) {
+			ts := newTestServer(t, backend.options(t, withFakerOptions(gofakes3.WithUnimplementedPageError()))...)
+			defer ts.Close()
+			svc := ts.s3Client()
+
+			for _, key := range keys {
+				ts.backendPutString(defaultBucket, key, nil, "body")
+			}
+
+			for _, tc := range []struct {
+				prefix, delim string
+				items         []string // Contents and CommonPrefixes, in order
+			}{
+				{items: []string{"a-b", "a/b", "a/c/d", "a/c/e", "b", "c/d", "c0"}},
+				{prefix: "a", items: []string{"a-b", "a/b", "a/c/d", "a/c/e"}},
+				{delim: "/", items: []string{"a-b", "a/", "b", "c/", "c0"}},
+				{prefix: "a/", delim: "/", items: []string{"a/b", "a/c/"}},
+				{prefix: "nope", delim: "/"},
+			} {
+				for maxKeys := int64(1); maxKeys <= int64(len(tc.items))+1; maxKeys++ {
+					var found []string
+					var marker *string
+					for page := 0; ; page++ {
+						if page > len(keys) {
+							t.Fatal("listing did not end", tc.prefix, tc.delim, maxKeys)
+						}
+						rs, err := svc.ListObjects(&s3.ListObjectsInput{
+							Bucket:    aws.String(defaultBucket),
+							Prefix:    aws.String(tc.prefix),
+							Delimiter: aws.String(tc.delim),
+							Marker:    marker,
+							MaxKeys:   aws.Int64(maxKeys),
+						})
+						ts.OK(err)
+
+						// Contents and CommonPrefixes are listed separately, but
+						// together they are in key order:
+						var items []string
+						for _, v := range rs.Contents {
+							items = append(items, aws.StringValue(v.Key))
+						}
+						for _, v := range rs.CommonPrefixes {
+							items = append(items, aws.StringValue(v.Prefix))
+						}
+						sort.Strings(items)
+						if int64(len(items)) > maxKeys {
+							t.Fatalf("%q %q: %d items in page of %d", tc.prefix, tc.delim, len(items), maxKeys)
+						}
+						found = append(found, items...)
+
+						if !aws.BoolValue(rs.IsTruncated) {
+							break
+						}
+						if len(items) == 0 {
+							t.Fatal("truncated empty page", tc.prefix, tc.delim, maxKeys)
+						}
+						// Without a delimiter there is no NextMarker, and the
+						// last key is used instead:
+						marker = rs.NextMarker
+						if tc.delim == "" {
+							marker = rs.Contents[len(rs.Contents)-1].Key
+						} else if aws.StringValue(marker) != items[len(items)-1] {
+							t.Fatalf("%q %q: next marker %q, expected %q", tc.prefix, tc.delim, aws.StringValue(marker), items[len(items)-1])
+						}
+					}
+
+					if !reflect.DeepEqual(found, tc.items) {
+						t.Fatalf("%q %q max keys %d:\nexp: %q\ngot: %q", tc.prefix, tc.delim, maxKeys, tc.items, found)
+					}
+				}
+			}
+
+			// max-keys=0 lists nothing, and there is nothing more to list:
+			rs, err := svc.ListObjects(&s3.ListObjectsInput{
+				Bucket:  aws.String(defaultBucket),
+				MaxKeys: aws.Int64(0),
+			})
+			ts.OK(err)
+			if len(rs.Contents) != 0 || len(rs.CommonPrefixes) != 0 || aws.BoolValue(rs.IsTruncated) {
+				t.Fatal("unexpected max-keys=0 listing", rs)
+			}
+
+			// The continuation tokens of List Objects V2 page the same way:
+			var found []string
+			var token *string
+			for {
+				rs, err := svc.ListObjectsV2(&s3.ListObjectsV2Input{
+					Bucket:            aws.String(defaultBucket),
+					Delimiter:         aws.String("/"),
+					ContinuationToken: token,
+					MaxKeys:           aws.Int64(2),
+				})
+				ts.OK(err)
+				for _, v := range rs.Contents {
+					found = append(found, aws.StringValue(v.Key))
+				}
+				for _, v := range rs.CommonPrefixes {
+					found = append(found, aws.StringValue(v.Prefix))
+				}
+				if !aws.BoolValue(rs.IsTruncated) {
+					break
+				}
+				token = rs.NextContinuationToken
+			}
+			sort.Strings(found)
+			if exp := []string{"a-b", "a/", "b", "c/", "c0"}; !reflect.DeepEqual(found, exp) {
+				t.Fatalf("v2:\nexp: %q\ngot: %q", exp, found)
+			}
+		})
+	}
+}
+
+func TestHeadObjectHeaders(t *testing.T) {
+	ts := newTestServer(t, withVersioning(), withFakerOptions(gofakes3.WithResponseCompression(0)))
+	defer ts.Close()
+
+	ts.backendPutString(defaultBucket, "empty", nil, "")
+	old, err := ts.backend.PutObject(defaultBucket, "meta", map[string]string{
+		"Content-Type":   "text/plain",
+		"X-Amz-Meta-Foo": "old",
+	}, strings.NewReader("old"), 3)
+	ts.OK(err)
+	ts.backendPutString(defaultBucket, "meta", map[string]string{
+		"Content-Type":   "application/json",
+		"X-Amz-Meta-Foo": "bar",
+		"X-Amz-Meta-Baz": "qux",
+	}, "{}")
+
+	for _, tc := range []struct {
+		method, path string
+		headers      map[string]string
+	}{
+		{"HEAD", "empty", map[string]string{"Content-Length": "0", "Content-Type": "binary/octet-stream"}},
+		{"GET", "empty", map[string]string{"Content-Length": "0", "Content-Type": "binary/octet-stream"}},
+		{"HEAD", "meta", map[string]string{"Content-Length": "2", "Content-Type": "application/json", "X-Amz-Meta-Foo": "bar", "X-Amz-Meta-Baz": "qux"}},
+		{"HEAD", "meta?versionId=" + string(old.VersionID), map[string]string{"Content-Length": "3", "Content-Type": "text/plain", "X-Amz-Meta-Foo": "old", "X-Amz-Meta-Baz": ""}},
+	} {
+		t.Run(tc.method+" "+tc.path, func(t *testing.T) {
+			rq, err := http.NewRequest(tc.method, ts.url("/"+defaultBucket+"/"+tc.path), nil)
+			ts.OK(err)
+			rq.Header.Set("Accept-Encoding", "gzip")
+			rs, err := httpClient().Do(rq)
+			ts.OK(err)
+			defer rs.Body.Close()
+
+			if rs.StatusCode != http.StatusOK {
+				t.Fatal("unexpected status", rs.StatusCode)
+			}
+			if v := rs.Header.Get("Accept-Ranges"); v != "bytes" {
+				t.Fatalf("unexpected Accept-Ranges %q", v)
+			}
+			for k, v := range tc.headers {
+				if found := rs.Header.Get(k); found != v {
+					t.Fatalf("unexpected %s %q, expected %q", k, found, v)
+				}
+			}
+		})
+	}
+}
+
+func TestObjectLastModified(t *testing.T) {
+	ts := newTestServer(t)
+	defer ts.Close()
+	svc := ts.s3Client()
+
+	// Objects stored directly in the backend have no Last-Modified in their
+	// metadata, so this must come from the backend too:
+	ts.backendPutString(defaultBucket, "object", nil, "hello")
+
+	head := func() time.Time {
+		t.Helper()
+		out, err := svc.HeadObject(&s3.HeadObjectInput{
+			Bucket: aws.String(defaultBucket),
+			Key:    aws.String("object"),
+		})
+		ts.OK(err)
+		if out.LastModified == nil {
+			t.Fatal("missing Last-Modified")
+		}
+		return *out.LastModified
+	}
+
+	first := head()
+	ts.Advance(time.Minute)
+	if second := head(); !second.Equal(first) {
+		t.Fatal("Last-Modified of an unchanged object changed from", first, "to", second)
+	}
+	if !first.Equal(defaultDate) {
+		t.Fatal("unexpected Last-Modified", first, "expected", defaultDate)
+	}
+
+	list, err := svc.ListObjects(&s3.ListObjectsInput{Bucket: aws.String(defaultBucket)})
+	ts.OK(err)
+	if len(list.Contents) != 1 || !list.Contents[0].LastModified.Equal(first) {
+		t.Fatal("listed Last-Modified does not match HEAD:", list.Contents)
+	}
+
+	_, err = svc.PutObject(&s3.PutObjectInput{
+		Bucket: aws.String(defaultBucket),
+		Key:    aws.String("object"),
+		Body:   bytes.NewReader([]byte("world")),
+	})
+	ts.OK(err)
+	if overwritten := head(); !overwritten.Equal(defaultDate.Add(time.Minute)) {
+		t.Fatal("unexpected Last-Modified after overwrite", overwritten)
+	}
+}
+
+func TestResponseCompression(t *testing.T) {
+	ts := newTestServer(t, withFakerOptions(gofakes3.WithResponseCompression(100)))
+	defer ts.Close()
+
+	// Large enough that the compressed response doesn't fit in the server's
+	// write buffer, which would otherwise add a Content-Length:
+	random := make([]byte, 32<<10)
+	rand.New(rand.NewSource(0)).Read(random)
+	large := hex.EncodeToString(random)
+	ts.backendPutString(defaultBucket, "large", nil, large)
+	ts.backendPutString(defaultBucket, "small", nil, "tiny")
+	ts.backendPutString(defaultBucket, "encoded", map[string]string{"Content-Encoding": "br"}, large)
+
+	get := func(key, accept, rnge string) (rs *http.Response, body string) {
+		t.Helper()
+		rq, err := http.NewRequest("GET", ts.url("/"+defaultBucket+"/"+key), nil)
+		ts.OK(err)
+		if accept != "" {
+			rq.Header.Set("Accept-Encoding", accept)
+		}
+		if rnge != "" {
+			rq.Header.Set("Range", rnge)
+		}
+
+		// Setting Accept-Encoding stops the client from decompressing the
+		// response itself:
+		rs, err = httpClient().Do(rq)
+		ts.OK(err)
+		defer rs.Body.Close()
+
+		var rdr io.Reader = rs.Body
+		if rs.Header.Get("Content-Encoding") == "gzip" {
+			gz, err := gzip.NewReader(rs.Body)
+			ts.OK(err)
+			rdr = gz
+		}
+		bts, err := ioutil.ReadAll(rdr)
+		ts.OK(err)
+		return rs, string(bts)
+	}
+
+	for _, tc := range []struct {
+		key, accept, rnge string
+		compressed        bool
+		body              string
+	}{
+		{key: "large", accept: "gzip", compressed: true, body: large},
+		{key: "large", accept: "br, gzip;q=0.5", compressed: true, body: large},
+		{key: "large", accept: "gzip;q=0", body: large},
+		{key: "large", body: large},
+		{key: "large", accept: "gzip", rnge: "bytes=0-11", body: large[:12]},
+		{key: "small", accept: "gzip", body: "tiny"},
+		{key: "encoded", accept: "gzip", body: large},
+	} {
+		t.Run(fmt.Sprintf("%s/%s/%s", tc.key, tc.accept, tc.rnge), func(t *testing.T) {
+			rs, body := get(tc.key, tc.accept, tc.rnge)
+			if body != tc.body {
+				t.Fatalf("unexpected body %q", body)
+			}
+			if compressed := rs.Header.Get("Content-Encoding") == "gzip"; compressed != tc.compressed {
+				t.Fatal("compressed", compressed, "!=", tc.compressed)
+			}
+			if tc.compressed && (rs.ContentLength != -1 || rs.Header.Get("Content-Length") != "") {
+				t.Fatal("unexpected Content-Length", rs.ContentLength)
+			}
+			if tc.key == "encoded" && rs.Header.Get("Content-Encoding") != "br" {
+				t.Fatal("stored Content-Encoding not returned", rs.Header.Get("Content-Encoding"))
+			}
+		})
+	}
+}
+
+func TestPutObjectContentEncoding(t *testing.T) {
+	ts := newTestServer(t)
+	defer ts.Close()
+
+	ts.OKAll(ts.s3Client().PutObject(&s3.PutObjectInput{
+		Bucket:          aws.String(defaultBucket),
+		Key:             aws.String("object"),
+		Body:            bytes.NewReader([]byte("hello")),
+		ContentEncoding: aws.String("aws-chunked,gzip"),
+	}))
+
+	obj, err := ts.backend.HeadObject(defaultBucket, "object")
+	ts.OK(err)
+	if v := obj.Metadata["Content-Encoding"]; v != "gzip" {
+		t.Fatalf("bad Content-Encoding: %q", v)
+	}
+}
+
+func TestHeadBucket(t *testing.T) {
+	// The handler is called directly, as the client would discard any body
+	// sent in response to a HEAD request:
+	head := func(handler http.Handler, bucket string, sign func(rq *http.Request)) *httptest.ResponseRecorder {
+		t.Helper()
+		rq := httptest.NewRequest("HEAD", "/"+bucket, nil)
+		if sign != nil {
+			sign(rq)
+		}
+		rs := httptest.NewRecorder()
+		handler.ServeHTTP(rs, rq)
+		return rs
+	}
+	assertHead := func(rs *httptest.ResponseRecorder, status int, region string) {
+		t.Helper()
+		if rs.Code != status {
+			t.Fatal("expected status", status, "found", rs.Code)
+		}
+		if rs.Body.Len() != 0 {
+			t.Fatal("unexpected body", rs.Body.String())
+		}
+		if v := rs.Header().Get("x-amz-bucket-region"); v != region {
+			t.Fatalf("x-amz-bucket-region %q, expected %q", v, region)
+		}
+	}
+
+	t.Run("exists", func(t *testing.T) {
+		ts := newTestServer(t)
+		defer ts.Close()
+		assertHead(head(ts.Server(), defaultBucket, nil), http.StatusOK, gofakes3.DefaultRegion)
+		assertHead(head(ts.Server(), "missing", nil), http.StatusNotFound, "")
+
+		_, err := ts.s3Client().HeadBucket(&s3.HeadBucketInput{Bucket: aws.String("missing")})
+		if rerr, ok := err.(awserr.RequestFailure); !ok || rerr.StatusCode() != http.StatusNotFound {
+			t.Fatal("expected 404, found", err)
+		}
+	})
+
+	t.Run("authentication", func(t *testing.T) {
+		ts := newTestServer(t, withFakerOptions(
+			gofakes3.WithAuthentication(map[string]string{"dummy-access": "dummy-secret"}),
+		))
+		defer ts.Close()
+
+		sign := func(secret string) func(rq *http.Request) {
+			return func(rq *http.Request) {
+				signer := v4.NewSigner(credentials.NewStaticCredentials("dummy-access", secret, ""))
+				if _, err := signer.Sign(rq, nil, "s3", "region", time.Now()); err != nil {
+					t.Fatal(err)
+				}
+			}
+		}
+
+		assertHead(head(ts.Server(), defaultBucket, sign("dummy-secret")), http.StatusOK, gofakes3.DefaultRegion)
+		assertHead(head(ts.Server(), "missing", sign("dummy-secret")), http.StatusNotFound, "")
+		assertHead(head(ts.Server(), defaultBucket, sign("wrong-secret")), http.StatusForbidden, "")
+		assertHead(head(ts.Server(), defaultBucket, nil), http.StatusForbidden, "")
+	})
+}
+
+func TestRegion(t *testing.T) {
+	t.Run("default", func(t *testing.T) {
+		ts := newTestServer(t)
+		defer ts.Close()
+
+		// The test client's region is not us-east-1, so it sends a
+		// LocationConstraint, which is accepted if WithRegion was not used:
+		ts.OKAll(ts.s3Client().CreateBucket(&s3.CreateBucketInput{Bucket: aws.String("other")}))
+
+		rs, err := httpClient().Head(ts.url("/" + defaultBucket))
+		ts.OK(err)
+		rs.Body.Close()
+		if region := rs.Header.Get("x-amz-bucket-region"); region != gofakes3.DefaultRegion {
+			t.Fatal(region, "!=", gofakes3.DefaultRegion)
+		}
+	})
+
+	t.Run("configured", func(t *testing.T) {
+		ts := newTestServer(t, withFakerOptions(gofakes3.WithRegion("region")))
+		defer ts.Close()
+		svc := ts.s3Client()
+
+		out, err := svc.GetBucketLocation(&s3.GetBucketLocationInput{Bucket: aws.String(defaultBucket)})
+		ts.OK(err)
+		if aws.StringValue(out.LocationConstraint) != "region" {
+			t.Fatal(aws.StringValue(out.LocationConstraint), "!= region")
+		}
+
+		rs, err := httpClient().Head(ts.url("/" + defaultBucket))
+		ts.OK(err)
+		rs.Body.Close()
+		if region := rs.Header.Get("x-amz-bucket-region"); region != "region" {
+			t.Fatal(region, "!= region")
+		}
+
+		ts.OKAll(svc.CreateBucket(&s3.CreateBucketInput{
+			Bucket:                    aws.String("matching"),
+			CreateBucketConfiguration: &s3.CreateBucketConfiguration{LocationConstraint: aws.String("region")},
+		}))
+
+		_, err = svc.CreateBucket(&s3.CreateBucketInput{
+			Bucket:                    aws.String("mismatched"),
+			CreateBucketConfiguration: &s3.CreateBucketConfiguration{LocationConstraint: aws.String("eu-west-1")},
+		})
+		if !s3HasErrorCode(err, gofakes3.ErrInvalidLocationConstraint) {
+			t.Fatal("expected ErrInvalidLocationConstraint, found", err)
+		}
+		if exists, _ := ts.backend.BucketExists("mismatched"); exists {
+			t.Fatal("bucket was created")
+		}
+
+		// A request without a body is created in the server's region:
+		rq, err := http.NewRequest("PUT", ts.url("/nobody"), nil)
+		ts.OK(err)
+		rs, err = httpClient().Do(rq)
+		ts.OK(err)
+		rs.Body.Close()
+		if rs.StatusCode != http.StatusOK {
+			t.Fatal("unexpected status", rs.StatusCode)
+		}
+	})
+
+	t.Run("us-east-1", func(t *testing.T) {
+		ts := newTestServer(t, withFakerOptions(gofakes3.WithRegion("us-east-1")))
+		defer ts.Close()
+		svc := ts.s3Client()
+
+		out, err := svc.GetBucketLocation(&s3.GetBucketLocationInput{Bucket: aws.String(defaultBucket)})
+		ts.OK(err)
+		if out.LocationConstraint != nil {
+			t.Fatal("location-constraint-not-empty", *out.LocationConstraint)
+		}
+
+		_, err = svc.CreateBucket(&s3.CreateBucketInput{
+			Bucket:                    aws.String("explicit"),
+			CreateBucketConfiguration: &s3.CreateBucketConfiguration{LocationConstraint: aws.String("us-east-1")},
+		})
+		if !s3HasErrorCode(err, gofakes3.ErrInvalidLocationConstraint) {
+			t.Fatal("expected ErrInvalidLocationConstraint, found", err)
+		}
+	})
+}
+
+func TestGetObjectResponseOverrides(t *testing.T) {
+	ts := newTestServer(t)
+	defer ts.Close()
+	svc := ts.s3Client()
+
+	ts.backendPutString(defaultBucket, "object", map[string]string{
+		"Content-Type":        "text/plain",
+		"Content-Disposition": "inline",
+	}, "hello world")
+
+	for _, rnge := range []string{"", "bytes=0-4"} {
+		t.Run(rnge, func(t *testing.T) {
+			input := &s3.GetObjectInput{
+				Bucket:                     aws.String(defaultBucket),
+				Key:                        aws.String("object"),
+				ResponseCacheControl:       aws.String("no-cache"),
+				ResponseContentDisposition: aws.String(`attachment; filename="hello.txt"`),
+				ResponseContentEncoding:    aws.String("identity"),
+				ResponseContentLanguage:    aws.String("en-GB"),
+				ResponseContentType:        aws.String("application/octet-stream"),
+				ResponseExpires:            aws.Time(defaultDate),
+			}
+			if rnge != "" {
+				input.Range = aws.String(rnge)
+			}
+			out, err := svc.GetObject(input)
+			ts.OK(err)
+			defer out.Body.Close()
+
+			for _, tc := range []struct{ header, found, expected string }{
+				{"Cache-Control", aws.StringValue(out.CacheControl), "no-cache"},
+				{"Content-Disposition", aws.StringValue(out.ContentDisposition), `attachment; filename="hello.txt"`},
+				{"Content-Encoding", aws.StringValue(out.ContentEncoding), "identity"},
+				{"Content-Language", aws.StringValue(out.ContentLanguage), "en-GB"},
+				{"Content-Type", aws.StringValue(out.ContentType), "application/octet-stream"},
+			} {
+				if tc.found != tc.expected {
+					t.Errorf("%s: %q != %q", tc.header, tc.found, tc.expected)
+				}
+			}
+			// The SDK sends response-expires as ISO 8601, which is passed
+			// through untouched:
+			if expires := aws.StringValue(out.Expires); expires != defaultDate.Format(time.RFC3339) {
+				t.Error("unexpected Expires", expires)
+			}
+			if rnge != "" && aws.StringValue(out.ContentRange) != "bytes 0-4/11" {
+				t.Error("unexpected Content-Range", aws.StringValue(out.ContentRange))
+			}
+		})
+	}
+
+	// Without the overrides, the stored metadata is returned:
+	out, err := svc.GetObject(&s3.GetObjectInput{Bucket: aws.String(defaultBucket), Key: aws.String("object")})
+	ts.OK(err)
+	defer out.Body.Close()
+	if aws.StringValue(out.ContentType) != "text/plain" || aws.StringValue(out.ContentDisposition) != "inline" {
+		t.Fatal("unexpected headers", aws.StringValue(out.ContentType), aws.StringValue(out.ContentDisposition))
+	}
+}
+
+func TestGetObjectAttributes(t *testing.T) {
+	ts := newTestServer(t, withVersioning())
+	defer ts.Close()
+	svc := ts.s3Client()
+
+	attributes := func(object string, hdrs map[string]string) (*gofakes3.GetObjectAttributesResult, *http.Response) {
+		t.Helper()
+		rq, err := http.NewRequest("GET", ts.url("/"+defaultBucket+"/"+object), nil)
+		ts.OK(err)
+		for k, v := range hdrs {
+			rq.Header.Set(k, v)
+		}
+		rs, err := httpClient().Do(rq)
+		ts.OK(err)
+		defer rs.Body.Close()
+
+		if rs.StatusCode != http.StatusOK {
+			return nil, rs
+		}
+		var result gofakes3.GetObjectAttributesResult
+		ts.OK(xml.NewDecoder(rs.Body).Decode(&result))
+		return &result, rs
+	}
+
+	t.Run("object", func(t *testing.T) {
+		sha := sha256.Sum256([]byte("hello"))
+		sum := base64.StdEncoding.EncodeToString(sha[:])
+		ts.backendPutString(defaultBucket, "plain", map[string]string{"X-Amz-Checksum-Sha256": sum}, "hello")
+
+		result, rs := attributes("plain?attributes", map[string]string{
+			"x-amz-object-attributes": "ETag,Checksum,ObjectParts,StorageClass,ObjectSize",
+		})
+		if result == nil {
+			t.Fatal("unexpected status", rs.StatusCode)
+		}
+		if result.ETag != hashMD5Bytes([]byte("hello")).Hex() {
+			t.Fatal("unexpected etag", result.ETag)
+		}
+		if result.Checksum == nil || result.Checksum.ChecksumSHA256 != sum {
+			t.Fatal("unexpected checksum", result.Checksum)
+		}
+		if result.ObjectParts != nil {
+			t.Fatal("unexpected parts", result.ObjectParts)
+		}
+		if result.StorageClass != gofakes3.StorageStandard {
+			t.Fatal("unexpected storage class", result.StorageClass)
+		}
+		if result.ObjectSize == nil || *result.ObjectSize != 5 {
+			t.Fatal("unexpected size", result.ObjectSize)
+		}
+
+		// Only the requested attributes are returned:
+		result, _ = attributes("plain?attributes", map[string]string{"x-amz-object-attributes": "ObjectSize"})
+		if result.ETag != "" || result.Checksum != nil || result.StorageClass != "" || result.ObjectSize == nil {
+			t.Fatal("unexpected attributes", result)
+		}
 	})
 
-	t.Run("no-versioning-suspend", func(t *testing.T) {
-		ts := newTestServer(t, withFakerOptions(
-			gofakes3.WithoutVersioning(),
-		))
-		defer ts.Close()
+	t.Run("multipart", func(t *testing.T) {
+		uploadID := ts.createMultipartUpload(defaultBucket, "multi", nil)
+		sizes := []int{5 << 20, 5 << 20, 5 << 20, 3}
+		var parts []*s3.CompletedPart
+		for i, size := range sizes {
+			parts = append(parts, ts.uploadPart(defaultBucket, "multi", uploadID, int64(i+1), make([]byte, size)))
+		}
+		_, err := svc.CompleteMultipartUpload(&s3.CompleteMultipartUploadInput{
+			Bucket:          aws.String(defaultBucket),
+			Key:             aws.String("multi"),
+			UploadId:        aws.String(uploadID),
+			MultipartUpload: &s3.CompletedMultipartUpload{Parts: parts},
+		})
+		ts.OK(err)
 
-		setVersioning(ts, "Suspended")
-		assertVersioning(ts, "", "")
-	})
+		result, _ := attributes("multi?attributes", map[string]string{
+			"x-amz-object-attributes":  "ETag,ObjectParts",
+			"x-amz-max-parts":          "2",
+			"x-amz-part-number-marker": "1",
+		})
+		if !strings.HasSuffix(result.ETag, "-4") {
+			t.Fatal("unexpected etag", result.ETag)
+		}
+		op := result.ObjectParts
+		if op == nil || op.PartsCount != 4 || op.PartNumberMarker != 1 || op.NextPartNumberMarker != 3 || op.MaxParts != 2 || !op.IsTruncated {
+			t.Fatal("unexpected parts", op)
+		}
+		if len(op.Parts) != 2 || op.Parts[0].PartNumber != 2 || op.Parts[1].PartNumber != 3 || op.Parts[1].Size != 5<<20 {
+			t.Fatal("unexpected parts", op.Parts)
+		}
 
-	t.Run("no-versioning-enable", func(t *testing.T) {
-		ts := newTestServer(t, withFakerOptions(
-			gofakes3.WithoutVersioning(),
-		))
-		defer ts.Close()
+		result, _ = attributes("multi?attributes", map[string]string{
+			"x-amz-object-attributes":  "ObjectParts",
+			"x-amz-part-number-marker": "3",
+		})
+		op = result.ObjectParts
+		if op.IsTruncated || len(op.Parts) != 1 || op.Parts[0].PartNumber != 4 || op.Parts[0].Size != 3 {
+			t.Fatal("unexpected parts", op)
+		}
 
-		svc := ts.s3Client()
-		_, err := svc.PutBucketVersioning(&s3.PutBucketVersioningInput{
+		// The stored parts are not returned by HEAD:
+		rq, err := http.NewRequest("HEAD", ts.url("/"+defaultBucket+"/multi"), nil)
+		ts.OK(err)
+		rs, err := httpClient().Do(rq)
+		ts.OK(err)
+		rs.Body.Close()
+		for k := range rs.Header {
+			if strings.Contains(k, "Gofakes3") {
+				t.Fatal("unexpected header", k)
+			}
+		}
+
+		// Nor are they kept when the object is replaced:
+		ts.backendPutString(defaultBucket, "multi", map[string]string{}, "replaced")
+		result, _ = attributes("multi?attributes", map[string]string{"x-amz-object-attributes": "ETag,ObjectParts"})
+		if result.ETag != hashMD5Bytes([]byte("replaced")).Hex() || result.ObjectParts != nil {
+			t.Fatal("unexpected attributes", result)
+		}
+	})
+
+	t.Run("version", func(t *testing.T) {
+		first, err := svc.PutObject(&s3.PutObjectInput{
 			Bucket: aws.String(defaultBucket),
-			VersioningConfiguration: &s3.VersioningConfiguration{
-				Status: aws.String("Enabled"),
-			},
+			Key:    aws.String("versioned"),
+			Body:   strings.NewReader("first"),
 		})
-		if !hasErrorCode(err, gofakes3.ErrNotImplemented) {
-			ts.Fatal("expected ErrNotImplemented, found", err)
+		ts.OK(err)
+		ts.OKAll(svc.PutObject(&s3.PutObjectInput{
+			Bucket: aws.String(defaultBucket),
+			Key:    aws.String("versioned"),
+			Body:   strings.NewReader("second version"),
+		}))
+
+		result, rs := attributes("versioned?attributes&versionId="+aws.StringValue(first.VersionId), map[string]string{
+			"x-amz-object-attributes": "ObjectSize",
+		})
+		if result == nil || *result.ObjectSize != 5 {
+			t.Fatal("unexpected result", rs.StatusCode, result)
+		}
+		if v := rs.Header.Get("x-amz-version-id"); v != aws.StringValue(first.VersionId) {
+			t.Fatal("unexpected version", v)
+		}
+	})
+
+	t.Run("invalid", func(t *testing.T) {
+		ts.backendPutString(defaultBucket, "invalid", nil, "hello")
+		for _, hdr := range []string{"", "ETag,Nope"} {
+			if result, rs := attributes("invalid?attributes", map[string]string{"x-amz-object-attributes": hdr}); result != nil || rs.StatusCode != http.StatusBadRequest {
+				t.Fatal("expected bad request for", hdr)
+			}
+		}
+		if result, rs := attributes("missing?attributes", map[string]string{"x-amz-object-attributes": "ETag"}); result != nil || rs.StatusCode != http.StatusNotFound {
+			t.Fatal("expected not found")
 		}
 	})
 }
 
-func TestObjectVersions(t *testing.T) {
-	create := func(ts *testServer, bucket, key string, contents []byte, version string) {
-		ts.Helper()
-		svc := ts.s3Client()
-		out, err := svc.PutObject(&s3.PutObjectInput{
-			Bucket: aws.String(bucket),
-			Key:    aws.String(key),
-			Body:   bytes.NewReader(contents),
-		})
+func TestETagFunc(t *testing.T) {
+	etag := func(r io.Reader) (string, error) {
+		b, err := ioutil.ReadAll(r)
+		return fmt.Sprintf("len%d", len(b)), err
+	}
+	ts := newTestServer(t, withFakerOptions(gofakes3.WithETagFunc(etag)))
+	defer ts.Close()
+	svc := ts.s3Client()
+
+	assertETag := func(object, expected string) {
+		t.Helper()
+		head, err := svc.HeadObject(&s3.HeadObjectInput{Bucket: aws.String(defaultBucket), Key: aws.String(object)})
 		ts.OK(err)
-		if aws.StringValue(out.VersionId) != version {
-			t.Fatal("version ID mismatch. found:", aws.StringValue(out.VersionId), "expected:", version)
+		if aws.StringValue(head.ETag) != expected {
+			t.Fatal("unexpected HEAD etag", aws.StringValue(head.ETag), "expected", expected)
+		}
+
+		list, err := svc.ListObjectsV2(&s3.ListObjectsV2Input{Bucket: aws.String(defaultBucket), Prefix: aws.String(object)})
+		ts.OK(err)
+		if len(list.Contents) != 1 || aws.StringValue(list.Contents[0].ETag) != expected {
+			t.Fatal("unexpected listing", list.Contents)
 		}
 	}
 
-	get := func(ts *testServer, bucket, key string, contents []byte, version string) {
-		ts.Helper()
-		svc := ts.s3Client()
-		input := &s3.GetObjectInput{
-			Bucket: aws.String(bucket),
-			Key:    aws.String(key),
+	put, err := svc.PutObject(&s3.PutObjectInput{
+		Bucket: aws.String(defaultBucket),
+		Key:    aws.String("object"),
+		Body:   strings.NewReader("hello"),
+	})
+	ts.OK(err)
+	if aws.StringValue(put.ETag) != `"len5"` {
+		t.Fatal("unexpected etag", aws.StringValue(put.ETag))
+	}
+	assertETag("object", `"len5"`)
+
+	copied, err := svc.CopyObject(&s3.CopyObjectInput{
+		Bucket:     aws.String(defaultBucket),
+		Key:        aws.String("copy"),
+		CopySource: aws.String(defaultBucket + "/object"),
+	})
+	ts.OK(err)
+	if aws.StringValue(copied.CopyObjectResult.ETag) != `"len5"` {
+		t.Fatal("unexpected etag", aws.StringValue(copied.CopyObjectResult.ETag))
+	}
+	assertETag("copy", `"len5"`)
+
+	// The ETag of the upload is computed over the ETags of its parts; "len9"
+	// is not hex-encoded, so it is hashed as it is:
+	uploadID := ts.createMultipartUpload(defaultBucket, "upload", nil)
+	part := ts.uploadPart(defaultBucket, "upload", uploadID, 1, []byte("multipart"))
+	if aws.StringValue(part.ETag) != `"len9"` {
+		t.Fatal("unexpected part etag", aws.StringValue(part.ETag))
+	}
+	completed, err := svc.CompleteMultipartUpload(&s3.CompleteMultipartUploadInput{
+		Bucket:          aws.String(defaultBucket),
+		Key:             aws.String("upload"),
+		UploadId:        aws.String(uploadID),
+		MultipartUpload: &s3.CompletedMultipartUpload{Parts: []*s3.CompletedPart{part}},
+	})
+	ts.OK(err)
+	if aws.StringValue(completed.ETag) != `"len4-1"` {
+		t.Fatal("unexpected etag", aws.StringValue(completed.ETag))
+	}
+	assertETag("upload", `"len4-1"`)
+}
+
+func TestMaxUploadSize(t *testing.T) {
+	ts := newTestServer(t, withFakerOptions(gofakes3.WithMaxUploadSize(10), gofakes3.WithMinPartSize(0)))
+	defer ts.Close()
+	svc := ts.s3Client()
+
+	put := func(object, body string) error {
+		_, err := svc.PutObject(&s3.PutObjectInput{
+			Bucket: aws.String(defaultBucket),
+			Key:    aws.String(object),
+			Body:   strings.NewReader(body),
+		})
+		return err
+	}
+
+	t.Run("put", func(t *testing.T) {
+		ts.OK(put("small", "0123456789"))
+		if err := put("large", "0123456789a"); !s3HasErrorCode(err, gofakes3.ErrEntityTooLarge) {
+			t.Fatal("expected EntityTooLarge, found", err)
 		}
-		if version != "" {
-			input.VersionId = aws.String(version)
+		if ts.backendObjectExists(defaultBucket, "large") {
+			t.Fatal("object should not exist")
 		}
-		out, err := svc.GetObject(input)
+
+		rq, err := http.NewRequest("PUT", ts.url("/"+defaultBucket+"/large"), strings.NewReader("0123456789ab"))
 		ts.OK(err)
-		defer out.Body.Close()
-		bts, err := ioutil.ReadAll(out.Body)
+		rs, err := httpClient().Do(rq)
 		ts.OK(err)
-		if !bytes.Equal(bts, contents) {
-			ts.Fatal("body mismatch. found:", string(bts), "expected:", string(contents))
+		defer rs.Body.Close()
+		if rs.StatusCode != http.StatusBadRequest {
+			t.Fatal("unexpected status", rs.StatusCode)
 		}
-	}
+		var result gofakes3.ErrorEntityTooLargeResponse
+		ts.OK(xml.NewDecoder(rs.Body).Decode(&result))
+		if result.Code != gofakes3.ErrEntityTooLarge || result.ProposedSize != 12 || result.MaxSizeAllowed != 10 {
+			t.Fatal("unexpected error", result)
+		}
+	})
 
-	deleteVersion := func(ts *testServer, bucket, key, version string) {
-		ts.Helper()
-		svc := ts.s3Client()
-		input := &s3.DeleteObjectInput{
-			Bucket: aws.String(bucket),
-			Key:    aws.String(key),
+	t.Run("part", func(t *testing.T) {
+		uploadID := ts.createMultipartUpload(defaultBucket, "part", nil)
+		_, err := svc.UploadPart(&s3.UploadPartInput{
+			Bucket:     aws.String(defaultBucket),
+			Key:        aws.String("part"),
+			UploadId:   aws.String(uploadID),
+			PartNumber: aws.Int64(1),
+			Body:       strings.NewReader("0123456789a"),
+		})
+		if !s3HasErrorCode(err, gofakes3.ErrEntityTooLarge) {
+			t.Fatal("expected EntityTooLarge, found", err)
 		}
-		if version != "" {
-			input.VersionId = aws.String(version)
+	})
+
+	t.Run("complete", func(t *testing.T) {
+		uploadID := ts.createMultipartUpload(defaultBucket, "complete", nil)
+		parts := []*s3.CompletedPart{
+			ts.uploadPart(defaultBucket, "complete", uploadID, 1, []byte("012345")),
+			ts.uploadPart(defaultBucket, "complete", uploadID, 2, []byte("012345")),
 		}
-		ts.OKAll(svc.DeleteObject(input))
+		_, err := svc.CompleteMultipartUpload(&s3.CompleteMultipartUploadInput{
+			Bucket:          aws.String(defaultBucket),
+			Key:             aws.String("complete"),
+			UploadId:        aws.String(uploadID),
+			MultipartUpload: &s3.CompletedMultipartUpload{Parts: parts},
+		})
+		if !s3HasErrorCode(err, gofakes3.ErrEntityTooLarge) {
+			t.Fatal("expected EntityTooLarge, found", err)
+		}
+		if ts.backendObjectExists(defaultBucket, "complete") {
+			t.Fatal("object should not exist")
+		}
+	})
+}
+
+func TestRestoreObject(t *testing.T) {
+	ts := newTestServer(t)
+	defer ts.Close()
+	svc := ts.s3Client()
+
+	for key, class := range map[string]string{"glacier": "GLACIER", "deep": "DEEP_ARCHIVE", "standard": "STANDARD"} {
+		_, err := svc.PutObject(&s3.PutObjectInput{
+			Bucket:       aws.String(defaultBucket),
+			Key:          aws.String(key),
+			Body:         bytes.NewReader([]byte("archived")),
+			StorageClass: aws.String(class),
+		})
+		ts.OK(err)
 	}
 
-	deleteDirect := func(ts *testServer, bucket, key, version string) {
-		ts.Helper()
-		svc := ts.s3Client()
-		input := &s3.DeleteObjectInput{
-			Bucket: aws.String(bucket),
+	restore := func(key string, days int64, tier string) (status int, err error) {
+		t.Helper()
+		rq, _ := svc.RestoreObjectRequest(&s3.RestoreObjectInput{
+			Bucket: aws.String(defaultBucket),
 			Key:    aws.String(key),
+			RestoreRequest: &s3.RestoreRequest{
+				Days:                 aws.Int64(days),
+				GlacierJobParameters: &s3.GlacierJobParameters{Tier: aws.String(tier)},
+			},
+		})
+		err = rq.Send()
+		return rq.HTTPResponse.StatusCode, err
+	}
+	get := func(key string) error {
+		t.Helper()
+		out, err := svc.GetObject(&s3.GetObjectInput{Bucket: aws.String(defaultBucket), Key: aws.String(key)})
+		if err == nil {
+			out.Body.Close()
 		}
-		out, err := svc.DeleteObject(input)
+		return err
+	}
+	assertRestoreHeader := func(key, expected string) {
+		t.Helper()
+		out, err := svc.HeadObject(&s3.HeadObjectInput{Bucket: aws.String(defaultBucket), Key: aws.String(key)})
 		ts.OK(err)
-		if aws.StringValue(out.VersionId) != version {
-			t.Fatal("version ID mismatch. found:", aws.StringValue(out.VersionId), "expected:", version)
+		if found := aws.StringValue(out.Restore); found != expected {
+			t.Fatalf("x-amz-restore %q, expected %q", found, expected)
 		}
 	}
 
-	list := func(ts *testServer, bucket string, versions ...string) {
-		ts.Helper()
-		svc := ts.s3Client()
-		out, err := svc.ListObjectVersions(&s3.ListObjectVersionsInput{Bucket: aws.String(bucket)})
-		ts.OK(err)
+	// Archived objects can not be read until they are restored, but HEAD
+	// still works:
+	if err := get("glacier"); !hasErrorCode(err, gofakes3.ErrInvalidObjectState) {
+		t.Fatal("expected ErrInvalidObjectState, found", err)
+	}
+	assertRestoreHeader("glacier", "")
 
-		var found []string
-		for _, ver := range out.Versions {
-			found = append(found, aws.StringValue(ver.VersionId))
-		}
-		for _, ver := range out.DeleteMarkers {
-			found = append(found, aws.StringValue(ver.VersionId))
-		}
+	if _, err := restore("standard", 1, "Standard"); !hasErrorCode(err, gofakes3.ErrInvalidObjectState) {
+		t.Fatal("expected ErrInvalidObjectState, found", err)
+	}
+	if _, err := restore("deep", 1, "Expedited"); !hasErrorCode(err, gofakes3.ErrInvalidArgument) {
+		t.Fatal("expected ErrInvalidArgument, found", err)
+	}
 
-		// Unfortunately, the S3 client API destroys the order of Versions and
-		// DeleteMarkers, which are sibling elements in the XML body but separated
-		// into different lists by the client:
-		sort.Strings(found)
-		sort.Strings(versions)
-		if !reflect.DeepEqual(found, versions) {
-			ts.Fatal("versions mismatch. found:", found, "expected:", versions)
+	status, err := restore("glacier", 2, "Expedited")
+	ts.OK(err)
+	if status != http.StatusAccepted {
+		t.Fatal("unexpected status", status)
+	}
+	if _, err := restore("glacier", 2, "Expedited"); !hasErrorCode(err, gofakes3.ErrRestoreAlreadyInProgress) {
+		t.Fatal("expected ErrRestoreAlreadyInProgress, found", err)
+	}
+	assertRestoreHeader("glacier", `ongoing-request="true"`)
+	if err := get("glacier"); !hasErrorCode(err, gofakes3.ErrInvalidObjectState) {
+		t.Fatal("expected ErrInvalidObjectState, found", err)
+	}
+
+	// The restored copy is kept for 2 days after the restore completes,
+	// rounded up to midnight:
+	ts.Advance(5 * time.Minute)
+	assertRestoreHeader("glacier", `ongoing-request="false", expiry-date="Thu, 04 Jan 2018 00:00:00 GMT"`)
+	ts.OK(get("glacier"))
+	_, err = svc.CopyObject(&s3.CopyObjectInput{
+		Bucket:     aws.String(defaultBucket),
+		Key:        aws.String("copy"),
+		CopySource: aws.String(defaultBucket + "/glacier"),
+	})
+	ts.OK(err)
+
+	// Restoring it again extends the time it is kept for:
+	status, err = restore("glacier", 3, "Bulk")
+	ts.OK(err)
+	if status != http.StatusOK {
+		t.Fatal("unexpected status", status)
+	}
+	assertRestoreHeader("glacier", `ongoing-request="false", expiry-date="Fri, 05 Jan 2018 00:00:00 GMT"`)
+
+	ts.Advance(4 * 24 * time.Hour)
+	assertRestoreHeader("glacier", "")
+	if err := get("glacier"); !hasErrorCode(err, gofakes3.ErrInvalidObjectState) {
+		t.Fatal("expected ErrInvalidObjectState, found", err)
+	}
+}
+
+func TestOwner(t *testing.T) {
+	ts := newTestServer(t, withVersioning(), withFakerOptions(gofakes3.WithOwner("owner-id", "owner")))
+	defer ts.Close()
+	svc := ts.s3Client()
+
+	assertOwner := func(what string, owner *s3.Owner) {
+		t.Helper()
+		if owner == nil || aws.StringValue(owner.ID) != "owner-id" || aws.StringValue(owner.DisplayName) != "owner" {
+			t.Fatal("unexpected owner for", what, owner)
 		}
 	}
 
-	// XXX: version IDs are brittle; we control the seed, but the format may
-	// change at any time.
-	const v1 = "3/60O30C1G60O30C1G60O30C1G60O30C1G60O30C1G60O30C1H03F9QN5V72K21OG="
-	const v2 = "3/60O30C1G60O30C1G60O30C1G60O30C1G60O30C1G60O30C1I00G5II3TDAF7GRG="
-	const v3 = "3/60O30C1G60O30C1G60O30C1G60O30C1G60O30C1G60O30C1J01VFV0CD31ES81G="
+	ts.backendPutString(defaultBucket, "object", nil, "hello")
+	_, err := svc.DeleteObject(&s3.DeleteObjectInput{Bucket: aws.String(defaultBucket), Key: aws.String("object")})
+	ts.OK(err)
 
-	t.Run("put-list-delete-versions", func(t *testing.T) {
-		ts := newTestServer(t, withVersioning())
-		defer ts.Close()
+	buckets, err := svc.ListBuckets(&s3.ListBucketsInput{})
+	ts.OK(err)
+	assertOwner("buckets", buckets.Owner)
 
-		create(ts, defaultBucket, "object", []byte("body 1"), v1)
-		get(ts, defaultBucket, "object", []byte("body 1"), "")
-		list(ts, defaultBucket, v1)
+	ts.backendPutString(defaultBucket, "listed", nil, "hello")
+	objects, err := svc.ListObjects(&s3.ListObjectsInput{Bucket: aws.String(defaultBucket)})
+	ts.OK(err)
+	assertOwner("objects", objects.Contents[0].Owner)
 
-		create(ts, defaultBucket, "object", []byte("body 2"), v2)
-		get(ts, defaultBucket, "object", []byte("body 2"), "")
-		list(ts, defaultBucket, v1, v2)
+	objectsV2, err := svc.ListObjectsV2(&s3.ListObjectsV2Input{Bucket: aws.String(defaultBucket), FetchOwner: aws.Bool(true)})
+	ts.OK(err)
+	assertOwner("objects v2", objectsV2.Contents[0].Owner)
 
-		create(ts, defaultBucket, "object", []byte("body 3"), v3)
-		get(ts, defaultBucket, "object", []byte("body 3"), "")
-		list(ts, defaultBucket, v1, v2, v3)
+	versions, err := svc.ListObjectVersions(&s3.ListObjectVersionsInput{Bucket: aws.String(defaultBucket), Prefix: aws.String("object")})
+	ts.OK(err)
+	assertOwner("version", versions.Versions[0].Owner)
+	assertOwner("delete marker", versions.DeleteMarkers[0].Owner)
+
+	mpu, err := svc.CreateMultipartUpload(&s3.CreateMultipartUploadInput{Bucket: aws.String(defaultBucket), Key: aws.String("upload")})
+	ts.OK(err)
+	uploads, err := svc.ListMultipartUploads(&s3.ListMultipartUploadsInput{Bucket: aws.String(defaultBucket)})
+	ts.OK(err)
+	assertOwner("upload", uploads.Uploads[0].Owner)
+	if initiator := uploads.Uploads[0].Initiator; aws.StringValue(initiator.ID) != "owner-id" {
+		t.Fatal("unexpected upload initiator", initiator)
+	}
+	parts, err := svc.ListParts(&s3.ListPartsInput{Bucket: aws.String(defaultBucket), Key: aws.String("upload"), UploadId: mpu.UploadId})
+	ts.OK(err)
+	assertOwner("parts", parts.Owner)
+	if aws.StringValue(parts.Initiator.ID) != "owner-id" {
+		t.Fatal("unexpected parts initiator", parts.Initiator)
+	}
+
+	bucketACL, err := svc.GetBucketAcl(&s3.GetBucketAclInput{Bucket: aws.String(defaultBucket)})
+	ts.OK(err)
+	assertOwner("bucket acl", bucketACL.Owner)
+	objectACL, err := svc.GetObjectAcl(&s3.GetObjectAclInput{Bucket: aws.String(defaultBucket), Key: aws.String("listed")})
+	ts.OK(err)
+	assertOwner("object acl", objectACL.Owner)
+}
+
+func TestStats(t *testing.T) {
+	ts := newTestServer(t)
+	defer ts.Close()
+
+	body := "hello, stats"
+	rq, err := http.NewRequest("PUT", ts.url(defaultBucket+"/object"), strings.NewReader(body))
+	ts.OK(err)
+	rs, err := httpClient().Do(rq)
+	ts.OK(err)
+	rs.Body.Close()
+
+	rs, err = httpClient().Get(ts.url(defaultBucket + "/object"))
+	ts.OK(err)
+	out, err := ioutil.ReadAll(rs.Body)
+	ts.OK(err)
+	rs.Body.Close()
+	if string(out) != body {
+		t.Fatalf("unexpected body %q", out)
+	}
+
+	for i := 0; i < 2; i++ {
+		rs, err = httpClient().Get(ts.url(defaultBucket + "/missing"))
+		ts.OK(err)
+		rs.Body.Close()
+	}
+
+	ts.backendCreateBucket("otherbucket")
+	ts.backendPutString("otherbucket", "a", nil, "a")
+	ts.backendPutString("otherbucket", "b", nil, "b")
+	ts.createMultipartUpload(defaultBucket, "upload", nil)
+
+	stats := ts.Stats()
+	if stats.StorageErr != nil {
+		t.Fatal(stats.StorageErr)
+	}
+	if exp := map[string]int64{"PutObject": 1, "GetObject": 3, "CreateMultipartUpload": 1}; !reflect.DeepEqual(stats.Operations, exp) {
+		t.Fatalf("operations:\nexp: %v\ngot: %v", exp, stats.Operations)
+	}
+
+	// The multipart upload was created with the SDK, whose request has no
+	// body, but whose response does:
+	if stats.BytesIn != int64(len(body)) {
+		t.Fatalf("expected %d bytes in, found %d", len(body), stats.BytesIn)
+	}
+	if stats.BytesOut <= int64(len(body)) {
+		t.Fatalf("expected more than %d bytes out, found %d", len(body), stats.BytesOut)
+	}
+
+	if stats.Buckets != 2 || stats.Objects != 3 {
+		t.Fatalf("expected 2 buckets and 3 objects, found %d and %d", stats.Buckets, stats.Objects)
+	}
+	if stats.MultipartUploads != 1 {
+		t.Fatalf("expected 1 multipart upload, found %d", stats.MultipartUploads)
+	}
+}
+
+func TestGetObjectPartNumber(t *testing.T) {
+	ts := newTestServer(t, withFakerOptions(gofakes3.WithMinPartSize(0)))
+	defer ts.Close()
+	svc := ts.s3Client()
+
+	// The part numbers need not be contiguous; partNumber selects a part by
+	// its position in the object:
+	uploadID := ts.createMultipartUpload(defaultBucket, "multi", nil)
+	parts := []*s3.CompletedPart{
+		ts.uploadPart(defaultBucket, "multi", uploadID, 1, []byte("hello")),
+		ts.uploadPart(defaultBucket, "multi", uploadID, 3, []byte(", w")),
+		ts.uploadPart(defaultBucket, "multi", uploadID, 4, []byte("orld")),
+	}
+	ts.OKAll(svc.CompleteMultipartUpload(&s3.CompleteMultipartUploadInput{
+		Bucket:          aws.String(defaultBucket),
+		Key:             aws.String("multi"),
+		UploadId:        aws.String(uploadID),
+		MultipartUpload: &s3.CompletedMultipartUpload{Parts: parts},
+	}))
+	ts.backendPutString(defaultBucket, "plain", nil, "plain")
+
+	for _, tc := range []struct {
+		method, path string
+		rangeHdr     string
+		status       int
+		contentRange string
+		partsCount   string
+		body         string
+	}{
+		{"GET", "multi?partNumber=1", "", http.StatusPartialContent, "bytes 0-4/12", "3", "hello"},
+		{"GET", "multi?partNumber=2", "", http.StatusPartialContent, "bytes 5-7/12", "3", ", w"},
+		{"GET", "multi?partNumber=3", "", http.StatusPartialContent, "bytes 8-11/12", "3", "orld"},
+		{"HEAD", "multi?partNumber=3", "", http.StatusPartialContent, "bytes 8-11/12", "3", ""},
+		{"GET", "multi?partNumber=4", "", http.StatusRequestedRangeNotSatisfiable, "", "", ""},
+		{"HEAD", "multi?partNumber=4", "", http.StatusRequestedRangeNotSatisfiable, "", "", ""},
+
+		// An object that was not uploaded in parts is a single part:
+		{"GET", "plain?partNumber=1", "", http.StatusPartialContent, "bytes 0-4/5", "", "plain"},
+		{"HEAD", "plain?partNumber=1", "", http.StatusPartialContent, "bytes 0-4/5", "", ""},
+		{"GET", "plain?partNumber=2", "", http.StatusRequestedRangeNotSatisfiable, "", "", ""},
+
+		{"GET", "multi?partNumber=0", "", http.StatusBadRequest, "", "", ""},
+		{"GET", "multi?partNumber=nope", "", http.StatusBadRequest, "", "", ""},
+		{"GET", "multi?partNumber=1", "bytes=0-1", http.StatusBadRequest, "", "", ""},
+	} {
+		t.Run(tc.method+" "+tc.path+" "+tc.rangeHdr, func(t *testing.T) {
+			rq, err := http.NewRequest(tc.method, ts.url("/"+defaultBucket+"/"+tc.path), nil)
+			ts.OK(err)
+			if tc.rangeHdr != "" {
+				rq.Header.Set("Range", tc.rangeHdr)
+			}
+			rs, err := httpClient().Do(rq)
+			ts.OK(err)
+			defer rs.Body.Close()
 
-		get(ts, defaultBucket, "object", []byte("body 1"), v1)
-		get(ts, defaultBucket, "object", []byte("body 2"), v2)
-		get(ts, defaultBucket, "object", []byte("body 3"), v3)
-		get(ts, defaultBucket, "object", []byte("body 3"), "")
+			if rs.StatusCode != tc.status {
+				t.Fatal("unexpected status", rs.StatusCode, "!=", tc.status)
+			}
+			if tc.status != http.StatusPartialContent {
+				return
+			}
+			if v := rs.Header.Get("Content-Range"); v != tc.contentRange {
+				t.Fatal("unexpected Content-Range", v, "!=", tc.contentRange)
+			}
+			if v := rs.Header.Get("x-amz-mp-parts-count"); v != tc.partsCount {
+				t.Fatal("unexpected parts count", v, "!=", tc.partsCount)
+			}
+			if tc.method == "HEAD" {
+				return
+			}
+			body, err := ioutil.ReadAll(rs.Body)
+			ts.OK(err)
+			if string(body) != tc.body || rs.ContentLength != int64(len(tc.body)) {
+				t.Fatalf("unexpected body %q with length %d", body, rs.ContentLength)
+			}
+		})
+	}
 
-		deleteVersion(ts, defaultBucket, "object", v1)
-		list(ts, defaultBucket, v2, v3)
-		deleteVersion(ts, defaultBucket, "object", v2)
-		list(ts, defaultBucket, v3)
-		deleteVersion(ts, defaultBucket, "object", v3)
-		list(ts, defaultBucket)
+	// The SDK reports the parts count, so that the parts can be downloaded
+	// in parallel:
+	out, err := svc.GetObject(&s3.GetObjectInput{
+		Bucket:     aws.String(defaultBucket),
+		Key:        aws.String("multi"),
+		PartNumber: aws.Int64(2),
 	})
+	ts.OK(err)
+	defer out.Body.Close()
+	if aws.Int64Value(out.PartsCount) != 3 || aws.Int64Value(out.ContentLength) != 3 {
+		t.Fatal("unexpected output", out)
+	}
+}
 
-	t.Run("delete-direct", func(t *testing.T) {
-		ts := newTestServer(t, withVersioning())
-		defer ts.Close()
+func TestGetObjectStreaming(t *testing.T) {
+	const size = 500 << 20
+	backend := &generatedObjectBackend{
+		Backend: s3mem.New(s3mem.WithTimeSource(gofakes3.FixedTimeSource(defaultDate))),
+		key:     "large",
+		size:    size,
+	}
+	ts := newTestServer(t, withBackend(backend))
+	defer ts.Close()
 
-		create(ts, defaultBucket, "object", []byte("body 1"), v1)
-		list(ts, defaultBucket, v1)
-		create(ts, defaultBucket, "object", []byte("body 2"), v2)
-		list(ts, defaultBucket, v1, v2)
+	client := &http.Client{Timeout: time.Minute}
 
-		get(ts, defaultBucket, "object", []byte("body 2"), "")
+	// The object is never held in memory, so the server should only need
+	// the buffers it copies it through, whichever way it is requested:
+	for _, tc := range []struct {
+		rangeHdr string
+		length   int64
+	}{
+		{"", size},
+		{"bytes=100-", size - 100},
+		{"bytes=0-99,200-", 100 + size - 200},
+	} {
+		t.Run(tc.rangeHdr, func(t *testing.T) {
+			rq, err := http.NewRequest("GET", ts.url("/"+defaultBucket+"/large"), nil)
+			ts.OK(err)
+			if tc.rangeHdr != "" {
+				rq.Header.Set("Range", tc.rangeHdr)
+			}
 
-		deleteDirect(ts, defaultBucket, "object", v3)
-		list(ts, defaultBucket, v1, v2, v3)
+			var before, after runtime.MemStats
+			runtime.GC()
+			runtime.ReadMemStats(&before)
 
-		svc := ts.s3Client()
-		_, err := svc.GetObject(&s3.GetObjectInput{
-			Bucket: aws.String(defaultBucket),
-			Key:    aws.String("object"),
+			rs, err := client.Do(rq)
+			ts.OK(err)
+			defer rs.Body.Close()
+
+			var n int64
+			if _, params, err := mime.ParseMediaType(rs.Header.Get("Content-Type")); err == nil && params["boundary"] != "" {
+				mr := multipart.NewReader(rs.Body, params["boundary"])
+				for {
+					part, err := mr.NextPart()
+					if err == io.EOF {
+						break
+					}
+					ts.OK(err)
+					pn, err := io.Copy(ioutil.Discard, part)
+					ts.OK(err)
+					n += pn
+				}
+			} else {
+				n, err = io.Copy(ioutil.Discard, rs.Body)
+				ts.OK(err)
+			}
+
+			runtime.ReadMemStats(&after)
+
+			if n != tc.length {
+				t.Fatal("unexpected length", n, "!=", tc.length)
+			}
+			if alloc := after.TotalAlloc - before.TotalAlloc; alloc > size/10 {
+				t.Fatalf("%d bytes were allocated to download %d bytes", alloc, n)
+			}
 		})
-		if !hasErrorCode(err, gofakes3.ErrNoSuchKey) {
-			ts.Fatal("expected ErrNoSuchKey, found", err)
-		}
-	})
+	}
+}
 
-	t.Run("list-never-versioned", func(t *testing.T) {
-		ts := newTestServer(t, withVersioning())
-		defer ts.Close()
+// generatedObjectBackend serves an object of size zero bytes as key, in any
+// bucket, without storing it. Its contents implement io.ReaderAt, as those of
+// an object held in memory do.
+type generatedObjectBackend struct {
+	gofakes3.Backend
+	key  string
+	size int64
+}
 
-		const neverVerBucket = "neverver"
-		ts.backendCreateBucket(neverVerBucket)
+func (b *generatedObjectBackend) HeadObject(bucketName, objectName string) (*gofakes3.Object, error) {
+	if objectName != b.key {
+		return b.Backend.HeadObject(bucketName, objectName)
+	}
+	return b.object(nil)
+}
 
-		ts.backendPutString(neverVerBucket, "object", nil, "body 1")
-		list(ts, neverVerBucket, "null") // S300005
-	})
+func (b *generatedObjectBackend) GetObject(bucketName, objectName string, rangeRequest *gofakes3.ObjectRangeRequest) (*gofakes3.Object, error) {
+	if objectName != b.key {
+		return b.Backend.GetObject(bucketName, objectName, rangeRequest)
+	}
+	rnge, err := rangeRequest.Range(b.size)
+	if err != nil {
+		return nil, err
+	}
+	return b.object(rnge)
 }
 
-func TestListBucketPages(t *testing.T) {
-	createData := func(ts *testServer, prefix string, n int64) []string {
-		keys := make([]string, n)
-		for i := int64(0); i < n; i++ {
-			key := fmt.Sprintf("%s%d", prefix, i)
-			ts.backendPutString(defaultBucket, key, nil, fmt.Sprintf("body-%d", i))
-			keys[i] = key
-		}
-		return keys
+func (b *generatedObjectBackend) object(rnge *gofakes3.ObjectRange) (*gofakes3.Object, error) {
+	start, length := int64(0), b.size
+	if rnge != nil {
+		start, length = rnge.Start, rnge.Length
 	}
+	return &gofakes3.Object{
+		Name:     b.key,
+		Metadata: map[string]string{},
+		Size:     b.size,
+		Range:    rnge,
+		Hash:     make([]byte, 16),
+		Contents: zeroContents{io.NewSectionReader(zeroReaderAt{}, start, length)},
+	}, nil
+}
 
-	assertKeys := func(ts *testServer, rs *listBucketResult, keys ...string) {
-		found := make([]string, len(rs.Contents))
-		for i := 0; i < len(rs.Contents); i++ {
-			found[i] = aws.StringValue(rs.Contents[i].Key)
-		}
-		if !reflect.DeepEqual(found, keys) {
-			t.Fatal("key mismatch:", keys, "!=", found)
-		}
+type zeroReaderAt struct{}
+
+func (zeroReaderAt) ReadAt(p []byte, off int64) (int, error) {
+	for i := range p {
+		p[i] = 0
 	}
+	return len(p), nil
+}
+
+type zeroContents struct{ *io.SectionReader }
+
+func (zeroContents) Close() error { return nil }
 
+func TestTimeSkew(t *testing.T) {
 	for idx, tc := range []struct {
-		keys, pageKeys int64
+		required bool
+		header   string
+		at       time.Time
+		raw      string
+		code     gofakes3.ErrorCode
 	}{
-		{9, 2},
-		{8, 3},
-		{7, 4},
-		{6, 5},
-		{5, 6},
+		{header: "x-amz-date", at: defaultDate.Add(14 * time.Minute)},
+		{header: "x-amz-date", at: defaultDate.Add(-14 * time.Minute)},
+		{header: "x-amz-date", at: defaultDate.Add(16 * time.Minute), code: gofakes3.ErrRequestTimeTooSkewed},
+		{header: "x-amz-date", at: defaultDate.Add(-16 * time.Minute), code: gofakes3.ErrRequestTimeTooSkewed},
+		{header: "Date", at: defaultDate.Add(14 * time.Minute)},
+		{header: "Date", at: defaultDate.Add(16 * time.Minute), code: gofakes3.ErrRequestTimeTooSkewed},
+		{header: "Date", at: defaultDate.Add(-16 * time.Minute), code: gofakes3.ErrRequestTimeTooSkewed},
+		{header: "x-amz-date", raw: "yesterday", code: gofakes3.ErrAccessDenied},
+		{header: "Date", raw: "yesterday", code: gofakes3.ErrAccessDenied},
+		{},
+		{required: true, code: gofakes3.ErrAccessDenied},
+		{required: true, header: "Date", at: defaultDate},
 	} {
-		t.Run(fmt.Sprintf("list-page-basic/%d", idx), func(t *testing.T) {
-			ts := newTestServer(t)
+		t.Run(fmt.Sprintf("%d", idx), func(t *testing.T) {
+			ts := newTestServer(t, withFakerOptions(
+				gofakes3.WithTimeSkewLimit(15*time.Minute),
+				gofakes3.WithRequestTimeRequired(tc.required),
+			))
 			defer ts.Close()
-			keys := createData(ts, "", tc.keys)
+			ts.backendPutString(defaultBucket, "object", nil, "hello")
 
-			rs := ts.mustListBucketV1Pages(nil, tc.pageKeys, "")
-			if len(rs.CommonPrefixes) > 0 {
-				t.Fatal()
+			rq, err := http.NewRequest("GET", ts.url(defaultBucket+"/object"), nil)
+			ts.OK(err)
+			value := tc.raw
+			if value == "" && tc.header == "Date" {
+				value = tc.at.Format(http.TimeFormat)
+			} else if value == "" && tc.header != "" {
+				value = tc.at.Format("20060102T150405Z")
+			}
+			if tc.header != "" {
+				rq.Header.Set(tc.header, value)
 			}
-			assertKeys(ts, rs, keys...)
 
-			rs = ts.mustListBucketV2Pages(nil, tc.pageKeys, "")
-			if len(rs.CommonPrefixes) > 0 {
-				t.Fatal()
+			rs, err := httpClient().Do(rq)
+			ts.OK(err)
+			defer rs.Body.Close()
+
+			if tc.code == "" {
+				if rs.StatusCode != http.StatusOK {
+					body, _ := ioutil.ReadAll(rs.Body)
+					t.Fatal("unexpected status", rs.StatusCode, string(body))
+				}
+				return
+			}
+
+			var errResp struct {
+				Code                       gofakes3.ErrorCode
+				RequestTime                string
+				ServerTime                 time.Time
+				MaxAllowedSkewMilliseconds int64
+			}
+			ts.OK(xml.NewDecoder(rs.Body).Decode(&errResp))
+			if rs.StatusCode != http.StatusForbidden || errResp.Code != tc.code {
+				t.Fatal("expected", tc.code, "found", rs.StatusCode, errResp.Code)
+			}
+			if tc.code != gofakes3.ErrRequestTimeTooSkewed {
+				return
+			}
+			if errResp.RequestTime != value || !errResp.ServerTime.Equal(defaultDate) || errResp.MaxAllowedSkewMilliseconds != 900000 {
+				t.Fatalf("unexpected error %+v", errResp)
 			}
-			assertKeys(ts, rs, keys...)
 		})
+	}
+}
 
-		t.Run(fmt.Sprintf("list-page-prefix/%d", idx), func(t *testing.T) {
-			ts := newTestServer(t)
-			defer ts.Close()
+func TestTimeSourceDates(t *testing.T) {
+	ts := newTestServer(t, withoutInitialBuckets())
+	defer ts.Close()
+	svc := ts.s3Client()
 
-			// junk keys with no prefix to ensure that we are actually limiting the output.
-			// these should not show up in the output.
-			createData(ts, "", tc.keys)
+	assertDate := func(name string, found *time.Time, expected time.Time) {
+		t.Helper()
+		if found == nil || !found.Equal(expected) {
+			t.Fatal(name, found, "!=", expected)
+		}
+	}
+	assertResponseDate := func(expected time.Time) {
+		t.Helper()
+		rs, err := httpClient().Head(ts.url(defaultBucket + "/object"))
+		ts.OK(err)
+		rs.Body.Close()
+		if found := rs.Header.Get("Date"); found != expected.Format(http.TimeFormat) {
+			t.Fatal("Date", found, "!=", expected.Format(http.TimeFormat))
+		}
+	}
 
-			// these are the actual keys we expect to see:
-			keys := createData(ts, "test", tc.keys)
+	_, err := svc.CreateBucket(&s3.CreateBucketInput{Bucket: aws.String(defaultBucket)})
+	ts.OK(err)
+	ts.Advance(time.Hour)
+	created := defaultDate.Add(time.Hour)
+	ts.backendPutString(defaultBucket, "object", nil, "hello")
+	upload := ts.createMultipartUpload(defaultBucket, "upload", nil)
 
-			prefix := gofakes3.NewPrefix(aws.String("test"), nil)
+	buckets, err := svc.ListBuckets(&s3.ListBucketsInput{})
+	ts.OK(err)
+	assertDate("CreationDate", buckets.Buckets[0].CreationDate, defaultDate)
 
-			rs := ts.mustListBucketV1Pages(&prefix, tc.pageKeys, "")
-			if len(rs.CommonPrefixes) > 0 {
-				t.Fatal()
-			}
-			assertKeys(ts, rs, keys...)
+	head, err := svc.HeadObject(&s3.HeadObjectInput{Bucket: aws.String(defaultBucket), Key: aws.String("object")})
+	ts.OK(err)
+	assertDate("LastModified", head.LastModified, created)
+	assertResponseDate(created)
 
-			rs = ts.mustListBucketV2Pages(&prefix, tc.pageKeys, "")
-			if len(rs.CommonPrefixes) > 0 {
-				t.Fatal()
-			}
-			assertKeys(ts, rs, keys...)
-		})
+	uploads, err := svc.ListMultipartUploads(&s3.ListMultipartUploadsInput{Bucket: aws.String(defaultBucket)})
+	ts.OK(err)
+	assertDate("Initiated", uploads.Uploads[0].Initiated, created)
 
-		t.Run(fmt.Sprintf("list-page-prefix-delim/%d", idx), func(t *testing.T) {
-			ts := newTestServer(t)
-			defer ts.Close()
+	_, err = svc.PutBucketLifecycleConfiguration(&s3.PutBucketLifecycleConfigurationInput{
+		Bucket: aws.String(defaultBucket),
+		LifecycleConfiguration: &s3.BucketLifecycleConfiguration{
+			Rules: []*s3.LifecycleRule{{
+				Status:     aws.String("Enabled"),
+				Filter:     &s3.LifecycleRuleFilter{Prefix: aws.String("")},
+				Expiration: &s3.LifecycleExpiration{Days: aws.Int64(1)},
+			}},
+		},
+	})
+	ts.OK(err)
 
-			// junk keys with no prefix to ensure that we are actually limiting the output.
-			// these should not show up in the output.
-			createData(ts, "", tc.keys)
+	// Nothing expires until the clock is advanced past the expiry, however
+	// often the lifecycle is swept:
+	ts.OK(ts.SweepLifecycle())
+	ts.assertObject(defaultBucket, "object", nil, "hello")
 
-			// these are the actual keys we expect to see:
-			keys := createData(ts, "test/", tc.keys)
+	ts.Advance(48 * time.Hour)
+	assertResponseDate(created.Add(48 * time.Hour))
+	ts.OK(ts.SweepLifecycle())
 
-			// add some common prefixes:
-			createData(ts, "test/prefix1/", 2)
-			createData(ts, "test/prefix2/", 2)
+	_, err = svc.HeadObject(&s3.HeadObjectInput{Bucket: aws.String(defaultBucket), Key: aws.String("object")})
+	if !s3HasErrorCode(err, gofakes3.ErrorCode("NotFound")) {
+		t.Fatal("expected the object to expire, found", err)
+	}
 
-			prefix := gofakes3.NewFolderPrefix("test/")
+	// The upload is exactly 48 hours old:
+	if n := ts.AbortMultipartUploadsOlderThan(48 * time.Hour); n != 0 {
+		t.Fatal("expected upload", upload, "to be kept, found", n, "aborted")
+	}
+	if n := ts.AbortMultipartUploadsOlderThan(47 * time.Hour); n != 1 {
+		t.Fatal("expected upload", upload, "to be aborted, found", n)
+	}
+}
 
-			rs := ts.mustListBucketV1Pages(&prefix, tc.pageKeys, "")
-			assertKeys(ts, rs, keys...)
+func TestWriteVersionIDHeaders(t *testing.T) {
+	ts := newTestServer(t, withVersioning(), withFakerOptions(gofakes3.WithMinPartSize(0)))
+	defer ts.Close()
+	svc := ts.s3Client()
+	ts.backendCreateBucket("unversioned")
 
-			rs = ts.mustListBucketV2Pages(&prefix, tc.pageKeys, "")
-			assertKeys(ts, rs, keys...)
+	for _, tc := range []struct {
+		bucket    string
+		versioned bool
+	}{
+		{defaultBucket, true},
+		{"unversioned", false},
+	} {
+		t.Run(tc.bucket, func(t *testing.T) {
+			assertVersion := func(op string, version *string) {
+				t.Helper()
+				if tc.versioned && aws.StringValue(version) == "" {
+					t.Fatal(op, "did not return a version ID")
+				} else if !tc.versioned && version != nil {
+					t.Fatal(op, "returned version ID", *version)
+				}
+			}
+			assertLatest := func(op, key string, version *string) {
+				t.Helper()
+				assertVersion(op, version)
+				head, err := svc.HeadObject(&s3.HeadObjectInput{Bucket: aws.String(tc.bucket), Key: aws.String(key)})
+				ts.OK(err)
+				if aws.StringValue(head.VersionId) != aws.StringValue(version) {
+					t.Fatal(op, "returned version", aws.StringValue(version), "but HEAD found", aws.StringValue(head.VersionId))
+				}
+			}
 
-			// FIXME: there are some unanswered questions for the assumer about
-			// how CommonPrefixes interacts with paging; CommonPrefixes should be
-			// checked once we've established how S3 actually behaves.
+			put, err := svc.PutObject(&s3.PutObjectInput{
+				Bucket: aws.String(tc.bucket),
+				Key:    aws.String("put"),
+				Body:   bytes.NewReader([]byte("hello")),
+			})
+			ts.OK(err)
+			assertLatest("PutObject", "put", put.VersionId)
+
+			copied, err := svc.CopyObject(&s3.CopyObjectInput{
+				Bucket:     aws.String(tc.bucket),
+				Key:        aws.String("copy"),
+				CopySource: aws.String(tc.bucket + "/put"),
+			})
+			ts.OK(err)
+			assertLatest("CopyObject", "copy", copied.VersionId)
+
+			uploadID := ts.createMultipartUpload(tc.bucket, "multipart", nil)
+			part := ts.uploadPart(tc.bucket, "multipart", uploadID, 1, []byte("hello"))
+			completed, err := svc.CompleteMultipartUpload(&s3.CompleteMultipartUploadInput{
+				Bucket:          aws.String(tc.bucket),
+				Key:             aws.String("multipart"),
+				UploadId:        aws.String(uploadID),
+				MultipartUpload: &s3.CompletedMultipartUpload{Parts: []*s3.CompletedPart{part}},
+			})
+			ts.OK(err)
+			assertLatest("CompleteMultipartUpload", "multipart", completed.VersionId)
+
+			del, err := svc.DeleteObject(&s3.DeleteObjectInput{Bucket: aws.String(tc.bucket), Key: aws.String("put")})
+			ts.OK(err)
+			assertVersion("DeleteObject", del.VersionId)
+			if tc.versioned != aws.BoolValue(del.DeleteMarker) || (!tc.versioned && del.DeleteMarker != nil) {
+				t.Fatal("DeleteObject returned delete marker", del.DeleteMarker)
+			}
+
+			if tc.versioned {
+				// Deleting the delete marker itself reports that it was one:
+				delMarker, err := svc.DeleteObject(&s3.DeleteObjectInput{
+					Bucket:    aws.String(tc.bucket),
+					Key:       aws.String("put"),
+					VersionId: del.VersionId,
+				})
+				ts.OK(err)
+				if !aws.BoolValue(delMarker.DeleteMarker) || aws.StringValue(delMarker.VersionId) != aws.StringValue(del.VersionId) {
+					t.Fatal("unexpected result deleting the delete marker", delMarker)
+				}
+			}
 		})
 	}
 }
 
-// Ensure that a backend that does not support pagination can use the fallback if enabled:
-func TestListBucketPagesFallback(t *testing.T) {
-	createData := func(ts *testServer, prefix string, n int64) []string {
-		keys := make([]string, n)
-		for i := int64(0); i < n; i++ {
-			key := fmt.Sprintf("%s%d", prefix, i)
-			ts.backendPutString(defaultBucket, key, nil, fmt.Sprintf("body-%d", i))
-			keys[i] = key
-		}
-		return keys
-	}
+func TestBandwidthLimit(t *testing.T) {
+	const rate = 40000
+	ts := newTestServer(t, withFakerOptions(gofakes3.WithBandwidthLimit(rate)))
+	defer ts.Close()
+	svc := ts.s3Client()
 
-	t.Run("fallback-disabled", func(t *testing.T) {
-		ts := newTestServer(t,
-			withBackend(&backendWithUnimplementedPaging{s3mem.New()}),
-			withFakerOptions(gofakes3.WithUnimplementedPageError()),
-		)
-		defer ts.Close()
-		createData(ts, "", 5)
-		_, err := ts.listBucketV1Pages(nil, 2, "")
-		if !hasErrorCode(err, gofakes3.ErrNotImplemented) {
-			t.Fatal(err)
-		}
-	})
+	body := bytes.Repeat([]byte("x"), rate/2)
+	ts.backendPutBytes(defaultBucket, "object", nil, body)
 
-	t.Run("fallback-enabled", func(t *testing.T) {
-		ts := newTestServer(t, withBackend(&backendWithUnimplementedPaging{s3mem.New()}))
-		defer ts.Close()
-		createData(ts, "", 5)
-		r := ts.mustListBucketV1Pages(nil, 2, "")
+	// Transfer times are only checked against generous bounds, so that a
+	// busy machine does not fail the test:
+	for _, tc := range []struct {
+		rnge     string
+		size     int
+		expected time.Duration
+	}{
+		{"", rate / 2, 500 * time.Millisecond},
+		{"bytes=0-9999", 10000, 250 * time.Millisecond},
+		{"bytes=0-4999,10000-14999", 10000, 250 * time.Millisecond},
+	} {
+		t.Run(tc.rnge, func(t *testing.T) {
+			in := &s3.GetObjectInput{Bucket: aws.String(defaultBucket), Key: aws.String("object")}
+			if tc.rnge != "" {
+				in.Range = aws.String(tc.rnge)
+			}
+			start := time.Now()
+			out, err := svc.GetObject(in)
+			ts.OK(err)
+			n, err := io.Copy(ioutil.Discard, out.Body)
+			ts.OK(err)
+			out.Body.Close()
+			elapsed := time.Since(start)
 
-		// Without pagination, should just fall back to returning all keys:
-		if len(r.Contents) != 5 {
-			t.Fatal()
+			if n < int64(tc.size) {
+				t.Fatal("read", n, "bytes, expected at least", tc.size)
+			}
+			if elapsed < tc.expected*8/10 || elapsed > tc.expected*4 {
+				t.Fatal("transfer took", elapsed, "expected about", tc.expected)
+			}
+		})
+	}
+
+	t.Run("cancel", func(t *testing.T) {
+		ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+		defer cancel()
+		start := time.Now()
+		out, err := svc.GetObjectWithContext(ctx, &s3.GetObjectInput{Bucket: aws.String(defaultBucket), Key: aws.String("object")})
+		if err == nil {
+			_, err = io.Copy(ioutil.Discard, out.Body)
+			out.Body.Close()
+		}
+		if err == nil {
+			t.Fatal("expected the transfer to be cancelled")
+		}
+		if elapsed := time.Since(start); elapsed > 400*time.Millisecond {
+			t.Fatal("cancelled transfer took", elapsed)
 		}
 	})
 }
-
-func tryDumpResponse(rs *http.Response, body bool) string {
-	b, _ := httputil.DumpResponse(rs, body)
-	return string(b)
-}
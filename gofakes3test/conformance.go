@@ -0,0 +1,611 @@
+// Package gofakes3test contains a conformance test suite for implementations
+// of gofakes3.Backend, so that every Backend behaves the way GoFakeS3 expects
+// it to, whether it is bundled with GoFakeS3 or not:
+//
+//	func TestConformance(t *testing.T) {
+//		gofakes3test.RunBackendConformance(t, func(t *testing.T) gofakes3.Backend {
+//			return mybackend.New()
+//		})
+//	}
+//
+// The suite calls the Backend directly rather than through GoFakeS3.
+//
+// It also contains helpers for testing S3 clients against GoFakeS3, such as
+// NewTLSServer.
+package gofakes3test
+
+import (
+	"bytes"
+	"crypto/md5"
+	"fmt"
+	"io/ioutil"
+	"reflect"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/johannesboyne/gofakes3"
+)
+
+// Option configures RunBackendConformance.
+type Option func(c *config)
+
+type config struct {
+	bucket       string
+	singleBucket bool
+	timeSource   gofakes3.TimeSourceAdvancer
+}
+
+// WithSingleBucket runs the suite against a Backend that serves exactly one
+// bucket, which it creates itself, like s3afero.SingleBucketBackend. The
+// tests that create and delete buckets are skipped.
+func WithSingleBucket(name string) Option {
+	return func(c *config) {
+		c.bucket = name
+		c.singleBucket = true
+	}
+}
+
+// WithTimeSource tells the suite that each Backend returned by newBackend
+// takes the time from timeSource, so that the creation dates of buckets can
+// be checked. The suite advances timeSource itself.
+func WithTimeSource(timeSource gofakes3.TimeSourceAdvancer) Option {
+	return func(c *config) { c.timeSource = timeSource }
+}
+
+// RunBackendConformance runs each of the conformance tests as a subtest of t,
+// with a new, empty Backend returned by newBackend.
+//
+// If the Backend implements gofakes3.VersionedBackend, those methods are
+// tested too.
+func RunBackendConformance(t *testing.T, newBackend func(t *testing.T) gofakes3.Backend, opts ...Option) {
+	c := config{bucket: "conformance"}
+	for _, opt := range opts {
+		opt(&c)
+	}
+
+	for _, test := range []struct {
+		name string
+		fn   func(s *suite)
+	}{
+		{"Buckets", (*suite).testBuckets},
+		{"PutGetHead", (*suite).testPutGetHead},
+		{"Missing", (*suite).testMissing},
+		{"Overwrite", (*suite).testOverwrite},
+		{"ZeroByteObject", (*suite).testZeroByteObject},
+		{"Delete", (*suite).testDelete},
+		{"ListBucket", (*suite).testListBucket},
+		{"ListBucketPages", (*suite).testListBucketPages},
+		{"ListBucketMarkerInPrefix", (*suite).testListBucketMarkerInPrefix},
+		{"Versions", (*suite).testVersions},
+	} {
+		test := test
+		t.Run(test.name, func(t *testing.T) {
+			s := &suite{T: t, config: c, backend: newBackend(t)}
+			if !c.singleBucket {
+				s.ok(s.backend.CreateBucket(s.bucket))
+			}
+			test.fn(s)
+		})
+	}
+}
+
+type suite struct {
+	*testing.T
+	config
+	backend gofakes3.Backend
+}
+
+func (s *suite) ok(err error) {
+	s.Helper()
+	if err != nil {
+		s.Fatal(err)
+	}
+}
+
+func (s *suite) assertErrorCode(err error, code gofakes3.ErrorCode, what string) {
+	s.Helper()
+	if !gofakes3.HasErrorCode(err, code) {
+		s.Fatalf("%s: expected %s, found %v", what, code, err)
+	}
+}
+
+func (s *suite) put(key string, meta map[string]string, body string) gofakes3.PutObjectResult {
+	s.Helper()
+	result, err := s.backend.PutObject(s.bucket, key, meta, bytes.NewReader([]byte(body)), int64(len(body)))
+	s.ok(err)
+	return result
+}
+
+// assertObject checks that obj, which must be closed, holds body.
+func (s *suite) assertObject(obj *gofakes3.Object, key, body string) {
+	s.Helper()
+	defer obj.Contents.Close()
+
+	contents, err := ioutil.ReadAll(obj.Contents)
+	s.ok(err)
+	if string(contents) != body {
+		s.Fatalf("%s: expected body %q, found %q", key, body, contents)
+	}
+	if obj.Size != int64(len(body)) {
+		s.Fatalf("%s: expected size %d, found %d", key, len(body), obj.Size)
+	}
+	if hash := md5.Sum([]byte(body)); !bytes.Equal(obj.Hash, hash[:]) {
+		s.Fatalf("%s: expected hash %x, found %x", key, hash, obj.Hash)
+	}
+}
+
+func (s *suite) get(key, body string) *gofakes3.Object {
+	s.Helper()
+	obj, err := s.backend.GetObject(s.bucket, key, nil)
+	s.ok(err)
+	s.assertObject(obj, key, body)
+	return obj
+}
+
+// head checks that HeadObject describes an object holding body, but does not
+// return the body.
+func (s *suite) head(key, body string) *gofakes3.Object {
+	s.Helper()
+	obj, err := s.backend.HeadObject(s.bucket, key)
+	s.ok(err)
+	defer obj.Contents.Close()
+
+	if obj.Size != int64(len(body)) {
+		s.Fatalf("%s: expected size %d, found %d", key, len(body), obj.Size)
+	}
+	if hash := md5.Sum([]byte(body)); !bytes.Equal(obj.Hash, hash[:]) {
+		s.Fatalf("%s: expected hash %x, found %x", key, hash, obj.Hash)
+	}
+	if contents, err := ioutil.ReadAll(obj.Contents); err != nil || len(contents) != 0 {
+		s.Fatalf("%s: expected no contents, found %q %v", key, contents, err)
+	}
+	return obj
+}
+
+func (s *suite) assertMeta(obj *gofakes3.Object, key string, meta map[string]string) {
+	s.Helper()
+	for k, v := range meta {
+		if obj.Metadata[k] != v {
+			s.Fatalf("%s: expected metadata %s=%q, found %q", key, k, v, obj.Metadata[k])
+		}
+	}
+}
+
+func (s *suite) list(prefix *gofakes3.Prefix, page gofakes3.ListBucketPage) *gofakes3.ObjectList {
+	s.Helper()
+	list, err := s.backend.ListBucket(s.bucket, prefix, page)
+	s.ok(err)
+	return list
+}
+
+// assertContents checks the keys and sizes of the whole of the bucket.
+func (s *suite) assertContents(sizes map[string]int64) {
+	s.Helper()
+	found := map[string]int64{}
+	for _, item := range s.list(nil, gofakes3.ListBucketPage{}).Contents {
+		if _, ok := found[item.Key]; ok {
+			s.Fatalf("%s listed more than once", item.Key)
+		}
+		found[item.Key] = item.Size
+	}
+	if !reflect.DeepEqual(found, sizes) {
+		s.Fatalf("unexpected contents:\nexp: %v\ngot: %v", sizes, found)
+	}
+}
+
+func (s *suite) testBuckets() {
+	if s.singleBucket {
+		s.Skip("the Backend has a single bucket")
+	}
+
+	const other = "conformance-other"
+
+	exists, err := s.backend.BucketExists(other)
+	s.ok(err)
+	if exists {
+		s.Fatal(other, "exists before it was created")
+	}
+	s.assertErrorCode(s.backend.DeleteBucket(other), gofakes3.ErrNoSuchBucket, "DeleteBucket before CreateBucket")
+
+	created := s.advance()
+	s.ok(s.backend.CreateBucket(other))
+	s.assertErrorCode(s.backend.CreateBucket(other), gofakes3.ErrBucketAlreadyExists, "CreateBucket twice")
+
+	exists, err = s.backend.BucketExists(other)
+	s.ok(err)
+	if !exists {
+		s.Fatal(other, "does not exist after it was created")
+	}
+
+	buckets, err := s.backend.ListBuckets()
+	s.ok(err)
+	var names []string
+	for _, bucket := range buckets {
+		if bucket.CreationDate.IsZero() {
+			s.Fatal(bucket.Name, "has no creation date")
+		}
+		names = append(names, bucket.Name)
+	}
+	sort.Strings(names)
+	if exp := []string{s.bucket, other}; !reflect.DeepEqual(names, exp) {
+		s.Fatalf("unexpected buckets:\nexp: %q\ngot: %q", exp, names)
+	}
+
+	// Putting an object in the bucket must not change its creation date:
+	s.advance()
+	_, err = s.backend.PutObject(other, "object", nil, bytes.NewReader([]byte("body")), 4)
+	s.ok(err)
+	s.assertCreationDate(other, created)
+	s.assertErrorCode(s.backend.DeleteBucket(other), gofakes3.ErrBucketNotEmpty, "DeleteBucket on a bucket that is not empty")
+
+	_, err = s.backend.DeleteObject(other, "object")
+	s.ok(err)
+	s.ok(s.backend.DeleteBucket(other))
+
+	exists, err = s.backend.BucketExists(other)
+	s.ok(err)
+	if exists {
+		s.Fatal(other, "exists after it was deleted")
+	}
+
+	// A bucket that is created again is a new bucket:
+	recreated := s.advance()
+	s.ok(s.backend.CreateBucket(other))
+	s.assertCreationDate(other, recreated)
+	s.ok(s.backend.DeleteBucket(other))
+}
+
+// advance moves the time source on, if there is one, and returns the new
+// time.
+func (s *suite) advance() time.Time {
+	if s.timeSource == nil {
+		return time.Time{}
+	}
+	s.timeSource.Advance(time.Minute)
+	return s.timeSource.Now()
+}
+
+// assertCreationDate does nothing without a time source, as the dates can
+// not be known.
+func (s *suite) assertCreationDate(bucket string, expected time.Time) {
+	s.Helper()
+	if s.timeSource == nil {
+		return
+	}
+
+	buckets, err := s.backend.ListBuckets()
+	s.ok(err)
+	for _, info := range buckets {
+		if info.Name == bucket {
+			// Backends may store the date less precisely than the time source
+			// supplies it, and S3 only returns it to the millisecond:
+			if !info.CreationDate.Truncate(time.Millisecond).Equal(expected.Truncate(time.Millisecond)) {
+				s.Fatalf("creation date of %s:\nexp: %s\ngot: %s", bucket, expected, info.CreationDate.Time)
+			}
+			return
+		}
+	}
+	s.Fatal(bucket, "not listed")
+}
+
+func (s *suite) testPutGetHead() {
+	meta := map[string]string{
+		"Content-Type":   "text/plain",
+		"X-Amz-Meta-Foo": "bar",
+	}
+	s.put("object", meta, "hello world")
+	s.put("dir/object", nil, "nested")
+
+	s.assertMeta(s.get("object", "hello world"), "object", meta)
+	s.assertMeta(s.head("object", "hello world"), "object", meta)
+	s.get("dir/object", "nested")
+
+	// Backends that do not support ranges must say so:
+	obj, err := s.backend.GetObject(s.bucket, "object", &gofakes3.ObjectRangeRequest{Start: 6, End: 8})
+	if gofakes3.HasErrorCode(err, gofakes3.ErrNotImplemented) {
+		return
+	}
+	s.ok(err)
+	defer obj.Contents.Close()
+	contents, err := ioutil.ReadAll(obj.Contents)
+	s.ok(err)
+	if string(contents) != "wor" {
+		s.Fatalf("expected range %q, found %q", "wor", contents)
+	}
+	if obj.Range == nil || *obj.Range != (gofakes3.ObjectRange{Start: 6, Length: 3}) {
+		s.Fatalf("unexpected range %+v", obj.Range)
+	}
+	if obj.Size != 11 {
+		s.Fatal("expected the size of the whole object, found", obj.Size)
+	}
+}
+
+func (s *suite) testMissing() {
+	_, err := s.backend.GetObject(s.bucket, "missing", nil)
+	s.assertErrorCode(err, gofakes3.ErrNoSuchKey, "GetObject on a missing object")
+	_, err = s.backend.HeadObject(s.bucket, "missing")
+	s.assertErrorCode(err, gofakes3.ErrNoSuchKey, "HeadObject on a missing object")
+
+	const missing = "conformance-missing"
+	_, err = s.backend.GetObject(missing, "object", nil)
+	s.assertErrorCode(err, gofakes3.ErrNoSuchBucket, "GetObject in a missing bucket")
+	_, err = s.backend.HeadObject(missing, "object")
+	s.assertErrorCode(err, gofakes3.ErrNoSuchBucket, "HeadObject in a missing bucket")
+	_, err = s.backend.ListBucket(missing, nil, gofakes3.ListBucketPage{})
+	s.assertErrorCode(err, gofakes3.ErrNoSuchBucket, "ListBucket on a missing bucket")
+	_, err = s.backend.DeleteObject(missing, "object")
+	s.assertErrorCode(err, gofakes3.ErrNoSuchBucket, "DeleteObject in a missing bucket")
+}
+
+func (s *suite) testOverwrite() {
+	s.put("object", map[string]string{"X-Amz-Meta-Foo": "first"}, "first")
+	s.put("object", map[string]string{"X-Amz-Meta-Foo": "second"}, "the second body")
+
+	s.assertMeta(s.get("object", "the second body"), "object", map[string]string{"X-Amz-Meta-Foo": "second"})
+	s.head("object", "the second body")
+	s.assertContents(map[string]int64{"object": 15})
+}
+
+func (s *suite) testZeroByteObject() {
+	const emptyETag = `"d41d8cd98f00b204e9800998ecf8427e"`
+
+	s.put("empty", nil, "")
+	s.get("empty", "")
+	s.head("empty", "")
+
+	list := s.list(nil, gofakes3.ListBucketPage{})
+	if len(list.Contents) != 1 || list.Contents[0].Size != 0 || list.Contents[0].ETag != emptyETag {
+		s.Fatalf("unexpected listing %+v", list.Contents)
+	}
+}
+
+func (s *suite) testDelete() {
+	s.put("a", nil, "a")
+	s.put("b", nil, "b")
+	s.put("c", nil, "c")
+
+	_, err := s.backend.DeleteObject(s.bucket, "a")
+	s.ok(err)
+	_, err = s.backend.GetObject(s.bucket, "a", nil)
+	s.assertErrorCode(err, gofakes3.ErrNoSuchKey, "GetObject after DeleteObject")
+
+	// Deleting a missing object is not an error:
+	_, err = s.backend.DeleteObject(s.bucket, "a")
+	s.ok(err)
+
+	result, err := s.backend.DeleteMulti(s.bucket, "b", "missing")
+	s.ok(err)
+	if len(result.Error) != 0 {
+		s.Fatal("unexpected errors", result.Error)
+	}
+	var deleted []string
+	for _, v := range result.Deleted {
+		deleted = append(deleted, v.Key)
+	}
+	sort.Strings(deleted)
+	if exp := []string{"b", "missing"}; !reflect.DeepEqual(deleted, exp) {
+		s.Fatalf("unexpected deleted keys:\nexp: %q\ngot: %q", exp, deleted)
+	}
+
+	s.assertContents(map[string]int64{"c": 1})
+}
+
+// listKeys are listed by testListBucket and testListBucketPages. None of them
+// is a "directory" of another, so that they suit Backends that store objects
+// as files.
+var listKeys = []string{"c0", "a/c/e", "b", "a-b", "c/d", "a/b", "a/c/d"}
+
+var listCases = []struct {
+	prefix *gofakes3.Prefix
+	items  []string // Contents and CommonPrefixes, in order
+}{
+	{nil, []string{"a-b", "a/b", "a/c/d", "a/c/e", "b", "c/d", "c0"}},
+	{&gofakes3.Prefix{HasPrefix: true, Prefix: "a"}, []string{"a-b", "a/b", "a/c/d", "a/c/e"}},
+	{&gofakes3.Prefix{HasDelimiter: true, Delimiter: "/"}, []string{"a-b", "a/", "b", "c/", "c0"}},
+	{folder(""), []string{"a-b", "a/", "b", "c/", "c0"}},
+	{folder("a/"), []string{"a/b", "a/c/"}},
+	{folder("a/c"), []string{"a/c/"}},
+	{folder("a/c/"), []string{"a/c/d", "a/c/e"}},
+	{folder("a/b"), []string{"a/b"}},
+	{folder("nope/"), nil},
+}
+
+func folder(prefix string) *gofakes3.Prefix {
+	p := gofakes3.NewFolderPrefix(prefix)
+	return &p
+}
+
+func listItems(list *gofakes3.ObjectList) []string {
+	var items []string
+	for _, v := range list.Contents {
+		items = append(items, v.Key)
+	}
+	for _, v := range list.CommonPrefixes {
+		items = append(items, v.Prefix)
+	}
+	sort.Strings(items)
+	return items
+}
+
+func (s *suite) testListBucket() {
+	for _, key := range listKeys {
+		s.put(key, nil, key)
+	}
+
+	for _, tc := range listCases {
+		list := s.list(tc.prefix, gofakes3.ListBucketPage{})
+		if items := listItems(list); !reflect.DeepEqual(items, tc.items) {
+			s.Fatalf("%v:\nexp: %q\ngot: %q", tc.prefix, tc.items, items)
+		}
+		if list.IsTruncated {
+			s.Fatal(tc.prefix, "listing was truncated")
+		}
+		for _, item := range list.Contents {
+			if item.Size != int64(len(item.Key)) {
+				s.Fatalf("%s: expected size %d, found %d", item.Key, len(item.Key), item.Size)
+			}
+			if hash := md5.Sum([]byte(item.Key)); item.ETag != fmt.Sprintf(`"%x"`, hash) {
+				s.Fatalf("%s: unexpected ETag %s", item.Key, item.ETag)
+			}
+			if item.LastModified.IsZero() {
+				s.Fatal(item.Key, "has no LastModified time")
+			}
+		}
+	}
+}
+
+func (s *suite) testListBucketPages() {
+	for _, key := range listKeys {
+		s.put(key, nil, key)
+	}
+
+	for _, tc := range listCases {
+		for maxKeys := int64(1); maxKeys <= int64(len(tc.items))+1; maxKeys++ {
+			var found []string
+			var page = gofakes3.ListBucketPage{MaxKeys: maxKeys}
+			for i := 0; ; i++ {
+				if i > len(listKeys) {
+					s.Fatal(tc.prefix, "listing did not end with max keys", maxKeys)
+				}
+
+				list, err := s.backend.ListBucket(s.bucket, tc.prefix, page)
+				if err == gofakes3.ErrInternalPageNotImplemented {
+					s.Skip("the Backend does not support pagination")
+				}
+				s.ok(err)
+
+				items := listItems(list)
+				if int64(len(items)) > maxKeys {
+					s.Fatalf("%v: %d items in a page of %d", tc.prefix, len(items), maxKeys)
+				}
+				found = append(found, items...)
+
+				if !list.IsTruncated {
+					break
+				}
+				if len(items) == 0 || list.NextMarker != items[len(items)-1] {
+					s.Fatalf("%v: expected the last item of %q as the next marker, found %q", tc.prefix, items, list.NextMarker)
+				}
+				page.HasMarker, page.Marker = true, list.NextMarker
+			}
+
+			if !reflect.DeepEqual(found, tc.items) {
+				s.Fatalf("%v max keys %d:\nexp: %q\ngot: %q", tc.prefix, maxKeys, tc.items, found)
+			}
+		}
+	}
+}
+
+// A marker may be a key inside a common prefix, rather than the prefix itself,
+// in which case the prefix was listed before the marker and is not listed
+// again.
+func (s *suite) testListBucketMarkerInPrefix() {
+	for _, key := range listKeys {
+		s.put(key, nil, key)
+	}
+
+	for _, tc := range []struct {
+		prefix *gofakes3.Prefix
+		marker string
+		items  []string
+	}{
+		{&gofakes3.Prefix{HasDelimiter: true, Delimiter: "/"}, "a/b", []string{"b", "c/", "c0"}},
+		{folder(""), "a/c/d", []string{"b", "c/", "c0"}},
+		{folder("a/"), "a/c/d", nil},
+	} {
+		list, err := s.backend.ListBucket(s.bucket, tc.prefix, gofakes3.ListBucketPage{Marker: tc.marker, HasMarker: true})
+		if err == gofakes3.ErrInternalPageNotImplemented {
+			s.Skip("the Backend does not support pagination")
+		}
+		s.ok(err)
+		if items := listItems(list); !reflect.DeepEqual(items, tc.items) {
+			s.Fatalf("%v after %q:\nexp: %q\ngot: %q", tc.prefix, tc.marker, tc.items, items)
+		}
+	}
+}
+
+func (s *suite) testVersions() {
+	versioned, ok := s.backend.(gofakes3.VersionedBackend)
+	if !ok {
+		s.Skip("the Backend is not a VersionedBackend")
+	}
+
+	config, err := versioned.VersioningConfiguration(s.bucket)
+	s.ok(err)
+	if config.Status != "" || config.MFADelete != "" {
+		s.Fatalf("expected an empty configuration for a new bucket, found %+v", config)
+	}
+	s.ok(versioned.SetVersioningConfiguration(s.bucket, gofakes3.VersioningConfiguration{Status: gofakes3.VersioningEnabled}))
+	config, err = versioned.VersioningConfiguration(s.bucket)
+	s.ok(err)
+	if config.Status != gofakes3.VersioningEnabled {
+		s.Fatal("versioning was not enabled:", config.Status)
+	}
+
+	first := s.put("object", nil, "first")
+	second := s.put("object", nil, "second")
+	if first.VersionID == "" || second.VersionID == "" || first.VersionID == second.VersionID {
+		s.Fatalf("expected two different version IDs, found %q and %q", first.VersionID, second.VersionID)
+	}
+
+	s.get("object", "second")
+	obj, err := versioned.GetObjectVersion(s.bucket, "object", first.VersionID, nil)
+	s.ok(err)
+	s.assertObject(obj, "object", "first")
+	if obj.VersionID != first.VersionID {
+		s.Fatalf("expected version %q, found %q", first.VersionID, obj.VersionID)
+	}
+	obj, err = versioned.HeadObjectVersion(s.bucket, "object", first.VersionID)
+	s.ok(err)
+	obj.Contents.Close()
+	if obj.Size != 5 {
+		s.Fatal("expected the size of the first version, found", obj.Size)
+	}
+
+	_, err = versioned.GetObjectVersion(s.bucket, "object", "nope", nil)
+	s.assertErrorCode(err, gofakes3.ErrNoSuchVersion, "GetObjectVersion on a missing version")
+
+	// Deleting the object leaves a delete marker as the latest version:
+	result, err := s.backend.DeleteObject(s.bucket, "object")
+	s.ok(err)
+	if !result.IsDeleteMarker || result.VersionID == "" {
+		s.Fatalf("expected a delete marker, found %+v", result)
+	}
+	_, err = s.backend.GetObject(s.bucket, "object", nil)
+	s.assertErrorCode(err, gofakes3.ErrNoSuchKey, "GetObject after DeleteObject")
+
+	versions, err := versioned.ListBucketVersions(s.bucket, nil, nil)
+	s.ok(err)
+	var found []string
+	for _, item := range versions.Versions {
+		switch item := item.(type) {
+		case *gofakes3.DeleteMarker:
+			found = append(found, fmt.Sprintf("marker latest=%t", item.IsLatest))
+		case *gofakes3.Version:
+			found = append(found, fmt.Sprintf("%s latest=%t", item.VersionID, item.IsLatest))
+		}
+	}
+	exp := []string{
+		"marker latest=true",
+		fmt.Sprintf("%s latest=false", second.VersionID),
+		fmt.Sprintf("%s latest=false", first.VersionID),
+	}
+	if !reflect.DeepEqual(found, exp) {
+		s.Fatalf("unexpected versions:\nexp: %q\ngot: %q", exp, found)
+	}
+
+	// Deleting a version removes it for good:
+	_, err = versioned.DeleteObjectVersion(s.bucket, "object", first.VersionID)
+	s.ok(err)
+	_, err = versioned.GetObjectVersion(s.bucket, "object", first.VersionID, nil)
+	s.assertErrorCode(err, gofakes3.ErrNoSuchVersion, "GetObjectVersion after DeleteObjectVersion")
+
+	obj, err = versioned.GetObjectVersion(s.bucket, "object", second.VersionID, nil)
+	s.ok(err)
+	s.assertObject(obj, "object", "second")
+
+	// Deleting a missing version is not an error:
+	_, err = versioned.DeleteObjectVersion(s.bucket, "object", first.VersionID)
+	s.ok(err)
+}
@@ -0,0 +1,41 @@
+package gofakes3test
+
+import (
+	"net/http/httptest"
+
+	"github.com/johannesboyne/gofakes3"
+)
+
+// TLSServer is a GoFakeS3 server listening for HTTPS requests on the loopback
+// interface, returned by NewTLSServer.
+type TLSServer struct {
+	*httptest.Server
+	Faker *gofakes3.GoFakeS3
+}
+
+// NewTLSServer starts a GoFakeS3 server for backend over HTTPS, for testing
+// clients that refuse to use plain HTTP. The server uses a throwaway
+// self-signed certificate: its Client method returns an *http.Client that
+// trusts it, and its Certificate method returns the certificate itself. The
+// caller should call Close when finished, to shut it down.
+//
+//	ts := gofakes3test.NewTLSServer(s3mem.New())
+//	defer ts.Close()
+//
+//	config := aws.NewConfig().
+//		WithEndpoint(ts.URL).
+//		WithHTTPClient(ts.Client())
+func NewTLSServer(backend gofakes3.Backend, opts ...gofakes3.Option) *TLSServer {
+	faker := gofakes3.New(backend, opts...)
+	return &TLSServer{
+		Server: httptest.NewTLSServer(faker.Server()),
+		Faker:  faker,
+	}
+}
+
+// Close shuts down the server and stops any background work started by
+// GoFakeS3. It does not close the Backend.
+func (ts *TLSServer) Close() {
+	ts.Server.Close()
+	ts.Faker.Close()
+}
@@ -19,6 +19,10 @@ type hashingReader struct {
 	expected []byte
 	hash     hash.Hash
 	sum      []byte
+
+	// mismatch is returned if the hash does not match; if nil, ErrBadDigest
+	// is used.
+	mismatch error
 }
 
 func newHashingReader(inner io.Reader, expectedMD5Base64 string) (*hashingReader, error) {
@@ -42,6 +46,17 @@ func newHashingReader(inner io.Reader, expectedMD5Base64 string) (*hashingReader
 	}, nil
 }
 
+// newChecksumReader returns a hashingReader that validates the data read from
+// inner against a checksum supplied in one of the 'x-amz-checksum-*' headers.
+func newChecksumReader(inner io.Reader, c *checksum) *hashingReader {
+	return &hashingReader{
+		inner:    inner,
+		expected: c.value,
+		hash:     c.algorithm.newHash(),
+		mismatch: ErrorMessagef(ErrBadDigest, "The %s you specified did not match the calculated checksum.", c.algorithm),
+	}
+}
+
 // Sum returns the hash of the data read from the inner reader so far.
 // If into is passed, it may be used if the hash needs to be computed.
 func (h *hashingReader) Sum(into []byte) []byte {
@@ -66,6 +81,9 @@ func (h *hashingReader) Read(p []byte) (n int, err error) {
 			h.sum = h.hash.Sum(nil)
 
 			if h.expected != nil && !bytes.Equal(h.sum, h.expected) {
+				if h.mismatch != nil {
+					return n, h.mismatch
+				}
 				// FIXME: some more context here would be useful; need to flush out
 				// what S3 responds with in this case.
 				return n, ErrBadDigest
@@ -22,6 +22,7 @@ import (
 	"net/url"
 	"os"
 	"path"
+	"path/filepath"
 	"reflect"
 	"sort"
 	"strconv"
@@ -37,7 +38,10 @@ import (
 	"github.com/aws/aws-sdk-go/service/s3"
 	"github.com/aws/aws-sdk-go/service/s3/s3manager"
 	"github.com/johannesboyne/gofakes3"
+	"github.com/johannesboyne/gofakes3/backend/s3afero"
+	"github.com/johannesboyne/gofakes3/backend/s3bolt"
 	"github.com/johannesboyne/gofakes3/backend/s3mem"
+	"github.com/spf13/afero"
 )
 
 const (
@@ -172,6 +176,48 @@ func withBackend(backend gofakes3.Backend) testServerOption {
 	return func(ts *testServer) { ts.backend = backend }
 }
 
+// bundledBackend is one of the Backends that come with GoFakeS3, for tests
+// that every Backend should pass.
+type bundledBackend struct {
+	name    string
+	backend func(t *testing.T) gofakes3.Backend
+	opts    []testServerOption
+}
+
+// options returns the options for a testServer that uses the Backend.
+func (b bundledBackend) options(t *testing.T, opts ...testServerOption) []testServerOption {
+	out := append([]testServerOption{}, b.opts...)
+	out = append(out, opts...)
+	return append(out, withBackend(b.backend(t)))
+}
+
+func bundledBackends() []bundledBackend {
+	return []bundledBackend{
+		{name: "s3mem", backend: func(t *testing.T) gofakes3.Backend { return s3mem.New() }},
+		{name: "s3bolt", backend: func(t *testing.T) gofakes3.Backend {
+			db, err := s3bolt.NewFile(filepath.Join(t.TempDir(), "bolt.db"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			return db
+		}},
+		{name: "s3afero/multi", backend: func(t *testing.T) gofakes3.Backend {
+			b, err := s3afero.MultiBucket(afero.NewMemMapFs())
+			if err != nil {
+				t.Fatal(err)
+			}
+			return b
+		}},
+		{name: "s3afero/single", opts: []testServerOption{withoutInitialBuckets()}, backend: func(t *testing.T) gofakes3.Backend {
+			b, err := s3afero.SingleBucket(defaultBucket, afero.NewMemMapFs(), nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			return b
+		}},
+	}
+}
+
 func newTestServer(t *testing.T, opts ...testServerOption) *testServer {
 	t.Helper()
 	var ts = testServer{
@@ -720,6 +766,7 @@ func (ts *testServer) listBucketV2Pages(prefix *gofakes3.Prefix, maxKeys int64,
 
 func (ts *testServer) Close() {
 	ts.server.Close()
+	ts.GoFakeS3.Close()
 }
 
 func hashMD5Bytes(body []byte) hashValue {
@@ -1,11 +1,21 @@
 package s3io
 
-import "io"
+import (
+	"bytes"
+	"io"
+)
 
 type ReaderWithDummyCloser struct{ io.Reader }
 
 func (d ReaderWithDummyCloser) Close() error { return nil }
 
+// BytesReaderWithDummyCloser is like ReaderWithDummyCloser, but keeps the
+// ReadAt and Seek methods of the bytes.Reader, so that parts of it can be read
+// without reading the rest.
+type BytesReaderWithDummyCloser struct{ *bytes.Reader }
+
+func (d BytesReaderWithDummyCloser) Close() error { return nil }
+
 type NoOpReadCloser struct{}
 
 func (d NoOpReadCloser) Read(b []byte) (n int, err error) { return 0, io.EOF }
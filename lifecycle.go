@@ -0,0 +1,232 @@
+package gofakes3
+
+import (
+	"strings"
+	"time"
+)
+
+// From https://docs.aws.amazon.com/AmazonS3/latest/dev/intro-lifecycle-rules.html:
+//
+//	"A lifecycle configuration can have up to 1,000 rules."
+const MaxLifecycleRules = 1000
+
+func validateLifecycleConfiguration(config *LifecycleConfiguration) error {
+	if len(config.Rules) == 0 || len(config.Rules) > MaxLifecycleRules {
+		return ErrMalformedXML
+	}
+
+	ids := map[string]bool{}
+	for _, rule := range config.Rules {
+		if rule.Status != LifecycleEnabled && rule.Status != LifecycleDisabled {
+			return ErrMalformedXML
+		}
+		if rule.ID != "" {
+			if ids[rule.ID] {
+				return ErrorMessage(ErrInvalidArgument, "Rule ID must be unique. Found same ID for more than one rule")
+			}
+			ids[rule.ID] = true
+		}
+		if rule.Prefix != nil && rule.Filter != nil {
+			return ErrMalformedXML
+		}
+		if rule.Expiration == nil && len(rule.Transitions) == 0 &&
+			rule.NoncurrentVersionExpiration == nil && len(rule.NoncurrentVersionTransitions) == 0 &&
+			rule.AbortIncompleteMultipartUpload == nil {
+			return ErrorMessage(ErrInvalidRequest, "At least one action needs to be specified in a rule")
+		}
+
+		if exp := rule.Expiration; exp != nil {
+			if exp.Days < 0 || (exp.Days > 0 && !exp.Date.IsZero()) {
+				return ErrMalformedXML
+			}
+			if exp.Days == 0 && exp.Date.IsZero() && !exp.ExpiredObjectDeleteMarker {
+				return ErrorMessage(ErrInvalidArgument, "'Days' for Expiration action must be a positive integer")
+			}
+		}
+	}
+
+	return nil
+}
+
+// matches reports whether the rule applies to an object. tags are only
+// fetched if the rule's filter needs them.
+func (rule *LifecycleRule) matches(key string, tags func() map[string]string) bool {
+	var prefix string
+	var wantTags []Tag
+
+	if rule.Prefix != nil {
+		prefix = *rule.Prefix
+	} else if f := rule.Filter; f != nil {
+		switch {
+		case f.Prefix != nil:
+			prefix = *f.Prefix
+		case f.Tag != nil:
+			wantTags = []Tag{*f.Tag}
+		case f.And != nil:
+			prefix, wantTags = f.And.Prefix, f.And.Tags
+		}
+	}
+
+	return matchesKeyAndTags(key, tags, prefix, wantTags)
+}
+
+// matchesKeyAndTags reports whether an object's key starts with prefix, and
+// its tags include every one of wantTags. tags are only fetched if there are
+// wantTags.
+func matchesKeyAndTags(key string, tags func() map[string]string, prefix string, wantTags []Tag) bool {
+	if !strings.HasPrefix(key, prefix) {
+		return false
+	}
+	if len(wantTags) > 0 {
+		found := tags()
+		for _, tag := range wantTags {
+			if v, ok := found[tag.Key]; !ok || v != tag.Value {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// expiresAt returns the time at which an object last modified at the given
+// time expires according to exp, or the zero time if it does not.
+//
+// From the docs: "Amazon S3 calculates the time by adding the number of days
+// specified in the rule to the object creation time and rounding the
+// resulting time to the next day midnight UTC."
+func (exp *LifecycleExpiration) expiresAt(lastModified time.Time) time.Time {
+	if !exp.Date.IsZero() {
+		return exp.Date.Time
+	}
+	if exp.Days <= 0 {
+		return time.Time{}
+	}
+
+	at := lastModified.UTC().Add(time.Duration(exp.Days) * 24 * time.Hour)
+	midnight := at.Truncate(24 * time.Hour)
+	if midnight.Before(at) {
+		midnight = midnight.Add(24 * time.Hour)
+	}
+	return midnight
+}
+
+func (g *GoFakeS3) runLifecycleSweeper(interval time.Duration) {
+	defer g.inFlight.Done()
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-g.stopSweep:
+			return
+		case <-ticker.C:
+			if err := g.SweepLifecycle(); err != nil {
+				g.log.Print(LogErr, "lifecycle sweep failed:", err)
+			}
+		}
+	}
+}
+
+// SweepLifecycle applies the enabled Expiration actions of every bucket's
+// lifecycle configuration once, deleting each current object version that
+// has expired according to the server's TimeSource. Objects protected by an
+// object lock are skipped.
+//
+// In a versioned bucket, expiring an object creates a delete marker, as it
+// does in S3. The other lifecycle actions (transitions, noncurrent version
+// expiration and aborting multipart uploads) are stored but not enforced.
+//
+// SweepLifecycle does nothing if the Backend does not implement
+// LifecycleBackend.
+func (g *GoFakeS3) SweepLifecycle() error {
+	if g.lifecycle == nil {
+		return nil
+	}
+
+	buckets, err := g.storage.ListBuckets()
+	if err != nil {
+		return err
+	}
+
+	for _, bucket := range buckets {
+		config, err := g.lifecycle.BucketLifecycleConfiguration(bucket.Name)
+		if HasErrorCode(err, ErrNoSuchBucket) {
+			continue // Deleted since we listed the buckets
+		} else if err != nil {
+			return err
+		}
+		if config == nil {
+			continue
+		}
+
+		if err := g.sweepBucketLifecycle(bucket.Name, config); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (g *GoFakeS3) sweepBucketLifecycle(bucket string, config *LifecycleConfiguration) error {
+	now := g.timeSource.Now()
+
+	objects, err := g.storage.ListBucket(bucket, &Prefix{}, ListBucketPage{})
+	if HasErrorCode(err, ErrNoSuchBucket) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+
+	for _, item := range objects.Contents {
+		var tags map[string]string
+		getTags := func() map[string]string {
+			if tags == nil && g.tagging != nil {
+				tags, _ = g.tagging.GetObjectTagging(bucket, item.Key, "")
+			}
+			return tags
+		}
+
+		expired := false
+		for i := range config.Rules {
+			rule := &config.Rules[i]
+			if rule.Status != LifecycleEnabled || rule.Expiration == nil {
+				continue
+			}
+			at := rule.Expiration.expiresAt(item.LastModified.Time)
+			if !at.IsZero() && !now.Before(at) && rule.matches(item.Key, getTags) {
+				expired = true
+				break
+			}
+		}
+		if !expired {
+			continue
+		}
+
+		// The object may have been replaced or deleted since it was listed;
+		// only expire it if it is still the version we saw:
+		if obj, err := g.storage.HeadObject(bucket, item.Key); err != nil {
+			continue
+		} else {
+			obj.Contents.Close()
+			if etag := ObjectETag(obj.Hash, obj.Metadata); len(obj.Hash) > 0 && etag != item.ETag {
+				continue
+			}
+		}
+
+		if err := g.checkObjectLock(bucket, item.Key, "", false); HasErrorCode(err, ErrAccessDenied) {
+			continue
+		} else if err != nil {
+			return err
+		}
+
+		if _, err := g.storage.DeleteObject(bucket, item.Key); err != nil {
+			if HasErrorCode(err, ErrNoSuchKey) || HasErrorCode(err, ErrNoSuchBucket) {
+				continue
+			}
+			return err
+		}
+		g.log.Print(LogInfo, "LIFECYCLE EXPIRED:", bucket, item.Key)
+	}
+
+	return nil
+}
@@ -22,6 +22,11 @@ import (
 	"golang.org/x/tools/cover"
 )
 
+// cmdDir contains the gofakes3 command, which is a module of its own so that
+// the dependencies of the backends it supports aren't forced on users of the
+// gofakes3 package.
+const cmdDir = "./cmd/gofakes3"
+
 func main() {
 	if err := run(); err != nil {
 		log.Fatal(err)
@@ -57,9 +62,10 @@ func runBuildDocker() error {
 	cmd := command(
 		"go", "build", "-a",
 		"-installsuffix", "cgo",
-		"-o", "./build/main",
-		"./cmd/gofakes3",
+		"-o", "../../build/main",
+		".",
 	)
+	cmd.Dir = cmdDir
 	cmd.Env = append(cmd.Env, "GO111MODULE=on", "CGO_ENABLED=0", "GOOS=linux")
 	if err := cmd.Run(); err != nil {
 		return err
@@ -107,9 +113,10 @@ func runBuildRelease() error {
 	for suffix, build := range builds {
 		cmd := command(
 			"go", "build",
-			"-o", fmt.Sprintf("./build/gofakes3_%s", suffix),
-			"./cmd/gofakes3",
+			"-o", fmt.Sprintf("../../build/gofakes3_%s", suffix),
+			".",
 		)
+		cmd.Dir = cmdDir
 		cmd.Env = append(cmd.Env, "GO111MODULE=on")
 		cmd.Env = append(cmd.Env, build...)
 		if err := cmd.Run(); err != nil {
@@ -3,9 +3,11 @@ package gofakes3
 import (
 	"encoding/xml"
 	"fmt"
+	"net/url"
 	"sort"
 	"strings"
 	"time"
+	"unicode/utf8"
 )
 
 type Storage struct {
@@ -13,6 +15,11 @@ type Storage struct {
 	Xmlns   string    `xml:"xmlns,attr"`
 	Owner   *UserInfo `xml:"Owner,omitempty"`
 	Buckets Buckets   `xml:"Buckets>Bucket"`
+
+	// ContinuationToken is returned if there are more buckets than
+	// max-buckets, for the request for the next page.
+	ContinuationToken string `xml:"ContinuationToken,omitempty"`
+	Prefix            string `xml:"Prefix,omitempty"`
 }
 
 type UserInfo struct {
@@ -47,26 +54,42 @@ type CommonPrefix struct {
 	Prefix string `xml:"Prefix"`
 }
 
+// encodingTypeURL is the only value of the encoding-type parameter accepted
+// by the listing operations.
+const encodingTypeURL = "url"
+
+// urlEncodeKey encodes a key, prefix or delimiter for a listing requested with
+// encoding-type=url. S3 encodes them as it would a query string, but leaves
+// the '/' separator alone, so url.QueryUnescape() recovers the original.
+func urlEncodeKey(s string) string {
+	return strings.Replace(url.QueryEscape(s), "%2F", "/", -1)
+}
+
+func urlEncodeCommonPrefixes(prefixes []CommonPrefix) {
+	for i := range prefixes {
+		prefixes[i].Prefix = urlEncodeKey(prefixes[i].Prefix)
+	}
+}
+
 type CompletedPart struct {
 	PartNumber int    `xml:"PartNumber"`
 	ETag       string `xml:"ETag"`
+	Checksums
 }
 
 type CompleteMultipartUploadRequest struct {
 	Parts []CompletedPart `xml:"Part"`
 }
 
+// partsAreSorted reports whether the part numbers are in strictly ascending
+// order, as S3 requires; a part may not be listed twice.
 func (c CompleteMultipartUploadRequest) partsAreSorted() bool {
-	return sort.IntsAreSorted(c.partIDs())
-}
-
-func (c CompleteMultipartUploadRequest) partIDs() []int {
-	inParts := make([]int, 0, len(c.Parts))
-	for _, inputPart := range c.Parts {
-		inParts = append(inParts, inputPart.PartNumber)
+	for i := 1; i < len(c.Parts); i++ {
+		if c.Parts[i].PartNumber <= c.Parts[i-1].PartNumber {
+			return false
+		}
 	}
-	sort.Ints(inParts)
-	return inParts
+	return true
 }
 
 type CompleteMultipartUploadResult struct {
@@ -74,6 +97,7 @@ type CompleteMultipartUploadResult struct {
 	Bucket   string `xml:"Bucket"`
 	Key      string `xml:"Key"`
 	ETag     string `xml:"ETag"`
+	Checksums
 }
 
 type Content struct {
@@ -137,32 +161,40 @@ func (d MultiDeleteResult) AsError() error {
 type ErrorResult struct {
 	XMLName   xml.Name  `xml:"Error"`
 	Key       string    `xml:"Key,omitempty"`
+	VersionID string    `xml:"VersionId,omitempty"`
 	Code      ErrorCode `xml:"Code,omitempty"`
 	Message   string    `xml:"Message,omitempty"`
 	Resource  string    `xml:"Resource,omitempty"`
 	RequestID string    `xml:"RequestId,omitempty"`
 }
 
-func ErrorResultFromError(err error) ErrorResult {
+// ErrorResultFromError converts err to an ErrorResult. If err does not carry
+// a message, the default message for its code is used.
+func ErrorResultFromError(err error) (result ErrorResult) {
 	switch err := err.(type) {
 	case *resourceErrorResponse:
-		return ErrorResult{
+		result = ErrorResult{
 			Resource:  err.Resource,
 			RequestID: err.RequestID,
 			Message:   err.Message,
 			Code:      err.Code,
 		}
 	case *ErrorResponse:
-		return ErrorResult{
+		result = ErrorResult{
 			RequestID: err.RequestID,
 			Message:   err.Message,
 			Code:      err.Code,
 		}
 	case Error:
-		return ErrorResult{Code: err.ErrorCode()}
+		result = ErrorResult{Code: err.ErrorCode()}
 	default:
-		return ErrorResult{Code: ErrInternal}
+		result = ErrorResult{Code: ErrInternal}
+	}
+
+	if result.Message == "" {
+		result.Message = result.Code.Message()
 	}
+	return result
 }
 
 func (er ErrorResult) String() string {
@@ -201,10 +233,30 @@ type ListBucketResultBase struct {
 
 	MaxKeys int64 `xml:"MaxKeys,omitempty"`
 
+	// EncodingType is 'url' if the request asked for the keys, prefixes and
+	// delimiter in the response to be URL encoded.
+	EncodingType string `xml:"EncodingType,omitempty"`
+
 	CommonPrefixes []CommonPrefix `xml:"CommonPrefixes,omitempty"`
 	Contents       []*Content     `xml:"Contents"`
 }
 
+func (b *ListBucketResultBase) urlEncode() {
+	b.EncodingType = encodingTypeURL
+	b.Delimiter = urlEncodeKey(b.Delimiter)
+	b.Prefix = urlEncodeKey(b.Prefix)
+	urlEncodeCommonPrefixes(b.CommonPrefixes)
+	for _, v := range b.Contents {
+		v.Key = urlEncodeKey(v.Key)
+	}
+}
+
+// CreateBucketConfiguration is the optional body of a CreateBucket request.
+type CreateBucketConfiguration struct {
+	XMLName            xml.Name `xml:"CreateBucketConfiguration"`
+	LocationConstraint string   `xml:"LocationConstraint"`
+}
+
 type GetBucketLocation struct {
 	XMLName            xml.Name `xml:"LocationConstraint"`
 	Xmlns              string   `xml:"xmlns,attr"`
@@ -230,6 +282,12 @@ type ListBucketResult struct {
 	NextMarker string `xml:"NextMarker,omitempty"`
 }
 
+func (b *ListBucketResult) urlEncode() {
+	b.ListBucketResultBase.urlEncode()
+	b.Marker = urlEncodeKey(b.Marker)
+	b.NextMarker = urlEncodeKey(b.NextMarker)
+}
+
 type ListBucketResultV2 struct {
 	ListBucketResultBase
 
@@ -238,8 +296,8 @@ type ListBucketResultV2 struct {
 	ContinuationToken string `xml:"ContinuationToken,omitempty"`
 
 	// Returns the number of keys included in the response. The value is always
-	// less than or equal to the MaxKeys value.
-	KeyCount int64 `xml:"KeyCount,omitempty"`
+	// less than or equal to the MaxKeys value. This includes CommonPrefixes.
+	KeyCount int64 `xml:"KeyCount"`
 
 	// If the response is truncated, Amazon S3 returns this parameter with a
 	// continuation token. You can specify the token as the continuation-token
@@ -250,6 +308,11 @@ type ListBucketResultV2 struct {
 	StartAfter string `xml:"StartAfter,omitempty"`
 }
 
+func (b *ListBucketResultV2) urlEncode() {
+	b.ListBucketResultBase.urlEncode()
+	b.StartAfter = urlEncodeKey(b.StartAfter)
+}
+
 type DeleteMarker struct {
 	XMLName      xml.Name    `xml:"DeleteMarker"`
 	Key          string      `xml:"Key"`
@@ -265,14 +328,12 @@ func (d DeleteMarker) GetVersionID() VersionID   { return d.VersionID }
 func (d *DeleteMarker) setVersionID(i VersionID) { d.VersionID = i }
 
 type Version struct {
-	XMLName      xml.Name    `xml:"Version"`
-	Key          string      `xml:"Key"`
-	VersionID    VersionID   `xml:"VersionId"`
-	IsLatest     bool        `xml:"IsLatest"`
-	LastModified ContentTime `xml:"LastModified,omitempty"`
-	Size         int64       `xml:"Size"`
-
-	// According to the S3 docs, this is always STANDARD for a Version:
+	XMLName      xml.Name     `xml:"Version"`
+	Key          string       `xml:"Key"`
+	VersionID    VersionID    `xml:"VersionId"`
+	IsLatest     bool         `xml:"IsLatest"`
+	LastModified ContentTime  `xml:"LastModified,omitempty"`
+	Size         int64        `xml:"Size"`
 	StorageClass StorageClass `xml:"StorageClass"`
 
 	ETag  string    `xml:"ETag"`
@@ -298,6 +359,7 @@ type ListBucketVersionsResult struct {
 	CommonPrefixes []CommonPrefix `xml:"CommonPrefixes,omitempty"`
 	IsTruncated    bool           `xml:"IsTruncated"`
 	MaxKeys        int64          `xml:"MaxKeys"`
+	EncodingType   string         `xml:"EncodingType,omitempty"`
 
 	// Marks the last Key returned in a truncated response.
 	KeyMarker string `xml:"KeyMarker,omitempty"`
@@ -355,6 +417,28 @@ func NewListBucketVersionsResult(
 	return result
 }
 
+func (b *ListBucketVersionsResult) urlEncode() {
+	b.EncodingType = encodingTypeURL
+	b.Delimiter = urlEncodeKey(b.Delimiter)
+	b.Prefix = urlEncodeKey(b.Prefix)
+	b.KeyMarker = urlEncodeKey(b.KeyMarker)
+	b.NextKeyMarker = urlEncodeKey(b.NextKeyMarker)
+	urlEncodeCommonPrefixes(b.CommonPrefixes)
+	for _, v := range b.Versions {
+		switch v := v.(type) {
+		case *Version:
+			v.Key = urlEncodeKey(v.Key)
+		case *DeleteMarker:
+			v.Key = urlEncodeKey(v.Key)
+		}
+	}
+}
+
+// HasPrefix reports whether the prefix has been added with AddPrefix.
+func (b *ListBucketVersionsResult) HasPrefix(prefix string) bool {
+	return b.prefixes[prefix]
+}
+
 func (b *ListBucketVersionsResult) AddPrefix(prefix string) {
 	if b.prefixes == nil {
 		b.prefixes = map[string]bool{}
@@ -391,12 +475,26 @@ type ListMultipartUploadsResult struct {
 	// prefix.
 	Prefix string `xml:"Prefix,omitempty"`
 
+	EncodingType string `xml:"EncodingType,omitempty"`
+
 	CommonPrefixes []CommonPrefix `xml:"CommonPrefixes,omitempty"`
 	IsTruncated    bool           `xml:"IsTruncated,omitempty"`
 
 	Uploads []ListMultipartUploadItem `xml:"Upload"`
 }
 
+func (b *ListMultipartUploadsResult) urlEncode() {
+	b.EncodingType = encodingTypeURL
+	b.Delimiter = urlEncodeKey(b.Delimiter)
+	b.Prefix = urlEncodeKey(b.Prefix)
+	b.KeyMarker = urlEncodeKey(b.KeyMarker)
+	b.NextKeyMarker = urlEncodeKey(b.NextKeyMarker)
+	urlEncodeCommonPrefixes(b.CommonPrefixes)
+	for i := range b.Uploads {
+		b.Uploads[i].Key = urlEncodeKey(b.Uploads[i].Key)
+	}
+}
+
 type ListMultipartUploadItem struct {
 	Key          string       `xml:"Key"`
 	UploadID     UploadID     `xml:"UploadId"`
@@ -428,6 +526,38 @@ type ListMultipartUploadPartItem struct {
 	LastModified ContentTime `xml:"LastModified,omitempty"`
 	ETag         string      `xml:"ETag,omitempty"`
 	Size         int64       `xml:"Size"`
+	Checksums
+}
+
+// GetObjectAttributesResult contains the attributes requested in the
+// x-amz-object-attributes header of a GetObjectAttributes request; the others
+// are left empty.
+type GetObjectAttributesResult struct {
+	XMLName      xml.Name               `xml:"GetObjectAttributesResponse"`
+	ETag         string                 `xml:"ETag,omitempty"`
+	Checksum     *Checksums             `xml:"Checksum,omitempty"`
+	ObjectParts  *ObjectAttributesParts `xml:"ObjectParts,omitempty"`
+	StorageClass StorageClass           `xml:"StorageClass,omitempty"`
+	ObjectSize   *int64                 `xml:"ObjectSize,omitempty"`
+}
+
+// ObjectAttributesParts lists the parts of an object created by
+// CompleteMultipartUpload.
+type ObjectAttributesParts struct {
+	PartsCount           int                    `xml:"PartsCount"`
+	PartNumberMarker     int                    `xml:"PartNumberMarker"`
+	NextPartNumberMarker int                    `xml:"NextPartNumberMarker"`
+	MaxParts             int64                  `xml:"MaxParts"`
+	IsTruncated          bool                   `xml:"IsTruncated"`
+	Parts                []ObjectAttributesPart `xml:"Part"`
+}
+
+// ObjectAttributesPart is a part in ObjectAttributesParts. Only the checksum
+// of the algorithm used by the upload is set.
+type ObjectAttributesPart struct {
+	PartNumber int   `xml:"PartNumber"`
+	Size       int64 `xml:"Size"`
+	Checksums
 }
 
 // CopyObjectResult contains the response from a CopyObject operation.
@@ -437,6 +567,13 @@ type CopyObjectResult struct {
 	LastModified ContentTime `xml:"LastModified,omitempty"`
 }
 
+// CopyPartResult contains the response from an UploadPartCopy operation.
+type CopyPartResult struct {
+	XMLName      xml.Name    `xml:"CopyPartResult"`
+	ETag         string      `xml:"ETag,omitempty"`
+	LastModified ContentTime `xml:"LastModified,omitempty"`
+}
+
 // MFADeleteStatus is used by VersioningConfiguration.
 type MFADeleteStatus string
 
@@ -465,11 +602,589 @@ const (
 	MFADeleteDisabled MFADeleteStatus = "Disabled"
 )
 
-type ObjectID struct {
+// WebsiteConfiguration is used by the PutBucketWebsite and GetBucketWebsite
+// operations.
+//
+// https://docs.aws.amazon.com/AmazonS3/latest/API/API_WebsiteConfiguration.html
+type WebsiteConfiguration struct {
+	XMLName xml.Name `xml:"WebsiteConfiguration"`
+	Xmlns   string   `xml:"xmlns,attr,omitempty"`
+
+	RedirectAllRequestsTo *WebsiteRedirectAllRequestsTo `xml:"RedirectAllRequestsTo,omitempty"`
+	IndexDocument         *WebsiteIndexDocument         `xml:"IndexDocument,omitempty"`
+	ErrorDocument         *WebsiteErrorDocument         `xml:"ErrorDocument,omitempty"`
+	RoutingRules          []WebsiteRoutingRule          `xml:"RoutingRules>RoutingRule,omitempty"`
+}
+
+type WebsiteIndexDocument struct {
+	Suffix string `xml:"Suffix"`
+}
+
+type WebsiteErrorDocument struct {
 	Key string `xml:"Key"`
+}
+
+type WebsiteRedirectAllRequestsTo struct {
+	HostName string `xml:"HostName"`
+	Protocol string `xml:"Protocol,omitempty"`
+}
+
+type WebsiteRoutingRule struct {
+	Condition *WebsiteRoutingRuleCondition `xml:"Condition,omitempty"`
+	Redirect  WebsiteRedirect              `xml:"Redirect"`
+}
+
+type WebsiteRoutingRuleCondition struct {
+	HTTPErrorCodeReturnedEquals string `xml:"HttpErrorCodeReturnedEquals,omitempty"`
+	KeyPrefixEquals             string `xml:"KeyPrefixEquals,omitempty"`
+}
+
+type WebsiteRedirect struct {
+	HostName             string `xml:"HostName,omitempty"`
+	HTTPRedirectCode     string `xml:"HttpRedirectCode,omitempty"`
+	Protocol             string `xml:"Protocol,omitempty"`
+	ReplaceKeyPrefixWith string `xml:"ReplaceKeyPrefixWith,omitempty"`
+	ReplaceKeyWith       string `xml:"ReplaceKeyWith,omitempty"`
+}
+
+// Payer is the party that pays for requests to a bucket, and for the data
+// they transfer.
+type Payer string
+
+const (
+	PayerBucketOwner Payer = "BucketOwner"
+	PayerRequester   Payer = "Requester"
+)
+
+func (p Payer) valid() bool {
+	return p == PayerBucketOwner || p == PayerRequester
+}
+
+// RequestPaymentConfiguration is used by the PutBucketRequestPayment and
+// GetBucketRequestPayment operations.
+//
+// https://docs.aws.amazon.com/AmazonS3/latest/API/API_RequestPaymentConfiguration.html
+type RequestPaymentConfiguration struct {
+	XMLName xml.Name `xml:"RequestPaymentConfiguration"`
+	Xmlns   string   `xml:"xmlns,attr,omitempty"`
+
+	Payer Payer `xml:"Payer"`
+}
+
+// AccelerateConfiguration is used by the PutBucketAccelerateConfiguration and
+// GetBucketAccelerateConfiguration operations. GoFakeS3 stores it, but has no
+// accelerate endpoint.
+//
+// https://docs.aws.amazon.com/AmazonS3/latest/API/API_AccelerateConfiguration.html
+type AccelerateConfiguration struct {
+	XMLName xml.Name `xml:"AccelerateConfiguration"`
+	Xmlns   string   `xml:"xmlns,attr,omitempty"`
+
+	// Status is either "Enabled" or "Suspended", or empty if acceleration has
+	// never been configured.
+	Status string `xml:"Status,omitempty"`
+}
+
+// BucketLoggingStatus is used by the PutBucketLogging and GetBucketLogging
+// operations. GoFakeS3 stores it, but does not deliver access logs.
+//
+// https://docs.aws.amazon.com/AmazonS3/latest/API/API_BucketLoggingStatus.html
+type BucketLoggingStatus struct {
+	XMLName xml.Name `xml:"BucketLoggingStatus"`
+	Xmlns   string   `xml:"xmlns,attr,omitempty"`
+
+	// LoggingEnabled is nil if logging is disabled.
+	LoggingEnabled *LoggingEnabled `xml:"LoggingEnabled,omitempty"`
+}
+
+type LoggingEnabled struct {
+	TargetBucket string  `xml:"TargetBucket"`
+	TargetPrefix string  `xml:"TargetPrefix"`
+	TargetGrants []Grant `xml:"TargetGrants>Grant,omitempty"`
+}
+
+// AnalyticsConfiguration is used by the PutBucketAnalyticsConfiguration and
+// GetBucketAnalyticsConfiguration operations. A bucket may have several,
+// identified by their ID. GoFakeS3 stores them, but does not analyse or
+// export anything.
+//
+// https://docs.aws.amazon.com/AmazonS3/latest/API/API_AnalyticsConfiguration.html
+type AnalyticsConfiguration struct {
+	XMLName xml.Name `xml:"AnalyticsConfiguration"`
+	Xmlns   string   `xml:"xmlns,attr,omitempty"`
+
+	ID                   string               `xml:"Id"`
+	Filter               *AnalyticsFilter     `xml:"Filter,omitempty"`
+	StorageClassAnalysis StorageClassAnalysis `xml:"StorageClassAnalysis"`
+}
+
+// AnalyticsFilter holds at most one of its fields.
+type AnalyticsFilter struct {
+	Prefix *string               `xml:"Prefix,omitempty"`
+	Tag    *Tag                  `xml:"Tag,omitempty"`
+	And    *AnalyticsAndOperator `xml:"And,omitempty"`
+}
+
+type AnalyticsAndOperator struct {
+	Prefix string `xml:"Prefix,omitempty"`
+	Tags   []Tag  `xml:"Tag,omitempty"`
+}
+
+type StorageClassAnalysis struct {
+	DataExport *StorageClassAnalysisDataExport `xml:"DataExport,omitempty"`
+}
+
+type StorageClassAnalysisDataExport struct {
+	// OutputSchemaVersion is always "V_1".
+	OutputSchemaVersion string                     `xml:"OutputSchemaVersion"`
+	Destination         AnalyticsExportDestination `xml:"Destination"`
+}
+
+type AnalyticsExportDestination struct {
+	S3BucketDestination AnalyticsS3BucketDestination `xml:"S3BucketDestination"`
+}
+
+type AnalyticsS3BucketDestination struct {
+	Bucket          string `xml:"Bucket"`
+	BucketAccountID string `xml:"BucketAccountId,omitempty"`
+
+	// Format is always "CSV".
+	Format string `xml:"Format"`
+	Prefix string `xml:"Prefix,omitempty"`
+}
+
+// InventoryConfiguration is used by the PutBucketInventoryConfiguration and
+// GetBucketInventoryConfiguration operations. A bucket may have several,
+// identified by their ID. GoFakeS3 stores them, but does not produce any
+// inventory reports.
+//
+// https://docs.aws.amazon.com/AmazonS3/latest/API/API_InventoryConfiguration.html
+type InventoryConfiguration struct {
+	XMLName xml.Name `xml:"InventoryConfiguration"`
+	Xmlns   string   `xml:"xmlns,attr,omitempty"`
+
+	ID                     string               `xml:"Id"`
+	IsEnabled              bool                 `xml:"IsEnabled"`
+	Filter                 *InventoryFilter     `xml:"Filter,omitempty"`
+	Destination            InventoryDestination `xml:"Destination"`
+	Schedule               InventorySchedule    `xml:"Schedule"`
+	IncludedObjectVersions string               `xml:"IncludedObjectVersions"`
+	OptionalFields         []string             `xml:"OptionalFields>Field,omitempty"`
+}
+
+type InventoryFilter struct {
+	Prefix string `xml:"Prefix"`
+}
+
+type InventoryDestination struct {
+	S3BucketDestination InventoryS3BucketDestination `xml:"S3BucketDestination"`
+}
+
+type InventoryS3BucketDestination struct {
+	AccountID  string               `xml:"AccountId,omitempty"`
+	Bucket     string               `xml:"Bucket"`
+	Format     string               `xml:"Format"`
+	Prefix     string               `xml:"Prefix,omitempty"`
+	Encryption *InventoryEncryption `xml:"Encryption,omitempty"`
+}
+
+// InventoryEncryption selects how inventory reports are encrypted. Only one
+// of its fields may be set.
+type InventoryEncryption struct {
+	SSES3  *struct{}        `xml:"SSE-S3,omitempty"`
+	SSEKMS *InventorySSEKMS `xml:"SSE-KMS,omitempty"`
+}
+
+type InventorySSEKMS struct {
+	KeyID string `xml:"KeyId"`
+}
+
+type InventorySchedule struct {
+	// Frequency is either "Daily" or "Weekly".
+	Frequency string `xml:"Frequency"`
+}
+
+// MetricsConfiguration is used by the PutBucketMetricsConfiguration and
+// GetBucketMetricsConfiguration operations. A bucket may have several,
+// identified by their ID. GoFakeS3 stores them, but does not publish any
+// metrics.
+//
+// https://docs.aws.amazon.com/AmazonS3/latest/API/API_MetricsConfiguration.html
+type MetricsConfiguration struct {
+	XMLName xml.Name `xml:"MetricsConfiguration"`
+	Xmlns   string   `xml:"xmlns,attr,omitempty"`
+
+	ID     string         `xml:"Id"`
+	Filter *MetricsFilter `xml:"Filter,omitempty"`
+}
+
+// MetricsFilter holds at most one of its fields.
+type MetricsFilter struct {
+	Prefix         *string             `xml:"Prefix,omitempty"`
+	Tag            *Tag                `xml:"Tag,omitempty"`
+	AccessPointARN *string             `xml:"AccessPointArn,omitempty"`
+	And            *MetricsAndOperator `xml:"And,omitempty"`
+}
+
+type MetricsAndOperator struct {
+	Prefix         string `xml:"Prefix,omitempty"`
+	Tags           []Tag  `xml:"Tag,omitempty"`
+	AccessPointARN string `xml:"AccessPointArn,omitempty"`
+}
+
+// OwnershipControls is used by the PutBucketOwnershipControls and
+// GetBucketOwnershipControls operations. It holds a single rule.
+//
+// https://docs.aws.amazon.com/AmazonS3/latest/API/API_OwnershipControls.html
+type OwnershipControls struct {
+	XMLName xml.Name                `xml:"OwnershipControls"`
+	Xmlns   string                  `xml:"xmlns,attr,omitempty"`
+	Rules   []OwnershipControlsRule `xml:"Rule"`
+}
+
+type OwnershipControlsRule struct {
+	ObjectOwnership ObjectOwnership `xml:"ObjectOwnership"`
+}
+
+// PublicAccessBlockConfiguration is used by the PutPublicAccessBlock and
+// GetPublicAccessBlock operations. Unlike the other bucket configurations,
+// GoFakeS3 acts on it; see WithAuthentication for how anonymous requests are
+// authorized.
+//
+// https://docs.aws.amazon.com/AmazonS3/latest/API/API_PublicAccessBlockConfiguration.html
+type PublicAccessBlockConfiguration struct {
+	XMLName xml.Name `xml:"PublicAccessBlockConfiguration"`
+	Xmlns   string   `xml:"xmlns,attr,omitempty"`
+
+	// BlockPublicACLs rejects requests that grant AllUsers or
+	// AuthenticatedUsers access to the bucket or its objects with an ACL.
+	BlockPublicACLs bool `xml:"BlockPublicAcls"`
+
+	// IgnorePublicACLs stops the ACLs of the bucket and its objects from
+	// granting access to anonymous requests.
+	IgnorePublicACLs bool `xml:"IgnorePublicAcls"`
+
+	// BlockPublicPolicy rejects bucket policies that allow access to
+	// everyone.
+	BlockPublicPolicy bool `xml:"BlockPublicPolicy"`
+
+	// RestrictPublicBuckets stops the bucket policy from allowing access to
+	// anonymous requests. Statements that deny access still apply.
+	RestrictPublicBuckets bool `xml:"RestrictPublicBuckets"`
+}
+
+// ReplicationRuleStatus is used by ReplicationRule and its optional
+// settings.
+type ReplicationRuleStatus string
+
+const (
+	ReplicationEnabled  ReplicationRuleStatus = "Enabled"
+	ReplicationDisabled ReplicationRuleStatus = "Disabled"
+)
+
+// ReplicationConfiguration is used by the PutBucketReplication and
+// GetBucketReplication operations. GoFakeS3 does not replicate objects, but
+// reports the objects an enabled rule applies to as replicated, with the
+// x-amz-replication-status header.
+//
+// https://docs.aws.amazon.com/AmazonS3/latest/API/API_ReplicationConfiguration.html
+type ReplicationConfiguration struct {
+	XMLName xml.Name `xml:"ReplicationConfiguration"`
+	Xmlns   string   `xml:"xmlns,attr,omitempty"`
+
+	// Role is the ARN of the IAM role S3 would assume to replicate objects.
+	Role  string            `xml:"Role"`
+	Rules []ReplicationRule `xml:"Rule"`
+}
+
+type ReplicationRule struct {
+	ID       string `xml:"ID,omitempty"`
+	Priority *int   `xml:"Priority,omitempty"`
 
-	// Versions not supported in GoFakeS3 yet.
+	// Prefix is deprecated in favour of Filter; a rule can not have both.
+	Prefix *string               `xml:"Prefix,omitempty"`
+	Filter *ReplicationFilter    `xml:"Filter,omitempty"`
+	Status ReplicationRuleStatus `xml:"Status"`
+
+	Destination               ReplicationDestination          `xml:"Destination"`
+	DeleteMarkerReplication   *ReplicationStatusConfiguration `xml:"DeleteMarkerReplication,omitempty"`
+	ExistingObjectReplication *ReplicationStatusConfiguration `xml:"ExistingObjectReplication,omitempty"`
+}
+
+type ReplicationFilter struct {
+	Prefix *string                 `xml:"Prefix,omitempty"`
+	Tag    *Tag                    `xml:"Tag,omitempty"`
+	And    *ReplicationAndOperator `xml:"And,omitempty"`
+}
+
+type ReplicationAndOperator struct {
+	Prefix string `xml:"Prefix,omitempty"`
+	Tags   []Tag  `xml:"Tag,omitempty"`
+}
+
+type ReplicationDestination struct {
+	// Bucket is the ARN of the destination bucket.
+	Bucket       string       `xml:"Bucket"`
+	Account      string       `xml:"Account,omitempty"`
+	StorageClass StorageClass `xml:"StorageClass,omitempty"`
+}
+
+// ReplicationStatusConfiguration enables or disables one of the optional
+// settings of a ReplicationRule.
+type ReplicationStatusConfiguration struct {
+	Status ReplicationRuleStatus `xml:"Status"`
+}
+
+// NotificationConfiguration is used by the PutBucketNotificationConfiguration
+// and GetBucketNotificationConfiguration operations. GoFakeS3 stores it, but
+// does not send notifications; see WithEventHook for a way to observe the
+// same events.
+//
+// https://docs.aws.amazon.com/AmazonS3/latest/API/API_NotificationConfiguration.html
+type NotificationConfiguration struct {
+	XMLName xml.Name `xml:"NotificationConfiguration"`
+	Xmlns   string   `xml:"xmlns,attr,omitempty"`
+
+	TopicConfigurations          []TopicConfiguration          `xml:"TopicConfiguration,omitempty"`
+	QueueConfigurations          []QueueConfiguration          `xml:"QueueConfiguration,omitempty"`
+	LambdaFunctionConfigurations []LambdaFunctionConfiguration `xml:"CloudFunctionConfiguration,omitempty"`
+	EventBridgeConfiguration     *struct{}                     `xml:"EventBridgeConfiguration,omitempty"`
+}
+
+type TopicConfiguration struct {
+	ID       string              `xml:"Id,omitempty"`
+	TopicARN string              `xml:"Topic"`
+	Events   []string            `xml:"Event"`
+	Filter   *NotificationFilter `xml:"Filter,omitempty"`
+}
+
+type QueueConfiguration struct {
+	ID       string              `xml:"Id,omitempty"`
+	QueueARN string              `xml:"Queue"`
+	Events   []string            `xml:"Event"`
+	Filter   *NotificationFilter `xml:"Filter,omitempty"`
+}
+
+type LambdaFunctionConfiguration struct {
+	ID                string              `xml:"Id,omitempty"`
+	LambdaFunctionARN string              `xml:"CloudFunction"`
+	Events            []string            `xml:"Event"`
+	Filter            *NotificationFilter `xml:"Filter,omitempty"`
+}
+
+type NotificationFilter struct {
+	KeyFilterRules []FilterRule `xml:"S3Key>FilterRule"`
+}
+
+// FilterRule matches the prefix or suffix of an object's key, as selected by
+// Name, which is either "prefix" or "suffix".
+type FilterRule struct {
+	Name  string `xml:"Name"`
+	Value string `xml:"Value"`
+}
+
+// CORSConfiguration is used by the PutBucketCors and GetBucketCors
+// operations.
+//
+// https://docs.aws.amazon.com/AmazonS3/latest/API/API_CORSConfiguration.html
+type CORSConfiguration struct {
+	XMLName xml.Name `xml:"CORSConfiguration"`
+	Xmlns   string   `xml:"xmlns,attr,omitempty"`
+
+	Rules []CORSRule `xml:"CORSRule"`
+}
+
+type CORSRule struct {
+	ID             string   `xml:"ID,omitempty"`
+	AllowedMethods []string `xml:"AllowedMethod"`
+	AllowedOrigins []string `xml:"AllowedOrigin"`
+	AllowedHeaders []string `xml:"AllowedHeader,omitempty"`
+	ExposeHeaders  []string `xml:"ExposeHeader,omitempty"`
+	MaxAgeSeconds  int      `xml:"MaxAgeSeconds,omitempty"`
+}
+
+// LifecycleStatus is used by LifecycleRule.
+type LifecycleStatus string
+
+const (
+	LifecycleEnabled  LifecycleStatus = "Enabled"
+	LifecycleDisabled LifecycleStatus = "Disabled"
+)
+
+// LifecycleConfiguration is used by the PutBucketLifecycleConfiguration and
+// GetBucketLifecycleConfiguration operations.
+//
+// https://docs.aws.amazon.com/AmazonS3/latest/API/API_LifecycleConfiguration.html
+type LifecycleConfiguration struct {
+	XMLName xml.Name `xml:"LifecycleConfiguration"`
+	Xmlns   string   `xml:"xmlns,attr,omitempty"`
+
+	Rules []LifecycleRule `xml:"Rule"`
+}
+
+type LifecycleRule struct {
+	ID     string          `xml:"ID,omitempty"`
+	Status LifecycleStatus `xml:"Status"`
+
+	// Prefix is deprecated in favour of Filter, but is still accepted:
+	Prefix *string          `xml:"Prefix,omitempty"`
+	Filter *LifecycleFilter `xml:"Filter,omitempty"`
+
+	// Only Expiration is enforced by GoFakeS3; the other actions are stored
+	// so that the configuration can be round-tripped.
+	Expiration                     *LifecycleExpiration                     `xml:"Expiration,omitempty"`
+	Transitions                    []LifecycleTransition                    `xml:"Transition,omitempty"`
+	NoncurrentVersionExpiration    *LifecycleNoncurrentVersionExpiration    `xml:"NoncurrentVersionExpiration,omitempty"`
+	NoncurrentVersionTransitions   []LifecycleNoncurrentVersionTransition   `xml:"NoncurrentVersionTransition,omitempty"`
+	AbortIncompleteMultipartUpload *LifecycleAbortIncompleteMultipartUpload `xml:"AbortIncompleteMultipartUpload,omitempty"`
+}
+
+// LifecycleFilter contains at most one of Prefix, Tag or And.
+type LifecycleFilter struct {
+	Prefix *string               `xml:"Prefix,omitempty"`
+	Tag    *Tag                  `xml:"Tag,omitempty"`
+	And    *LifecycleAndOperator `xml:"And,omitempty"`
+}
+
+type LifecycleAndOperator struct {
+	Prefix string `xml:"Prefix,omitempty"`
+	Tags   []Tag  `xml:"Tag,omitempty"`
+}
+
+type LifecycleExpiration struct {
+	Date                      ContentTime `xml:"Date,omitempty"`
+	Days                      int         `xml:"Days,omitempty"`
+	ExpiredObjectDeleteMarker bool        `xml:"ExpiredObjectDeleteMarker,omitempty"`
+}
+
+type LifecycleTransition struct {
+	Date         ContentTime  `xml:"Date,omitempty"`
+	Days         int          `xml:"Days,omitempty"`
+	StorageClass StorageClass `xml:"StorageClass"`
+}
+
+type LifecycleNoncurrentVersionExpiration struct {
+	NoncurrentDays int `xml:"NoncurrentDays"`
+}
+
+type LifecycleNoncurrentVersionTransition struct {
+	NoncurrentDays int          `xml:"NoncurrentDays"`
+	StorageClass   StorageClass `xml:"StorageClass"`
+}
+
+type LifecycleAbortIncompleteMultipartUpload struct {
+	DaysAfterInitiation int `xml:"DaysAfterInitiation"`
+}
+
+// ObjectLockRetentionMode is used by ObjectRetention.
+type ObjectLockRetentionMode string
+
+const (
+	// In governance mode, objects can only be deleted, or have their
+	// retention shortened, by requests that send the
+	// x-amz-bypass-governance-retention header.
+	ObjectLockGovernance ObjectLockRetentionMode = "GOVERNANCE"
+
+	// In compliance mode, objects can not be deleted by anyone until the
+	// retention period has expired, and the retention can not be shortened.
+	ObjectLockCompliance ObjectLockRetentionMode = "COMPLIANCE"
+)
+
+// ObjectRetention is used by the PutObjectRetention and GetObjectRetention
+// operations.
+//
+// https://docs.aws.amazon.com/AmazonS3/latest/API/API_ObjectLockRetention.html
+type ObjectRetention struct {
+	XMLName xml.Name `xml:"Retention"`
+	Xmlns   string   `xml:"xmlns,attr,omitempty"`
+
+	Mode            ObjectLockRetentionMode `xml:"Mode"`
+	RetainUntilDate ContentTime             `xml:"RetainUntilDate"`
+}
+
+// Active reports whether the retention prevents the object from being
+// deleted at the given time.
+func (r *ObjectRetention) Active(at time.Time) bool {
+	return r != nil && r.Mode != "" && r.RetainUntilDate.After(at)
+}
+
+// RestoreRequest is the body of a RestoreObject request. Only restores of
+// archived objects are supported, not SELECT queries.
+//
+// https://docs.aws.amazon.com/AmazonS3/latest/API/API_RestoreRequest.html
+type RestoreRequest struct {
+	XMLName xml.Name `xml:"RestoreRequest"`
+	Xmlns   string   `xml:"xmlns,attr,omitempty"`
+
+	Days                 int                   `xml:"Days"`
+	GlacierJobParameters *GlacierJobParameters `xml:"GlacierJobParameters,omitempty"`
+}
+
+type GlacierJobParameters struct {
+	Tier RestoreTier `xml:"Tier"`
+}
+
+// RestoreTier is the speed at which an archived object is restored.
+type RestoreTier string
+
+const (
+	RestoreTierExpedited RestoreTier = "Expedited"
+	RestoreTierStandard  RestoreTier = "Standard"
+	RestoreTierBulk      RestoreTier = "Bulk"
+)
+
+// ObjectRestore is the state of a restore of an archived object, which is
+// stored by a RestoreBackend.
+type ObjectRestore struct {
+	Tier RestoreTier
+	Days int
+
+	// CompletesAt is when the restored copy of the object can be read.
+	CompletesAt time.Time
+
+	// ExpiresAt is when the restored copy is removed again, after which the
+	// object must be restored before it can be read.
+	ExpiresAt time.Time
+}
+
+// Ongoing reports whether the restore has yet to complete at the given time.
+func (r *ObjectRestore) Ongoing(at time.Time) bool {
+	return r != nil && at.Before(r.CompletesAt)
+}
+
+// Available reports whether the restored copy can be read at the given time.
+func (r *ObjectRestore) Available(at time.Time) bool {
+	return r != nil && !at.Before(r.CompletesAt) && at.Before(r.ExpiresAt)
+}
+
+// ObjectLockLegalHoldStatus is used by ObjectLegalHold.
+type ObjectLockLegalHoldStatus string
+
+const (
+	LegalHoldOn  ObjectLockLegalHoldStatus = "ON"
+	LegalHoldOff ObjectLockLegalHoldStatus = "OFF"
+)
+
+// ObjectLegalHold is used by the PutObjectLegalHold and GetObjectLegalHold
+// operations.
+//
+// https://docs.aws.amazon.com/AmazonS3/latest/API/API_ObjectLockLegalHold.html
+type ObjectLegalHold struct {
+	XMLName xml.Name `xml:"LegalHold"`
+	Xmlns   string   `xml:"xmlns,attr,omitempty"`
+
+	Status ObjectLockLegalHoldStatus `xml:"Status"`
+}
+
+type ObjectID struct {
+	Key       string `xml:"Key"`
 	VersionID string `xml:"VersionId,omitempty" json:"VersionId,omitempty"`
+
+	// In the Deleted entries of a MultiDeleteResult, DeleteMarker reports
+	// whether a delete marker was created, or the version deleted was a delete
+	// marker. DeleteMarkerVersionID is then the version ID of that marker.
+	DeleteMarker          bool   `xml:"DeleteMarker,omitempty" json:"-"`
+	DeleteMarkerVersionID string `xml:"DeleteMarkerVersionId,omitempty" json:"-"`
 }
 
 type StorageClass string
@@ -482,9 +1197,156 @@ func (s StorageClass) MarshalXML(e *xml.Encoder, start xml.StartElement) error {
 }
 
 const (
-	StorageStandard StorageClass = "STANDARD"
+	StorageStandard           StorageClass = "STANDARD"
+	StorageReducedRedundancy  StorageClass = "REDUCED_REDUNDANCY"
+	StorageStandardIA         StorageClass = "STANDARD_IA"
+	StorageOneZoneIA          StorageClass = "ONEZONE_IA"
+	StorageIntelligentTiering StorageClass = "INTELLIGENT_TIERING"
+	StorageGlacier            StorageClass = "GLACIER"
+	StorageGlacierIR          StorageClass = "GLACIER_IR"
+	StorageDeepArchive        StorageClass = "DEEP_ARCHIVE"
+	StorageOutposts           StorageClass = "OUTPOSTS"
+	StorageSnow               StorageClass = "SNOW"
+	StorageExpressOneZone     StorageClass = "EXPRESS_ONEZONE"
 )
 
+// Valid reports whether s is one of the storage classes S3 accepts. No
+// storage class behaves any differently in GoFakeS3; they are stored and
+// reported back, nothing more.
+func (s StorageClass) Valid() bool {
+	switch s {
+	case StorageStandard, StorageReducedRedundancy, StorageStandardIA, StorageOneZoneIA,
+		StorageIntelligentTiering, StorageGlacier, StorageGlacierIR, StorageDeepArchive,
+		StorageOutposts, StorageSnow, StorageExpressOneZone:
+		return true
+	}
+	return false
+}
+
+// AccessControlPolicy is used by the GetObjectAcl, PutObjectAcl, GetBucketAcl
+// and PutBucketAcl operations.
+// https://docs.aws.amazon.com/AmazonS3/latest/API/API_GetObjectAcl.html
+type AccessControlPolicy struct {
+	XMLName xml.Name  `xml:"AccessControlPolicy"`
+	Xmlns   string    `xml:"xmlns,attr,omitempty"`
+	Owner   *UserInfo `xml:"Owner,omitempty"`
+	Grants  []Grant   `xml:"AccessControlList>Grant"`
+}
+
+type Grant struct {
+	Grantee    Grantee    `xml:"Grantee"`
+	Permission Permission `xml:"Permission"`
+}
+
+// Grantee is the user or group a Grant applies to. Type is one of
+// GranteeCanonicalUser, GranteeAmazonCustomerByEmail or GranteeGroup, which
+// identify the grantee by ID, EmailAddress and URI respectively.
+type Grantee struct {
+	Type         string `xml:"-"`
+	ID           string `xml:"ID,omitempty"`
+	DisplayName  string `xml:"DisplayName,omitempty"`
+	EmailAddress string `xml:"EmailAddress,omitempty"`
+	URI          string `xml:"URI,omitempty"`
+}
+
+const xsiNamespace = "http://www.w3.org/2001/XMLSchema-instance"
+
+// MarshalXML writes the type as an xsi:type attribute. encoding/xml would
+// invent its own prefix for the namespace, which S3 clients may not accept.
+func (g Grantee) MarshalXML(e *xml.Encoder, start xml.StartElement) error {
+	start.Attr = append(start.Attr,
+		xml.Attr{Name: xml.Name{Local: "xmlns:xsi"}, Value: xsiNamespace},
+		xml.Attr{Name: xml.Name{Local: "xsi:type"}, Value: g.Type})
+	type grantee Grantee
+	return e.EncodeElement(grantee(g), start)
+}
+
+// UnmarshalXML reads the xsi:type attribute, whether or not the client
+// declared the namespace.
+func (g *Grantee) UnmarshalXML(d *xml.Decoder, start xml.StartElement) error {
+	type grantee Grantee
+	var v grantee
+	if err := d.DecodeElement(&v, &start); err != nil {
+		return err
+	}
+	*g = Grantee(v)
+	for _, attr := range start.Attr {
+		if attr.Name.Local == "type" {
+			g.Type = attr.Value
+		}
+	}
+	return nil
+}
+
+// Tag is a single key/value pair in a Tagging request or response.
+type Tag struct {
+	Key   string `xml:"Key"`
+	Value string `xml:"Value"`
+}
+
+// Tagging is used by the PutObjectTagging and GetObjectTagging operations,
+// and their bucket equivalents.
+// https://docs.aws.amazon.com/AmazonS3/latest/API/API_GetObjectTagging.html
+type Tagging struct {
+	XMLName xml.Name `xml:"Tagging"`
+	Xmlns   string   `xml:"xmlns,attr"`
+	TagSet  []Tag    `xml:"TagSet>Tag"`
+}
+
+// NewTagging creates a Tagging response from the map of tags returned by a
+// TaggingBackend or BucketTaggingBackend. Tags are sorted by key so the output is deterministic.
+func NewTagging(tags map[string]string) *Tagging {
+	keys := make([]string, 0, len(tags))
+	for k := range tags {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	tagging := &Tagging{
+		Xmlns:  "http://s3.amazonaws.com/doc/2006-03-01/",
+		TagSet: make([]Tag, 0, len(keys)),
+	}
+	for _, k := range keys {
+		tagging.TagSet = append(tagging.TagSet, Tag{Key: k, Value: tags[k]})
+	}
+	return tagging
+}
+
+// Tags validates the TagSet and converts it into a map suitable for passing
+// to a TaggingBackend.
+func (t *Tagging) Tags() (map[string]string, error) {
+	if len(t.TagSet) > MaxObjectTags {
+		return nil, ErrorMessagef(ErrInvalidTag, "Object tags cannot be greater than %d", MaxObjectTags)
+	}
+	return t.tags()
+}
+
+// BucketTags validates the TagSet and converts it into a map suitable for
+// passing to a BucketTaggingBackend.
+func (t *Tagging) BucketTags() (map[string]string, error) {
+	if len(t.TagSet) > MaxBucketTags {
+		return nil, ErrorMessagef(ErrInvalidTag, "Bucket tag count cannot be greater than %d", MaxBucketTags)
+	}
+	return t.tags()
+}
+
+func (t *Tagging) tags() (map[string]string, error) {
+	tags := make(map[string]string, len(t.TagSet))
+	for _, tag := range t.TagSet {
+		if len(tag.Key) == 0 || utf8.RuneCountInString(tag.Key) > MaxObjectTagKeyLen {
+			return nil, ErrorMessage(ErrInvalidTag, "The TagKey you have provided is invalid")
+		}
+		if utf8.RuneCountInString(tag.Value) > MaxObjectTagValueLen {
+			return nil, ErrorMessage(ErrInvalidTag, "The TagValue you have provided is invalid")
+		}
+		if _, ok := tags[tag.Key]; ok {
+			return nil, ErrorMessage(ErrInvalidTag, "Cannot provide multiple Tags with the same key")
+		}
+		tags[tag.Key] = tag.Value
+	}
+	return tags, nil
+}
+
 // UploadID uses a string as the underlying type, but the string should only
 // represent a decimal integer. See uploader.uploadID for details.
 type UploadID string
@@ -493,13 +1355,16 @@ type VersionID string
 
 type VersioningConfiguration struct {
 	XMLName xml.Name `xml:"VersioningConfiguration"`
+	Xmlns   string   `xml:"xmlns,attr,omitempty"`
 
-	Status VersioningStatus `xml:"Status"`
+	// Status is empty if versioning has never been configured for the
+	// bucket, in which case S3 leaves out the element.
+	Status VersioningStatus `xml:"Status,omitempty"`
 
 	// When enabled, the bucket owner must include the x-amz-mfa request header
 	// in requests to change the versioning state of a bucket and to
 	// permanently delete a versioned object.
-	MFADelete MFADeleteStatus `xml:"MfaDelete"`
+	MFADelete MFADeleteStatus `xml:"MfaDelete,omitempty"`
 }
 
 func (v *VersioningConfiguration) Enabled() bool {
@@ -74,14 +74,14 @@ func TestContentTimeOmitEmpty(t *testing.T) {
 func TestErrorResultFromError(t *testing.T) {
 	t.Run("any-old-junk", func(t *testing.T) {
 		er := ErrorResultFromError(io.EOF)
-		if er.Code != ErrInternal {
+		if er.Code != ErrInternal || er.Message != ErrInternal.Message() {
 			t.Fatal()
 		}
 	})
 
 	t.Run("direct-code", func(t *testing.T) {
 		er := ErrorResultFromError(ErrBadDigest)
-		if er.Code != ErrBadDigest {
+		if er.Code != ErrBadDigest || er.Message != ErrBadDigest.Message() {
 			t.Fatal()
 		}
 	})
@@ -154,3 +154,28 @@ func TestCopyObjectResult(t *testing.T) {
 		t.Fatalf("unexpected XML output: %s", string(out))
 	}
 }
+
+func TestGranteeXML(t *testing.T) {
+	grantee := Grantee{Type: GranteeGroup, URI: GroupAllUsers}
+	out, err := xml.Marshal(grantee)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := `<Grantee xmlns:xsi="http://www.w3.org/2001/XMLSchema-instance" xsi:type="Group"><URI>` + GroupAllUsers + `</URI></Grantee>`
+	if string(out) != expected {
+		t.Fatal("unexpected XML", string(out))
+	}
+
+	for _, in := range []string{
+		expected,
+		`<Grantee xsi:type="Group"><URI>` + GroupAllUsers + `</URI></Grantee>`, // Undeclared namespace
+	} {
+		var found Grantee
+		if err := xml.Unmarshal([]byte(in), &found); err != nil {
+			t.Fatal(err)
+		}
+		if found != grantee {
+			t.Fatal("unexpected grantee", found)
+		}
+	}
+}
@@ -0,0 +1,131 @@
+package gofakes3
+
+import (
+	"net/http"
+	"net/url"
+	"time"
+)
+
+// Metrics receives measurements of the requests served by GoFakeS3. Set one
+// with WithMetrics.
+//
+// GoFakeS3 does not depend on any particular metrics library; see the
+// gofakes3/prommetrics package for an implementation that exports to
+// Prometheus.
+type Metrics interface {
+	// ObserveOperation is called after each request has been handled.
+	// operation is the name of the S3 API action, such as "PutObject", or
+	// "Unknown" if the request did not match one. code is ErrNone if the
+	// request succeeded, otherwise it is the code of the error returned to
+	// the client.
+	ObserveOperation(operation string, code ErrorCode, duration time.Duration)
+}
+
+// BackendMetrics may be implemented by a Metrics that reports on what is
+// stored in the Backend, such as the number of buckets. GoFakeS3 passes its
+// Backend to ObserveBackend when it is created.
+type BackendMetrics interface {
+	Metrics
+	ObserveBackend(backend Backend)
+}
+
+// operationName returns the S3 API action that routeBase will dispatch the
+// request to. The conditions must be kept in the same order as routeBase.
+func operationName(r *http.Request, bucket, object string, query url.Values) string {
+	has := func(key string) bool {
+		_, ok := query[key]
+		return ok
+	}
+	byMethod := func(ops map[string]string) string {
+		if op, ok := ops[r.Method]; ok {
+			return op
+		}
+		return "Unknown"
+	}
+
+	if op, ok := unimplementedOperation(r, query); ok {
+		return op
+	}
+
+	switch {
+	case query.Get("uploadId") != "":
+		op := byMethod(map[string]string{
+			"GET": "ListParts", "PUT": "UploadPart", "DELETE": "AbortMultipartUpload", "POST": "CompleteMultipartUpload",
+		})
+		if op == "UploadPart" && r.Header.Get("x-amz-copy-source") != "" {
+			op = "UploadPartCopy"
+		}
+		return op
+	case has("uploads"):
+		return byMethod(map[string]string{"GET": "ListMultipartUploads", "POST": "CreateMultipartUpload"})
+	case has("versioning"):
+		return byMethod(map[string]string{"GET": "GetBucketVersioning", "PUT": "PutBucketVersioning"})
+	case has("versions"):
+		return byMethod(map[string]string{"GET": "ListObjectVersions"})
+	case has("website") && object == "":
+		return byMethod(map[string]string{"GET": "GetBucketWebsite", "PUT": "PutBucketWebsite", "DELETE": "DeleteBucketWebsite"})
+	case has("policy") && object == "":
+		return byMethod(map[string]string{"GET": "GetBucketPolicy", "PUT": "PutBucketPolicy", "DELETE": "DeleteBucketPolicy"})
+	case has("requestPayment") && object == "":
+		return byMethod(map[string]string{"GET": "GetBucketRequestPayment", "PUT": "PutBucketRequestPayment"})
+	case has("cors") && object == "":
+		return byMethod(map[string]string{"GET": "GetBucketCors", "PUT": "PutBucketCors", "DELETE": "DeleteBucketCors"})
+	case has("lifecycle") && object == "":
+		return byMethod(map[string]string{"GET": "GetBucketLifecycleConfiguration", "PUT": "PutBucketLifecycleConfiguration", "DELETE": "DeleteBucketLifecycle"})
+	case object == "" && idBucketConfigOperations(query) != nil:
+		if r.Method == "GET" && query.Get("id") == "" {
+			return idBucketConfigOperations(query)["LIST"]
+		}
+		return byMethod(idBucketConfigOperations(query))
+	case object == "" && bucketConfigOperations(query) != nil:
+		return byMethod(bucketConfigOperations(query))
+	case has("acl") && object == "":
+		return byMethod(map[string]string{"GET": "GetBucketAcl", "PUT": "PutBucketAcl"})
+	case has("acl") && object != "":
+		return byMethod(map[string]string{"GET": "GetObjectAcl", "PUT": "PutObjectAcl"})
+	case has("tagging") && object == "":
+		return byMethod(map[string]string{"GET": "GetBucketTagging", "PUT": "PutBucketTagging", "DELETE": "DeleteBucketTagging"})
+	case has("tagging") && object != "":
+		return byMethod(map[string]string{"GET": "GetObjectTagging", "PUT": "PutObjectTagging", "DELETE": "DeleteObjectTagging"})
+	case has("retention") && object != "":
+		return byMethod(map[string]string{"GET": "GetObjectRetention", "PUT": "PutObjectRetention"})
+	case has("legal-hold") && object != "":
+		return byMethod(map[string]string{"GET": "GetObjectLegalHold", "PUT": "PutObjectLegalHold"})
+	case has("restore") && object != "":
+		return byMethod(map[string]string{"POST": "RestoreObject"})
+	case has("attributes") && object != "":
+		return byMethod(map[string]string{"GET": "GetObjectAttributes"})
+	case versionFromQuery(query["versionId"]) != "":
+		return byMethod(map[string]string{"GET": "GetObject", "HEAD": "HeadObject", "DELETE": "DeleteObject"})
+	case bucket != "" && object != "":
+		op := byMethod(map[string]string{"GET": "GetObject", "HEAD": "HeadObject", "PUT": "PutObject", "DELETE": "DeleteObject"})
+		if op == "PutObject" && r.Header.Get("x-amz-copy-source") != "" {
+			op = "CopyObject"
+		}
+		return op
+	case bucket != "":
+		switch {
+		case r.Method == "GET" && has("location"):
+			return "GetBucketLocation"
+		case r.Method == "GET" && query.Get("list-type") == "2":
+			return "ListObjectsV2"
+		case r.Method == "POST" && has("delete"):
+			return "DeleteObjects"
+		case r.Method == "POST":
+			return "PostObject"
+		}
+		return byMethod(map[string]string{"GET": "ListObjects", "PUT": "CreateBucket", "DELETE": "DeleteBucket", "HEAD": "HeadBucket"})
+	case r.Method == "GET":
+		return "ListBuckets"
+	default:
+		return "Unknown"
+	}
+}
+
+// errorCodeOf returns the code that httpError sends to the client for err.
+func errorCodeOf(err error) ErrorCode {
+	if err == nil {
+		return ErrNone
+	}
+	return ensureErrorResponse(err, "").ErrorCode()
+}
@@ -0,0 +1,59 @@
+package gofakes3
+
+import (
+	"net/http"
+	"strings"
+)
+
+// MFAValidator reports whether token is a valid authentication code from the
+// MFA device configured with WithMFA.
+type MFAValidator func(token string) bool
+
+type mfaDevice struct {
+	serial   string
+	validate MFAValidator
+}
+
+// mfaDeleteEnabled reports whether MFA Delete is enabled in the versioning
+// configuration of the bucket.
+func (g *GoFakeS3) mfaDeleteEnabled(bucket string) (bool, error) {
+	if g.versioned == nil {
+		return false, nil
+	}
+	config, err := g.versioned.VersioningConfiguration(bucket)
+	if err != nil {
+		return false, err
+	}
+	return config.MFADelete.Enabled(), nil
+}
+
+// checkMFA returns ErrAccessDenied unless the x-amz-mfa header of the request
+// contains the serial number of the device configured with WithMFA and a
+// token it accepts, separated by a space. Without a device, every request
+// that needs MFA is denied.
+func (g *GoFakeS3) checkMFA(r *http.Request) error {
+	header := r.Header.Get("x-amz-mfa")
+	if header == "" {
+		return ErrorMessage(ErrAccessDenied, "Mfa Authentication must be used for this request")
+	}
+
+	parts := strings.Fields(header)
+	if len(parts) != 2 {
+		return ErrorInvalidArgument("x-amz-mfa", header, "The x-amz-mfa header must contain the serial number of the MFA device and a token, separated by a space")
+	}
+
+	if g.mfa == nil || parts[0] != g.mfa.serial || !g.mfa.validate(parts[1]) {
+		return ErrorMessage(ErrAccessDenied, "Invalid MFA authentication")
+	}
+	return nil
+}
+
+// checkMFADelete calls checkMFA if MFA Delete is enabled for the bucket.
+func (g *GoFakeS3) checkMFADelete(bucket string, r *http.Request) error {
+	if enabled, err := g.mfaDeleteEnabled(bucket); err != nil {
+		return err
+	} else if enabled {
+		return g.checkMFA(r)
+	}
+	return nil
+}
@@ -1,6 +1,9 @@
 package gofakes3
 
-import "time"
+import (
+	"strings"
+	"time"
+)
 
 type Option func(g *GoFakeS3)
 
@@ -8,7 +11,13 @@ type Option func(g *GoFakeS3)
 // time.Since() within GoFakeS3. This can be used to trigger time skew errors,
 // or to ensure the output of the commands is deterministic.
 //
-// See gofakes3.FixedTimeSource(), gofakes3.LocalTimeSource(tz).
+// The TimeSource dates multipart uploads, restores and lifecycle expiry, the
+// "Date" header of responses, and is used for the skew and presigned URL
+// expiry checks. Pass the same TimeSource to the Backend, which dates the
+// buckets and objects it stores. Only the durations reported to Metrics and
+// in log messages use the system clock.
+//
+// See gofakes3.FixedTimeSource().
 func WithTimeSource(timeSource TimeSource) Option {
 	return func(g *GoFakeS3) { g.timeSource = timeSource }
 }
@@ -16,7 +25,8 @@ func WithTimeSource(timeSource TimeSource) Option {
 // WithTimeSkewLimit allows you to reconfigure the allowed skew between the
 // client's clock and the server's clock. The AWS client SDKs will send the
 // "x-amz-date" header containing the time at the client, which is used to
-// calculate the skew.
+// calculate the skew; the "Date" header is used if it is missing. Requests
+// outside the limit fail with a 403 RequestTimeTooSkewed error.
 //
 // See DefaultSkewLimit for the starting value, set to '0' to disable.
 //
@@ -24,6 +34,15 @@ func WithTimeSkewLimit(skew time.Duration) Option {
 	return func(g *GoFakeS3) { g.timeSkew = skew }
 }
 
+// WithRequestTimeRequired rejects requests that have neither an "x-amz-date"
+// nor a "Date" header with a 403 AccessDenied error, as S3 does for
+// authenticated requests. Presigned URLs and CORS preflight requests are
+// still allowed. By default, requests without a date are not checked against
+// the limit set with WithTimeSkewLimit.
+func WithRequestTimeRequired(required bool) Option {
+	return func(g *GoFakeS3) { g.requireRequestTime = required }
+}
+
 // WithMetadataSizeLimit allows you to reconfigure the maximum allowed metadata
 // size.
 //
@@ -32,8 +51,8 @@ func WithMetadataSizeLimit(size int) Option {
 	return func(g *GoFakeS3) { g.metadataSizeLimit = size }
 }
 
-// WithIntegrityCheck enables or disables Content-MD5 validation when
-// putting an Object.
+// WithIntegrityCheck enables or disables Content-MD5 and 'x-amz-checksum-*'
+// validation when putting an Object or uploading a part.
 func WithIntegrityCheck(check bool) Option {
 	return func(g *GoFakeS3) { g.integrityCheck = check }
 }
@@ -50,22 +69,46 @@ func WithGlobalLog() Option {
 	return WithLogger(GlobalLog())
 }
 
-// WithRequestID sets the starting ID used to generate the "x-amz-request-id"
-// header.
-func WithRequestID(id uint64) Option {
-	return func(g *GoFakeS3) { g.requestID = id }
+// WithRequestID sets the function used to generate the "x-amz-request-id"
+// header of each request, which is also used in error responses and logs.
+// The default generates 16 random hex digits, like S3; supply a deterministic
+// generator to compare the IDs in tests.
+func WithRequestID(next func() string) Option {
+	return func(g *GoFakeS3) { g.requestID = next }
 }
 
 // WithHostBucket enables or disables bucket rewriting in the router.
 // If active, the URL 'http://mybucket.localhost/object' will be routed
 // as if the URL path was '/mybucket/object'.
 //
+// If the rest of the hostname begins with 's3-website', as in
+// 'http://mybucket.s3-website.localhost/', the request is served as if it
+// were made to the bucket's website endpoint, provided the Backend implements
+// WebsiteBackend.
+//
 // See https://docs.aws.amazon.com/AmazonS3/latest/dev/UsingBucket.html
+// and https://docs.aws.amazon.com/AmazonS3/latest/dev/WebsiteEndpoints.html
 // for details.
 func WithHostBucket(enabled bool) Option {
 	return func(g *GoFakeS3) { g.hostBucket = enabled }
 }
 
+// WithHostBucketBase enables bucket rewriting in the router, like
+// WithHostBucket, for hosts that are subdomains of the base domain, so that
+// both 'http://mybucket.s3.localhost/object' and
+// 'http://s3.localhost/mybucket/object' refer to the same object if the base
+// domain is 's3.localhost'. The port of the Host header is ignored.
+//
+// Requests made to the base domain itself, to 'localhost', to an IP address
+// or to any other host are treated as path-style requests. Everything before
+// the base domain is the bucket name, so bucket names may contain dots.
+func WithHostBucketBase(domain string) Option {
+	return func(g *GoFakeS3) {
+		g.hostBucket = true
+		g.hostBucketBase = strings.ToLower(strings.Trim(domain, "."))
+	}
+}
+
 // WithoutVersioning disables versioning on the passed backend, if it supported it.
 func WithoutVersioning() Option {
 	return func(g *GoFakeS3) { g.versioned = nil }
@@ -81,8 +124,246 @@ func WithUnimplementedPageError() Option {
 	return func(g *GoFakeS3) { g.failOnUnimplementedPage = true }
 }
 
+// WithLifecycleSweep starts a background sweeper that applies the Expiration
+// actions of each bucket's lifecycle configuration every interval, using the
+// TimeSource supplied with WithTimeSource to decide which objects have
+// expired. The sweeper runs until GoFakeS3.Close() is called.
+//
+// Use GoFakeS3.SweepLifecycle() to run a single sweep on demand instead, which
+// is usually more convenient in tests.
+func WithLifecycleSweep(interval time.Duration) Option {
+	return func(g *GoFakeS3) { g.lifecycleSweep = interval }
+}
+
+// WithMultipartExpiry starts a background sweeper that aborts multipart
+// uploads initiated more than age ago, according to the TimeSource supplied
+// with WithTimeSource, so that uploads which are never completed or aborted
+// do not keep their parts in memory. The sweeper runs until GoFakeS3.Close()
+// is called.
+//
+// Use GoFakeS3.AbortMultipartUploadsOlderThan() to abort old uploads on demand
+// instead.
+func WithMultipartExpiry(age time.Duration) Option {
+	return func(g *GoFakeS3) { g.multipartExpiry = age }
+}
+
+// WithAuthentication enables verification of AWS Signature Version 4 request
+// signatures, using the Authorization header or the query string of a
+// presigned URL. keys maps each valid access key ID to its secret.
+//
+// Requests signed with an unknown access key fail with InvalidAccessKeyId,
+// and requests with an incorrect signature fail with SignatureDoesNotMatch.
+// Unsigned requests fail with AccessDenied, unless the bucket has a policy
+// that allows the action to everyone ("Principal": "*"), or the bucket or
+// object ACL grants it to AllUsers. The bucket's PublicAccessBlockConfiguration
+// can stop either from applying.
+//
+// Presigned URLs are rejected with AccessDenied once X-Amz-Expires seconds
+// have passed since their X-Amz-Date, according to the TimeSource set with
+// WithTimeSource, plus the tolerance set with WithTimeSkewLimit.
+//
+// The signatures of the individual chunks of a streaming upload are not
+// verified.
+//
+// If this option is not used, all requests are allowed.
+func WithAuthentication(keys map[string]string) Option {
+	return func(g *GoFakeS3) {
+		g.authKeys = make(map[string]string, len(keys))
+		for k, v := range keys {
+			g.authKeys[k] = v
+		}
+	}
+}
+
+// WithMetrics reports the operation, result and duration of every request
+// to m. If m also implements BackendMetrics, it is given the Backend so it can
+// report on the stored buckets and objects.
+//
+// See the gofakes3/prommetrics package to export the metrics to Prometheus.
+func WithMetrics(m Metrics) Option {
+	return func(g *GoFakeS3) { g.metrics = m }
+}
+
+// WithFaultInjector delays requests and makes them fail as configured by cfg,
+// for testing how clients cope with a degraded S3. The faults are injected
+// once a request has been matched to an S3 API action, before it is handled,
+// so a failed request makes no change.
+//
+// Unlike FaultBackend, which affects calls to the Backend, this affects every
+// request for an action, whichever Backend methods it would call:
+//
+//	gofakes3.WithFaultInjector(gofakes3.FaultConfig{
+//		Operations: map[string]gofakes3.OperationFault{
+//			"PutObject": {Latency: time.Second, Probability: 0.1, Error: gofakes3.ErrSlowDown},
+//		},
+//	})
+func WithFaultInjector(cfg FaultConfig) Option {
+	return func(g *GoFakeS3) { g.faults = newFaultInjector(cfg) }
+}
+
+// WithMFA configures the MFA device that authenticates requests to buckets
+// with MFA Delete enabled in their versioning configuration. Those requests,
+// which delete a version of an object or change the versioning configuration,
+// must send the device's serial number and a token accepted by validator in
+// the x-amz-mfa header, or they are denied:
+//
+//	gofakes3.WithMFA("arn:aws:iam::123456789012:mfa/user", func(token string) bool {
+//		return token == "123456"
+//	})
+//
+// MFA Delete can not be enabled without a device.
+func WithMFA(serial string, validator MFAValidator) Option {
+	return func(g *GoFakeS3) { g.mfa = &mfaDevice{serial: serial, validate: validator} }
+}
+
+// WithEventHook calls fn whenever an object is created or deleted through the
+// S3 API, after the change has been made in the Backend.
+//
+// fn is called synchronously, before the response is sent to the client, so
+// events for a single connection are seen in the order the requests were
+// made. If fn panics, the panic is logged and the request continues as
+// normal.
+//
+// Changes made directly to the Backend, or by the lifecycle sweeper, are not
+// reported.
+func WithEventHook(fn func(Event)) Option {
+	return func(g *GoFakeS3) { g.eventHook = fn }
+}
+
+// WithResponseCompression gzips the body of GET object responses of at least
+// minBytes, if the client sends 'Accept-Encoding: gzip'. Compressed responses
+// are sent with 'Content-Encoding: gzip' and no Content-Length.
+//
+// Objects that were stored with a Content-Encoding are sent as they are, as
+// are empty objects and responses to Range requests, so that the byte offsets
+// refer to the stored object.
+func WithResponseCompression(minBytes int64) Option {
+	return func(g *GoFakeS3) {
+		g.compress = true
+		g.compressMinBytes = minBytes
+	}
+}
+
+// WithBandwidthLimit throttles the body of GET object responses, including
+// range and compressed responses, to about bytesPerSec, so that a client can
+// be tested against predictable transfer times. A body of n bytes takes
+// about n/bytesPerSec to send. Waiting for the next chunk is cut short if the
+// request is cancelled. Zero, the default, disables throttling.
+//
+// The limit is tracked against the TimeSource passed to WithTimeSource, as
+// well as the time spent waiting, so advancing a FixedTimeSource lets the
+// transfer catch up immediately.
+func WithBandwidthLimit(bytesPerSec int64) Option {
+	return func(g *GoFakeS3) { g.bandwidthLimit = bytesPerSec }
+}
+
+// WithObjectOwnership sets the ObjectOwnership of the buckets created by
+// CreateBucket requests that do not send an x-amz-object-ownership header.
+// The default is ObjectOwnershipBucketOwnerEnforced, which disables ACLs, as
+// it is for new buckets in S3. Pass ObjectOwnershipObjectWriter to create
+// buckets that accept ACLs, as S3 did before.
+//
+// Buckets created directly with the Backend have no ownership controls, and
+// accept ACLs, until they are set with PutBucketOwnershipControls.
+func WithObjectOwnership(ownership ObjectOwnership) Option {
+	return func(g *GoFakeS3) { g.defaultObjectOwnership = ownership }
+}
+
+// WithOwner sets the canonical user ID and display name of the owner of all
+// buckets and objects, as reported in listings and ACLs. It is also the
+// initiator and owner of every multipart upload. The default ID is
+// DefaultOwnerID.
+func WithOwner(id, displayName string) Option {
+	return func(g *GoFakeS3) { g.owner = &UserInfo{ID: id, DisplayName: displayName} }
+}
+
+// WithCORS sets a CORS configuration for the whole server, which is used in
+// place of the permissive default for every bucket that does not have a CORS
+// configuration of its own. Cross-origin requests that none of its rules
+// allow get no CORS headers, and preflight requests for them are forbidden.
+func WithCORS(config CORSConfiguration) Option {
+	return func(g *GoFakeS3) { g.corsConfig = &config }
+}
+
+// WithRegion sets the region GoFakeS3 reports for its buckets, which is
+// DefaultRegion ("us-east-1") by default. It is returned by GetBucketLocation
+// and in the x-amz-bucket-region header of HEAD bucket responses.
+//
+// CreateBucket requests fail with InvalidLocationConstraint if they contain a
+// LocationConstraint for another region. If WithRegion is not used, any
+// LocationConstraint is accepted.
+func WithRegion(region string) Option {
+	return func(g *GoFakeS3) { g.region = region }
+}
+
+// WithBucketNameValidation selects the rules used to validate the names of new
+// buckets and the keys of new objects. The default, BucketNamesStrict, rejects
+// the names S3 would reject with InvalidBucketName, and keys S3 would reject
+// with InvalidArgument or KeyTooLongError. BucketNamesRelaxed accepts the
+// legacy bucket names S3 once allowed in us-east-1, such as 'UPPER_CASE' or
+// 'a', and does not check keys.
+//
+// Some Backends have rules of their own: the s3afero backends only accept
+// bucket names that are valid under the strict rules.
+func WithBucketNameValidation(mode BucketNameValidation) Option {
+	return func(g *GoFakeS3) { g.bucketNames = mode }
+}
+
 // WithAutoBucket instructs GoFakeS3 to create buckets that don't exist on first use,
 // rather than returning ErrNoSuchBucket.
 func WithAutoBucket(enabled bool) Option {
-	return func(g *GoFakeS3) { g.autoBucket = true }
+	return func(g *GoFakeS3) { g.autoBucket = enabled }
+}
+
+// WithAutoBucketFunc is like WithAutoBucket, but only creates the buckets for
+// which create returns true, and only when an object is first written to them
+// with PutObject, CopyObject, PostObject or CreateMultipartUpload; any other
+// use of a bucket that does not exist still fails with ErrNoSuchBucket. This
+// lets tests create their fixtures on demand without hiding mistyped bucket
+// names. For example, to create only the
+// buckets starting with 'fixture-':
+//
+//	gofakes3.WithAutoBucketFunc(func(name string) bool {
+//		return strings.HasPrefix(name, "fixture-")
+//	})
+func WithAutoBucketFunc(create func(name string) bool) Option {
+	return func(g *GoFakeS3) {
+		g.autoBucket = create != nil
+		g.autoBucketFunc = create
+	}
+}
+
+// WithMaxUploadSize makes GoFakeS3 reject objects larger than size bytes with
+// EntityTooLarge, whether they are uploaded with PutObject, a browser POST, or
+// in parts, in which case the sum of the parts is checked when the upload is
+// completed. Uploads are rejected as soon as they are known to be too large,
+// without reading the rest of the body.
+//
+// Individual parts are always limited to MaxUploadPartSize, as they are by S3.
+func WithMaxUploadSize(size int64) Option {
+	return func(g *GoFakeS3) { g.maxUploadSize = size }
+}
+
+// WithMinPartSize sets the minimum size of every part of a multipart upload
+// but the last, which is DefaultUploadPartSize (5 MiB) by default, as it is in
+// S3. Uploads with smaller parts fail with EntityTooSmall when they are
+// completed. Tests that upload small parts can use WithMinPartSize(0).
+func WithMinPartSize(size int64) Option {
+	return func(g *GoFakeS3) { g.minPartSize = size }
+}
+
+// WithETagFunc replaces the MD5 hash used for the ETags of objects and parts
+// with fn, for example to test clients that do not expect MD5 ETags, or to use
+// a faster hash for large objects. The ETag of an object completed from parts
+// is computed with fn over the ETags of its parts, followed by "-" and the
+// number of parts.
+//
+// The ETag is stored in the object's metadata under ETagMetaKey, so it is
+// returned by GET, HEAD and listings with any Backend that uses ObjectETag.
+// Objects are read into memory to compute their ETag before they are passed
+// to the Backend.
+//
+// Content-MD5 headers are still checked against the MD5 hash of the contents.
+func WithETagFunc(fn ETagFunc) Option {
+	return func(g *GoFakeS3) { g.etag = fn }
 }
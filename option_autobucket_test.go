@@ -2,6 +2,8 @@ package gofakes3_test
 
 import (
 	"bytes"
+	"log"
+	"strings"
 	"testing"
 
 	"github.com/aws/aws-sdk-go/aws"
@@ -34,6 +36,44 @@ func TestAutoBucketPutObject(t *testing.T) {
 	autoSrv.assertObject(autoBucket, "object", nil, "hello")
 }
 
+func TestAutoBucketObjectOwnership(t *testing.T) {
+	ts := newAutoBucketTestServer(t)
+	defer ts.Close()
+	svc := ts.s3Client()
+
+	// Auto-created buckets have ACLs disabled, like the ones created with
+	// CreateBucket:
+	_, err := svc.PutObject(&s3.PutObjectInput{
+		Bucket: aws.String(autoBucket),
+		Key:    aws.String("object"),
+		Body:   bytes.NewReader([]byte("hello")),
+		ACL:    aws.String("public-read"),
+	})
+	if !hasErrorCode(err, gofakes3.ErrAccessControlListNotSupported) {
+		t.Fatal("expected AccessControlListNotSupported, found", err)
+	}
+	ts.OKAll(svc.PutObject(&s3.PutObjectInput{
+		Bucket: aws.String(autoBucket),
+		Key:    aws.String("object"),
+		Body:   bytes.NewReader([]byte("hello")),
+	}))
+	ts.assertObject(autoBucket, "object", nil, "hello")
+}
+
+func TestAutoBucketCreateMultipartUpload(t *testing.T) {
+	ts := newAutoBucketTestServer(t)
+	defer ts.Close()
+	svc := ts.s3Client()
+
+	ts.OKAll(svc.CreateMultipartUpload(&s3.CreateMultipartUploadInput{
+		Bucket: aws.String(autoBucket),
+		Key:    aws.String("object"),
+	}))
+	if exists, err := ts.backend.BucketExists(autoBucket); err != nil || !exists {
+		t.Fatal("bucket was not created", exists, err)
+	}
+}
+
 func TestAutoBucketGetObject(t *testing.T) {
 	ts := newAutoBucketTestServer(t)
 	defer ts.Close()
@@ -117,7 +157,7 @@ func TestAutoBucketListMultipartUploads(t *testing.T) {
 	_, err := svc.ListMultipartUploads(&s3.ListMultipartUploadsInput{
 		Bucket: aws.String(autoBucket),
 	})
-	if !hasErrorCode(err, gofakes3.ErrNoSuchUpload) {
+	if err != nil {
 		t.Fatal(err)
 	}
 }
@@ -134,3 +174,129 @@ func TestAutoBucketGetBucketVersioning(t *testing.T) {
 		t.Fatal(err)
 	}
 }
+
+// With WithAutoBucketFunc, only writing an object creates the bucket; anything
+// else fails as if it was not used, so that a mistyped bucket name is not
+// hidden.
+func TestAutoBucketFuncReadOnly(t *testing.T) {
+	for _, tc := range []struct {
+		name string
+		call func(svc *s3.S3) error
+	}{
+		{"GetObject", func(svc *s3.S3) error {
+			_, err := svc.GetObject(&s3.GetObjectInput{Bucket: aws.String(autoBucket), Key: aws.String("object")})
+			return err
+		}},
+		{"DeleteObject", func(svc *s3.S3) error {
+			_, err := svc.DeleteObject(&s3.DeleteObjectInput{Bucket: aws.String(autoBucket), Key: aws.String("object")})
+			return err
+		}},
+		{"DeleteObjectVersion", func(svc *s3.S3) error {
+			_, err := svc.DeleteObject(&s3.DeleteObjectInput{Bucket: aws.String(autoBucket), Key: aws.String("object"), VersionId: aws.String("version")})
+			return err
+		}},
+		{"DeleteObjects", func(svc *s3.S3) error {
+			_, err := svc.DeleteObjects(&s3.DeleteObjectsInput{
+				Bucket: aws.String(autoBucket),
+				Delete: &s3.Delete{Objects: []*s3.ObjectIdentifier{{Key: aws.String("object1"), VersionId: aws.String("version1")}}},
+			})
+			return err
+		}},
+		{"GetBucketLocation", func(svc *s3.S3) error {
+			_, err := svc.GetBucketLocation(&s3.GetBucketLocationInput{Bucket: aws.String(autoBucket)})
+			return err
+		}},
+		{"ListObjects", func(svc *s3.S3) error {
+			_, err := svc.ListObjects(&s3.ListObjectsInput{Bucket: aws.String(autoBucket)})
+			return err
+		}},
+		{"ListMultipartUploads", func(svc *s3.S3) error {
+			_, err := svc.ListMultipartUploads(&s3.ListMultipartUploadsInput{Bucket: aws.String(autoBucket)})
+			return err
+		}},
+		{"GetBucketVersioning", func(svc *s3.S3) error {
+			_, err := svc.GetBucketVersioning(&s3.GetBucketVersioningInput{Bucket: aws.String(autoBucket)})
+			return err
+		}},
+		{"DeleteBucket", func(svc *s3.S3) error {
+			_, err := svc.DeleteBucket(&s3.DeleteBucketInput{Bucket: aws.String(autoBucket)})
+			return err
+		}},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			ts := newTestServer(t,
+				withoutInitialBuckets(),
+				withFakerOptions(gofakes3.WithAutoBucketFunc(func(name string) bool { return true })))
+			defer ts.Close()
+
+			if err := tc.call(ts.s3Client()); !hasErrorCode(err, gofakes3.ErrNoSuchBucket) {
+				t.Fatal("expected NoSuchBucket, found", err)
+			}
+			if exists, err := ts.backend.BucketExists(autoBucket); err != nil || exists {
+				t.Fatal("bucket was created", exists, err)
+			}
+		})
+	}
+}
+
+func TestAutoBucketFunc(t *testing.T) {
+	var buf bytes.Buffer
+	ts := newTestServer(t,
+		withoutInitialBuckets(),
+		withFakerOptions(
+			gofakes3.WithLogger(gofakes3.StdLog(log.New(&buf, "", 0), gofakes3.LogInfo)),
+			gofakes3.WithAutoBucketFunc(func(name string) bool {
+				return strings.HasPrefix(name, "fixture-")
+			}),
+		))
+	defer ts.Close()
+	svc := ts.s3Client()
+
+	put := func(bucket string) error {
+		_, err := svc.PutObject(&s3.PutObjectInput{
+			Bucket: aws.String(bucket),
+			Key:    aws.String("object"),
+			Body:   bytes.NewReader([]byte("hello")),
+		})
+		return err
+	}
+
+	ts.OK(put("fixture-one"))
+	ts.assertObject("fixture-one", "object", nil, "hello")
+	if !strings.Contains(buf.String(), "INFO autobucket created: fixture-one\n") {
+		t.Fatal("auto-created bucket not logged:", buf.String())
+	}
+
+	// A bucket that matches is only created by a write:
+	_, err := svc.GetObject(&s3.GetObjectInput{Bucket: aws.String("fixture-two"), Key: aws.String("object")})
+	if !hasErrorCode(err, gofakes3.ErrNoSuchBucket) {
+		t.Fatal("expected NoSuchBucket, found", err)
+	}
+	if exists, err := ts.backend.BucketExists("fixture-two"); err != nil || exists {
+		t.Fatal("bucket was created", exists, err)
+	}
+
+	if err := put("fixtrue-one"); !hasErrorCode(err, gofakes3.ErrNoSuchBucket) {
+		t.Fatal("expected NoSuchBucket, found", err)
+	}
+	if exists, err := ts.backend.BucketExists("fixtrue-one"); err != nil || exists {
+		t.Fatal("bucket was created", exists, err)
+	}
+}
+
+func TestAutoBucketDisabled(t *testing.T) {
+	ts := newTestServer(t,
+		withoutInitialBuckets(),
+		withFakerOptions(gofakes3.WithAutoBucket(false)))
+	defer ts.Close()
+	svc := ts.s3Client()
+
+	_, err := svc.PutObject(&s3.PutObjectInput{
+		Bucket: aws.String(autoBucket),
+		Key:    aws.String("object"),
+		Body:   bytes.NewReader([]byte("hello")),
+	})
+	if !hasErrorCode(err, gofakes3.ErrNoSuchBucket) {
+		t.Fatal("expected NoSuchBucket, found", err)
+	}
+}
@@ -0,0 +1,127 @@
+package gofakes3
+
+import (
+	"encoding/xml"
+	"net/http"
+)
+
+// ObjectOwnership selects who owns the objects uploaded to a bucket and,
+// with ObjectOwnershipBucketOwnerEnforced, disables ACLs. As every bucket
+// and object has the same owner in GoFakeS3, only the latter has any effect.
+type ObjectOwnership string
+
+const (
+	ObjectOwnershipBucketOwnerPreferred ObjectOwnership = "BucketOwnerPreferred"
+	ObjectOwnershipObjectWriter         ObjectOwnership = "ObjectWriter"
+
+	// ObjectOwnershipBucketOwnerEnforced disables ACLs: requests that set an
+	// ACL granting anything to anyone but the owner fail with
+	// ErrAccessControlListNotSupported, and the ACLs already stored are
+	// ignored, both when they are read and when authorizing anonymous
+	// requests.
+	ObjectOwnershipBucketOwnerEnforced ObjectOwnership = "BucketOwnerEnforced"
+)
+
+func (o ObjectOwnership) valid() bool {
+	switch o {
+	case ObjectOwnershipBucketOwnerPreferred, ObjectOwnershipObjectWriter, ObjectOwnershipBucketOwnerEnforced:
+		return true
+	}
+	return false
+}
+
+// ownershipControlsSubresource is the name the OwnershipControls are stored
+// under by a BucketConfigBackend.
+const ownershipControlsSubresource = "ownershipControls"
+
+// objectOwnership returns the ObjectOwnership of the bucket's ownership
+// controls, or "" if it has none, if the bucket does not exist, or if the
+// Backend does not implement BucketConfigBackend.
+func (g *GoFakeS3) objectOwnership(bucket string) (ObjectOwnership, error) {
+	if g.configs == nil {
+		return "", nil
+	}
+	stored, err := g.configs.BucketConfig(bucket, ownershipControlsSubresource)
+	if HasErrorCode(err, ErrNoSuchBucket) || stored == nil {
+		return "", nil
+	} else if err != nil {
+		return "", err
+	}
+
+	var controls OwnershipControls
+	if err := xml.Unmarshal(stored, &controls); err != nil {
+		return "", err
+	}
+	if len(controls.Rules) == 0 {
+		return "", nil
+	}
+	return controls.Rules[0].ObjectOwnership, nil
+}
+
+func (g *GoFakeS3) setObjectOwnership(bucket string, ownership ObjectOwnership) error {
+	stored, err := xml.Marshal(OwnershipControls{Rules: []OwnershipControlsRule{{ObjectOwnership: ownership}}})
+	if err != nil {
+		return err
+	}
+	return g.configs.SetBucketConfig(bucket, ownershipControlsSubresource, stored)
+}
+
+// objectOwnershipFromHeader returns the ObjectOwnership requested for a new
+// bucket with the x-amz-object-ownership header, or the one passed to
+// WithObjectOwnership. It returns "" if the ownership can not be stored, as
+// the Backend does not implement BucketConfigBackend.
+func (g *GoFakeS3) objectOwnershipFromHeader(h http.Header) (ObjectOwnership, error) {
+	ownership := g.defaultObjectOwnership
+	if v := h.Get("x-amz-object-ownership"); v != "" {
+		ownership = ObjectOwnership(v)
+		if !ownership.valid() {
+			return "", ErrorInvalidArgument("x-amz-object-ownership", v, "Invalid x-amz-object-ownership header")
+		}
+	}
+	if g.configs == nil {
+		return "", nil
+	}
+	return ownership, nil
+}
+
+// effectiveACL returns the ACL that applies to the bucket or one of its
+// objects, which is the private one if the bucket has ACLs disabled.
+func (g *GoFakeS3) effectiveACL(bucket string, acl *AccessControlPolicy) (*AccessControlPolicy, error) {
+	ownership, err := g.objectOwnership(bucket)
+	if err != nil {
+		return nil, err
+	}
+	if ownership == ObjectOwnershipBucketOwnerEnforced {
+		return nil, nil
+	}
+	return acl, nil
+}
+
+// bucketOwnerSubresource is the name the ID of the owner that created a
+// bucket is stored under by a BucketConfigBackend. Servers sharing a Backend
+// but set up with different owners with WithOwner act as different accounts
+// when creating buckets.
+const bucketOwnerSubresource = "owner"
+
+// bucketOwner returns the ID of the owner that created the bucket. Buckets
+// created directly with the Backend, or when it does not implement
+// BucketConfigBackend, belong to the owner passed to WithOwner.
+func (g *GoFakeS3) bucketOwner(bucket string) (string, error) {
+	if g.configs == nil {
+		return g.owner.ID, nil
+	}
+	stored, err := g.configs.BucketConfig(bucket, bucketOwnerSubresource)
+	if err != nil {
+		return "", err
+	} else if stored == nil {
+		return g.owner.ID, nil
+	}
+	return string(stored), nil
+}
+
+func (g *GoFakeS3) setBucketOwner(bucket string) error {
+	if g.configs == nil {
+		return nil
+	}
+	return g.configs.SetBucketConfig(bucket, bucketOwnerSubresource, []byte(g.owner.ID))
+}
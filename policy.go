@@ -0,0 +1,177 @@
+package gofakes3
+
+import (
+	"encoding/json"
+	"strings"
+)
+
+// From https://docs.aws.amazon.com/AmazonS3/latest/dev/example-bucket-policies.html:
+//
+//	"Bucket policies are limited to 20 KB in size."
+const MaxBucketPolicySize = 20 * 1024
+
+// validateBucketPolicy ensures that a bucket policy is valid JSON containing a
+// list of statements. The statements themselves are not inspected here; only
+// the subset used to authorize anonymous requests is ever evaluated.
+func validateBucketPolicy(policy []byte) error {
+	if len(policy) > MaxBucketPolicySize {
+		return ErrorMessage(ErrMalformedPolicy, "Policies must be less than 20 KB")
+	}
+
+	var doc struct {
+		Statement []json.RawMessage
+	}
+	if err := json.Unmarshal(policy, &doc); err != nil {
+		return ErrorMessage(ErrMalformedPolicy, "Policies must be valid JSON and the first byte must be '{'")
+	}
+	if len(doc.Statement) == 0 {
+		return ErrorMessage(ErrMalformedPolicy, "Missing required field Statement")
+	}
+
+	return nil
+}
+
+// policyStatement is the subset of a policy statement needed to decide
+// whether anonymous requests are allowed.
+type policyStatement struct {
+	Effect    string
+	Principal json.RawMessage
+	Action    policyStrings
+	Resource  policyStrings
+}
+
+// policyStrings is a policy element that may be either a single string or a
+// list of strings.
+type policyStrings []string
+
+func (p *policyStrings) UnmarshalJSON(b []byte) error {
+	var one string
+	if err := json.Unmarshal(b, &one); err == nil {
+		*p = policyStrings{one}
+		return nil
+	}
+	var many []string
+	if err := json.Unmarshal(b, &many); err != nil {
+		return err
+	}
+	*p = many
+	return nil
+}
+
+// isPublic reports whether the statement applies to everyone, i.e. its
+// principal is "*" or {"AWS": "*"}.
+func (s *policyStatement) isPublic() bool {
+	var one string
+	if err := json.Unmarshal(s.Principal, &one); err == nil {
+		return one == "*"
+	}
+	var principal struct{ AWS policyStrings }
+	if err := json.Unmarshal(s.Principal, &principal); err != nil {
+		return false
+	}
+	for _, v := range principal.AWS {
+		if v == "*" {
+			return true
+		}
+	}
+	return false
+}
+
+func (s *policyStatement) matches(action, resource string) bool {
+	actionOK := false
+	for _, pattern := range s.Action {
+		if pattern == "*" || pattern == "s3:*" ||
+			(action != "" && policyWildcardMatch(strings.ToLower(pattern), strings.ToLower(action))) {
+			actionOK = true
+			break
+		}
+	}
+	if !actionOK {
+		return false
+	}
+
+	for _, pattern := range s.Resource {
+		if policyWildcardMatch(pattern, resource) {
+			return true
+		}
+	}
+	return false
+}
+
+// policyAnonymousAccess reports whether a bucket policy explicitly allows or
+// denies everyone to perform action on resource. A Deny statement wins over
+// any Allow statement, and over the ACLs. An empty action is only matched by
+// statements that cover all actions. Conditions are not supported; statements
+// that have them are treated as if they did not.
+func policyAnonymousAccess(policy []byte, action, resource string) (allowed, denied bool) {
+	var doc struct {
+		Statement []policyStatement
+	}
+	if err := json.Unmarshal(policy, &doc); err != nil {
+		return false, false
+	}
+
+	for i := range doc.Statement {
+		stmt := &doc.Statement[i]
+		if !stmt.isPublic() || !stmt.matches(action, resource) {
+			continue
+		}
+		switch stmt.Effect {
+		case "Deny":
+			return false, true
+		case "Allow":
+			allowed = true
+		}
+	}
+	return allowed, false
+}
+
+// policyIsPublic reports whether a bucket policy allows anything to
+// everyone. As with policyAnonymousAccess, conditions are not supported, so
+// a statement that limits access to everyone with one still counts.
+func policyIsPublic(policy []byte) bool {
+	var doc struct {
+		Statement []policyStatement
+	}
+	if err := json.Unmarshal(policy, &doc); err != nil {
+		return false
+	}
+	for i := range doc.Statement {
+		if stmt := &doc.Statement[i]; stmt.Effect == "Allow" && stmt.isPublic() {
+			return true
+		}
+	}
+	return false
+}
+
+// policyWildcardMatch matches value against a pattern in which '*' matches any
+// sequence of characters and '?' matches any single character.
+func policyWildcardMatch(pattern, value string) bool {
+	for len(pattern) > 0 {
+		switch pattern[0] {
+		case '*':
+			pattern = strings.TrimLeft(pattern, "*")
+			if pattern == "" {
+				return true
+			}
+			for i := 0; i <= len(value); i++ {
+				if policyWildcardMatch(pattern, value[i:]) {
+					return true
+				}
+			}
+			return false
+
+		case '?':
+			if value == "" {
+				return false
+			}
+
+		default:
+			if value == "" || value[0] != pattern[0] {
+				return false
+			}
+		}
+		pattern, value = pattern[1:], value[1:]
+	}
+	return value == ""
+}
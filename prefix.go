@@ -80,7 +80,7 @@ func (p Prefix) Match(key string, match *PrefixMatch) (ok bool) {
 		return true
 	}
 
-	if !p.HasDelimiter {
+	if !p.HasDelimiter || p.Delimiter == "" {
 		// If the request does not contain a delimiter, prefix matching is a
 		// simple string prefix:
 		if strings.HasPrefix(key, p.Prefix) {
@@ -101,47 +101,25 @@ func (p Prefix) Match(key string, match *PrefixMatch) (ok bool) {
 	//	                            PRE 260839334643/
 	//	 $ aws s3 ls s3://my-bucket/AWSLogs/2608
 	//	                            PRE 260839334643/
-
-	keyParts := strings.Split(strings.TrimLeft(key, p.Delimiter), p.Delimiter)
-	preParts := strings.Split(strings.TrimLeft(p.Prefix, p.Delimiter), p.Delimiter)
-
-	if len(keyParts) < len(preParts) {
+	//
+	// Keys that contain the delimiter after the prefix are rolled up into a
+	// common prefix, which runs up to and including the first delimiter after
+	// the prefix. A key that equals the prefix, or that does not contain the
+	// delimiter after it, belongs in the contents.
+	if !strings.HasPrefix(key, p.Prefix) {
 		return false
 	}
 
-	// If the key exactly matches the prefix, but only up to a delimiter,
-	// AWS appends the delimiter to the result:
-	//	 $ aws s3 ls s3://my-bucket/AWSLogs
-	//	                            PRE AWSLogs/
-	appendDelim := len(keyParts) != len(preParts)
-	matched := 0
-
-	last := len(preParts) - 1
-	for i := 0; i < len(preParts); i++ {
-		if i == last {
-			if !strings.HasPrefix(keyParts[i], preParts[i]) {
-				return false
-			}
-
+	idx := strings.Index(key[len(p.Prefix):], p.Delimiter)
+	if match != nil {
+		if idx < 0 {
+			*match = PrefixMatch{Key: key, MatchedPart: key}
 		} else {
-			if keyParts[i] != preParts[i] {
-				return false
-			}
+			// A key that ends with the delimiter, like a "folder" object, is
+			// rolled up too:
+			out := key[:len(p.Prefix)+idx+len(p.Delimiter)]
+			*match = PrefixMatch{Key: key, CommonPrefix: true, MatchedPart: out}
 		}
-		matched++
-	}
-
-	if matched == 0 {
-		return false
-	}
-
-	out := strings.Join(keyParts[:matched], p.Delimiter)
-	if appendDelim {
-		out += p.Delimiter
-	}
-
-	if match != nil {
-		*match = PrefixMatch{Key: key, CommonPrefix: out != key, MatchedPart: out}
 	}
 	return true
 }
@@ -21,7 +21,26 @@ func TestPrefixMatch(t *testing.T) {
 		{key: "foo/bar", p: s("foo"), d: s("/"), out: s("foo/"), common: true},
 		{key: "foo/bar", p: s("foo/ba"), d: s("/"), out: s("foo/bar")},
 		{key: "foo/bar", p: s("foo/ba/"), d: s("/"), out: nil},
-		{key: "foo/bar", p: s("/"), d: s("/"), out: s("foo/"), common: true},
+		{key: "foo/bar", p: s(""), d: s("/"), out: s("foo/"), common: true},
+		{key: "foo/bar/baz", p: s("foo/"), d: s("/"), out: s("foo/bar/"), common: true},
+		{key: "foo/bar/", p: s("foo/"), d: s("/"), out: s("foo/bar/"), common: true},
+		{key: "foo/", p: s("foo/"), d: s("/"), out: s("foo/")},
+		{key: "foo", p: s("foo"), d: s("/"), out: s("foo")},
+		{key: "foo//bar", p: s("foo/"), d: s("/"), out: s("foo//"), common: true},
+
+		// As in S3, the prefix is matched as is, so a leading delimiter is
+		// not ignored:
+		{key: "foo/bar", p: s("/"), d: s("/"), out: nil},
+		{key: "/foo/bar", p: s(""), d: s("/"), out: s("/"), common: true},
+
+		// The delimiter may be longer than one character, and the prefix may
+		// end part way through it:
+		{key: "foo--bar--baz", p: s("foo-"), d: s("--"), out: s("foo--bar--"), common: true},
+		{key: "foo--bar", p: s(""), d: s("--"), out: s("foo--"), common: true},
+		{key: "foo-bar", p: s(""), d: s("--"), out: s("foo-bar")},
+
+		// An empty delimiter is no delimiter at all:
+		{key: "foo/bar", p: s("foo"), d: s(""), out: s("foo")},
 
 		// Without a delimiter, it's just a boring ol' prefix match:
 		{key: "foo/bar", p: s("foo/b"), out: s("foo/b")},
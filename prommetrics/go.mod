@@ -0,0 +1,10 @@
+module github.com/johannesboyne/gofakes3/prommetrics
+
+go 1.16
+
+require (
+	github.com/johannesboyne/gofakes3 v0.0.0-00010101000000-000000000000
+	github.com/prometheus/client_golang v1.15.1
+)
+
+replace github.com/johannesboyne/gofakes3 => ../
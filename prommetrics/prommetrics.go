@@ -0,0 +1,183 @@
+// Package prommetrics exports GoFakeS3 metrics to Prometheus.
+//
+// It is a module of its own, separate from gofakes3, so that users who don't
+// want metrics don't need the Prometheus client as a dependency:
+//
+//	faker := gofakes3.New(backend, prommetrics.WithMetrics(prometheus.DefaultRegisterer))
+//
+// The following metrics are exported:
+//
+//	gofakes3_requests_total{operation}                Requests handled
+//	gofakes3_request_errors_total{operation,code}     Requests that returned an S3 error
+//	gofakes3_request_duration_seconds{operation}      Histogram of request latency
+//	gofakes3_buckets                                  Buckets in the backend
+//	gofakes3_objects                                  Objects in the backend
+//	gofakes3_stored_bytes                             Total size of the objects in the backend
+//
+// The storage gauges are calculated by listing every bucket in the backend
+// each time the metrics are collected, so may be slow to scrape if the
+// backend holds many objects.
+package prommetrics
+
+import (
+	"sync"
+	"time"
+
+	"github.com/johannesboyne/gofakes3"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+const namespace = "gofakes3"
+
+// Metrics implements gofakes3.BackendMetrics by recording to Prometheus
+// collectors. It must be registered with a prometheus.Registerer, which New
+// does for you.
+type Metrics struct {
+	requests *prometheus.CounterVec
+	errors   *prometheus.CounterVec
+	duration *prometheus.HistogramVec
+
+	bucketsDesc *prometheus.Desc
+	objectsDesc *prometheus.Desc
+	bytesDesc   *prometheus.Desc
+
+	mu      sync.RWMutex
+	backend gofakes3.Backend
+}
+
+var (
+	_ gofakes3.BackendMetrics = &Metrics{}
+	_ prometheus.Collector    = &Metrics{}
+)
+
+// New creates a Metrics and registers it with reg.
+func New(reg prometheus.Registerer) (*Metrics, error) {
+	m := &Metrics{
+		requests: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "requests_total",
+			Help:      "Number of S3 requests handled, by operation.",
+		}, []string{"operation"}),
+
+		errors: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "request_errors_total",
+			Help:      "Number of S3 requests that returned an error, by operation and S3 error code.",
+		}, []string{"operation", "code"}),
+
+		duration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: namespace,
+			Name:      "request_duration_seconds",
+			Help:      "Time taken to handle S3 requests, by operation.",
+			Buckets:   prometheus.DefBuckets,
+		}, []string{"operation"}),
+
+		bucketsDesc: prometheus.NewDesc(namespace+"_buckets", "Number of buckets in the backend.", nil, nil),
+		objectsDesc: prometheus.NewDesc(namespace+"_objects", "Number of objects in the backend.", nil, nil),
+		bytesDesc:   prometheus.NewDesc(namespace+"_stored_bytes", "Total size of the objects in the backend, in bytes.", nil, nil),
+	}
+
+	if err := reg.Register(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+// WithMetrics creates a Metrics registered with reg, and passes it to
+// gofakes3.WithMetrics. It panics if the metrics can not be registered, for
+// example if they already have been.
+func WithMetrics(reg prometheus.Registerer) gofakes3.Option {
+	m, err := New(reg)
+	if err != nil {
+		panic(err)
+	}
+	return gofakes3.WithMetrics(m)
+}
+
+// ObserveOperation implements gofakes3.Metrics.
+func (m *Metrics) ObserveOperation(operation string, code gofakes3.ErrorCode, duration time.Duration) {
+	m.requests.WithLabelValues(operation).Inc()
+	if code != gofakes3.ErrNone {
+		m.errors.WithLabelValues(operation, string(code)).Inc()
+	}
+	m.duration.WithLabelValues(operation).Observe(duration.Seconds())
+}
+
+// ObserveBackend implements gofakes3.BackendMetrics.
+func (m *Metrics) ObserveBackend(backend gofakes3.Backend) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.backend = backend
+}
+
+// Describe implements prometheus.Collector.
+func (m *Metrics) Describe(ch chan<- *prometheus.Desc) {
+	m.requests.Describe(ch)
+	m.errors.Describe(ch)
+	m.duration.Describe(ch)
+	ch <- m.bucketsDesc
+	ch <- m.objectsDesc
+	ch <- m.bytesDesc
+}
+
+// Collect implements prometheus.Collector.
+func (m *Metrics) Collect(ch chan<- prometheus.Metric) {
+	m.requests.Collect(ch)
+	m.errors.Collect(ch)
+	m.duration.Collect(ch)
+
+	m.mu.RLock()
+	backend := m.backend
+	m.mu.RUnlock()
+	if backend == nil {
+		return
+	}
+
+	buckets, objects, bytes, err := storageStats(backend)
+	if err != nil {
+		for _, desc := range []*prometheus.Desc{m.bucketsDesc, m.objectsDesc, m.bytesDesc} {
+			ch <- prometheus.NewInvalidMetric(desc, err)
+		}
+		return
+	}
+	ch <- prometheus.MustNewConstMetric(m.bucketsDesc, prometheus.GaugeValue, float64(buckets))
+	ch <- prometheus.MustNewConstMetric(m.objectsDesc, prometheus.GaugeValue, float64(objects))
+	ch <- prometheus.MustNewConstMetric(m.bytesDesc, prometheus.GaugeValue, float64(bytes))
+}
+
+func storageStats(backend gofakes3.Backend) (buckets, objects, bytes int64, err error) {
+	infos, err := backend.ListBuckets()
+	if err != nil {
+		return 0, 0, 0, err
+	}
+
+	for _, info := range infos {
+		var page gofakes3.ListBucketPage
+		for {
+			list, err := backend.ListBucket(info.Name, &gofakes3.Prefix{}, page)
+			if gofakes3.HasErrorCode(err, gofakes3.ErrNoSuchBucket) {
+				break // Deleted since it was listed
+			} else if err != nil {
+				return 0, 0, 0, err
+			}
+			for _, item := range list.Contents {
+				objects++
+				bytes += item.Size
+			}
+
+			// Backends are not required to supply a NextMarker if there is no
+			// delimiter, in which case the last key is the next marker:
+			next := list.NextMarker
+			if next == "" && len(list.Contents) > 0 {
+				next = list.Contents[len(list.Contents)-1].Key
+			}
+			if !list.IsTruncated || next == "" {
+				break
+			}
+			page = gofakes3.ListBucketPage{Marker: next, HasMarker: true}
+		}
+		buckets++
+	}
+
+	return buckets, objects, bytes, nil
+}
@@ -0,0 +1,96 @@
+package prommetrics
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/johannesboyne/gofakes3"
+	"github.com/johannesboyne/gofakes3/backend/s3mem"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestMetrics(t *testing.T) {
+	reg := prometheus.NewRegistry()
+	metrics, err := New(reg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	faker := gofakes3.New(s3mem.New(), gofakes3.WithTimeSkewLimit(0), gofakes3.WithMetrics(metrics))
+	ts := httptest.NewServer(faker.Server())
+	defer ts.Close()
+
+	do := func(method, path, body string, status int) {
+		t.Helper()
+		rq, err := http.NewRequest(method, ts.URL+path, strings.NewReader(body))
+		if err != nil {
+			t.Fatal(err)
+		}
+		rs, err := http.DefaultClient.Do(rq)
+		if err != nil {
+			t.Fatal(err)
+		}
+		rs.Body.Close()
+		if rs.StatusCode != status {
+			t.Fatal(method, path, "returned", rs.StatusCode, "!=", status)
+		}
+	}
+
+	do("PUT", "/bucket", "", 200)
+	do("PUT", "/bucket/a", "hello", 200)
+	do("PUT", "/bucket/b", "world!", 200)
+	do("GET", "/bucket/a", "", 200)
+	do("GET", "/bucket/missing", "", 404)
+	do("GET", "/bucket?list-type=2", "", 200)
+	do("GET", "/", "", 200)
+
+	for _, tc := range []struct {
+		operation string
+		requests  float64
+	}{
+		{"CreateBucket", 1},
+		{"PutObject", 2},
+		{"GetObject", 2},
+		{"ListObjectsV2", 1},
+		{"ListBuckets", 1},
+	} {
+		if got := testutil.ToFloat64(metrics.requests.WithLabelValues(tc.operation)); got != tc.requests {
+			t.Error(tc.operation, "requests", got, "!=", tc.requests)
+		}
+	}
+
+	if got := testutil.ToFloat64(metrics.errors.WithLabelValues("GetObject", string(gofakes3.ErrNoSuchKey))); got != 1 {
+		t.Error("GetObject NoSuchKey errors", got, "!= 1")
+	}
+	if got := testutil.CollectAndCount(metrics.errors); got != 1 {
+		t.Error("found", got, "error series, expected 1")
+	}
+	if got := testutil.CollectAndCount(metrics.duration); got != 5 {
+		t.Error("found", got, "duration series, expected 5")
+	}
+
+	expected := `
+# HELP gofakes3_buckets Number of buckets in the backend.
+# TYPE gofakes3_buckets gauge
+gofakes3_buckets 1
+# HELP gofakes3_objects Number of objects in the backend.
+# TYPE gofakes3_objects gauge
+gofakes3_objects 2
+# HELP gofakes3_stored_bytes Total size of the objects in the backend, in bytes.
+# TYPE gofakes3_stored_bytes gauge
+gofakes3_stored_bytes 11
+`
+	if err := testutil.GatherAndCompare(reg, strings.NewReader(expected), "gofakes3_buckets", "gofakes3_objects", "gofakes3_stored_bytes"); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestRegisterTwice(t *testing.T) {
+	reg := prometheus.NewRegistry()
+	WithMetrics(reg)
+	if _, err := New(reg); err == nil {
+		t.Fatal("expected error registering metrics twice")
+	}
+}
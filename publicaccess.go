@@ -0,0 +1,58 @@
+package gofakes3
+
+import (
+	"encoding/xml"
+)
+
+// publicAccessBlockSubresource is the name the PublicAccessBlockConfiguration
+// is stored under by a BucketConfigBackend.
+const publicAccessBlockSubresource = "publicAccessBlock"
+
+// publicAccessBlock returns the bucket's public access block, which blocks
+// nothing if it has never been set, if the bucket does not exist, or if the
+// Backend does not implement BucketConfigBackend.
+func (g *GoFakeS3) publicAccessBlock(bucket string) (block PublicAccessBlockConfiguration, err error) {
+	if g.configs == nil {
+		return block, nil
+	}
+	stored, err := g.configs.BucketConfig(bucket, publicAccessBlockSubresource)
+	if HasErrorCode(err, ErrNoSuchBucket) || stored == nil {
+		return block, nil
+	} else if err != nil {
+		return block, err
+	}
+	return block, xml.Unmarshal(stored, &block)
+}
+
+// checkPublicACL refuses an ACL requested for the bucket or one of its
+// objects if it is public and the bucket's public access block has
+// BlockPublicACLs.
+func (g *GoFakeS3) checkPublicACL(bucket string, acl *AccessControlPolicy) error {
+	if !acl.isPublic() {
+		return nil
+	}
+	block, err := g.publicAccessBlock(bucket)
+	if err != nil {
+		return err
+	}
+	if block.BlockPublicACLs {
+		return ErrorMessage(ErrAccessDenied, "Access Denied")
+	}
+	return nil
+}
+
+// checkPublicPolicy refuses a bucket policy that allows access to everyone
+// if the bucket's public access block has BlockPublicPolicy.
+func (g *GoFakeS3) checkPublicPolicy(bucket string, policy []byte) error {
+	if !policyIsPublic(policy) {
+		return nil
+	}
+	block, err := g.publicAccessBlock(bucket)
+	if err != nil {
+		return err
+	}
+	if block.BlockPublicPolicy {
+		return ErrorMessage(ErrAccessDenied, "Access Denied")
+	}
+	return nil
+}
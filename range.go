@@ -1,8 +1,12 @@
 package gofakes3
 
 import (
+	"bytes"
 	"fmt"
+	"io"
+	"mime/multipart"
 	"net/http"
+	"net/textproto"
 	"strconv"
 	"strings"
 )
@@ -11,15 +15,25 @@ type ObjectRange struct {
 	Start, Length int64
 }
 
+// writeHeader writes the Content-Length of the response, and if o is not nil,
+// the Content-Range of the part of the object of size sz that it covers, and
+// the '206 Partial Content' status.
 func (o *ObjectRange) writeHeader(sz int64, w http.ResponseWriter) {
 	if o != nil {
-		w.Header().Set("Content-Range", fmt.Sprintf("bytes %d-%d/%d", o.Start, o.Start+o.Length-1, sz))
+		w.Header().Set("Content-Range", o.contentRange(sz))
 		w.Header().Set("Content-Length", fmt.Sprintf("%d", o.Length))
+		w.WriteHeader(http.StatusPartialContent)
 	} else {
 		w.Header().Set("Content-Length", fmt.Sprintf("%d", sz))
 	}
 }
 
+// contentRange formats o as the value of a Content-Range header, in which
+// the end is inclusive and sz is the size of the whole object.
+func (o *ObjectRange) contentRange(sz int64) string {
+	return fmt.Sprintf("bytes %d-%d/%d", o.Start, o.Start+o.Length-1, sz)
+}
+
 type ObjectRangeRequest struct {
 	Start, End int64
 	FromEnd    bool
@@ -46,10 +60,13 @@ func (o *ObjectRangeRequest) Range(size int64) (*ObjectRange, error) {
 		}
 
 	} else {
-		// If no start is specified, end specifies the range start relative
-		// to the end of the file.
-		end := o.End
-		start = size - end
+		// If no start is specified, end is the number of bytes at the end
+		// of the file to return. If the file is shorter than that, all of it
+		// is returned:
+		start = size - o.End
+		if o.End > 0 && start < 0 {
+			start = 0
+		}
 		length = size - start
 	}
 
@@ -64,11 +81,105 @@ func (o *ObjectRangeRequest) Range(size int64) (*ObjectRange, error) {
 	return &ObjectRange{Start: start, Length: length}, nil
 }
 
+// parsePartNumber returns the partNumber query parameter of a GET or HEAD
+// request, which selects a single part of an object, or 0 if there is none.
+// A Range header can not be given with it.
+func parsePartNumber(r *http.Request) (int, error) {
+	v := r.URL.Query().Get("partNumber")
+	if v == "" {
+		return 0, nil
+	}
+
+	partNumber, err := strconv.Atoi(v)
+	if err != nil || partNumber < 1 || partNumber > MaxUploadPartNumber {
+		return 0, ErrorInvalidArgument("partNumber", v, "Part number must be an integer between 1 and 10000, inclusive")
+	}
+	if r.Header.Get("Range") != "" {
+		return 0, ErrorMessage(ErrInvalidRequest, "Cannot specify both Range header and partNumber query parameter")
+	}
+	return partNumber, nil
+}
+
+// partRange returns the range of the object covered by the part with the
+// given (1-based) position in it, and the number of parts in the object. An
+// object that was not uploaded in parts has a single part, but a parts count
+// of 0, as S3 does not report one for it.
+//
+// The range is nil if the part is empty, as no range can select it.
+func partRange(obj *Object, partNumber int) (rnge *ObjectRangeRequest, partsCount int, err error) {
+	parts, err := decodeObjectParts(obj.Metadata)
+	if err != nil {
+		return nil, 0, err
+	}
+	if parts == nil {
+		parts = []objectPart{{PartNumber: 1, Size: obj.Size}}
+	} else {
+		partsCount = len(parts)
+	}
+	if partNumber > len(parts) {
+		return nil, 0, ErrInvalidPartNumber
+	}
+
+	var start int64
+	for _, part := range parts[:partNumber-1] {
+		start += part.Size
+	}
+	if size := parts[partNumber-1].Size; size > 0 {
+		rnge = &ObjectRangeRequest{Start: start, End: start + size - 1}
+	}
+	return rnge, partsCount, nil
+}
+
 // parseRangeHeader parses a single byte range from the Range header.
 //
 // Amazon S3 doesn't support retrieving multiple ranges of data per GET request:
 // https://docs.aws.amazon.com/AmazonS3/latest/API/RESTObjectGET.html
+//
+// GoFakeS3 does support multiple ranges for GET requests (see
+// parseRangesHeader), but this function should be used wherever only a
+// single range makes sense. See parseCopySourceRange for
+// x-amz-copy-source-range.
 func parseRangeHeader(s string) (*ObjectRangeRequest, error) {
+	ranges, err := parseRangesHeader(s)
+	if err != nil || ranges == nil {
+		return nil, err
+	}
+	if len(ranges) > 1 {
+		return nil, ErrorMessage(ErrNotImplemented, "multiple ranges not supported")
+	}
+	return &ranges[0], nil
+}
+
+// parseCopySourceRange parses the x-amz-copy-source-range header of an
+// UploadPartCopy request. Unlike the Range header of a GET request, it must
+// be a single range with both a first and a last byte, i.e. 'bytes=0-9'.
+func parseCopySourceRange(s string) (*ObjectRangeRequest, error) {
+	ranges, err := parseRangesHeader(s)
+	if ranges == nil && err == nil {
+		return nil, nil
+	} else if err != nil || len(ranges) > 1 || ranges[0].FromEnd || ranges[0].End == RangeNoEnd {
+		return nil, ErrorInvalidArgument("x-amz-copy-source-range", s,
+			"The x-amz-copy-source-range value must be of the form bytes=first-last where first and last are the zero-based offsets of the first and last bytes to copy")
+	}
+	return &ranges[0], nil
+}
+
+// copySourceRange returns the part of an object of size sz that o, which was
+// parsed by parseCopySourceRange, selects. Unlike Range, it fails with
+// ErrInvalidRange if the last byte is past the end of the object, rather than
+// stopping at the end.
+func (o *ObjectRangeRequest) copySourceRange(sz int64) (*ObjectRange, error) {
+	if o == nil {
+		return nil, nil
+	} else if o.End >= sz {
+		return nil, ErrorMessagef(ErrInvalidRange, "Range specified is not valid for source object of size: %d", sz)
+	}
+	return o.Range(sz)
+}
+
+// parseRangesHeader parses one or more comma separated byte ranges from the
+// Range header, i.e. 'bytes=0-9,20-29'. It returns nil if the header is empty.
+func parseRangesHeader(s string) ([]ObjectRangeRequest, error) {
 	if s == "" {
 		return nil, nil
 	}
@@ -78,43 +189,51 @@ func parseRangeHeader(s string) (*ObjectRangeRequest, error) {
 		return nil, ErrInvalidRange
 	}
 
-	ranges := strings.Split(s[len(b):], ",")
-	if len(ranges) > 1 {
-		return nil, ErrorMessage(ErrNotImplemented, "multiple ranges not supported")
+	specs := strings.Split(s[len(b):], ",")
+	ranges := make([]ObjectRangeRequest, 0, len(specs))
+	for _, spec := range specs {
+		o, err := parseRangeSpec(strings.TrimSpace(spec))
+		if err != nil {
+			return nil, err
+		}
+		ranges = append(ranges, o)
 	}
 
-	rnge := strings.TrimSpace(ranges[0])
+	return ranges, nil
+}
+
+// parseRangeSpec parses a single byte-range-spec or suffix-byte-range-spec
+// from the Range header, i.e. '0-9', '10-' or '-10'.
+func parseRangeSpec(rnge string) (o ObjectRangeRequest, err error) {
 	if len(rnge) == 0 {
-		return nil, ErrInvalidRange
+		return o, ErrInvalidRange
 	}
 
 	i := strings.Index(rnge, "-")
 	if i < 0 {
-		return nil, ErrInvalidRange
+		return o, ErrInvalidRange
 	}
 
-	var o ObjectRangeRequest
-
 	start, end := strings.TrimSpace(rnge[:i]), strings.TrimSpace(rnge[i+1:])
 	if start == "" {
 		o.FromEnd = true
 
 		i, err := strconv.ParseInt(end, 10, 64)
 		if err != nil {
-			return nil, ErrInvalidRange
+			return o, ErrInvalidRange
 		}
 		o.End = i
 
 	} else {
 		i, err := strconv.ParseInt(start, 10, 64)
 		if err != nil || i < 0 {
-			return nil, ErrInvalidRange
+			return o, ErrInvalidRange
 		}
 		o.Start = i
 		if end != "" {
 			i, err := strconv.ParseInt(end, 10, 64)
 			if err != nil || o.Start > i {
-				return nil, ErrInvalidRange
+				return o, ErrInvalidRange
 			}
 			o.End = i
 		} else {
@@ -122,5 +241,55 @@ func parseRangeHeader(s string) (*ObjectRangeRequest, error) {
 		}
 	}
 
-	return &o, nil
+	return o, nil
+}
+
+// writeMultipartRanges responds to a request for more than one range with a
+// multipart/byteranges body, as described in RFC 7233, section 4.1. body must
+// contain the entire object, of size sz.
+func writeMultipartRanges(w http.ResponseWriter, body io.ReaderAt, sz int64, contentType string, ranges []*ObjectRange) error {
+	// The parts are written twice; once without their contents, to find the
+	// Content-Length, then again to w, so the body is never buffered:
+	var headers bytes.Buffer
+	mw := multipart.NewWriter(&headers)
+	if err := writeRangeParts(mw, nil, sz, contentType, ranges); err != nil {
+		return err
+	}
+	length := int64(headers.Len())
+	for _, rnge := range ranges {
+		length += rnge.Length
+	}
+
+	w.Header().Set("Content-Type", "multipart/byteranges; boundary="+mw.Boundary())
+	w.Header().Set("Content-Length", fmt.Sprintf("%d", length))
+	w.WriteHeader(http.StatusPartialContent)
+
+	out := multipart.NewWriter(w)
+	if err := out.SetBoundary(mw.Boundary()); err != nil {
+		return err
+	}
+	return writeRangeParts(out, body, sz, contentType, ranges)
+}
+
+// writeRangeParts writes a part to mw for each of the ranges of body, and
+// closes it. If body is nil, only the parts' headers are written.
+func writeRangeParts(mw *multipart.Writer, body io.ReaderAt, sz int64, contentType string, ranges []*ObjectRange) error {
+	for _, rnge := range ranges {
+		hdr := textproto.MIMEHeader{}
+		if contentType != "" {
+			hdr.Set("Content-Type", contentType)
+		}
+		hdr.Set("Content-Range", rnge.contentRange(sz))
+
+		part, err := mw.CreatePart(hdr)
+		if err != nil {
+			return err
+		}
+		if body != nil {
+			if _, err := copyContents(part, io.NewSectionReader(body, rnge.Start, rnge.Length)); err != nil {
+				return err
+			}
+		}
+	}
+	return mw.Close()
 }
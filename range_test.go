@@ -23,13 +23,16 @@ func TestRangeRequest(t *testing.T) {
 		{rev: true, inend: 10, sz: 10, outst: 0, outln: 10},
 		{rev: true, inend: 5, sz: 10, outst: 5, outln: 5},
 
+		// A suffix longer than the object selects all of it:
+		{rev: true, inend: 11, sz: 10, outst: 0, outln: 10},
+		{rev: true, inend: 20, sz: 10, outst: 0, outln: 10},
+
 		{fail: true, inst: 0, inend: 0, sz: 0},
 		{fail: true, inst: 1, inend: 1, sz: 1},
 		{fail: true, inst: 10, inend: 15, sz: 10},
 		{fail: true, inst: 40, inend: 50, sz: 11},
-		{fail: true, rev: true, inend: 20, sz: 10},
-		{fail: true, rev: true, inend: 11, sz: 10},
 		{fail: true, rev: true, inend: 0, sz: 10}, // zero suffix-length is not satisfiable
+		{fail: true, rev: true, inend: 5, sz: 0},
 	} {
 		t.Run(fmt.Sprintf("%d", idx), func(t *testing.T) {
 			orr := ObjectRangeRequest{Start: tc.inst, End: tc.inend, FromEnd: tc.rev}
@@ -0,0 +1,74 @@
+package gofakes3
+
+import (
+	"encoding/xml"
+	"net/http"
+)
+
+// From https://docs.aws.amazon.com/AmazonS3/latest/userguide/replication-add-config.html:
+//
+//	"A replication configuration can have up to 1,000 rules."
+const MaxReplicationRules = 1000
+
+// replicationSubresource is the name the ReplicationConfiguration is stored
+// under by a BucketConfigBackend.
+const replicationSubresource = "replication"
+
+func (s ReplicationRuleStatus) valid() bool {
+	return s == ReplicationEnabled || s == ReplicationDisabled
+}
+
+// matches reports whether the rule applies to an object. tags are only
+// fetched if the rule's filter needs them.
+func (rule *ReplicationRule) matches(key string, tags func() map[string]string) bool {
+	var prefix string
+	var wantTags []Tag
+
+	if rule.Prefix != nil {
+		prefix = *rule.Prefix
+	} else if f := rule.Filter; f != nil {
+		switch {
+		case f.Prefix != nil:
+			prefix = *f.Prefix
+		case f.Tag != nil:
+			wantTags = []Tag{*f.Tag}
+		case f.And != nil:
+			prefix, wantTags = f.And.Prefix, f.And.Tags
+		}
+	}
+	return matchesKeyAndTags(key, tags, prefix, wantTags)
+}
+
+// writeReplicationHeader sets the x-amz-replication-status header of a GET or
+// HEAD response to COMPLETED if an enabled rule of the bucket's replication
+// configuration applies to the object. As nothing is replicated, objects are
+// never PENDING or FAILED, and there are no replicas.
+func (g *GoFakeS3) writeReplicationHeader(bucket, object string, versionID VersionID, w http.ResponseWriter) error {
+	if g.configs == nil {
+		return nil
+	}
+	stored, err := g.configs.BucketConfig(bucket, replicationSubresource)
+	if err != nil || stored == nil {
+		return err
+	}
+	var config ReplicationConfiguration
+	if err := xml.Unmarshal(stored, &config); err != nil {
+		return err
+	}
+
+	var tags map[string]string
+	getTags := func() map[string]string {
+		if tags == nil && g.tagging != nil {
+			tags, _ = g.tagging.GetObjectTagging(bucket, object, versionID)
+		}
+		return tags
+	}
+	for i := range config.Rules {
+		rule := &config.Rules[i]
+		if rule.Status == ReplicationEnabled && rule.matches(object, getTags) {
+			w.Header().Set("x-amz-replication-status", "COMPLETED")
+			return nil
+		}
+	}
+	return nil
+}
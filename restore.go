@@ -0,0 +1,166 @@
+package gofakes3
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// restoreDelays is how long a restore of an object in each archived storage
+// class takes at each tier, according to the GoFakeS3 clock. These are the
+// upper bounds of the times S3 documents; tiers that are missing are not
+// supported for the storage class.
+var restoreDelays = map[StorageClass]map[RestoreTier]time.Duration{
+	StorageGlacier: {
+		RestoreTierExpedited: 5 * time.Minute,
+		RestoreTierStandard:  5 * time.Hour,
+		RestoreTierBulk:      12 * time.Hour,
+	},
+	StorageDeepArchive: {
+		RestoreTierStandard: 12 * time.Hour,
+		RestoreTierBulk:     48 * time.Hour,
+	},
+}
+
+// restoreExpiry returns when a copy restored at the given time for a number
+// of days is removed. S3 rounds this up to the following midnight, UTC.
+func restoreExpiry(restored time.Time, days int) time.Time {
+	at := restored.UTC().AddDate(0, 0, days)
+	midnight := time.Date(at.Year(), at.Month(), at.Day(), 0, 0, 0, 0, time.UTC)
+	if midnight.Before(at) {
+		midnight = midnight.AddDate(0, 0, 1)
+	}
+	return midnight
+}
+
+// restoreObject starts a restore of an archived object, which can be read
+// once the restore completes. Requesting a restore of an object that has
+// already been restored extends the time the restored copy is kept for.
+// https://docs.aws.amazon.com/AmazonS3/latest/API/API_RestoreObject.html
+func (g *GoFakeS3) restoreObject(bucket, object string, versionID VersionID, w http.ResponseWriter, r *http.Request) error {
+	g.log.Print(LogInfo, "RESTORE OBJECT:", bucket, object, versionID)
+
+	if g.restore == nil {
+		return ErrNotImplemented
+	}
+
+	var in RestoreRequest
+	if err := g.xmlDecodeBody(r.Body, &in); err != nil {
+		return err
+	}
+	if in.Days < 1 {
+		return ErrorInvalidArgument("Days", strconv.Itoa(in.Days), "Days must be a positive integer")
+	}
+	tier := RestoreTierStandard
+	if in.GlacierJobParameters != nil && in.GlacierJobParameters.Tier != "" {
+		tier = in.GlacierJobParameters.Tier
+	}
+	if tier != RestoreTierExpedited && tier != RestoreTierStandard && tier != RestoreTierBulk {
+		return ErrMalformedXML
+	}
+
+	var obj *Object
+	var err error
+	if versionID == "" {
+		obj, err = g.storage.HeadObject(bucket, object)
+	} else {
+		if g.versioned == nil {
+			return ErrNotImplemented
+		}
+		obj, err = g.versioned.HeadObjectVersion(bucket, object, versionID)
+	}
+	if err != nil {
+		return err
+	}
+	if obj.IsDeleteMarker {
+		return KeyNotFound(object)
+	}
+
+	class := ObjectStorageClass(obj.Metadata)
+	delays, ok := restoreDelays[class]
+	if !ok {
+		return ErrorMessage(ErrInvalidObjectState, "Restore is not allowed for the object's current storage class")
+	}
+	delay, ok := delays[tier]
+	if !ok {
+		return ErrorMessage(ErrInvalidArgument, "The "+string(tier)+" tier is not supported for the "+string(class)+" storage class")
+	}
+
+	existing, err := g.restore.GetObjectRestore(bucket, object, obj.VersionID)
+	if err != nil {
+		return err
+	}
+
+	now := g.timeSource.Now()
+	if existing.Ongoing(now) {
+		return ErrRestoreAlreadyInProgress
+	}
+	if existing.Available(now) {
+		extended := *existing
+		extended.Days = in.Days
+		extended.ExpiresAt = restoreExpiry(now, in.Days)
+		return g.restore.PutObjectRestore(bucket, object, obj.VersionID, extended)
+	}
+
+	completes := now.Add(delay)
+	if err := g.restore.PutObjectRestore(bucket, object, obj.VersionID, ObjectRestore{
+		Tier:        tier,
+		Days:        in.Days,
+		CompletesAt: completes,
+		ExpiresAt:   restoreExpiry(completes, in.Days),
+	}); err != nil {
+		return err
+	}
+
+	w.WriteHeader(http.StatusAccepted)
+	return nil
+}
+
+// objectRestore returns the restore of obj if it is archived, and the
+// Backend supports restores; archived is false if obj can be read without
+// being restored. restore is nil if a restore has never been requested.
+func (g *GoFakeS3) objectRestore(bucket, object string, obj *Object) (restore *ObjectRestore, archived bool, err error) {
+	if g.restore == nil || obj.IsDeleteMarker {
+		return nil, false, nil
+	}
+	if _, ok := restoreDelays[ObjectStorageClass(obj.Metadata)]; !ok {
+		return nil, false, nil
+	}
+	restore, err = g.restore.GetObjectRestore(bucket, object, obj.VersionID)
+	return restore, true, err
+}
+
+// checkRestored returns ErrInvalidObjectState if obj is archived, and the
+// restored copy of it can not be read.
+func (g *GoFakeS3) checkRestored(bucket, object string, obj *Object) error {
+	restore, archived, err := g.objectRestore(bucket, object, obj)
+	if err != nil {
+		return err
+	} else if archived && !restore.Available(g.timeSource.Now()) {
+		return ErrInvalidObjectState
+	}
+	return nil
+}
+
+// writeRestoreHeader sets the x-amz-restore header of a GET or HEAD response
+// for an archived object, if it has been restored or is being restored. If
+// read is true, ErrInvalidObjectState is returned unless the restored copy
+// can be read.
+func (g *GoFakeS3) writeRestoreHeader(bucket, object string, obj *Object, read bool, w http.ResponseWriter) error {
+	restore, archived, err := g.objectRestore(bucket, object, obj)
+	if err != nil || !archived {
+		return err
+	}
+
+	now := g.timeSource.Now()
+	if restore.Ongoing(now) {
+		w.Header().Set("x-amz-restore", `ongoing-request="true"`)
+	} else if restore.Available(now) {
+		w.Header().Set("x-amz-restore", `ongoing-request="false", expiry-date="`+formatHeaderTime(restore.ExpiresAt)+`"`)
+	}
+
+	if read && !restore.Available(now) {
+		return ErrInvalidObjectState
+	}
+	return nil
+}
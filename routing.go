@@ -1,10 +1,10 @@
 package gofakes3
 
 import (
-	"encoding/base64"
-	"fmt"
 	"net/http"
+	"net/url"
 	"strings"
+	"time"
 )
 
 // routeBase is a http.HandlerFunc that dispatches top level routes for
@@ -28,18 +28,35 @@ func (g *GoFakeS3) routeBase(w http.ResponseWriter, r *http.Request) {
 		err    error
 	)
 
-	hdr := w.Header()
-
-	id := fmt.Sprintf("%016X", g.nextRequestID())
-	hdr.Set("x-amz-id-2", base64.StdEncoding.EncodeToString([]byte(id+id+id+id))) // x-amz-id-2 is 48 bytes of random stuff
-	hdr.Set("x-amz-request-id", id)
-	hdr.Set("Server", "AmazonS3")
+	start := time.Now()
 
 	if len(parts) == 2 {
 		object = parts[1]
 	}
+	operation := operationName(r, bucket, object, query)
+
+	if g.faults != nil {
+		err = g.faults.inject(r.Context(), operation)
+	}
+	if err == nil && bucket != "" && operation != "CreateBucket" {
+		// Every operation on a bucket, or on one of its objects or
+		// subresources, fails the same way if it does not exist, before the
+		// handler gets to mistake it for a missing object:
+		err = g.ensureBucketExists(bucket, autoBucketWriteOperations[operation])
+	}
+	if err == nil && object != "" {
+		err = g.checkRequestPayer(bucket, w, r)
+	}
 
-	if uploadID := UploadID(query.Get("uploadId")); uploadID != "" {
+	if err != nil {
+		// An injected fault, a missing bucket, or a request for an object in a
+		// Requester Pays bucket that did not agree to pay; the request is not
+		// handled
+
+	} else if op, ok := unimplementedOperation(r, query); ok {
+		err = routeNotImplemented(op)
+
+	} else if uploadID := UploadID(query.Get("uploadId")); uploadID != "" {
 		err = g.routeMultipartUpload(bucket, object, uploadID, w, r)
 
 	} else if _, ok := query["uploads"]; ok {
@@ -51,8 +68,53 @@ func (g *GoFakeS3) routeBase(w http.ResponseWriter, r *http.Request) {
 	} else if _, ok := query["versions"]; ok {
 		err = g.routeVersions(bucket, w, r)
 
-	} else if versionID := versionFromQuery(query["versionId"]); versionID != "" {
-		err = g.routeVersion(bucket, object, VersionID(versionID), w, r)
+	} else if _, ok := query["website"]; ok && object == "" {
+		err = g.routeBucketWebsite(bucket, w, r)
+
+	} else if _, ok := query["policy"]; ok && object == "" {
+		err = g.routeBucketPolicy(bucket, w, r)
+
+	} else if _, ok := query["requestPayment"]; ok && object == "" {
+		err = g.routeBucketRequestPayment(bucket, w, r)
+
+	} else if _, ok := query["cors"]; ok && object == "" {
+		err = g.routeBucketCORS(bucket, w, r)
+
+	} else if _, ok := query["lifecycle"]; ok && object == "" {
+		err = g.routeBucketLifecycle(bucket, w, r)
+
+	} else if sub, ok := idBucketConfigSubresource(query); ok && object == "" {
+		err = g.routeIDBucketConfig(bucket, sub, w, r)
+
+	} else if sub, ok := bucketConfigSubresource(query); ok && object == "" {
+		err = g.routeBucketConfig(bucket, sub, w, r)
+
+	} else if _, ok := query["acl"]; ok && object == "" {
+		err = g.routeBucketACL(bucket, w, r)
+
+	} else if _, ok := query["acl"]; ok && object != "" {
+		err = g.routeObjectACL(bucket, object, g.queryVersionID(query["versionId"]), w, r)
+
+	} else if _, ok := query["tagging"]; ok && object == "" {
+		err = g.routeBucketTagging(bucket, w, r)
+
+	} else if _, ok := query["tagging"]; ok && object != "" {
+		err = g.routeObjectTagging(bucket, object, g.queryVersionID(query["versionId"]), w, r)
+
+	} else if _, ok := query["retention"]; ok && object != "" {
+		err = g.routeObjectRetention(bucket, object, g.queryVersionID(query["versionId"]), w, r)
+
+	} else if _, ok := query["legal-hold"]; ok && object != "" {
+		err = g.routeObjectLegalHold(bucket, object, g.queryVersionID(query["versionId"]), w, r)
+
+	} else if _, ok := query["restore"]; ok && object != "" {
+		err = g.routeObjectRestore(bucket, object, g.queryVersionID(query["versionId"]), w, r)
+
+	} else if _, ok := query["attributes"]; ok && object != "" {
+		err = g.routeObjectAttributes(bucket, object, g.queryVersionID(query["versionId"]), w, r)
+
+	} else if versionID := g.queryVersionID(query["versionId"]); versionID != "" {
+		err = g.routeVersion(bucket, object, versionID, w, r)
 
 	} else if bucket != "" && object != "" {
 		err = g.routeObject(bucket, object, w, r)
@@ -71,6 +133,62 @@ func (g *GoFakeS3) routeBase(w http.ResponseWriter, r *http.Request) {
 	if err != nil {
 		g.httpError(w, r, err)
 	}
+	g.stats.observeOperation(operation)
+	if g.metrics != nil {
+		g.metrics.ObserveOperation(operation, errorCodeOf(err), time.Since(start))
+	}
+}
+
+// autoBucketWriteOperations are the operations that write an object, which
+// are the only ones that create the buckets WithAutoBucketFunc allows. The
+// parts of a multipart upload are left out, as the upload must have been
+// created first.
+var autoBucketWriteOperations = map[string]bool{
+	"CopyObject":            true,
+	"CreateMultipartUpload": true,
+	"PostObject":            true,
+	"PutObject":             true,
+}
+
+// unimplementedSubresources lists the query parameters that select an S3
+// operation GoFakeS3 does not implement, with the operation each request
+// method selects. Without it, a request such as 'GET /bucket?accelerate'
+// would be mistaken for ListObjects.
+//
+// To implement one of these, route it in routeBase and operationName, or add
+// it to bucketConfigs or idBucketConfigs if its configuration only needs to
+// be stored, then remove it from here.
+var unimplementedSubresources = map[string]map[string]string{
+	"encryption":          {"GET": "GetBucketEncryption", "PUT": "PutBucketEncryption", "DELETE": "DeleteBucketEncryption"},
+	"intelligent-tiering": {"GET": "GetBucketIntelligentTieringConfiguration", "PUT": "PutBucketIntelligentTieringConfiguration", "DELETE": "DeleteBucketIntelligentTieringConfiguration"},
+	"object-lock":         {"GET": "GetObjectLockConfiguration", "PUT": "PutObjectLockConfiguration"},
+	"policyStatus":        {"GET": "GetBucketPolicyStatus"},
+	"select":              {"POST": "SelectObjectContent"},
+	"session":             {"GET": "CreateSession"},
+	"torrent":             {"GET": "GetObjectTorrent"},
+}
+
+// unimplementedOperation reports whether the request is for one of the
+// unimplementedSubresources, and returns the name of the operation. If the
+// method does not select one, the name is made up of the method and the
+// subresource.
+func unimplementedOperation(r *http.Request, query url.Values) (operation string, ok bool) {
+	for sub, ops := range unimplementedSubresources {
+		if _, ok := query[sub]; !ok {
+			continue
+		}
+		if op, ok := ops[r.Method]; ok {
+			return op, true
+		}
+		return r.Method + " ?" + sub, true
+	}
+	return "", false
+}
+
+// routeNotImplemented handles every request for an operation GoFakeS3 does not
+// implement.
+func routeNotImplemented(operation string) error {
+	return ErrorMessagef(ErrNotImplemented, "%s is not implemented", operation)
 }
 
 // routeObject oandles URLs that contain both a bucket path segment and an
@@ -155,6 +273,183 @@ func (g *GoFakeS3) routeVersions(bucket string, w http.ResponseWriter, r *http.R
 	}
 }
 
+// routeBucketWebsite operates on routes that contain '?website' in the query
+// string and have only a bucket path segment.
+func (g *GoFakeS3) routeBucketWebsite(bucket string, w http.ResponseWriter, r *http.Request) error {
+	switch r.Method {
+	case "GET":
+		return g.getBucketWebsite(bucket, w, r)
+	case "PUT":
+		return g.putBucketWebsite(bucket, w, r)
+	case "DELETE":
+		return g.deleteBucketWebsite(bucket, w, r)
+	default:
+		return ErrMethodNotAllowed
+	}
+}
+
+// routeBucketRequestPayment operates on routes that contain '?requestPayment'
+// in the query string and have only a bucket path segment.
+func (g *GoFakeS3) routeBucketRequestPayment(bucket string, w http.ResponseWriter, r *http.Request) error {
+	switch r.Method {
+	case "GET":
+		return g.getBucketRequestPayment(bucket, w, r)
+	case "PUT":
+		return g.putBucketRequestPayment(bucket, w, r)
+	default:
+		return ErrMethodNotAllowed
+	}
+}
+
+// routeBucketPolicy operates on routes that contain '?policy' in the query
+// string and have only a bucket path segment.
+func (g *GoFakeS3) routeBucketPolicy(bucket string, w http.ResponseWriter, r *http.Request) error {
+	switch r.Method {
+	case "GET":
+		return g.getBucketPolicy(bucket, w, r)
+	case "PUT":
+		return g.putBucketPolicy(bucket, w, r)
+	case "DELETE":
+		return g.deleteBucketPolicy(bucket, w, r)
+	default:
+		return ErrMethodNotAllowed
+	}
+}
+
+// routeBucketCORS operates on routes that contain '?cors' in the query string
+// and have only a bucket path segment.
+func (g *GoFakeS3) routeBucketCORS(bucket string, w http.ResponseWriter, r *http.Request) error {
+	switch r.Method {
+	case "GET":
+		return g.getBucketCORS(bucket, w, r)
+	case "PUT":
+		return g.putBucketCORS(bucket, w, r)
+	case "DELETE":
+		return g.deleteBucketCORS(bucket, w, r)
+	default:
+		return ErrMethodNotAllowed
+	}
+}
+
+// routeBucketLifecycle operates on routes that contain '?lifecycle' in the
+// query string and have only a bucket path segment.
+func (g *GoFakeS3) routeBucketLifecycle(bucket string, w http.ResponseWriter, r *http.Request) error {
+	switch r.Method {
+	case "GET":
+		return g.getBucketLifecycle(bucket, w, r)
+	case "PUT":
+		return g.putBucketLifecycle(bucket, w, r)
+	case "DELETE":
+		return g.deleteBucketLifecycle(bucket, w, r)
+	default:
+		return ErrMethodNotAllowed
+	}
+}
+
+// routeBucketACL operates on routes that contain '?acl' in the query string
+// and have only a bucket path segment.
+func (g *GoFakeS3) routeBucketACL(bucket string, w http.ResponseWriter, r *http.Request) error {
+	switch r.Method {
+	case "GET":
+		return g.getBucketACL(bucket, w, r)
+	case "PUT":
+		return g.putBucketACL(bucket, w, r)
+	default:
+		return ErrMethodNotAllowed
+	}
+}
+
+// routeObjectACL operates on routes that contain '?acl' in the query string
+// and have both a bucket and an object path segment.
+func (g *GoFakeS3) routeObjectACL(bucket, object string, versionID VersionID, w http.ResponseWriter, r *http.Request) error {
+	switch r.Method {
+	case "GET":
+		return g.getObjectACL(bucket, object, versionID, w, r)
+	case "PUT":
+		return g.putObjectACL(bucket, object, versionID, w, r)
+	default:
+		return ErrMethodNotAllowed
+	}
+}
+
+// routeBucketTagging operates on routes that contain '?tagging' in the query
+// string and have only a bucket path segment.
+func (g *GoFakeS3) routeBucketTagging(bucket string, w http.ResponseWriter, r *http.Request) error {
+	switch r.Method {
+	case "GET":
+		return g.getBucketTagging(bucket, w, r)
+	case "PUT":
+		return g.putBucketTagging(bucket, w, r)
+	case "DELETE":
+		return g.deleteBucketTagging(bucket, w, r)
+	default:
+		return ErrMethodNotAllowed
+	}
+}
+
+// routeObjectTagging operates on routes that contain '?tagging' in the query
+// string and have both a bucket and an object path segment.
+func (g *GoFakeS3) routeObjectTagging(bucket, object string, versionID VersionID, w http.ResponseWriter, r *http.Request) error {
+	switch r.Method {
+	case "GET":
+		return g.getObjectTagging(bucket, object, versionID, w, r)
+	case "PUT":
+		return g.putObjectTagging(bucket, object, versionID, w, r)
+	case "DELETE":
+		return g.deleteObjectTagging(bucket, object, versionID, w, r)
+	default:
+		return ErrMethodNotAllowed
+	}
+}
+
+// routeObjectRetention operates on routes that contain '?retention' in the
+// query string and have both a bucket and an object path segment.
+func (g *GoFakeS3) routeObjectRetention(bucket, object string, versionID VersionID, w http.ResponseWriter, r *http.Request) error {
+	switch r.Method {
+	case "GET":
+		return g.getObjectRetention(bucket, object, versionID, w, r)
+	case "PUT":
+		return g.putObjectRetention(bucket, object, versionID, w, r)
+	default:
+		return ErrMethodNotAllowed
+	}
+}
+
+// routeObjectRestore operates on routes that contain '?restore' in the query
+// string and have both a bucket and an object path segment.
+func (g *GoFakeS3) routeObjectRestore(bucket, object string, versionID VersionID, w http.ResponseWriter, r *http.Request) error {
+	switch r.Method {
+	case "POST":
+		return g.restoreObject(bucket, object, versionID, w, r)
+	default:
+		return ErrMethodNotAllowed
+	}
+}
+
+// routeObjectLegalHold operates on routes that contain '?legal-hold' in the
+// query string and have both a bucket and an object path segment.
+func (g *GoFakeS3) routeObjectLegalHold(bucket, object string, versionID VersionID, w http.ResponseWriter, r *http.Request) error {
+	switch r.Method {
+	case "GET":
+		return g.getObjectLegalHold(bucket, object, versionID, w, r)
+	case "PUT":
+		return g.putObjectLegalHold(bucket, object, versionID, w, r)
+	default:
+		return ErrMethodNotAllowed
+	}
+}
+
+// routeObjectAttributes operates on routes that contain '?attributes' in the
+// query string and have both a bucket and an object path segment.
+func (g *GoFakeS3) routeObjectAttributes(bucket, object string, versionID VersionID, w http.ResponseWriter, r *http.Request) error {
+	switch r.Method {
+	case "GET":
+		return g.getObjectAttributes(bucket, object, versionID, w, r)
+	default:
+		return ErrMethodNotAllowed
+	}
+}
+
 // routeVersion operates on routes that contain '?versionId=<id>' in the
 // query string.
 func (g *GoFakeS3) routeVersion(bucket, object string, versionID VersionID, w http.ResponseWriter, r *http.Request) error {
@@ -198,3 +493,14 @@ func versionFromQuery(qv []string) string {
 	}
 	return ""
 }
+
+// queryVersionID returns the version ID given by the versionId subresource.
+// Unlike versionFromQuery, it passes the 'null' version on to a
+// VersionedBackend, which refers to the version stored while versioning was
+// not enabled on the bucket.
+func (g *GoFakeS3) queryVersionID(qv []string) VersionID {
+	if g.versioned != nil && len(qv) > 0 && qv[0] == "null" {
+		return VersionID(qv[0])
+	}
+	return VersionID(versionFromQuery(qv))
+}
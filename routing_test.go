@@ -1,7 +1,13 @@
 package gofakes3_test
 
 import (
+	"encoding/xml"
+	"net/http"
 	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/johannesboyne/gofakes3"
 )
 
 func TestRoutingSlashes(t *testing.T) {
@@ -30,3 +36,47 @@ func TestRoutingSlashes(t *testing.T) {
 	assertStatus("test/obj/", 200)
 	assertStatus("test/obj//", 200)
 }
+
+func TestRoutingNotImplemented(t *testing.T) {
+	ts := newTestServer(t)
+	defer ts.Close()
+	svc := ts.s3Client()
+
+	_, err := svc.GetBucketEncryption(&s3.GetBucketEncryptionInput{
+		Bucket: aws.String(defaultBucket),
+	})
+	if !hasErrorCode(err, gofakes3.ErrNotImplemented) {
+		t.Fatal("expected ErrNotImplemented, found", err)
+	}
+
+	for _, tc := range []struct {
+		method    string
+		path      string
+		operation string
+	}{
+		{"GET", defaultBucket + "?encryption", "GetBucketEncryption"},
+		{"PUT", defaultBucket + "?intelligent-tiering", "PutBucketIntelligentTieringConfiguration"},
+		{"GET", defaultBucket + "/object?torrent", "GetObjectTorrent"},
+		{"POST", defaultBucket + "/object?select&select-type=2", "SelectObjectContent"},
+		{"DELETE", defaultBucket + "?policyStatus", "DELETE ?policyStatus"},
+	} {
+		t.Run(tc.operation, func(t *testing.T) {
+			rq, err := http.NewRequest(tc.method, ts.url(tc.path), nil)
+			ts.OK(err)
+			rs, err := httpClient().Do(rq)
+			ts.OK(err)
+			defer rs.Body.Close()
+			if rs.StatusCode != http.StatusNotImplemented {
+				t.Fatal("unexpected status", rs.StatusCode)
+			}
+			var errResp gofakes3.ErrorResponse
+			ts.OK(xml.NewDecoder(rs.Body).Decode(&errResp))
+			if errResp.Code != gofakes3.ErrNotImplemented {
+				t.Fatal("unexpected code", errResp.Code)
+			}
+			if expected := tc.operation + " is not implemented"; errResp.Message != expected {
+				t.Fatalf("message %q, expected %q", errResp.Message, expected)
+			}
+		})
+	}
+}
@@ -0,0 +1,97 @@
+package gofakes3
+
+import (
+	"crypto/md5"
+	"encoding/base64"
+	"net/http"
+)
+
+// Headers used for server-side encryption with customer-provided keys
+// (SSE-C). The key itself is never stored; only the algorithm and the MD5 of
+// the key are kept with the object's metadata, which is enough to check that
+// subsequent reads supply the same key.
+//
+// No encryption actually takes place.
+//
+// https://docs.aws.amazon.com/AmazonS3/latest/dev/ServerSideEncryptionCustomerKeys.html
+const (
+	sseCustomerAlgorithmHeader = "X-Amz-Server-Side-Encryption-Customer-Algorithm"
+	sseCustomerKeyHeader       = "X-Amz-Server-Side-Encryption-Customer-Key"
+	sseCustomerKeyMD5Header    = "X-Amz-Server-Side-Encryption-Customer-Key-Md5"
+
+	// The same headers are used with this prefix to supply the key for the
+	// source object of a copy:
+	copySourceHeaderPrefix = "X-Amz-Copy-Source-"
+
+	sseCustomerAlgorithmAES256 = "AES256"
+)
+
+// sseCustomerKey holds the SSE-C parameters supplied with a request.
+type sseCustomerKey struct {
+	Algorithm string
+	KeyMD5    string
+}
+
+// sseCustomerKeyFromHeaders validates the SSE-C headers found in the request.
+// prefix is either empty, or copySourceHeaderPrefix for the copy source
+// variants of the headers.
+//
+// If none of the headers are present, nil is returned.
+func sseCustomerKeyFromHeaders(headers http.Header, prefix string) (*sseCustomerKey, error) {
+	algorithm := headers.Get(prefix + sseCustomerAlgorithmHeader)
+	key := headers.Get(prefix + sseCustomerKeyHeader)
+	keyMD5 := headers.Get(prefix + sseCustomerKeyMD5Header)
+
+	if algorithm == "" && key == "" && keyMD5 == "" {
+		return nil, nil
+	}
+
+	if algorithm == "" {
+		return nil, ErrorInvalidArgument(prefix+sseCustomerAlgorithmHeader, "",
+			"Requests specifying Server Side Encryption with Customer provided keys must provide a valid encryption algorithm.")
+	}
+	if algorithm != sseCustomerAlgorithmAES256 {
+		return nil, ErrorInvalidArgument(prefix+sseCustomerAlgorithmHeader, algorithm,
+			"The encryption method specified is not supported")
+	}
+
+	rawKey, err := base64.StdEncoding.DecodeString(key)
+	if err != nil || len(rawKey) != 32 {
+		return nil, ErrorInvalidArgument(prefix+sseCustomerKeyHeader, "",
+			"The secret key was invalid for the specified algorithm.")
+	}
+
+	sum := md5.Sum(rawKey)
+	if keyMD5 != base64.StdEncoding.EncodeToString(sum[:]) {
+		return nil, ErrorInvalidArgument(prefix+sseCustomerKeyMD5Header, "",
+			"The calculated MD5 hash of the key did not match the hash that was provided.")
+	}
+
+	return &sseCustomerKey{Algorithm: algorithm, KeyMD5: keyMD5}, nil
+}
+
+// checkSSECustomerKey ensures that the SSE-C key supplied with a request
+// matches the one the object was stored with, if any.
+func checkSSECustomerKey(meta map[string]string, headers http.Header, prefix string) error {
+	key, err := sseCustomerKeyFromHeaders(headers, prefix)
+	if err != nil {
+		return err
+	}
+
+	storedMD5 := meta[sseCustomerKeyMD5Header]
+	if storedMD5 == "" {
+		if key != nil {
+			return ErrorMessage(ErrInvalidRequest,
+				"The encryption parameters are not applicable to this object.")
+		}
+		return nil
+	}
+
+	if key == nil || key.KeyMD5 != storedMD5 {
+		return ErrorMessage(ErrInvalidRequest,
+			"The object was stored using a form of Server Side Encryption. "+
+				"The correct parameters must be provided to retrieve the object.")
+	}
+
+	return nil
+}
@@ -0,0 +1,171 @@
+package gofakes3
+
+import (
+	"io"
+	"net/http"
+	"sync"
+	"sync/atomic"
+)
+
+// ServerStats is a snapshot of the activity and contents of a GoFakeS3,
+// returned by GoFakeS3.Stats().
+type ServerStats struct {
+	// Operations counts the requests handled since the GoFakeS3 was created,
+	// keyed by the name of the S3 operation (as passed to
+	// Metrics.ObserveOperation). Requests that failed are counted too.
+	Operations map[string]int64
+
+	// BytesIn and BytesOut are the total number of bytes read from request
+	// bodies and written to response bodies. Headers are not counted.
+	BytesIn  int64
+	BytesOut int64
+
+	// Buckets and Objects are the number of buckets and objects currently in
+	// the Backend. Only the latest version of each object is counted.
+	Buckets int64
+	Objects int64
+
+	// MultipartUploads is the number of multipart uploads that have been
+	// started, but not yet completed or aborted.
+	MultipartUploads int64
+
+	// StorageErr is the error returned by the Backend when the buckets and
+	// objects were listed, in which case Buckets and Objects are zero.
+	StorageErr error
+}
+
+// serverStats holds the counters behind GoFakeS3.Stats(). The int64 fields
+// are updated atomically, and come first so that they are 64-bit aligned on
+// 32-bit platforms.
+type serverStats struct {
+	bytesIn  int64
+	bytesOut int64
+
+	mu         sync.RWMutex
+	operations map[string]*int64
+}
+
+func newServerStats() *serverStats {
+	return &serverStats{operations: make(map[string]*int64)}
+}
+
+func (s *serverStats) observeOperation(operation string) {
+	s.mu.RLock()
+	cnt := s.operations[operation]
+	s.mu.RUnlock()
+
+	if cnt == nil {
+		s.mu.Lock()
+		if cnt = s.operations[operation]; cnt == nil {
+			cnt = new(int64)
+			s.operations[operation] = cnt
+		}
+		s.mu.Unlock()
+	}
+
+	atomic.AddInt64(cnt, 1)
+}
+
+func (s *serverStats) snapshot() ServerStats {
+	stats := ServerStats{
+		BytesIn:  atomic.LoadInt64(&s.bytesIn),
+		BytesOut: atomic.LoadInt64(&s.bytesOut),
+	}
+
+	s.mu.RLock()
+	stats.Operations = make(map[string]int64, len(s.operations))
+	for operation, cnt := range s.operations {
+		stats.Operations[operation] = atomic.LoadInt64(cnt)
+	}
+	s.mu.RUnlock()
+
+	return stats
+}
+
+// Stats returns the number of requests handled for each operation, the
+// number of bytes transferred, and the number of buckets, objects and
+// multipart uploads there are now.
+//
+// The buckets and objects are counted by listing every bucket in the
+// Backend, which can be slow if it holds a lot of objects.
+func (g *GoFakeS3) Stats() ServerStats {
+	stats := g.stats.snapshot()
+	stats.MultipartUploads = g.uploader.Len()
+	stats.Buckets, stats.Objects, stats.StorageErr = storageCounts(g.storage)
+	return stats
+}
+
+func storageCounts(backend Backend) (buckets, objects int64, err error) {
+	infos, err := backend.ListBuckets()
+	if err != nil {
+		return 0, 0, err
+	}
+
+	for _, info := range infos {
+		var page ListBucketPage
+		for {
+			list, err := backend.ListBucket(info.Name, &Prefix{}, page)
+			if HasErrorCode(err, ErrNoSuchBucket) {
+				break // Deleted since it was listed
+			} else if err != nil {
+				return 0, 0, err
+			}
+			objects += int64(len(list.Contents))
+
+			// Backends are not required to supply a NextMarker if there is no
+			// delimiter, in which case the last key is the next marker:
+			next := list.NextMarker
+			if next == "" && len(list.Contents) > 0 {
+				next = list.Contents[len(list.Contents)-1].Key
+			}
+			if !list.IsTruncated || next == "" {
+				break
+			}
+			page = ListBucketPage{Marker: next, HasMarker: true}
+		}
+		buckets++
+	}
+
+	return buckets, objects, nil
+}
+
+// statsMiddleware counts the bytes read from each request body and written
+// to each response body.
+func (g *GoFakeS3) statsMiddleware(handler http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, rq *http.Request) {
+		if rq.Body != nil && rq.Body != http.NoBody {
+			rq.Body = &countingReadCloser{ReadCloser: rq.Body, n: &g.stats.bytesIn}
+		}
+		handler.ServeHTTP(&countingResponseWriter{ResponseWriter: w, n: &g.stats.bytesOut}, rq)
+	})
+}
+
+type countingReadCloser struct {
+	io.ReadCloser
+	n *int64
+}
+
+func (c *countingReadCloser) Read(p []byte) (n int, err error) {
+	n, err = c.ReadCloser.Read(p)
+	atomic.AddInt64(c.n, int64(n))
+	return n, err
+}
+
+type countingResponseWriter struct {
+	http.ResponseWriter
+	n *int64
+}
+
+func (c *countingResponseWriter) Write(p []byte) (n int, err error) {
+	n, err = c.ResponseWriter.Write(p)
+	atomic.AddInt64(c.n, int64(n))
+	return n, err
+}
+
+// Flush passes on to the underlying ResponseWriter if it supports it, so that
+// wrapping it does not stop responses from being streamed.
+func (c *countingResponseWriter) Flush() {
+	if f, ok := c.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
@@ -1,6 +1,9 @@
 package gofakes3
 
-import "time"
+import (
+	"sync"
+	"time"
+)
 
 type TimeSource interface {
 	Now() time.Time
@@ -13,7 +16,8 @@ type TimeSourceAdvancer interface {
 }
 
 // FixedTimeSource provides a source of time that always returns the
-// specified time.
+// specified time, until it is moved forward with Advance. Tests can share it
+// between a GoFakeS3 and its Backend to control every date they see.
 func FixedTimeSource(at time.Time) TimeSourceAdvancer {
 	return &fixedTimeSource{time: at}
 }
@@ -42,17 +46,22 @@ func (l *locatedTimeSource) Since(t time.Time) time.Duration {
 }
 
 type fixedTimeSource struct {
+	mu   sync.Mutex
 	time time.Time
 }
 
 func (l *fixedTimeSource) Now() time.Time {
+	l.mu.Lock()
+	defer l.mu.Unlock()
 	return l.time
 }
 
 func (l *fixedTimeSource) Since(t time.Time) time.Duration {
-	return l.time.Sub(t)
+	return l.Now().Sub(t)
 }
 
 func (l *fixedTimeSource) Advance(by time.Duration) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
 	l.time = l.time.Add(by)
 }
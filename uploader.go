@@ -1,11 +1,14 @@
 package gofakes3
 
 import (
-	"crypto/md5"
-	"encoding/hex"
+	"bytes"
+	"encoding/base64"
 	"fmt"
+	"hash"
+	"io"
 	"math/big"
 	"net/url"
+	"sort"
 	"strings"
 	"sync"
 	"time"
@@ -128,25 +131,15 @@ func (bu *bucketUploads) remove(uploadID UploadID) {
 
 // uploader manages multipart uploads.
 //
-// Multipart upload support has the following rather severe limitations (which
-// will hopefully be addressed in the future):
-//
-//	- uploads do not interface with the Backend, so they do not
-// 	  currently persist across reboots
-//
-//	- upload parts are held in memory, so if you want to upload something huge
-//	  in multiple parts (which is pretty much exactly what you'd want multipart
-//	  uploads for), you'll need to make sure your memory is also sufficiently
-//	  huge!
-//
-// At this stage, the current thinking would be to add a second optional
-// Backend interface that allows persistent operations on multipart upload
-// data, and if a Backend does not implement it, this limited in-memory
-// behaviour can be the fallback. If that can be made to work, it would provide
-// good convenience for Backend implementers if their use case did not require
-// persistent multipart upload handling, or it could be satisfied by this
-// naive implementation.
+// The uploads themselves, and the ETag, size and checksum of each part, are
+// only held in memory, so uploads do not persist across reboots unless the
+// Backend is a MultipartStateBackend.
 //
+// The contents of the parts are stored in the Backend if it implements
+// MultipartBackend. Otherwise, they are held in memory too, so if you want to
+// upload something huge in multiple parts (which is pretty much exactly what
+// you'd want multipart uploads for), you'll need to make sure your memory is
+// also sufficiently huge!
 type uploader struct {
 	// uploadIDs use a big.Int to allow unbounded IDs (not that you'd be
 	// expected to ever generate 4.2 billion of these but who are we to judge?)
@@ -163,18 +156,19 @@ func newUploader() *uploader {
 	}
 }
 
-func (u *uploader) Begin(bucket, object string, meta map[string]string, initiated time.Time) *multipartUpload {
+func (u *uploader) Begin(bucket, object string, meta map[string]string, initiated time.Time, alg checksumAlgorithm) *multipartUpload {
 	u.mu.Lock()
 	defer u.mu.Unlock()
 
 	u.uploadID.Add(u.uploadID, add1)
 
 	mpu := &multipartUpload{
-		ID:        UploadID(u.uploadID.String()),
-		Bucket:    bucket,
-		Object:    object,
-		Meta:      meta,
-		Initiated: initiated,
+		ID:                UploadID(u.uploadID.String()),
+		Bucket:            bucket,
+		Object:            object,
+		Meta:              meta,
+		Initiated:         initiated,
+		ChecksumAlgorithm: alg,
 	}
 
 	// FIXME: make sure the uploader responds to DeleteBucket
@@ -204,27 +198,35 @@ func (u *uploader) ListParts(bucket, object string, uploadID UploadID, marker in
 		UploadID:         uploadID,
 		MaxParts:         limit,
 		PartNumberMarker: marker,
-		StorageClass:     "STANDARD", // FIXME
+		StorageClass:     ObjectStorageClass(mpu.Meta),
 	}
 
+	// The marker is exclusive: listing begins with the first part whose
+	// number is greater than the marker. The index into mpu.parts is the part
+	// number, so we walk the slice directly rather than reslicing it.
 	var cnt int64
-	for partNumber, part := range mpu.parts[marker:] {
+	for partNumber := marker + 1; partNumber < len(mpu.parts); partNumber++ {
+		part := mpu.parts[partNumber]
 		if part == nil {
 			continue
 		}
 
 		if cnt >= limit {
 			result.IsTruncated = true
-			result.NextPartNumberMarker = partNumber
 			break
 		}
 
-		result.Parts = append(result.Parts, ListMultipartUploadPartItem{
+		item := ListMultipartUploadPartItem{
 			ETag:         part.ETag,
-			Size:         int64(len(part.Body)),
+			Size:         part.Size,
 			PartNumber:   partNumber,
 			LastModified: part.LastModified,
-		})
+		}
+		if part.Checksum != nil {
+			item.set(mpu.ChecksumAlgorithm, base64.StdEncoding.EncodeToString(part.Checksum))
+		}
+		result.Parts = append(result.Parts, item)
+		result.NextPartNumberMarker = partNumber
 
 		cnt++
 	}
@@ -238,7 +240,9 @@ func (u *uploader) List(bucket string, marker *UploadListMarker, prefix Prefix,
 
 	bucketUploads, ok := u.buckets[bucket]
 	if !ok {
-		return nil, ErrNoSuchUpload
+		// Nothing has ever been uploaded to this bucket, which is not an
+		// error; the page is simply empty:
+		bucketUploads = newBucketUploads()
 	}
 
 	var result = ListMultipartUploadsResult{
@@ -284,6 +288,14 @@ func (u *uploader) List(bucket string, marker *UploadListMarker, prefix Prefix,
 		}
 
 		if !firstFound {
+			if object != marker.Object {
+				// The upload ID marker was not found under the key marker (the
+				// upload may have been completed or aborted since the previous
+				// page), so the page starts at the next key instead:
+				firstFound = true
+				goto retry
+			}
+
 			for idx, mpu := range uploads {
 				if mpu.ID == marker.UploadID {
 					firstFound = true
@@ -302,7 +314,7 @@ func (u *uploader) List(bucket string, marker *UploadListMarker, prefix Prefix,
 			} else {
 				for idx, upload := range uploads {
 					result.Uploads = append(result.Uploads, ListMultipartUploadItem{
-						StorageClass: "STANDARD", // FIXME
+						StorageClass: ObjectStorageClass(upload.Meta),
 						Key:          object,
 						UploadID:     upload.ID,
 						Initiated:    ContentTime{Time: upload.Initiated},
@@ -328,7 +340,7 @@ done:
 	if !truncated {
 		for iter.Next() {
 			object := iter.Key().(string)
-			if matched := prefix.Match(object, &match); matched && !match.CommonPrefix {
+			if matched := prefix.Match(object, &match); matched && (!match.CommonPrefix || !seenPrefixes[match.MatchedPart]) {
 				truncated = true
 
 				// This is not especially defensive; it assumes the rest of the code works
@@ -359,6 +371,140 @@ func (u *uploader) Complete(bucket, object string, id UploadID) (*multipartUploa
 	return up, nil
 }
 
+// AbortInitiatedBefore removes every upload that was initiated before the
+// given time, discarding the parts held in memory. The removed uploads are returned in the
+// order they were initiated.
+func (u *uploader) AbortInitiatedBefore(before time.Time) (aborted []*multipartUpload) {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+
+	for _, bucketUploads := range u.buckets {
+		for id, mpu := range bucketUploads.uploads {
+			if mpu.Initiated.Before(before) {
+				bucketUploads.remove(id)
+				aborted = append(aborted, mpu)
+			}
+		}
+	}
+	for _, mpu := range aborted {
+		mpu.discard()
+	}
+
+	sort.Slice(aborted, func(i, j int) bool {
+		if !aborted[i].Initiated.Equal(aborted[j].Initiated) {
+			return aborted[i].Initiated.Before(aborted[j].Initiated)
+		}
+		return aborted[i].ID < aborted[j].ID
+	})
+	return aborted
+}
+
+// Len returns the number of uploads in progress, in every bucket.
+func (u *uploader) Len() (n int64) {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+
+	for _, bucketUploads := range u.buckets {
+		n += int64(len(bucketUploads.uploads))
+	}
+	return n
+}
+
+// Uploads implements MultipartUploads.
+func (u *uploader) Uploads() []MultipartUploadState {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+
+	buckets := make([]string, 0, len(u.buckets))
+	for bucket := range u.buckets {
+		buckets = append(buckets, bucket)
+	}
+	sort.Strings(buckets)
+
+	var states []MultipartUploadState
+	for _, bucket := range buckets {
+		iter := u.buckets[bucket].objectIndex.Iterator()
+		for iter.Next() {
+			for _, mpu := range iter.Value().([]*multipartUpload) {
+				states = append(states, mpu.state())
+			}
+		}
+		iter.Close()
+	}
+	return states
+}
+
+// Restore implements MultipartUploads. The upload IDs given to new uploads
+// continue after the largest of the restored ones.
+func (u *uploader) Restore(uploads []MultipartUploadState) error {
+	buckets := make(map[string]*bucketUploads)
+	maxID := new(big.Int)
+
+	for _, state := range uploads {
+		if err := state.Validate(); err != nil {
+			return err
+		}
+		mpu := &multipartUpload{
+			ID:                state.ID,
+			Bucket:            state.Bucket,
+			Object:            state.Object,
+			Meta:              state.Meta,
+			Initiated:         state.Initiated,
+			ChecksumAlgorithm: checksumAlgorithm(state.ChecksumAlgorithm),
+		}
+		for _, part := range state.Parts {
+			mpu.setPart(&multipartUploadPart{
+				PartNumber:   part.PartNumber,
+				ETag:         part.ETag,
+				Size:         part.Size,
+				LastModified: NewContentTime(part.LastModified),
+				Checksum:     part.Checksum,
+			})
+		}
+
+		bucketUploads := buckets[state.Bucket]
+		if bucketUploads == nil {
+			bucketUploads = newBucketUploads()
+			buckets[state.Bucket] = bucketUploads
+		}
+		if bucketUploads.uploads[state.ID] != nil {
+			return fmt.Errorf("gofakes3: duplicate upload %q in bucket %q", state.ID, state.Bucket)
+		}
+		bucketUploads.add(mpu)
+
+		if id, ok := new(big.Int).SetString(string(state.ID), 10); ok && id.Cmp(maxID) > 0 {
+			maxID = id
+		}
+	}
+
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	u.buckets = buckets
+	if maxID.Cmp(u.uploadID) > 0 {
+		u.uploadID = maxID
+	}
+	return nil
+}
+
+// Validate returns an error if the upload could not be restored with
+// MultipartUploads.Restore.
+func (state MultipartUploadState) Validate() error {
+	if state.ID == "" || state.Bucket == "" || state.Object == "" {
+		return fmt.Errorf("gofakes3: upload %q has no ID, bucket or object", state.ID)
+	}
+	if state.ChecksumAlgorithm != "" {
+		if alg, err := parseChecksumAlgorithm(state.ChecksumAlgorithm); err != nil || string(alg) != state.ChecksumAlgorithm {
+			return fmt.Errorf("gofakes3: upload %q has unknown checksum algorithm %q", state.ID, state.ChecksumAlgorithm)
+		}
+	}
+	for _, part := range state.Parts {
+		if part.PartNumber < 1 || part.PartNumber > MaxUploadPartNumber {
+			return fmt.Errorf("gofakes3: upload %q has invalid part number %d", state.ID, part.PartNumber)
+		}
+	}
+	return nil
+}
+
 func (u *uploader) Get(bucket, object string, id UploadID) (mu *multipartUpload, err error) {
 	u.mu.Lock()
 	defer u.mu.Unlock()
@@ -420,8 +566,14 @@ func uploadListMarkerFromQuery(q url.Values) *UploadListMarker {
 type multipartUploadPart struct {
 	PartNumber   int
 	ETag         string
-	Body         []byte
+	Size         int64
 	LastModified ContentTime
+
+	// Body is nil if the part is stored in a MultipartBackend.
+	Body []byte
+
+	// Checksum is only set if the upload has a ChecksumAlgorithm.
+	Checksum []byte
 }
 
 type multipartUpload struct {
@@ -431,6 +583,11 @@ type multipartUpload struct {
 	Meta      map[string]string
 	Initiated time.Time
 
+	// ChecksumAlgorithm is chosen when the upload is initiated, and is used to
+	// calculate the checksum of each part and the composite checksum of the
+	// completed object. It may be empty.
+	ChecksumAlgorithm checksumAlgorithm
+
 	// Part numbers are limited in S3 to 10,000, so we can be a little wasteful.
 	// If a new part number is added, the slice is grown to that size. Depending
 	// on how bad the input is, this could mean you have a 10,000 element slice
@@ -448,71 +605,278 @@ type multipartUpload struct {
 	mu sync.Mutex
 }
 
-func (mpu *multipartUpload) AddPart(partNumber int, at time.Time, body []byte) (etag string, err error) {
-	if partNumber > MaxUploadPartNumber {
-		return "", ErrInvalidPart
+// state returns the MultipartUploadState of the upload.
+func (mpu *multipartUpload) state() MultipartUploadState {
+	mpu.mu.Lock()
+	defer mpu.mu.Unlock()
+
+	state := MultipartUploadState{
+		ID:                mpu.ID,
+		Bucket:            mpu.Bucket,
+		Object:            mpu.Object,
+		Meta:              mpu.Meta,
+		Initiated:         mpu.Initiated,
+		ChecksumAlgorithm: string(mpu.ChecksumAlgorithm),
+	}
+	for _, part := range mpu.parts {
+		if part == nil {
+			continue
+		}
+		state.Parts = append(state.Parts, MultipartPartState{
+			PartNumber:   part.PartNumber,
+			ETag:         part.ETag,
+			Size:         part.Size,
+			LastModified: part.LastModified.Time,
+			Checksum:     part.Checksum,
+		})
 	}
+	return state
+}
 
+// discard releases the parts of an upload that has been aborted. A request
+// that is adding a part may still hold a reference to the upload, so the
+// parts are dropped here rather than when the upload is garbage collected.
+func (mpu *multipartUpload) discard() {
 	mpu.mu.Lock()
 	defer mpu.mu.Unlock()
+	mpu.parts = nil
+}
+
+// AddPart adds or replaces the part with the given number, using etagFunc
+// to compute its ETag. The part is returned so that its ETag and checksum can
+// be reported.
+func (mpu *multipartUpload) AddPart(partNumber int, at time.Time, body []byte, etagFunc ETagFunc) (added *multipartUploadPart, err error) {
+	if partNumber > MaxUploadPartNumber {
+		return nil, ErrInvalidPart
+	}
 
 	// What the ETag actually is is not specified, so let's just invent any old thing
 	// from guaranteed unique input:
-	hash := md5.New()
-	hash.Write([]byte(body))
-	etag = fmt.Sprintf(`"%s"`, hex.EncodeToString(hash.Sum(nil)))
+	partETag, err := etagFunc(bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
 
-	part := multipartUploadPart{
+	part := &multipartUploadPart{
 		PartNumber:   partNumber,
 		Body:         body,
-		ETag:         etag,
+		Size:         int64(len(body)),
+		ETag:         `"` + partETag + `"`,
 		LastModified: NewContentTime(at),
 	}
-	if partNumber >= len(mpu.parts) {
-		mpu.parts = append(mpu.parts, make([]*multipartUploadPart, partNumber-len(mpu.parts)+1)...)
+	if mpu.ChecksumAlgorithm != "" {
+		part.Checksum = mpu.ChecksumAlgorithm.sum(body)
 	}
-	mpu.parts[partNumber] = &part
-	return etag, nil
+	mpu.setPart(part)
+	return part, nil
 }
 
-func (mpu *multipartUpload) Reassemble(input *CompleteMultipartUploadRequest) (body []byte, etag string, err error) {
+// setPart adds or replaces the part with the same number as part.
+func (mpu *multipartUpload) setPart(part *multipartUploadPart) {
+	mpu.mu.Lock()
+	defer mpu.mu.Unlock()
+
+	if part.PartNumber >= len(mpu.parts) {
+		mpu.parts = append(mpu.parts, make([]*multipartUploadPart, part.PartNumber-len(mpu.parts)+1)...)
+	}
+	mpu.parts[part.PartNumber] = part
+}
+
+// assembledUpload describes the object created from the parts of a multipart
+// upload by Reassemble.
+type assembledUpload struct {
+	Size int64
+
+	// ETag is not the MD5 hash of the body, but the hash of the parts' hashes
+	// followed by the number of parts, as S3 does:
+	// "<md5(md5(p1)+md5(p2)...)>-N". It is not quoted.
+	ETag string
+
+	// Checksum is the composite checksum of the parts, if the upload has a
+	// ChecksumAlgorithm.
+	Checksum string
+
+	// Parts holds the size and checksum of each part for
+	// GetObjectAttributes, in order.
+	Parts []objectPart
+
+	// Body reads the concatenated parts. It is empty if the parts are stored
+	// in a MultipartBackend.
+	Body io.Reader
+}
+
+// Reassemble validates the parts listed in the CompleteMultipartUpload request
+// and returns the object they make up. The ETag is computed with etagFunc,
+// which should be the one used to add the parts.
+//
+// Every part but the last must be at least minPartSize bytes.
+func (mpu *multipartUpload) Reassemble(input *CompleteMultipartUploadRequest, etagFunc ETagFunc, minPartSize int64) (*assembledUpload, error) {
 	mpu.mu.Lock()
 	defer mpu.mu.Unlock()
 
 	mpuPartsLen := len(mpu.parts)
 
+	if len(input.Parts) == 0 {
+		return nil, ErrMalformedXML
+	}
+
 	// FIXME: what does AWS do when mpu.Parts > input.Parts? Presumably you may
 	// end up uploading more parts than you need to assemble, so it should
 	// probably just ignore that?
 	if len(input.Parts) > mpuPartsLen {
-		return nil, "", ErrInvalidPart
+		return nil, ErrInvalidPart
 	}
 
 	if !input.partsAreSorted() {
-		return nil, "", ErrInvalidPartOrder
+		return nil, ErrInvalidPartOrder
 	}
 
-	var size int64
-
-	for _, inPart := range input.Parts {
-		if inPart.PartNumber >= mpuPartsLen || mpu.parts[inPart.PartNumber] == nil {
-			return nil, "", ErrorMessagef(ErrInvalidPart, "unexpected part number %d in complete request", inPart.PartNumber)
+	for i, inPart := range input.Parts {
+		if inPart.PartNumber < 1 || inPart.PartNumber >= mpuPartsLen || mpu.parts[inPart.PartNumber] == nil {
+			return nil, ErrorMessagef(ErrInvalidPart, "unexpected part number %d in complete request", inPart.PartNumber)
 		}
 
 		upPart := mpu.parts[inPart.PartNumber]
 		if strings.Trim(inPart.ETag, "\"") != strings.Trim(upPart.ETag, "\"") {
-			return nil, "", ErrorMessagef(ErrInvalidPart, "unexpected part etag for number %d in complete request", inPart.PartNumber)
+			return nil, ErrorMessagef(ErrInvalidPart, "unexpected part etag for number %d in complete request", inPart.PartNumber)
+		}
+		if upPart.Checksum != nil {
+			if v := inPart.get(mpu.ChecksumAlgorithm); v != "" && v != base64.StdEncoding.EncodeToString(upPart.Checksum) {
+				return nil, ErrorMessagef(ErrInvalidPart, "unexpected part checksum for number %d in complete request", inPart.PartNumber)
+			}
 		}
 
-		size += int64(len(upPart.Body))
+		if i < len(input.Parts)-1 && upPart.Size < minPartSize {
+			return nil, ErrorEntityTooSmall(inPart.PartNumber, upPart.ETag, upPart.Size, minPartSize)
+		}
 	}
 
-	body = make([]byte, 0, size)
+	var assembled assembledUpload
+	bodies := make([]io.Reader, 0, len(input.Parts))
+	partETags := make([]string, 0, len(input.Parts))
+	assembled.Parts = make([]objectPart, 0, len(input.Parts))
 	for _, part := range input.Parts {
-		body = append(body, mpu.parts[part.PartNumber].Body...)
+		upPart := mpu.parts[part.PartNumber]
+		assembled.Size += upPart.Size
+		bodies = append(bodies, bytes.NewReader(upPart.Body))
+		partETags = append(partETags, strings.Trim(upPart.ETag, `"`))
+
+		objPart := objectPart{PartNumber: part.PartNumber, Size: upPart.Size}
+		if upPart.Checksum != nil {
+			objPart.Checksum = base64.StdEncoding.EncodeToString(upPart.Checksum)
+		}
+		assembled.Parts = append(assembled.Parts, objPart)
+	}
+	assembled.Body = io.MultiReader(bodies...)
+
+	var err error
+	if assembled.ETag, err = multipartETag(etagFunc, partETags); err != nil {
+		return nil, err
+	}
+
+	if mpu.ChecksumAlgorithm != "" {
+		sums := make([][]byte, 0, len(input.Parts))
+		for _, part := range input.Parts {
+			sums = append(sums, mpu.parts[part.PartNumber].Checksum)
+		}
+		assembled.Checksum = mpu.ChecksumAlgorithm.composite(sums)
+	}
+
+	return &assembled, nil
+}
+
+// putPart reads a part of exactly size bytes from input and adds it to
+// upload. If the Backend is a MultipartBackend, the part is streamed to it
+// and only its ETag and checksum are kept; otherwise it is held in memory.
+func (g *GoFakeS3) putPart(upload *multipartUpload, partNumber int, at time.Time, input io.Reader, size int64) (*multipartUploadPart, error) {
+	if g.multipart == nil {
+		body, err := ReadAll(input, size)
+		if err != nil {
+			return nil, err
+		}
+		return upload.AddPart(partNumber, at, body, g.etagFunc())
+	}
+
+	if partNumber > MaxUploadPartNumber {
+		return nil, ErrInvalidPart
 	}
 
-	hash := fmt.Sprintf("%x", md5.Sum(body))
+	// The ETag and checksum are computed as the part is read by the Backend,
+	// which only stores the part if all of it was read successfully:
+	rdr := io.Reader(&exactSizeReader{r: input, size: size})
+	var sum hash.Hash
+	if upload.ChecksumAlgorithm != "" {
+		sum = upload.ChecksumAlgorithm.newHash()
+		rdr = io.TeeReader(rdr, sum)
+	}
+	etag := newETagReader(rdr, g.etagFunc())
 
-	return body, hash, nil
+	err := g.multipart.PutMultipartPart(upload.Bucket, upload.ID, partNumber, etag, size)
+	partETag, etagErr := etag.finish(err)
+	if err != nil {
+		return nil, err
+	} else if etagErr != nil {
+		return nil, etagErr
+	}
+
+	part := &multipartUploadPart{
+		PartNumber:   partNumber,
+		Size:         size,
+		ETag:         `"` + partETag + `"`,
+		LastModified: NewContentTime(at),
+	}
+	if sum != nil {
+		part.Checksum = sum.Sum(nil)
+	}
+	upload.setPart(part)
+	return part, nil
+}
+
+// discardUpload releases the parts of an upload that has been aborted,
+// including any stored in a MultipartBackend.
+func (g *GoFakeS3) discardUpload(upload *multipartUpload) error {
+	upload.discard()
+	if g.multipart != nil {
+		return g.multipart.AbortMultipart(upload.Bucket, upload.ID)
+	}
+	return nil
+}
+
+func (g *GoFakeS3) runMultipartSweeper(age time.Duration) {
+	defer g.inFlight.Done()
+
+	interval := age
+	if interval < time.Second {
+		interval = time.Second
+	} else if interval > time.Minute {
+		interval = time.Minute
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-g.stopSweep:
+			return
+		case <-ticker.C:
+			g.AbortMultipartUploadsOlderThan(age)
+		}
+	}
+}
+
+// AbortMultipartUploadsOlderThan aborts every multipart upload that was
+// initiated more than age ago, according to the server's TimeSource, and
+// returns the number of uploads aborted. Their parts are discarded, as they
+// are by AbortMultipartUpload.
+//
+// Use WithMultipartExpiry to abort old uploads in the background instead.
+func (g *GoFakeS3) AbortMultipartUploadsOlderThan(age time.Duration) int {
+	aborted := g.uploader.AbortInitiatedBefore(g.timeSource.Now().Add(-age))
+	for _, mpu := range aborted {
+		g.log.Print(LogInfo, "abort expired multipart upload", mpu.Bucket, mpu.Object, mpu.ID)
+		if err := g.discardUpload(mpu); err != nil {
+			g.log.Print(LogErr, "abort expired multipart upload failed:", mpu.Bucket, mpu.Object, mpu.ID, err)
+		}
+	}
+	return len(aborted)
 }
@@ -1,10 +1,27 @@
 package gofakes3_test
 
 import (
+	"bytes"
+	"crypto/sha1"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"log"
+	"math"
+	"math/rand"
+	"net/http"
+	"net/url"
+	"reflect"
+	"strings"
 	"testing"
+	"time"
 
+	"github.com/aws/aws-sdk-go/aws"
 	"github.com/aws/aws-sdk-go/service/s3"
 	"github.com/johannesboyne/gofakes3"
+	"github.com/johannesboyne/gofakes3/backend/s3mem"
 )
 
 func TestMultipartUpload(t *testing.T) {
@@ -41,6 +58,379 @@ func TestMultipartUpload(t *testing.T) {
 	}
 }
 
+func TestMultipartUploadWithoutMultipartBackend(t *testing.T) {
+	// Hiding the optional interfaces of s3mem makes GoFakeS3 hold the parts
+	// in memory instead of storing them in the Backend:
+	ts := newTestServer(t, withBackend(struct{ gofakes3.Backend }{s3mem.New()}))
+	defer ts.Close()
+
+	body := randomFileBody(2*defaultUploadPartSize + 1)
+	ts.assertMultipartUpload(defaultBucket, "uploadtest", body, nil)
+
+	id := ts.createMultipartUpload(defaultBucket, "aborted", nil)
+	ts.uploadPart(defaultBucket, "aborted", id, 1, []byte("hello"))
+	ts.assertAbortMultipartUpload(defaultBucket, "aborted", gofakes3.UploadID(id))
+}
+
+func TestMultipartUploadChecksum(t *testing.T) {
+	ts := newTestServer(t)
+	defer ts.Close()
+	svc := ts.s3Client()
+
+	sha1Sum := func(b []byte) []byte {
+		sum := sha1.Sum(b)
+		return sum[:]
+	}
+
+	createReq, created := svc.CreateMultipartUploadRequest(&s3.CreateMultipartUploadInput{
+		Bucket: aws.String(defaultBucket),
+		Key:    aws.String("object"),
+	})
+	createReq.HTTPRequest.Header.Set("x-amz-checksum-algorithm", "SHA1")
+	ts.OK(createReq.Send())
+
+	parts := [][]byte{
+		bytes.Repeat([]byte("a"), 5*1024*1024),
+		[]byte("tail"),
+	}
+
+	var completed []*s3.CompletedPart
+	var sums []byte
+	for i, body := range parts {
+		sum := base64.StdEncoding.EncodeToString(sha1Sum(body))
+		req, out := svc.UploadPartRequest(&s3.UploadPartInput{
+			Bucket:     aws.String(defaultBucket),
+			Key:        aws.String("object"),
+			UploadId:   created.UploadId,
+			PartNumber: aws.Int64(int64(i + 1)),
+			Body:       bytes.NewReader(body),
+		})
+		req.HTTPRequest.Header.Set("x-amz-checksum-sha1", sum)
+		ts.OK(req.Send())
+		if v := req.HTTPResponse.Header.Get("x-amz-checksum-sha1"); v != sum {
+			t.Fatal("unexpected part checksum", v)
+		}
+
+		completed = append(completed, &s3.CompletedPart{ETag: out.ETag, PartNumber: aws.Int64(int64(i + 1))})
+		sums = append(sums, sha1Sum(body)...)
+	}
+
+	// A part checksum using a different algorithm to the upload's is refused:
+	req, _ := svc.UploadPartRequest(&s3.UploadPartInput{
+		Bucket:     aws.String(defaultBucket),
+		Key:        aws.String("object"),
+		UploadId:   created.UploadId,
+		PartNumber: aws.Int64(3),
+		Body:       bytes.NewReader([]byte("nope")),
+	})
+	sha := sha256.Sum256([]byte("nope"))
+	req.HTTPRequest.Header.Set("x-amz-checksum-sha256", base64.StdEncoding.EncodeToString(sha[:]))
+	if err := req.Send(); !s3HasErrorCode(err, gofakes3.ErrInvalidRequest) {
+		t.Fatal("expected InvalidRequest, found", err)
+	}
+
+	_, err := svc.CompleteMultipartUpload(&s3.CompleteMultipartUploadInput{
+		Bucket:          aws.String(defaultBucket),
+		Key:             aws.String("object"),
+		UploadId:        created.UploadId,
+		MultipartUpload: &s3.CompletedMultipartUpload{Parts: completed},
+	})
+	ts.OK(err)
+
+	expected := base64.StdEncoding.EncodeToString(sha1Sum(sums)) + "-2"
+	headReq, _ := svc.HeadObjectRequest(&s3.HeadObjectInput{
+		Bucket: aws.String(defaultBucket),
+		Key:    aws.String("object"),
+	})
+	ts.OK(headReq.Send())
+	if v := headReq.HTTPResponse.Header.Get("x-amz-checksum-sha1"); v != expected {
+		t.Fatal("unexpected composite checksum", v, "expected", expected)
+	}
+
+	// The part checksums are returned by GetObjectAttributes:
+	rq, err := http.NewRequest("GET", ts.url("/"+defaultBucket+"/object?attributes"), nil)
+	ts.OK(err)
+	rq.Header.Set("x-amz-object-attributes", "Checksum,ObjectParts")
+	rs, err := httpClient().Do(rq)
+	ts.OK(err)
+	defer rs.Body.Close()
+	var attrs gofakes3.GetObjectAttributesResult
+	ts.OK(xml.NewDecoder(rs.Body).Decode(&attrs))
+	if attrs.Checksum == nil || attrs.Checksum.ChecksumSHA1 != expected {
+		t.Fatal("unexpected checksum attribute", attrs.Checksum)
+	}
+	if attrs.ObjectParts == nil || len(attrs.ObjectParts.Parts) != len(parts) {
+		t.Fatal("unexpected parts", attrs.ObjectParts)
+	}
+	for i, part := range attrs.ObjectParts.Parts {
+		if sum := base64.StdEncoding.EncodeToString(sha1Sum(parts[i])); part.ChecksumSHA1 != sum {
+			t.Fatal("unexpected part checksum", i+1, part.ChecksumSHA1, "expected", sum)
+		}
+	}
+}
+
+func TestMultipartUploadPartContentMD5(t *testing.T) {
+	for _, tc := range []struct {
+		name    string
+		backend gofakes3.Backend
+	}{
+		{"multipart backend", s3mem.New()},
+		{"in memory", struct{ gofakes3.Backend }{s3mem.New()}},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			ts := newTestServer(t, withBackend(tc.backend))
+			defer ts.Close()
+			svc := ts.s3Client()
+
+			id := ts.createMultipartUpload(defaultBucket, "object", nil)
+			upload := func(body []byte, md5Base64 string) (*s3.UploadPartOutput, error) {
+				return svc.UploadPart(&s3.UploadPartInput{
+					Bucket:     aws.String(defaultBucket),
+					Key:        aws.String("object"),
+					UploadId:   aws.String(id),
+					PartNumber: aws.Int64(1),
+					Body:       bytes.NewReader(body),
+					ContentMD5: aws.String(md5Base64),
+				})
+			}
+
+			// The part was corrupted after its digest was computed:
+			_, err := upload([]byte("hellp"), hashMD5Bytes([]byte("hello")).Base64())
+			if !s3HasErrorCode(err, gofakes3.ErrBadDigest) {
+				t.Fatal("expected BadDigest, found", err)
+			}
+			parts, err := svc.ListParts(&s3.ListPartsInput{Bucket: aws.String(defaultBucket), Key: aws.String("object"), UploadId: aws.String(id)})
+			ts.OK(err)
+			if len(parts.Parts) != 0 {
+				t.Fatal("expected the part not to be stored, found", parts.Parts)
+			}
+
+			hash := hashMD5Bytes([]byte("hello"))
+			out, err := upload([]byte("hello"), hash.Base64())
+			ts.OK(err)
+			if etag := aws.StringValue(out.ETag); etag != `"`+hash.Hex()+`"` {
+				t.Fatal("unexpected ETag", etag)
+			}
+		})
+	}
+}
+
+func TestMultipartUploadETag(t *testing.T) {
+	ts := newTestServer(t)
+	defer ts.Close()
+	svc := ts.s3Client()
+
+	// The expected ETags are the MD5 of the concatenated binary MD5s of the
+	// parts, followed by the number of parts, which is how S3 calculates them:
+	for _, tc := range []struct {
+		name  string
+		parts [][]byte
+		etag  string
+	}{
+		{"single", [][]byte{[]byte("hello world")}, `"241d8a27c836427bd7f04461b60e7359-1"`},
+		{"two", [][]byte{bytes.Repeat([]byte("a"), 5<<20), bytes.Repeat([]byte("b"), 1024)}, `"16329fb6004d64a4fbc5bbb983fa0528-2"`},
+		{"three", [][]byte{make([]byte, 5<<20), make([]byte, 5<<20), []byte("c")}, `"1be1722a1d7434aa221a00cd174ce263-3"`},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			uploadID := ts.createMultipartUpload(defaultBucket, tc.name, nil)
+			var parts []*s3.CompletedPart
+			for i, body := range tc.parts {
+				parts = append(parts, ts.uploadPart(defaultBucket, tc.name, uploadID, int64(i+1), body))
+			}
+
+			out, err := svc.CompleteMultipartUpload(&s3.CompleteMultipartUploadInput{
+				Bucket:          aws.String(defaultBucket),
+				Key:             aws.String(tc.name),
+				UploadId:        aws.String(uploadID),
+				MultipartUpload: &s3.CompletedMultipartUpload{Parts: parts},
+			})
+			ts.OK(err)
+			if etag := aws.StringValue(out.ETag); etag != tc.etag {
+				t.Fatal("complete", etag, "!=", tc.etag)
+			}
+
+			head, err := svc.HeadObject(&s3.HeadObjectInput{Bucket: aws.String(defaultBucket), Key: aws.String(tc.name)})
+			ts.OK(err)
+			if etag := aws.StringValue(head.ETag); etag != tc.etag {
+				t.Fatal("head", etag, "!=", tc.etag)
+			}
+
+			get, err := svc.GetObject(&s3.GetObjectInput{Bucket: aws.String(defaultBucket), Key: aws.String(tc.name)})
+			ts.OK(err)
+			get.Body.Close()
+			if etag := aws.StringValue(get.ETag); etag != tc.etag {
+				t.Fatal("get", etag, "!=", tc.etag)
+			}
+
+			list, err := svc.ListObjectsV2(&s3.ListObjectsV2Input{Bucket: aws.String(defaultBucket), Prefix: aws.String(tc.name)})
+			ts.OK(err)
+			if len(list.Contents) != 1 || aws.StringValue(list.Contents[0].ETag) != tc.etag {
+				t.Fatal("unexpected list", list.Contents)
+			}
+		})
+	}
+
+	// A copy is not a multipart object, so it has the ETag of its contents:
+	copied, err := svc.CopyObject(&s3.CopyObjectInput{
+		Bucket:     aws.String(defaultBucket),
+		Key:        aws.String("copy"),
+		CopySource: aws.String(defaultBucket + "/single"),
+	})
+	ts.OK(err)
+	expected := `"` + hashMD5Bytes([]byte("hello world")).Hex() + `"`
+	if etag := aws.StringValue(copied.CopyObjectResult.ETag); etag != expected {
+		t.Fatal("copy", etag, "!=", expected)
+	}
+	head, err := svc.HeadObject(&s3.HeadObjectInput{Bucket: aws.String(defaultBucket), Key: aws.String("copy")})
+	ts.OK(err)
+	if etag := aws.StringValue(head.ETag); etag != expected {
+		t.Fatal("copy head", etag, "!=", expected)
+	}
+}
+
+func TestUploadPartCopy(t *testing.T) {
+	ts := newTestServer(t, withFakerOptions(gofakes3.WithMinPartSize(0)))
+	defer ts.Close()
+	svc := ts.s3Client()
+
+	ts.backendPutString(defaultBucket, "src", nil, "0123456789")
+	uploadID := ts.createMultipartUpload(defaultBucket, "dst", nil)
+
+	var parts []*s3.CompletedPart
+	for idx, rng := range []string{"bytes=5-9", ""} {
+		in := &s3.UploadPartCopyInput{
+			Bucket:     aws.String(defaultBucket),
+			Key:        aws.String("dst"),
+			UploadId:   aws.String(uploadID),
+			PartNumber: aws.Int64(int64(idx + 1)),
+			CopySource: aws.String("/" + defaultBucket + "/src"),
+		}
+		if rng != "" {
+			in.CopySourceRange = aws.String(rng)
+		}
+		out, err := svc.UploadPartCopy(in)
+		ts.OK(err)
+		if out.CopyPartResult == nil || out.CopyPartResult.ETag == nil {
+			t.Fatal("missing CopyPartResult ETag")
+		}
+		parts = append(parts, &s3.CompletedPart{ETag: out.CopyPartResult.ETag, PartNumber: in.PartNumber})
+	}
+
+	ts.assertCompleteUpload(defaultBucket, "dst", uploadID, parts, []byte("567890123456789"))
+
+	_, err := svc.UploadPartCopy(&s3.UploadPartCopyInput{
+		Bucket:     aws.String(defaultBucket),
+		Key:        aws.String("dst"),
+		UploadId:   aws.String("nope"),
+		PartNumber: aws.Int64(1),
+		CopySource: aws.String("/" + defaultBucket + "/src"),
+	})
+	if !s3HasErrorCode(err, gofakes3.ErrNoSuchUpload) {
+		t.Fatal("expected NoSuchUpload, found", err)
+	}
+}
+
+func TestUploadPartCopyRange(t *testing.T) {
+	ts := newTestServer(t, withFakerOptions(gofakes3.WithMinPartSize(0)))
+	defer ts.Close()
+	svc := ts.s3Client()
+
+	src := make([]byte, 16<<20)
+	rand.New(rand.NewSource(0)).Read(src)
+	ts.backendPutBytes(defaultBucket, "src", nil, src)
+
+	uploadID := ts.createMultipartUpload(defaultBucket, "dst", nil)
+	parts := []*s3.CompletedPart{ts.uploadPart(defaultBucket, "dst", uploadID, 1, []byte("head"))}
+
+	// A range from the middle of the source becomes the whole of the part:
+	start, end := int64(5<<20), int64(11<<20)-1
+	out, err := svc.UploadPartCopy(&s3.UploadPartCopyInput{
+		Bucket:          aws.String(defaultBucket),
+		Key:             aws.String("dst"),
+		UploadId:        aws.String(uploadID),
+		PartNumber:      aws.Int64(2),
+		CopySource:      aws.String("/" + defaultBucket + "/src"),
+		CopySourceRange: aws.String(fmt.Sprintf("bytes=%d-%d", start, end)),
+	})
+	ts.OK(err)
+	parts = append(parts, &s3.CompletedPart{ETag: out.CopyPartResult.ETag, PartNumber: aws.Int64(2)})
+
+	list, err := svc.ListParts(&s3.ListPartsInput{
+		Bucket:   aws.String(defaultBucket),
+		Key:      aws.String("dst"),
+		UploadId: aws.String(uploadID),
+	})
+	ts.OK(err)
+	if len(list.Parts) != 2 || aws.Int64Value(list.Parts[1].Size) != end-start+1 {
+		t.Fatal("unexpected parts", list.Parts)
+	}
+
+	expected := append([]byte("head"), src[start:end+1]...)
+	ts.assertCompleteUpload(defaultBucket, "dst", uploadID, parts, expected)
+
+	for _, tc := range []struct {
+		rnge string
+		code gofakes3.ErrorCode
+	}{
+		{"bytes=0-", gofakes3.ErrInvalidArgument},
+		{"bytes=-10", gofakes3.ErrInvalidArgument},
+		{"bytes=0-1,4-5", gofakes3.ErrInvalidArgument},
+		{"bytes=5-4", gofakes3.ErrInvalidArgument},
+		{"nope", gofakes3.ErrInvalidArgument},
+		{fmt.Sprintf("bytes=0-%d", len(src)), gofakes3.ErrInvalidRange},
+		{fmt.Sprintf("bytes=%d-%d", len(src), len(src)+1), gofakes3.ErrInvalidRange},
+	} {
+		t.Run(tc.rnge, func(t *testing.T) {
+			uploadID := ts.createMultipartUpload(defaultBucket, "invalid", nil)
+			_, err := svc.UploadPartCopy(&s3.UploadPartCopyInput{
+				Bucket:          aws.String(defaultBucket),
+				Key:             aws.String("invalid"),
+				UploadId:        aws.String(uploadID),
+				PartNumber:      aws.Int64(1),
+				CopySource:      aws.String("/" + defaultBucket + "/src"),
+				CopySourceRange: aws.String(tc.rnge),
+			})
+			if !s3HasErrorCode(err, tc.code) {
+				t.Fatal("expected", tc.code, "found", err)
+			}
+		})
+	}
+}
+
+func TestUploadPartCopyRangeIgnoredByBackend(t *testing.T) {
+	backend := &rangeIgnoringBackend{s3mem.New(s3mem.WithTimeSource(gofakes3.FixedTimeSource(defaultDate)))}
+	ts := newTestServer(t, withBackend(backend), withFakerOptions(gofakes3.WithMinPartSize(0)))
+	defer ts.Close()
+	svc := ts.s3Client()
+
+	ts.backendPutString(defaultBucket, "src", nil, "0123456789")
+	uploadID := ts.createMultipartUpload(defaultBucket, "dst", nil)
+
+	// Only the requested bytes are copied, even though the Backend returns
+	// all of them:
+	out, err := svc.UploadPartCopy(&s3.UploadPartCopyInput{
+		Bucket:          aws.String(defaultBucket),
+		Key:             aws.String("dst"),
+		UploadId:        aws.String(uploadID),
+		PartNumber:      aws.Int64(1),
+		CopySource:      aws.String("/" + defaultBucket + "/src"),
+		CopySourceRange: aws.String("bytes=3-5"),
+	})
+	ts.OK(err)
+	parts := []*s3.CompletedPart{{ETag: out.CopyPartResult.ETag, PartNumber: aws.Int64(1)}}
+	ts.assertCompleteUpload(defaultBucket, "dst", uploadID, parts, []byte("345"))
+}
+
+// rangeIgnoringBackend returns the whole of an object from GetObject, whatever
+// range is requested.
+type rangeIgnoringBackend struct {
+	gofakes3.Backend
+}
+
+func (b *rangeIgnoringBackend) GetObject(bucketName, objectName string, rangeRequest *gofakes3.ObjectRangeRequest) (*gofakes3.Object, error) {
+	return b.Backend.GetObject(bucketName, objectName, nil)
+}
+
 func TestAbortMultipartUpload(t *testing.T) {
 	ts := newTestServer(t)
 	defer ts.Close()
@@ -52,6 +442,62 @@ func TestAbortMultipartUpload(t *testing.T) {
 	ts.assertAbortMultipartUpload(defaultBucket, "obj", "1")
 }
 
+func TestAbortMultipartUploadsOlderThan(t *testing.T) {
+	var buf bytes.Buffer
+	ts := newTestServer(t, withFakerOptions(
+		gofakes3.WithLogger(gofakes3.StdLog(log.New(&buf, "", 0), gofakes3.LogInfo)),
+	))
+	defer ts.Close()
+
+	old := ts.createMultipartUpload(defaultBucket, "old", nil)
+	ts.uploadPart(defaultBucket, "old", old, 1, []byte("hello"))
+	ts.Advance(time.Hour)
+	recent := ts.createMultipartUpload(defaultBucket, "recent", nil)
+	ts.Advance(time.Minute)
+
+	if n := ts.AbortMultipartUploadsOlderThan(time.Hour); n != 1 {
+		t.Fatal("expected 1 upload to be aborted, found", n)
+	}
+	ts.assertListMultipartUploads(defaultBucket, listUploadsOpts{
+		Uploads: strs("recent/" + recent)})
+	ts.assertListUploadPartsFails(gofakes3.ErrNoSuchUpload, defaultBucket, "old", old, listUploadPartsOpts{})
+	if !strings.Contains(buf.String(), "abort expired multipart upload "+defaultBucket+" old "+old) {
+		t.Fatal("expected the aborted upload to be logged, found", buf.String())
+	}
+
+	if n := ts.AbortMultipartUploadsOlderThan(time.Hour); n != 0 {
+		t.Fatal("expected no uploads to be aborted, found", n)
+	}
+	if n := ts.AbortMultipartUploadsOlderThan(0); n != 1 {
+		t.Fatal("expected 1 upload to be aborted, found", n)
+	}
+	ts.assertListMultipartUploads(defaultBucket, listUploadsOpts{})
+}
+
+func TestMultipartExpiry(t *testing.T) {
+	ts := newTestServer(t, withFakerOptions(gofakes3.WithMultipartExpiry(time.Millisecond)))
+	defer ts.Close()
+
+	ts.createMultipartUpload(defaultBucket, "obj", nil)
+	ts.Advance(time.Hour)
+
+	svc := ts.s3Client()
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		rs, err := svc.ListMultipartUploads(&s3.ListMultipartUploadsInput{
+			Bucket: aws.String(defaultBucket),
+		})
+		ts.OK(err)
+		if len(rs.Uploads) == 0 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("upload was not aborted by the sweeper")
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+}
+
 func TestListMultipartUploadsWithTheSameObjectKey(t *testing.T) {
 	ts := newTestServer(t)
 	defer ts.Close()
@@ -100,6 +546,63 @@ func TestListMultipartUploadsWithDifferentObjectKeys(t *testing.T) {
 		Marker: "baz/3", Limit: 2, Uploads: strs("baz/3", "foo/1")})
 }
 
+func TestListMultipartUploadsEmptyBucket(t *testing.T) {
+	ts := newTestServer(t)
+	defer ts.Close()
+
+	ts.assertListMultipartUploads(defaultBucket, listUploadsOpts{})
+}
+
+func TestListMultipartUploadsMissingUploadIDMarker(t *testing.T) {
+	ts := newTestServer(t)
+	defer ts.Close()
+
+	ts.createMultipartUpload(defaultBucket, "bar", nil)
+	ts.createMultipartUpload(defaultBucket, "foo", nil)
+	ts.assertAbortMultipartUpload(defaultBucket, "bar", "1")
+
+	ts.assertListMultipartUploads(defaultBucket, listUploadsOpts{
+		Marker: "bar/1", Uploads: strs("foo/2")})
+}
+
+func TestListMultipartUploadsPaginated(t *testing.T) {
+	ts := newTestServer(t)
+	defer ts.Close()
+	svc := ts.s3Client()
+
+	for _, key := range []string{"e", "d", "c", "b", "a", "c", "a"} {
+		ts.createMultipartUpload(defaultBucket, key, nil)
+	}
+	expected := strs("a/5", "a/7", "b/4", "c/3", "c/6", "d/2", "e/1")
+
+	var found []string
+	rq := &s3.ListMultipartUploadsInput{
+		Bucket:     aws.String(defaultBucket),
+		MaxUploads: aws.Int64(2),
+	}
+	for pages := 0; ; pages++ {
+		if pages > len(expected) {
+			t.Fatal("too many pages")
+		}
+		rs, err := svc.ListMultipartUploads(rq)
+		ts.OK(err)
+		for _, up := range rs.Uploads {
+			found = append(found, *up.Key+"/"+*up.UploadId)
+			if up.Initiated == nil || up.Initiated.IsZero() {
+				t.Fatal("missing Initiated for upload", *up.Key)
+			}
+		}
+		if !aws.BoolValue(rs.IsTruncated) {
+			break
+		}
+		rq.KeyMarker, rq.UploadIdMarker = rs.NextKeyMarker, rs.NextUploadIdMarker
+	}
+
+	if !reflect.DeepEqual(found, expected) {
+		t.Fatal("upload list mismatch:", found, "!=", expected)
+	}
+}
+
 func TestListMultipartUploadsPrefix(t *testing.T) {
 	ts := newTestServer(t)
 	defer ts.Close()
@@ -112,7 +615,7 @@ func TestListMultipartUploadsPrefix(t *testing.T) {
 	ts.createMultipartUpload(defaultBucket, "food/baz", nil)
 	ts.createMultipartUpload(defaultBucket, "yep/qux", nil)
 
-	ts.assertListMultipartUploads(defaultBucket, listUploadsOpts{Prefix: prefixFile("/"),
+	ts.assertListMultipartUploads(defaultBucket, listUploadsOpts{Prefix: prefixFile(""),
 		Prefixes: strs("foo/", "food/", "yep/")})
 
 	ts.assertListMultipartUploads(defaultBucket, listUploadsOpts{Prefix: prefixFile("fo"),
@@ -138,9 +641,36 @@ func TestListMultipartUploadsPrefix(t *testing.T) {
 	//     Uploads:  strs("foo/bar/1", "foo/bar/2")})
 }
 
-func TestListMultipartUploadParts(t *testing.T) {
+func TestListMultipartUploadsURLEncoding(t *testing.T) {
 	ts := newTestServer(t)
 	defer ts.Close()
+	svc := ts.s3Client()
+
+	ts.createMultipartUpload(defaultBucket, "a&b", nil)
+	ts.createMultipartUpload(defaultBucket, "dir one/ünïcødé", nil)
+
+	rs, err := svc.ListMultipartUploads(&s3.ListMultipartUploadsInput{
+		Bucket:       aws.String(defaultBucket),
+		EncodingType: aws.String("url"),
+	})
+	ts.OK(err)
+	if aws.StringValue(rs.EncodingType) != "url" || len(rs.Uploads) != 2 {
+		t.Fatal("unexpected listing", rs)
+	}
+	for idx, exp := range []string{"a&b", "dir one/ünïcødé"} {
+		key := aws.StringValue(rs.Uploads[idx].Key)
+		if key != strings.Replace(url.QueryEscape(exp), "%2F", "/", -1) {
+			t.Fatal("key not encoded:", key)
+		}
+		decoded, err := url.QueryUnescape(key)
+		ts.OK(err)
+		ts.assertAbortMultipartUpload(defaultBucket, decoded, gofakes3.UploadID(aws.StringValue(rs.Uploads[idx].UploadId)))
+	}
+}
+
+func TestListMultipartUploadParts(t *testing.T) {
+	ts := newTestServer(t, withFakerOptions(gofakes3.WithMinPartSize(0)))
+	defer ts.Close()
 
 	id := ts.createMultipartUpload(defaultBucket, "foo", nil)
 
@@ -158,3 +688,343 @@ func TestListMultipartUploadParts(t *testing.T) {
 	// No parts should be returned after the upload is completed:
 	ts.assertListUploadPartsFails(gofakes3.ErrNoSuchUpload, defaultBucket, "foo", id, listUploadPartsOpts{})
 }
+
+func TestListMultipartUploadPartsPaginated(t *testing.T) {
+	ts := newTestServer(t)
+	defer ts.Close()
+	svc := ts.s3Client()
+
+	id := ts.createMultipartUpload(defaultBucket, "foo", nil)
+
+	parts := []*s3.CompletedPart{
+		ts.uploadPart(defaultBucket, "foo", id, 1, []byte("abc")),
+		ts.uploadPart(defaultBucket, "foo", id, 2, []byte("def")),
+		ts.uploadPart(defaultBucket, "foo", id, 4, []byte("ghi")),
+		ts.uploadPart(defaultBucket, "foo", id, 7, []byte("jkl")),
+	}
+
+	ts.assertListUploadParts(defaultBucket, "foo", id,
+		listUploadPartsOpts{Limit: 2}.withCompletedParts(parts[:2]...))
+	ts.assertListUploadParts(defaultBucket, "foo", id,
+		listUploadPartsOpts{Marker: 2, Limit: 2}.withCompletedParts(parts[2:]...))
+	ts.assertListUploadParts(defaultBucket, "foo", id,
+		listUploadPartsOpts{Marker: 3}.withCompletedParts(parts[2:]...))
+	ts.assertListUploadParts(defaultBucket, "foo", id,
+		listUploadPartsOpts{Marker: 100})
+
+	var found []*s3.CompletedPart
+	rq := &s3.ListPartsInput{
+		Bucket:   aws.String(defaultBucket),
+		Key:      aws.String("foo"),
+		UploadId: aws.String(id),
+		MaxParts: aws.Int64(3),
+	}
+	for pages := 0; ; pages++ {
+		if pages > len(parts) {
+			t.Fatal("too many pages")
+		}
+		rs, err := svc.ListParts(rq)
+		ts.OK(err)
+		for _, part := range rs.Parts {
+			if *part.Size != 3 || part.LastModified == nil {
+				t.Fatal("unexpected part", part)
+			}
+			found = append(found, &s3.CompletedPart{ETag: part.ETag, PartNumber: part.PartNumber})
+		}
+		if !aws.BoolValue(rs.IsTruncated) {
+			break
+		}
+		rq.PartNumberMarker = rs.NextPartNumberMarker
+	}
+
+	if !reflect.DeepEqual(found, parts) {
+		t.Fatal("parts mismatch:", found, "!=", parts)
+	}
+
+	ts.assertListUploadPartsFails(gofakes3.ErrNoSuchUpload, defaultBucket, "foo", "nope", listUploadPartsOpts{})
+}
+
+func TestListMultipartUploadPartsLimits(t *testing.T) {
+	ts := newTestServer(t)
+	defer ts.Close()
+	svc := ts.s3Client()
+
+	id := ts.createMultipartUpload(defaultBucket, "foo", nil)
+	parts := []*s3.CompletedPart{
+		ts.uploadPart(defaultBucket, "foo", id, 1, []byte("abc")),
+		ts.uploadPart(defaultBucket, "foo", id, 2, []byte("def")),
+	}
+
+	{ // The marker can not overflow when it is incremented:
+		rs, err := svc.ListParts(&s3.ListPartsInput{
+			Bucket:           aws.String(defaultBucket),
+			Key:              aws.String("foo"),
+			UploadId:         aws.String(id),
+			PartNumberMarker: aws.Int64(math.MaxInt64),
+		})
+		ts.OK(err)
+		if len(rs.Parts) != 0 || aws.BoolValue(rs.IsTruncated) {
+			t.Fatal("expected an empty page, found", rs)
+		}
+	}
+
+	{ // Clients paginating with max-parts=0 still get through every part:
+		var found []*s3.CompletedPart
+		rq := &s3.ListPartsInput{
+			Bucket:   aws.String(defaultBucket),
+			Key:      aws.String("foo"),
+			UploadId: aws.String(id),
+			MaxParts: aws.Int64(0),
+		}
+		for pages := 0; ; pages++ {
+			if pages > len(parts) {
+				t.Fatal("too many pages")
+			}
+			rs, err := svc.ListParts(rq)
+			ts.OK(err)
+			for _, part := range rs.Parts {
+				found = append(found, &s3.CompletedPart{ETag: part.ETag, PartNumber: part.PartNumber})
+			}
+			if !aws.BoolValue(rs.IsTruncated) {
+				break
+			}
+			rq.PartNumberMarker = rs.NextPartNumberMarker
+		}
+		if !reflect.DeepEqual(found, parts) {
+			t.Fatal("parts mismatch:", found, "!=", parts)
+		}
+	}
+}
+
+func TestMultipartUploadMinPartSize(t *testing.T) {
+	upload := func(ts *testServer, object string, bodies ...[]byte) (parts []*s3.CompletedPart, uploadID string) {
+		t.Helper()
+		uploadID = ts.createMultipartUpload(defaultBucket, object, nil)
+		for i, body := range bodies {
+			parts = append(parts, ts.uploadPart(defaultBucket, object, uploadID, int64(i+1), body))
+		}
+		return parts, uploadID
+	}
+
+	t.Run("default", func(t *testing.T) {
+		ts := newTestServer(t)
+		defer ts.Close()
+
+		// The last part may be smaller, so may the only part:
+		parts, id := upload(ts, "last", make([]byte, defaultUploadPartSize), []byte("abc"))
+		ts.assertCompleteUpload(defaultBucket, "last", id, parts, append(make([]byte, defaultUploadPartSize), "abc"...))
+		parts, id = upload(ts, "single", []byte("abc"))
+		ts.assertCompleteUpload(defaultBucket, "single", id, parts, []byte("abc"))
+
+		// The error reports the first part that is too small:
+		parts, id = upload(ts, "small", make([]byte, defaultUploadPartSize), []byte("abc"), []byte("def"))
+		var body bytes.Buffer
+		ts.OK(xml.NewEncoder(&body).Encode(&gofakes3.CompleteMultipartUploadRequest{Parts: []gofakes3.CompletedPart{
+			{PartNumber: 1, ETag: aws.StringValue(parts[0].ETag)},
+			{PartNumber: 2, ETag: aws.StringValue(parts[1].ETag)},
+			{PartNumber: 3, ETag: aws.StringValue(parts[2].ETag)},
+		}}))
+		rq, err := http.NewRequest("POST", ts.url("/"+defaultBucket+"/small?uploadId="+id), &body)
+		ts.OK(err)
+		rs, err := httpClient().Do(rq)
+		ts.OK(err)
+		defer rs.Body.Close()
+		if rs.StatusCode != http.StatusBadRequest {
+			t.Fatal("unexpected status", rs.StatusCode)
+		}
+		var result gofakes3.ErrorEntityTooSmallResponse
+		ts.OK(xml.NewDecoder(rs.Body).Decode(&result))
+		if result.Code != gofakes3.ErrEntityTooSmall || result.PartNumber != 2 || result.ETag != aws.StringValue(parts[1].ETag) ||
+			result.ProposedSize != 3 || result.MinSizeAllowed != defaultUploadPartSize {
+			t.Fatal("unexpected error", result)
+		}
+		if ts.backendObjectExists(defaultBucket, "small") {
+			t.Fatal("object should not exist")
+		}
+	})
+
+	t.Run("option", func(t *testing.T) {
+		ts := newTestServer(t, withFakerOptions(gofakes3.WithMinPartSize(3)))
+		defer ts.Close()
+		svc := ts.s3Client()
+
+		parts, id := upload(ts, "ok", []byte("abc"), []byte("de"))
+		ts.assertCompleteUpload(defaultBucket, "ok", id, parts, []byte("abcde"))
+
+		parts, id = upload(ts, "small", []byte("ab"), []byte("cde"))
+		_, err := svc.CompleteMultipartUpload(&s3.CompleteMultipartUploadInput{
+			Bucket:          aws.String(defaultBucket),
+			Key:             aws.String("small"),
+			UploadId:        aws.String(id),
+			MultipartUpload: &s3.CompletedMultipartUpload{Parts: parts},
+		})
+		if !s3HasErrorCode(err, gofakes3.ErrEntityTooSmall) {
+			t.Fatal("expected EntityTooSmall, found", err)
+		}
+	})
+}
+
+func TestCompleteMultipartUploadValidation(t *testing.T) {
+	ts := newTestServer(t, withFakerOptions(gofakes3.WithMinPartSize(0)))
+	defer ts.Close()
+	svc := ts.s3Client()
+
+	id := ts.createMultipartUpload(defaultBucket, "foo", nil)
+	parts := []*s3.CompletedPart{
+		ts.uploadPart(defaultBucket, "foo", id, 1, []byte("abc")),
+		ts.uploadPart(defaultBucket, "foo", id, 2, []byte("def")),
+		ts.uploadPart(defaultBucket, "foo", id, 3, []byte("ghi")),
+	}
+	part := func(number int64, etag string) *s3.CompletedPart {
+		return &s3.CompletedPart{PartNumber: aws.Int64(number), ETag: aws.String(etag)}
+	}
+
+	// The upload is kept after each failure, so it can be completed at the end:
+	for _, tc := range []struct {
+		name  string
+		parts []*s3.CompletedPart
+		code  gofakes3.ErrorCode
+	}{
+		{"descending", []*s3.CompletedPart{parts[1], parts[0]}, gofakes3.ErrInvalidPartOrder},
+		{"duplicate", []*s3.CompletedPart{parts[0], parts[0]}, gofakes3.ErrInvalidPartOrder},
+		{"etag", []*s3.CompletedPart{parts[0], part(2, aws.StringValue(parts[0].ETag))}, gofakes3.ErrInvalidPart},
+		{"missing", []*s3.CompletedPart{parts[0], part(4, aws.StringValue(parts[2].ETag))}, gofakes3.ErrInvalidPart},
+		{"zero", []*s3.CompletedPart{part(0, aws.StringValue(parts[0].ETag))}, gofakes3.ErrInvalidPart},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			_, err := svc.CompleteMultipartUpload(&s3.CompleteMultipartUploadInput{
+				Bucket:          aws.String(defaultBucket),
+				Key:             aws.String("foo"),
+				UploadId:        aws.String(id),
+				MultipartUpload: &s3.CompletedMultipartUpload{Parts: tc.parts},
+			})
+			if !s3HasErrorCode(err, tc.code) {
+				t.Fatal("expected", tc.code, "found", err)
+			}
+		})
+	}
+
+	// Part 2 is not listed, so it is left out:
+	ts.assertCompleteUpload(defaultBucket, "foo", id, []*s3.CompletedPart{parts[0], parts[2]}, []byte("abcghi"))
+	ts.assertListUploadPartsFails(gofakes3.ErrNoSuchUpload, defaultBucket, "foo", id, listUploadPartsOpts{})
+}
+
+func TestCompleteMultipartUploadACLNotSupported(t *testing.T) {
+	ts := newTestServer(t)
+	defer ts.Close()
+	svc := ts.s3Client()
+
+	ownershipControls := func(method, body string) {
+		t.Helper()
+		rq, err := http.NewRequest(method, ts.url(defaultBucket+"?ownershipControls"), strings.NewReader(body))
+		ts.OK(err)
+		rs, err := httpClient().Do(rq)
+		ts.OK(err)
+		rs.Body.Close()
+		if rs.StatusCode >= 300 {
+			t.Fatal("unexpected status", rs.StatusCode)
+		}
+	}
+
+	mpu, err := svc.CreateMultipartUpload(&s3.CreateMultipartUploadInput{
+		Bucket: aws.String(defaultBucket),
+		Key:    aws.String("foo"),
+		ACL:    aws.String("public-read"),
+	})
+	ts.OK(err)
+	id := aws.StringValue(mpu.UploadId)
+	parts := []*s3.CompletedPart{ts.uploadPart(defaultBucket, "foo", id, 1, []byte("abc"))}
+
+	// ACLs are disabled after the upload was initiated, so it can not be
+	// completed, but it is kept until they are enabled again:
+	ownershipControls("PUT", "<OwnershipControls><Rule><ObjectOwnership>BucketOwnerEnforced</ObjectOwnership></Rule></OwnershipControls>")
+	_, err = svc.CompleteMultipartUpload(&s3.CompleteMultipartUploadInput{
+		Bucket:          aws.String(defaultBucket),
+		Key:             aws.String("foo"),
+		UploadId:        aws.String(id),
+		MultipartUpload: &s3.CompletedMultipartUpload{Parts: parts},
+	})
+	if !s3HasErrorCode(err, gofakes3.ErrAccessControlListNotSupported) {
+		t.Fatal("expected AccessControlListNotSupported, found", err)
+	}
+
+	ownershipControls("DELETE", "")
+	ts.assertCompleteUpload(defaultBucket, "foo", id, parts, []byte("abc"))
+}
+
+// failingPutBackend fails every PutObject. It hides the MultipartBackend
+// of the Backend it wraps, so the parts of uploads are held by GoFakeS3.
+type failingPutBackend struct {
+	gofakes3.Backend
+}
+
+func (b *failingPutBackend) PutObject(bucketName, key string, meta map[string]string, input io.Reader, size int64) (gofakes3.PutObjectResult, error) {
+	return gofakes3.PutObjectResult{}, gofakes3.ErrAccessDenied
+}
+
+// failingCompleteBackend fails every CompleteMultipart.
+type failingCompleteBackend struct {
+	*s3mem.Backend
+}
+
+func (b *failingCompleteBackend) CompleteMultipart(bucketName, objectName string, uploadID gofakes3.UploadID, partNumbers []int, meta map[string]string, size int64) (gofakes3.PutObjectResult, error) {
+	return gofakes3.PutObjectResult{}, gofakes3.ErrAccessDenied
+}
+
+func TestCompleteMultipartUploadBackendFailure(t *testing.T) {
+	for _, tc := range []struct {
+		name    string
+		backend gofakes3.Backend
+	}{
+		{"PutObject", &failingPutBackend{s3mem.New()}},
+		{"CompleteMultipart", &failingCompleteBackend{s3mem.New()}},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			ts := newTestServer(t, withBackend(tc.backend))
+			defer ts.Close()
+			svc := ts.s3Client()
+
+			id := ts.createMultipartUpload(defaultBucket, "foo", nil)
+			parts := []*s3.CompletedPart{ts.uploadPart(defaultBucket, "foo", id, 1, []byte("abc"))}
+
+			_, err := svc.CompleteMultipartUpload(&s3.CompleteMultipartUploadInput{
+				Bucket:          aws.String(defaultBucket),
+				Key:             aws.String("foo"),
+				UploadId:        aws.String(id),
+				MultipartUpload: &s3.CompletedMultipartUpload{Parts: parts},
+			})
+			if !s3HasErrorCode(err, gofakes3.ErrAccessDenied) {
+				t.Fatal("expected AccessDenied, found", err)
+			}
+
+			// The upload is kept, so its parts can still be discarded:
+			ts.assertListUploadParts(defaultBucket, "foo", id, listUploadPartsOpts{}.withCompletedParts(parts...))
+			ts.assertAbortMultipartUpload(defaultBucket, "foo", gofakes3.UploadID(id))
+		})
+	}
+}
+
+func TestMultipartUploadSnapshotRestore(t *testing.T) {
+	db := s3mem.New()
+	ts := newTestServer(t, withBackend(db), withFakerOptions(gofakes3.WithMinPartSize(0)))
+	id := ts.createMultipartUpload(defaultBucket, "foo", nil)
+	parts := []*s3.CompletedPart{
+		ts.uploadPart(defaultBucket, "foo", id, 1, []byte("abc")),
+		ts.uploadPart(defaultBucket, "foo", id, 2, []byte("def")),
+	}
+	var snapshot bytes.Buffer
+	ts.OK(db.Snapshot(&snapshot))
+	ts.Close()
+
+	// The upload is restored into the GoFakeS3 the new backend is passed to:
+	restored := s3mem.New()
+	ts.OK(restored.Restore(&snapshot))
+	ts = newTestServer(t, withBackend(restored), withoutInitialBuckets(), withFakerOptions(gofakes3.WithMinPartSize(0)))
+	defer ts.Close()
+
+	ts.assertListUploadParts(defaultBucket, "foo", id, listUploadPartsOpts{}.withCompletedParts(parts...))
+	if next := ts.createMultipartUpload(defaultBucket, "bar", nil); next == id {
+		t.Fatal("upload ID", next, "was reused")
+	}
+	ts.assertCompleteUpload(defaultBucket, "foo", id, parts, []byte("abcdef"))
+}
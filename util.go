@@ -1,9 +1,11 @@
 package gofakes3
 
 import (
+	"context"
 	"io"
 	"io/ioutil"
 	"strconv"
+	"sync"
 )
 
 func parseClampedInt(in string, defaultValue, min, max int64) (int64, error) {
@@ -56,3 +58,85 @@ func ReadAll(r io.Reader, size int64) (b []byte, err error) {
 
 	return b, nil
 }
+
+// copyBufferSize is the size of the chunks copyContents copies in.
+const copyBufferSize = 32 * 1024
+
+var copyBuffers = sync.Pool{New: func() interface{} {
+	buf := make([]byte, copyBufferSize)
+	return &buf
+}}
+
+// copyContents copies the contents of an object from r to w in chunks of
+// copyBufferSize. Unlike io.Copy, it does not let r write itself to w, which
+// could hand w the entire object at once if it is held in memory.
+func copyContents(w io.Writer, r io.Reader) (int64, error) {
+	buf := copyBuffers.Get().(*[]byte)
+	defer copyBuffers.Put(buf)
+	return io.CopyBuffer(struct{ io.Writer }{w}, struct{ io.Reader }{r}, *buf)
+}
+
+// ReadAllContext is like ReadAll, but returns ctx.Err() rather than reading
+// the next chunk from r once ctx is cancelled.
+func ReadAllContext(ctx context.Context, r io.Reader, size int64) (b []byte, err error) {
+	return ReadAll(&contextReader{ctx: ctx, r: r}, size)
+}
+
+// ContextReadCloser returns an io.ReadCloser that reads from rc until ctx is
+// cancelled, then returns ctx.Err().
+func ContextReadCloser(ctx context.Context, rc io.ReadCloser) io.ReadCloser {
+	return &contextReadCloser{contextReader{ctx: ctx, r: rc}, rc}
+}
+
+type contextReader struct {
+	ctx context.Context
+	r   io.Reader
+}
+
+func (c *contextReader) Read(p []byte) (n int, err error) {
+	if err := c.ctx.Err(); err != nil {
+		return 0, err
+	}
+	return c.r.Read(p)
+}
+
+// maxSizeReader returns ErrEntityTooLarge once more than max bytes have been
+// read from r, so that an upload larger than it claimed to be is rejected
+// without reading all of it.
+type maxSizeReader struct {
+	r   io.Reader
+	n   int64
+	max int64
+}
+
+func (m *maxSizeReader) Read(p []byte) (n int, err error) {
+	n, err = m.r.Read(p)
+	m.n += int64(n)
+	if m.n > m.max {
+		return n, ErrorEntityTooLarge(m.n, m.max)
+	}
+	return n, err
+}
+
+// exactSizeReader returns ErrIncompleteBody if r does not contain exactly
+// size bytes, in place of the error that ends the data, so that a Backend
+// reading from it until io.EOF does not store an incomplete part.
+type exactSizeReader struct {
+	r    io.Reader
+	n    int64
+	size int64
+}
+
+func (e *exactSizeReader) Read(p []byte) (n int, err error) {
+	n, err = e.r.Read(p)
+	e.n += int64(n)
+	if e.n > e.size || (err == io.EOF && e.n < e.size) {
+		return n, ErrIncompleteBody
+	}
+	return n, err
+}
+
+type contextReadCloser struct {
+	contextReader
+	io.Closer
+}
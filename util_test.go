@@ -1,6 +1,9 @@
 package gofakes3
 
 import (
+	"context"
+	"io"
+	"io/ioutil"
 	"strings"
 	"testing"
 )
@@ -62,3 +65,58 @@ func TestReadAll(t *testing.T) {
 		}
 	})
 }
+
+func TestReadAllContext(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	tt := TT{t}
+	b, err := ReadAllContext(ctx, strings.NewReader("test"), 4)
+	tt.OK(err)
+	if string(b) != "test" {
+		t.Fatal(string(b), "!=", "test")
+	}
+
+	cancel()
+	if _, err := ReadAllContext(ctx, strings.NewReader("test"), 4); err != context.Canceled {
+		t.Fatal("expected context.Canceled, found", err)
+	}
+}
+
+func TestContextReadCloser(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	rc := ContextReadCloser(ctx, ioutil.NopCloser(strings.NewReader("test")))
+	defer rc.Close()
+
+	var b [2]byte
+	if _, err := io.ReadFull(rc, b[:]); err != nil {
+		t.Fatal(err)
+	}
+	cancel()
+	if _, err := rc.Read(b[:]); err != context.Canceled {
+		t.Fatal("expected context.Canceled, found", err)
+	}
+}
+
+func TestMaxSizeReader(t *testing.T) {
+	if b, err := ioutil.ReadAll(&maxSizeReader{r: strings.NewReader("test"), max: 4}); err != nil || string(b) != "test" {
+		t.Fatal(string(b), err)
+	}
+
+	// A reader that never ends is stopped after the limit:
+	r := &maxSizeReader{r: zeroReader{}, max: 1000}
+	_, err := ioutil.ReadAll(r)
+	if !HasErrorCode(err, ErrEntityTooLarge) {
+		t.Fatal("expected EntityTooLarge, found", err)
+	}
+	if tooLarge := err.(*ErrorEntityTooLargeResponse); tooLarge.MaxSizeAllowed != 1000 || tooLarge.ProposedSize <= 1000 {
+		t.Fatal("unexpected sizes", tooLarge.ProposedSize, tooLarge.MaxSizeAllowed)
+	}
+}
+
+type zeroReader struct{}
+
+func (zeroReader) Read(p []byte) (int, error) {
+	for i := range p {
+		p[i] = 0
+	}
+	return len(p), nil
+}
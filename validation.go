@@ -4,6 +4,24 @@ import (
 	"net"
 	"regexp"
 	"strings"
+	"unicode/utf8"
+)
+
+// BucketNameValidation selects the rules used to validate the names of new
+// buckets and the keys of new objects. See WithBucketNameValidation.
+type BucketNameValidation int
+
+const (
+	// BucketNamesStrict applies the rules S3 applies to new buckets in every
+	// region (see ValidateBucketName), and rejects object keys that S3 would
+	// reject. This is the default.
+	BucketNamesStrict BucketNameValidation = iota
+
+	// BucketNamesRelaxed applies the legacy rules S3 once applied to buckets
+	// in us-east-1: names may contain up to 255 uppercase and lowercase
+	// letters, numbers, periods, hyphens and underscores. Object keys are not
+	// validated.
+	BucketNamesRelaxed
 )
 
 // This pattern can be used to match both the entire bucket name (including period-
@@ -11,6 +29,15 @@ import (
 // split the string by period.
 var bucketNamePattern = regexp.MustCompile(`^[a-z0-9]([a-z0-9\.-]+)[a-z0-9]$`)
 
+var legacyBucketNamePattern = regexp.MustCompile(`^[a-zA-Z0-9._-]{1,255}$`)
+
+// Bucket names must not start or end with these, as S3 reserves them for
+// internationalised domain names, access point aliases and the like:
+var (
+	reservedBucketNamePrefixes = []string{"xn--", "sthree-"}
+	reservedBucketNameSuffixes = []string{"-s3alias", "--ol-s3"}
+)
+
 // ValidateBucketName applies the rules from the AWS docs:
 // https://docs.aws.amazon.com/AmazonS3/latest/dev/BucketRestrictions.html#bucketnamingrules
 //
@@ -18,6 +45,7 @@ var bucketNamePattern = regexp.MustCompile(`^[a-z0-9]([a-z0-9\.-]+)[a-z0-9]$`)
 // 2. Bucket names must be at least 3 and no more than 63 characters long.
 // 3. Bucket names must not contain uppercase characters or underscores.
 // 4. Bucket names must start with a lowercase letter or number.
+// 5. Bucket names must not use the prefixes and suffixes that S3 reserves.
 //
 // The DNS RFC confirms that the valid range of characters in an LDH label is 'a-z0-9-':
 // https://tools.ietf.org/html/rfc5890#section-2.3.1
@@ -34,6 +62,17 @@ func ValidateBucketName(name string) error {
 		return ErrorMessage(ErrInvalidBucketName, "bucket names must not be formatted as an IP address")
 	}
 
+	for _, prefix := range reservedBucketNamePrefixes {
+		if strings.HasPrefix(name, prefix) {
+			return ErrorMessagef(ErrInvalidBucketName, "bucket names must not start with %q", prefix)
+		}
+	}
+	for _, suffix := range reservedBucketNameSuffixes {
+		if strings.HasSuffix(name, suffix) {
+			return ErrorMessagef(ErrInvalidBucketName, "bucket names must not end with %q", suffix)
+		}
+	}
+
 	// Bucket names must be a series of one or more labels. Adjacent labels are
 	// separated by a single period (.). Bucket names can contain lowercase
 	// letters, numbers, and hyphens. Each label must start and end with a
@@ -48,6 +87,38 @@ func ValidateBucketName(name string) error {
 	return nil
 }
 
+// validateBucketName validates the name of a new bucket using the rules chosen
+// with WithBucketNameValidation.
+func (g *GoFakeS3) validateBucketName(name string) error {
+	if g.bucketNames == BucketNamesRelaxed {
+		if !legacyBucketNamePattern.MatchString(name) {
+			return ErrorMessage(ErrInvalidBucketName, "bucket name must be <= 255 characters and contain only 'a-z, A-Z, 0-9, ., -, _'")
+		}
+		return nil
+	}
+	return ValidateBucketName(name)
+}
+
+// validateObjectKey validates the key of a new object. If the rules chosen
+// with WithBucketNameValidation are strict, keys must be valid UTF-8 and must
+// not contain characters that are not allowed in XML 1.0, as they could not be
+// returned in a listing. The length of keys is checked against KeySizeLimit
+// whatever the rules.
+func (g *GoFakeS3) validateObjectKey(key string) error {
+	if g.bucketNames == BucketNamesRelaxed {
+		return nil
+	}
+	if !utf8.ValidString(key) {
+		return ErrorInvalidArgument("key", key, "Object key is not valid UTF-8.")
+	}
+	for _, r := range key {
+		if (r < 0x20 && r != '\t' && r != '\n' && r != '\r') || r == 0xFFFE || r == 0xFFFF {
+			return ErrorInvalidArgument("key", key, "Object key contains a character that is not allowed.")
+		}
+	}
+	return nil
+}
+
 var etagPattern = regexp.MustCompile(`^"[a-z0-9]+"$`)
 
 func validETag(v string) bool {
@@ -21,6 +21,13 @@ func TestValidateBucketName(t *testing.T) {
 		// Appending labels to these causes them to pass:
 		{"192.168.1.1", ErrInvalidBucketName},     // IP addresses are not allowed as bucket names. These may trip the "3-char min" rule first.
 		{"192.168.111.111", ErrInvalidBucketName}, // These should not trip the 3-char min but should still fail.
+
+		// Reserved prefixes and suffixes only apply to the whole name:
+		{"xn--yep", ErrInvalidBucketName},
+		{"sthree-yep", ErrInvalidBucketName},
+		{"yep-s3alias", ErrInvalidBucketName},
+		{"yep--ol-s3", ErrInvalidBucketName},
+		{"yep.xn--yep", ErrNone},
 	}
 
 	nameCases := []tcase{
@@ -67,3 +74,57 @@ func TestValidateBucketName(t *testing.T) {
 		})
 	}
 }
+
+func TestValidateBucketNameRelaxed(t *testing.T) {
+	g := &GoFakeS3{bucketNames: BucketNamesRelaxed}
+	for _, tc := range []struct {
+		name    string
+		errCode ErrorCode
+	}{
+		{"yep", ErrNone},
+		{"a", ErrNone},
+		{"UPPER_CASE", ErrNone},
+		{"192.168.1.1", ErrNone},
+		{strings.Repeat("a", 255), ErrNone},
+
+		{"", ErrInvalidBucketName},
+		{strings.Repeat("a", 256), ErrInvalidBucketName},
+		{"n🤡p", ErrInvalidBucketName},
+		{"n/p", ErrInvalidBucketName},
+	} {
+		t.Run("", func(t *testing.T) {
+			err := g.validateBucketName(tc.name)
+			if !HasErrorCode(err, tc.errCode) {
+				t.Fatalf("name %q did not contain code %q", tc.name, tc.errCode)
+			}
+		})
+	}
+}
+
+func TestValidateObjectKey(t *testing.T) {
+	for _, tc := range []struct {
+		key     string
+		strict  ErrorCode
+		relaxed ErrorCode
+	}{
+		{"yep", ErrNone, ErrNone},
+		{"y/e p\t\n", ErrNone, ErrNone},
+		{"🤡", ErrNone, ErrNone},
+
+		{"n\x01p", ErrInvalidArgument, ErrNone},
+		{"n\x00p", ErrInvalidArgument, ErrNone},
+		{"n\xffp", ErrInvalidArgument, ErrNone},
+		{"n\uffffp", ErrInvalidArgument, ErrNone},
+	} {
+		t.Run("", func(t *testing.T) {
+			strict := &GoFakeS3{}
+			if err := strict.validateObjectKey(tc.key); !HasErrorCode(err, tc.strict) {
+				t.Fatalf("strict: key %q did not contain code %q", tc.key, tc.strict)
+			}
+			relaxed := &GoFakeS3{bucketNames: BucketNamesRelaxed}
+			if err := relaxed.validateObjectKey(tc.key); !HasErrorCode(err, tc.relaxed) {
+				t.Fatalf("relaxed: key %q did not contain code %q", tc.key, tc.relaxed)
+			}
+		})
+	}
+}
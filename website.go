@@ -0,0 +1,105 @@
+package gofakes3
+
+import (
+	"io"
+	"net/http"
+	"strings"
+)
+
+// isWebsiteHost reports whether the host, with the bucket name already
+// removed, refers to an S3 website endpoint, i.e. it begins with
+// 's3-website', as in 'mybucket.s3-website.localhost' or
+// 'mybucket.s3-website-us-east-1.amazonaws.com'.
+func isWebsiteHost(host string) bool {
+	return strings.HasPrefix(host, "s3-website")
+}
+
+func validateWebsiteConfiguration(config *WebsiteConfiguration) error {
+	if config.RedirectAllRequestsTo != nil {
+		if config.IndexDocument != nil || config.ErrorDocument != nil || len(config.RoutingRules) > 0 {
+			return ErrorMessage(ErrInvalidRequest, "RedirectAllRequestsTo cannot be provided in conjunction with other Routing Rules.")
+		}
+		if config.RedirectAllRequestsTo.HostName == "" {
+			return ErrMalformedXML
+		}
+		return nil
+	}
+
+	if config.IndexDocument == nil {
+		return ErrorMessage(ErrInvalidArgument, "A value for IndexDocument Suffix must be provided if RedirectAllRequestsTo is empty")
+	}
+	if suffix := config.IndexDocument.Suffix; suffix == "" || strings.Contains(suffix, "/") {
+		return ErrorMessage(ErrInvalidArgument, "The IndexDocument Suffix is not well formed")
+	}
+	if config.ErrorDocument != nil && config.ErrorDocument.Key == "" {
+		return ErrorMessage(ErrInvalidArgument, "The ErrorDocument Key is not well formed")
+	}
+
+	for _, rule := range config.RoutingRules {
+		if rule.Redirect.ReplaceKeyPrefixWith != "" && rule.Redirect.ReplaceKeyWith != "" {
+			return ErrorMessage(ErrInvalidRequest, "You can only define ReplaceKeyPrefix or ReplaceKey but not both.")
+		}
+	}
+
+	return nil
+}
+
+// serveWebsite handles a request made to a bucket's website endpoint. Keys
+// ending in '/' are served using the configured IndexDocument, and if the
+// requested key does not exist, the ErrorDocument is served in its place.
+// RoutingRules are stored, but are not applied.
+func (g *GoFakeS3) serveWebsite(bucket, path string, w http.ResponseWriter, r *http.Request) error {
+	g.log.Print(LogInfo, "WEBSITE:", bucket, path)
+
+	if r.Method != "GET" && r.Method != "HEAD" {
+		return ErrMethodNotAllowed
+	}
+
+	config, err := g.website.BucketWebsite(bucket)
+	if err != nil {
+		return err
+	}
+	if config == nil {
+		return ResourceError(ErrNoSuchWebsiteConfiguration, bucket)
+	}
+
+	if redirect := config.RedirectAllRequestsTo; redirect != nil {
+		// The request's own protocol is kept unless another is configured:
+		protocol := redirect.Protocol
+		if protocol == "" {
+			protocol = requestScheme(r)
+		}
+		http.Redirect(w, r, protocol+"://"+redirect.HostName+path, http.StatusMovedPermanently)
+		return nil
+	}
+
+	object := strings.TrimPrefix(path, "/")
+	if object == "" || strings.HasSuffix(object, "/") {
+		object += config.IndexDocument.Suffix
+	}
+
+	if r.Method == "HEAD" {
+		err = g.headObject(bucket, object, "", w, r)
+	} else {
+		err = g.getObject(bucket, object, "", w, r)
+	}
+	if !HasErrorCode(err, ErrNoSuchKey) || config.ErrorDocument == nil {
+		return err
+	}
+
+	doc, docErr := getObjectContext(r.Context(), g.storage, bucket, config.ErrorDocument.Key, nil)
+	if docErr != nil {
+		return err
+	}
+	defer doc.Contents.Close()
+
+	if contentType := doc.Metadata["Content-Type"]; contentType != "" {
+		w.Header().Set("Content-Type", contentType)
+	}
+	w.WriteHeader(http.StatusNotFound)
+	if r.Method == "HEAD" {
+		return nil
+	}
+	_, err = io.Copy(w, doc.Contents)
+	return err
+}
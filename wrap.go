@@ -0,0 +1,145 @@
+package gofakes3
+
+import (
+	"context"
+	"io"
+	"time"
+)
+
+// BackendMiddleware wraps a Backend to add behaviour to its methods, for
+// example logging, fault injection or latency.
+//
+// A middleware only needs to implement the methods of Backend. GoFakeS3 finds
+// the optional interfaces, such as VersionedBackend or TaggingBackend, on the
+// wrapped Backend as long as the wrapper implements BackendUnwrapper; calls to
+// optional methods that the wrapper does not implement itself bypass it.
+type BackendMiddleware func(Backend) Backend
+
+// BackendUnwrapper should be implemented by any Backend returned by a
+// BackendMiddleware, so that GoFakeS3 can find the optional interfaces
+// implemented by the Backend it wraps.
+type BackendUnwrapper interface {
+	Unwrap() Backend
+}
+
+// WrapBackend wraps b with each middleware in turn. The first middleware is
+// the outermost, so it sees each call first:
+//
+//	backend := gofakes3.WrapBackend(s3mem.New(), gofakes3.LoggingBackend(log))
+//	faker := gofakes3.New(backend)
+func WrapBackend(b Backend, mw ...BackendMiddleware) Backend {
+	for i := len(mw) - 1; i >= 0; i-- {
+		b = mw[i](b)
+	}
+	return b
+}
+
+// unwrapBackend returns the Backend wrapped by b, or nil if b is not a
+// wrapper.
+func unwrapBackend(b Backend) Backend {
+	if u, ok := b.(BackendUnwrapper); ok {
+		return u.Unwrap()
+	}
+	return nil
+}
+
+// LoggingBackend returns a BackendMiddleware that logs each call to a method
+// of Backend at LogInfo, and each error it returns. Errors that S3 would
+// return to the client, such as ErrNoSuchKey, are logged at LogWarn, others at
+// LogErr.
+func LoggingBackend(log Logger) BackendMiddleware {
+	return func(b Backend) Backend {
+		return &loggingBackend{backend: b, log: log}
+	}
+}
+
+type loggingBackend struct {
+	backend Backend
+	log     Logger
+}
+
+var _ interface {
+	Backend
+	BackendUnwrapper
+	ContextBackend
+} = &loggingBackend{}
+
+func (l *loggingBackend) Unwrap() Backend { return l.backend }
+
+// call logs a call to method, and returns a function that logs its error and
+// duration; use it with defer.
+func (l *loggingBackend) call(method string, args ...interface{}) func(err *error) {
+	l.log.Print(LogInfo, append([]interface{}{"BACKEND", method + ":"}, args...)...)
+	start := time.Now()
+
+	return func(err *error) {
+		if *err == nil {
+			return
+		}
+		level := LogErr
+		if s3err, ok := (*err).(Error); ok && s3err.ErrorCode() != ErrInternal {
+			level = LogWarn
+		}
+		l.log.Print(level, "BACKEND", method+":", *err, "after", time.Since(start))
+	}
+}
+
+func (l *loggingBackend) ListBuckets() (buckets []BucketInfo, err error) {
+	defer l.call("ListBuckets")(&err)
+	return l.backend.ListBuckets()
+}
+
+func (l *loggingBackend) ListBucket(name string, prefix *Prefix, page ListBucketPage) (list *ObjectList, err error) {
+	defer l.call("ListBucket", name, prefix, page)(&err)
+	return l.backend.ListBucket(name, prefix, page)
+}
+
+func (l *loggingBackend) CreateBucket(name string) (err error) {
+	defer l.call("CreateBucket", name)(&err)
+	return l.backend.CreateBucket(name)
+}
+
+func (l *loggingBackend) BucketExists(name string) (exists bool, err error) {
+	defer l.call("BucketExists", name)(&err)
+	return l.backend.BucketExists(name)
+}
+
+func (l *loggingBackend) DeleteBucket(name string) (err error) {
+	defer l.call("DeleteBucket", name)(&err)
+	return l.backend.DeleteBucket(name)
+}
+
+func (l *loggingBackend) GetObject(bucketName, objectName string, rangeRequest *ObjectRangeRequest) (obj *Object, err error) {
+	defer l.call("GetObject", bucketName, objectName, rangeRequest)(&err)
+	return l.backend.GetObject(bucketName, objectName, rangeRequest)
+}
+
+func (l *loggingBackend) GetObjectContext(ctx context.Context, bucketName, objectName string, rangeRequest *ObjectRangeRequest) (obj *Object, err error) {
+	defer l.call("GetObject", bucketName, objectName, rangeRequest)(&err)
+	return getObjectContext(ctx, l.backend, bucketName, objectName, rangeRequest)
+}
+
+func (l *loggingBackend) HeadObject(bucketName, objectName string) (obj *Object, err error) {
+	defer l.call("HeadObject", bucketName, objectName)(&err)
+	return l.backend.HeadObject(bucketName, objectName)
+}
+
+func (l *loggingBackend) DeleteObject(bucketName, objectName string) (result ObjectDeleteResult, err error) {
+	defer l.call("DeleteObject", bucketName, objectName)(&err)
+	return l.backend.DeleteObject(bucketName, objectName)
+}
+
+func (l *loggingBackend) PutObject(bucketName, key string, meta map[string]string, input io.Reader, size int64) (result PutObjectResult, err error) {
+	defer l.call("PutObject", bucketName, key, size)(&err)
+	return l.backend.PutObject(bucketName, key, meta, input, size)
+}
+
+func (l *loggingBackend) PutObjectContext(ctx context.Context, bucketName, key string, meta map[string]string, input io.Reader, size int64) (result PutObjectResult, err error) {
+	defer l.call("PutObject", bucketName, key, size)(&err)
+	return putObjectContext(ctx, l.backend, bucketName, key, meta, input, size)
+}
+
+func (l *loggingBackend) DeleteMulti(bucketName string, objects ...string) (result MultiDeleteResult, err error) {
+	defer l.call("DeleteMulti", bucketName, objects)(&err)
+	return l.backend.DeleteMulti(bucketName, objects...)
+}